@@ -0,0 +1,668 @@
+// Package bpetok is the public API of the byte-level BPE tokenizer.
+//
+// A Tokenizer is immutable once loaded and safe for concurrent use. Encoders and decoders built from it
+// carry per-stream state and are not.
+package bpetok
+
+import (
+	"fmt"
+	"io"
+	"io/fs"
+	"iter"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/bpetok/internal/tokenizer/core"
+	"github.com/bpetok/internal/tokenizer/streaming_encoder_adaptive"
+	"github.com/bpetok/internal/tokenizer/streaming_encoder_incremental"
+	"github.com/bpetok/internal/tokenizer/streaming_encoder_naive"
+	"github.com/bpetok/internal/tokenizer/streaming_encoder_pretoken"
+)
+
+// Encoder turns a byte stream into token IDs, see core.Encoder for the Feed/Flush contract.
+type Encoder = core.Encoder
+
+// Decoder turns token IDs back into bytes, see core.Decoder for the aliasing contract.
+type Decoder = core.Decoder
+
+// Warning is a non-fatal anomaly seen by an encoder: a count plus the first occurrence.
+type Warning = core.Warning
+
+// WarningReporter is implemented by the encoders returned from NewEncoder. Warnings accumulate across
+// streams until ResetWarnings; they are only recorded with WithWarnings on.
+type WarningReporter interface {
+	Warnings() []Warning
+	ResetWarnings()
+}
+
+// Resetter is implemented by the encoders returned from NewEncoder and NewResultEncoder. Reset abandons
+// the current stream without emitting what is held back and keeps the encoder's buffers, so one encoder
+// can serve request after request, e.g. from a sync.Pool.
+type Resetter interface {
+	Reset()
+}
+
+// SoftFlusher is implemented by the encoders returned from NewEncoder, NewResultEncoder and
+// NewPretokenEncoder. FlushSoft emits everything held back like Flush but continues the stream, as if the
+// input had a hard split there: use it at record boundaries that need their IDs out now.
+type SoftFlusher interface {
+	FlushSoft() []int
+}
+
+// Snapshotter is implemented by the encoders returned from NewEncoder and NewResultEncoder. Snapshot
+// captures the current stream (the input held back, not the history) and Restore continues it in an
+// encoder built with the same tokenizer and options, e.g. after a process restart.
+type Snapshotter interface {
+	Snapshot() []byte
+	Restore(data []byte) error
+}
+
+// EncoderStats counts an encoder's merge work, see Inspector.
+type EncoderStats = streaming_encoder_incremental.Stats
+
+// Inspector is implemented by the encoders returned from NewEncoder and NewResultEncoder, for streaming
+// services tuning WithTailReserve or chasing latency: how much input is held back (in bytes and in merge
+// list tokens) and how much work the merge loop has done. Stats accumulate until ResetStats.
+type Inspector interface {
+	PendingBytes() int
+	PendingNodes() int
+	Stats() EncoderStats
+	ResetStats()
+}
+
+// ErrSnapshotFormat is returned by Snapshotter.Restore for data that isn't a snapshot or is corrupt.
+var ErrSnapshotFormat = streaming_encoder_incremental.ErrSnapshotFormat
+
+// ErrInvalidTokenID is returned by Decode, and reported by Decoder.Err, for IDs outside [0, VocabSize()) and
+// for holes in a vocab loaded WithVocabHoles.
+var ErrInvalidTokenID = core.ErrInvalidTokenID
+
+// CompressionStats summarises how well the vocab compresses an input, see Tokenizer.CompressionStats.
+type CompressionStats = core.CompressionStats
+
+// TemplateCache encodes {{name}} prompt templates, reusing the encoding of their literal text across
+// renders, see Tokenizer.NewTemplateCache.
+type TemplateCache = core.TemplateCache
+
+// TemplateCacheStats counts hits, misses and evictions of a TemplateCache, and the tokens it reused.
+type TemplateCacheStats = core.TemplateCacheStats
+
+// TextGenerator produces pseudo-text by sampling token IDs, see Tokenizer.NewTextGenerator.
+type TextGenerator = core.TextGenerator
+
+// PairCounts counts adjacent token pairs over a corpus, see Tokenizer.CountPairs.
+type PairCounts = core.PairCounts
+
+// PairCount is one entry of PairCounts.
+type PairCount = core.PairCount
+
+// ErrTemplateVar is returned by TemplateCache.Render for a placeholder with no value.
+var ErrTemplateVar = core.ErrTemplateVar
+
+// ScratchPoolLimits bounds the per-tokenizer scratch kept between encodes, see SetScratchPoolLimits.
+type ScratchPoolLimits = core.ScratchPoolLimits
+
+// SetScratchPoolLimits changes, process wide, how much encode scratch tokenizers retain between calls.
+// The default keeps up to 4 items of 64 KiB of input each; lower MaxItemLen if occasional huge inputs leave
+// the process holding on to memory it no longer needs. It is safe to call at any time, and a tokenizer
+// loaded WithScratchPoolLimits keeps its own limits.
+func SetScratchPoolLimits(l ScratchPoolLimits) {
+	core.SetScratchPoolLimits(l)
+}
+
+// GetScratchPoolLimits returns the process wide limits in effect, see SetScratchPoolLimits.
+func GetScratchPoolLimits() ScratchPoolLimits {
+	return core.GetScratchPoolLimits()
+}
+
+// Tokenizer is a loaded BPE model.
+type Tokenizer struct {
+	tok *core.Tokenizer
+}
+
+// LoadTokenizer builds a tokenizer from the contents of a GPT-2 style vocab.json and merges.txt.
+// It never touches the filesystem, so the assets can come from go:embed, a network fetch, or a test
+// fixture. Neither slice is retained.
+func LoadTokenizer(vocab, merges []byte) (*Tokenizer, error) {
+	tok, err := core.LoadTokenizerFromBytes(vocab, merges)
+	if err != nil {
+		return nil, err
+	}
+	return &Tokenizer{tok: tok}, nil
+}
+
+// LoadTokenizerFromReaders builds a tokenizer from readers over vocab.json and merges.txt. Both are read to
+// EOF and left open.
+func LoadTokenizerFromReaders(vocab, merges io.Reader) (*Tokenizer, error) {
+	return Load(Readers(vocab, merges))
+}
+
+// LoadTokenizerFS builds a tokenizer from vocab.json and merges.txt inside fsys, such as an embed.FS or a
+// *zip.Reader.
+func LoadTokenizerFS(fsys fs.FS, vocabPath, mergesPath string) (*Tokenizer, error) {
+	return Load(FS(fsys, vocabPath, mergesPath))
+}
+
+// Load builds a tokenizer from src, configured by opts. The other loaders are shorthands for Load with no
+// options.
+func Load(src Source, opts ...LoadOption) (*Tokenizer, error) {
+	tok, err := core.Load(src, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return &Tokenizer{tok: tok}, nil
+}
+
+// SpecialTokens returns the special tokens the tokenizer was loaded with.
+func (t *Tokenizer) SpecialTokens() map[string]int {
+	return t.tok.SpecialTokens()
+}
+
+// IsSpecial reports whether id is a special token.
+func (t *Tokenizer) IsSpecial(id int) bool {
+	return t.tok.IsSpecial(id)
+}
+
+// SpecialTokenID looks up a special token by its role in special_tokens_map.json ("eos_token",
+// "pad_token", ...; see HFDir) or by its text.
+func (t *Tokenizer) SpecialTokenID(name string) (int, bool) {
+	return t.tok.SpecialTokenID(name)
+}
+
+// MemoryFootprint estimates the bytes held by the tokenizer's lookup tables, see WithMemoryBudget.
+func (t *Tokenizer) MemoryFootprint() int64 {
+	return t.tok.MemoryFootprint()
+}
+
+// LoadTiktoken builds a tokenizer from the contents of a tiktoken rank file such as cl100k_base.tiktoken.
+// data is not retained. Merges run over the raw input without tiktoken's pre-tokenization regex, so IDs can
+// differ from tiktoken's wherever that regex would have split; Load(TiktokenBytes(data),
+// WithPreTokenization(PreTokenizeCL100K)) and the like match it, as Get does.
+func LoadTiktoken(data []byte) (*Tokenizer, error) {
+	tok, err := core.LoadTokenizerFromTiktokenBytes(data)
+	if err != nil {
+		return nil, err
+	}
+	return &Tokenizer{tok: tok}, nil
+}
+
+// MarshalBinary encodes the loaded tokenizer, special tokens, normalization and pre-tokenizer included, in
+// a versioned, checksummed binary format. Load it back with Compiled or CompiledFile.
+func (t *Tokenizer) MarshalBinary() ([]byte, error) {
+	return t.tok.MarshalBinary()
+}
+
+// ErrTiktokenIncompatible is returned by WriteTiktoken for a tokenizer a rank file can't describe.
+var ErrTiktokenIncompatible = core.ErrTiktokenIncompatible
+
+// WriteTiktoken writes the vocab as a tiktoken rank file ("base64(token) rank" per line) for tiktoken and
+// tiktoken-rs, e.g. to check IDs match across languages. Special tokens, normalization and pre-tokenization
+// aren't part of the format. It fails with ErrTiktokenIncompatible when token IDs don't follow merge order,
+// which a rank file can't express, and for SentencePiece tokenizers.
+func (t *Tokenizer) WriteTiktoken(w io.Writer) error {
+	return t.tok.WriteTiktoken(w)
+}
+
+// InternedStrings returns a printable form of every token, indexed by ID, for display and analytics. It is
+// lossy (invalid bytes print as \xNN, control characters as escapes) and built once per tokenizer; the
+// slice is shared, don't modify it.
+func (t *Tokenizer) InternedStrings() []string {
+	return t.tok.InternedStrings()
+}
+
+// TokensContaining returns the IDs of every token whose bytes contain sub, in ascending order, for
+// building logit-ban lists and similar vocab searches. sub is matched as raw bytes, without normalization.
+// The index behind it is built on the first call unless the tokenizer was loaded WithSubstringIndex.
+func (t *Tokenizer) TokensContaining(sub []byte) []int {
+	return t.tok.TokensContaining(sub)
+}
+
+// VocabSize returns the number of token IDs, valid IDs are [0, VocabSize()).
+func (t *Tokenizer) VocabSize() int {
+	return t.tok.VocabSize()
+}
+
+// ValidID reports whether id is a token: in [0, VocabSize()) and not a hole in a vocab loaded
+// WithVocabHoles.
+func (t *Tokenizer) ValidID(id int) bool {
+	return t.tok.ValidID(id)
+}
+
+// TokenBytes returns the bytes token id decodes to, or nil if id is out of range. The slice is shared with
+// the tokenizer and must not be modified.
+func (t *Tokenizer) TokenBytes(id int) []byte {
+	return t.tok.TokenBytes(id)
+}
+
+// MaxMergeDepth returns the height of the deepest merge tree in the vocab: how many merges, one on top of
+// the other, the most nested token takes to build from bytes. For GPT-2 it is 8.
+func (t *Tokenizer) MaxMergeDepth() int {
+	return t.tok.MaxMergeDepth()
+}
+
+// CommitGuard returns MaxMergeDepth times the longest token's length, which NewEncoder raises a nonzero
+// tail reserve to, see WithTailReserve. It sizes the hold-back but doesn't bound how far back appended input
+// can change an encoding: some rank orders re-pair a whole run for one more byte.
+func (t *Tokenizer) CommitGuard() int {
+	return t.tok.CommitGuard()
+}
+
+// NewEncoder returns a streaming encoder backed by the incremental merge engine. Encoders carry
+// per-stream state, use one per stream; after Flush the same encoder can start a new stream.
+func (t *Tokenizer) NewEncoder(opts ...EncoderOption) Encoder {
+	return streaming_encoder_incremental.NewStreamingEncoderV2(t.tok, newEncoderOptions(opts).incremental...)
+}
+
+// Divergence is where a verifying encoder's IDs first differ from Encode's for the same stream, with the
+// IDs and text around it; it is an error. See NewVerifyingEncoder.
+type Divergence = streaming_encoder_incremental.Divergence
+
+// NewVerifyingEncoder returns NewEncoder(opts...) checked against Encode as the stream goes, a safety net
+// for staging deployments of the streaming path: every ID it emits is compared with Encode's as soon as
+// that is known, at the latest when the stream ends, and onDivergence gets the first mismatch of a stream.
+// Its output is the encoder's, unchanged; the checks cost about an Encode of the input on top. It
+// implements WarningReporter and Resetter.
+func (t *Tokenizer) NewVerifyingEncoder(onDivergence func(*Divergence), opts ...EncoderOption) Encoder {
+	return streaming_encoder_incremental.NewVerifyingEncoder(t.tok, onDivergence, newEncoderOptions(opts).incremental...)
+}
+
+// NewAdaptiveEncoder returns a streaming encoder that switches between the naive engine, which is cheaper
+// for large chunks, and the incremental one, which is cheaper for small ones, according to the chunk sizes
+// it sees. Both engines emit only up to junctions no merge can join, so switches are exact: output always
+// equals Encode over the whole stream.
+func (t *Tokenizer) NewAdaptiveEncoder(opts ...EncoderOption) Encoder {
+	o := newEncoderOptions(opts)
+	return streaming_encoder_adaptive.NewAdaptiveEncoder(t.tok, streaming_encoder_adaptive.WithZeroCopyOutput(o.zeroCopy),
+		streaming_encoder_adaptive.WithWarnings(o.warnings))
+}
+
+// NewNaiveEncoder returns the simplest streaming encoder: it encodes the input it holds back up to the last
+// junction no merge can join and emits the tokens that end at least a maximum token length before its end.
+// It is a baseline to check and measure the other encoders against. Output equals Encode over the whole
+// stream. It implements WarningReporter.
+func (t *Tokenizer) NewNaiveEncoder(opts ...EncoderOption) Encoder {
+	o := newEncoderOptions(opts)
+	return streaming_encoder_naive.NewNaiveEncoder(t.tok, o.zeroCopy, o.warnings)
+}
+
+// ErrNoPreTokenizer is returned by NewPretokenEncoder for a tokenizer loaded without WithPreTokenization.
+var ErrNoPreTokenizer = streaming_encoder_pretoken.ErrNoPreTokenizer
+
+// NewPretokenEncoder returns a streaming encoder for a tokenizer with a pre-tokenizer that emits the IDs
+// of each pre-token (a word, a number, a run of spaces) as soon as the next one starts, the way the
+// reference GPT-2 and tiktoken encoders do. No merge spans pre-tokens, so it keeps no merge state and
+// needs no tail reserve, and its IDs trail the input by about a word. Output equals Encode over the whole
+// stream. It implements WarningReporter and Resetter.
+func (t *Tokenizer) NewPretokenEncoder(opts ...EncoderOption) (Encoder, error) {
+	o := newEncoderOptions(opts)
+	pe, err := streaming_encoder_pretoken.NewPretokenEncoder(t.tok, streaming_encoder_pretoken.WithZeroCopyOutput(o.zeroCopy),
+		streaming_encoder_pretoken.WithWarnings(o.warnings))
+	if err != nil {
+		return nil, err
+	}
+	return pe, nil
+}
+
+// StreamResult is one call's output from a ResultEncoder: committed IDs, the provisional encoding of the
+// held back tail, a byte watermark and a revision, see core.StreamResult.
+type StreamResult = core.StreamResult
+
+// ResultEncoder is an Encoder whose PushResult and FlushResult return StreamResults.
+type ResultEncoder = core.ResultEncoder
+
+// StreamPatch is one call's output from a Patcher: drop the last Retract IDs shown, then append Tokens,
+// see core.StreamPatch.
+type StreamPatch = core.StreamPatch
+
+// Patcher is implemented by the encoders returned from NewResultEncoder, for UIs that show tokens as soon
+// as their bytes arrive: PushPatch and FlushPatch describe each call as an edit to the IDs shown so far,
+// retracting provisional ones when a later chunk merges into them. Use them for every call of a stream.
+type Patcher interface {
+	PushPatch(chunk []byte) StreamPatch
+	FlushPatch() StreamPatch
+}
+
+// NewResultEncoder is NewEncoder for callers that want StreamResults, e.g. to show provisional tokens
+// while a stream is still arriving.
+func (t *Tokenizer) NewResultEncoder(opts ...EncoderOption) ResultEncoder {
+	return streaming_encoder_incremental.NewStreamingEncoderV2(t.tok, newEncoderOptions(opts).incremental...)
+}
+
+// NewDecoder returns a streaming decoder. Feed returns only whole UTF-8 characters, holding back the first
+// bytes of one split across tokens until the rest arrive, and Flush hands over whatever is still held. The
+// slice either returns lives in a buffer the decoder reuses on the next call, copy it to keep it. A stream
+// that ends on a character boundary leaves nothing held back, so the decoder can go straight on to the
+// next stream without a Flush. An invalid ID stops the stream: Feed returns what the IDs before it decode
+// to and nothing after, and Err reports ErrInvalidTokenID until the next stream. The DecodeOptions apply
+// as they do to Decode, except WithMaxBytes: WithReplacement makes Flush return U+FFFD for the held bytes
+// and Feed replace invalid ones, WithSkipSpecial and WithSpecialText handle special tokens.
+func (t *Tokenizer) NewDecoder(opts ...DecodeOption) Decoder {
+	o := newDecodeOptions(opts)
+	return o.decoder(t)
+}
+
+// LineEncoder tokenizes newline separated records into one token array per line, see
+// Tokenizer.NewLineEncoder.
+type LineEncoder = streaming_encoder_incremental.LineEncoder
+
+// NewLineEncoder returns an encoder for log and JSONL style input: Push returns a completed token array for
+// every line that ends in the chunk, Flush the unterminated last line. Merges never span a line break, so
+// each array is what Encode would give for that line alone. keepNewline encodes the '\n' with its line,
+// otherwise it is stripped along with a '\r' before it.
+func (t *Tokenizer) NewLineEncoder(keepNewline bool, opts ...EncoderOption) *LineEncoder {
+	return streaming_encoder_incremental.NewLineEncoder(t.tok, keepNewline, newEncoderOptions(opts).incremental...)
+}
+
+// Encode tokenizes text in one go. It gives the same IDs as feeding text through NewEncoder in any
+// chunking and flushing, without the per-stream state. The error is always nil for now, it is there so
+// future input validation doesn't need an API break.
+func (t *Tokenizer) Encode(text string) ([]int, error) {
+	return t.tok.EncodeOffline(t.tok.Prepare([]byte(text)), nil), nil
+}
+
+// EncodeWithSpecial is Encode with the special tokens from WithSpecialTokens (or the tokenizer file)
+// recognized in text under p: each occurrence of an allowed one becomes its ID and the text around it is
+// encoded as usual, never merging across it, while a disallowed one fails with ErrDisallowedSpecial. The
+// zero SpecialPolicy disallows them all, which is what text from users wants; pass
+// SpecialPolicy{Allowed: []string{AllSpecial}} for trusted text. Encode treats their text as ordinary
+// characters.
+func (t *Tokenizer) EncodeWithSpecial(text string, p SpecialPolicy) ([]int, error) {
+	return t.tok.EncodeWithSpecial(t.tok.Prepare([]byte(text)), p)
+}
+
+// CountTokens returns the number of IDs Encode would produce for input, without building them. Use it for
+// prompt budgeting.
+func (t *Tokenizer) CountTokens(input []byte) int {
+	return t.tok.CountTokens(t.tok.Prepare(input))
+}
+
+// CompressionStats encodes input and reports bytes, tokens, bytes per token and the entropy of the emitted
+// IDs. It's a one-call health metric for comparing vocabs over sample documents.
+func (t *Tokenizer) CompressionStats(input []byte) CompressionStats {
+	return t.tok.CompressionStats(t.tok.Prepare(input))
+}
+
+// TokenHeal returns the encoding of prefix's text followed by continuation, reusing prefix (e.g. a cached
+// prompt encoding) for everything in front of its last junction that no merge can join. Appending
+// Encode(continuation) to prefix instead would leave a token boundary where the concatenated text would
+// not have one. continuation gets no prefix space, it isn't the start of the text.
+func (t *Tokenizer) TokenHeal(prefix []int, continuation []byte) ([]int, error) {
+	if err := t.checkIDs(prefix); err != nil {
+		return nil, err
+	}
+	return t.tok.TokenHeal(prefix, t.normalize(continuation)), nil
+}
+
+// Fingerprint returns a hex SHA-256 of the tokenizer's vocab, merges, special tokens, normalization, prefix
+// space and pre-tokenizer. Tokenizers with equal fingerprints encode alike however they were loaded, so it
+// works as a cache key and for checking that client and server use the same tokenizer. It doesn't cover
+// AlgorithmVersion.
+func (t *Tokenizer) Fingerprint() string {
+	return t.tok.Fingerprint()
+}
+
+// AlgorithmVersion returns the algorithm version the tokenizer was built under: the one recorded in a
+// compiled file, otherwise this build's AlgorithmVersion.
+func (t *Tokenizer) AlgorithmVersion() int {
+	return t.tok.AlgorithmVersion()
+}
+
+// Normalization returns the normalization input goes through before it is tokenized, see
+// WithNormalization.
+func (t *Tokenizer) Normalization() Normalization {
+	return t.tok.Normalization()
+}
+
+// AddPrefixSpace reports whether input gets a space in front before it is tokenized, see
+// WithAddPrefixSpace.
+func (t *Tokenizer) AddPrefixSpace() bool {
+	return t.tok.AddPrefixSpace()
+}
+
+// PreTokenization returns the pre-tokenizer the tokenizer was loaded with, see WithPreTokenization.
+func (t *Tokenizer) PreTokenization() PreTokenization {
+	return t.tok.PreTokenization()
+}
+
+// DigitGroup returns the size digit runs are cut into, 0 for none, see WithDigitGroup.
+func (t *Tokenizer) DigitGroup() int {
+	return t.tok.DigitGroup()
+}
+
+// Owned reports whether the tokenizer owns all of its memory, which is only false after CompiledBorrowed
+// and CompiledMapped.
+func (t *Tokenizer) Owned() bool {
+	return t.tok.Owned()
+}
+
+// Freeze returns a tokenizer that owns all of its memory, t itself unless it was loaded with
+// CompiledBorrowed or CompiledMapped. The borrowed buffer may be reused once t is no longer in use.
+func (t *Tokenizer) Freeze() *Tokenizer {
+	if t.tok.Owned() {
+		return t
+	}
+	return &Tokenizer{tok: t.tok.Freeze()}
+}
+
+// NewTemplateCache returns a cache of up to capacity compiled prompt templates. Render(template, vars)
+// returns what Encode would for the template with its {{name}} placeholders filled in, but only encodes
+// the values and the literal bytes close enough to merge with them; the rest of the literal text is encoded
+// once per template.
+func (t *Tokenizer) NewTemplateCache(capacity int) *TemplateCache {
+	return t.tok.NewTemplateCache(capacity)
+}
+
+// NewTextGenerator returns a deterministic source of pseudo-text for benchmarks and load tests. It samples
+// token IDs, weighted by freqs if non-nil (see TokenFrequencies), and decodes them, so the text has the token
+// mix of real input instead of uniform random bytes.
+func (t *Tokenizer) NewTextGenerator(seed uint64, freqs []int) *TextGenerator {
+	return t.tok.NewTextGenerator(seed, freqs)
+}
+
+// TokenFrequencies counts how often each token ID appears in the encoding of corpus, indexed by ID.
+func (t *Tokenizer) TokenFrequencies(corpus []byte) []int {
+	return t.tok.TokenFrequencies(t.tok.Prepare(corpus))
+}
+
+// CountPairs encodes every document of docs on workers goroutines (GOMAXPROCS if workers <= 0) and counts
+// the adjacent token pairs in the output. Pairs never span documents. The per-worker counts are merged at
+// the end; further PairCounts, e.g. from other machines, can be folded in with Merge.
+func (t *Tokenizer) CountPairs(docs iter.Seq[[]byte], workers int) *PairCounts {
+	return t.tok.CountPairsParallel(func(yield func([]byte) bool) {
+		for doc := range docs {
+			if !yield(t.tok.Prepare(doc)) {
+				return
+			}
+		}
+	}, workers)
+}
+
+// Offsets returns the [start, end) byte span of each of ids in Decode(ids).
+func (t *Tokenizer) Offsets(ids []int) ([][2]int, error) {
+	if err := t.checkIDs(ids); err != nil {
+		return nil, err
+	}
+	offs := t.tok.TokenOffsets(ids)
+	spans := make([][2]int, len(ids))
+	for i := range ids {
+		spans[i] = [2]int{offs[i], offs[i+1]}
+	}
+	return spans, nil
+}
+
+// Span is the [Start, End) byte range of one pre-tokenizer split (see Tokenizer.PreTokenize) or of a
+// streamed token (see WithOffsets).
+type Span = core.Span
+
+// PreTokenize splits input with the tokenizer's pre-tokenizer and digit groups, or GPT-2's regex (the
+// splits Hugging Face's ByteLevel pre-tokenizer produces) if it was loaded without one, for checking
+// against another implementation or for word-level processing. Encode applies the splits only in the
+// first case; otherwise tokens can cross span boundaries. The spans index the normalized input, which is
+// input itself unless the tokenizer was loaded with a normalization; the prefix space isn't added.
+func (t *Tokenizer) PreTokenize(input []byte) []Span {
+	return t.tok.PreTokenize(t.normalize(input))
+}
+
+// ErrDecodeLimit is returned by Decode when the output would exceed WithMaxBytes.
+var ErrDecodeLimit = core.ErrDecodeLimit
+
+// DecodeOption configures Decode.
+type DecodeOption func(*decodeOptions)
+
+type decodeOptions struct {
+	maxBytes int
+	replace  bool
+	// special is set by WithSkipSpecial and WithSpecialText, specialText is what special tokens decode to
+	special     bool
+	specialText []byte
+}
+
+// WithMaxBytes caps Decode's output at n bytes. Past that Decode returns ErrDecodeLimit along with the text
+// of the whole tokens that fit, so callers can fail or truncate. Use it when ids come from outside: a
+// crafted array of long tokens can otherwise expand to gigabytes.
+func WithMaxBytes(n int) DecodeOption {
+	return func(o *decodeOptions) { o.maxBytes = max(n, 0) }
+}
+
+// WithReplacement replaces every byte of the output that isn't part of a valid UTF-8 character with
+// U+FFFD, for text shown to users. A streaming decoder with it on returns the same text as Decode, however
+// the IDs are split across Feeds. WithMaxBytes counts the bytes before replacement.
+func WithReplacement(on bool) DecodeOption {
+	return func(o *decodeOptions) { o.replace = on }
+}
+
+// WithSkipSpecial drops special tokens (see IsSpecial) from the output, so control markers such as
+// <|endoftext|> don't reach users. The text on either side is joined as if they weren't there.
+func WithSkipSpecial(on bool) DecodeOption {
+	return func(o *decodeOptions) { o.special, o.specialText = on, nil }
+}
+
+// WithSpecialText decodes every special token to text instead of its own, e.g. "\n" for an end of text
+// marker between documents. WithMaxBytes counts text, and of WithSkipSpecial and WithSpecialText the last
+// one given wins.
+func WithSpecialText(text string) DecodeOption {
+	return func(o *decodeOptions) { o.special, o.specialText = true, []byte(text) }
+}
+
+func newDecodeOptions(opts []DecodeOption) decodeOptions {
+	o := decodeOptions{maxBytes: -1}
+	for _, opt := range opts {
+		opt(&o)
+	}
+	return o
+}
+
+// decoder returns a streaming decoder applying o.
+func (o *decodeOptions) decoder(t *Tokenizer) *core.StreamingDecoder {
+	opts := []core.DecoderOption{core.WithReplacement(o.replace)}
+	if o.special {
+		opts = append(opts, core.WithSpecialText(o.specialText))
+	}
+	return core.NewStreamingDecoder(t.tok, opts...)
+}
+
+// limit cuts ids to the whole tokens whose text fits WithMaxBytes, with ErrDecodeLimit if that drops any.
+func (o *decodeOptions) limit(t *Tokenizer, ids []int) ([]int, error) {
+	if o.maxBytes < 0 {
+		return ids, nil
+	}
+	total := 0
+	for i, id := range ids {
+		if o.special && t.tok.IsSpecial(id) {
+			total += len(o.specialText)
+		} else {
+			total += t.tok.TokenLen(id)
+		}
+		if total > o.maxBytes {
+			return ids[:i], ErrDecodeLimit
+		}
+	}
+	return ids, nil
+}
+
+// Decode turns ids back into text. Byte-level BPE can represent bytes that aren't valid UTF-8, those are
+// returned as is rather than replaced unless WithReplacement is on. Special tokens decode to their text
+// unless WithSkipSpecial or WithSpecialText say otherwise.
+func (t *Tokenizer) Decode(ids []int, opts ...DecodeOption) (string, error) {
+	if err := t.checkIDs(ids); err != nil {
+		return "", err
+	}
+
+	o := newDecodeOptions(opts)
+	ids, err := o.limit(t, ids)
+	if o.special {
+		var sb strings.Builder
+		t.decodeStreamingTo(&sb, ids, &o)
+		return sb.String(), err
+	}
+	out := t.tok.Decode(ids)
+	if o.replace && !utf8.Valid(out) {
+		out = core.AppendValidUTF8(nil, out)
+	}
+	return string(out), err
+}
+
+// DecodeTo writes the text of ids to w as it decodes, instead of building it in memory first: for long
+// outputs going to a file or a response. It takes the same options as Decode, WithMaxBytes stopping it
+// after the whole tokens that fit with ErrDecodeLimit. It returns the number of bytes written; ids are all
+// checked before anything is written, a write error stops it.
+func (t *Tokenizer) DecodeTo(w io.Writer, ids []int, opts ...DecodeOption) (int, error) {
+	if err := t.checkIDs(ids); err != nil {
+		return 0, err
+	}
+
+	o := newDecodeOptions(opts)
+	ids, limitErr := o.limit(t, ids)
+	var n int
+	var err error
+	if o.replace || o.special {
+		n, err = t.decodeStreamingTo(w, ids, &o)
+	} else {
+		n, err = t.tok.DecodeTo(w, ids)
+	}
+	if err != nil {
+		return n, err
+	}
+	return n, limitErr
+}
+
+// decodeStreamingTo is DecodeTo under the options the core decode doesn't apply, through a streaming
+// decoder fed in batches.
+func (t *Tokenizer) decodeStreamingTo(w io.Writer, ids []int, o *decodeOptions) (int, error) {
+	const batch = 1 << 10
+	dec := o.decoder(t)
+	written := 0
+	write := func(out []byte) error {
+		n, err := w.Write(out)
+		written += n
+		return err
+	}
+	for pos := 0; pos < len(ids); pos += batch {
+		if out := dec.Feed(ids[pos:min(pos+batch, len(ids))]); len(out) > 0 {
+			if err := write(out); err != nil {
+				return written, err
+			}
+		}
+	}
+	if out := dec.Flush(); len(out) > 0 {
+		if err := write(out); err != nil {
+			return written, err
+		}
+	}
+	return written, nil
+}
+
+// normalize applies the normalization the tokenizer was loaded with, so the one-shot methods agree with
+// NewEncoder.
+func (t *Tokenizer) normalize(b []byte) []byte {
+	return t.tok.Normalization().Apply(b)
+}
+
+func (t *Tokenizer) checkIDs(ids []int) error {
+	for i, id := range ids {
+		if !t.tok.ValidID(id) {
+			return fmt.Errorf("%w: %d at position %d", ErrInvalidTokenID, id, i)
+		}
+	}
+	return nil
+}
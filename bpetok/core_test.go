@@ -0,0 +1,414 @@
+package bpetok
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"reflect"
+	"testing"
+)
+
+const (
+	testVocabPath  = "../internal/tokenizer/testdata/gpt2/vocab.json"
+	testMergesPath = "../internal/tokenizer/testdata/gpt2/merges.txt"
+)
+
+func loadTestTokenizer(t *testing.T) *Tokenizer {
+	t.Helper()
+
+	vocab, err := os.ReadFile(testVocabPath)
+	if err != nil {
+		t.Fatalf("read vocab: %v", err)
+	}
+	merges, err := os.ReadFile(testMergesPath)
+	if err != nil {
+		t.Fatalf("read merges: %v", err)
+	}
+
+	tok, err := LoadTokenizer(vocab, merges)
+	if err != nil {
+		t.Fatalf("failed to load tokenizer: %v", err)
+	}
+	return tok
+}
+
+func TestLoadTokenizer_FromBytes(t *testing.T) {
+	tok := loadTestTokenizer(t)
+
+	if tok.VocabSize() != 50257 {
+		t.Fatalf("unexpected vocab size %d", tok.VocabSize())
+	}
+
+	in := []byte("loaded from memory, not from disk")
+	ids := tok.tok.EncodeOffline(in, nil)
+	if out := tok.tok.Decode(ids); !bytes.Equal(out, in) {
+		t.Fatalf("roundtrip mismatch: %q", out)
+	}
+}
+
+func TestLoadTokenizer_DoesNotRetainInput(t *testing.T) {
+	vocab, _ := os.ReadFile(testVocabPath)
+	merges, _ := os.ReadFile(testMergesPath)
+
+	tok, err := LoadTokenizer(vocab, merges)
+	if err != nil {
+		t.Fatalf("failed to load tokenizer: %v", err)
+	}
+	in := []byte("hello world")
+	want := tok.tok.EncodeOffline(in, nil)
+
+	for i := range vocab {
+		vocab[i] = 0
+	}
+	for i := range merges {
+		merges[i] = 0
+	}
+
+	if got := tok.tok.EncodeOffline(in, nil); !bytes.Equal(tok.tok.Decode(got), in) || len(got) != len(want) {
+		t.Fatalf("tokenizer changed after the caller reused its buffers")
+	}
+}
+
+func TestLoadTokenizer_BadInput(t *testing.T) {
+	if _, err := LoadTokenizer([]byte("not json"), nil); err == nil {
+		t.Fatalf("expected an error for malformed vocab")
+	}
+}
+
+func TestTokenizer_EncodeDecodeRoundTrip(t *testing.T) {
+	tok := loadTestTokenizer(t)
+
+	for _, s := range []string{"", "Hello, world!", "naïve café 😀", "  leading spaces\n\ttabs"} {
+		ids, err := tok.Encode(s)
+		if err != nil {
+			t.Fatalf("Encode(%q): %v", s, err)
+		}
+
+		if want := tok.tok.EncodeOffline([]byte(s), nil); len(want) > 0 && !reflect.DeepEqual(ids, want) {
+			t.Fatalf("Encode(%q) = %v, want %v", s, ids, want)
+		}
+
+		got, err := tok.Decode(ids)
+		if err != nil {
+			t.Fatalf("Decode: %v", err)
+		}
+		if got != s {
+			t.Fatalf("round trip: got %q want %q", got, s)
+		}
+	}
+}
+
+func TestTokenizer_DecodeInvalidID(t *testing.T) {
+	tok := loadTestTokenizer(t)
+
+	for _, id := range []int{-1, tok.VocabSize()} {
+		if _, err := tok.Decode([]int{31373, id}); !errors.Is(err, ErrInvalidTokenID) {
+			t.Fatalf("Decode with id %d: got err %v, want ErrInvalidTokenID", id, err)
+		}
+	}
+}
+
+func TestTokenizer_DecodeVocabHole(t *testing.T) {
+	vocab, err := os.ReadFile(testVocabPath)
+	if err != nil {
+		t.Fatalf("read vocab: %v", err)
+	}
+	merges, err := os.ReadFile(testMergesPath)
+	if err != nil {
+		t.Fatalf("read merges: %v", err)
+	}
+	// move <|endoftext|> up one, leaving 50256 unused
+	vocab = bytes.Replace(vocab, []byte(`"<|endoftext|>": 50256`), []byte(`"<|endoftext|>": 50257`), 1)
+
+	tok, err := Load(Bytes(vocab, merges), WithVocabHoles(true))
+	if err != nil {
+		t.Fatalf("load: %v", err)
+	}
+	if _, err := tok.Decode([]int{31373, 50256}); !errors.Is(err, ErrInvalidTokenID) {
+		t.Fatalf("Decode of a hole: got err %v, want ErrInvalidTokenID", err)
+	}
+	if got, err := tok.Decode([]int{31373, 50257}); err != nil || got != "hello<|endoftext|>" {
+		t.Fatalf("Decode: %q, %v", got, err)
+	}
+}
+
+func TestTokenizer_TokenHeal(t *testing.T) {
+	tok := loadTestTokenizer(t)
+
+	prefix, _ := tok.Encode("Stitching a cached prompt to new t")
+	healed, err := tok.TokenHeal(prefix, []byte("ext"))
+	if err != nil {
+		t.Fatalf("TokenHeal: %v", err)
+	}
+	if want, _ := tok.Encode("Stitching a cached prompt to new text"); !reflect.DeepEqual(healed, want) {
+		t.Fatalf("got %v want %v", healed, want)
+	}
+
+	if _, err := tok.TokenHeal([]int{-3}, []byte("x")); !errors.Is(err, ErrInvalidTokenID) {
+		t.Fatalf("expected ErrInvalidTokenID, got %v", err)
+	}
+}
+
+func TestTokenizer_CommitGuard(t *testing.T) {
+	tok := loadTestTokenizer(t)
+	if d := tok.MaxMergeDepth(); d != 8 {
+		t.Fatalf("GPT-2 merge depth: got %d, want 8", d)
+	}
+
+	// IDs ending before the last CommitGuard bytes are the same however the text goes on
+	text := bytes.Repeat([]byte("Merges cascade back at most a token per level. "), 40)
+	whole, _ := tok.Encode(string(text))
+	guard := tok.CommitGuard()
+	for cut := guard; cut < len(text); cut += 97 {
+		alone, _ := tok.Encode(string(text[:cut]))
+		end := 0
+		for i, id := range alone {
+			if end += len(tok.TokenBytes(id)); end > cut-guard {
+				break
+			}
+			if whole[i] != id {
+				t.Fatalf("cut %d: ID %d changed from %d to %d", cut, i, id, whole[i])
+			}
+		}
+	}
+}
+
+func TestLoad_NormalizationAppliesEverywhere(t *testing.T) {
+	tok, err := Load(Files(testVocabPath, testMergesPath), WithNormalization(NormalizeNFC))
+	if err != nil {
+		t.Fatalf("load: %v", err)
+	}
+
+	decomposed := "café au lait"
+	want, _ := tok.Encode("café au lait")
+
+	got, _ := tok.Encode(decomposed)
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("Encode: got %v, want %v", got, want)
+	}
+	if n := tok.CountTokens([]byte(decomposed)); n != len(want) {
+		t.Fatalf("CountTokens: got %d, want %d", n, len(want))
+	}
+
+	enc := tok.NewEncoder()
+	var streamed []int
+	for _, chunk := range []string{"cafe", "́ au", " lait"} {
+		streamed = append(streamed, enc.Feed([]byte(chunk))...)
+	}
+	if streamed = append(streamed, enc.Flush()...); !reflect.DeepEqual(streamed, want) {
+		t.Fatalf("NewEncoder: got %v, want %v", streamed, want)
+	}
+}
+
+func TestLoad_NormalizationBeforePreTokenization(t *testing.T) {
+	tok, err := Load(Files(testVocabPath, testMergesPath), WithNormalization(NormalizeNFKC),
+		WithPreTokenization(PreTokenizeGPT2))
+	if err != nil {
+		t.Fatalf("load: %v", err)
+	}
+	if tok.Normalization() != NormalizeNFKC {
+		t.Fatalf("Normalization: got %v, want NFKC", tok.Normalization())
+	}
+
+	// full-width letters, comma and space only split like their ASCII forms once normalized
+	fullWidth := "ｈｅｌｌｏ，\u3000ｗｏｒｌｄ２０２４"
+	want, _ := tok.Encode("hello, world2024")
+	if got, _ := tok.Encode(fullWidth); !reflect.DeepEqual(got, want) {
+		t.Fatalf("Encode: got %v, want %v", got, want)
+	}
+
+	enc := tok.NewEncoder()
+	var streamed []int
+	for i := 0; i < len(fullWidth); i += 2 {
+		streamed = append(streamed, enc.Feed([]byte(fullWidth[i:min(i+2, len(fullWidth))]))...)
+	}
+	if streamed = append(streamed, enc.Flush()...); !reflect.DeepEqual(streamed, want) {
+		t.Fatalf("NewEncoder: got %v, want %v", streamed, want)
+	}
+}
+
+func TestLoad_DigitGroup(t *testing.T) {
+	tok, err := Load(Files(testVocabPath, testMergesPath), WithPreTokenization(PreTokenizeGPT2),
+		WithDigitGroup(1))
+	if err != nil {
+		t.Fatalf("load: %v", err)
+	}
+	if tok.DigitGroup() != 1 {
+		t.Fatalf("DigitGroup: got %d, want 1", tok.DigitGroup())
+	}
+
+	text := "in 2024, 365 days"
+	var want []int
+	for _, piece := range []string{"in", " 2", "0", "2", "4", ",", " 3", "6", "5", " days"} {
+		ids, _ := tok.Encode(piece)
+		want = append(want, ids...)
+	}
+	got, _ := tok.Encode(text)
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("Encode: got %v, want %v", got, want)
+	}
+	enc := tok.NewEncoder()
+	var streamed []int
+	for i := 0; i < len(text); i += 3 {
+		streamed = append(streamed, enc.Feed([]byte(text[i:min(i+3, len(text))]))...)
+	}
+	if streamed = append(streamed, enc.Flush()...); !reflect.DeepEqual(streamed, got) {
+		t.Fatalf("NewEncoder: got %v, want %v", streamed, got)
+	}
+
+	if _, err := Load(Files(testVocabPath, testMergesPath), WithDigitGroup(3)); err == nil {
+		t.Fatalf("digit groups without a pre-tokenizer should fail to load")
+	}
+}
+
+func TestNewPretokenEncoder(t *testing.T) {
+	tok, err := Load(Files(testVocabPath, testMergesPath), WithPreTokenization(PreTokenizeGPT2))
+	if err != nil {
+		t.Fatalf("load: %v", err)
+	}
+	enc, err := tok.NewPretokenEncoder()
+	if err != nil {
+		t.Fatalf("NewPretokenEncoder: %v", err)
+	}
+
+	text := "words come out one behind the input"
+	want, _ := tok.Encode(text)
+	first := enc.Feed([]byte("words come o"))
+	if w, _ := tok.Encode("words come"); !reflect.DeepEqual(first, w) {
+		t.Fatalf("first Feed: got %v, want %v", first, w)
+	}
+	streamed := append(first, enc.Feed([]byte(text[len("words come o"):]))...)
+	if streamed = append(streamed, enc.Flush()...); !reflect.DeepEqual(streamed, want) {
+		t.Fatalf("got %v, want %v", streamed, want)
+	}
+
+	if enc, err := loadTestTokenizer(t).NewPretokenEncoder(); !errors.Is(err, ErrNoPreTokenizer) || enc != nil {
+		t.Fatalf("without a pre-tokenizer: got %v, %v", enc, err)
+	}
+}
+
+func TestLoad_MemoryBudget(t *testing.T) {
+	_, err := Load(Files(testVocabPath, testMergesPath), WithMemoryBudget(1<<10))
+	if !errors.Is(err, ErrMemoryBudget) {
+		t.Fatalf("expected ErrMemoryBudget, got %v", err)
+	}
+}
+
+func TestLoad_TokenizerJSON(t *testing.T) {
+	vocab, err := os.ReadFile(testVocabPath)
+	if err != nil {
+		t.Fatalf("read vocab: %v", err)
+	}
+	data := []byte(`{"added_tokens":[{"id":50257,"content":"<|pad|>","special":true}],` +
+		`"pre_tokenizer":{"type":"ByteLevel"},"decoder":{"type":"ByteLevel"},` +
+		`"model":{"type":"BPE","vocab":` + string(vocab) + `,"merges":["Ġ t","h e","Ġt he"]}}`)
+
+	tok, err := Load(TokenizerJSONBytes(data))
+	if err != nil {
+		t.Fatalf("load: %v", err)
+	}
+	ids, _ := tok.Encode(" the")
+	if len(ids) != 1 || ids[0] != 262 {
+		t.Fatalf("Encode(\" the\") = %v, want [262]", ids)
+	}
+	if text, _ := tok.Decode([]int{50257}); text != "<|pad|>" {
+		t.Fatalf("Decode(50257) = %q", text)
+	}
+}
+
+func TestTokenizer_TemplateCache(t *testing.T) {
+	tok := loadTestTokenizer(t)
+	c := tok.NewTemplateCache(4)
+
+	for _, name := range []string{"Ada", "Grace"} {
+		got, err := c.Render("Hello {{name}}, welcome back!", map[string]string{"name": name})
+		if err != nil {
+			t.Fatalf("render: %v", err)
+		}
+		want, _ := tok.Encode("Hello " + name + ", welcome back!")
+		if !reflect.DeepEqual(got, want) {
+			t.Fatalf("got %v, want %v", got, want)
+		}
+	}
+	if st := c.Stats(); st.Hits != 1 || st.Misses != 1 {
+		t.Fatalf("unexpected stats %+v", st)
+	}
+}
+
+func TestTokenizer_Offsets(t *testing.T) {
+	tok := loadTestTokenizer(t)
+	ids, _ := tok.Encode("Hello world")
+
+	spans, err := tok.Offsets(ids)
+	if err != nil {
+		t.Fatalf("offsets: %v", err)
+	}
+	if want := [][2]int{{0, 5}, {5, 11}}; !reflect.DeepEqual(spans, want) {
+		t.Fatalf("got %v, want %v", spans, want)
+	}
+	if _, err := tok.Offsets([]int{-1}); !errors.Is(err, ErrInvalidTokenID) {
+		t.Fatalf("expected ErrInvalidTokenID, got %v", err)
+	}
+}
+
+func TestLoadTokenizerFromReadersAndFS(t *testing.T) {
+	vocab, err := os.ReadFile(testVocabPath)
+	if err != nil {
+		t.Fatalf("read vocab: %v", err)
+	}
+	merges, err := os.ReadFile(testMergesPath)
+	if err != nil {
+		t.Fatalf("read merges: %v", err)
+	}
+
+	fromReaders, err := LoadTokenizerFromReaders(bytes.NewReader(vocab), bytes.NewReader(merges))
+	if err != nil {
+		t.Fatalf("readers: %v", err)
+	}
+	fromFS, err := LoadTokenizerFS(os.DirFS("../internal/tokenizer/testdata"), "gpt2/vocab.json", "gpt2/merges.txt")
+	if err != nil {
+		t.Fatalf("fs: %v", err)
+	}
+
+	a, _ := fromReaders.Encode("same either way")
+	b, _ := fromFS.Encode("same either way")
+	if !reflect.DeepEqual(a, b) {
+		t.Fatalf("readers %v vs fs %v", a, b)
+	}
+}
+
+func TestTokenizer_DecodeMaxBytes(t *testing.T) {
+	tok := loadTestTokenizer(t)
+	ids, _ := tok.Encode("Hello world, again")
+
+	text, err := tok.Decode(ids, WithMaxBytes(14))
+	if !errors.Is(err, ErrDecodeLimit) || text != "Hello world," {
+		t.Fatalf("got %q, %v", text, err)
+	}
+	if text, err := tok.Decode(ids, WithMaxBytes(100)); err != nil || text != "Hello world, again" {
+		t.Fatalf("within limit: %q, %v", text, err)
+	}
+}
+
+func TestTokenizer_CompiledRoundTrip(t *testing.T) {
+	tok := loadTestTokenizer(t)
+	data, err := tok.MarshalBinary()
+	if err != nil {
+		t.Fatalf("marshal: %v", err)
+	}
+
+	loaded, err := Load(Compiled(data))
+	if err != nil {
+		t.Fatalf("load: %v", err)
+	}
+	a, _ := tok.Encode("compiled round trip")
+	b, _ := loaded.Encode("compiled round trip")
+	if !reflect.DeepEqual(a, b) {
+		t.Fatalf("got %v, want %v", b, a)
+	}
+
+	data[len(data)-1] ^= 1
+	if _, err := Load(Compiled(data)); !errors.Is(err, ErrCompiledFormat) {
+		t.Fatalf("expected ErrCompiledFormat, got %v", err)
+	}
+}
@@ -0,0 +1,257 @@
+package bpetok
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+	"unicode/utf8"
+)
+
+func decodeAll(dec Decoder, ids []int, chunk int) []byte {
+	var out []byte
+	for pos := 0; pos < len(ids); pos += chunk {
+		// copy, the slice is only valid until the next call
+		out = append(out, dec.Feed(ids[pos:min(pos+chunk, len(ids))])...)
+	}
+	return append(out, dec.Flush()...)
+}
+
+func TestNewDecoder_MatchesDecode(t *testing.T) {
+	tok := loadTestTokenizer(t)
+	input := "The quick brown fox. Héllo 🌍 日本語, split me anywhere!"
+	ids := mustEncode(t, tok, input)
+
+	for _, chunk := range []int{1, 2, 3, 5, len(ids)} {
+		dec := tok.NewDecoder()
+		var out []byte
+		for pos := 0; pos < len(ids); pos += chunk {
+			got := dec.Feed(ids[pos:min(pos+chunk, len(ids))])
+			if !utf8.Valid(got) {
+				t.Fatalf("chunk=%d: Feed returned a partial character %q", chunk, got)
+			}
+			out = append(out, got...)
+		}
+		if rest := dec.Flush(); len(rest) != 0 {
+			t.Fatalf("chunk=%d: nothing should be held back at the end of valid text, got %q", chunk, rest)
+		}
+		if string(out) != input {
+			t.Fatalf("chunk=%d: got %q", chunk, out)
+		}
+	}
+}
+
+func TestNewDecoder_ReusesItsBuffer(t *testing.T) {
+	tok := loadTestTokenizer(t)
+	dec := tok.NewDecoder()
+
+	first := dec.Feed(mustEncode(t, tok, "a first chunk that is long enough"))
+	kept := bytes.Clone(first)
+	second := dec.Feed(mustEncode(t, tok, "short"))
+	if &first[0] != &second[0] {
+		t.Fatalf("expected Feed to reuse its output buffer")
+	}
+	if string(second) != "short" || bytes.Equal(first[:len(second)], kept[:len(second)]) {
+		t.Fatalf("the earlier slice should have been overwritten in place, got %q then %q", kept, second)
+	}
+}
+
+func TestNewDecoder_FlushlessStreams(t *testing.T) {
+	tok := loadTestTokenizer(t)
+	dec := tok.NewDecoder()
+
+	// each stream ends on a character boundary, so no Flush is needed between them
+	for _, s := range []string{"first stream 🌍", "ünïcödé second", "third"} {
+		ids := mustEncode(t, tok, s)
+		var out []byte
+		for _, id := range ids {
+			out = append(out, dec.Feed([]int{id})...)
+		}
+		if string(out) != s {
+			t.Fatalf("stream %q decoded to %q", s, out)
+		}
+	}
+
+	// a stream cut mid-character keeps its bytes until Flush, which resets the decoder
+	ids := mustEncode(t, tok, "🌍")
+	if len(ids) < 2 {
+		t.Skip("emoji encodes to a single token")
+	}
+	if got := dec.Feed(ids[:1]); len(got) != 0 {
+		t.Fatalf("expected the partial emoji to be held back, got %q", got)
+	}
+	held := dec.Flush()
+	if !bytes.HasPrefix([]byte("🌍"), held) || len(held) == 0 {
+		t.Fatalf("Flush returned %q", held)
+	}
+	if got := decodeAll(dec, mustEncode(t, tok, "clean"), 2); string(got) != "clean" {
+		t.Fatalf("after Flush the decoder should start fresh, got %q", got)
+	}
+}
+
+func TestNewDecoder_WithReplacement(t *testing.T) {
+	tok := loadTestTokenizer(t)
+	// an emoji cut short in the middle of the text, and a character cut short at its end
+	var broken []int
+	for _, s := range []string{"Héllo ", "\xf0\x9f", " 日本語 tail\xe2"} {
+		broken = append(broken, mustEncode(t, tok, s)...)
+	}
+
+	want, err := tok.Decode(broken, WithReplacement(true))
+	if err != nil {
+		t.Fatalf("Decode: %v", err)
+	}
+	if want != "Héllo \uFFFD\uFFFD 日本語 tail\uFFFD" {
+		t.Fatalf("Decode with replacement returned %q", want)
+	}
+	for _, chunk := range []int{1, 2, 3, len(broken)} {
+		dec := tok.NewDecoder(WithReplacement(true))
+		if got := decodeAll(dec, broken, chunk); string(got) != want {
+			t.Fatalf("chunk=%d: got %q, want %q", chunk, got, want)
+		}
+	}
+
+	raw, _ := tok.Decode(broken)
+	if got := decodeAll(tok.NewDecoder(), broken, 1); string(got) != raw || utf8.ValidString(raw) {
+		t.Fatalf("without the option the bytes should come out as is, got %q", got)
+	}
+}
+
+func TestNewDecoder_InvalidID(t *testing.T) {
+	tok := loadTestTokenizer(t)
+	dec := tok.NewDecoder()
+
+	// the stream stops at the bad ID, the bytes before it still come out, the split 🌍 on Flush
+	ids := append(mustEncode(t, tok, "good \xf0\x9f"), -1)
+	ids = append(ids, mustEncode(t, tok, " never decoded")...)
+	if out := dec.Feed(ids); string(out) != "good " {
+		t.Fatalf("Feed returned %q", out)
+	}
+	if !errors.Is(dec.Err(), ErrInvalidTokenID) {
+		t.Fatalf("Err = %v", dec.Err())
+	}
+	if out := dec.Feed(mustEncode(t, tok, "more")); out != nil {
+		t.Fatalf("a stopped stream returned %q", out)
+	}
+	if out := dec.Flush(); string(out) != "\xf0\x9f" || !errors.Is(dec.Err(), ErrInvalidTokenID) {
+		t.Fatalf("Flush returned %q, Err %v", out, dec.Err())
+	}
+
+	// the next stream starts clean
+	if out := dec.Feed(mustEncode(t, tok, "next")); string(out) != "next" || dec.Err() != nil {
+		t.Fatalf("next stream: %q, %v", out, dec.Err())
+	}
+	if out := dec.Feed([]int{tok.VocabSize()}); out != nil || !errors.Is(dec.Err(), ErrInvalidTokenID) {
+		t.Fatalf("ID past the vocab: %q, %v", out, dec.Err())
+	}
+}
+
+// shortWriter accepts up to n bytes, then fails.
+type shortWriter struct {
+	buf bytes.Buffer
+	n   int
+}
+
+func (w *shortWriter) Write(p []byte) (int, error) {
+	if w.buf.Len()+len(p) > w.n {
+		k := w.n - w.buf.Len()
+		w.buf.Write(p[:k])
+		return k, io.ErrShortWrite
+	}
+	return w.buf.Write(p)
+}
+
+func TestDecodeTo(t *testing.T) {
+	tok := loadTestTokenizer(t)
+	text := strings.Repeat("The quick brown fox. Héllo 🌍 日本語! ", 500)
+	ids := mustEncode(t, tok, text)
+
+	var buf bytes.Buffer
+	if n, err := tok.DecodeTo(&buf, ids); err != nil || n != len(text) || buf.String() != text {
+		t.Fatalf("DecodeTo wrote %d bytes, %v, matching Decode: %v", n, err, buf.String() == text)
+	}
+
+	// the writer's error stops it, with the count of what it took
+	w := &shortWriter{n: 5000}
+	if n, err := tok.DecodeTo(w, ids); !errors.Is(err, io.ErrShortWrite) || n != 5000 || w.buf.String() != text[:5000] {
+		t.Fatalf("short writer: %d, %v", n, err)
+	}
+
+	// IDs are checked before anything is written
+	buf.Reset()
+	if n, err := tok.DecodeTo(&buf, append(ids[:3:3], -1)); !errors.Is(err, ErrInvalidTokenID) || n != 0 || buf.Len() != 0 {
+		t.Fatalf("invalid ID: %d, %v, wrote %q", n, err, buf.String())
+	}
+
+	// the options work as they do for Decode
+	want, wantErr := tok.Decode(ids, WithMaxBytes(100))
+	buf.Reset()
+	if n, err := tok.DecodeTo(&buf, ids, WithMaxBytes(100)); !errors.Is(err, ErrDecodeLimit) || err != wantErr ||
+		n != len(want) || buf.String() != want {
+		t.Fatalf("WithMaxBytes: wrote %q, %v, want %q", buf.String(), err, want)
+	}
+	broken := append(mustEncode(t, tok, "cut \xf0\x9f"), ids...)
+	broken = append(broken, mustEncode(t, tok, "\xe2")...)
+	want, _ = tok.Decode(broken, WithReplacement(true))
+	buf.Reset()
+	if n, err := tok.DecodeTo(&buf, broken, WithReplacement(true)); err != nil || n != len(want) || buf.String() != want {
+		t.Fatalf("WithReplacement: %d, %v, matching Decode: %v", n, err, buf.String() == want)
+	}
+
+	// the output is never built in memory, it goes out in small writes
+	lw := &largestWrite{}
+	if _, err := tok.DecodeTo(lw, ids); err != nil || lw.largest == 0 || lw.largest > 8<<10 {
+		t.Fatalf("the largest of the writes was %d bytes out of %d, %v", lw.largest, len(text), err)
+	}
+}
+
+type largestWrite struct{ largest int }
+
+func (w *largestWrite) Write(p []byte) (int, error) {
+	w.largest = max(w.largest, len(p))
+	return len(p), nil
+}
+
+func TestDecode_SpecialTokens(t *testing.T) {
+	tok, err := Load(Files(testVocabPath, testMergesPath), WithSpecialTokens(map[string]int{"<|endoftext|>": 50256}))
+	if err != nil {
+		t.Fatalf("load: %v", err)
+	}
+	ids, err := tok.EncodeWithSpecial("first doc<|endoftext|>second 🌍<|endoftext|>", SpecialPolicy{Allowed: []string{AllSpecial}})
+	if err != nil {
+		t.Fatalf("EncodeWithSpecial: %v", err)
+	}
+
+	for _, tc := range []struct {
+		name string
+		opts []DecodeOption
+		want string
+	}{
+		{"default", nil, "first doc<|endoftext|>second 🌍<|endoftext|>"},
+		{"skip", []DecodeOption{WithSkipSpecial(true)}, "first docsecond 🌍"},
+		{"skip off", []DecodeOption{WithSkipSpecial(false)}, "first doc<|endoftext|>second 🌍<|endoftext|>"},
+		{"text", []DecodeOption{WithSpecialText("\n")}, "first doc\nsecond 🌍\n"},
+		{"last wins", []DecodeOption{WithSpecialText("\n"), WithSkipSpecial(true)}, "first docsecond 🌍"},
+	} {
+		got, err := tok.Decode(ids, tc.opts...)
+		if err != nil || got != tc.want {
+			t.Fatalf("%s: Decode got %q, %v, want %q", tc.name, got, err, tc.want)
+		}
+		var buf bytes.Buffer
+		if n, err := tok.DecodeTo(&buf, ids, tc.opts...); err != nil || n != len(tc.want) || buf.String() != tc.want {
+			t.Fatalf("%s: DecodeTo got %q, %v", tc.name, buf.String(), err)
+		}
+		for _, chunk := range []int{1, 3} {
+			if got := decodeAll(tok.NewDecoder(tc.opts...), ids, chunk); string(got) != tc.want {
+				t.Fatalf("%s: NewDecoder chunk=%d got %q", tc.name, chunk, got)
+			}
+		}
+	}
+
+	// WithMaxBytes counts the text special tokens decode to
+	got, err := tok.Decode(ids, WithSpecialText(" [END] "), WithMaxBytes(len("first doc [END] second")))
+	if !errors.Is(err, ErrDecodeLimit) || got != "first doc [END] second" {
+		t.Fatalf("WithMaxBytes: got %q, %v", got, err)
+	}
+}
@@ -0,0 +1,258 @@
+// Package download fetches tokenizer assets into a local cache. Every asset is pinned by SHA-256, partial
+// downloads are resumed with a Range request, and Hugging Face URLs get an auth token when one is set.
+//
+// The cache lives in $BPETOK_CACHE_DIR, or bpetok/ under the user cache dir ($XDG_CACHE_HOME, ~/.cache
+// on Linux), one subdirectory per model.
+package download
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// CacheDirEnv overrides the cache directory.
+const CacheDirEnv = "BPETOK_CACHE_DIR"
+
+// TokenEnv is where Client reads a Hugging Face token from when Client.Token is empty.
+const TokenEnv = "HF_TOKEN"
+
+// ErrChecksum means a downloaded or cached asset doesn't match its pinned hash.
+var ErrChecksum = errors.New("download: checksum mismatch")
+
+// Asset is one file a model needs.
+type Asset struct {
+	Name   string // file name inside the model's cache dir
+	URL    string
+	SHA256 string // hex
+}
+
+func tiktokenAssets(name, sha string) []Asset {
+	return []Asset{{
+		Name:   name + ".tiktoken",
+		URL:    "https://openaipublic.blob.core.windows.net/encodings/" + name + ".tiktoken",
+		SHA256: sha,
+	}}
+}
+
+var gpt2Assets = []Asset{
+	{
+		Name:   "vocab.json",
+		URL:    "https://huggingface.co/openai-community/gpt2/resolve/main/vocab.json",
+		SHA256: "196139668be63f3b5d6574427317ae82f612a97c5d1cdaf36ed2256dbf636783",
+	},
+	{
+		Name:   "merges.txt",
+		URL:    "https://huggingface.co/openai-community/gpt2/resolve/main/merges.txt",
+		SHA256: "1ce1664773c50f3e0cc8842619a93edc4624525b728b188a9e0be33b7726adc5",
+	},
+}
+
+var (
+	modelsMu sync.RWMutex
+
+	// models are vocab.json and merges.txt, in that order, or a single tiktoken rank file. r50k_base is
+	// tiktoken's name for the GPT-2 vocab; both are cached under their own name so either can be pre-seeded.
+	models = map[string][]Asset{
+		"gpt2":        gpt2Assets,
+		"r50k_base":   gpt2Assets,
+		"p50k_base":   tiktokenAssets("p50k_base", "94b5ca7dff4d00767bc256fdd1b27e5b17361d7b8a5f968547f9f23eb70d2069"),
+		"cl100k_base": tiktokenAssets("cl100k_base", "223921b76ee99bde995b7ff738513eef100fb51d18c93597a113bcffe865b2a7"),
+		"o200k_base":  tiktokenAssets("o200k_base", "446a9538cb6c348e3516120d7c08b09f57c36495e2acfffe59a5bf8b0cfb1a2d"),
+	}
+)
+
+// Assets returns the files a known model needs.
+func Assets(name string) ([]Asset, bool) {
+	modelsMu.RLock()
+	defer modelsMu.RUnlock()
+	a, ok := models[name]
+	return a, ok
+}
+
+// Register adds or replaces a model, e.g. a private vocab on the Hub. It takes either two assets
+// (vocab.json, merges.txt) or one tiktoken rank file.
+func Register(name string, assets []Asset) {
+	modelsMu.Lock()
+	defer modelsMu.Unlock()
+	models[name] = assets
+}
+
+// CacheDir returns $BPETOK_CACHE_DIR, or bpetok/ under the user cache dir.
+func CacheDir() (string, error) {
+	if dir := os.Getenv(CacheDirEnv); dir != "" {
+		return dir, nil
+	}
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		return "", fmt.Errorf("download: no cache dir, set %s: %w", CacheDirEnv, err)
+	}
+	return filepath.Join(dir, "bpetok"), nil
+}
+
+// DefaultTimeout bounds each download of a Client without an HTTPClient, the whole response included, so a
+// stalled server fails the download instead of hanging it.
+const DefaultTimeout = 5 * time.Minute
+
+// defaultHTTPClient is the HTTPClient of a Client that sets none.
+var defaultHTTPClient = &http.Client{Timeout: DefaultTimeout}
+
+// Client downloads assets. The zero value uses CacheDir, $HF_TOKEN and an HTTP client with DefaultTimeout.
+type Client struct {
+	Dir        string       // cache root; empty means CacheDir()
+	Token      string       // Hugging Face token; empty means $HF_TOKEN
+	HTTPClient *http.Client // nil means a client with DefaultTimeout
+}
+
+// LoadOrDownload returns the asset contents of a known model using the zero Client.
+func LoadOrDownload(name string) ([][]byte, error) {
+	var c Client
+	return c.LoadOrDownload(name)
+}
+
+// LoadOrDownload returns the asset contents of a known model, in Assets order, downloading whatever isn't
+// cached yet.
+func (c *Client) LoadOrDownload(name string) ([][]byte, error) {
+	assets, ok := Assets(name)
+	if !ok {
+		return nil, fmt.Errorf("download: unknown model %q", name)
+	}
+	root := c.Dir
+	if root == "" {
+		var err error
+		if root, err = CacheDir(); err != nil {
+			return nil, err
+		}
+	}
+
+	files := make([][]byte, len(assets))
+	for i, a := range assets {
+		data, err := c.Fetch(filepath.Join(root, name), a)
+		if err != nil {
+			return nil, fmt.Errorf("download: %s: %w", name, err)
+		}
+		files[i] = data
+	}
+	return files, nil
+}
+
+// Fetch returns dir/a.Name, downloading it first if it's missing. A cached file whose hash doesn't match is
+// an error rather than silently re-downloaded, something else put it there. An interrupted download leaves
+// a .part file behind that the next call resumes from.
+func (c *Client) Fetch(dir string, a Asset) ([]byte, error) {
+	path := filepath.Join(dir, a.Name)
+
+	data, err := os.ReadFile(path)
+	if err == nil {
+		if err := checkHash(data, a); err != nil {
+			return nil, fmt.Errorf("cached %s: %w", path, err)
+		}
+		return data, nil
+	}
+	if !errors.Is(err, os.ErrNotExist) {
+		return nil, err
+	}
+
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+	part := path + ".part"
+	if err := c.get(a.URL, part); err != nil {
+		return nil, err
+	}
+	if data, err = os.ReadFile(part); err != nil {
+		return nil, err
+	}
+	if err := checkHash(data, a); err != nil {
+		// a bad prefix would poison every resume, start over next time
+		os.Remove(part)
+		return nil, fmt.Errorf("download %s: %w", a.URL, err)
+	}
+	return data, os.Rename(part, path)
+}
+
+// get appends the rest of rawURL to part, or rewrites it if the server doesn't honour the range.
+func (c *Client) get(rawURL, part string) error {
+	f, err := os.OpenFile(part, os.O_RDWR|os.O_CREATE, 0o644)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	have, err := f.Seek(0, io.SeekEnd)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequest(http.MethodGet, rawURL, nil)
+	if err != nil {
+		return err
+	}
+	if have > 0 {
+		req.Header.Set("Range", fmt.Sprintf("bytes=%d-", have))
+	}
+	if tok := c.token(); tok != "" && isHub(req.URL) {
+		req.Header.Set("Authorization", "Bearer "+tok)
+	}
+
+	hc := c.HTTPClient
+	if hc == nil {
+		hc = defaultHTTPClient
+	}
+	resp, err := hc.Do(req)
+	if err != nil {
+		return fmt.Errorf("GET %s: %w", rawURL, err)
+	}
+	defer resp.Body.Close()
+
+	switch {
+	case resp.StatusCode == http.StatusPartialContent && have > 0:
+	case resp.StatusCode == http.StatusRequestedRangeNotSatisfiable && have > 0:
+		// already complete, the hash check decides
+		return nil
+	case resp.StatusCode == http.StatusOK:
+		if _, err := f.Seek(0, io.SeekStart); err != nil {
+			return err
+		}
+		if err := f.Truncate(0); err != nil {
+			return err
+		}
+	default:
+		return fmt.Errorf("GET %s: unexpected status %s", rawURL, resp.Status)
+	}
+
+	if _, err := io.Copy(f, resp.Body); err != nil {
+		return fmt.Errorf("GET %s: %w", rawURL, err)
+	}
+	return f.Close()
+}
+
+func (c *Client) token() string {
+	if c.Token != "" {
+		return c.Token
+	}
+	return os.Getenv(TokenEnv)
+}
+
+// isHub limits the token to Hugging Face hosts so it never leaks to a mirror or a redirect target elsewhere.
+func isHub(u *url.URL) bool {
+	h := u.Hostname()
+	return h == "huggingface.co" || strings.HasSuffix(h, ".huggingface.co")
+}
+
+func checkHash(data []byte, a Asset) error {
+	sum := sha256.Sum256(data)
+	if got := hex.EncodeToString(sum[:]); got != a.SHA256 {
+		return fmt.Errorf("%w: sha256 %s, want %s", ErrChecksum, got, a.SHA256)
+	}
+	return nil
+}
@@ -0,0 +1,107 @@
+package download
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func assetFor(url string, data []byte) Asset {
+	sum := sha256.Sum256(data)
+	return Asset{Name: "vocab.json", URL: url, SHA256: hex.EncodeToString(sum[:])}
+}
+
+func TestFetch_ResumesPartialDownload(t *testing.T) {
+	data := bytes.Repeat([]byte("0123456789"), 1000)
+
+	var ranges []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ranges = append(ranges, r.Header.Get("Range"))
+		http.ServeContent(w, r, "vocab.json", time.Time{}, bytes.NewReader(data))
+	}))
+	defer srv.Close()
+
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "vocab.json.part"), data[:4000], 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	var c Client
+	a := assetFor(srv.URL+"/vocab.json", data)
+	got, err := c.Fetch(dir, a)
+	if err != nil {
+		t.Fatalf("Fetch: %v", err)
+	}
+	if !bytes.Equal(got, data) {
+		t.Fatalf("resumed download differs from the original")
+	}
+	if len(ranges) != 1 || ranges[0] != "bytes=4000-" {
+		t.Fatalf("expected one ranged request, got %q", ranges)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "vocab.json.part")); !errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("part file left behind: %v", err)
+	}
+
+	// cached now, no more requests
+	if _, err := c.Fetch(dir, a); err != nil || len(ranges) != 1 {
+		t.Fatalf("expected a cache hit, got %d requests, err %v", len(ranges), err)
+	}
+}
+
+func TestFetch_ChecksumMismatch(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, "not what was pinned")
+	}))
+	defer srv.Close()
+
+	dir := t.TempDir()
+	var c Client
+	_, err := c.Fetch(dir, assetFor(srv.URL+"/vocab.json", []byte("the real thing")))
+	if !errors.Is(err, ErrChecksum) {
+		t.Fatalf("expected ErrChecksum, got %v", err)
+	}
+	if entries, _ := os.ReadDir(dir); len(entries) != 0 {
+		t.Fatalf("a bad download should leave nothing behind, found %d files", len(entries))
+	}
+}
+
+type roundTripFunc func(*http.Request) (*http.Response, error)
+
+func (f roundTripFunc) RoundTrip(req *http.Request) (*http.Response, error) { return f(req) }
+
+func TestFetch_TokenOnlyForHub(t *testing.T) {
+	data := []byte(`{"a":0}`)
+	auth := map[string]string{}
+	c := Client{
+		Token: "hf_secret",
+		HTTPClient: &http.Client{Transport: roundTripFunc(func(req *http.Request) (*http.Response, error) {
+			auth[req.URL.Host] = req.Header.Get("Authorization")
+			return &http.Response{StatusCode: http.StatusOK, Body: io.NopCloser(bytes.NewReader(data))}, nil
+		})},
+	}
+
+	for _, url := range []string{"https://huggingface.co/org/private/resolve/main/vocab.json", "https://example.com/vocab.json"} {
+		if _, err := c.Fetch(t.TempDir(), assetFor(url, data)); err != nil {
+			t.Fatalf("Fetch %s: %v", url, err)
+		}
+	}
+	if auth["huggingface.co"] != "Bearer hf_secret" || auth["example.com"] != "" {
+		t.Fatalf("unexpected Authorization headers %q", auth)
+	}
+}
+
+func TestLoadOrDownload_Unknown(t *testing.T) {
+	t.Setenv(CacheDirEnv, t.TempDir())
+	if _, err := LoadOrDownload("nope"); err == nil || !strings.Contains(err.Error(), "unknown model") {
+		t.Fatalf("expected an unknown model error, got %v", err)
+	}
+}
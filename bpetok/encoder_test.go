@@ -0,0 +1,425 @@
+package bpetok
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"reflect"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func feedAll(enc Encoder, input []byte, chunk int) []int {
+	var out []int
+	for pos := 0; pos < len(input); pos += chunk {
+		end := min(pos+chunk, len(input))
+		out = append(out, enc.Feed(input[pos:end])...)
+	}
+	return append(out, enc.Flush()...)
+}
+
+func TestNewEncoder_MatchesOffline(t *testing.T) {
+	tok := loadTestTokenizer(t)
+	input := []byte("The quick brown fox jumps over the lazy dog. Héllo 🌍, split me anywhere!")
+	want := tok.tok.EncodeOffline(input, nil)
+
+	for _, chunk := range []int{1, 2, 3, 7, 16, len(input)} {
+		got := feedAll(tok.NewEncoder(), input, chunk)
+		if !reflect.DeepEqual(got, want) {
+			t.Fatalf("chunk=%d mismatch:\ngot  %v\nwant %v", chunk, got, want)
+		}
+		if out := tok.tok.Decode(got); !bytes.Equal(out, input) {
+			t.Fatalf("chunk=%d roundtrip mismatch: %q", chunk, out)
+		}
+	}
+}
+
+func TestNewEncoder_ReusableAfterFlush(t *testing.T) {
+	tok := loadTestTokenizer(t)
+	enc := tok.NewEncoder()
+
+	for _, s := range []string{"first stream", "second, unrelated stream", "x"} {
+		want := tok.tok.EncodeOffline([]byte(s), nil)
+		if got := feedAll(enc, []byte(s), 3); !reflect.DeepEqual(got, want) {
+			t.Fatalf("%q: got %v want %v", s, got, want)
+		}
+	}
+
+	if out := enc.Flush(); len(out) != 0 {
+		t.Fatalf("flush on an empty encoder should emit nothing, got %v", out)
+	}
+}
+
+func TestNewEncoder_Reset(t *testing.T) {
+	tok := loadTestTokenizer(t)
+	enc := tok.NewEncoder()
+
+	enc.Feed([]byte("a stream the client hung up on"))
+	enc.(Resetter).Reset()
+
+	want := tok.tok.EncodeOffline([]byte("next request"), nil)
+	if got := feedAll(enc, []byte("next request"), 4); !reflect.DeepEqual(got, want) {
+		t.Fatalf("after Reset: got %v want %v", got, want)
+	}
+}
+
+func TestNewEncoder_FlushSoft(t *testing.T) {
+	tok := loadTestTokenizer(t)
+	enc := tok.NewEncoder()
+
+	var got, want []int
+	for _, rec := range []string{"one record,", "another record,", "the last one"} {
+		want = append(want, tok.tok.EncodeOffline([]byte(rec), nil)...)
+		for pos := 0; pos < len(rec); pos += 5 {
+			got = append(got, enc.Feed([]byte(rec[pos:min(pos+5, len(rec))]))...)
+		}
+		got = append(got, enc.(SoftFlusher).FlushSoft()...)
+	}
+	if out := enc.Flush(); len(out) != 0 {
+		t.Fatalf("Flush after FlushSoft emitted %v", out)
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("got %v want %v", got, want)
+	}
+}
+
+func TestNewResultEncoder_Patcher(t *testing.T) {
+	tok := loadTestTokenizer(t)
+	input := "shown at once, patched later"
+	want, _ := tok.Encode(input)
+
+	p := tok.NewResultEncoder().(Patcher)
+	var shown []int
+	for i := 0; i < len(input); i += 4 {
+		patch := p.PushPatch([]byte(input[i:min(i+4, len(input))]))
+		shown = append(shown[:len(shown)-patch.Retract], patch.Tokens...)
+	}
+	patch := p.FlushPatch()
+	if shown = append(shown[:len(shown)-patch.Retract], patch.Tokens...); !reflect.DeepEqual(shown, want) {
+		t.Fatalf("got %v want %v", shown, want)
+	}
+}
+
+func TestNewEncoder_SnapshotRestore(t *testing.T) {
+	tok := loadTestTokenizer(t)
+	input := "a live transcript that outlives the process holding it"
+	want := tok.tok.EncodeOffline([]byte(input), nil)
+
+	enc := tok.NewEncoder()
+	got := enc.Feed([]byte(input[:20]))
+	snap := enc.(Snapshotter).Snapshot()
+
+	resumed := tok.NewEncoder()
+	if err := resumed.(Snapshotter).Restore(snap); err != nil {
+		t.Fatalf("Restore: %v", err)
+	}
+	got = append(got, feedAll(resumed, []byte(input[20:]), 7)...)
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("got %v want %v", got, want)
+	}
+
+	if err := resumed.(Snapshotter).Restore([]byte("junk")); !errors.Is(err, ErrSnapshotFormat) {
+		t.Fatalf("Restore of junk: got %v", err)
+	}
+}
+
+func TestNewEncoder_Inspector(t *testing.T) {
+	tok := loadTestTokenizer(t)
+	enc := tok.NewEncoder(WithTailReserve(64))
+	in := enc.(Inspector)
+
+	enc.Feed([]byte("a stream whose tail is held back"))
+	if in.PendingBytes() == 0 || in.PendingNodes() == 0 || in.Stats().Merges == 0 {
+		t.Fatalf("pending %d bytes in %d nodes, stats %+v", in.PendingBytes(), in.PendingNodes(), in.Stats())
+	}
+	enc.Flush()
+	if in.PendingBytes() != 0 {
+		t.Fatalf("pending %d bytes after Flush", in.PendingBytes())
+	}
+	in.ResetStats()
+	if in.Stats() != (EncoderStats{}) {
+		t.Fatalf("ResetStats left %+v", in.Stats())
+	}
+}
+
+func TestNewEncoder_IndependentStreams(t *testing.T) {
+	tok := loadTestTokenizer(t)
+	a, b := tok.NewEncoder(), tok.NewEncoder()
+
+	var outA, outB []int
+	outA = append(outA, a.Feed([]byte("hello "))...)
+	outB = append(outB, b.Feed([]byte("goodbye "))...)
+	outA = append(outA, a.Feed([]byte("world"))...)
+	outB = append(outB, b.Feed([]byte("moon"))...)
+	outA = append(outA, a.Flush()...)
+	outB = append(outB, b.Flush()...)
+
+	if string(tok.tok.Decode(outA)) != "hello world" || string(tok.tok.Decode(outB)) != "goodbye moon" {
+		t.Fatalf("streams leaked into each other: %q / %q", tok.tok.Decode(outA), tok.tok.Decode(outB))
+	}
+}
+
+func TestNewEncoder_WarnsOnInvalidUTF8(t *testing.T) {
+	tok := loadTestTokenizer(t)
+	enc := tok.NewEncoder(WithWarnings(true))
+	wr, ok := enc.(WarningReporter)
+	if !ok {
+		t.Fatalf("encoder does not report warnings")
+	}
+
+	// a euro sign cut off at the end of the stream, then a clean second stream
+	feedAll(enc, []byte("ok \xe2\x82"), 1)
+	feedAll(enc, []byte("fine"), 4)
+
+	ws := wr.Warnings()
+	if len(ws) != 1 || ws[0].Count != 2 || ws[0].FirstOffset != 3 {
+		t.Fatalf("unexpected warnings %+v", ws)
+	}
+
+	wr.ResetWarnings()
+	feedAll(enc, []byte("clean text"), 3)
+	if ws := wr.Warnings(); len(ws) != 0 {
+		t.Fatalf("expected no warnings after reset, got %+v", ws)
+	}
+}
+
+func TestEncoders_CappedRankWarnings(t *testing.T) {
+	tok, err := Load(Files(testVocabPath, testMergesPath), WithMaxRank(1000), WithPreTokenization(PreTokenizeGPT2))
+	if err != nil {
+		t.Fatalf("load: %v", err)
+	}
+	input := []byte("The quick brown fox jumps over the lazy dog, again and again.")
+	want := mustEncode(t, tok, string(input))
+	if full := mustEncode(t, loadTestTokenizer(t), string(input)); len(full) >= len(want) {
+		t.Fatalf("the cap should leave more tokens: %d capped, %d full", len(want), len(full))
+	}
+
+	pretoken, err := tok.NewPretokenEncoder(WithWarnings(true))
+	if err != nil {
+		t.Fatalf("NewPretokenEncoder: %v", err)
+	}
+	for name, enc := range map[string]Encoder{
+		"incremental": tok.NewEncoder(WithWarnings(true)),
+		"adaptive":    tok.NewAdaptiveEncoder(WithWarnings(true)),
+		"naive":       tok.NewNaiveEncoder(WithWarnings(true)),
+		"pretoken":    pretoken,
+	} {
+		if got := feedAll(enc, input, 5); !reflect.DeepEqual(got, want) {
+			t.Fatalf("%s: got %v, want %v", name, got, want)
+		}
+		ws := enc.(WarningReporter).Warnings()
+		if len(ws) != 1 || ws[0].Kind.String() != "capped-rank" || ws[0].FirstOffset <= 0 ||
+			ws[0].FirstOffset >= int64(len(input)) {
+			t.Fatalf("%s: warnings %+v", name, ws)
+		}
+	}
+
+	// nothing is recorded, or checked, unless asked for
+	enc := tok.NewEncoder()
+	feedAll(enc, input, 5)
+	if ws := enc.(WarningReporter).Warnings(); len(ws) != 0 {
+		t.Fatalf("warnings without WithWarnings: %+v", ws)
+	}
+}
+
+func TestNewEncoder_Options(t *testing.T) {
+	tok := loadTestTokenizer(t)
+	input := []byte("Options should not change what gets emitted, only how.")
+	want := tok.tok.EncodeOffline(input, nil)
+
+	for _, opts := range [][]EncoderOption{
+		{WithHeap(HeapBinary)},
+		{WithZeroCopyOutput(true)},
+		{WithHeap(HeapBucket), WithTailReserve(tok.tok.MaxTokenByteLen - 1)},
+		{WithCommitPolicy(CommitRankAware)},
+		{WithMaxChunk(7)},
+	} {
+		// every constructor takes the same options, the ones tuning the merge engine only matter to NewEncoder
+		for name, enc := range map[string]Encoder{
+			"incremental": tok.NewEncoder(opts...),
+			"adaptive":    tok.NewAdaptiveEncoder(opts...),
+			"naive":       tok.NewNaiveEncoder(opts...),
+		} {
+			var got []int
+			for pos := 0; pos < len(input); pos += 5 {
+				got = append(got, enc.Feed(input[pos:min(pos+5, len(input))])...)
+			}
+			got = append(got, enc.Flush()...)
+			if !reflect.DeepEqual(got, want) {
+				t.Fatalf("%s: got %v want %v", name, got, want)
+			}
+		}
+	}
+}
+
+// TestEncoders_ZeroCopyOutput checks that every encoder hands out slices the caller owns by default and
+// reuses one buffer with WithZeroCopyOutput.
+func TestEncoders_ZeroCopyOutput(t *testing.T) {
+	tok, err := Load(Files(testVocabPath, testMergesPath), WithPreTokenization(PreTokenizeGPT2))
+	if err != nil {
+		t.Fatalf("load: %v", err)
+	}
+	encoders := map[string]func(zeroCopy bool) Encoder{
+		"incremental": func(zeroCopy bool) Encoder { return tok.NewEncoder(WithZeroCopyOutput(zeroCopy)) },
+		"adaptive":    func(zeroCopy bool) Encoder { return tok.NewAdaptiveEncoder(WithZeroCopyOutput(zeroCopy)) },
+		"naive":       func(zeroCopy bool) Encoder { return tok.NewNaiveEncoder(WithZeroCopyOutput(zeroCopy)) },
+		"pretoken": func(zeroCopy bool) Encoder {
+			enc, err := tok.NewPretokenEncoder(WithZeroCopyOutput(zeroCopy))
+			if err != nil {
+				t.Fatalf("NewPretokenEncoder: %v", err)
+			}
+			return enc
+		},
+	}
+
+	for name, newEncoder := range encoders {
+		for _, zeroCopy := range []bool{false, true} {
+			enc := newEncoder(zeroCopy)
+			enc.Feed([]byte("hello there"))
+			a := enc.Flush()
+			enc.Feed([]byte("general there"))
+			b := enc.Flush()
+			if len(a) == 0 || len(b) == 0 {
+				t.Fatalf("%s: expected flush output", name)
+			}
+			if shared := &a[0] == &b[0]; shared != zeroCopy {
+				t.Fatalf("%s, zero-copy %v: flushes share a buffer: %v", name, zeroCopy, shared)
+			}
+		}
+	}
+}
+
+func TestEncoderPool(t *testing.T) {
+	tok := loadTestTokenizer(t)
+	pool := tok.NewEncoderPool(WithCommitPolicy(CommitRankAware), WithWarnings(true))
+	input := []byte("Pooled encoders start every request from scratch.")
+	want, _ := tok.Encode(string(input))
+
+	// a request that gives up mid-stream, with a warning, leaves nothing behind
+	enc := pool.Get()
+	enc.Feed([]byte("abandoned \xff half a wo"))
+	pool.Put(enc)
+
+	var wg sync.WaitGroup
+	errs := make(chan string, 8)
+	for range 8 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for range 20 {
+				enc := pool.Get()
+				if ws := enc.(WarningReporter).Warnings(); len(ws) != 0 {
+					errs <- fmt.Sprintf("fresh encoder has warnings %+v", ws)
+				}
+				if got := feedAll(enc, input, 5); !slices.Equal(got, want) {
+					errs <- fmt.Sprintf("got %v, want %v", got, want)
+				}
+				pool.Put(enc)
+			}
+		}()
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Fatal(err)
+	}
+}
+
+func TestNewVerifyingEncoder(t *testing.T) {
+	tok := loadTestTokenizer(t)
+	input := []byte("The quick brown fox jumps over the lazy dog while thinking about tokens")
+
+	for _, c := range []struct {
+		opts     []EncoderOption
+		diverges bool
+	}{
+		{nil, false},
+		{[]EncoderOption{WithTailReserve(0)}, true},
+	} {
+		var err error
+		enc := tok.NewVerifyingEncoder(func(d *Divergence) { err = d }, c.opts...)
+		feedAll(enc, input, 3)
+		if (err != nil) != c.diverges {
+			t.Fatalf("%d options: divergence %v, want one: %v", len(c.opts), err, c.diverges)
+		}
+		var d *Divergence
+		if c.diverges && (!errors.As(err, &d) || len(d.Text) == 0) {
+			t.Fatalf("got %v, want a Divergence with context", err)
+		}
+	}
+}
+
+func TestNewLineEncoder(t *testing.T) {
+	tok := loadTestTokenizer(t)
+	le := tok.NewLineEncoder(false)
+
+	lines := le.Push([]byte("{\"a\": 1}\n{\"b\""))
+	lines = append(lines, le.Push([]byte(": 2}\n"))...)
+	if last := le.Flush(); last != nil {
+		t.Fatalf("expected no trailing line, got %v", last)
+	}
+
+	var want [][]int
+	for _, s := range []string{"{\"a\": 1}", "{\"b\": 2}"} {
+		ids, _ := tok.Encode(s)
+		want = append(want, ids)
+	}
+	if !reflect.DeepEqual(lines, want) {
+		t.Fatalf("got %v, want %v", lines, want)
+	}
+}
+
+func TestNewEncoder_AddPrefixSpace(t *testing.T) {
+	plain := loadTestTokenizer(t)
+	tok, err := Load(Files(testVocabPath, testMergesPath), WithAddPrefixSpace(true),
+		WithNormalization(NormalizeNFC))
+	if err != nil {
+		t.Fatalf("load: %v", err)
+	}
+	if tok.Fingerprint() == plain.Fingerprint() {
+		t.Fatalf("the prefix space should change the fingerprint")
+	}
+
+	for _, text := range []string{"Hello world", " Hello world", "́e and more", "\nHi", ""} {
+		want, _ := plain.Encode(" " + text)
+		if strings.HasPrefix(text, " ") || text == "" {
+			want, _ = plain.Encode(text)
+		}
+		if got, _ := tok.Encode(text); !slices.Equal(got, want) {
+			t.Fatalf("Encode(%q): got %v, want %v", text, got, want)
+		}
+		if n := tok.CountTokens([]byte(text)); n != len(want) {
+			t.Fatalf("CountTokens(%q): got %d, want %d", text, n, len(want))
+		}
+
+		for _, chunk := range []int{1, 2, 5} {
+			encoders := map[string]Encoder{"incremental": tok.NewEncoder(), "adaptive": tok.NewAdaptiveEncoder(),
+				"naive": tok.NewNaiveEncoder()}
+			for name, enc := range encoders {
+				// twice, the second stream gets its own prefix space
+				for range 2 {
+					if got := feedAll(enc, []byte(text), chunk); !slices.Equal(got, want) {
+						t.Fatalf("%s, chunk=%d, %q: got %v, want %v", name, chunk, text, got, want)
+					}
+				}
+			}
+		}
+
+		// input continuing a stream, such as TakePending's, gets none
+		cont, _ := plain.Encode(text)
+		if got := feedAll(tok.NewEncoder(WithPrefixSpace(false)), []byte(text), 3); !slices.Equal(got, cont) {
+			t.Fatalf("WithPrefixSpace(false), %q: got %v, want %v", text, got, cont)
+		}
+	}
+
+	// every line is encoded as if alone
+	lines := tok.NewLineEncoder(false)
+	got := lines.Push([]byte("one\n two\nthree\n"))
+	for i, line := range []string{"one", " two", "three"} {
+		if want, _ := tok.Encode(line); !slices.Equal(got[i], want) {
+			t.Fatalf("line %d: got %v, want %v", i, got[i], want)
+		}
+	}
+}
@@ -0,0 +1,312 @@
+package bpetok
+
+import (
+	"io"
+	"io/fs"
+	"log"
+
+	"github.com/bpetok/internal/tokenizer/core"
+)
+
+// Source is where Load reads a tokenizer from.
+type Source = core.Source
+
+// LoadOption configures Load.
+type LoadOption = core.Option
+
+// Normalization selects the Unicode normalization applied to input before it is tokenized.
+type Normalization = core.Normalization
+
+const (
+	NormalizeNone = core.NormalizeNone
+	NormalizeNFC  = core.NormalizeNFC
+	NormalizeNFKC = core.NormalizeNFKC
+)
+
+// PreTokenization selects the regex split applied before merging, see WithPreTokenization.
+type PreTokenization = core.PreTokenization
+
+const (
+	PreTokenizeNone   = core.PreTokenizeNone
+	PreTokenizeGPT2   = core.PreTokenizeGPT2
+	PreTokenizeCL100K = core.PreTokenizeCL100K
+	PreTokenizeO200K  = core.PreTokenizeO200K
+)
+
+// SpecialPolicy decides which special tokens EncodeWithSpecial encodes as their IDs and which fail it,
+// see core.SpecialPolicy.
+type SpecialPolicy = core.SpecialPolicy
+
+// AllSpecial stands for every special token in SpecialPolicy's lists.
+const AllSpecial = core.AllSpecial
+
+// ErrDisallowedSpecial is returned by EncodeWithSpecial for input holding a disallowed special token.
+var ErrDisallowedSpecial = core.ErrDisallowedSpecial
+
+// ErrUnsupportedTokenizerJSON is returned by Load for a tokenizer.json that isn't a byte-level BPE model.
+var ErrUnsupportedTokenizerJSON = core.ErrUnsupportedTokenizerJSON
+
+// ErrUnsupportedSentencePiece is returned by Load for a SentencePiece model that isn't BPE with byte
+// fallback.
+var ErrUnsupportedSentencePiece = core.ErrUnsupportedSentencePiece
+
+// ErrUnsupportedGGUF is returned by Load for a GGUF file whose tokenizer isn't a gpt2 or llama BPE vocab.
+var ErrUnsupportedGGUF = core.ErrUnsupportedGGUF
+
+// ErrCompiledFormat is returned by Load for compiled data that is corrupt, truncated or from an
+// incompatible version.
+var ErrCompiledFormat = core.ErrCompiledFormat
+
+// ErrTruncatedMerges is returned by Load for a merges file that stops making sense part way through, the
+// usual sign of an interrupted download.
+var ErrTruncatedMerges = core.ErrTruncatedMerges
+
+// ErrVocabNotDense is returned by Load for a vocab whose IDs have gaps, see WithVocabHoles.
+var ErrVocabNotDense = core.ErrVocabNotDense
+
+// ErrMalformedMerges is returned by Load for a merges line that isn't two tokens separated by spaces. Only
+// on the last line is it wrapped in ErrTruncatedMerges.
+var ErrMalformedMerges = core.ErrMalformedMerges
+
+// ErrMergeUnknownToken is returned by Load, wrapped in ErrTruncatedMerges, for a merge naming a token the
+// vocab doesn't have.
+var ErrMergeUnknownToken = core.ErrMergeUnknownToken
+
+// ErrDuplicateMerge is returned by Load for a merges file that lists the same pair twice.
+var ErrDuplicateMerge = core.ErrDuplicateMerge
+
+// ErrMemoryBudget is returned by Load when the tokenizer would exceed WithMemoryBudget.
+var ErrMemoryBudget = core.ErrMemoryBudget
+
+// ErrAlgorithmVersion is returned by Load when WithAlgorithmVersion pins a version this build or the
+// compiled file doesn't match.
+var ErrAlgorithmVersion = core.ErrAlgorithmVersion
+
+// AlgorithmVersion identifies this build's encoding behavior. It changes only when the same vocab, merges
+// and options could encode some input to different IDs, so store it next to persisted token IDs.
+const AlgorithmVersion = core.AlgorithmVersion
+
+// Files reads a GPT-2 style vocab.json and merges.txt from disk.
+func Files(vocabPath, mergesPath string) Source {
+	return core.Files(vocabPath, mergesPath)
+}
+
+// Bytes uses in-memory vocab.json and merges.txt contents.
+func Bytes(vocab, merges []byte) Source {
+	return core.Bytes(vocab, merges)
+}
+
+// Readers reads vocab.json and merges.txt from readers, which Load consumes to EOF and doesn't close.
+func Readers(vocab, merges io.Reader) Source {
+	return core.Readers(vocab, merges)
+}
+
+// FS reads vocab.json and merges.txt from fsys, e.g. an embed.FS or a *zip.Reader.
+func FS(fsys fs.FS, vocabPath, mergesPath string) Source {
+	return core.FS(fsys, vocabPath, mergesPath)
+}
+
+// TiktokenFile reads a tiktoken rank file from disk.
+func TiktokenFile(path string) Source {
+	return core.TiktokenFile(path)
+}
+
+// TiktokenBytes uses in-memory tiktoken rank file contents.
+func TiktokenBytes(data []byte) Source {
+	return core.TiktokenBytes(data)
+}
+
+// TokenizerJSONFile reads a HuggingFace tokenizer.json from disk, see TokenizerJSONBytes.
+func TokenizerJSONFile(path string) Source {
+	return core.TokenizerJSONFile(path)
+}
+
+// TokenizerJSONBytes uses an in-memory HuggingFace tokenizer.json holding a byte-level BPE model. Its
+// added_tokens extend the vocab and an NFC or NFKC normalizer is honoured. ByteLevel's use_regex applies
+// PreTokenizeGPT2 unless WithPreTokenization picks another; Split regexes are not applied, see
+// core.TokenizerJSONBytes. Files whose model isn't byte-level fail with ErrUnsupportedTokenizerJSON.
+func TokenizerJSONBytes(data []byte) Source {
+	return core.TokenizerJSONBytes(data)
+}
+
+// HFDir reads the tokenizer files of a Hugging Face model directory on disk, see HFDirFS.
+func HFDir(dir string) Source {
+	return core.HFDir(dir)
+}
+
+// HFDirFS reads a Hugging Face model directory inside fsys: tokenizer.json if present, otherwise vocab.json
+// and merges.txt plus an optional added_tokens.json. special_tokens_map.json, if present, marks its tokens
+// special and names their roles for Tokenizer.SpecialTokenID.
+func HFDirFS(fsys fs.FS, dir string) Source {
+	return core.HFDirFS(fsys, dir)
+}
+
+// SentencePieceFile reads a SentencePiece .model file from disk, see SentencePieceBytes.
+func SentencePieceFile(path string) Source {
+	return core.SentencePieceFile(path)
+}
+
+// SentencePieceBytes uses an in-memory SentencePiece BPE model such as Llama-2's tokenizer.model. Token IDs
+// are the piece IDs and <s>, </s>, <unk> are reported as special tokens. add_dummy_prefix is not applied,
+// prepend a space to get SentencePiece's IDs; see core.SentencePieceBytes for the details.
+func SentencePieceBytes(data []byte) Source {
+	return core.SentencePieceBytes(data)
+}
+
+// GGUFFile reads the tokenizer embedded in a GGUF model file, such as one exported for llama.cpp. Only the
+// metadata is read, not the weights.
+func GGUFFile(path string) Source {
+	return core.GGUFFile(path)
+}
+
+// GGUFBytes reads the tokenizer from GGUF data, which only needs to cover the metadata. gpt2 tokenizers
+// load like vocab.json and merges.txt, llama ones like SentencePieceBytes; see core.GGUFBytes.
+func GGUFBytes(data []byte) Source {
+	return core.GGUFBytes(data)
+}
+
+// Compiled uses the output of Tokenizer.MarshalBinary. Loading it skips parsing and most of the table
+// building, which makes it the fastest way to start up with a large vocab.
+func Compiled(data []byte) Source {
+	return core.Compiled(data)
+}
+
+// CompiledBorrowed is Compiled without copying data: the tokenizer aliases it, so data must not be
+// modified while the tokenizer is in use. Tokenizer.Freeze detaches it.
+func CompiledBorrowed(data []byte) Source {
+	return core.CompiledBorrowed(data)
+}
+
+// CompiledFile reads a file written from Tokenizer.MarshalBinary, see Compiled.
+func CompiledFile(path string) Source {
+	return core.CompiledFile(path)
+}
+
+// CompiledMapped memory-maps a file written from Tokenizer.MarshalBinary, so the vocab takes next to no
+// heap. The mapping is released once the tokenizer and everything built on it are unreachable; slices
+// from TokenBytes must not outlive them. Tokenizer.Freeze copies the vocab out. See core.CompiledMapped.
+func CompiledMapped(path string) Source {
+	return core.CompiledMapped(path)
+}
+
+// WithStrict fails the load on any problem with the vocab or merges, the default. WithStrict(false) loads
+// slightly imperfect vocabs instead: bad or repeated merges are skipped, a truncated merges file keeps its
+// valid prefix and ID gaps load as with WithVocabHoles, all of it logged as warnings.
+func WithStrict(strict bool) LoadOption {
+	return core.WithStrict(strict)
+}
+
+// ByteCodec is the byte-level scheme vocab.json keys and merges are spelled in. Implement it to load a
+// vocab with a scheme of its own.
+type ByteCodec = core.ByteCodec
+
+// GPT2ByteCodec is GPT-2's byte-level scheme, where a space is spelled "Ġ", and the default.
+func GPT2ByteCodec() ByteCodec {
+	return core.GPT2ByteCodec()
+}
+
+// ByteLevelEncode spells token bytes the way GPT-2's vocab.json does, e.g. "Ġhello" for " hello", for tools
+// that write vocab files or show tokens while debugging. Any byte sequence has a spelling.
+func ByteLevelEncode(b []byte) string {
+	return core.ByteLevelEncode(b)
+}
+
+// ByteLevelDecode reverses ByteLevelEncode, reading a vocab.json key back into its bytes.
+func ByteLevelDecode(s string) ([]byte, error) {
+	return core.ByteLevelDecode(s)
+}
+
+// RawByteCodec is for vocabs whose keys are the token text itself, with bytes that can't stand alone as
+// text spelled as "<0x20>" style pieces.
+func RawByteCodec() ByteCodec {
+	return core.RawByteCodec()
+}
+
+// WithByteCodec sets the byte-level scheme of a vocab.json (Files, Bytes, tokenizer.json, gpt2 GGUF);
+// formats that store raw bytes ignore it.
+func WithByteCodec(c ByteCodec) LoadOption {
+	return core.WithByteCodec(c)
+}
+
+// WithSubstringIndex(true) builds the index behind TokensContaining during load, counted against
+// WithMemoryBudget, instead of on the first search.
+func WithSubstringIndex(build bool) LoadOption {
+	return core.WithSubstringIndex(build)
+}
+
+// WithVocabHoles(true) accepts a vocab whose IDs have gaps, such as reserved IDs a fine-tune left unused,
+// instead of failing the load. Encoding never produces a missing ID and Decode rejects one with
+// ErrInvalidTokenID. Added and special tokens may fill the gaps.
+func WithVocabHoles(allow bool) LoadOption {
+	return core.WithVocabHoles(allow)
+}
+
+// WithLogger sends load warnings to l instead of log.Default().
+func WithLogger(l *log.Logger) LoadOption {
+	return core.WithLogger(l)
+}
+
+// WithPreTokenization splits the input with p's regex before merging, in Encode, CountTokens and every
+// encoder, so tokens never cross a split. PreTokenizeGPT2 gives the IDs Hugging Face's GPT-2 tokenizer and
+// tiktoken's gpt2 encoding produce, PreTokenizeCL100K and PreTokenizeO200K those of tiktoken's cl100k_base
+// and o200k_base; without it merges run over the raw input and a token can span a word and the
+// punctuation or space after it. Streaming encoders hold back the text after the last split boundary
+// later input can't move, usually the last word.
+func WithPreTokenization(p PreTokenization) LoadOption {
+	return core.WithPreTokenization(p)
+}
+
+// WithDigitGroup(n) cuts every run of digits into splits of at most n digits on top of the
+// pre-tokenizer's, which WithPreTokenization must set: 1 splits numbers into single digits as Llama and
+// the Digits pre-tokenizer do, 3 gives PreTokenizeGPT2 cl100k's grouping. Larger groups than the regex's
+// own change nothing; n must be between 0 (off) and 255.
+func WithDigitGroup(n int) LoadOption {
+	return core.WithDigitGroup(n)
+}
+
+// WithAddPrefixSpace(true) puts a space in front of input that doesn't start with one, after
+// normalization, so "Hello" encodes as " Hello" does: the add_prefix_space of GPT-2 and RoBERTa style
+// configs, which a tokenizer.json turns on by itself. Encode, CountTokens and encoders from NewEncoder all
+// honor it; a special token at the start of the input doesn't stop it, and Decode keeps the space.
+func WithAddPrefixSpace(on bool) LoadOption {
+	return core.WithAddPrefixSpace(on)
+}
+
+// WithNormalization makes Encode, CountTokens and encoders from NewEncoder normalize their input first,
+// ahead of pre-tokenization and special token matching, as a tokenizer.json normalizer would. Without it,
+// or with NormalizeNone, input is tokenized as the raw bytes it is.
+func WithNormalization(n Normalization) LoadOption {
+	return core.WithNormalization(n)
+}
+
+// WithSpecialTokens registers special tokens. IDs past the end of the vocab extend it and must follow on
+// without gaps.
+func WithSpecialTokens(special map[string]int) LoadOption {
+	return core.WithSpecialTokens(special)
+}
+
+// WithAlgorithmVersion(v) is strict reproducibility mode: the load fails with ErrAlgorithmVersion unless
+// this build encodes as version v and, for a compiled tokenizer, the file was written under v too. Zero,
+// the default, accepts any version.
+func WithAlgorithmVersion(v int) LoadOption {
+	return core.WithAlgorithmVersion(v)
+}
+
+// WithMemoryBudget fails the load with ErrMemoryBudget when the tokenizer's estimated footprint exceeds n
+// bytes.
+func WithMemoryBudget(n int64) LoadOption {
+	return core.WithMemoryBudget(n)
+}
+
+// WithScratchPoolLimits bounds how much encode scratch the tokenizer retains between calls, in place of the
+// process wide limits SetScratchPoolLimits sets.
+func WithScratchPoolLimits(l ScratchPoolLimits) LoadOption {
+	return core.WithScratchPoolLimits(l)
+}
+
+// WithMaxRank leaves every merge ranked above n out, so encoding behaves like a model trained with fewer
+// merges. Encoders with warnings on report a "capped-rank" Warning where a left out merge would have
+// joined two of their output tokens.
+func WithMaxRank(n int) LoadOption {
+	return core.WithMaxRank(n)
+}
@@ -0,0 +1,162 @@
+package bpetok
+
+import "github.com/bpetok/internal/tokenizer/streaming_encoder_incremental"
+
+// EncoderOption configures the encoders the Tokenizer's constructors return: NewEncoder and the ones built
+// on it, NewAdaptiveEncoder, NewNaiveEncoder and NewPretokenEncoder. The last three don't run the
+// incremental merge engine, so they honour WithZeroCopyOutput and WithWarnings and ignore the options that
+// tune that engine.
+type EncoderOption func(*encoderOptions)
+
+// encoderOptions is what EncoderOptions set: the incremental engine's own options, in order, plus the
+// settings every encoder shares.
+type encoderOptions struct {
+	incremental []streaming_encoder_incremental.Option
+	zeroCopy    bool
+	warnings    bool
+}
+
+func newEncoderOptions(opts []EncoderOption) encoderOptions {
+	var o encoderOptions
+	for _, opt := range opts {
+		opt(&o)
+	}
+	return o
+}
+
+// incrementalOption passes opt on to the incremental engine.
+func incrementalOption(opt streaming_encoder_incremental.Option) EncoderOption {
+	return func(o *encoderOptions) { o.incremental = append(o.incremental, opt) }
+}
+
+// HeapKind selects the priority queue the encoder orders merge candidates with.
+type HeapKind = streaming_encoder_incremental.HeapKind
+
+const (
+	// HeapBucket is one bucket per merge rank: constant time, memory proportional to the number of merges.
+	// This is the default.
+	HeapBucket = streaming_encoder_incremental.HeapBucket
+	// HeapBinary is a binary heap: logarithmic time, memory proportional to the pending candidates.
+	HeapBinary = streaming_encoder_incremental.HeapBinary
+)
+
+// WithTailReserve sets how many trailing bytes Feed holds back instead of emitting, raised to
+// Tokenizer.CommitGuard (1 KiB for GPT-2). Once four times the reserve is pending, Feed emits everything
+// up to the last junction in front of it that no merge can join, which keeps the emitted IDs equal to
+// Encode's, so output comes in batches of about 3 KiB; for steadier output use
+// WithCommitPolicy(CommitRankAware), which emits at such junctions without a reserve. 0 instead emits all
+// but the last token on every Feed, trading exactness for latency.
+func WithTailReserve(n int) EncoderOption {
+	return incrementalOption(streaming_encoder_incremental.WithTailReserve(n))
+}
+
+// CommitPolicy picks what Feed emits on top of the tail reserve, see WithCommitPolicy.
+type CommitPolicy = streaming_encoder_incremental.CommitPolicy
+
+const (
+	// CommitReserve emits only what lies in front of the tail reserve. This is the default.
+	CommitReserve = streaming_encoder_incremental.CommitReserve
+	// CommitRankAware also emits everything in front of the last junction no merge can join, which no
+	// later input can change: for GPT-2 the end of most words, so IDs trail the input by about a word.
+	CommitRankAware = streaming_encoder_incremental.CommitRankAware
+)
+
+// WithCommitPolicy picks when Feed emits IDs. The IDs are the same under every policy.
+func WithCommitPolicy(p CommitPolicy) EncoderOption {
+	return incrementalOption(streaming_encoder_incremental.WithCommitPolicy(p))
+}
+
+// WithHeap picks the merge candidate queue.
+func WithHeap(kind HeapKind) EncoderOption {
+	return incrementalOption(streaming_encoder_incremental.WithHeap(kind))
+}
+
+// MergeQueue is a priority queue for merge candidates, see WithMergeQueue and the contract on
+// streaming_encoder_incremental.MergeQueue: lowest Rank first, equal ranks in push order.
+type MergeQueue = streaming_encoder_incremental.MergeQueue
+
+// MergeCandidate is what a MergeQueue holds. Only its Rank matters to the queue.
+type MergeCandidate = streaming_encoder_incremental.MergeCandidate
+
+// WithMergeQueue makes the encoder use q, one per encoder, instead of a queue picked with WithHeap, e.g.
+// to benchmark another kind of priority queue. The IDs don't depend on the queue.
+func WithMergeQueue(q MergeQueue) EncoderOption {
+	return incrementalOption(streaming_encoder_incremental.WithMergeQueue(q))
+}
+
+// WithOnMerge calls fn for every merge the encoder performs while IDs are still held back: the two tokens,
+// the token they merge into and its byte offset in the stream since the last Flush. It is for tracing and
+// for visualizing how a stream converges to its final tokens, and slows the encoder down. Under a
+// pre-tokenizer nothing is reported, see streaming_encoder_incremental.WithOnMerge.
+func WithOnMerge(fn func(left, right, merged, pos int)) EncoderOption {
+	return incrementalOption(streaming_encoder_incremental.WithOnMerge(fn))
+}
+
+// WithOffsets makes a ResultEncoder fill StreamResult.Spans: the [Start, End) byte range each committed ID
+// covers in the stream since the last Flush, for mapping tokens back to the text (after normalization and
+// the prefix space, if the tokenizer has them).
+func WithOffsets(on bool) EncoderOption {
+	return incrementalOption(streaming_encoder_incremental.WithOffsets(on))
+}
+
+// WithZeroCopyOutput makes Feed and Flush return slices backed by a buffer the encoder reuses. They stay
+// valid only until the next call on the same encoder, copy them if you need to keep them. Without it every
+// slice returned is the caller's. Every encoder honours it.
+func WithZeroCopyOutput(on bool) EncoderOption {
+	return func(o *encoderOptions) {
+		o.incremental = append(o.incremental, streaming_encoder_incremental.WithZeroCopyOutput(on))
+		o.zeroCopy = on
+	}
+}
+
+// WithPrefixSpace overrides the tokenizer's WithAddPrefixSpace for one encoder. Turn it off to push input
+// that continues an earlier stream, such as what TakePending handed over.
+func WithPrefixSpace(on bool) EncoderOption {
+	return incrementalOption(streaming_encoder_incremental.WithPrefixSpace(on))
+}
+
+// WithLongRunCommit sets how many bytes of a single uncommitted run (say, a long base64 blob) the encoder
+// buffers before emitting it up to its last junction that no merge can join; the IDs stay Encode's. n <= 0
+// turns that off and lets the run grow until Flush.
+func WithLongRunCommit(n int) EncoderOption {
+	return incrementalOption(streaming_encoder_incremental.WithLongRunCommit(n))
+}
+
+// WithWarnings makes the encoder record what WarningReporter returns. Off, the default, Warnings stays
+// empty and the checks behind it (UTF-8 validation of the input among them) are skipped. Every encoder
+// honours it.
+func WithWarnings(on bool) EncoderOption {
+	return func(o *encoderOptions) {
+		o.incremental = append(o.incremental, streaming_encoder_incremental.WithWarnings(on))
+		o.warnings = on
+	}
+}
+
+// WithMaxPendingBytes caps the input an encoder holds back at n bytes, whatever the input. Past it the
+// encoder emits what it can exactly and, failing that, force-commits its oldest tokens: the IDs may then
+// differ from Encode's, and with WithWarnings on a "forced-commit" Warning says so. n <= 0, the default,
+// sets no cap.
+func WithMaxPendingBytes(n int) EncoderOption {
+	return incrementalOption(streaming_encoder_incremental.WithMaxPendingBytes(n))
+}
+
+// WithMaxChunk makes Feed run a chunk longer than n bytes through the merge loop n bytes at a time (1 MiB
+// is a good cap), bounding the memory and pause one huge chunk costs. The IDs are the same, except that
+// under WithTailReserve(0) chunks are never split. n <= 0, the default, sets no limit.
+func WithMaxChunk(n int) EncoderOption {
+	return incrementalOption(streaming_encoder_incremental.WithMaxChunk(n))
+}
+
+// WithParallelPush sets the chunk size, 1 MiB by default, from which Feed encodes most of the chunk on
+// several CPUs, cutting it where no token can span. The IDs are the same. n <= 0 turns it off.
+func WithParallelPush(n int) EncoderOption {
+	return incrementalOption(streaming_encoder_incremental.WithParallelPush(n))
+}
+
+// WithAllowedSpecial makes the encoder emit the named special tokens as their IDs wherever their text
+// appears in the stream, AllSpecial standing for all of them, the way EncodeWithSpecial does with those
+// allowed and none disallowed. Feed may hold back up to the longest one's length while the text so far
+// could still be the start of one.
+func WithAllowedSpecial(names ...string) EncoderOption {
+	return incrementalOption(streaming_encoder_incremental.WithAllowedSpecial(names...))
+}
@@ -0,0 +1,33 @@
+package bpetok
+
+import "sync"
+
+// EncoderPool recycles encoders for servers that encode one stream per request: Get one when a request
+// starts and Put it back when it ends, and the merge list and buffers it has grown serve a later request
+// instead of being allocated again. It is safe for concurrent use; the encoders themselves are not.
+type EncoderPool struct {
+	pool sync.Pool
+}
+
+// NewEncoderPool returns a pool of encoders from NewEncoder(opts...).
+func (t *Tokenizer) NewEncoderPool(opts ...EncoderOption) *EncoderPool {
+	p := &EncoderPool{}
+	p.pool.New = func() any {
+		return t.NewEncoder(opts...)
+	}
+	return p
+}
+
+// Get returns an encoder ready for a new stream, with no warnings or stats recorded.
+func (p *EncoderPool) Get() Encoder {
+	return p.pool.Get().(Encoder)
+}
+
+// Put resets enc, abandoning whatever stream it is in the middle of, and returns it to the pool. enc must
+// come from p's Get, and neither it nor a slice it returned under WithZeroCopyOutput may be used after.
+func (p *EncoderPool) Put(enc Encoder) {
+	enc.(Resetter).Reset()
+	enc.(WarningReporter).ResetWarnings()
+	enc.(Inspector).ResetStats()
+	p.pool.Put(enc)
+}
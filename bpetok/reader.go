@@ -0,0 +1,67 @@
+package bpetok
+
+import (
+	"errors"
+	"io"
+)
+
+// readChunk is the buffer EncodeReader reads into, io.Copy's size.
+const readChunk = 32 << 10
+
+// EncodeReader encodes everything r yields through an encoder from NewEncoder(opts...), handing the IDs to
+// emit as they are committed and the rest once r reports io.EOF, so the IDs emit sees add up to Encode of
+// the whole stream. The slice passed to emit is only valid during the call (see WithZeroCopyOutput) and is
+// never empty. It returns the first error from r other than io.EOF, or from emit; the stream isn't flushed
+// then.
+func (t *Tokenizer) EncodeReader(r io.Reader, emit func([]int) error, opts ...EncoderOption) error {
+	enc := t.NewEncoder(opts...)
+	buf := make([]byte, readChunk)
+	for {
+		n, err := r.Read(buf)
+		if n > 0 {
+			if ids := enc.Feed(buf[:n]); len(ids) > 0 {
+				if err := emit(ids); err != nil {
+					return err
+				}
+			}
+		}
+		if errors.Is(err, io.EOF) {
+			if ids := enc.Flush(); len(ids) > 0 {
+				return emit(ids)
+			}
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// TokenWriter is the token counterpart of io.Writer, the end of a pipeline CopyTokens feeds: a shard
+// writer, a counter, a network encoder. ids is only valid during the call, implementations that keep the
+// IDs must copy them.
+type TokenWriter interface {
+	WriteTokens(ids []int) error
+}
+
+// TokenWriterFunc lets a plain function be a TokenWriter.
+type TokenWriterFunc func(ids []int) error
+
+// WriteTokens calls f(ids).
+func (f TokenWriterFunc) WriteTokens(ids []int) error {
+	return f(ids)
+}
+
+// CopyTokens is io.Copy for tokens: it encodes src through an encoder from NewEncoder(opts...) and writes
+// the IDs to dst as they are committed, see EncodeReader, without collecting them anywhere in between. It
+// returns the number of IDs written and the first error from src (other than io.EOF) or dst.
+func (t *Tokenizer) CopyTokens(dst TokenWriter, src io.Reader, opts ...EncoderOption) (written int64, err error) {
+	err = t.EncodeReader(src, func(ids []int) error {
+		if err := dst.WriteTokens(ids); err != nil {
+			return err
+		}
+		written += int64(len(ids))
+		return nil
+	}, opts...)
+	return written, err
+}
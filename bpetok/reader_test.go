@@ -0,0 +1,117 @@
+package bpetok
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"reflect"
+	"strings"
+	"testing"
+	"testing/iotest"
+)
+
+func TestEncodeReader_MatchesEncode(t *testing.T) {
+	tok := loadTestTokenizer(t)
+	text := strings.Repeat("Streaming from a reader, one odd chunk at a time. Héllo 🌍! ", 2000)
+	want, _ := tok.Encode(text)
+
+	for name, r := range map[string]io.Reader{
+		"whole":    strings.NewReader(text),
+		"one byte": iotest.OneByteReader(strings.NewReader(text)),
+		"data EOF": iotest.DataErrReader(strings.NewReader(text)),
+	} {
+		var got []int
+		calls := 0
+		err := tok.EncodeReader(r, func(ids []int) error {
+			if len(ids) == 0 {
+				t.Fatalf("%s: emit called with no IDs", name)
+			}
+			calls++
+			got = append(got, ids...)
+			return nil
+		}, WithZeroCopyOutput(true))
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Fatalf("%s: got %d tokens, want %d", name, len(got), len(want))
+		}
+		if calls < 2 {
+			t.Fatalf("%s: everything was emitted at the end", name)
+		}
+	}
+}
+
+func TestEncodeReader_Errors(t *testing.T) {
+	tok := loadTestTokenizer(t)
+	boom := errors.New("boom")
+
+	r := io.MultiReader(strings.NewReader("some text"), iotest.ErrReader(boom))
+	if err := tok.EncodeReader(r, func([]int) error { return nil }); !errors.Is(err, boom) {
+		t.Fatalf("read error: got %v", err)
+	}
+
+	err := tok.EncodeReader(bytes.NewReader([]byte("some text")), func([]int) error { return boom })
+	if !errors.Is(err, boom) {
+		t.Fatalf("emit error: got %v", err)
+	}
+
+	if err := tok.EncodeReader(strings.NewReader(""), func([]int) error {
+		t.Fatalf("emit called for empty input")
+		return nil
+	}); err != nil {
+		t.Fatalf("empty input: %v", err)
+	}
+}
+
+// shardWriter packs IDs into fixed-size shards, the kind of sink a training data pipeline ends in.
+type shardWriter struct {
+	size   int
+	cur    []int
+	shards [][]int
+}
+
+func (w *shardWriter) WriteTokens(ids []int) error {
+	for _, id := range ids {
+		if w.cur = append(w.cur, id); len(w.cur) == w.size {
+			w.shards = append(w.shards, w.cur)
+			w.cur = nil
+		}
+	}
+	return nil
+}
+
+func TestCopyTokens(t *testing.T) {
+	tok := loadTestTokenizer(t)
+	text := strings.Repeat("file → encoder → shard writer, with nothing collected in between. ", 3000)
+	want, _ := tok.Encode(text)
+
+	w := &shardWriter{size: 1000}
+	n, err := tok.CopyTokens(w, strings.NewReader(text), WithZeroCopyOutput(true))
+	if err != nil {
+		t.Fatalf("CopyTokens: %v", err)
+	}
+	if n != int64(len(want)) {
+		t.Fatalf("wrote %d tokens, want %d", n, len(want))
+	}
+	var got []int
+	for _, s := range w.shards {
+		got = append(got, s...)
+	}
+	if got = append(got, w.cur...); !reflect.DeepEqual(got, want) {
+		t.Fatalf("shards don't add up to Encode")
+	}
+
+	// a failing writer stops the copy and the count covers only what it took
+	full := errors.New("disk full")
+	limit := 500
+	n, err = tok.CopyTokens(TokenWriterFunc(func(ids []int) error {
+		if limit -= len(ids); limit < 0 {
+			return full
+		}
+		return nil
+	}), strings.NewReader(text))
+	if !errors.Is(err, full) || n > 500 {
+		t.Fatalf("failing writer: n=%d err=%v", n, err)
+	}
+}
@@ -0,0 +1,126 @@
+package bpetok
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/bpetok/bpetok/download"
+)
+
+// load builds a tokenizer from download.LoadOrDownload's files: vocab.json and merges.txt, or a single
+// tiktoken rank file.
+func load(files [][]byte, opts ...LoadOption) (*Tokenizer, error) {
+	if len(files) == 1 {
+		return Load(TiktokenBytes(files[0]), opts...)
+	}
+	return Load(Bytes(files[0], files[1]), opts...)
+}
+
+// preTokenizers are the regex splits of the well-known encodings, so their tokenizers give tiktoken's IDs.
+// Encodings added with download.Register merge over the raw input.
+var preTokenizers = map[string]PreTokenization{
+	"gpt2":        PreTokenizeGPT2,
+	"r50k_base":   PreTokenizeGPT2,
+	"p50k_base":   PreTokenizeGPT2,
+	"cl100k_base": PreTokenizeCL100K,
+	"o200k_base":  PreTokenizeO200K,
+}
+
+// modelPrefixes maps model names to encodings the way tiktoken's encoding_for_model does, longest prefix
+// first.
+var modelPrefixes = []struct{ prefix, encoding string }{
+	{"gpt-4o", "o200k_base"},
+	{"o1", "o200k_base"},
+	{"o3", "o200k_base"},
+	{"gpt-4", "cl100k_base"},
+	{"gpt-3.5-turbo", "cl100k_base"},
+	{"text-embedding-ada-002", "cl100k_base"},
+	{"text-embedding-3", "cl100k_base"},
+	{"text-davinci-003", "p50k_base"},
+	{"text-davinci-002", "p50k_base"},
+	{"code-davinci", "p50k_base"},
+	{"davinci", "r50k_base"},
+	{"gpt2", "gpt2"},
+}
+
+// registryEntry is one encoding's load, shared by every Get that asks for it while it runs. done is closed
+// once tok or err is set.
+type registryEntry struct {
+	done chan struct{}
+	tok  *Tokenizer
+	err  error
+}
+
+var (
+	// registryMu guards loaded and is never held across a download or a load, so a stalled download only
+	// holds up the Gets waiting for that one encoding
+	registryMu sync.Mutex
+	loaded     = map[string]*registryEntry{}
+
+	// downloader fetches assets, tests swap its transport out to stay off the network.
+	downloader = &download.Client{}
+)
+
+// Get returns the tokenizer for a well-known encoding ("gpt2", "r50k_base", "p50k_base", "cl100k_base",
+// "o200k_base", or anything added with download.Register). Assets come from download.LoadOrDownload, so
+// they're read from $BPETOK_CACHE_DIR/<name> (default: the user cache dir), downloaded there on first use
+// and checked against pinned hashes. The well-known encodings apply their pre-tokenization regex, so IDs
+// match tiktoken's. Tokenizers are loaded once and shared; concurrent Gets for one encoding wait for a
+// single load, and a failed load is retried by the next Get. Downloads time out after
+// download.DefaultTimeout.
+func Get(name string) (*Tokenizer, error) {
+	registryMu.Lock()
+	e, ok := loaded[name]
+	if !ok {
+		e = &registryEntry{done: make(chan struct{})}
+		loaded[name] = e
+	}
+	registryMu.Unlock()
+
+	if ok {
+		<-e.done
+		return e.tok, e.err
+	}
+
+	defer close(e.done)
+	e.tok, e.err = fetch(name)
+	if e.err != nil {
+		registryMu.Lock()
+		if loaded[name] == e {
+			delete(loaded, name)
+		}
+		registryMu.Unlock()
+	}
+	return e.tok, e.err
+}
+
+// fetch downloads and loads one encoding for Get.
+func fetch(name string) (*Tokenizer, error) {
+	files, err := downloader.LoadOrDownload(name)
+	if err != nil {
+		return nil, fmt.Errorf("bpetok: %w", err)
+	}
+
+	tok, err := load(files, WithPreTokenization(EncodingPreTokenization(name)))
+	if err != nil {
+		return nil, fmt.Errorf("bpetok: %s: %w", name, err)
+	}
+	return tok, nil
+}
+
+// EncodingPreTokenization returns the pre-tokenization Get loads an encoding with: the regex split of a
+// well-known encoding, PreTokenizeNone for anything else.
+func EncodingPreTokenization(name string) PreTokenization {
+	return preTokenizers[name]
+}
+
+// ForModel returns the tokenizer a model name uses, e.g. "gpt-4" gives cl100k_base.
+func ForModel(model string) (*Tokenizer, error) {
+	for _, p := range modelPrefixes {
+		if strings.HasPrefix(model, p.prefix) {
+			return Get(p.encoding)
+		}
+	}
+	return nil, fmt.Errorf("bpetok: no known encoding for model %q", model)
+}
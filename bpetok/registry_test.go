@@ -0,0 +1,240 @@
+package bpetok
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/bpetok/bpetok/download"
+)
+
+// roundTripFunc serves requests without a network.
+type roundTripFunc func(*http.Request) (*http.Response, error)
+
+func (f roundTripFunc) RoundTrip(req *http.Request) (*http.Response, error) { return f(req) }
+
+// withRegistry points Get at a fresh cache dir and a fake downloader serving the test assets.
+func withRegistry(t *testing.T) (dir string, fetches *int) {
+	t.Helper()
+
+	dir = t.TempDir()
+	t.Setenv(download.CacheDirEnv, dir)
+
+	gpt2, _ := download.Assets("gpt2")
+	n := 0
+	serve := func(req *http.Request) (*http.Response, error) {
+		n++
+		for _, a := range gpt2 {
+			if a.URL != req.URL.String() {
+				continue
+			}
+			path := testMergesPath
+			if a.Name == "vocab.json" {
+				path = testVocabPath
+			}
+			data, err := os.ReadFile(path)
+			if err != nil {
+				return nil, err
+			}
+			return &http.Response{StatusCode: http.StatusOK, Body: io.NopCloser(bytes.NewReader(data))}, nil
+		}
+		return nil, errors.New("unexpected url " + req.URL.String())
+	}
+
+	oldDownloader := downloader
+	downloader = &download.Client{HTTPClient: &http.Client{Transport: roundTripFunc(serve)}}
+
+	registryMu.Lock()
+	oldLoaded := loaded
+	loaded = map[string]*registryEntry{}
+	registryMu.Unlock()
+
+	t.Cleanup(func() {
+		downloader = oldDownloader
+		registryMu.Lock()
+		loaded = oldLoaded
+		registryMu.Unlock()
+	})
+	return dir, &n
+}
+
+func TestGet_DownloadsOnceThenCaches(t *testing.T) {
+	dir, fetches := withRegistry(t)
+
+	tok, err := Get("gpt2")
+	if err != nil {
+		t.Fatalf("Get: %v", err)
+	}
+	if tok.VocabSize() != 50257 || *fetches != 2 {
+		t.Fatalf("vocab size %d after %d fetches", tok.VocabSize(), *fetches)
+	}
+	if tok.PreTokenization() != PreTokenizeGPT2 {
+		t.Fatalf("pre-tokenization %v, want GPT-2's regex", tok.PreTokenization())
+	}
+	if _, err := os.Stat(filepath.Join(dir, "gpt2", "merges.txt")); err != nil {
+		t.Fatalf("asset not cached: %v", err)
+	}
+
+	again, err := Get("gpt2")
+	if err != nil || again != tok {
+		t.Fatalf("second Get should return the shared tokenizer, got %p, %v", again, err)
+	}
+
+	// a fresh process would read the cache instead of downloading again
+	registryMu.Lock()
+	loaded = map[string]*registryEntry{}
+	registryMu.Unlock()
+	if _, err := Get("gpt2"); err != nil || *fetches != 2 {
+		t.Fatalf("expected a cache hit, got %d fetches, err %v", *fetches, err)
+	}
+}
+
+func TestGet_Concurrent(t *testing.T) {
+	_, fetches := withRegistry(t)
+
+	// a download that never finishes holds up only the Gets for its own encoding
+	cl100k, _ := download.Assets("cl100k_base")
+	started, release := make(chan struct{}), make(chan struct{})
+	serve := downloader.HTTPClient.Transport
+	downloader = &download.Client{HTTPClient: &http.Client{Transport: roundTripFunc(func(req *http.Request) (*http.Response, error) {
+		if req.URL.String() == cl100k[0].URL {
+			close(started)
+			<-release
+			return nil, errors.New("stalled")
+		}
+		return serve.RoundTrip(req)
+	})}}
+	stalled := make(chan error)
+	go func() {
+		_, err := Get("cl100k_base")
+		stalled <- err
+	}()
+	<-started
+
+	// and concurrent Gets for one encoding share a single download
+	toks := make(chan *Tokenizer, 8)
+	for range 8 {
+		go func() {
+			tok, err := Get("gpt2")
+			if err != nil {
+				t.Error(err)
+			}
+			toks <- tok
+		}()
+	}
+	first := <-toks
+	for range 7 {
+		if tok := <-toks; tok != first {
+			t.Fatalf("concurrent Gets returned different tokenizers")
+		}
+	}
+	if first == nil || *fetches != 2 {
+		t.Fatalf("%d fetches for one encoding", *fetches)
+	}
+
+	// a failed load isn't kept, the next Get tries again
+	close(release)
+	if err := <-stalled; err == nil {
+		t.Fatalf("expected the stalled download to fail")
+	}
+	registryMu.Lock()
+	_, kept := loaded["cl100k_base"]
+	registryMu.Unlock()
+	if kept {
+		t.Fatalf("the failed load is still registered")
+	}
+}
+
+func TestGet_RejectsCorruptCache(t *testing.T) {
+	dir, _ := withRegistry(t)
+
+	if err := os.MkdirAll(filepath.Join(dir, "r50k_base"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "r50k_base", "vocab.json"), []byte(`{"a":0}`), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := Get("r50k_base"); err == nil {
+		t.Fatalf("expected a hash mismatch error")
+	}
+}
+
+func TestGet_Unknown(t *testing.T) {
+	withRegistry(t)
+
+	if _, err := Get("nope"); err == nil {
+		t.Fatalf("expected an error for an unknown encoding")
+	}
+}
+
+func TestForModel(t *testing.T) {
+	withRegistry(t)
+
+	// the fake downloader only serves GPT-2, so this fails, but on the o200k_base asset
+	if _, err := ForModel("gpt-4o-mini"); err == nil || !strings.Contains(err.Error(), "o200k_base") {
+		t.Fatalf("gpt-4o-mini should resolve to o200k_base, got %v", err)
+	}
+	if tok, err := ForModel("gpt2-medium"); err != nil || tok.VocabSize() != 50257 {
+		t.Fatalf("gpt2-medium: %v", err)
+	}
+	if _, err := ForModel("llama"); err == nil {
+		t.Fatalf("expected an error for an unknown model")
+	}
+}
+
+func TestGet_TiktokenRankFile(t *testing.T) {
+	dir, _ := withRegistry(t)
+
+	// seed the cache with a rank file built from GPT-2 and pin its hash in place of the real cl100k one
+	gpt2, err := Get("gpt2")
+	if err != nil {
+		t.Fatalf("Get: %v", err)
+	}
+	var buf strings.Builder
+	for id := 0; id < gpt2.VocabSize(); id++ {
+		fmt.Fprintf(&buf, "%s %d\n", base64.StdEncoding.EncodeToString(gpt2.TokenBytes(id)), id)
+	}
+	data := []byte(buf.String())
+	sum := sha256.Sum256(data)
+
+	old, _ := download.Assets("cl100k_base")
+	pinned := slices.Clone(old)
+	pinned[0].SHA256 = hex.EncodeToString(sum[:])
+	download.Register("cl100k_base", pinned)
+	t.Cleanup(func() { download.Register("cl100k_base", old) })
+
+	if err := os.MkdirAll(filepath.Join(dir, "cl100k_base"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "cl100k_base", "cl100k_base.tiktoken"), data, 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	tok, err := Get("cl100k_base")
+	if err != nil {
+		t.Fatalf("Get: %v", err)
+	}
+	if got, _ := tok.Decode(mustEncode(t, tok, "rank files load too")); got != "rank files load too" {
+		t.Fatalf("round trip through a rank file tokenizer gave %q", got)
+	}
+}
+
+func mustEncode(t *testing.T, tok *Tokenizer, s string) []int {
+	t.Helper()
+	ids, err := tok.Encode(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return ids
+}
@@ -0,0 +1,133 @@
+package segment
+
+import (
+	"sort"
+	"unicode/utf8"
+)
+
+// TokenCounter counts the tokens of a piece of text; *bpetok.Tokenizer is one.
+type TokenCounter interface {
+	CountTokens(input []byte) int
+}
+
+// Chunk is one piece of SplitByTokenBudget's output.
+type Chunk struct {
+	Span
+	// Tokens is the exact token count of the chunk's text encoded on its own.
+	Tokens int
+}
+
+// SplitByTokenBudget cuts text into consecutive chunks of at most budget tokens each, counted by tc on
+// the chunk's own text. Whole paragraphs are packed while they fit; a paragraph that doesn't fit in a
+// chunk of its own is packed sentence by sentence under r, and a sentence that doesn't fit alone is cut at
+// the last whitespace (or, failing that, the last character) that keeps it under budget.
+//
+// The chunks cover text exactly. budget must be positive; a single character that encodes to more than
+// budget tokens still gets a chunk of its own.
+func SplitByTokenBudget(tc TokenCounter, text string, budget int, r Rules) []Chunk {
+	if budget <= 0 {
+		panic("segment: token budget must be positive")
+	}
+	s := splitter{tc: tc, text: text, budget: budget, rules: r}
+	s.pack(Paragraphs(text), levelParagraph)
+	s.flush()
+	return s.chunks
+}
+
+const (
+	levelParagraph = iota
+	levelSentence
+	levelHard
+)
+
+type splitter struct {
+	tc     TokenCounter
+	text   string
+	budget int
+	rules  Rules
+
+	cur    Span
+	tokens int
+	chunks []Chunk
+}
+
+// count returns the tokens of text[a:b] and whether they fit the budget.
+func (s *splitter) count(a, b int) (int, bool) {
+	n := s.tc.CountTokens([]byte(s.text[a:b]))
+	return n, n <= s.budget
+}
+
+func (s *splitter) flush() {
+	if s.cur.End > s.cur.Start {
+		s.chunks = append(s.chunks, Chunk{Span: s.cur, Tokens: s.tokens})
+	}
+	s.cur = Span{s.cur.End, s.cur.End}
+	s.tokens = 0
+}
+
+// pack adds contiguous units to the current chunk, starting a new chunk when one doesn't fit and breaking
+// a unit up at the next level when it doesn't fit even on its own.
+func (s *splitter) pack(units []Span, level int) {
+	for _, u := range units {
+		if n, ok := s.count(s.cur.Start, u.End); ok {
+			s.cur.End, s.tokens = u.End, n
+			continue
+		}
+		s.flush()
+		if n, ok := s.count(u.Start, u.End); ok {
+			s.cur.End, s.tokens = u.End, n
+			continue
+		}
+
+		switch level {
+		case levelParagraph:
+			s.pack(offset(s.rules.Sentences(s.text[u.Start:u.End]), u.Start), levelSentence)
+		default:
+			s.hardSplit(u)
+		}
+	}
+}
+
+// hardSplit cuts u into pieces that each fit the budget, flushing all but the last, which stays open so
+// the following units can join it.
+func (s *splitter) hardSplit(u Span) {
+	for start := u.Start; start < u.End; {
+		// the longest prefix that fits, on a character boundary
+		prefix := s.text[start:u.End]
+		end := start + sort.Search(len(prefix), func(i int) bool {
+			_, ok := s.count(start, start+i+1)
+			return !ok
+		})
+		for end > start && end < u.End && !utf8.RuneStart(s.text[end]) {
+			end--
+		}
+		if end == start {
+			// not even one character fits, give it a chunk of its own
+			_, size := utf8.DecodeRuneInString(prefix)
+			end = start + size
+		} else if end < u.End {
+			// prefer to cut after whitespace in the second half of the piece
+			for cut := end; cut > start+(end-start)/2; cut-- {
+				if isSpaceByte(s.text[cut-1]) {
+					end = cut
+					break
+				}
+			}
+		}
+
+		n, _ := s.count(start, end)
+		s.cur, s.tokens = Span{start, end}, n
+		if end < u.End {
+			s.flush()
+		}
+		start = end
+	}
+}
+
+func offset(spans []Span, by int) []Span {
+	for i := range spans {
+		spans[i].Start += by
+		spans[i].End += by
+	}
+	return spans
+}
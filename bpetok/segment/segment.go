@@ -0,0 +1,163 @@
+// Package segment splits text into paragraphs and sentences with simple locale rules and packs them into
+// chunks under a token budget, the usual preprocessing for retrieval (RAG) ingestion. Chunks break at
+// paragraph ends where they can, at sentence ends otherwise, and only cut inside a sentence that alone
+// exceeds the budget.
+//
+// Spans are byte offsets into the input and cover it without gaps or overlap: whitespace after a
+// paragraph or sentence belongs to it, so every span starts at content.
+package segment
+
+import (
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// Span is the [Start, End) byte range of a paragraph, sentence or chunk.
+type Span struct {
+	Start, End int
+}
+
+// Rules are the locale specifics of sentence splitting.
+type Rules struct {
+	// Terminators end a sentence when followed by whitespace or the end of the text, like ".!?".
+	Terminators string
+	// FullWidthTerminators end a sentence even with text right after them, like "。！？" in Chinese and
+	// Japanese, which don't put spaces between sentences.
+	FullWidthTerminators string
+	// Closers may follow a terminator and still belong to its sentence: quotes and brackets.
+	Closers string
+	// Abbreviations are words that don't end a sentence when followed by ".", lower case and without the
+	// final period, e.g. "mr" or "e.g". Single letters (initials) never end a sentence either.
+	Abbreviations []string
+}
+
+// English splits on .!? followed by whitespace and knows common English abbreviations.
+var English = Rules{
+	Terminators: ".!?",
+	Closers:     "\"')]’”»",
+	Abbreviations: []string{
+		"mr", "mrs", "ms", "dr", "prof", "sr", "jr", "st", "vs", "etc", "e.g", "i.e", "cf", "al",
+		"inc", "ltd", "co", "corp", "no", "vol", "fig", "approx", "dept", "est", "u.s", "u.k",
+		"jan", "feb", "mar", "apr", "jun", "jul", "aug", "sep", "sept", "oct", "nov", "dec",
+	},
+}
+
+// CJK adds the full-width terminators of Chinese and Japanese to English.
+var CJK = Rules{
+	Terminators:          English.Terminators,
+	FullWidthTerminators: "。！？",
+	Closers:              English.Closers + "」』）】",
+	Abbreviations:        English.Abbreviations,
+}
+
+// Paragraphs splits text at blank lines. Each paragraph keeps the newlines and whitespace after it.
+func Paragraphs(text string) []Span {
+	var spans []Span
+	start := 0
+	for i := 0; i < len(text); {
+		if text[i] != '\n' {
+			i++
+			continue
+		}
+		// a run of whitespace holding at least two newlines ends the paragraph
+		j, newlines := i, 0
+		for j < len(text) && isSpaceByte(text[j]) {
+			if text[j] == '\n' {
+				newlines++
+			}
+			j++
+		}
+		if newlines >= 2 && j < len(text) {
+			spans = append(spans, Span{start, j})
+			start = j
+		}
+		i = j
+	}
+	if start < len(text) {
+		spans = append(spans, Span{start, len(text)})
+	}
+	return spans
+}
+
+// Sentences splits text into sentences under r. Each sentence keeps the whitespace after it.
+func (r Rules) Sentences(text string) []Span {
+	var spans []Span
+	start := 0
+	for i := 0; i < len(text); {
+		c, size := utf8.DecodeRuneInString(text[i:])
+		fullWidth := strings.ContainsRune(r.FullWidthTerminators, c)
+		if !fullWidth && !strings.ContainsRune(r.Terminators, c) {
+			i += size
+			continue
+		}
+
+		// take the whole run of terminators and closers, "?!" or `."`
+		end := i + size
+		for end < len(text) {
+			c, size := utf8.DecodeRuneInString(text[end:])
+			if !strings.ContainsRune(r.Terminators+r.FullWidthTerminators+r.Closers, c) {
+				break
+			}
+			end += size
+		}
+		next := end
+		for next < len(text) && isSpace(text[next:]) {
+			_, size := utf8.DecodeRuneInString(text[next:])
+			next += size
+		}
+
+		if (fullWidth || next > end || end == len(text)) && r.boundary(text, i, next) {
+			spans = append(spans, Span{start, next})
+			start = next
+		}
+		i = max(end, i+size)
+	}
+	if start < len(text) {
+		spans = append(spans, Span{start, len(text)})
+	}
+	return spans
+}
+
+// boundary rules out the false stops of a period at text[dot]: after an abbreviation or an initial, or
+// before a lower case word. next is where the following sentence would start.
+func (r Rules) boundary(text string, dot, next int) bool {
+	if next < len(text) {
+		c, _ := utf8.DecodeRuneInString(text[next:])
+		if unicode.IsLower(c) {
+			return false
+		}
+	}
+	if text[dot] != '.' {
+		return true
+	}
+
+	w := dot
+	for w > 0 {
+		c, size := utf8.DecodeLastRuneInString(text[:w])
+		if unicode.IsSpace(c) || c == '(' || c == '"' || c == '\'' {
+			break
+		}
+		w -= size
+	}
+	word := strings.ToLower(text[w:dot])
+	if utf8.RuneCountInString(word) == 1 {
+		c, _ := utf8.DecodeRuneInString(word)
+		return !unicode.IsLetter(c)
+	}
+	for _, a := range r.Abbreviations {
+		if word == a {
+			return false
+		}
+	}
+	return true
+}
+
+func isSpaceByte(b byte) bool {
+	return b == ' ' || b == '\t' || b == '\n' || b == '\r' || b == '\f' || b == '\v'
+}
+
+func isSpace(s string) bool {
+	c, _ := utf8.DecodeRuneInString(s)
+	return unicode.IsSpace(c)
+}
@@ -0,0 +1,92 @@
+package segment
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/bpetok/bpetok/vocabs/gpt2"
+)
+
+func texts(text string, spans []Span) []string {
+	var out []string
+	for _, s := range spans {
+		out = append(out, text[s.Start:s.End])
+	}
+	return out
+}
+
+func TestSentences_English(t *testing.T) {
+	text := `Dr. Smith arrived at 3.30 p.m. on Monday. He said "Hello!" Then he left... Was it J. R. R. Tolkien? ` +
+		`Maybe, e.g. the other one. Prices rose 2.5% in the U.S. last year.`
+	want := []string{
+		"Dr. Smith arrived at 3.30 p.m. on Monday. ",
+		`He said "Hello!" `,
+		"Then he left... ",
+		"Was it J. R. R. Tolkien? ",
+		"Maybe, e.g. the other one. ",
+		"Prices rose 2.5% in the U.S. last year.",
+	}
+	if got := texts(text, English.Sentences(text)); !reflect.DeepEqual(got, want) {
+		t.Fatalf("got %q", got)
+	}
+}
+
+func TestSentences_CJK(t *testing.T) {
+	text := "今日は晴れです。明日は雨でしょう！本当に？Yes. It is."
+	want := []string{"今日は晴れです。", "明日は雨でしょう！", "本当に？", "Yes. ", "It is."}
+	if got := texts(text, CJK.Sentences(text)); !reflect.DeepEqual(got, want) {
+		t.Fatalf("got %q", got)
+	}
+	// English rules leave the full-width stops alone
+	if got := English.Sentences(text); len(got) != 2 {
+		t.Fatalf("got %q", texts(text, got))
+	}
+}
+
+func TestParagraphs(t *testing.T) {
+	text := "First para.\nStill first.\n\n  \nSecond para.\n\nThird.\n\n"
+	want := []string{"First para.\nStill first.\n\n  \n", "Second para.\n\n", "Third.\n\n"}
+	if got := texts(text, Paragraphs(text)); !reflect.DeepEqual(got, want) {
+		t.Fatalf("got %q", got)
+	}
+}
+
+func TestSplitByTokenBudget(t *testing.T) {
+	tok := gpt2.MustTokenizer()
+
+	para := strings.Repeat("The quick brown fox jumps over the lazy dog. ", 6) + "\n\n"
+	long := strings.Repeat("supercalifragilistic", 40) + ". "
+	text := para + "A short one. Another short one.\n\n" + long + "The end."
+
+	for _, budget := range []int{5, 20, 64, 1000} {
+		chunks := SplitByTokenBudget(tok, text, budget, English)
+
+		pos := 0
+		for _, c := range chunks {
+			if c.Start != pos || c.End <= c.Start {
+				t.Fatalf("budget %d: chunks don't tile the text at %d: %+v", budget, pos, c)
+			}
+			pos = c.End
+			if n := tok.CountTokens([]byte(text[c.Start:c.End])); n != c.Tokens || n > budget {
+				t.Fatalf("budget %d: chunk %q has %d tokens, reported %d", budget, text[c.Start:c.End], n, c.Tokens)
+			}
+		}
+		if pos != len(text) {
+			t.Fatalf("budget %d: chunks stop at %d of %d", budget, pos, len(text))
+		}
+		if budget == 1000 && len(chunks) != 1 {
+			t.Fatalf("everything fits one chunk, got %d", len(chunks))
+		}
+	}
+
+	// with room for a paragraph but not two, chunks end where paragraphs do
+	budget := tok.CountTokens([]byte(para)) + 2
+	chunks := SplitByTokenBudget(tok, text, budget, English)
+	if got := text[chunks[0].Start:chunks[0].End]; got != para {
+		t.Fatalf("first chunk %q", got)
+	}
+	if got := text[chunks[1].Start:chunks[1].End]; got != "A short one. Another short one.\n\n" {
+		t.Fatalf("second chunk %q", got)
+	}
+}
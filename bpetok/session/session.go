@@ -0,0 +1,113 @@
+// Package session keeps streaming tokenization state outside the process, for gateways that tokenize a
+// conversation across many requests and must not lose the held back tail of a stream to a restart or to
+// the next request landing on another replica.
+//
+// A session's snapshot is the input its encoder holds back (the bytes of the pending tokens, already
+// normalized), not the encoder's internals: feeding those bytes to a fresh encoder continues the stream
+// exactly, so snapshots are small, independent of the encoder engine and readable by any later version.
+package session
+
+import (
+	"errors"
+	"hash/maphash"
+	"slices"
+	"sync"
+
+	"github.com/bpetok/bpetok"
+)
+
+// pendingTaker is implemented by the encoders bpetok.Tokenizer.NewEncoder returns.
+type pendingTaker interface {
+	TakePending() []byte
+}
+
+// Manager runs streaming encoders whose state lives in a Store between calls. Every call restores the
+// session's encoder from its snapshot, pushes the new input and stores what is left pending, so a
+// Manager holds no per-session memory and any number of Managers, in any number of processes, can serve
+// the same Store. Output is the same as one encoder fed the whole stream.
+//
+// Calls for the same session are serialized within a Manager; across Managers the caller must route a
+// session's requests so they don't overlap.
+type Manager struct {
+	tok   *bpetok.Tokenizer
+	store Store
+	opts  []bpetok.EncoderOption
+
+	seed  maphash.Seed
+	locks [64]sync.Mutex
+}
+
+// NewManager returns a Manager that encodes with tok and keeps snapshots in store. opts configure the
+// encoders, as for bpetok.Tokenizer.NewEncoder.
+func NewManager(tok *bpetok.Tokenizer, store Store, opts ...bpetok.EncoderOption) *Manager {
+	return &Manager{tok: tok, store: store, opts: opts, seed: maphash.MakeSeed()}
+}
+
+func (m *Manager) lock(id string) func() {
+	mu := &m.locks[maphash.String(m.seed, id)%uint64(len(m.locks))]
+	mu.Lock()
+	return mu.Unlock
+}
+
+// restore returns the pending input of session id and whether it has been started.
+func (m *Manager) restore(id string) ([]byte, bool, error) {
+	snap, err := m.store.Get(id)
+	if errors.Is(err, ErrNotFound) {
+		return nil, false, nil
+	}
+	return snap, err == nil, err
+}
+
+// encoder returns an encoder for a session. One continuing a started session doesn't add a prefix space,
+// the snapshot already has it (see bpetok.WithAddPrefixSpace).
+func (m *Manager) encoder(started bool) bpetok.Encoder {
+	if !started {
+		return m.tok.NewEncoder(m.opts...)
+	}
+	return m.tok.NewEncoder(append(slices.Clip(m.opts), bpetok.WithPrefixSpace(false))...)
+}
+
+// Push feeds chunk to session id, starting the session if it has no snapshot, and returns the token IDs it
+// commits. An empty chunk doesn't start a session. If storing the new snapshot fails, Push returns the error
+// and no IDs and the session is left as it was, so the chunk can be pushed again.
+func (m *Manager) Push(id string, chunk []byte) ([]int, error) {
+	defer m.lock(id)()
+
+	pending, started, err := m.restore(id)
+	if err != nil || !started && len(chunk) == 0 {
+		return nil, err
+	}
+
+	enc := m.encoder(started)
+	out := enc.Feed(append(pending, chunk...))
+	out = append([]int(nil), out...) // may alias the encoder's buffer in zero-copy mode
+	if err := m.store.Put(id, enc.(pendingTaker).TakePending()); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// Flush ends session id: it returns the IDs of the input still pending and deletes the snapshot. Flushing a
+// session with no snapshot returns no IDs.
+func (m *Manager) Flush(id string) ([]int, error) {
+	defer m.lock(id)()
+
+	pending, started, err := m.restore(id)
+	if err != nil {
+		return nil, err
+	}
+
+	enc := m.encoder(started)
+	out := append([]int(nil), enc.Feed(pending)...)
+	out = append(out, enc.Flush()...)
+	if err := m.store.Delete(id); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// Drop deletes session id without encoding what it holds back, for a stream that was abandoned.
+func (m *Manager) Drop(id string) error {
+	defer m.lock(id)()
+	return m.store.Delete(id)
+}
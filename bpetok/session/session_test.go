@@ -0,0 +1,186 @@
+package session
+
+import (
+	"errors"
+	"os"
+	"slices"
+	"testing"
+
+	"github.com/bpetok/bpetok"
+	"github.com/bpetok/bpetok/vocabs/gpt2"
+)
+
+const text = "The quick brown fox jumps over the lazy dog. 東京タワー!!! aaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaa\n\n  done"
+
+func TestManager_MatchesEncode(t *testing.T) {
+	tok := gpt2.MustTokenizer()
+	want, _ := tok.Encode(text)
+
+	fs, err := NewFileStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, store := range map[string]Store{"memory": NewMemoryStore(), "file": fs} {
+		for _, size := range []int{1, 3, 7, 64} {
+			m := NewManager(tok, store)
+			var got []int
+			for i := 0; i < len(text); i += size {
+				ids, err := m.Push("s", []byte(text[i:min(i+size, len(text))]))
+				if err != nil {
+					t.Fatal(err)
+				}
+				got = append(got, ids...)
+			}
+			ids, err := m.Flush("s")
+			if err != nil {
+				t.Fatal(err)
+			}
+			got = append(got, ids...)
+
+			if !slices.Equal(got, want) {
+				t.Errorf("%s, chunk %d: got %v, want %v", name, size, got, want)
+			}
+			if _, err := store.Get("s"); !errors.Is(err, ErrNotFound) {
+				t.Errorf("%s: Flush left a snapshot behind: %v", name, err)
+			}
+		}
+	}
+}
+
+func TestManager_SurvivesRestart(t *testing.T) {
+	tok := gpt2.MustTokenizer()
+	want, _ := tok.Encode(text)
+	dir := t.TempDir()
+
+	// every chunk goes through a new Manager over a new FileStore, as if the process restarted in between
+	var got []int
+	for i := 0; i < len(text); i += 5 {
+		store, err := NewFileStore(dir)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ids, err := NewManager(tok, store).Push("conversation/1", []byte(text[i:min(i+5, len(text))]))
+		if err != nil {
+			t.Fatal(err)
+		}
+		got = append(got, ids...)
+	}
+	store, _ := NewFileStore(dir)
+	ids, err := NewManager(tok, store).Flush("conversation/1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	got = append(got, ids...)
+
+	if !slices.Equal(got, want) {
+		t.Fatalf("got %v, want %v", got, want)
+	}
+	if entries, _ := os.ReadDir(dir); len(entries) != 0 {
+		t.Fatalf("store dir not empty after Flush: %v", entries)
+	}
+}
+
+func TestManager_SessionsAreIndependent(t *testing.T) {
+	tok := gpt2.MustTokenizer()
+	store := NewMemoryStore()
+	m := NewManager(tok, store)
+
+	a, b := "hello world, hello", "東京 is big"
+	var gotA, gotB []int
+	for i := range max(len(a), len(b)) {
+		if i < len(a) {
+			ids, _ := m.Push("a", []byte{a[i]})
+			gotA = append(gotA, ids...)
+		}
+		if i < len(b) {
+			ids, _ := m.Push("b", []byte{b[i]})
+			gotB = append(gotB, ids...)
+		}
+	}
+	if store.Len() != 2 {
+		t.Fatalf("store holds %d sessions, want 2", store.Len())
+	}
+	if err := m.Drop("b"); err != nil {
+		t.Fatal(err)
+	}
+	ids, _ := m.Flush("a")
+	gotA = append(gotA, ids...)
+
+	if want, _ := tok.Encode(a); !slices.Equal(gotA, want) {
+		t.Errorf("a: got %v, want %v", gotA, want)
+	}
+	if store.Len() != 0 {
+		t.Errorf("store holds %d sessions after Flush and Drop, want 0", store.Len())
+	}
+}
+
+// failingStore fails every Put.
+type failingStore struct{ *MemoryStore }
+
+func (failingStore) Put(string, []byte) error { return errors.New("disk full") }
+
+func TestManager_FailedPutKeepsSession(t *testing.T) {
+	tok := gpt2.MustTokenizer()
+	mem := NewMemoryStore()
+	m := NewManager(tok, mem)
+	if _, err := m.Push("s", []byte("hello wor")); err != nil {
+		t.Fatal(err)
+	}
+	before, _ := mem.Get("s")
+
+	if ids, err := NewManager(tok, failingStore{mem}).Push("s", []byte("ld and more")); err == nil || ids != nil {
+		t.Fatalf("Push with a failing store: got %v, %v", ids, err)
+	}
+	if after, _ := mem.Get("s"); string(after) != string(before) {
+		t.Fatalf("snapshot changed by a failed Push: %q -> %q", before, after)
+	}
+}
+
+func TestStores_NotFound(t *testing.T) {
+	fs, err := NewFileStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, store := range map[string]Store{"memory": NewMemoryStore(), "file": fs} {
+		if _, err := store.Get("missing"); !errors.Is(err, ErrNotFound) {
+			t.Errorf("%s: Get(missing) = %v, want ErrNotFound", name, err)
+		}
+		if err := store.Delete("missing"); err != nil {
+			t.Errorf("%s: Delete(missing) = %v", name, err)
+		}
+		snap := []byte("pending")
+		if err := store.Put("id", snap); err != nil {
+			t.Fatal(err)
+		}
+		snap[0] = 'X'
+		if got, err := store.Get("id"); err != nil || string(got) != "pending" {
+			t.Errorf("%s: Get after Put = %q, %v", name, got, err)
+		}
+	}
+}
+
+func TestManager_AddPrefixSpace(t *testing.T) {
+	tok, err := bpetok.Load(bpetok.FS(gpt2.Files(), "vocab.json", "merges.txt"), bpetok.WithAddPrefixSpace(true))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want, _ := tok.Encode(text)
+
+	// only the start of the stream gets the space, not what each restored encoder is fed
+	m := NewManager(tok, NewMemoryStore())
+	var got []int
+	for i := 0; i < len(text); i += 4 {
+		ids, err := m.Push("s", []byte(text[i:min(i+4, len(text))]))
+		if err != nil {
+			t.Fatal(err)
+		}
+		got = append(got, ids...)
+	}
+	ids, err := m.Flush("s")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got = append(got, ids...); !slices.Equal(got, want) {
+		t.Fatalf("got %v, want %v", got, want)
+	}
+}
@@ -0,0 +1,126 @@
+package session
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// ErrNotFound is returned by Store.Get for a session that has no snapshot.
+var ErrNotFound = errors.New("session: not found")
+
+// Store keeps encoder snapshots by session ID. Implementations must be safe for concurrent use; the
+// Manager never touches one session from two goroutines at once, but does touch different ones.
+type Store interface {
+	// Get returns the snapshot stored for id, or ErrNotFound.
+	Get(id string) ([]byte, error)
+	// Put replaces the snapshot for id. The store must not keep snapshot, which the caller may reuse.
+	Put(id string, snapshot []byte) error
+	// Delete removes the snapshot for id. Deleting a missing session is not an error.
+	Delete(id string) error
+}
+
+// MemoryStore is a Store in a map, for tests and single-process deployments that only want the Manager's
+// bookkeeping. Its snapshots don't survive a restart.
+type MemoryStore struct {
+	mu    sync.Mutex
+	snaps map[string][]byte
+}
+
+// NewMemoryStore returns an empty MemoryStore.
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{snaps: make(map[string][]byte)}
+}
+
+func (s *MemoryStore) Get(id string) ([]byte, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	snap, ok := s.snaps[id]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return append([]byte(nil), snap...), nil
+}
+
+func (s *MemoryStore) Put(id string, snapshot []byte) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.snaps[id] = append([]byte(nil), snapshot...)
+	return nil
+}
+
+func (s *MemoryStore) Delete(id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.snaps, id)
+	return nil
+}
+
+// Len returns the number of sessions stored.
+func (s *MemoryStore) Len() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.snaps)
+}
+
+// FileStore is a Store with one file per session in a directory. Session IDs are hashed into the file
+// names, so any string is a valid ID. Put writes a temporary file and renames it over the old snapshot, so
+// a crash leaves either the old snapshot or the new one, never a torn one.
+type FileStore struct {
+	dir string
+}
+
+// NewFileStore returns a FileStore in dir, creating it if needed.
+func NewFileStore(dir string) (*FileStore, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, fmt.Errorf("session: create store dir: %w", err)
+	}
+	return &FileStore{dir: dir}, nil
+}
+
+func (s *FileStore) path(id string) string {
+	sum := sha256.Sum256([]byte(id))
+	return filepath.Join(s.dir, hex.EncodeToString(sum[:])+".session")
+}
+
+func (s *FileStore) Get(id string) ([]byte, error) {
+	snap, err := os.ReadFile(s.path(id))
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("session: read snapshot: %w", err)
+	}
+	return snap, nil
+}
+
+func (s *FileStore) Put(id string, snapshot []byte) error {
+	f, err := os.CreateTemp(s.dir, ".tmp-*")
+	if err != nil {
+		return fmt.Errorf("session: write snapshot: %w", err)
+	}
+	_, err = f.Write(snapshot)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(f.Name(), s.path(id))
+	}
+	if err != nil {
+		os.Remove(f.Name())
+		return fmt.Errorf("session: write snapshot: %w", err)
+	}
+	return nil
+}
+
+func (s *FileStore) Delete(id string) error {
+	if err := os.Remove(s.path(id)); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return fmt.Errorf("session: delete snapshot: %w", err)
+	}
+	return nil
+}
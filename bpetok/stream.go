@@ -0,0 +1,74 @@
+package bpetok
+
+import (
+	"context"
+	"errors"
+)
+
+// ErrStreamClosed is returned by TokenStream.Push after Close.
+var ErrStreamClosed = errors.New("bpetok: token stream closed")
+
+// TokenStream delivers the IDs of a stream on a bounded channel for goroutine pipelines: a producer Pushes
+// chunks and a consumer ranges over Tokens. Push blocks while the channel is full, so a slow consumer
+// holds the producer back instead of the IDs piling up, and gives up when the context is done. Push and
+// Close belong to the producer's goroutine; the producer must Close the stream, which ends the consumer's
+// range.
+type TokenStream struct {
+	ctx    context.Context
+	enc    Encoder
+	out    chan []int
+	closed bool
+}
+
+// NewTokenStream returns a stream encoding through NewEncoder(opts...) whose channel holds up to buffer
+// slices of IDs (0 for an unbuffered one). The slices sent are the consumer's to keep, whatever
+// WithZeroCopyOutput says.
+func (t *Tokenizer) NewTokenStream(ctx context.Context, buffer int, opts ...EncoderOption) *TokenStream {
+	opts = append(opts[:len(opts):len(opts)], WithZeroCopyOutput(false))
+	return &TokenStream{ctx: ctx, enc: t.NewEncoder(opts...), out: make(chan []int, max(buffer, 0))}
+}
+
+// Tokens returns the channel the IDs arrive on, never an empty slice. It is closed by Close.
+func (s *TokenStream) Tokens() <-chan []int {
+	return s.out
+}
+
+// Push encodes chunk and sends the IDs it commits, waiting for room on the channel. It returns the
+// context's error if it is done first, in which case those IDs are lost.
+func (s *TokenStream) Push(chunk []byte) error {
+	if s.closed {
+		return ErrStreamClosed
+	}
+	if err := s.ctx.Err(); err != nil {
+		return err
+	}
+	return s.send(s.enc.Feed(chunk))
+}
+
+// Close flushes the encoder, sends the last IDs and closes the channel. It returns the context's error if
+// it is done before they could be sent; the channel is closed either way. Later calls return
+// ErrStreamClosed.
+func (s *TokenStream) Close() error {
+	if s.closed {
+		return ErrStreamClosed
+	}
+	s.closed = true
+	defer close(s.out)
+
+	if err := s.ctx.Err(); err != nil {
+		return err
+	}
+	return s.send(s.enc.Flush())
+}
+
+func (s *TokenStream) send(ids []int) error {
+	if len(ids) == 0 {
+		return nil
+	}
+	select {
+	case s.out <- ids:
+		return nil
+	case <-s.ctx.Done():
+		return s.ctx.Err()
+	}
+}
@@ -0,0 +1,86 @@
+package bpetok
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestTokenStream_MatchesEncode(t *testing.T) {
+	tok := loadTestTokenizer(t)
+	text := strings.Repeat("producer and consumer on two goroutines, the channel in between. ", 400)
+	want, _ := tok.Encode(text)
+
+	s := tok.NewTokenStream(context.Background(), 2, WithZeroCopyOutput(true))
+	done := make(chan []int)
+	go func() {
+		var got []int
+		for ids := range s.Tokens() {
+			got = append(got, ids...)
+		}
+		done <- got
+	}()
+
+	for pos := 0; pos < len(text); pos += 100 {
+		if err := s.Push([]byte(text[pos:min(pos+100, len(text))])); err != nil {
+			t.Fatalf("Push: %v", err)
+		}
+	}
+	if err := s.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+	if got := <-done; !reflect.DeepEqual(got, want) {
+		t.Fatalf("got %d tokens, want %d", len(got), len(want))
+	}
+
+	if err := s.Push([]byte("late")); !errors.Is(err, ErrStreamClosed) {
+		t.Fatalf("Push after Close: got %v", err)
+	}
+	if err := s.Close(); !errors.Is(err, ErrStreamClosed) {
+		t.Fatalf("second Close: got %v", err)
+	}
+}
+
+func TestTokenStream_Backpressure(t *testing.T) {
+	tok := loadTestTokenizer(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	s := tok.NewTokenStream(ctx, 1)
+
+	// nobody reads: the first batch fills the channel, the next Push blocks until cancel
+	chunk := []byte(strings.Repeat("no one is reading this stream. ", 1000))
+	pushed := make(chan error)
+	go func() {
+		for {
+			if err := s.Push(chunk); err != nil {
+				pushed <- err
+				return
+			}
+		}
+	}()
+
+	for deadline := time.Now().Add(5 * time.Second); len(s.Tokens()) < 1; time.Sleep(time.Millisecond) {
+		if time.Now().After(deadline) {
+			t.Fatalf("nothing was sent")
+		}
+	}
+	select {
+	case err := <-pushed:
+		t.Fatalf("Push returned %v with a full channel", err)
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	cancel()
+	if err := <-pushed; !errors.Is(err, context.Canceled) {
+		t.Fatalf("blocked Push: got %v, want context.Canceled", err)
+	}
+	if err := s.Close(); !errors.Is(err, context.Canceled) {
+		t.Fatalf("Close: got %v", err)
+	}
+	<-s.Tokens()
+	if _, ok := <-s.Tokens(); ok {
+		t.Fatalf("channel should be closed")
+	}
+}
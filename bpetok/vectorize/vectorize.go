@@ -0,0 +1,160 @@
+// Package vectorize turns encoded documents into sparse feature vectors for classical ML: bag-of-tokens
+// counts or presence over the vocab, or hashed features in a fixed, smaller space. The input is the token
+// IDs a bpetok.Tokenizer produced, the output is ready for a linear model or an embedding-bag lookup.
+package vectorize
+
+import (
+	"fmt"
+	"math"
+	"slices"
+
+	"github.com/bpetok/bpetok"
+)
+
+// Vector is a sparse vector of dimension Dim. Indices are strictly ascending and Values[i] belongs to
+// Indices[i]; positions not listed are zero.
+type Vector struct {
+	Dim     int
+	Indices []int
+	Values  []float64
+}
+
+// Counts returns the bag-of-tokens vector of ids over a vocab of dim tokens: index id holds how often id
+// occurs. dim must be positive, and an ID outside [0, dim), which means the IDs came from a different vocab,
+// fails with an error wrapping bpetok.ErrInvalidTokenID.
+func Counts(ids []int, dim int) (Vector, error) {
+	return bag(ids, dim, false)
+}
+
+// OneHot is Counts with every present token at 1, the multi-hot presence vector.
+func OneHot(ids []int, dim int) (Vector, error) {
+	return bag(ids, dim, true)
+}
+
+func bag(ids []int, dim int, binary bool) (Vector, error) {
+	if dim <= 0 {
+		return Vector{}, fmt.Errorf("vectorize: dimension %d must be positive", dim)
+	}
+	for i, id := range ids {
+		if id < 0 || id >= dim {
+			return Vector{}, fmt.Errorf("vectorize: %w: %d at position %d, want [0, %d)", bpetok.ErrInvalidTokenID, id, i, dim)
+		}
+	}
+
+	sorted := slices.Clone(ids)
+	slices.Sort(sorted)
+
+	v := Vector{Dim: dim}
+	for i := 0; i < len(sorted); {
+		j := i + 1
+		for j < len(sorted) && sorted[j] == sorted[i] {
+			j++
+		}
+		n := float64(j - i)
+		if binary {
+			n = 1
+		}
+		v.Indices = append(v.Indices, sorted[i])
+		v.Values = append(v.Values, n)
+		i = j
+	}
+	return v, nil
+}
+
+// Hashed folds ids into dim buckets by hashing each ID, the hashing trick: the dimension no longer depends
+// on the vocab, at the price of unrelated tokens sharing a bucket. Each ID also hashes to a sign, so
+// colliding tokens tend to cancel instead of piling up, as scikit-learn's HashingVectorizer does with
+// alternate_sign. Buckets that cancel to exactly zero are left out. dim must be positive.
+func Hashed(ids []int, dim int) Vector {
+	if dim <= 0 {
+		panic("vectorize: hashed dimension must be positive")
+	}
+
+	type feature struct {
+		index int
+		value float64
+	}
+	fs := make([]feature, len(ids))
+	for i, id := range ids {
+		h := mix(uint64(id))
+		sign := 1.0
+		if h>>63 != 0 {
+			sign = -1
+		}
+		fs[i] = feature{index: int((h & math.MaxInt64) % uint64(dim)), value: sign}
+	}
+	slices.SortFunc(fs, func(a, b feature) int { return a.index - b.index })
+
+	v := Vector{Dim: dim}
+	for i := 0; i < len(fs); {
+		sum := 0.0
+		j := i
+		for ; j < len(fs) && fs[j].index == fs[i].index; j++ {
+			sum += fs[j].value
+		}
+		if sum != 0 {
+			v.Indices = append(v.Indices, fs[i].index)
+			v.Values = append(v.Values, sum)
+		}
+		i = j
+	}
+	return v
+}
+
+// mix is the splitmix64 finalizer, enough to spread consecutive IDs across buckets.
+func mix(x uint64) uint64 {
+	x += 0x9e3779b97f4a7c15
+	x = (x ^ (x >> 30)) * 0xbf58476d1ce4e5b9
+	x = (x ^ (x >> 27)) * 0x94d049bb133111eb
+	return x ^ (x >> 31)
+}
+
+// Dense expands v into a slice of length Dim.
+func (v Vector) Dense() []float64 {
+	out := make([]float64, v.Dim)
+	for i, idx := range v.Indices {
+		out[idx] = v.Values[i]
+	}
+	return out
+}
+
+// Norm returns the Euclidean length of v.
+func (v Vector) Norm() float64 {
+	sum := 0.0
+	for _, x := range v.Values {
+		sum += x * x
+	}
+	return math.Sqrt(sum)
+}
+
+// Normalized returns v scaled to unit length, or v itself if it is all zero. The values are copied.
+func (v Vector) Normalized() Vector {
+	n := v.Norm()
+	if n == 0 {
+		return v
+	}
+	out := Vector{Dim: v.Dim, Indices: v.Indices, Values: make([]float64, len(v.Values))}
+	for i, x := range v.Values {
+		out.Values[i] = x / n
+	}
+	return out
+}
+
+// Dot returns the inner product of two vectors of the same dimension, the cosine similarity when both
+// are normalized.
+func (v Vector) Dot(w Vector) float64 {
+	sum := 0.0
+	for i, j := 0, 0; i < len(v.Indices) && j < len(w.Indices); {
+		switch {
+		case v.Indices[i] < w.Indices[j]:
+			i++
+		case v.Indices[i] > w.Indices[j]:
+			j++
+		default:
+			sum += v.Values[i] * w.Values[j]
+			i++
+			j++
+		}
+	}
+	return sum
+}
@@ -0,0 +1,101 @@
+package vectorize
+
+import (
+	"errors"
+	"math"
+	"reflect"
+	"testing"
+
+	"github.com/bpetok/bpetok"
+	"github.com/bpetok/bpetok/vocabs/gpt2"
+)
+
+func TestCounts(t *testing.T) {
+	v, err := Counts([]int{7, 3, 7, 0, 7}, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := Vector{Dim: 10, Indices: []int{0, 3, 7}, Values: []float64{1, 1, 3}}
+	if !reflect.DeepEqual(v, want) {
+		t.Fatalf("got %+v, want %+v", v, want)
+	}
+	if d := v.Dense(); len(d) != 10 || d[7] != 3 || d[1] != 0 {
+		t.Fatalf("dense %v", d)
+	}
+
+	if o, err := OneHot([]int{7, 3, 7}, 10); err != nil || !reflect.DeepEqual(o.Values, []float64{1, 1}) {
+		t.Fatalf("one-hot %+v, %v", o, err)
+	}
+	if e, err := Counts(nil, 10); err != nil || len(e.Indices) != 0 || e.Dim != 10 {
+		t.Fatalf("empty %+v, %v", e, err)
+	}
+}
+
+func TestCounts_Invalid(t *testing.T) {
+	for _, ids := range [][]int{{3, 10}, {-1, 3}} {
+		if _, err := Counts(ids, 10); !errors.Is(err, bpetok.ErrInvalidTokenID) {
+			t.Fatalf("%v: expected ErrInvalidTokenID, got %v", ids, err)
+		}
+		if _, err := OneHot(ids, 10); !errors.Is(err, bpetok.ErrInvalidTokenID) {
+			t.Fatalf("%v: one-hot expected ErrInvalidTokenID, got %v", ids, err)
+		}
+	}
+	for _, dim := range []int{0, -5} {
+		if _, err := Counts(nil, dim); err == nil {
+			t.Fatalf("dimension %d accepted", dim)
+		}
+	}
+}
+
+func TestHashed(t *testing.T) {
+	ids := make([]int, 1000)
+	for i := range ids {
+		ids[i] = i * 13
+	}
+	v := Hashed(ids, 64)
+	if v.Dim != 64 || len(v.Indices) == 0 {
+		t.Fatalf("unexpected %+v", v)
+	}
+	for i := 1; i < len(v.Indices); i++ {
+		if v.Indices[i] <= v.Indices[i-1] {
+			t.Fatalf("indices not strictly ascending: %v", v.Indices)
+		}
+	}
+	for _, x := range v.Values {
+		if x == 0 {
+			t.Fatalf("zero bucket kept")
+		}
+	}
+
+	// the same ID always lands in the same bucket with the same sign
+	one := Hashed([]int{42}, 64)
+	two := Hashed([]int{42, 42}, 64)
+	if two.Indices[0] != one.Indices[0] || two.Values[0] != 2*one.Values[0] {
+		t.Fatalf("%+v vs %+v", one, two)
+	}
+}
+
+func TestVector_Similarity(t *testing.T) {
+	tok := gpt2.MustTokenizer()
+	encode := func(s string) Vector {
+		ids, err := tok.Encode(s)
+		if err != nil {
+			t.Fatal(err)
+		}
+		v, err := Counts(ids, tok.VocabSize())
+		if err != nil {
+			t.Fatal(err)
+		}
+		return v.Normalized()
+	}
+
+	a := encode("the quick brown fox jumps over the lazy dog")
+	b := encode("the lazy dog sleeps while the quick brown fox jumps")
+	c := encode("quarterly revenue grew by twelve percent")
+	if math.Abs(a.Norm()-1) > 1e-12 || math.Abs(a.Dot(a)-1) > 1e-12 {
+		t.Fatalf("not normalized: %v", a.Norm())
+	}
+	if ab, ac := a.Dot(b), a.Dot(c); ab <= ac {
+		t.Fatalf("similar texts scored %v, unrelated %v", ab, ac)
+	}
+}
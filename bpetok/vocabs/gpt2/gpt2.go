@@ -0,0 +1,39 @@
+// Package gpt2 embeds the GPT-2 (r50k_base) vocab.json and merges.txt, so a binary can tokenize with no
+// files next to it and no network fetch. Importing it adds about 1.5 MB to the binary; programs that can
+// reach a cache directory should prefer bpetok.Get("gpt2").
+package gpt2
+
+import (
+	"embed"
+	"io/fs"
+	"sync"
+
+	"github.com/bpetok/bpetok"
+)
+
+//go:embed vocab.json merges.txt
+var files embed.FS
+
+var load = sync.OnceValues(func() (*bpetok.Tokenizer, error) {
+	return bpetok.LoadTokenizerFS(files, "vocab.json", "merges.txt")
+})
+
+// Tokenizer returns the GPT-2 tokenizer. It is built on first use and shared afterwards, which is fine
+// since tokenizers are safe for concurrent use.
+func Tokenizer() (*bpetok.Tokenizer, error) {
+	return load()
+}
+
+// MustTokenizer is Tokenizer for package level variables, it panics if the embedded assets don't load.
+func MustTokenizer() *bpetok.Tokenizer {
+	tok, err := load()
+	if err != nil {
+		panic("gpt2: " + err.Error())
+	}
+	return tok
+}
+
+// Files exposes the embedded vocab.json and merges.txt, e.g. to pass options through bpetok.Load(bpetok.FS(...)).
+func Files() fs.FS {
+	return files
+}
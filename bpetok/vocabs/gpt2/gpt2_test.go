@@ -0,0 +1,51 @@
+package gpt2
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"io/fs"
+	"reflect"
+	"testing"
+
+	"github.com/bpetok/bpetok"
+)
+
+func TestTokenizer(t *testing.T) {
+	tok, err := Tokenizer()
+	if err != nil {
+		t.Fatalf("load: %v", err)
+	}
+	if tok.VocabSize() != 50257 {
+		t.Fatalf("vocab size %d", tok.VocabSize())
+	}
+
+	ids, _ := tok.Encode("Hello world")
+	if want := []int{15496, 995}; !reflect.DeepEqual(ids, want) {
+		t.Fatalf("got %v, want %v", ids, want)
+	}
+
+	if again := MustTokenizer(); again != tok {
+		t.Fatalf("expected the tokenizer to be shared")
+	}
+}
+
+// The embedded files must be the ones bpetok.Get("gpt2") pins, so both routes give the same tokenizer.
+func TestFilesMatchRegistryHashes(t *testing.T) {
+	for name, want := range map[string]string{
+		"vocab.json": "196139668be63f3b5d6574427317ae82f612a97c5d1cdaf36ed2256dbf636783",
+		"merges.txt": "1ce1664773c50f3e0cc8842619a93edc4624525b728b188a9e0be33b7726adc5",
+	} {
+		data, err := fs.ReadFile(Files(), name)
+		if err != nil {
+			t.Fatalf("read %s: %v", name, err)
+		}
+		sum := sha256.Sum256(data)
+		if got := hex.EncodeToString(sum[:]); got != want {
+			t.Fatalf("%s: sha256 %s, want %s", name, got, want)
+		}
+	}
+
+	if _, err := bpetok.Load(bpetok.FS(Files(), "vocab.json", "merges.txt"), bpetok.WithMemoryBudget(1<<30)); err != nil {
+		t.Fatalf("load through Load: %v", err)
+	}
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/bpetok/bpetok"
+	"github.com/bpetok/bpetok/download"
+	"github.com/bpetok/bpetok/vocabs/gpt2"
+	"github.com/bpetok/internal/tokenizer/core"
+)
+
+// errMismatch is returned by a command that has already reported why it fails, it only sets the exit code.
+var errMismatch = errors.New("mismatch")
+
+const usage = `usage: bpetok <command> [flags]
+
+commands:
+  replay    re-run a recorded streaming session and report emission differences
+  tiebreak  encode a corpus under each merge tie-break rule and report divergences
+`
+
+func main() {
+	os.Exit(run(os.Args[1:]))
+}
+
+// run executes one command and returns the process exit code. Commands return instead of exiting so their
+// deferred cleanup runs.
+func run(args []string) int {
+	if len(args) < 1 {
+		fmt.Fprint(os.Stderr, usage)
+		return 2
+	}
+
+	var err error
+	switch args[0] {
+	case "replay":
+		err = runReplay(args[1:])
+	case "tiebreak":
+		err = runTieBreak(args[1:])
+	case "-h", "-help", "--help", "help":
+		fmt.Print(usage)
+		return 0
+	default:
+		fmt.Fprintf(os.Stderr, "unknown command %q\n\n%s", args[0], usage)
+		return 2
+	}
+
+	switch {
+	case errors.Is(err, errMismatch):
+		return 1
+	case err != nil:
+		fmt.Fprintf(os.Stderr, "bpetok %s: %v\n", args[0], err)
+		return 1
+	}
+	return 0
+}
+
+// loadTokenizer loads vocab and merges when they are given, otherwise model with the pre-tokenization
+// bpetok.Get gives it. gpt2 comes from the files embedded in bpetok/vocabs/gpt2, other models are downloaded
+// into the cache.
+func loadTokenizer(model, vocab, merges string) (*core.Tokenizer, error) {
+	var (
+		src  core.Source
+		opts []core.Option
+	)
+	switch {
+	case vocab != "" || merges != "":
+		if vocab == "" || merges == "" {
+			return nil, fmt.Errorf("-vocab and -merges must be given together")
+		}
+		src = core.Files(vocab, merges)
+	case model == "gpt2":
+		src = core.FS(gpt2.Files(), "vocab.json", "merges.txt")
+		opts = append(opts, core.WithPreTokenization(bpetok.EncodingPreTokenization(model)))
+	default:
+		files, err := download.LoadOrDownload(model)
+		if err != nil {
+			return nil, err
+		}
+		if len(files) == 1 {
+			src = core.TiktokenBytes(files[0])
+		} else {
+			src = core.Bytes(files[0], files[1])
+		}
+		opts = append(opts, core.WithPreTokenization(bpetok.EncodingPreTokenization(model)))
+	}
+
+	tok, err := core.Load(src, opts...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load tokenizer: %w", err)
+	}
+	return tok, nil
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/bpetok/bpetok"
+	"github.com/bpetok/internal/tokenizer/core"
+	"github.com/bpetok/internal/tokenizer/streaming_encoder_adaptive"
+	"github.com/bpetok/internal/tokenizer/streaming_encoder_incremental"
+	"github.com/bpetok/internal/tokenizer/streaming_encoder_naive"
+	"github.com/bpetok/replay"
+)
+
+// pushEncoder adapts the Push/Flush streaming encoders to the Feed/Flush contract replay drives.
+type pushEncoder struct {
+	push  func([]byte) []int
+	flush func() []int
+}
+
+func (p pushEncoder) Feed(chunk []byte) []int { return p.push(chunk) }
+func (p pushEncoder) Flush() []int            { return p.flush() }
+
+func newEncoder(tok *core.Tokenizer, kind string) (bpetok.Encoder, error) {
+	switch kind {
+	case "incremental":
+		se := streaming_encoder_incremental.NewStreamingEncoderV2(tok)
+		return pushEncoder{push: se.Push, flush: se.Flush}, nil
+	case "naive":
+		st := streaming_encoder_naive.NewNaiveStreamingEncoderStateWithOpts(tok, core.BaseEncoderState{})
+		return pushEncoder{push: st.Push, flush: st.Flush}, nil
+	case "adaptive":
+		return streaming_encoder_adaptive.NewAdaptiveEncoder(tok), nil
+	default:
+		return nil, fmt.Errorf("unknown encoder %q, want incremental, naive or adaptive", kind)
+	}
+}
+
+func runReplay(args []string) error {
+	fs := flag.NewFlagSet("replay", flag.ContinueOnError)
+	model := fs.String("model", "gpt2", "known model (e.g. gpt2, cl100k_base), all but gpt2 are downloaded into the cache")
+	vocab := fs.String("vocab", "", "path to vocab.json to use instead of -model, with -merges")
+	merges := fs.String("merges", "", "path to merges.txt to use instead of -model, with -vocab")
+	encoder := fs.String("encoder", "incremental", "encoder to replay against: incremental, naive or adaptive")
+	input := fs.String("input", "", "original input, required for sessions recorded in hashed mode")
+	verbose := fs.Bool("v", false, "print every differing step")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "usage: bpetok replay [flags] <session.bprp>")
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() != 1 {
+		fs.Usage()
+		return fmt.Errorf("expected exactly one replay file")
+	}
+
+	f, err := os.Open(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	sess, err := replay.Read(f)
+	if err != nil {
+		return err
+	}
+
+	var in []byte
+	if sess.Mode == replay.ModeHashed {
+		if *input == "" {
+			return fmt.Errorf("session was recorded in hashed mode, pass the original stream with -input")
+		}
+		if in, err = os.ReadFile(*input); err != nil {
+			return err
+		}
+	}
+
+	tok, err := loadTokenizer(*model, *vocab, *merges)
+	if err != nil {
+		return err
+	}
+
+	enc, err := newEncoder(tok, *encoder)
+	if err != nil {
+		return err
+	}
+
+	rep, err := replay.Run(sess, enc, in)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("steps: %d, differing steps: %d\n", rep.Steps, len(rep.Diffs))
+	if *verbose {
+		for _, d := range rep.Diffs {
+			fmt.Printf("  step %d:\n    want %v\n    got  %v\n", d.Step, d.Want, d.Got)
+		}
+	}
+
+	if !rep.StreamMatch {
+		fmt.Printf("token streams diverge at token %d\n", rep.FirstDivergence)
+		return errMismatch
+	}
+	fmt.Println("token streams match")
+	return nil
+}
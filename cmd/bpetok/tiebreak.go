@@ -0,0 +1,70 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"os"
+	"slices"
+
+	"github.com/bpetok/internal/tokenizer/core"
+)
+
+func runTieBreak(args []string) error {
+	fs := flag.NewFlagSet("tiebreak", flag.ContinueOnError)
+	model := fs.String("model", "gpt2", "known model (e.g. gpt2, cl100k_base), all but gpt2 are downloaded into the cache")
+	vocab := fs.String("vocab", "", "path to vocab.json to use instead of -model, with -merges")
+	merges := fs.String("merges", "", "path to merges.txt to use instead of -model, with -vocab")
+	tiktoken := fs.String("tiktoken", "", "tiktoken rank file to use instead of -model")
+	lines := fs.Bool("lines", true, "treat every line of a corpus file as its own document")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "usage: bpetok tiebreak [flags] <corpus>...")
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() == 0 {
+		fs.Usage()
+		return fmt.Errorf("expected at least one corpus file")
+	}
+
+	var (
+		tok *core.Tokenizer
+		err error
+	)
+	if *tiktoken != "" {
+		tok, err = core.Load(core.TiktokenFile(*tiktoken))
+		if err != nil {
+			return fmt.Errorf("failed to load tokenizer: %w", err)
+		}
+	} else if tok, err = loadTokenizer(*model, *vocab, *merges); err != nil {
+		return err
+	}
+
+	var docs [][]byte
+	for _, path := range fs.Args() {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		if *lines {
+			docs = append(docs, bytes.Split(data, []byte("\n"))...)
+		} else {
+			docs = append(docs, data)
+		}
+	}
+
+	rep := tok.SimulateTieBreaks(slices.Values(docs))
+	fmt.Printf("docs: %d, bytes: %d\n", rep.Docs, rep.Bytes)
+	fmt.Printf("  %-16s %d diverged from EncodeOffline (%.4f%%)\n", core.TieLeftmost, rep.Diverged[core.TieLeftmost], 100*rep.Rate(core.TieLeftmost))
+	for _, rule := range core.TieBreaks[1:] {
+		fmt.Printf("  %-16s %d diverged from leftmost (%.4f%%)\n", rule, rep.Diverged[rule], 100*rep.Rate(rule))
+	}
+	for _, rule := range core.TieBreaks {
+		if ex, ok := rep.Examples[rule]; ok {
+			fmt.Printf("first %s divergence: doc %d, token %d\n    want %v\n    got  %v\n", rule, ex.Doc, ex.Token, ex.Want, ex.Got)
+		}
+	}
+	return nil
+}
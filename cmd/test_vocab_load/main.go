@@ -4,14 +4,14 @@ import (
 	"log"
 	"path/filepath"
 
-	"github.com/bpetok/internal/tokenizer"
+	"github.com/bpetok/internal/tokenizer/core"
 )
 
 func main() {
 	vocab := filepath.Join("testdata", "gpt2", "vocab.json")
 	merges := filepath.Join("testdata", "gpt2", "merges.txt")
 
-	tok, err := tokenizer.LoadTokenizerFromFiles(vocab, merges)
+	tok, err := core.LoadTokenizerFromFiles(vocab, merges)
 	if err != nil {
 		log.Fatalf("failed to load tokenizer: %v", err)
 	}
@@ -0,0 +1,183 @@
+// Package hf produces HuggingFace tokenizers style Encoding values from bpetok, so pipelines built around
+// the Rust/Python library can diff their JSON output against bpetok's and migrate piecemeal.
+//
+// Encodings carry what a byte-level BPE without a post-processor can fill in: there are no pair
+// sequences, no truncation and no template tokens, so type_ids and special_tokens_mask are all zeros,
+// words are null and overflowing is empty. Offsets are byte offsets into the input, as in the Rust crate.
+package hf
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/bpetok/bpetok"
+	"github.com/bpetok/internal/tokenizer/core"
+)
+
+// Encoding mirrors tokenizers::Encoding, field for field and in the same order, so json.Marshal output
+// lines up with serde's.
+type Encoding struct {
+	IDs               []uint32          `json:"ids"`
+	TypeIDs           []uint32          `json:"type_ids"`
+	Tokens            []string          `json:"tokens"`
+	Words             []*uint32         `json:"words"`
+	Offsets           [][2]int          `json:"offsets"`
+	SpecialTokensMask []uint32          `json:"special_tokens_mask"`
+	AttentionMask     []uint32          `json:"attention_mask"`
+	Overflowing       []Encoding        `json:"overflowing"`
+	SequenceRanges    map[string][2]int `json:"sequence_ranges"`
+}
+
+// Len returns the number of tokens.
+func (e *Encoding) Len() int {
+	return len(e.IDs)
+}
+
+// Tokenizer wraps a bpetok tokenizer with the added tokens HuggingFace would split out of the input before
+// running the model.
+type Tokenizer struct {
+	tok   *bpetok.Tokenizer
+	added map[string]int
+	names map[int]string
+	// matcher finds the added tokens, nil if there are none
+	matcher *core.SpecialMatcher
+}
+
+// New returns a Tokenizer over tok. added maps added token content (e.g. "<|endoftext|>") to its ID and may
+// be nil.
+func New(tok *bpetok.Tokenizer, added map[string]int) (*Tokenizer, error) {
+	t := &Tokenizer{tok: tok, added: make(map[string]int, len(added)), names: make(map[int]string, len(added))}
+	for s, id := range added {
+		if s == "" {
+			return nil, fmt.Errorf("hf: empty added token")
+		}
+		if id < 0 || id >= tok.VocabSize() {
+			return nil, fmt.Errorf("hf: added token %q has id %d outside the vocab", s, id)
+		}
+		t.added[s] = id
+		t.names[id] = s
+	}
+	t.matcher = core.NewSpecialMatcher(t.added)
+	return t, nil
+}
+
+// Encode encodes text into an Encoding. The text between added tokens is normalized and encoded piece by
+// piece, so no token spans an added one, and only the start of text gets the prefix space. Offsets index
+// text itself: a token covering part of what normalization changed gets the changed span's start.
+func (t *Tokenizer) Encode(text string) (*Encoding, error) {
+	enc := &Encoding{
+		IDs:               []uint32{},
+		TypeIDs:           []uint32{},
+		Tokens:            []string{},
+		Words:             []*uint32{},
+		Offsets:           [][2]int{},
+		SpecialTokensMask: []uint32{},
+		AttentionMask:     []uint32{},
+		Overflowing:       []Encoding{},
+		SequenceRanges:    map[string][2]int{},
+	}
+
+	b := []byte(text)
+	pos := 0
+	for pos < len(b) {
+		m, _ := t.matcher.Find(b, pos, false)
+		end := len(b)
+		if m.Len > 0 {
+			end = m.Start
+		}
+
+		if end > pos {
+			if err := t.encodeSegment(enc, b, pos, end); err != nil {
+				return nil, err
+			}
+		}
+
+		if m.Len == 0 {
+			break
+		}
+		enc.add(m.ID, text[m.Start:m.Start+m.Len], m.Start, m.Start+m.Len)
+		pos = m.Start + m.Len
+	}
+
+	return enc, nil
+}
+
+// encodeSegment adds the tokens of b[start:end], text between added tokens, to enc.
+func (t *Tokenizer) encodeSegment(enc *Encoding, b []byte, start, end int) error {
+	seg, orig := t.tok.Normalization().ApplyMapped(b[start:end])
+	if start == 0 && t.tok.AddPrefixSpace() {
+		if prefixed := core.PrefixSpace(seg); len(prefixed) > len(seg) {
+			// the space is no part of text, it goes with the token that starts it
+			seg, orig = prefixed, append([]int{0}, orig...)
+		}
+	}
+
+	// TokenHeal with no prefix encodes seg as a continuation: normalized, which leaves seg as it is, and
+	// without a prefix space of its own
+	ids, err := t.tok.TokenHeal(nil, seg)
+	if err != nil {
+		return err
+	}
+	p := 0
+	for _, id := range ids {
+		tb := t.tok.TokenBytes(id)
+		enc.add(id, bpetok.ByteLevelEncode(tb), start+orig[p], start+orig[p+len(tb)])
+		p += len(tb)
+	}
+	return nil
+}
+
+// EncodeBatch encodes every input, like Tokenizer.encode_batch.
+func (t *Tokenizer) EncodeBatch(texts []string) ([]*Encoding, error) {
+	out := make([]*Encoding, len(texts))
+	for i, text := range texts {
+		enc, err := t.Encode(text)
+		if err != nil {
+			return nil, fmt.Errorf("hf: input %d: %w", i, err)
+		}
+		out[i] = enc
+	}
+	return out, nil
+}
+
+// Decode turns ids back into text. Added tokens are dropped when skipSpecialTokens is set.
+func (t *Tokenizer) Decode(ids []uint32, skipSpecialTokens bool) (string, error) {
+	var sb strings.Builder
+	run := make([]int, 0, len(ids))
+	flush := func() error {
+		s, err := t.tok.Decode(run)
+		if err != nil {
+			return err
+		}
+		sb.WriteString(s)
+		run = run[:0]
+		return nil
+	}
+
+	for _, id := range ids {
+		if name, ok := t.names[int(id)]; ok {
+			if err := flush(); err != nil {
+				return "", err
+			}
+			if !skipSpecialTokens {
+				sb.WriteString(name)
+			}
+			continue
+		}
+		run = append(run, int(id))
+	}
+	if err := flush(); err != nil {
+		return "", err
+	}
+	return sb.String(), nil
+}
+
+func (e *Encoding) add(id int, token string, start, end int) {
+	e.IDs = append(e.IDs, uint32(id))
+	e.TypeIDs = append(e.TypeIDs, 0)
+	e.Tokens = append(e.Tokens, token)
+	e.Words = append(e.Words, nil)
+	e.Offsets = append(e.Offsets, [2]int{start, end})
+	e.SpecialTokensMask = append(e.SpecialTokensMask, 0)
+	e.AttentionMask = append(e.AttentionMask, 1)
+}
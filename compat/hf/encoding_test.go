@@ -0,0 +1,168 @@
+package hf
+
+import (
+	"encoding/json"
+	"os"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/bpetok/bpetok"
+)
+
+func loadTestTokenizer(t *testing.T) *Tokenizer {
+	t.Helper()
+
+	vocab, err := os.ReadFile("../../internal/tokenizer/testdata/gpt2/vocab.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	merges, err := os.ReadFile("../../internal/tokenizer/testdata/gpt2/merges.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tok, err := bpetok.LoadTokenizer(vocab, merges)
+	if err != nil {
+		t.Fatalf("load tokenizer: %v", err)
+	}
+
+	hf, err := New(tok, map[string]int{"<|endoftext|>": 50256})
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	return hf
+}
+
+func TestEncode_TokensAndOffsets(t *testing.T) {
+	tok := loadTestTokenizer(t)
+
+	text := "Hello world<|endoftext|> café"
+	enc, err := tok.Encode(text)
+	if err != nil {
+		t.Fatalf("Encode: %v", err)
+	}
+
+	if enc.IDs[len(enc.IDs)-1] == 50256 || !containsID(enc.IDs, 50256) {
+		t.Fatalf("expected the added token in the middle, got %v", enc.IDs)
+	}
+
+	var rebuilt strings.Builder
+	prevEnd := 0
+	for i := range enc.IDs {
+		off := enc.Offsets[i]
+		if off[0] != prevEnd || off[1] < off[0] {
+			t.Fatalf("token %d: offsets %v don't tile the input", i, off)
+		}
+		rebuilt.WriteString(text[off[0]:off[1]])
+		prevEnd = off[1]
+	}
+	if rebuilt.String() != text || prevEnd != len(text) {
+		t.Fatalf("offsets rebuild %q, want %q", rebuilt.String(), text)
+	}
+
+	if enc.Tokens[1] != "Ġworld" {
+		t.Fatalf("expected byte-level token text, got %q", enc.Tokens)
+	}
+	for i := range enc.IDs {
+		if enc.AttentionMask[i] != 1 || enc.TypeIDs[i] != 0 || enc.SpecialTokensMask[i] != 0 {
+			t.Fatalf("unexpected masks at %d", i)
+		}
+	}
+}
+
+func TestEncode_OverlappingAddedTokens(t *testing.T) {
+	base := loadTestTokenizer(t)
+	tok, err := New(base.tok, map[string]int{"<a>": 1000, "<a><b>": 1001, "b>c": 1002})
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+
+	// the earliest match wins, the longest of those starting together
+	enc, err := tok.Encode("x<a><b>c<a>")
+	if err != nil {
+		t.Fatalf("Encode: %v", err)
+	}
+	want := []string{"x", "<a><b>", "c", "<a>"}
+	if !slices.Equal(enc.Tokens, want) || enc.IDs[1] != 1001 || enc.IDs[3] != 1000 || enc.Offsets[3] != [2]int{8, 11} {
+		t.Fatalf("tokens %q, ids %v, offsets %v", enc.Tokens, enc.IDs, enc.Offsets)
+	}
+}
+
+// With a prefix space and NFKC, only the start of the text gets the space, the text after an added token
+// is encoded as it stands, and offsets still index the input: the ligature ﬁ normalizes to two bytes of
+// "fi" but spans its own three.
+func TestEncode_PrefixSpaceAroundAddedToken(t *testing.T) {
+	base, err := bpetok.Load(bpetok.Files("../../internal/tokenizer/testdata/gpt2/vocab.json",
+		"../../internal/tokenizer/testdata/gpt2/merges.txt"),
+		bpetok.WithAddPrefixSpace(true), bpetok.WithNormalization(bpetok.NormalizeNFKC))
+	if err != nil {
+		t.Fatalf("load tokenizer: %v", err)
+	}
+	tok, err := New(base, map[string]int{"<|endoftext|>": 50256})
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+
+	text := "Hello<|endoftext|>world ﬁne"
+	enc, err := tok.Encode(text)
+	if err != nil {
+		t.Fatalf("Encode: %v", err)
+	}
+	want := []string{"ĠHello", "<|endoftext|>", "world", "Ġfine"}
+	if !slices.Equal(enc.Tokens, want) {
+		t.Fatalf("tokens %q, want %q", enc.Tokens, want)
+	}
+	wantOffs := [][2]int{{0, 5}, {5, 18}, {18, 23}, {23, len(text)}}
+	if !slices.Equal(enc.Offsets, wantOffs) {
+		t.Fatalf("offsets %v, want %v", enc.Offsets, wantOffs)
+	}
+}
+
+func TestEncode_JSONShape(t *testing.T) {
+	tok := loadTestTokenizer(t)
+
+	enc, err := tok.Encode("Hi")
+	if err != nil {
+		t.Fatalf("Encode: %v", err)
+	}
+	b, err := json.Marshal(enc)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := `{"ids":[17250],"type_ids":[0],"tokens":["Hi"],"words":[null],"offsets":[[0,2]],` +
+		`"special_tokens_mask":[0],"attention_mask":[1],"overflowing":[],"sequence_ranges":{}}`
+	if string(b) != want {
+		t.Fatalf("got  %s\nwant %s", b, want)
+	}
+}
+
+func TestDecode_SkipSpecialTokens(t *testing.T) {
+	tok := loadTestTokenizer(t)
+
+	encs, err := tok.EncodeBatch([]string{"a<|endoftext|>b", ""})
+	if err != nil {
+		t.Fatalf("EncodeBatch: %v", err)
+	}
+	if encs[1].Len() != 0 {
+		t.Fatalf("empty input should give an empty encoding")
+	}
+
+	got, err := tok.Decode(encs[0].IDs, false)
+	if err != nil || got != "a<|endoftext|>b" {
+		t.Fatalf("Decode = %q, %v", got, err)
+	}
+	got, err = tok.Decode(encs[0].IDs, true)
+	if err != nil || got != "ab" {
+		t.Fatalf("Decode skipping specials = %q, %v", got, err)
+	}
+}
+
+func containsID(ids []uint32, id uint32) bool {
+	for _, v := range ids {
+		if v == id {
+			return true
+		}
+	}
+	return false
+}
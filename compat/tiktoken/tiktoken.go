@@ -0,0 +1,198 @@
+// Package tiktoken mirrors the tiktoken-go API on top of bpetok, so code written against that library can
+// switch over with little more than an import change and pick up streaming encoders along the way.
+//
+// Built-in encoding names resolve through bpetok.Get, so they share its asset cache. Anything else has to be
+// registered with RegisterEncoding first.
+//
+// Built-in encodings apply tiktoken's pre-tokenization regex, so their IDs match real tiktoken. Encodings
+// from RegisterEncoding merge over the raw byte stream, so their IDs can differ where a merge would cross
+// one of the regex's split points.
+package tiktoken
+
+import (
+	"fmt"
+	"maps"
+	"strings"
+	"sync"
+
+	"github.com/bpetok/bpetok"
+	"github.com/bpetok/internal/tokenizer/core"
+)
+
+// gpt2Special are the special tokens of the GPT-2 vocab.
+var gpt2Special = map[string]int{"<|endoftext|>": 50256}
+
+// encodingDef describes how to build an encoding the first time it is asked for.
+type encodingDef struct {
+	load    func() (*bpetok.Tokenizer, error)
+	special map[string]int
+}
+
+var (
+	mu   sync.Mutex
+	defs = map[string]encodingDef{
+		"gpt2":      {load: builtin("gpt2"), special: gpt2Special},
+		"r50k_base": {load: builtin("r50k_base"), special: gpt2Special},
+	}
+	encodings = map[string]*Tiktoken{}
+)
+
+func builtin(name string) func() (*bpetok.Tokenizer, error) {
+	return func() (*bpetok.Tokenizer, error) { return bpetok.Get(name) }
+}
+
+// Tiktoken is a loaded encoding. It is safe for concurrent use.
+type Tiktoken struct {
+	tok     *bpetok.Tokenizer
+	special map[string]int
+	// specialByID is the inverse of special, for Decode.
+	specialByID map[int]string
+}
+
+// RegisterEncoding makes name available to GetEncoding. special maps special token text to its ID, it may
+// be nil. Registering a name again replaces it for later GetEncoding calls.
+func RegisterEncoding(name string, vocab, merges []byte, special map[string]int) {
+	vocab, merges = clone(vocab), clone(merges)
+	sp := make(map[string]int, len(special))
+	for k, v := range special {
+		sp[k] = v
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	defs[name] = encodingDef{
+		load:    func() (*bpetok.Tokenizer, error) { return bpetok.LoadTokenizer(vocab, merges) },
+		special: sp,
+	}
+	delete(encodings, name)
+}
+
+func clone(b []byte) []byte {
+	return append([]byte(nil), b...)
+}
+
+// GetEncoding returns the named encoding, loading it on first use.
+func GetEncoding(encodingName string) (*Tiktoken, error) {
+	mu.Lock()
+	defer mu.Unlock()
+
+	if enc, ok := encodings[encodingName]; ok {
+		return enc, nil
+	}
+
+	def, ok := defs[encodingName]
+	if !ok {
+		return nil, fmt.Errorf("tiktoken: unknown encoding %q", encodingName)
+	}
+
+	tok, err := def.load()
+	if err != nil {
+		return nil, fmt.Errorf("tiktoken: load %s: %w", encodingName, err)
+	}
+
+	enc := &Tiktoken{tok: tok, special: def.special, specialByID: make(map[int]string, len(def.special))}
+	for s, id := range def.special {
+		if id < 0 || id >= tok.VocabSize() {
+			return nil, fmt.Errorf("tiktoken: load %s: special token %q has id %d outside the vocab", encodingName, s, id)
+		}
+		enc.specialByID[id] = s
+	}
+
+	encodings[encodingName] = enc
+	return enc, nil
+}
+
+// EncodeOrdinary encodes text treating special tokens as plain text.
+func (t *Tiktoken) EncodeOrdinary(text string) []int {
+	ids, _ := t.tok.Encode(text)
+	return ids
+}
+
+// EncodeWithSpecialTokens encodes text, turning every special token in it into its ID.
+func (t *Tiktoken) EncodeWithSpecialTokens(text string) []int {
+	return t.Encode(text, []string{"all"}, nil)
+}
+
+// Encode encodes text the way tiktoken-go does: special tokens in allowedSpecial become their IDs, ones in
+// disallowedSpecial panic if they appear in text, and the rest are encoded as plain text. "all" stands for
+// every special token of the encoding; a nil or empty disallowedSpecial means "all" minus allowedSpecial,
+// same as tiktoken.
+func (t *Tiktoken) Encode(text string, allowedSpecial []string, disallowedSpecial []string) []int {
+	allowed := t.specialSet(allowedSpecial)
+
+	var disallowed map[string]int
+	if len(disallowedSpecial) == 0 {
+		disallowed = t.specialSet([]string{"all"})
+	} else {
+		disallowed = t.specialSet(disallowedSpecial)
+	}
+	for s := range allowed {
+		delete(disallowed, s)
+	}
+
+	b := []byte(text)
+	if m, _ := core.NewSpecialMatcher(disallowed).Find(b, 0, false); m.Len > 0 {
+		panic(fmt.Sprintf("text contains disallowed special token %s", text[m.Start:m.Start+m.Len]))
+	}
+
+	var out []int
+	matcher := core.NewSpecialMatcher(allowed)
+	start := 0
+	for {
+		m, _ := matcher.Find(b, start, false)
+		if m.Len == 0 {
+			break
+		}
+		out = append(out, t.EncodeOrdinary(text[start:m.Start])...)
+		out = append(out, m.ID)
+		start = m.Start + m.Len
+	}
+	return append(out, t.EncodeOrdinary(text[start:])...)
+}
+
+// Decode turns tokens back into text. Unknown IDs are skipped, tiktoken-go doesn't report them either.
+func (t *Tiktoken) Decode(tokens []int) string {
+	var sb strings.Builder
+	n := t.tok.VocabSize()
+	start := 0
+	flush := func(end int) {
+		if start < end {
+			s, _ := t.tok.Decode(tokens[start:end])
+			sb.WriteString(s)
+		}
+	}
+	for i, id := range tokens {
+		if s, ok := t.specialByID[id]; ok {
+			flush(i)
+			sb.WriteString(s)
+			start = i + 1
+		} else if id < 0 || id >= n {
+			flush(i)
+			start = i + 1
+		}
+	}
+	flush(len(tokens))
+	return sb.String()
+}
+
+// NewEncoder returns a streaming encoder over the same vocab. Special tokens are not recognized in the
+// stream, they are encoded as ordinary text.
+func (t *Tiktoken) NewEncoder() bpetok.Encoder {
+	return t.tok.NewEncoder()
+}
+
+// specialSet resolves a tiktoken style list of special tokens to their IDs, expanding "all". Names that
+// aren't special tokens of this encoding are ignored.
+func (t *Tiktoken) specialSet(names []string) map[string]int {
+	set := make(map[string]int)
+	for _, name := range names {
+		if name == "all" {
+			maps.Copy(set, t.special)
+			continue
+		}
+		if id, ok := t.special[name]; ok {
+			set[name] = id
+		}
+	}
+	return set
+}
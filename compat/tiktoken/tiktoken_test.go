@@ -0,0 +1,85 @@
+package tiktoken
+
+import (
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func gpt2(t *testing.T) *Tiktoken {
+	t.Helper()
+	// the testdata layout doubles as a pre-seeded bpetok cache
+	t.Setenv("BPETOK_CACHE_DIR", "../../internal/tokenizer/testdata")
+
+	enc, err := GetEncoding("gpt2")
+	if err != nil {
+		t.Fatalf("GetEncoding: %v", err)
+	}
+	return enc
+}
+
+func TestGetEncoding_Unknown(t *testing.T) {
+	if _, err := GetEncoding("no_such_encoding"); err == nil {
+		t.Fatalf("expected an error for an unknown encoding")
+	}
+}
+
+func TestEncodeOrdinary_RoundTrip(t *testing.T) {
+	enc := gpt2(t)
+
+	text := "Hello world, this is <|endoftext|> plain text"
+	ids := enc.EncodeOrdinary(text)
+	for _, id := range ids {
+		if id == 50256 {
+			t.Fatalf("EncodeOrdinary produced the special token: %v", ids)
+		}
+	}
+	if got := enc.Decode(ids); got != text {
+		t.Fatalf("round trip: got %q want %q", got, text)
+	}
+}
+
+func TestEncodeWithSpecialTokens(t *testing.T) {
+	enc := gpt2(t)
+
+	ids := enc.EncodeWithSpecialTokens("first<|endoftext|>second")
+	want := append(append(enc.EncodeOrdinary("first"), 50256), enc.EncodeOrdinary("second")...)
+	if !reflect.DeepEqual(ids, want) {
+		t.Fatalf("got %v want %v", ids, want)
+	}
+	if got := enc.Decode(ids); got != "first<|endoftext|>second" {
+		t.Fatalf("decode: got %q", got)
+	}
+}
+
+func TestEncode_DisallowedSpecialPanics(t *testing.T) {
+	enc := gpt2(t)
+
+	defer func() {
+		if r := recover(); r == nil || !strings.Contains(r.(string), "<|endoftext|>") {
+			t.Fatalf("expected a panic naming the special token, got %v", r)
+		}
+	}()
+	enc.Encode("oops <|endoftext|>", nil, nil)
+}
+
+func TestRegisterEncoding(t *testing.T) {
+	vocab, err := os.ReadFile("../../internal/tokenizer/testdata/gpt2/vocab.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	merges, err := os.ReadFile("../../internal/tokenizer/testdata/gpt2/merges.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	RegisterEncoding("test_gpt2", vocab, merges, map[string]int{"<|endoftext|>": 50256})
+
+	enc, err := GetEncoding("test_gpt2")
+	if err != nil {
+		t.Fatalf("GetEncoding: %v", err)
+	}
+	if ids := enc.Encode("<|endoftext|>", []string{"<|endoftext|>"}, nil); !reflect.DeepEqual(ids, []int{50256}) {
+		t.Fatalf("got %v", ids)
+	}
+}
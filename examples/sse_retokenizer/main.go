@@ -0,0 +1,266 @@
+// Command sse_retokenizer is an example HTTP proxy for streamed chat completions. It forwards requests to
+// an upstream OpenAI-style API and, as the server-sent events come back, feeds every text delta through a
+// streaming encoder. After each upstream event it adds a bpetok.usage event with the running token count,
+// and before the final [DONE] one with the exact total and the encoder's Feed latency. Totals accumulate
+// per conversation (the X-Conversation-ID request header) and are served on GET /usage?conversation=<id>.
+//
+//	go run ./examples/sse_retokenizer -upstream https://api.example.com
+//	curl -N -H 'X-Conversation-ID: c1' -d @request.json localhost:8080/v1/chat/completions
+//
+// The deltas are tokenized with GPT-2's vocab (embedded, see bpetok/vocabs/gpt2) unless -model names
+// something bpetok.ForModel knows. Counts are what that vocab gives for the streamed text, so they only
+// match the upstream's own billing when the upstream uses the same vocab.
+//
+// Every response carries the tokenizer's algorithm version in X-Bpetok-Algorithm-Version and its
+// fingerprint in X-Bpetok-Fingerprint. A client that stores token counts or IDs sends the values it
+// recorded in the same headers and gets 412 Precondition Failed instead of counts that could differ from
+// the ones it has; -algorithm-version makes the proxy refuse to start at all unless it encodes as that
+// version.
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/bpetok/bpetok"
+	"github.com/bpetok/bpetok/vocabs/gpt2"
+)
+
+func main() {
+	listen := flag.String("listen", ":8080", "address to listen on")
+	upstream := flag.String("upstream", "", "base URL of the upstream API")
+	model := flag.String("model", "", "model to count tokens for, the embedded GPT-2 vocab if empty")
+	pinned := flag.Int("algorithm-version", 0, "refuse to start unless the tokenizer encodes as this algorithm version")
+	flag.Parse()
+
+	target, err := url.Parse(*upstream)
+	if err != nil || target.Scheme == "" {
+		log.Fatalf("sse_retokenizer: -upstream must be an absolute URL, got %q", *upstream)
+	}
+
+	tok, err := gpt2.Tokenizer()
+	if *model != "" {
+		tok, err = bpetok.ForModel(*model)
+	}
+	if err != nil {
+		log.Fatalf("sse_retokenizer: load tokenizer: %v", err)
+	}
+	if *pinned != 0 && (bpetok.AlgorithmVersion != *pinned || tok.AlgorithmVersion() != *pinned) {
+		log.Fatalf("sse_retokenizer: %v: pinned to %d, tokenizer encodes as %d", bpetok.ErrAlgorithmVersion, *pinned, tok.AlgorithmVersion())
+	}
+
+	log.Printf("sse_retokenizer: proxying %s to %s", *listen, target)
+	log.Fatal(http.ListenAndServe(*listen, newProxy(tok, target, http.DefaultClient)))
+}
+
+// usage is what the proxy has counted, both per stream (in bpetok.usage events) and per conversation.
+type usage struct {
+	CompletionTokens int `json:"completion_tokens"`
+	Streams          int `json:"streams"`
+}
+
+// streamUsage is the payload of a bpetok.usage event. Latency fields are only set on the final one.
+type streamUsage struct {
+	// CommittedTokens is how many tokens of the stream so far can no longer change. The final event
+	// carries the exact total.
+	CommittedTokens int  `json:"committed_tokens"`
+	Final           bool `json:"final,omitempty"`
+	Feeds           int  `json:"feeds,omitempty"`
+	FeedAvgMicros   int  `json:"feed_avg_us,omitempty"`
+	FeedMaxMicros   int  `json:"feed_max_us,omitempty"`
+}
+
+// algorithmHeader and fingerprintHeader carry the tokenizer's algorithm version and fingerprint, see the
+// package doc.
+const (
+	algorithmHeader   = "X-Bpetok-Algorithm-Version"
+	fingerprintHeader = "X-Bpetok-Fingerprint"
+)
+
+type proxy struct {
+	tok      *bpetok.Tokenizer
+	upstream *url.URL
+	client   *http.Client
+
+	mu            sync.Mutex
+	conversations map[string]*usage
+}
+
+func newProxy(tok *bpetok.Tokenizer, upstream *url.URL, client *http.Client) *proxy {
+	return &proxy{tok: tok, upstream: upstream, client: client, conversations: make(map[string]*usage)}
+}
+
+func (p *proxy) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	handshake := []struct{ name, have string }{
+		{algorithmHeader, strconv.Itoa(p.tok.AlgorithmVersion())},
+		{fingerprintHeader, p.tok.Fingerprint()},
+	}
+	for _, h := range handshake {
+		w.Header().Set(h.name, h.have)
+	}
+	for _, h := range handshake {
+		if want := r.Header.Get(h.name); want != "" && want != h.have {
+			http.Error(w, fmt.Sprintf("%s is %s, client expects %s", h.name, h.have, want), http.StatusPreconditionFailed)
+			return
+		}
+	}
+
+	if r.Method == http.MethodGet && r.URL.Path == "/usage" {
+		p.serveUsage(w, r.URL.Query().Get("conversation"))
+		return
+	}
+
+	req, err := http.NewRequestWithContext(r.Context(), r.Method, p.upstream.JoinPath(r.URL.Path).String(), r.Body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	req.URL.RawQuery = r.URL.RawQuery
+	req.Header = r.Header.Clone()
+	req.Header.Del(algorithmHeader)
+	req.Header.Del(fingerprintHeader)
+
+	resp, err := p.client.Do(req)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadGateway)
+		return
+	}
+	defer resp.Body.Close()
+
+	for k, vs := range resp.Header {
+		if k != algorithmHeader && k != fingerprintHeader {
+			w.Header()[k] = vs
+		}
+	}
+	if !strings.HasPrefix(resp.Header.Get("Content-Type"), "text/event-stream") {
+		w.WriteHeader(resp.StatusCode)
+		io.Copy(w, resp.Body)
+		return
+	}
+
+	// the annotations change the length
+	w.Header().Del("Content-Length")
+	w.WriteHeader(resp.StatusCode)
+
+	st, err := p.relay(w, resp.Body)
+	if err != nil {
+		log.Printf("sse_retokenizer: relay: %v", err)
+	}
+	if id := r.Header.Get("X-Conversation-ID"); id != "" {
+		p.mu.Lock()
+		u := p.conversations[id]
+		if u == nil {
+			u = &usage{}
+			p.conversations[id] = u
+		}
+		u.CompletionTokens += st.CommittedTokens
+		u.Streams++
+		p.mu.Unlock()
+	}
+}
+
+// relay copies the event stream to w line by line, feeding deltas to a fresh encoder and adding a
+// bpetok.usage event after every upstream event. It returns the final usage of the stream.
+func (p *proxy) relay(w http.ResponseWriter, body io.Reader) (streamUsage, error) {
+	flusher, _ := w.(http.Flusher)
+	enc := p.tok.NewEncoder()
+
+	var st streamUsage
+	var feedTotal, feedMax time.Duration
+	done := false
+
+	emit := func(final bool) {
+		if final {
+			st.CommittedTokens += len(enc.Flush())
+			st.Final = true
+			if st.Feeds > 0 {
+				st.FeedAvgMicros = int(feedTotal.Microseconds()) / st.Feeds
+				st.FeedMaxMicros = int(feedMax.Microseconds())
+			}
+		}
+		payload, _ := json.Marshal(st)
+		fmt.Fprintf(w, "event: bpetok.usage\ndata: %s\n\n", payload)
+	}
+
+	sc := bufio.NewScanner(body)
+	sc.Buffer(make([]byte, 0, 64<<10), 1<<20)
+	for sc.Scan() {
+		line := sc.Text()
+		data, isData := strings.CutPrefix(line, "data:")
+		data = strings.TrimSpace(data)
+
+		if isData && data == "[DONE]" {
+			emit(true)
+			done = true
+		} else if isData {
+			if delta := deltaContent(data); delta != "" {
+				start := time.Now()
+				st.CommittedTokens += len(enc.Feed([]byte(delta)))
+				d := time.Since(start)
+				feedTotal += d
+				feedMax = max(feedMax, d)
+				st.Feeds++
+			}
+		}
+
+		fmt.Fprintf(w, "%s\n", line)
+		if line == "" && !done {
+			// end of an upstream event
+			emit(false)
+		}
+		if flusher != nil {
+			flusher.Flush()
+		}
+	}
+
+	if !done {
+		// upstream closed without [DONE], still report the exact total
+		emit(true)
+		if flusher != nil {
+			flusher.Flush()
+		}
+	}
+	return st, sc.Err()
+}
+
+// deltaContent pulls choices[0].delta.content out of a chat completion chunk, "" for anything else.
+func deltaContent(data string) string {
+	var chunk struct {
+		Choices []struct {
+			Delta struct {
+				Content string `json:"content"`
+			} `json:"delta"`
+		} `json:"choices"`
+	}
+	if json.Unmarshal([]byte(data), &chunk) != nil || len(chunk.Choices) == 0 {
+		return ""
+	}
+	return chunk.Choices[0].Delta.Content
+}
+
+func (p *proxy) serveUsage(w http.ResponseWriter, conversation string) {
+	p.mu.Lock()
+	u, ok := p.conversations[conversation]
+	var snapshot usage
+	if ok {
+		snapshot = *u
+	}
+	p.mu.Unlock()
+
+	if !ok {
+		http.Error(w, "unknown conversation", http.StatusNotFound)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(snapshot)
+}
@@ -0,0 +1,180 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/bpetok/bpetok/vocabs/gpt2"
+)
+
+var deltas = []string{"The quick", " brown fo", "x jumps over", " the lazy dog", ". 東", "京!"}
+
+func fakeUpstream(t *testing.T, done bool) *httptest.Server {
+	t.Helper()
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v1/chat/completions" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Header().Set("Content-Type", "text/event-stream")
+		for _, d := range deltas {
+			payload, _ := json.Marshal(map[string]any{"choices": []any{map[string]any{"delta": map[string]string{"content": d}}}})
+			fmt.Fprintf(w, "data: %s\n\n", payload)
+		}
+		if done {
+			fmt.Fprint(w, "data: [DONE]\n\n")
+		}
+	}))
+}
+
+// usageEvents returns the payloads of the bpetok.usage events and whether [DONE] came after the last one.
+func usageEvents(t *testing.T, body string) ([]streamUsage, bool) {
+	t.Helper()
+
+	var events []streamUsage
+	doneLast := false
+	sc := bufio.NewScanner(strings.NewReader(body))
+	for sc.Scan() {
+		switch line := sc.Text(); {
+		case line == "event: bpetok.usage":
+			sc.Scan()
+			var u streamUsage
+			if err := json.Unmarshal([]byte(strings.TrimPrefix(sc.Text(), "data: ")), &u); err != nil {
+				t.Fatalf("bad usage event %q: %v", sc.Text(), err)
+			}
+			events = append(events, u)
+			doneLast = false
+		case line == "data: [DONE]":
+			doneLast = true
+		}
+	}
+	return events, doneLast
+}
+
+func TestProxy_AnnotatesStream(t *testing.T) {
+	tok := gpt2.MustTokenizer()
+	want, _ := tok.Encode(strings.Join(deltas, ""))
+
+	for _, done := range []bool{true, false} {
+		up := fakeUpstream(t, done)
+		defer up.Close()
+		target, _ := url.Parse(up.URL)
+		srv := httptest.NewServer(newProxy(tok, target, up.Client()))
+		defer srv.Close()
+
+		for range 2 {
+			req, _ := http.NewRequest(http.MethodPost, srv.URL+"/v1/chat/completions", strings.NewReader(`{"stream":true}`))
+			req.Header.Set("X-Conversation-ID", "c1")
+			resp, err := http.DefaultClient.Do(req)
+			if err != nil {
+				t.Fatalf("request: %v", err)
+			}
+			body, err := io.ReadAll(resp.Body)
+			if err != nil {
+				t.Fatalf("read: %v", err)
+			}
+			resp.Body.Close()
+
+			events, doneLast := usageEvents(t, string(body))
+			if len(events) != len(deltas)+1 {
+				t.Fatalf("done=%v: expected %d usage events, got %d:\n%s", done, len(deltas)+1, len(events), body)
+			}
+			for i := 1; i < len(events); i++ {
+				if events[i].CommittedTokens < events[i-1].CommittedTokens {
+					t.Fatalf("running count went backwards: %+v", events)
+				}
+			}
+			final := events[len(events)-1]
+			if !final.Final || final.CommittedTokens != len(want) || final.Feeds != len(deltas) {
+				t.Fatalf("done=%v: final usage %+v, want %d tokens over %d feeds", done, final, len(want), len(deltas))
+			}
+			if done && !doneLast {
+				t.Fatalf("[DONE] should follow the final usage event")
+			}
+		}
+
+		resp, err := http.Get(srv.URL + "/usage?conversation=c1")
+		if err != nil {
+			t.Fatalf("usage: %v", err)
+		}
+		var u usage
+		if err := json.NewDecoder(resp.Body).Decode(&u); err != nil {
+			t.Fatalf("decode usage: %v", err)
+		}
+		resp.Body.Close()
+		if u.Streams != 2 || u.CompletionTokens != 2*len(want) {
+			t.Fatalf("conversation usage %+v, want 2 streams and %d tokens", u, 2*len(want))
+		}
+	}
+}
+
+func TestProxy_PassesThroughNonStreaming(t *testing.T) {
+	up := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprint(w, `{"ok":true}`)
+	}))
+	defer up.Close()
+	target, _ := url.Parse(up.URL)
+	srv := httptest.NewServer(newProxy(gpt2.MustTokenizer(), target, up.Client()))
+	defer srv.Close()
+
+	resp, err := http.Get(srv.URL + "/v1/models")
+	if err != nil {
+		t.Fatalf("request: %v", err)
+	}
+	defer resp.Body.Close()
+	if body, _ := io.ReadAll(resp.Body); string(body) != `{"ok":true}` {
+		t.Fatalf("body %q", body)
+	}
+}
+
+func TestProxy_TokenizerHandshake(t *testing.T) {
+	hits := 0
+	up := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hits++
+		if r.Header.Get(algorithmHeader) != "" || r.Header.Get(fingerprintHeader) != "" {
+			t.Errorf("handshake header forwarded upstream")
+		}
+		fmt.Fprint(w, `{"ok":true}`)
+	}))
+	defer up.Close()
+	target, _ := url.Parse(up.URL)
+	tok := gpt2.MustTokenizer()
+	srv := httptest.NewServer(newProxy(tok, target, up.Client()))
+	defer srv.Close()
+
+	version, fp := fmt.Sprint(tok.AlgorithmVersion()), tok.Fingerprint()
+	for _, tc := range []struct {
+		header, sent string
+		want         int
+	}{
+		{algorithmHeader, "", http.StatusOK},
+		{algorithmHeader, version, http.StatusOK},
+		{algorithmHeader, version + "0", http.StatusPreconditionFailed},
+		{fingerprintHeader, fp, http.StatusOK},
+		{fingerprintHeader, "0" + fp[1:], http.StatusPreconditionFailed},
+	} {
+		req, _ := http.NewRequest(http.MethodGet, srv.URL+"/v1/models", nil)
+		if tc.sent != "" {
+			req.Header.Set(tc.header, tc.sent)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("request: %v", err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != tc.want || resp.Header.Get(algorithmHeader) != version || resp.Header.Get(fingerprintHeader) != fp {
+			t.Fatalf("%s %q: status %d, headers %v; want %d", tc.header, tc.sent, resp.StatusCode, resp.Header, tc.want)
+		}
+	}
+	if hits != 3 {
+		t.Fatalf("upstream saw %d requests, want 3: a mismatch must not be forwarded", hits)
+	}
+}
@@ -1,3 +1,5 @@
 module github.com/bpetok
 
-go 1.25.3
\ No newline at end of file
+go 1.25.3
+
+require golang.org/x/text v0.36.0
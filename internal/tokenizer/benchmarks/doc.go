@@ -0,0 +1,4 @@
+// Package benchmarks runs every encoder over the same corpora and chunk sizes, so their numbers can be
+// compared directly: go test -bench . ./internal/tokenizer/benchmarks. It holds no code of its own, the
+// benchmarks live in its tests.
+package benchmarks
@@ -0,0 +1,161 @@
+package benchmarks
+
+import (
+	"encoding/base64"
+	"fmt"
+	"math/rand"
+	"os"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/bpetok/internal/tokenizer/core"
+	"github.com/bpetok/internal/tokenizer/streaming_encoder_adaptive"
+	"github.com/bpetok/internal/tokenizer/streaming_encoder_incremental"
+	"github.com/bpetok/internal/tokenizer/streaming_encoder_naive"
+)
+
+// corpusLen caps every corpus, so the slowest encoder at the smallest chunk size still finishes a run in
+// about a second.
+const corpusLen = 256 << 10
+
+// streamer is what the benchmarks drive: Push and Flush as every streaming encoder has them.
+type streamer interface {
+	Push(chunk []byte) []int
+	Flush() []int
+}
+
+// offline runs EncodeOffline under the streaming harness: it buffers the chunks and encodes them all in
+// Flush, so its first token arrives with its last.
+type offline struct {
+	tok *core.Tokenizer
+	buf []byte
+}
+
+func (o *offline) Push(chunk []byte) []int {
+	o.buf = append(o.buf, chunk...)
+	return nil
+}
+
+func (o *offline) Flush() []int {
+	out := o.tok.EncodeOffline(o.buf, nil)
+	o.buf = o.buf[:0]
+	return out
+}
+
+var encoders = []struct {
+	name string
+	new  func(tok *core.Tokenizer) streamer
+}{
+	{"offline", func(tok *core.Tokenizer) streamer { return &offline{tok: tok} }},
+	{"naive", func(tok *core.Tokenizer) streamer {
+		return streaming_encoder_naive.NewNaiveEncoder(tok, true, false)
+	}},
+	{"incremental", func(tok *core.Tokenizer) streamer {
+		return streaming_encoder_incremental.NewStreamingEncoderV2(tok,
+			streaming_encoder_incremental.WithZeroCopyOutput(true))
+	}},
+	{"incremental-rank-aware", func(tok *core.Tokenizer) streamer {
+		return streaming_encoder_incremental.NewStreamingEncoderV2(tok,
+			streaming_encoder_incremental.WithZeroCopyOutput(true),
+			streaming_encoder_incremental.WithCommitPolicy(streaming_encoder_incremental.CommitRankAware))
+	}},
+	{"adaptive", func(tok *core.Tokenizer) streamer {
+		return streaming_encoder_adaptive.NewAdaptiveEncoder(tok,
+			streaming_encoder_adaptive.WithZeroCopyOutput(true))
+	}},
+}
+
+// chunkSizes are the Push sizes benchmarked, 0 standing for the whole corpus in one Push.
+var chunkSizes = []int{16, 256, 4 << 10, 0}
+
+// BenchmarkEncoders streams each corpus through each encoder at each chunk size, one encoder reused across
+// iterations. Besides MB/s and allocs/op it reports ns/first-token, the time from the first Push to the
+// first ID out.
+func BenchmarkEncoders(b *testing.B) {
+	tok, err := core.LoadTokenizerFromFiles("../testdata/gpt2/vocab.json", "../testdata/gpt2/merges.txt")
+	if err != nil {
+		b.Fatalf("load tokenizer: %v", err)
+	}
+	text, err := os.ReadFile("../testdata/gpt2/bench_corpus.txt")
+	if err != nil {
+		b.Fatalf("read corpus: %v", err)
+	}
+	corpora := []struct {
+		name  string
+		input []byte
+	}{
+		{"text", text[:min(len(text), corpusLen)]},
+		// base64 has almost no junction a merge can't join, the worst case for committing early
+		{"base64", base64Corpus(corpusLen)},
+	}
+
+	for _, corpus := range corpora {
+		for _, enc := range encoders {
+			for _, chunkSize := range chunkSizes {
+				chunk := fmt.Sprintf("%dB", chunkSize)
+				if chunkSize == 0 {
+					chunkSize, chunk = len(corpus.input), "whole"
+				}
+				b.Run(corpus.name+"/"+enc.name+"/"+chunk, func(b *testing.B) {
+					benchmarkStream(b, enc.new(tok), corpus.input, chunkSize)
+				})
+			}
+		}
+	}
+}
+
+// TestEncoders_Agree checks that the benchmarks compare like with like: every encoder gives the offline
+// IDs for every corpus.
+func TestEncoders_Agree(t *testing.T) {
+	tok, err := core.LoadTokenizerFromFiles("../testdata/gpt2/vocab.json", "../testdata/gpt2/merges.txt")
+	if err != nil {
+		t.Fatalf("load tokenizer: %v", err)
+	}
+	text, err := os.ReadFile("../testdata/gpt2/bench_corpus.txt")
+	if err != nil {
+		t.Fatalf("read corpus: %v", err)
+	}
+
+	for _, input := range [][]byte{text[:min(len(text), 32<<10)], base64Corpus(32 << 10)} {
+		want := tok.EncodeOffline(input, nil)
+		for _, enc := range encoders {
+			s := enc.new(tok)
+			var got []int
+			for pos := 0; pos < len(input); pos += 256 {
+				got = append(got, s.Push(input[pos:min(pos+256, len(input))])...)
+			}
+			got = append(got, s.Flush()...)
+			if !slices.Equal(got, want) {
+				t.Fatalf("%s: got %d tokens, want %d", enc.name, len(got), len(want))
+			}
+		}
+	}
+}
+
+func benchmarkStream(b *testing.B, s streamer, input []byte, chunkSize int) {
+	b.SetBytes(int64(len(input)))
+	b.ReportAllocs()
+	b.ResetTimer()
+
+	var firstToken time.Duration
+	for i := 0; i < b.N; i++ {
+		start, waiting := time.Now(), true
+		for pos := 0; pos < len(input); pos += chunkSize {
+			if out := s.Push(input[pos:min(pos+chunkSize, len(input))]); waiting && len(out) > 0 {
+				firstToken += time.Since(start)
+				waiting = false
+			}
+		}
+		if out := s.Flush(); waiting && len(out) > 0 {
+			firstToken += time.Since(start)
+		}
+	}
+	b.ReportMetric(float64(firstToken.Nanoseconds())/float64(b.N), "ns/first-token")
+}
+
+func base64Corpus(n int) []byte {
+	raw := make([]byte, base64.StdEncoding.DecodedLen(n))
+	rand.New(rand.NewSource(1)).Read(raw)
+	return []byte(base64.StdEncoding.EncodeToString(raw))
+}
@@ -0,0 +1,41 @@
+package core
+
+import (
+	"errors"
+	"fmt"
+)
+
+// AlgorithmVersion identifies this build's encoding behavior: the IDs it produces for a given vocab, merges
+// and set of load options. It goes up whenever a change could make the same input encode differently, such
+// as a new pre-tokenizer default or a different tie-break in the merge loop, and stays put for pure
+// refactors and speedups. Systems that persist token IDs record it next to them.
+const AlgorithmVersion = 1
+
+// ErrAlgorithmVersion is returned by a load that pins an algorithm version other than this build's, or
+// reads a compiled tokenizer written under another one.
+var ErrAlgorithmVersion = errors.New("tokenizer algorithm version mismatch")
+
+// WithAlgorithmVersion pins the algorithm version, see LoadOptions.AlgorithmVersion.
+func WithAlgorithmVersion(v int) Option {
+	return func(o *LoadOptions) { o.AlgorithmVersion = v }
+}
+
+// AlgorithmVersion returns the algorithm version the tokenizer's tables were built under: the one recorded
+// in a compiled file, AlgorithmVersion for everything loaded from source files. Compiled files from before
+// versions were recorded count as version 1.
+func (t *Tokenizer) AlgorithmVersion() int {
+	return t.algorithmVersion
+}
+
+// checkAlgorithmVersion enforces LoadOptions.AlgorithmVersion.
+func (t *Tokenizer) checkAlgorithmVersion(want int) error {
+	switch {
+	case want == 0:
+		return nil
+	case want != AlgorithmVersion:
+		return fmt.Errorf("%w: pinned to %d, this build encodes as %d", ErrAlgorithmVersion, want, AlgorithmVersion)
+	case t.algorithmVersion != want:
+		return fmt.Errorf("%w: pinned to %d, tokenizer was compiled under %d", ErrAlgorithmVersion, want, t.algorithmVersion)
+	}
+	return nil
+}
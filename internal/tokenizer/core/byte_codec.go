@@ -0,0 +1,109 @@
+package core
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"unicode/utf8"
+)
+
+// ByteCodec is the byte-level scheme a vocab.json spells tokens in. JSON strings must be valid UTF-8, so
+// byte-level BPE vocabs store raw token bytes as text, and a codec says how. Merges are read in the same
+// spelling as the vocab.
+type ByteCodec interface {
+	// ByteText returns the vocab key of the single-byte token for b.
+	ByteText(b byte) string
+	// DecodeToken returns the raw bytes the vocab key s stands for.
+	DecodeToken(s string) ([]byte, error)
+	// EncodeToken returns the vocab key for a token holding b, the inverse of DecodeToken. It fails for
+	// bytes the scheme can't spell.
+	EncodeToken(b []byte) (string, error)
+}
+
+// GPT2ByteCodec is GPT-2's byte-level scheme and the default: printable Latin-1 bytes stand for
+// themselves and the rest are shifted to U+0100 and up, so a space is spelled "Ġ" and a newline "Ċ".
+// Runes outside the mapping are read as their UTF-8 bytes.
+func GPT2ByteCodec() ByteCodec {
+	return gpt2Codec()
+}
+
+var gpt2Codec = sync.OnceValue(func() *gpt2ByteCodec {
+	return &gpt2ByteCodec{encoder: buildCursedByteEncoder(), decoder: buildCursedByteDecoder()}
+})
+
+type gpt2ByteCodec struct {
+	encoder map[byte]rune
+	decoder map[rune]byte
+}
+
+func (c *gpt2ByteCodec) ByteText(b byte) string {
+	return string(c.encoder[b])
+}
+
+func (c *gpt2ByteCodec) DecodeToken(s string) ([]byte, error) {
+	return decodeTokenString(s, c.decoder)
+}
+
+func (c *gpt2ByteCodec) EncodeToken(b []byte) (string, error) {
+	return ByteLevelEncode(b), nil
+}
+
+// ByteLevelEncode spells b the way GPT-2's vocab.json does, one rune per byte, e.g. "Ġhello" for
+// " hello". Every byte sequence has a spelling, so tools writing vocab files or printing tokens for
+// debugging can use it on any token.
+func ByteLevelEncode(b []byte) string {
+	enc := gpt2Codec().encoder
+	var sb strings.Builder
+	sb.Grow(len(b) * 2)
+	for _, c := range b {
+		sb.WriteRune(enc[c])
+	}
+	return sb.String()
+}
+
+// ByteLevelDecode reverses ByteLevelEncode. Runes outside GPT-2's mapping are taken as their UTF-8
+// bytes, the way vocab.json keys are read on load.
+func ByteLevelDecode(s string) ([]byte, error) {
+	return gpt2Codec().DecodeToken(s)
+}
+
+// RawByteCodec is for vocabs without a byte-level scheme, whose keys are the token text itself. Single
+// bytes that can't stand as text on their own, ASCII space and controls and 0x7F-0xFF, are spelled as
+// "<0x20>" style byte fallback pieces; every other key is its own UTF-8 bytes. merges.txt separates tokens
+// with spaces, so longer tokens holding whitespace can be produced by a merge but can't take part in one.
+func RawByteCodec() ByteCodec {
+	return rawByteCodec{}
+}
+
+type rawByteCodec struct{}
+
+func rawBytePiece(b byte) bool {
+	return b <= ' ' || b >= 0x7F
+}
+
+func (rawByteCodec) ByteText(b byte) string {
+	if rawBytePiece(b) {
+		return fmt.Sprintf("<0x%02X>", b)
+	}
+	return string(rune(b))
+}
+
+func (rawByteCodec) EncodeToken(b []byte) (string, error) {
+	if len(b) == 1 && rawBytePiece(b[0]) {
+		return fmt.Sprintf("<0x%02X>", b[0]), nil
+	}
+	if !utf8.Valid(b) {
+		return "", fmt.Errorf("token %q isn't valid utf8 and has no raw spelling", b)
+	}
+	return string(b), nil
+}
+
+func (rawByteCodec) DecodeToken(s string) ([]byte, error) {
+	if b, ok := parseBytePiece(s); ok && rawBytePiece(b) {
+		return []byte{b}, nil
+	}
+	if !utf8.ValidString(s) {
+		return nil, fmt.Errorf("invalid utf8 in token string %q", s)
+	}
+	return []byte(s), nil
+}
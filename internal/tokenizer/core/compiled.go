@@ -0,0 +1,359 @@
+package core
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"os"
+	"runtime"
+	"slices"
+)
+
+// Compiled tokenizer file layout, all integers little-endian uint32 unless noted:
+//
+//	magic "BPETOKC\x00", version, algorithm version (since version 2), pre-tokenization (since version 3),
+//	flags (since version 4, bit 0 is AddPrefixSpace, bits 8-15 DigitGroup)
+//	vocabSize, dataLen, pairCount, maxRank, droppedMerges, maxMergeDepth, normalization, specialCount
+//	byteToToken[256], unicodeByteToToken[256] (int32)
+//	offs[vocabSize+1], data[dataLen] (bytes)
+//	pairCount x {left, right, rank, token}, sorted by rank
+//	specialCount x {id, len, bytes}
+//	CRC-32C of everything above
+//
+// It is the tokenizer's state after loading, so reading one back skips JSON parsing, merges validation and
+// the bytes-to-ID reverse map. The merge depth is still replayed, files whose maxMergeDepth disagrees with
+// their merges are rejected.
+const (
+	compiledMagic   = "BPETOKC\x00"
+	compiledVersion = 4
+)
+
+// compiledPrefixSpace is the flags bit for AddPrefixSpace, DigitGroup takes the byte from
+// compiledDigitGroupShift.
+const (
+	compiledPrefixSpace     = 1
+	compiledDigitGroupShift = 8
+)
+
+// ErrCompiledFormat is returned for data that isn't a compiled tokenizer this version can read, or that
+// fails its checksum or consistency checks.
+var ErrCompiledFormat = errors.New("bad compiled tokenizer")
+
+var crc32c = crc32.MakeTable(crc32.Castagnoli)
+
+// MarshalBinary encodes the tokenizer in the compiled format, see Compiled. SentencePiece tokenizers have
+// no compiled form.
+func (t *Tokenizer) MarshalBinary() ([]byte, error) {
+	if t.partial != nil {
+		return nil, fmt.Errorf("%w: sentencepiece character prefixes can't be compiled", ErrCompiledFormat)
+	}
+	keys := t.pairsByRank()
+
+	specials := make([]string, 0, len(t.specialTokens))
+	for text := range t.specialTokens {
+		specials = append(specials, text)
+	}
+	slices.SortFunc(specials, func(a, b string) int { return t.specialTokens[a] - t.specialTokens[b] })
+
+	var buf bytes.Buffer
+	buf.Grow(len(compiledMagic) + 4*(13+512+len(t.vocab.offs)+4*len(keys)) + len(t.vocab.data))
+
+	u32 := func(v int) { buf.Write(binary.LittleEndian.AppendUint32(nil, uint32(v))) }
+
+	buf.WriteString(compiledMagic)
+	u32(compiledVersion)
+	u32(t.algorithmVersion)
+	u32(int(t.preTokenization))
+	flags := 0
+	if t.addPrefixSpace {
+		flags |= compiledPrefixSpace
+	}
+	flags |= t.digitGroup << compiledDigitGroupShift
+	u32(flags)
+	for _, v := range []int{t.vocab.size(), len(t.vocab.data), len(keys), t.maxRank, t.droppedMerges,
+		t.maxMergeDepth, int(t.normalization), len(specials)} {
+		u32(v)
+	}
+	for _, table := range []*[256]int{&t.byteToToken, &t.unicodeByteToToken} {
+		for _, id := range table {
+			u32(int(int32(id)))
+		}
+	}
+	for _, off := range t.vocab.offs {
+		u32(int(off))
+	}
+	buf.Write(t.vocab.data)
+	for _, key := range keys {
+		u32(int(key >> 32))
+		u32(int(key & 0xFFFFFFFF))
+		u32(t.pairRank[key])
+		u32(t.pairToken[key])
+	}
+	for _, text := range specials {
+		u32(t.specialTokens[text])
+		u32(len(text))
+		buf.WriteString(text)
+	}
+
+	u32(int(crc32.Checksum(buf.Bytes(), crc32c)))
+	return buf.Bytes(), nil
+}
+
+type compiledSource struct {
+	path   string
+	data   []byte
+	borrow bool
+	mapped bool
+}
+
+// Compiled uses a tokenizer previously encoded with MarshalBinary. The data is checked against its
+// checksum and for internal consistency, then copied, so it can be reused afterwards. WithStrict has no
+// effect; WithSpecialTokens may only name tokens already in the vocab.
+func Compiled(data []byte) Source {
+	return compiledSource{data: data}
+}
+
+// CompiledBorrowed is Compiled without the copy: the tokenizer's vocab bytes alias data, which skips an
+// allocation the size of the vocab, worthwhile when data is a read-only memory mapping or a go:embed
+// string's bytes. The caller hands over data for the tokenizer's lifetime and must not modify it; the
+// tokenizer reports Owned() false until detached with Freeze.
+func CompiledBorrowed(data []byte) Source {
+	return compiledSource{data: data, borrow: true}
+}
+
+// CompiledFile reads a compiled tokenizer from disk, see Compiled.
+func CompiledFile(path string) Source {
+	return compiledSource{path: path}
+}
+
+// CompiledMapped memory-maps a compiled tokenizer file instead of reading it (reading it where mmap isn't
+// available). The vocab bytes stay in the mapping, so they take no heap and Decode copies straight out of
+// it; the rest is built on the heap as for Compiled. The mapping lives as long as the tokenizer: it is
+// released once the tokenizer, and everything built on it, is unreachable, so slices from TokenBytes must
+// not outlive those. The tokenizer reports Owned() false, Freeze copies the vocab out of the mapping. The
+// file must not be modified or truncated while it is mapped.
+func CompiledMapped(path string) Source {
+	return compiledSource{path: path, mapped: true}
+}
+
+func (s compiledSource) load(opts LoadOptions) (*Tokenizer, error) {
+	if s.mapped {
+		return s.loadMapped(opts)
+	}
+	data, alias := s.data, s.borrow
+	if s.path != "" {
+		var err error
+		if data, err = os.ReadFile(s.path); err != nil {
+			return nil, fmt.Errorf("error while reading compiled tokenizer : %w", err)
+		}
+		// nobody else holds the buffer ReadFile returned
+		alias = true
+	}
+
+	tok, special, norm, err := decodeCompiled(data, alias)
+	if err != nil {
+		return nil, err
+	}
+	tok.borrowed = s.borrow
+	return tok.finishCompiled(special, norm, opts)
+}
+
+// loadMapped is load for CompiledMapped.
+func (s compiledSource) loadMapped(opts LoadOptions) (*Tokenizer, error) {
+	data, unmap, err := mapFile(s.path)
+	if err != nil {
+		return nil, fmt.Errorf("error while mapping compiled tokenizer : %w", err)
+	}
+	tok, special, norm, err := decodeCompiled(data, true)
+	if err == nil {
+		tok.borrowed = true
+		tok, err = tok.finishCompiled(special, norm, opts)
+	}
+	if err != nil {
+		unmap()
+		return nil, err
+	}
+	// nothing but the arena refers to the mapping and only the tokenizer holds the arena, Freeze copies it
+	runtime.AddCleanup(tok, func(unmap func()) { unmap() }, unmap)
+	return tok, nil
+}
+
+// finishCompiled applies opts on top of what the compiled data recorded.
+func (t *Tokenizer) finishCompiled(special map[string]int, norm Normalization, opts LoadOptions) (*Tokenizer, error) {
+	for text, id := range opts.SpecialTokens {
+		if have, ok := special[text]; ok && have == id {
+			continue
+		}
+		if id < 0 || id >= t.vocab.size() || string(t.vocab.bytes(id)) != text {
+			return nil, fmt.Errorf("special token %q with id %d is not in the compiled vocab", text, id)
+		}
+		special[text] = id
+	}
+	opts.SpecialTokens = special
+	if opts.Normalization == NormalizeNone {
+		opts.Normalization = norm
+	}
+	if opts.PreTokenization == PreTokenizeNone {
+		opts.PreTokenization = t.preTokenization
+	}
+	opts.AddPrefixSpace = opts.AddPrefixSpace || t.addPrefixSpace
+	if opts.DigitGroup == 0 {
+		opts.DigitGroup = t.digitGroup
+	}
+
+	return t.finishLoad(opts)
+}
+
+// compiledReader walks the compiled layout, the first short read sticks in err.
+type compiledReader struct {
+	data []byte
+	pos  int
+	err  error
+}
+
+func (r *compiledReader) next(n int) []byte {
+	if r.err != nil {
+		return nil
+	}
+	if n < 0 || n > len(r.data)-r.pos {
+		r.err = fmt.Errorf("%w: truncated at offset %d", ErrCompiledFormat, r.pos)
+		return nil
+	}
+	b := r.data[r.pos : r.pos+n]
+	r.pos += n
+	return b
+}
+
+func (r *compiledReader) u32() int {
+	b := r.next(4)
+	if b == nil {
+		return 0
+	}
+	return int(binary.LittleEndian.Uint32(b))
+}
+
+// decodeCompiled validates data and builds the tokenizer. With alias set the vocab arena points into data
+// instead of a copy of it.
+func decodeCompiled(data []byte, alias bool) (*Tokenizer, map[string]int, Normalization, error) {
+	bad := func(format string, args ...any) error {
+		return fmt.Errorf("%w: %s", ErrCompiledFormat, fmt.Sprintf(format, args...))
+	}
+
+	if len(data) < len(compiledMagic)+8 || string(data[:len(compiledMagic)]) != compiledMagic {
+		return nil, nil, 0, bad("missing magic")
+	}
+	body := data[:len(data)-4]
+	if sum := binary.LittleEndian.Uint32(data[len(data)-4:]); crc32.Checksum(body, crc32c) != sum {
+		return nil, nil, 0, bad("checksum mismatch")
+	}
+
+	r := &compiledReader{data: body, pos: len(compiledMagic)}
+	v := r.u32()
+	if v < 1 || v > compiledVersion {
+		return nil, nil, 0, bad("version %d, this build reads up to %d", v, compiledVersion)
+	}
+	algorithm, pre := 1, PreTokenizeNone
+	if v >= 2 {
+		algorithm = r.u32()
+	}
+	if v >= 3 {
+		if pre = PreTokenization(r.u32()); !pre.valid() {
+			return nil, nil, 0, bad("unknown pre-tokenization %d", int(pre))
+		}
+	}
+	flags := 0
+	if v >= 4 {
+		if flags = r.u32(); flags&^(compiledPrefixSpace|maxDigitGroup<<compiledDigitGroupShift) != 0 {
+			return nil, nil, 0, bad("unknown flags %#x", flags)
+		}
+	}
+
+	vocabSize, dataLen, pairCount := r.u32(), r.u32(), r.u32()
+	maxRank, dropped, maxMergeDepth := r.u32(), r.u32(), r.u32()
+	norm, specialCount := Normalization(r.u32()), r.u32()
+
+	var tables [2][256]int
+	for i := range tables {
+		for j := range tables[i] {
+			id := int(int32(uint32(r.u32())))
+			if id < -1 || id >= vocabSize {
+				return nil, nil, 0, bad("byte table entry %d out of range", id)
+			}
+			tables[i][j] = id
+		}
+	}
+
+	// bound counts by what's left before allocating anything proportional to them
+	if rest := len(body) - r.pos; 4*(vocabSize+1) > rest || dataLen > rest || 16*pairCount > rest {
+		return nil, nil, 0, bad("counts exceed the data")
+	}
+
+	arena := vocabArena{offs: make([]uint32, vocabSize+1)}
+	for i := range arena.offs {
+		arena.offs[i] = uint32(r.u32())
+		if i > 0 && arena.offs[i] < arena.offs[i-1] {
+			return nil, nil, 0, bad("token offsets not ascending at %d", i)
+		}
+	}
+	if arena.offs[0] != 0 || int(arena.offs[vocabSize]) != dataLen {
+		return nil, nil, 0, bad("token offsets don't span the data")
+	}
+	arena.data = r.next(dataLen)
+	if !alias {
+		arena.data = slices.Clone(arena.data)
+	}
+
+	pairRank := make(map[uint64]int, pairCount)
+	pairToken := make(map[uint64]int, pairCount)
+	for range pairCount {
+		a, b, rank, c := r.u32(), r.u32(), r.u32(), r.u32()
+		if r.err != nil {
+			break
+		}
+		if a >= vocabSize || b >= vocabSize || c >= vocabSize || rank > maxRank {
+			return nil, nil, 0, bad("pair (%d, %d) -> %d rank %d out of range", a, b, c, rank)
+		}
+		if len(arena.bytes(c)) != len(arena.bytes(a))+len(arena.bytes(b)) ||
+			!bytes.HasPrefix(arena.bytes(c), arena.bytes(a)) || !bytes.HasSuffix(arena.bytes(c), arena.bytes(b)) {
+			return nil, nil, 0, bad("pair (%d, %d) doesn't concatenate to %d", a, b, c)
+		}
+		key := packPair(a, b)
+		if _, dup := pairRank[key]; dup {
+			return nil, nil, 0, bad("duplicate pair (%d, %d)", a, b)
+		}
+		pairRank[key], pairToken[key] = rank, c
+	}
+
+	special := make(map[string]int, min(specialCount, vocabSize))
+	for range specialCount {
+		id, n := r.u32(), r.u32()
+		text := string(r.next(n))
+		if r.err != nil {
+			break
+		}
+		if id >= vocabSize || string(arena.bytes(id)) != text {
+			return nil, nil, 0, bad("special token %q doesn't match id %d", text, id)
+		}
+		special[text] = id
+	}
+
+	if r.err != nil {
+		return nil, nil, 0, r.err
+	}
+	if r.pos != len(body) {
+		return nil, nil, 0, bad("%d trailing bytes", len(body)-r.pos)
+	}
+	// CommitGuard is sized from the depth, so it's checked against the merges rather than trusted
+	if depth := buildMaxMergeDepth(pairRank, pairToken); depth != maxMergeDepth {
+		return nil, nil, 0, bad("max merge depth %d, the merges give %d", maxMergeDepth, depth)
+	}
+
+	tok := assembleTokenizer(arena, tables[0], tables[1], pairRank, pairToken, maxRank, dropped, maxMergeDepth)
+	tok.algorithmVersion = algorithm
+	tok.preTokenization = pre
+	tok.addPrefixSpace = flags&compiledPrefixSpace != 0
+	tok.digitGroup = flags >> compiledDigitGroupShift
+	return tok, special, norm, nil
+}
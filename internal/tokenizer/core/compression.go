@@ -0,0 +1,40 @@
+package core
+
+import "math"
+
+// CompressionStats summarises how well a vocab compresses one input.
+type CompressionStats struct {
+	Bytes  int
+	Tokens int
+	// BytesPerToken is Bytes/Tokens, 0 for empty input.
+	BytesPerToken float64
+	// DistinctTokens is the number of different IDs emitted.
+	DistinctTokens int
+	// Entropy is the Shannon entropy of the emitted ID distribution in bits per token. It's a plug-in
+	// estimate from the sample, so it runs low on short inputs.
+	Entropy float64
+}
+
+// CompressionStats encodes input and reports its compression figures, handy for comparing vocabs over the
+// same sample documents.
+func (t *Tokenizer) CompressionStats(input []byte) CompressionStats {
+	counts := make(map[int]int)
+	total := 0
+	t.encodeFunc(input, func(id int) bool {
+		counts[id]++
+		total++
+		return true
+	})
+
+	st := CompressionStats{Bytes: len(input), Tokens: total, DistinctTokens: len(counts)}
+	if total == 0 {
+		return st
+	}
+
+	st.BytesPerToken = float64(len(input)) / float64(total)
+	for _, c := range counts {
+		p := float64(c) / float64(total)
+		st.Entropy -= p * math.Log2(p)
+	}
+	return st
+}
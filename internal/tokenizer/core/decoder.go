@@ -1,6 +1,26 @@
 package core
 
-// Decode a given sequence of tokens to a sequence of bytes
+import (
+	"errors"
+	"fmt"
+	"io"
+	"unicode/utf8"
+)
+
+// ErrDecodeLimit is returned by DecodeLimited when the output would be longer than allowed.
+var ErrDecodeLimit = errors.New("decoded output exceeds limit")
+
+// ErrInvalidTokenID is reported by StreamingDecoder.Err for IDs that aren't tokens of the vocab, see ValidID.
+var ErrInvalidTokenID = errors.New("invalid token id")
+
+// ValidID reports whether id is a token of the vocab: in range, and not a hole left by a vocab loaded
+// with WithVocabHoles.
+func (t *Tokenizer) ValidID(id int) bool {
+	// every real token has at least one byte
+	return id >= 0 && id < t.vocab.size() && t.vocab.tokenLen(id) > 0
+}
+
+// Decode a given sequence of tokens to a sequence of bytes. Holes in the vocab (see ValidID) decode to nothing.
 func (t *Tokenizer) Decode(tokens []int) []byte {
 	if len(tokens) == 0 {
 		return nil
@@ -8,17 +28,220 @@ func (t *Tokenizer) Decode(tokens []int) []byte {
 
 	total := 0
 	for _, id := range tokens {
-		if id < 0 || id >= len(t.RevVocab) {
+		if id < 0 || id >= t.vocab.size() {
 			panic("token id out of range while decoding")
 		}
 
-		total += len(t.RevVocab[id])
+		total += t.vocab.tokenLen(id)
 	}
 
 	out := make([]byte, 0, total)
 	for _, id := range tokens {
-		out = append(out, t.RevVocab[id]...)
+		out = append(out, t.vocab.bytes(id)...)
 	}
 
 	return out
 }
+
+// DecodeLimited is Decode with a cap on the output size, for token arrays from untrusted callers: a few
+// million max-length tokens would otherwise expand to gigabytes. If the output would exceed maxBytes it
+// returns the longest prefix of whole tokens that fits together with ErrDecodeLimit, so callers can either
+// fail or keep the truncated text. Lengths are summed before anything is allocated and the scan stops at
+// the limit. Panics on out of range IDs, like Decode.
+func (t *Tokenizer) DecodeLimited(tokens []int, maxBytes int) ([]byte, error) {
+	total, n := 0, 0
+	for _, id := range tokens {
+		if id < 0 || id >= t.vocab.size() {
+			panic("token id out of range while decoding")
+		}
+
+		l := t.vocab.tokenLen(id)
+		if total+l > maxBytes {
+			break
+		}
+		total += l
+		n++
+	}
+
+	out := t.Decode(tokens[:n])
+	if n < len(tokens) {
+		return out, ErrDecodeLimit
+	}
+	return out, nil
+}
+
+// decodeToBuf is how many bytes DecodeTo gathers before each Write.
+const decodeToBuf = 4 << 10
+
+// DecodeTo writes the bytes of tokens to w in writes of about decodeToBuf bytes, so the whole output is
+// never held in memory. It returns the number of bytes written and the first write error. Panics on out of
+// range IDs, like Decode.
+func (t *Tokenizer) DecodeTo(w io.Writer, tokens []int) (int, error) {
+	buf := make([]byte, 0, decodeToBuf)
+	written := 0
+	for _, id := range tokens {
+		if id < 0 || id >= t.vocab.size() {
+			panic("token id out of range while decoding")
+		}
+
+		b := t.vocab.bytes(id)
+		if len(buf)+len(b) > decodeToBuf && len(buf) > 0 {
+			n, err := w.Write(buf)
+			written += n
+			if err != nil {
+				return written, err
+			}
+			buf = buf[:0]
+		}
+		buf = append(buf, b...)
+	}
+
+	if len(buf) > 0 {
+		n, err := w.Write(buf)
+		return written + n, err
+	}
+	return written, nil
+}
+
+// StreamingDecoder decodes token IDs incrementally. Byte-level BPE happily splits a multi-byte UTF-8
+// character across tokens, so decoding token by token can produce half a character; the decoder holds
+// those trailing bytes back until the rest of the character arrives. Every Feed therefore returns whole
+// characters (plus any bytes that were never valid UTF-8 to begin with, those are passed straight through
+// unless WithReplacement is on).
+type StreamingDecoder struct {
+	tok     *Tokenizer
+	replace bool
+	// special is set by WithSpecialText, specialText is what each special token then decodes to
+	special     bool
+	specialText []byte
+
+	out     []byte
+	pending [utf8.UTFMax]byte
+	n       int
+	// scratch is the second buffer replacement swaps out with
+	scratch []byte
+
+	// err stops the stream, flushed makes the next Feed start a new one
+	err     error
+	flushed bool
+}
+
+// DecoderOption configures a StreamingDecoder.
+type DecoderOption func(*StreamingDecoder)
+
+// WithReplacement makes the decoder hand out valid UTF-8 only: invalid bytes in Feed's output, and the
+// incomplete sequence Flush returns, come out as U+FFFD, see AppendValidUTF8. The concatenated output of
+// a stream is then AppendValidUTF8 of its Decode, however the IDs were split across Feeds.
+func WithReplacement(on bool) DecoderOption {
+	return func(d *StreamingDecoder) { d.replace = on }
+}
+
+// WithSpecialText makes every special token (see IsSpecial) decode to text instead of its own text, so
+// control markers such as <|endoftext|> stay out of user-facing output; an empty text drops them. The
+// decoder keeps text.
+func WithSpecialText(text []byte) DecoderOption {
+	return func(d *StreamingDecoder) { d.special, d.specialText = true, text }
+}
+
+// NewStreamingDecoder returns a decoder over t.
+func NewStreamingDecoder(t *Tokenizer, opts ...DecoderOption) *StreamingDecoder {
+	d := &StreamingDecoder{tok: t}
+	for _, opt := range opts {
+		opt(d)
+	}
+	return d
+}
+
+// Feed decodes tokens and returns the bytes that are safe to hand out. The returned slice is reused by the
+// next call, copy it if you need to keep it. An ID that fails ValidID stops the stream: Feed returns what
+// the IDs before it decode to, Err reports it, and later Feeds return nothing until Flush.
+func (d *StreamingDecoder) Feed(tokens []int) []byte {
+	if d.flushed {
+		d.err, d.flushed = nil, false
+	}
+	if d.err != nil {
+		return nil
+	}
+
+	d.out = append(d.out[:0], d.pending[:d.n]...)
+	d.n = 0
+
+	for i, id := range tokens {
+		if !d.tok.ValidID(id) {
+			d.err = fmt.Errorf("%w: %d at position %d of the chunk", ErrInvalidTokenID, id, i)
+			break
+		}
+		if d.special && d.tok.IsSpecial(id) {
+			d.out = append(d.out, d.specialText...)
+			continue
+		}
+		d.out = append(d.out, d.tok.vocab.bytes(id)...)
+	}
+
+	if cut := incompleteSuffix(d.out); cut < len(d.out) {
+		d.n = copy(d.pending[:], d.out[cut:])
+		d.out = d.out[:cut]
+	}
+	if d.replace && !utf8.Valid(d.out) {
+		d.scratch = AppendValidUTF8(d.scratch[:0], d.out)
+		d.out, d.scratch = d.scratch, d.out
+	}
+
+	if len(d.out) == 0 {
+		return nil
+	}
+	return d.out
+}
+
+// Flush returns the bytes still held back and resets the decoder. They are an incomplete UTF-8 sequence,
+// replaced by U+FFFD under WithReplacement.
+func (d *StreamingDecoder) Flush() []byte {
+	d.flushed = true
+	if d.n == 0 {
+		return nil
+	}
+	if d.replace {
+		d.out = AppendValidUTF8(d.out[:0], d.pending[:d.n])
+	} else {
+		d.out = append(d.out[:0], d.pending[:d.n]...)
+	}
+	d.n = 0
+	return d.out
+}
+
+// Err returns the error that stopped the stream, or nil. It is kept through Flush and cleared by the
+// first Feed after it.
+func (d *StreamingDecoder) Err() error {
+	return d.err
+}
+
+// AppendValidUTF8 appends b to dst with every byte that isn't part of a valid UTF-8 character replaced by
+// U+FFFD, one per byte as ranging over a string does. Unlike bytes.ToValidUTF8, which merges a run of
+// invalid bytes into one, the result doesn't depend on where b was split, so streamed and whole decodes
+// agree.
+func AppendValidUTF8(dst, b []byte) []byte {
+	for len(b) > 0 {
+		r, size := utf8.DecodeRune(b)
+		if r == utf8.RuneError && size == 1 {
+			dst = utf8.AppendRune(dst, utf8.RuneError)
+		} else {
+			dst = append(dst, b[:size]...)
+		}
+		b = b[size:]
+	}
+	return dst
+}
+
+// incompleteSuffix returns the index where a trailing, still completable UTF-8 sequence starts, or len(b)
+// if b doesn't end in one. Invalid bytes are never held back since no future byte can fix them.
+func incompleteSuffix(b []byte) int {
+	for i := len(b) - 1; i >= 0 && i >= len(b)-utf8.UTFMax+1; i-- {
+		if utf8.RuneStart(b[i]) {
+			if !utf8.FullRune(b[i:]) {
+				return i
+			}
+			break
+		}
+	}
+	return len(b)
+}
@@ -18,6 +18,51 @@ func (t *Tokenizer) EncodeOffline(input []byte, state *BaseEncoderState) []int {
 		return nil
 	}
 
+	out := make([]int, 0, n)
+	t.encodeFunc(input, func(id int) bool {
+		out = append(out, id)
+		return true
+	})
+
+	return out
+}
+
+// CountTokens returns how many tokens input encodes to, i.e. len(EncodeOffline(input, nil)), without
+// building the output slice. The merge loop runs on pooled scratch, so a warm tokenizer doesn't allocate
+// per token.
+func (t *Tokenizer) CountTokens(input []byte) int {
+	n := 0
+	t.encodeFunc(input, func(int) bool {
+		n++
+		return true
+	})
+	return n
+}
+
+// encodeFunc runs the offline merge loop over input, split by the tokenizer's pre-tokenizer, and hands
+// every final token ID to emit, in order, without building an output slice. Returning false from emit
+// stops early.
+func (t *Tokenizer) encodeFunc(input []byte, emit func(int) bool) {
+	if t.preTokenization == PreTokenizeNone {
+		t.mergeFunc(input, emit)
+		return
+	}
+	for start := 0; start < len(input); {
+		end := start + t.splitLen(input[start:])
+		if !t.mergeFunc(input[start:end], emit) {
+			return
+		}
+		start = end
+	}
+}
+
+// mergeFunc runs the merge loop over one split. It returns false if emit stopped it.
+func (t *Tokenizer) mergeFunc(input []byte, emit func(int) bool) bool {
+	n := len(input)
+	if n == 0 {
+		return true
+	}
+
 	scratch := t.acquireScratch(n)
 	defer t.releaseScratch(scratch)
 
@@ -44,7 +89,7 @@ func (t *Tokenizer) EncodeOffline(input []byte, state *BaseEncoderState) []int {
 		liveVersion[i] = 0
 	}
 
-	h := utils.NewBucketQueue(t.maxRank)
+	h := scratch.queue
 
 	pushIfMergeable := func(i int) {
 		j := next[i]
@@ -130,12 +175,30 @@ func (t *Tokenizer) EncodeOffline(input []byte, state *BaseEncoderState) []int {
 		pushIfMergeable(i)
 	}
 
-	out := make([]int, 0, n)
 	for i := head; i != -1; i = next[i] {
-		out = append(out, tokens[i])
+		if parts, ok := t.partial[tokens[i]]; ok {
+			for _, id := range parts {
+				if !emit(id) {
+					return false
+				}
+			}
+			continue
+		}
+		if !emit(tokens[i]) {
+			return false
+		}
 	}
+	return true
+}
 
-	return out
+// AppendToken appends a token the merge loop settled on to out. That's id itself, except for the
+// character prefix tokens of a SentencePiece model whose character never completed, which are spelled out
+// as their byte tokens. Encoders with their own merge loop must emit through it.
+func (t *Tokenizer) AppendToken(out []int, id int) []int {
+	if parts, ok := t.partial[id]; ok {
+		return append(out, parts...)
+	}
+	return append(out, id)
 }
 
 type encodeScratch struct {
@@ -143,22 +206,29 @@ type encodeScratch struct {
 	prev   []int
 	next   []int
 	live   []int
+	queue  *utils.BucketQueue
 }
 
 func (t *Tokenizer) acquireScratch(n int) *encodeScratch {
-	v := t.scratchPool.Get()
-	var sc *encodeScratch
-	if v == nil {
-		sc = &encodeScratch{}
+	sc := t.scratchPool.get()
+	if sc == nil {
+		sc = &encodeScratch{queue: utils.NewBucketQueue(t.maxRank)}
 	} else {
-		sc = v.(*encodeScratch)
+		sc.queue.Reset()
 	}
 	sc.prepare(n)
 	return sc
 }
 
 func (t *Tokenizer) releaseScratch(sc *encodeScratch) {
-	t.scratchPool.Put(sc)
+	t.scratchPool.put(sc)
+}
+
+// shrink drops the linked list arrays and trims the queue's buckets so the item holds scratch for at most
+// maxLen bytes of input. The next prepare grows them back as needed.
+func (sc *encodeScratch) shrink(maxLen int) {
+	sc.tokens, sc.prev, sc.next, sc.live = nil, nil, nil, nil
+	sc.queue.Trim(maxLen)
 }
 
 func (sc *encodeScratch) prepare(n int) {
@@ -0,0 +1,85 @@
+package core
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"slices"
+)
+
+// fingerprintTag starts the hashed data, so a change to what goes into the hash changes every fingerprint
+// instead of colliding with old ones.
+const fingerprintTag = "bpetok fingerprint 1\x00"
+
+// Fingerprint returns a hex SHA-256 over everything that decides which IDs the tokenizer produces: the
+// bytes of every token in ID order, the merges in rank order, the special tokens, the normalization form,
+// the prefix space and the pre-tokenizer. Two tokenizers with the same fingerprint encode alike, however
+// they were loaded (from files or a compiled copy, say), so it serves as a cache key and as a check that
+// client and server share a vocab.
+// It does not cover the algorithm version, compare AlgorithmVersion for that.
+func (t *Tokenizer) Fingerprint() string {
+	t.fingerprintOnce.Do(func() {
+		h := sha256.New()
+		var buf []byte
+		uv := func(v int) { buf = binary.AppendUvarint(buf, uint64(v)) }
+		flush := func() {
+			h.Write(buf)
+			buf = buf[:0]
+		}
+
+		buf = append(buf, fingerprintTag...)
+		uv(t.vocab.size())
+		for id := range t.vocab.size() {
+			b := t.vocab.bytes(id)
+			uv(len(b))
+			buf = append(buf, b...)
+			if len(buf) >= 32<<10 {
+				flush()
+			}
+		}
+
+		keys := t.pairsByRank()
+		uv(len(keys))
+		for _, key := range keys {
+			uv(int(key >> 32))
+			uv(int(key & 0xFFFFFFFF))
+			uv(t.pairRank[key])
+			uv(t.pairToken[key])
+			if len(buf) >= 32<<10 {
+				flush()
+			}
+		}
+
+		specials := make([]string, 0, len(t.specialTokens))
+		for text := range t.specialTokens {
+			specials = append(specials, text)
+		}
+		slices.Sort(specials)
+		uv(len(specials))
+		for _, text := range specials {
+			uv(len(text))
+			buf = append(buf, text...)
+			uv(t.specialTokens[text])
+		}
+
+		uv(int(t.normalization))
+		if t.preTokenization != PreTokenizeNone || t.addPrefixSpace || t.digitGroup > 0 {
+			// appended only when set, so fingerprints from before these options existed stay valid
+			uv(int(t.preTokenization))
+		}
+		switch {
+		case t.digitGroup > 0:
+			prefix := 0
+			if t.addPrefixSpace {
+				prefix = 1
+			}
+			uv(prefix)
+			uv(t.digitGroup)
+		case t.addPrefixSpace:
+			uv(1)
+		}
+		flush()
+		t.fingerprint = hex.EncodeToString(h.Sum(nil))
+	})
+	return t.fingerprint
+}
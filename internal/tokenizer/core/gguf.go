@@ -0,0 +1,356 @@
+package core
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"strings"
+)
+
+// ErrUnsupportedGGUF is returned for a GGUF file whose tokenizer this package can't reproduce, e.g. a
+// WordPiece (bert) or unigram (t5) vocab, or a file from before GGUF version 2.
+var ErrUnsupportedGGUF = errors.New("unsupported gguf tokenizer")
+
+const ggufMagic = "GGUF"
+
+// GGUF metadata value types.
+const (
+	ggufUint8 = iota
+	ggufInt8
+	ggufUint16
+	ggufInt16
+	ggufUint32
+	ggufInt32
+	ggufFloat32
+	ggufBool
+	ggufString
+	ggufArray
+	ggufUint64
+	ggufInt64
+	ggufFloat64
+)
+
+// ggufMaxLen caps string lengths and array counts, a corrupt header shouldn't make us allocate gigabytes.
+const ggufMaxLen = 1 << 28
+
+// ggufPrealloc bounds how many array elements are allocated up front, before they've actually been read.
+const ggufPrealloc = 1 << 20
+
+// ggufTokenizer is the tokenizer.ggml.* metadata of a GGUF file.
+type ggufTokenizer struct {
+	model          string
+	pre            string
+	tokens         []string
+	scores         []float32
+	tokenTypes     []int
+	merges         []string
+	addSpacePrefix bool
+}
+
+type ggufSource struct {
+	path string
+	data []byte
+}
+
+// GGUFFile reads the tokenizer tables from a GGUF model file, see GGUFBytes. Only the metadata at the
+// start of the file is read, the tensors are never touched.
+func GGUFFile(path string) Source {
+	return ggufSource{path: path}
+}
+
+// GGUFBytes reads the tokenizer tables from GGUF data, as written by llama.cpp's converters. data only
+// needs to hold the header and metadata, a prefix of the file is enough.
+//
+// A "gpt2" tokenizer (tokenizer.ggml.model) is a byte-level BPE vocab: tokens and merges are loaded like
+// vocab.json and merges.txt, control tokens are reported by SpecialTokens and tokenizer.ggml.pre is logged
+// as not applied. A "llama" tokenizer is a SentencePiece BPE vocab and loads as described on
+// SentencePieceBytes, with scores and token types taken from the metadata. Any other model is
+// ErrUnsupportedGGUF.
+func GGUFBytes(data []byte) Source {
+	return ggufSource{data: data}
+}
+
+func (s ggufSource) load(opts LoadOptions) (*Tokenizer, error) {
+	var r io.Reader = bytes.NewReader(s.data)
+	source := "<bytes>"
+	if s.path != "" {
+		f, err := os.Open(s.path)
+		if err != nil {
+			return nil, fmt.Errorf("error while reading gguf file : %w", err)
+		}
+		defer f.Close()
+		r, source = f, s.path
+	}
+
+	gt, err := readGGUFTokenizer(bufio.NewReaderSize(r, 1<<16))
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", source, err)
+	}
+
+	switch gt.model {
+	case "gpt2":
+		return gt.loadByteLevel(source, opts)
+	case "llama":
+		return gt.loadSentencePiece(source, opts)
+	case "":
+		return nil, fmt.Errorf("%s: %w: no tokenizer.ggml.model", source, ErrUnsupportedGGUF)
+	default:
+		return nil, fmt.Errorf("%s: %w: model %s", source, ErrUnsupportedGGUF, gt.model)
+	}
+}
+
+// loadByteLevel treats the tokens as vocab.json entries. Tokens after the last normal one are appended
+// the way tokenizer.json's added_tokens are, since their text is stored raw rather than byte-level encoded.
+func (gt *ggufTokenizer) loadByteLevel(source string, opts LoadOptions) (*Tokenizer, error) {
+	if gt.pre != "" && gt.pre != "default" {
+		opts.logger().Printf("bpetok: %s: pre-tokenizer %s is not applied, merges run over the raw input", source, gt.pre)
+	}
+
+	end := len(gt.tokens)
+	if len(gt.tokenTypes) == len(gt.tokens) {
+		for end > 0 && gt.tokenTypes[end-1] != spNormal && gt.tokenTypes[end-1] != spByte {
+			end--
+		}
+	}
+
+	vocab := make(map[string]int, end)
+	added := make(map[string]int)
+	special := make(map[string]int)
+	for id, text := range gt.tokens {
+		if id < end {
+			vocab[text] = id
+		} else {
+			added[text] = id
+		}
+		if id < len(gt.tokenTypes) && gt.tokenTypes[id] == spControl {
+			special[text] = id
+		}
+	}
+	if len(vocab) != end {
+		return nil, fmt.Errorf("%s: duplicate tokens in tokenizer.ggml.tokens", source)
+	}
+	for text, id := range opts.SpecialTokens {
+		special[text] = id
+	}
+	opts.SpecialTokens = special
+
+	return buildTokenizer(vocab, added, gt.merges, source, opts)
+}
+
+func (gt *ggufTokenizer) loadSentencePiece(source string, opts LoadOptions) (*Tokenizer, error) {
+	if len(gt.scores) != len(gt.tokens) || len(gt.tokenTypes) != len(gt.tokens) {
+		return nil, fmt.Errorf("%s: %d tokens but %d scores and %d token types", source, len(gt.tokens), len(gt.scores), len(gt.tokenTypes))
+	}
+
+	m := &spModel{
+		pieces:         make([]spPiece, len(gt.tokens)),
+		modelType:      spModelBPE,
+		addDummyPrefix: gt.addSpacePrefix,
+		escapeWS:       true,
+	}
+	for i, text := range gt.tokens {
+		m.pieces[i] = spPiece{text: text, score: gt.scores[i], typ: gt.tokenTypes[i]}
+	}
+	return m.load(source, opts)
+}
+
+// readGGUFTokenizer reads the header and metadata, keeping the tokenizer.ggml.* keys.
+func readGGUFTokenizer(r *bufio.Reader) (*ggufTokenizer, error) {
+	var magic [4]byte
+	if _, err := io.ReadFull(r, magic[:]); err != nil || string(magic[:]) != ggufMagic {
+		return nil, fmt.Errorf("not a gguf file")
+	}
+
+	gr := ggufReader{r: r}
+	version := gr.u32()
+	if gr.err == nil && version < 2 {
+		return nil, fmt.Errorf("%w: version %d", ErrUnsupportedGGUF, version)
+	}
+	gr.u64() // tensor count
+	kvs := gr.u64()
+
+	gt := &ggufTokenizer{addSpacePrefix: true}
+	for i := uint64(0); i < kvs && gr.err == nil; i++ {
+		key := gr.str()
+		typ := gr.u32()
+		if !strings.HasPrefix(key, "tokenizer.ggml.") {
+			gr.skip(typ)
+			continue
+		}
+
+		switch strings.TrimPrefix(key, "tokenizer.ggml.") {
+		case "model":
+			gt.model = gr.strValue(key, typ)
+		case "pre":
+			gt.pre = gr.strValue(key, typ)
+		case "add_space_prefix":
+			gt.addSpacePrefix = gr.boolValue(key, typ)
+		case "tokens":
+			gt.tokens = gr.strArray(key, typ)
+		case "merges":
+			gt.merges = gr.strArray(key, typ)
+		case "scores":
+			gr.array(key, typ, ggufFloat32, func(n uint64) {
+				gt.scores = make([]float32, 0, min(n, ggufPrealloc))
+			}, func() {
+				gt.scores = append(gt.scores, math.Float32frombits(gr.u32()))
+			})
+		case "token_type":
+			gr.array(key, typ, ggufInt32, func(n uint64) {
+				gt.tokenTypes = make([]int, 0, min(n, ggufPrealloc))
+			}, func() {
+				gt.tokenTypes = append(gt.tokenTypes, int(int32(gr.u32())))
+			})
+		default:
+			gr.skip(typ)
+		}
+	}
+	if gr.err != nil {
+		return nil, fmt.Errorf("malformed gguf metadata: %w", gr.err)
+	}
+	if len(gt.tokens) == 0 {
+		return nil, fmt.Errorf("%w: no tokenizer.ggml.tokens", ErrUnsupportedGGUF)
+	}
+	return gt, nil
+}
+
+// ggufReader reads little-endian GGUF values, remembering the first error so callers can check once.
+type ggufReader struct {
+	r   *bufio.Reader
+	buf [8]byte
+	err error
+}
+
+func (gr *ggufReader) read(n int) []byte {
+	if gr.err != nil {
+		return gr.buf[:n]
+	}
+	if _, err := io.ReadFull(gr.r, gr.buf[:n]); err != nil {
+		gr.err = fmt.Errorf("truncated: %w", err)
+	}
+	return gr.buf[:n]
+}
+
+func (gr *ggufReader) u32() uint32 { return binary.LittleEndian.Uint32(gr.read(4)) }
+func (gr *ggufReader) u64() uint64 { return binary.LittleEndian.Uint64(gr.read(8)) }
+
+func (gr *ggufReader) length() int {
+	n := gr.u64()
+	if gr.err == nil && n > ggufMaxLen {
+		gr.err = fmt.Errorf("length %d is implausible", n)
+	}
+	if gr.err != nil {
+		return 0
+	}
+	return int(n)
+}
+
+func (gr *ggufReader) str() string {
+	n := gr.length()
+	if gr.err != nil {
+		return ""
+	}
+	b := make([]byte, n)
+	if _, err := io.ReadFull(gr.r, b); err != nil {
+		gr.err = fmt.Errorf("truncated: %w", err)
+	}
+	return string(b)
+}
+
+func (gr *ggufReader) discard(n int) {
+	if gr.err != nil {
+		return
+	}
+	if _, err := gr.r.Discard(n); err != nil {
+		gr.err = fmt.Errorf("truncated: %w", err)
+	}
+}
+
+// ggufSizes is the width of each fixed-size value type.
+var ggufSizes = map[uint32]int{
+	ggufUint8: 1, ggufInt8: 1, ggufBool: 1,
+	ggufUint16: 2, ggufInt16: 2,
+	ggufUint32: 4, ggufInt32: 4, ggufFloat32: 4,
+	ggufUint64: 8, ggufInt64: 8, ggufFloat64: 8,
+}
+
+// skip reads past a value of type typ.
+func (gr *ggufReader) skip(typ uint32) {
+	switch typ {
+	case ggufString:
+		gr.discard(gr.length())
+	case ggufArray:
+		elem := gr.u32()
+		n := gr.length()
+		if size, ok := ggufSizes[elem]; ok {
+			gr.discard(n * size)
+			return
+		}
+		for i := 0; i < n && gr.err == nil; i++ {
+			gr.skip(elem)
+		}
+	default:
+		size, ok := ggufSizes[typ]
+		if !ok && gr.err == nil {
+			gr.err = fmt.Errorf("unknown value type %d", typ)
+		}
+		gr.discard(size)
+	}
+}
+
+func (gr *ggufReader) wrongType(key string, typ uint32) {
+	if gr.err == nil {
+		gr.err = fmt.Errorf("%s has value type %d", key, typ)
+	}
+}
+
+func (gr *ggufReader) strValue(key string, typ uint32) string {
+	if typ != ggufString {
+		gr.wrongType(key, typ)
+		return ""
+	}
+	return gr.str()
+}
+
+func (gr *ggufReader) boolValue(key string, typ uint32) bool {
+	if typ != ggufBool {
+		gr.wrongType(key, typ)
+		return false
+	}
+	return gr.read(1)[0] != 0
+}
+
+// array reads an array of elem values, calling start with the count and then next once per element.
+func (gr *ggufReader) array(key string, typ, elem uint32, start func(n uint64), next func()) {
+	if typ != ggufArray {
+		gr.wrongType(key, typ)
+		return
+	}
+	if got := gr.u32(); got != elem {
+		gr.wrongType(key+"[]", got)
+		return
+	}
+	n := gr.length()
+	if gr.err != nil {
+		return
+	}
+	start(uint64(n))
+	for i := 0; i < n && gr.err == nil; i++ {
+		next()
+	}
+}
+
+func (gr *ggufReader) strArray(key string, typ uint32) []string {
+	var out []string
+	gr.array(key, typ, ggufString, func(n uint64) {
+		out = make([]string, 0, min(n, ggufPrealloc))
+	}, func() {
+		out = append(out, gr.str())
+	})
+	return out
+}
@@ -0,0 +1,64 @@
+package core
+
+import (
+	"slices"
+	"sort"
+)
+
+// CommitGuard is MaxMergeDepth times the longest token's length, the hold-back the streaming encoders use
+// by default. It is a size, not a bound on what appended input can change: with ranks that pair tokens up
+// from the right ("y z" before "x y" before "w x" ...) one more byte re-pairs a whole run, however shallow
+// the merges. Exactness has to come from junctions no merge can join, see CanJoin.
+func (t *Tokenizer) CommitGuard() int {
+	return max(t.maxMergeDepth, 1) * t.MaxTokenByteLen
+}
+
+// TokenHeal returns the encoding of prefix's bytes followed by continuation, given prefix as already
+// encoded (say, a cached prompt). Trailing prefix tokens are backed off until the bytes either side of the
+// cut can't be joined by any merge (see CanJoin), then the dropped bytes plus continuation are re-encoded:
+// nothing merges across such a junction, so the tokens in front of it stay what the whole text encodes to.
+// For most vocabularies that is the last token or two, though a run whose every junction can be joined is
+// backed off whole. Only that tail is re-encoded, the rest of prefix is reused as is and not validated; out
+// of range IDs in the tail panic like in Decode.
+//
+// With a pre-tokenizer the cut goes after the last split of prefix that continuation can't change
+// instead, which takes decoding all of prefix to find.
+func (t *Tokenizer) TokenHeal(prefix []int, continuation []byte) []int {
+	if t.preTokenization != PreTokenizeNone {
+		return t.healSplits(prefix, continuation)
+	}
+	if len(continuation) == 0 {
+		return slices.Clone(prefix)
+	}
+
+	k, right := len(prefix), continuation[0]
+	for k > 0 {
+		left := t.TokenBytes(prefix[k-1])
+		if len(left) > 0 && !t.CanJoin(left[len(left)-1], right) {
+			break
+		}
+		k--
+		if b := t.TokenBytes(prefix[k]); len(b) > 0 {
+			right = b[0]
+		}
+	}
+
+	tail := append(t.Decode(prefix[k:]), continuation...)
+	out := make([]int, k, k+len(tail))
+	copy(out, prefix[:k])
+	return append(out, t.EncodeOffline(tail, nil)...)
+}
+
+// healSplits is TokenHeal for a pre-tokenized encoding: tokens never cross a split, so everything before
+// the final splits of prefix stays as it is.
+func (t *Tokenizer) healSplits(prefix []int, continuation []byte) []int {
+	text := t.Decode(prefix)
+	cut := t.preTokenization.FinalLen(text)
+	offs := t.TokenOffsets(prefix)
+	k := sort.SearchInts(offs, cut+1) - 1
+
+	tail := append(text[offs[k]:], continuation...)
+	out := make([]int, k, k+len(tail))
+	copy(out, prefix[:k])
+	return append(out, t.EncodeOffline(tail, nil)...)
+}
@@ -0,0 +1,194 @@
+package core
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"maps"
+	"os"
+	"path"
+	"slices"
+)
+
+type hfDirSource struct {
+	fsys  fs.FS
+	dir   string
+	label string
+}
+
+// HFDir reads a Hugging Face model directory from disk, see HFDirFS.
+func HFDir(dir string) Source {
+	return hfDirSource{fsys: os.DirFS(dir), dir: ".", label: dir}
+}
+
+// HFDirFS reads the tokenizer files of a Hugging Face model directory at dir inside fsys. tokenizer.json
+// is used when present and loads as described on TokenizerJSONBytes; otherwise vocab.json and merges.txt
+// are, with the tokens of an optional added_tokens.json appended like tokenizer.json's added_tokens.
+//
+// An optional special_tokens_map.json marks tokens special: each one must be in the vocab or the added
+// tokens. Its roles (bos_token, eos_token, unk_token, ...) can then be looked up with SpecialTokenID;
+// additional_special_tokens are special without a role.
+func HFDirFS(fsys fs.FS, dir string) Source {
+	return hfDirSource{fsys: fsys, dir: dir, label: dir}
+}
+
+func (s hfDirSource) load(opts LoadOptions) (*Tokenizer, error) {
+	roles, extra, err := s.readSpecialTokensMap()
+	if err != nil {
+		return nil, err
+	}
+
+	var tok *Tokenizer
+	if data, err := s.readOptional("tokenizer.json"); err != nil {
+		return nil, err
+	} else if data != nil {
+		tok, err = tokenizerJSONSource{data: data, label: s.file("tokenizer.json")}.load(opts)
+		if err != nil {
+			return nil, err
+		}
+	} else if tok, err = s.loadVocabMerges(opts); err != nil {
+		return nil, err
+	}
+
+	special := maps.Clone(tok.specialTokens)
+	if special == nil {
+		special = make(map[string]int)
+	}
+	roleIDs := make(map[string]int, len(roles))
+	resolve := func(text string) (int, error) {
+		if id, ok := special[text]; ok {
+			return id, nil
+		}
+		for id := range tok.vocab.size() {
+			if string(tok.vocab.bytes(id)) == text {
+				special[text] = id
+				return id, nil
+			}
+		}
+		return 0, fmt.Errorf("%s: special token %q is in neither the vocab nor the added tokens", s.file("special_tokens_map.json"), text)
+	}
+	// sorted so the error for a bad map doesn't depend on map order
+	for _, role := range slices.Sorted(maps.Keys(roles)) {
+		id, err := resolve(roles[role])
+		if err != nil {
+			return nil, err
+		}
+		roleIDs[role] = id
+	}
+	for _, text := range extra {
+		if _, err := resolve(text); err != nil {
+			return nil, err
+		}
+	}
+
+	tok.setSpecialTokens(special)
+	tok.specialRoles = roleIDs
+	return tok, nil
+}
+
+func (s hfDirSource) file(name string) string {
+	return path.Join(s.label, name)
+}
+
+// readOptional returns nil, nil for a file that doesn't exist.
+func (s hfDirSource) readOptional(name string) ([]byte, error) {
+	data, err := fs.ReadFile(s.fsys, path.Join(s.dir, name))
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error while reading %s : %w", name, err)
+	}
+	return data, nil
+}
+
+func (s hfDirSource) loadVocabMerges(opts LoadOptions) (*Tokenizer, error) {
+	data, err := fs.ReadFile(s.fsys, path.Join(s.dir, "vocab.json"))
+	if err != nil {
+		return nil, fmt.Errorf("error while reading vocab file : %w", err)
+	}
+	vocab, err := ParseVocabJSON(data)
+	if err != nil {
+		return nil, fmt.Errorf("error while unmarshalling vocab: %w", err)
+	}
+
+	f, err := s.fsys.Open(path.Join(s.dir, "merges.txt"))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read mergs: %w", err)
+	}
+	defer f.Close()
+	mergesLines, err := scanLines(f)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read mergs: %w", err)
+	}
+
+	var added map[string]int
+	if data, err := s.readOptional("added_tokens.json"); err != nil {
+		return nil, err
+	} else if data != nil {
+		if err := json.Unmarshal(data, &added); err != nil {
+			return nil, fmt.Errorf("error while unmarshalling added_tokens.json: %w", err)
+		}
+	}
+
+	return buildTokenizer(vocab, added, mergesLines, s.file("merges.txt"), opts)
+}
+
+// readSpecialTokensMap returns the role -> text entries of special_tokens_map.json and the texts of its
+// additional_special_tokens. Entries are either plain strings or AddedToken objects with a content field.
+func (s hfDirSource) readSpecialTokensMap() (map[string]string, []string, error) {
+	data, err := s.readOptional("special_tokens_map.json")
+	if err != nil || data == nil {
+		return nil, nil, err
+	}
+	bad := func(err error) error {
+		return fmt.Errorf("error while unmarshalling special_tokens_map.json: %w", err)
+	}
+
+	var raw map[string]json.RawMessage
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return nil, nil, bad(err)
+	}
+	roles := make(map[string]string, len(raw))
+	var extra []string
+	for key, msg := range raw {
+		if key == "additional_special_tokens" {
+			var list []json.RawMessage
+			if err := json.Unmarshal(msg, &list); err != nil {
+				return nil, nil, bad(err)
+			}
+			for _, m := range list {
+				text, err := specialTokenText(m)
+				if err != nil {
+					return nil, nil, bad(err)
+				}
+				extra = append(extra, text)
+			}
+			continue
+		}
+		text, err := specialTokenText(msg)
+		if err != nil {
+			return nil, nil, bad(fmt.Errorf("%s: %w", key, err))
+		}
+		roles[key] = text
+	}
+	return roles, extra, nil
+}
+
+func specialTokenText(msg json.RawMessage) (string, error) {
+	var text string
+	if err := json.Unmarshal(msg, &text); err == nil {
+		return text, nil
+	}
+	var obj struct {
+		Content *string `json:"content"`
+	}
+	if err := json.Unmarshal(msg, &obj); err != nil {
+		return "", err
+	}
+	if obj.Content == nil {
+		return "", errors.New("token object has no content")
+	}
+	return *obj.Content, nil
+}
@@ -0,0 +1,54 @@
+package core
+
+import (
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// InternedStrings returns a printable string for every token ID, index i for token i. Printable UTF-8 is
+// kept as is; invalid bytes (a token holding half a character, say) come out as \xNN and other
+// non-printable runes as Go escapes like \n or \u200b. The mapping is lossy, a token that really contains
+// `\n` prints the same as a newline, so use it for display and analytics, never to decode.
+//
+// The table is built on first call and shared afterwards: all strings are substrings of one buffer and the
+// slice is the same on every call, so don't modify it.
+func (t *Tokenizer) InternedStrings() []string {
+	t.internOnce.Do(func() {
+		var sb strings.Builder
+		sb.Grow(len(t.vocab.data) + len(t.vocab.data)/4)
+
+		ends := make([]int, t.vocab.size())
+		for id := range ends {
+			appendPrintable(&sb, t.vocab.bytes(id))
+			ends[id] = sb.Len()
+		}
+
+		all := sb.String()
+		t.interned = make([]string, len(ends))
+		start := 0
+		for id, end := range ends {
+			t.interned[id] = all[start:end]
+			start = end
+		}
+	})
+	return t.interned
+}
+
+func appendPrintable(sb *strings.Builder, b []byte) {
+	for len(b) > 0 {
+		r, size := utf8.DecodeRune(b)
+		switch {
+		case r == utf8.RuneError && size == 1:
+			sb.WriteString(`\x`)
+			sb.WriteString(strconv.FormatUint(uint64(b[0])|0x100, 16)[1:])
+		case unicode.IsPrint(r):
+			sb.Write(b[:size])
+		default:
+			q := strconv.QuoteRune(r)
+			sb.WriteString(q[1 : len(q)-1])
+		}
+		b = b[size:]
+	}
+}
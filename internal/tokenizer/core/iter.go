@@ -0,0 +1,44 @@
+package core
+
+import "iter"
+
+// EncodeSeq is EncodeOffline as an iterator. The merge loop still runs over the whole input up front
+// (BPE can't know a token is final before that), but the IDs are yielded straight from the scratch list
+// so callers that only range over them never pay for an output slice.
+func (t *Tokenizer) EncodeSeq(input []byte) iter.Seq[int] {
+	return func(yield func(int) bool) {
+		t.encodeFunc(input, yield)
+	}
+}
+
+// Vocab is a read-only view over a tokenizer's vocabulary.
+type Vocab struct {
+	t *Tokenizer
+}
+
+// Vocab returns a read-only view over the vocabulary.
+func (t *Tokenizer) Vocab() Vocab {
+	return Vocab{t: t}
+}
+
+// Len returns the number of token IDs.
+func (v Vocab) Len() int {
+	return v.t.vocab.size()
+}
+
+// Bytes returns the bytes of token id, nil if out of range. The slice aliases the vocab, don't modify it.
+func (v Vocab) Bytes(id int) []byte {
+	return v.t.TokenBytes(id)
+}
+
+// All yields every (id, bytes) pair in ID order. The byte slices alias the vocab, don't modify them.
+func (v Vocab) All() iter.Seq2[int, []byte] {
+	return func(yield func(int, []byte) bool) {
+		a := &v.t.vocab
+		for id := 0; id < a.size(); id++ {
+			if !yield(id, a.bytes(id)) {
+				return
+			}
+		}
+	}
+}
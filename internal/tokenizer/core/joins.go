@@ -0,0 +1,21 @@
+package core
+
+// CanJoin reports whether some merge joins a token ending in byte left to one starting with right. If not,
+// no token ever spans a left|right junction in the input, so the bytes on either side encode the same on
+// their own as together, whatever surrounds them: a streaming encoder can commit everything in front of
+// such a junction at once.
+func (t *Tokenizer) CanJoin(left, right byte) bool {
+	t.joinsOnce.Do(func() {
+		t.joins = new([256 * 256 / 64]uint64)
+		for key := range t.pairRank {
+			a, b := t.TokenBytes(int(key>>32)), t.TokenBytes(int(key&0xFFFFFFFF))
+			if len(a) == 0 || len(b) == 0 {
+				continue
+			}
+			i := int(a[len(a)-1])<<8 | int(b[0])
+			t.joins[i/64] |= 1 << (i % 64)
+		}
+	})
+	i := int(left)<<8 | int(right)
+	return t.joins[i/64]&(1<<(i%64)) != 0
+}
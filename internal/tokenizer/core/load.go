@@ -0,0 +1,398 @@
+package core
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/fs"
+	"log"
+	"maps"
+	"os"
+	"slices"
+)
+
+// Source is where a tokenizer's vocabulary comes from, see Files, Bytes, TiktokenFile and TiktokenBytes.
+//
+// Ownership: a Source only reads the byte slices and readers handed to it, and only while Load runs; don't
+// modify them concurrently with Load. The tokenizer Load returns owns copies of everything it keeps, so the
+// caller may reuse or overwrite its buffers afterwards. CompiledBorrowed is the one exception, see Owned.
+type Source interface {
+	load(opts LoadOptions) (*Tokenizer, error)
+}
+
+// Option adjusts LoadOptions for Load.
+type Option func(*LoadOptions)
+
+// Load builds a tokenizer from src. It is the one entry point every loader knob hangs off, new capabilities
+// get a new Option rather than another LoadTokenizerFromXWithY constructor.
+func Load(src Source, opts ...Option) (*Tokenizer, error) {
+	var o LoadOptions
+	for _, opt := range opts {
+		opt(&o)
+	}
+	return src.load(o)
+}
+
+// WithStrict fails the load on any validation problem (the default). Passing false loads what is usable
+// and logs the rest instead, see LoadOptions.Lenient.
+func WithStrict(strict bool) Option {
+	return func(o *LoadOptions) { o.Lenient = !strict }
+}
+
+// WithVocabHoles accepts vocabs with unused IDs instead of failing the load, see
+// LoadOptions.AllowVocabHoles.
+func WithVocabHoles(allow bool) Option {
+	return func(o *LoadOptions) { o.AllowVocabHoles = allow }
+}
+
+// WithLogger sends load warnings to l instead of log.Default().
+func WithLogger(l *log.Logger) Option {
+	return func(o *LoadOptions) { o.Logger = l }
+}
+
+// WithNormalization records the normalization form encoders built on the tokenizer apply to their input.
+func WithNormalization(n Normalization) Option {
+	return func(o *LoadOptions) { o.Normalization = n }
+}
+
+// WithAddPrefixSpace records that encoders put a space in front of their input, see
+// LoadOptions.AddPrefixSpace.
+func WithAddPrefixSpace(on bool) Option {
+	return func(o *LoadOptions) { o.AddPrefixSpace = on }
+}
+
+// WithPreTokenization sets the pre-tokenizer encoding applies, see LoadOptions.PreTokenization.
+func WithPreTokenization(p PreTokenization) Option {
+	return func(o *LoadOptions) { o.PreTokenization = p }
+}
+
+// WithDigitGroup cuts runs of digits into groups of at most n during pre-tokenization, see
+// LoadOptions.DigitGroup.
+func WithDigitGroup(n int) Option {
+	return func(o *LoadOptions) { o.DigitGroup = n }
+}
+
+// WithByteCodec sets the byte-level scheme vocab keys are spelled in, see LoadOptions.ByteCodec.
+func WithByteCodec(c ByteCodec) Option {
+	return func(o *LoadOptions) { o.ByteCodec = c }
+}
+
+// WithSpecialTokens registers special tokens, see LoadOptions.SpecialTokens.
+func WithSpecialTokens(special map[string]int) Option {
+	return func(o *LoadOptions) { o.SpecialTokens = maps.Clone(special) }
+}
+
+// WithMemoryBudget caps the estimated footprint of the loaded tokenizer in bytes.
+func WithMemoryBudget(n int64) Option {
+	return func(o *LoadOptions) { o.MemoryBudget = n }
+}
+
+// WithMaxRank leaves merges ranked above n out of the merge table, see LoadOptions.MaxRank.
+func WithMaxRank(n int) Option {
+	return func(o *LoadOptions) { o.MaxRank = n }
+}
+
+// WithScratchPoolLimits bounds the encode scratch the tokenizer keeps, overriding SetScratchPoolLimits for
+// it, see LoadOptions.ScratchPool.
+func WithScratchPoolLimits(l ScratchPoolLimits) Option {
+	l.MaxItemLen = max(l.MaxItemLen, 0)
+	l.MaxItems = max(l.MaxItems, 0)
+	return func(o *LoadOptions) { o.ScratchPool = &l }
+}
+
+// WithSubstringIndex builds the TokensContaining index at load, see LoadOptions.SubstringIndex.
+func WithSubstringIndex(build bool) Option {
+	return func(o *LoadOptions) { o.SubstringIndex = build }
+}
+
+type fileSource struct{ vocabPath, mergesPath string }
+
+// Files reads a GPT-2 style vocab.json and merges.txt from disk. Raw bytes may be spelled either as GPT-2
+// unicode stand-ins or as "<0x0A>" style byte fallback pieces.
+func Files(vocabPath, mergesPath string) Source {
+	return fileSource{vocabPath, mergesPath}
+}
+
+func (s fileSource) load(opts LoadOptions) (*Tokenizer, error) {
+	return LoadTokenizerFromFilesWithOptions(s.vocabPath, s.mergesPath, opts)
+}
+
+type bytesSource struct{ vocab, merges []byte }
+
+// Bytes uses in-memory vocab.json and merges.txt contents. Neither slice is retained past Load.
+func Bytes(vocab, merges []byte) Source {
+	return bytesSource{vocab, merges}
+}
+
+func (s bytesSource) load(opts LoadOptions) (*Tokenizer, error) {
+	return LoadTokenizerFromBytesWithOptions(s.vocab, s.merges, opts)
+}
+
+type readerSource struct{ vocab, merges io.Reader }
+
+// Readers reads vocab.json and merges.txt from r, e.g. an http.Response body or a zip entry. Load reads
+// both to EOF and doesn't close them.
+func Readers(vocab, merges io.Reader) Source {
+	return readerSource{vocab, merges}
+}
+
+func (s readerSource) load(opts LoadOptions) (*Tokenizer, error) {
+	return loadTokenizerFromReaders(s.vocab, s.merges, "<reader>", opts)
+}
+
+type fsSource struct {
+	fsys                  fs.FS
+	vocabPath, mergesPath string
+}
+
+// FS reads vocab.json and merges.txt out of fsys, such as an embed.FS, a zip.Reader or an fstest.MapFS.
+// Paths follow fs.FS rules: slash separated and unrooted.
+func FS(fsys fs.FS, vocabPath, mergesPath string) Source {
+	return fsSource{fsys, vocabPath, mergesPath}
+}
+
+func (s fsSource) load(opts LoadOptions) (*Tokenizer, error) {
+	vocab, err := fs.ReadFile(s.fsys, s.vocabPath)
+	if err != nil {
+		return nil, fmt.Errorf("error while reading vocab file : %w", err)
+	}
+
+	f, err := s.fsys.Open(s.mergesPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read mergs: %w", err)
+	}
+	defer f.Close()
+
+	return loadTokenizerFromReaders(bytes.NewReader(vocab), f, s.mergesPath, opts)
+}
+
+type tiktokenSource struct {
+	path string
+	data []byte
+}
+
+// TiktokenFile reads a tiktoken rank file from disk.
+func TiktokenFile(path string) Source {
+	return tiktokenSource{path: path}
+}
+
+// TiktokenBytes uses in-memory tiktoken rank file contents.
+func TiktokenBytes(data []byte) Source {
+	return tiktokenSource{data: data}
+}
+
+func (s tiktokenSource) load(opts LoadOptions) (*Tokenizer, error) {
+	data := s.data
+	if s.path != "" {
+		var err error
+		if data, err = os.ReadFile(s.path); err != nil {
+			return nil, fmt.Errorf("error while reading tiktoken file : %w", err)
+		}
+	}
+	return loadTiktoken(data, opts)
+}
+
+func loadTokenizerFromReaders(vocab, merges io.Reader, mergesSource string, opts LoadOptions) (*Tokenizer, error) {
+	data, err := io.ReadAll(vocab)
+	if err != nil {
+		return nil, fmt.Errorf("error while reading vocab file : %w", err)
+	}
+
+	mergesLines, err := scanLines(merges)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read mergs: %w", err)
+	}
+
+	return loadTokenizer(data, mergesLines, mergesSource, opts)
+}
+
+// appendSpecialTokens checks the special tokens against revVocab and appends the ones past its end. With
+// allowHoles they may also fill empty IDs and leave gaps past the end, see LoadOptions.AllowVocabHoles.
+func appendSpecialTokens(revVocab [][]byte, special map[string]int, allowHoles bool) ([][]byte, error) {
+	base := len(revVocab)
+
+	var extra []string
+	for text, id := range special {
+		switch {
+		case text == "":
+			return nil, fmt.Errorf("empty special token")
+		case id < 0:
+			return nil, fmt.Errorf("special token %q has negative id %d", text, id)
+		case id < base && allowHoles && len(revVocab[id]) == 0:
+			revVocab[id] = []byte(text)
+		case id < base:
+			if string(revVocab[id]) != text {
+				return nil, fmt.Errorf("special token %q has id %d, which decodes to %q", text, id, revVocab[id])
+			}
+		default:
+			extra = append(extra, text)
+		}
+	}
+
+	slices.SortFunc(extra, func(a, b string) int { return special[a] - special[b] })
+	for i, text := range extra {
+		id := special[text]
+		if allowHoles && id > len(revVocab) {
+			if id > maxHoleyVocab(base+len(extra)) {
+				return nil, fmt.Errorf("special token %q has id %d, which leaves too large a gap", text, id)
+			}
+			revVocab = append(revVocab, make([][]byte, id-len(revVocab))...)
+		}
+		if id != len(revVocab) {
+			if allowHoles {
+				return nil, fmt.Errorf("special tokens %q and %q share id %d", extra[i-1], text, id)
+			}
+			return nil, fmt.Errorf("special token %q has id %d, want %d to keep the vocab dense", text, id, base+i)
+		}
+		revVocab = append(revVocab, []byte(text))
+	}
+	return revVocab, nil
+}
+
+// maxHoleyVocab bounds the IDs of a vocab with holes holding n tokens, so a stray huge ID fails the load
+// rather than allocating a table for it.
+func maxHoleyVocab(n int) int {
+	return 4*n + 256
+}
+
+// finishLoad records what LoadOptions asks for on a freshly built tokenizer.
+func (t *Tokenizer) finishLoad(opts LoadOptions) (*Tokenizer, error) {
+	if err := t.checkAlgorithmVersion(opts.AlgorithmVersion); err != nil {
+		return nil, err
+	}
+	if !opts.PreTokenization.valid() {
+		return nil, fmt.Errorf("unknown pre-tokenization %d", int(opts.PreTokenization))
+	}
+	switch {
+	case opts.DigitGroup < 0 || opts.DigitGroup > maxDigitGroup:
+		return nil, fmt.Errorf("digit group %d out of range [0, %d]", opts.DigitGroup, maxDigitGroup)
+	case opts.DigitGroup > 0 && opts.PreTokenization == PreTokenizeNone:
+		return nil, fmt.Errorf("digit group %d needs a pre-tokenizer", opts.DigitGroup)
+	}
+	if opts.MaxRank > 0 {
+		t.capRanks(opts.MaxRank)
+	}
+	t.preTokenization = opts.PreTokenization
+	t.digitGroup = opts.DigitGroup
+	t.normalization = opts.Normalization
+	t.addPrefixSpace = opts.AddPrefixSpace
+	t.setSpecialTokens(maps.Clone(opts.SpecialTokens))
+	t.scratchPool.limits = opts.ScratchPool
+
+	var indexBytes int64
+	if opts.SubstringIndex {
+		t.substrAtLoad = true
+		indexBytes = 4 * int64(len(t.substringIndex().pos))
+	}
+
+	if opts.MemoryBudget > 0 {
+		if n := t.MemoryFootprint() + indexBytes; n > opts.MemoryBudget {
+			return nil, fmt.Errorf("%w: about %d bytes, budget %d", ErrMemoryBudget, n, opts.MemoryBudget)
+		}
+	}
+	return t, nil
+}
+
+// Owned reports whether the tokenizer owns all of its memory. Only a tokenizer loaded with
+// CompiledBorrowed or CompiledMapped doesn't: its vocab bytes alias the caller's buffer, which must stay
+// unmodified for as long as the tokenizer (or anything built on it) is in use, or the file mapping, which
+// lives as long as the tokenizer.
+func (t *Tokenizer) Owned() bool {
+	return !t.borrowed
+}
+
+// Freeze returns a tokenizer that owns all of its memory: t itself if it already does, otherwise a copy
+// whose vocab bytes are detached from the borrowed buffer. The copy shares t's read-only pair tables, so
+// it's cheap, and after Freeze the buffer handed to CompiledBorrowed may be reused as long as t itself is
+// no longer used.
+func (t *Tokenizer) Freeze() *Tokenizer {
+	if !t.borrowed {
+		return t
+	}
+
+	// Every field is either copied or deliberately left to start fresh (the scratch pool and the caches
+	// built on first use); TestFreeze_CoversEveryField fails for a field added without deciding which.
+	frozen := &Tokenizer{
+		vocab:                vocabArena{data: slices.Clone(t.vocab.data), offs: t.vocab.offs},
+		byteToToken:          t.byteToToken,
+		unicodeByteToToken:   t.unicodeByteToToken,
+		pairRank:             t.pairRank,
+		pairToken:            t.pairToken,
+		pairInfo:             t.pairInfo,
+		pairLookup:           t.pairLookup,
+		maxMergeDepth:        t.maxMergeDepth,
+		MaxTokenByteLen:      t.MaxTokenByteLen,
+		maxRank:              t.maxRank,
+		droppedMerges:        t.droppedMerges,
+		capped:               t.capped,
+		algorithmVersion:     t.algorithmVersion,
+		scratchPool:          scratchPool{limits: t.scratchPool.limits},
+		partial:              t.partial,
+		normalization:        t.normalization,
+		addPrefixSpace:       t.addPrefixSpace,
+		preTokenization:      t.preTokenization,
+		digitGroup:           t.digitGroup,
+		specialTokens:        t.specialTokens,
+		specialIDs:           t.specialIDs,
+		specialRoles:         t.specialRoles,
+		UseUnicodeInitTokens: t.UseUnicodeInitTokens,
+	}
+	if t.substrAtLoad {
+		// the index holds arena offsets, not bytes, so it still fits the copied arena
+		frozen.substrOnce.Do(func() { frozen.substr = t.substringIndex() })
+		frozen.substrAtLoad = true
+	}
+	return frozen
+}
+
+// Normalization returns the normalization form recorded at load time.
+func (t *Tokenizer) Normalization() Normalization {
+	return t.normalization
+}
+
+// AddPrefixSpace reports whether encoders put a space in front of their input, see
+// LoadOptions.AddPrefixSpace.
+func (t *Tokenizer) AddPrefixSpace() bool {
+	return t.addPrefixSpace
+}
+
+// SpecialTokens returns a copy of the special tokens registered at load time.
+func (t *Tokenizer) SpecialTokens() map[string]int {
+	return maps.Clone(t.specialTokens)
+}
+
+// setSpecialTokens records special, which t keeps.
+func (t *Tokenizer) setSpecialTokens(special map[string]int) {
+	t.specialTokens = special
+	t.specialIDs = make(map[int]bool, len(special))
+	for _, id := range special {
+		t.specialIDs[id] = true
+	}
+}
+
+// IsSpecial reports whether id is one of the special tokens.
+func (t *Tokenizer) IsSpecial(id int) bool {
+	return t.specialIDs[id]
+}
+
+// SpecialTokenID returns the ID of a special token by role, such as "eos_token" from a
+// special_tokens_map.json (see HFDirFS), or by its text. Roles aren't kept by MarshalBinary, texts are.
+func (t *Tokenizer) SpecialTokenID(name string) (int, bool) {
+	if id, ok := t.specialRoles[name]; ok {
+		return id, true
+	}
+	id, ok := t.specialTokens[name]
+	return id, ok
+}
+
+// MemoryFootprint estimates the bytes held by the tokenizer's lookup structures. Map entries are costed at
+// 32 bytes, roughly what the Go runtime spends on a uint64 key and a word sized value including overhead.
+func (t *Tokenizer) MemoryFootprint() int64 {
+	const mapEntry = 32
+
+	cfg := t.pairLookup.Config()
+	n := int64(len(t.vocab.data)) + 4*int64(len(t.vocab.offs))
+	n += mapEntry * int64(len(t.pairRank)+len(t.pairToken)+len(t.pairInfo)+cfg.FallbackPairs)
+	n += 8 * int64(cfg.Size) * int64(cfg.Size)
+	return n
+}
@@ -0,0 +1,121 @@
+package core
+
+import (
+	"errors"
+	"log"
+)
+
+// ErrTruncatedMerges is returned when merges.txt stops making sense part way through, which is what an
+// interrupted download usually looks like: a merge references a token that no earlier merge (or base byte)
+// could have produced, or the last line is cut in half.
+var ErrTruncatedMerges = errors.New("merges file looks truncated")
+
+// ErrVocabNotDense is returned for a vocab whose IDs have gaps when neither AllowVocabHoles nor Lenient is
+// set.
+var ErrVocabNotDense = errors.New("vocab ids are not dense")
+
+// ErrMalformedMerges is returned for a merges line that isn't two tokens separated by spaces. It comes
+// wrapped in ErrTruncatedMerges only for the last line of the file, which is where a cut off download ends.
+var ErrMalformedMerges = errors.New("malformed merges line")
+
+// ErrMergeUnknownToken is returned for a merges line naming a token, or producing one, that isn't in the
+// vocab. It comes wrapped in ErrTruncatedMerges, since a line cut in half usually looks like this.
+var ErrMergeUnknownToken = errors.New("merge names a token missing from the vocab")
+
+// ErrDuplicateMerge is returned when a merges file lists the same pair twice.
+var ErrDuplicateMerge = errors.New("duplicate merge")
+
+// ErrMemoryBudget is returned when a loaded tokenizer would not fit LoadOptions.MemoryBudget.
+var ErrMemoryBudget = errors.New("tokenizer exceeds memory budget")
+
+// LoadOptions tweaks how a tokenizer is loaded. The zero value is the strict default.
+type LoadOptions struct {
+	// AllowTruncatedMerges keeps the valid prefix of a truncated merges file instead of failing.
+	// The tokenizer still round trips, it just merges less than the real model would.
+	AllowTruncatedMerges bool
+
+	// Lenient loads slightly imperfect community vocabs instead of failing: malformed merges lines, lines
+	// naming unknown tokens and repeats of an earlier merge are skipped one by one, a truncated merges file
+	// keeps its valid prefix as with AllowTruncatedMerges, and a vocab with gaps loads as with
+	// AllowVocabHoles. Everything skipped is logged and counted in Stats().DroppedMerges. Left false, each of these fails the load with
+	// ErrMalformedMerges, ErrMergeUnknownToken, ErrDuplicateMerge, ErrTruncatedMerges or ErrVocabNotDense.
+	Lenient bool
+
+	// AllowVocabHoles accepts a vocab whose IDs have gaps, such as reserved IDs a fine-tune never filled
+	// in. A missing ID decodes to nothing and is never produced by encoding; ValidID reports it.
+	AllowVocabHoles bool
+
+	// Logger receives load warnings. Defaults to log.Default().
+	Logger *log.Logger
+
+	// Normalization is the Unicode normalization callers should apply before encoding, recorded on the
+	// tokenizer for encoders to pick up. Encoding methods on Tokenizer itself always see raw bytes.
+	Normalization Normalization
+
+	// PreTokenization splits the input with a regex before merging, so tokens never cross its splits, for
+	// IDs that match the model's reference implementation. PreTokenizeNone, the zero value, merges over the
+	// raw input.
+	PreTokenization PreTokenization
+
+	// DigitGroup, when positive, cuts every run of digits (\p{N}) into groups of at most this many from the
+	// start of the run, on top of PreTokenization's splits, which it requires: 1 gives every digit a split
+	// of its own, as Llama and the Digits pre-tokenizer do, 3 gives GPT-2 the grouping cl100k's regex has
+	// built in. A leading space GPT-2 attaches to a number stays with its first group. It can only make
+	// splits finer, so 3 or more changes nothing under cl100k and o200k. At most 255.
+	DigitGroup int
+
+	// AddPrefixSpace puts a space in front of input that doesn't start with one, after normalization, so
+	// the first word encodes like every other ("Hello" as " Hello"), the add_prefix_space of GPT-2 and
+	// RoBERTa style tokenizer configs. Like Normalization it is recorded for encoders to pick up.
+	AddPrefixSpace bool
+
+	// ByteCodec is the byte-level scheme vocab.json keys and merges are spelled in, GPT2ByteCodec when nil.
+	// Formats that store raw bytes (tiktoken, SentencePiece, compiled) don't use it.
+	ByteCodec ByteCodec
+
+	// SpecialTokens maps special token text to its ID. IDs inside the vocab must decode to exactly that
+	// text; IDs past the end extend the vocab and must follow on from it without gaps, the way tiktoken
+	// numbers <|endoftext|> and friends after the ranks.
+	SpecialTokens map[string]int
+
+	// SubstringIndex builds the TokensContaining index during load instead of on its first call, and
+	// counts it against MemoryBudget.
+	SubstringIndex bool
+
+	// AlgorithmVersion, when non-zero, is strict reproducibility: the load fails with ErrAlgorithmVersion
+	// unless this build's AlgorithmVersion and, for a compiled tokenizer, the version recorded in it are
+	// both exactly this one. Pin it where token IDs are persisted, so an upgrade that would change them
+	// stops at startup instead of silently mixing old and new IDs.
+	AlgorithmVersion int
+
+	// MemoryBudget fails the load with ErrMemoryBudget when the tokenizer's estimated footprint exceeds it.
+	// Zero means no limit.
+	MemoryBudget int64
+
+	// MaxRank, when positive, leaves every merge ranked above it out of the merge table, so encoding
+	// behaves like a model trained with fewer merges; the vocab keeps their tokens, which then never come
+	// out of Encode. Encoders with warnings on report a "capped-rank" Warning where a left out merge would
+	// have joined two output tokens, see CappedRank. Stats().CappedMerges counts them. MarshalBinary keeps
+	// the capped table but not what was left out, so a compiled copy encodes the same and reports no capped
+	// ranks.
+	MaxRank int
+
+	// ScratchPool bounds the encode scratch the tokenizer keeps between calls. Nil follows the process wide
+	// limits, SetScratchPoolLimits, which keep up to 4 items of 64 KiB of input each by default; set it to
+	// give one tokenizer its own, e.g. more for many concurrent encodes of long inputs.
+	ScratchPool *ScratchPoolLimits
+}
+
+func (o LoadOptions) byteCodec() ByteCodec {
+	if o.ByteCodec != nil {
+		return o.ByteCodec
+	}
+	return GPT2ByteCodec()
+}
+
+func (o LoadOptions) logger() *log.Logger {
+	if o.Logger != nil {
+		return o.Logger
+	}
+	return log.Default()
+}
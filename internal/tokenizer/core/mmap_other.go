@@ -0,0 +1,14 @@
+//go:build !unix
+
+package core
+
+import "os"
+
+// mapFile reads path into memory where there is no mmap, the GC then owns data.
+func mapFile(path string) (data []byte, unmap func(), err error) {
+	data, err = os.ReadFile(path)
+	if err != nil {
+		return nil, nil, err
+	}
+	return data, func() {}, nil
+}
@@ -0,0 +1,182 @@
+package core
+
+import (
+	"bytes"
+	"fmt"
+
+	"golang.org/x/text/unicode/norm"
+)
+
+// Normalization selects the Unicode normalization applied to input before it is tokenized.
+// Raw bytes (NormalizeNone) is the default, byte-level BPE doesn't need normalization to round trip.
+type Normalization int
+
+const (
+	NormalizeNone Normalization = iota
+	NormalizeNFC
+	NormalizeNFKC
+)
+
+func (n Normalization) String() string {
+	switch n {
+	case NormalizeNone:
+		return "none"
+	case NormalizeNFC:
+		return "nfc"
+	case NormalizeNFKC:
+		return "nfkc"
+	default:
+		return fmt.Sprintf("normalization(%d)", int(n))
+	}
+}
+
+func (n Normalization) form() (norm.Form, bool) {
+	switch n {
+	case NormalizeNFC:
+		return norm.NFC, true
+	case NormalizeNFKC:
+		return norm.NFKC, true
+	default:
+		return 0, false
+	}
+}
+
+// Apply returns b normalized, or b itself for NormalizeNone.
+func (n Normalization) Apply(b []byte) []byte {
+	f, ok := n.form()
+	if !ok {
+		return b
+	}
+	return f.Bytes(b)
+}
+
+// ApplyMapped is Apply that also maps offsets in the result back to b: orig has len(out)+1 entries, the
+// offset in b each offset in out comes from. Where normalization changed a segment (a starter and the marks
+// composed with it), offsets inside it map to its start in b.
+func (n Normalization) ApplyMapped(b []byte) (out []byte, orig []int) {
+	f, ok := n.form()
+	if !ok {
+		orig = make([]int, len(b)+1)
+		for i := range orig {
+			orig[i] = i
+		}
+		return b, orig
+	}
+
+	var it norm.Iter
+	it.Init(f, b)
+	out, orig = make([]byte, 0, len(b)), make([]int, 0, len(b)+1)
+	for !it.Done() {
+		start := it.Pos()
+		seg := it.Next()
+		same := bytes.Equal(seg, b[start:it.Pos()])
+		for i := range seg {
+			if same {
+				orig = append(orig, start+i)
+			} else {
+				orig = append(orig, start)
+			}
+		}
+		out = append(out, seg...)
+	}
+	return out, append(orig, len(b))
+}
+
+// PrefixSpace returns b with a space in front, unless it is empty or starts with one already, see
+// LoadOptions.AddPrefixSpace.
+func PrefixSpace(b []byte) []byte {
+	if len(b) == 0 || b[0] == ' ' {
+		return b
+	}
+	return append([]byte{' '}, b...)
+}
+
+// Prepare turns input into what the tokenizer encodes: normalized, then with a prefix space if it was
+// loaded to add one. The encoding methods on Tokenizer take prepared input.
+func (t *Tokenizer) Prepare(input []byte) []byte {
+	input = t.normalization.Apply(input)
+	if t.addPrefixSpace {
+		input = PrefixSpace(input)
+	}
+	return input
+}
+
+// StreamNormalizer applies a normalization form to a byte stream that arrives in arbitrary chunks.
+// Composition needs lookahead: "e" followed by a combining acute accent in the next chunk must come out as
+// a single "é", so everything after the last normalization boundary of a chunk (a starter and the
+// combining marks trailing it, or an incomplete UTF-8 sequence) is carried into the next Push.
+type StreamNormalizer struct {
+	form  norm.Form
+	carry []byte
+	out   []byte
+}
+
+// NewStreamNormalizer returns a normalizer for n, or nil for NormalizeNone.
+func NewStreamNormalizer(n Normalization) *StreamNormalizer {
+	f, ok := n.form()
+	if !ok {
+		return nil
+	}
+	return &StreamNormalizer{form: f}
+}
+
+// Fresh returns a normalizer of the same form with nothing carried, nil for a nil sn.
+func (sn *StreamNormalizer) Fresh() *StreamNormalizer {
+	if sn == nil {
+		return nil
+	}
+	return &StreamNormalizer{form: sn.form}
+}
+
+// Push normalizes as much of carry+chunk as is final and returns it. The returned slice is reused by the
+// next call.
+func (sn *StreamNormalizer) Push(chunk []byte) []byte {
+	sn.carry = append(sn.carry, chunk...)
+
+	b := sn.form.LastBoundary(sn.carry)
+	if b <= 0 {
+		return nil
+	}
+
+	sn.out = sn.form.Append(sn.out[:0], sn.carry[:b]...)
+	n := copy(sn.carry, sn.carry[b:])
+	sn.carry = sn.carry[:n]
+
+	return sn.out
+}
+
+// Flush normalizes and returns whatever was carried, and resets the normalizer.
+func (sn *StreamNormalizer) Flush() []byte {
+	if len(sn.carry) == 0 {
+		return nil
+	}
+	sn.out = sn.form.Append(sn.out[:0], sn.carry...)
+	sn.carry = sn.carry[:0]
+	return sn.out
+}
+
+// Reset drops the carried bytes, keeping the buffers.
+func (sn *StreamNormalizer) Reset() {
+	sn.carry = sn.carry[:0]
+}
+
+// Carry returns the bytes carried to the next Push, not normalized yet. The slice is valid until the next
+// call.
+func (sn *StreamNormalizer) Carry() []byte {
+	return sn.carry
+}
+
+// SetCarry replaces the carried bytes with a copy of carry, as returned by Carry.
+func (sn *StreamNormalizer) SetCarry(carry []byte) {
+	sn.carry = append(sn.carry[:0], carry...)
+}
+
+// Peek appends what Flush would return to dst without consuming it.
+func (sn *StreamNormalizer) Peek(dst []byte) []byte {
+	return sn.form.Append(dst, sn.carry...)
+}
+
+// Pending returns the number of bytes carried over waiting for more input.
+func (sn *StreamNormalizer) Pending() int {
+	return len(sn.carry)
+}
@@ -0,0 +1,135 @@
+package core
+
+import (
+	"cmp"
+	"iter"
+	"runtime"
+	"slices"
+	"sync"
+)
+
+// PairCounts counts adjacent token pairs over a corpus. It is the primitive under BPE training (the most
+// frequent pair becomes the next merge) and vocab coverage analysis (which merges real text still leaves
+// unmade). Counts from different shards combine with Merge, so a corpus can be counted in pieces and
+// summed. The zero value is ready to use; it is not safe for concurrent use.
+type PairCounts struct {
+	counts map[uint64]int64
+	// Tokens and Docs total what was counted, pairs never span two documents.
+	Tokens int64
+	Docs   int64
+}
+
+// PairCount is one entry of PairCounts.
+type PairCount struct {
+	Left, Right int
+	Count       int64
+}
+
+// Add adds n occurrences of the pair (a, b).
+func (pc *PairCounts) Add(a, b int, n int64) {
+	if pc.counts == nil {
+		pc.counts = make(map[uint64]int64)
+	}
+	pc.counts[packPair(a, b)] += n
+}
+
+// Get returns how often (a, b) was counted.
+func (pc *PairCounts) Get(a, b int) int64 {
+	return pc.counts[packPair(a, b)]
+}
+
+// Len returns the number of distinct pairs.
+func (pc *PairCounts) Len() int {
+	return len(pc.counts)
+}
+
+// Merge adds other's counts into pc.
+func (pc *PairCounts) Merge(other *PairCounts) {
+	if pc.counts == nil {
+		pc.counts = make(map[uint64]int64, len(other.counts))
+	}
+	for key, n := range other.counts {
+		pc.counts[key] += n
+	}
+	pc.Tokens += other.Tokens
+	pc.Docs += other.Docs
+}
+
+// All yields every pair and its count, in no particular order.
+func (pc *PairCounts) All() iter.Seq2[[2]int, int64] {
+	return func(yield func([2]int, int64) bool) {
+		for key, n := range pc.counts {
+			if !yield([2]int{int(key >> 32), int(key & 0xFFFFFFFF)}, n) {
+				return
+			}
+		}
+	}
+}
+
+// Top returns the k most frequent pairs, most frequent first. Ties go to the lower (left, right) so the
+// result is deterministic, which a trainer picking the next merge relies on.
+func (pc *PairCounts) Top(k int) []PairCount {
+	out := make([]PairCount, 0, len(pc.counts))
+	for key, n := range pc.counts {
+		out = append(out, PairCount{Left: int(key >> 32), Right: int(key & 0xFFFFFFFF), Count: n})
+	}
+	slices.SortFunc(out, func(a, b PairCount) int {
+		if c := cmp.Compare(b.Count, a.Count); c != 0 {
+			return c
+		}
+		if c := cmp.Compare(a.Left, b.Left); c != 0 {
+			return c
+		}
+		return cmp.Compare(a.Right, b.Right)
+	})
+	return out[:min(k, len(out))]
+}
+
+// CountPairs encodes doc with the offline merge loop and adds each adjacent pair of the output to pc.
+func (t *Tokenizer) CountPairs(doc []byte, pc *PairCounts) {
+	prev := -1
+	t.encodeFunc(doc, func(id int) bool {
+		if prev >= 0 {
+			pc.Add(prev, id, 1)
+		}
+		prev = id
+		pc.Tokens++
+		return true
+	})
+	pc.Docs++
+}
+
+// CountPairsParallel runs CountPairs over shards on workers goroutines (GOMAXPROCS if workers <= 0),
+// each into its own PairCounts, and merges them at the end. shards is consumed from a single goroutine,
+// so it doesn't have to be safe for concurrent use; the slices it yields must stay valid until they've
+// been counted.
+func (t *Tokenizer) CountPairsParallel(shards iter.Seq[[]byte], workers int) *PairCounts {
+	if workers <= 0 {
+		workers = runtime.GOMAXPROCS(0)
+	}
+
+	work := make(chan []byte, workers)
+	parts := make([]PairCounts, workers)
+	var wg sync.WaitGroup
+	for w := range workers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for doc := range work {
+				t.CountPairs(doc, &parts[w])
+			}
+		}()
+	}
+
+	for doc := range shards {
+		work <- doc
+	}
+	close(work)
+	wg.Wait()
+
+	total := &PairCounts{}
+	for i := range parts {
+		total.Merge(&parts[i])
+	}
+	return total
+}
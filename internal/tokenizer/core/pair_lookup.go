@@ -1,24 +1,54 @@
 package core
 
+import "sort"
+
+const (
+	// maxFastLookupSize caps the side of the dense table; 2048*2048*8 bytes is already 32 MiB.
+	maxFastLookupSize = 2048
+	// minFastLookupSize is the smallest window we bother sizing down to.
+	minFastLookupSize = 256
+	// fastLookupSampleRanks is how many of the highest priority merges we sample when picking the window.
+	// Low ranks are the frequent pairs, so they're a decent proxy for what the hot loop will actually hit.
+	fastLookupSampleRanks = 8192
+	// fastLookupCoverage is the fraction of the best achievable sample coverage a smaller window must reach
+	// before we prefer it over a larger one.
+	fastLookupCoverage = 0.95
+)
+
 // PairLookup provides fast lookup of pair info (rank and token) using a hybrid approach:
-// - 2D array for pairs where both tokens are < fastLookupSize (O(1) lookup)
-// - Map fallback for larger pairs
+// - 2D array for pairs where both tokens are in [fastLookupBase, fastLookupBase+fastLookupSize) (O(1) lookup)
+// - Map fallback for everything else
 type PairLookup struct {
 	fastLookup     [][]uint64
+	fastLookupBase int
 	fastLookupSize int
 	fallback       map[uint64]uint64
+	config         PairLookupConfig
+}
+
+// PairLookupConfig describes the window chosen for the dense table at load time.
+type PairLookupConfig struct {
+	// Base is the first token ID covered by the dense table.
+	Base int
+	// Size is the side length of the dense table, IDs in [Base, Base+Size) hit the fast path.
+	Size int
+	// FastPairs is the number of merge pairs stored in the dense table.
+	FastPairs int
+	// FallbackPairs is the number of merge pairs that live in the fallback map.
+	FallbackPairs int
+	// SampleCoverage is the fraction of sampled (low rank) merges that land in the dense table.
+	SampleCoverage float64
 }
 
-// NewPairLookup creates a new pair lookup structure
+// NewPairLookup creates a new pair lookup structure. The dense window is placed over the densest
+// region of low-rank merges instead of always covering [0, 2048).
 func NewPairLookup(pairInfo map[uint64]uint64, vocabSize int) *PairLookup {
-	fastLookupSize := 2048
-	if vocabSize < fastLookupSize {
-		fastLookupSize = vocabSize
-	}
+	sample := samplePairs(pairInfo)
+	base, size := tuneFastLookup(sample, vocabSize)
 
-	fastLookup := make([][]uint64, fastLookupSize)
+	fastLookup := make([][]uint64, size)
 	for i := range fastLookup {
-		fastLookup[i] = make([]uint64, fastLookupSize)
+		fastLookup[i] = make([]uint64, size)
 		for j := range fastLookup[i] {
 			fastLookup[i][j] = ^uint64(0)
 		}
@@ -26,28 +56,45 @@ func NewPairLookup(pairInfo map[uint64]uint64, vocabSize int) *PairLookup {
 
 	fallback := make(map[uint64]uint64, len(pairInfo)/10)
 
+	fast := 0
 	for key, value := range pairInfo {
-		a := int(key >> 32)
-		b := int(key & 0xFFFFFFFF)
+		a := int(key>>32) - base
+		b := int(key&0xFFFFFFFF) - base
 
-		if a < fastLookupSize && b < fastLookupSize {
+		if a >= 0 && a < size && b >= 0 && b < size {
 			fastLookup[a][b] = value
+			fast++
 		} else {
 			fallback[key] = value
 		}
 	}
 
+	coverage := 0.0
+	if len(sample) > 0 {
+		coverage = float64(countInside(sample, base, size)) / float64(len(sample))
+	}
+
 	return &PairLookup{
 		fastLookup:     fastLookup,
-		fastLookupSize: fastLookupSize,
+		fastLookupBase: base,
+		fastLookupSize: size,
 		fallback:       fallback,
+		config: PairLookupConfig{
+			Base:           base,
+			Size:           size,
+			FastPairs:      fast,
+			FallbackPairs:  len(fallback),
+			SampleCoverage: coverage,
+		},
 	}
 }
 
 // Lookup returns the pair info (rank << 32 | tokenID) and whether it was found
 func (pl *PairLookup) Lookup(a, b int) (uint64, bool) {
-	if a >= 0 && a < pl.fastLookupSize && b >= 0 && b < pl.fastLookupSize {
-		value := pl.fastLookup[a][b]
+	fa := uint(a - pl.fastLookupBase)
+	fb := uint(b - pl.fastLookupBase)
+	if fa < uint(pl.fastLookupSize) && fb < uint(pl.fastLookupSize) {
+		value := pl.fastLookup[fa][fb]
 		if value+1 != 0 {
 			return value, true
 		}
@@ -58,3 +105,90 @@ func (pl *PairLookup) Lookup(a, b int) (uint64, bool) {
 	value, ok := pl.fallback[key]
 	return value, ok
 }
+
+// Config reports the dense window chosen at construction time.
+func (pl *PairLookup) Config() PairLookupConfig {
+	return pl.config
+}
+
+// sampledPair is a merge pair reduced to the ID range it spans.
+type sampledPair struct {
+	lo, hi int
+}
+
+// samplePairs returns the fastLookupSampleRanks lowest-ranked pairs as (min ID, max ID) spans.
+func samplePairs(pairInfo map[uint64]uint64) []sampledPair {
+	type ranked struct {
+		rank int
+		p    sampledPair
+	}
+
+	all := make([]ranked, 0, len(pairInfo))
+	for key, info := range pairInfo {
+		a := int(key >> 32)
+		b := int(key & 0xFFFFFFFF)
+		lo, hi := a, b
+		if lo > hi {
+			lo, hi = hi, lo
+		}
+		all = append(all, ranked{rank: int(info >> 32), p: sampledPair{lo: lo, hi: hi}})
+	}
+
+	sort.Slice(all, func(i, j int) bool { return all[i].rank < all[j].rank })
+	if len(all) > fastLookupSampleRanks {
+		all = all[:fastLookupSampleRanks]
+	}
+
+	out := make([]sampledPair, len(all))
+	for i, r := range all {
+		out[i] = r.p
+	}
+	return out
+}
+
+// tuneFastLookup picks the base and size of the dense table.
+// For each candidate size (powers of two up to maxFastLookupSize) we slide the window across the
+// ID space in minFastLookupSize steps and count how many sampled pairs fall completely inside it.
+// The smallest size reaching fastLookupCoverage of the best coverage wins, which keeps memory down
+// for vocabs whose hot pairs are tightly clustered.
+func tuneFastLookup(sample []sampledPair, vocabSize int) (base, size int) {
+	if vocabSize <= maxFastLookupSize {
+		return 0, vocabSize
+	}
+
+	if len(sample) == 0 {
+		return 0, maxFastLookupSize
+	}
+
+	best := func(size int) (int, int) {
+		bestBase, bestHits := 0, -1
+		for b := 0; b+size <= vocabSize+minFastLookupSize-1; b += minFastLookupSize {
+			hits := countInside(sample, b, size)
+			if hits > bestHits {
+				bestBase, bestHits = b, hits
+			}
+		}
+		return bestBase, bestHits
+	}
+
+	maxBase, maxHits := best(maxFastLookupSize)
+	for size := minFastLookupSize; size < maxFastLookupSize; size *= 2 {
+		b, hits := best(size)
+		if float64(hits) >= fastLookupCoverage*float64(maxHits) {
+			return b, size
+		}
+	}
+
+	return maxBase, maxFastLookupSize
+}
+
+// countInside counts sampled pairs whose both IDs fall in [base, base+size).
+func countInside(sample []sampledPair, base, size int) int {
+	hits := 0
+	for _, p := range sample {
+		if p.lo >= base && p.hi < base+size {
+			hits++
+		}
+	}
+	return hits
+}
@@ -0,0 +1,99 @@
+package core
+
+import (
+	"runtime"
+	"sync"
+)
+
+// minParallel is the item count below which parallelRange doesn't bother with goroutines.
+const minParallel = 4096
+
+// parallelRange splits [0, n) into one contiguous range per CPU and runs fn on each concurrently, waiting
+// for all of them. Small inputs, or GOMAXPROCS=1, run as a single fn(0, n) on the calling goroutine.
+func parallelRange(n int, fn func(lo, hi int)) {
+	workers := min(runtime.GOMAXPROCS(0), n/minParallel)
+	if workers <= 1 {
+		fn(0, n)
+		return
+	}
+
+	var wg sync.WaitGroup
+	for w := range workers {
+		lo, hi := n*w/workers, n*(w+1)/workers
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			fn(lo, hi)
+		}()
+	}
+	wg.Wait()
+}
+
+// parallel runs independent load phases concurrently and waits for all of them; with GOMAXPROCS=1 they
+// simply run in order.
+func parallel(fns ...func()) {
+	if runtime.GOMAXPROCS(0) == 1 {
+		for _, fn := range fns {
+			fn()
+		}
+		return
+	}
+
+	var wg sync.WaitGroup
+	for _, fn := range fns[1:] {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			fn()
+		}()
+	}
+	fns[0]()
+	wg.Wait()
+}
+
+// minParallelEncode is the input bytes per worker below which EncodeParallel doesn't split.
+const minParallelEncode = 256 << 10
+
+// EncodeParallel returns EncodeOffline(input, nil), encoding pieces of a large input on separate CPUs. The
+// pieces end at junctions CanJoin rejects, which no token spans, so each encodes the same on its own as
+// within input; a piece that has none near where it should end is joined with the next. A pre-tokenizer's
+// splits can depend on the bytes before them, so with one the input is encoded in one go.
+func (t *Tokenizer) EncodeParallel(input []byte) []int {
+	workers := min(runtime.GOMAXPROCS(0), len(input)/minParallelEncode)
+	if workers <= 1 || t.preTokenization != PreTokenizeNone {
+		return t.EncodeOffline(input, nil)
+	}
+
+	cuts := []int{0}
+	for w := 1; w < workers; w++ {
+		from, to := max(len(input)*w/workers, cuts[len(cuts)-1]+1), len(input)*(w+1)/workers
+		for i := from; i < to; i++ {
+			if !t.CanJoin(input[i-1], input[i]) {
+				cuts = append(cuts, i)
+				break
+			}
+		}
+	}
+	cuts = append(cuts, len(input))
+
+	pieces := make([][]int, len(cuts)-1)
+	var wg sync.WaitGroup
+	for i := range pieces {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			pieces[i] = t.EncodeOffline(input[cuts[i]:cuts[i+1]], nil)
+		}()
+	}
+	wg.Wait()
+
+	n := 0
+	for _, p := range pieces {
+		n += len(p)
+	}
+	out := make([]int, 0, n)
+	for _, p := range pieces {
+		out = append(out, p...)
+	}
+	return out
+}
@@ -0,0 +1,468 @@
+package core
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// Span is the [Start, End) byte range of one pre-tokenizer split, or of a token in a stream, see
+// StreamResult.Spans.
+type Span struct {
+	Start, End int
+}
+
+// PreTokenization selects the regex split applied to input before merging. Merges never cross a split, so
+// a word can't share a token with the space or punctuation after it, which is what the reference
+// implementations of the models do.
+type PreTokenization int
+
+const (
+	// PreTokenizeNone merges over the raw input; a token can cross word boundaries. The default.
+	PreTokenizeNone PreTokenization = iota
+	// PreTokenizeGPT2 splits with GPT-2's regex, as Hugging Face's ByteLevel pre-tokenizer (use_regex) and
+	// tiktoken's gpt2, r50k and p50k encodings do:
+	//
+	//	's|'t|'re|'ve|'m|'ll|'d| ?\p{L}+| ?\p{N}+| ?[^\s\p{L}\p{N}]+|\s+(?!\S)|\s+
+	PreTokenizeGPT2
+	// PreTokenizeCL100K splits with tiktoken's cl100k_base regex (GPT-4, GPT-3.5): contractions in any
+	// case, a word may take one leading space or punctuation character, and numbers go in chunks of up to
+	// three digits:
+	//
+	//	(?i:'s|'t|'re|'ve|'m|'ll|'d)|[^\r\n\p{L}\p{N}]?\p{L}+|\p{N}{1,3}| ?[^\s\p{L}\p{N}]+[\r\n]*|\s*[\r\n]+|\s+(?!\S)|\s+
+	PreTokenizeCL100K
+	// PreTokenizeO200K splits with tiktoken's o200k_base regex (GPT-4o): like cl100k, but words also break
+	// where lower case turns to upper case, and contractions stick to the word before them:
+	//
+	//	[^\r\n\p{L}\p{N}]?[\p{Lu}\p{Lt}\p{Lm}\p{Lo}\p{M}]*[\p{Ll}\p{Lm}\p{Lo}\p{M}]+(?i:'s|'t|'re|'ve|'m|'ll|'d)?|
+	//	[^\r\n\p{L}\p{N}]?[\p{Lu}\p{Lt}\p{Lm}\p{Lo}\p{M}]+[\p{Ll}\p{Lm}\p{Lo}\p{M}]*(?i:'s|'t|'re|'ve|'m|'ll|'d)?|
+	//	\p{N}{1,3}| ?[^\s\p{L}\p{N}]+[\r\n/]*|\s*[\r\n]+|\s+(?!\S)|\s+
+	PreTokenizeO200K
+)
+
+func (p PreTokenization) String() string {
+	switch p {
+	case PreTokenizeNone:
+		return "none"
+	case PreTokenizeGPT2:
+		return "gpt2"
+	case PreTokenizeCL100K:
+		return "cl100k"
+	case PreTokenizeO200K:
+		return "o200k"
+	default:
+		return fmt.Sprintf("pretokenization(%d)", int(p))
+	}
+}
+
+// valid reports whether p is one of the constants above.
+func (p PreTokenization) valid() bool {
+	return p >= PreTokenizeNone && p <= PreTokenizeO200K
+}
+
+// splitLen returns the length of the split at the start of b, which is never empty. Without a
+// pre-tokenizer all of b is one split.
+func (p PreTokenization) splitLen(b []byte) int {
+	switch p {
+	case PreTokenizeGPT2:
+		return gpt2Len(b)
+	case PreTokenizeCL100K:
+		return cl100kLen(b)
+	case PreTokenizeO200K:
+		return o200kLen(b)
+	default:
+		return len(b)
+	}
+}
+
+// fixed reports whether there is a split boundary between the characters a and b whatever follows b: no
+// match of p's regex covers both, and the matches before it come out the same if the input ends at b
+// instead. A space never qualifies as a, it may start the next word or, with \s+(?!\S), give up its last
+// character to it.
+func (p PreTokenization) fixed(a, b rune) bool {
+	ca, cb := preTokenClass(a), preTokenClass(b)
+	switch p {
+	case PreTokenizeGPT2:
+		// a quote may start a contraction
+		return ca != cb && ca != classSpace && a != '\''
+	case PreTokenizeCL100K:
+		switch ca {
+		case classLetter, classNumber:
+			return cb != ca
+		case classOther:
+			// punctuation may lead the word after it and takes the line breaks after it
+			return cb == classNumber || cb == classSpace && !isNewline(b)
+		}
+		// \s*[\r\n]+ ends a run of spaces at its last line break
+		return isNewline(a) && cb != classSpace
+	case PreTokenizeO200K:
+		switch {
+		case ca == classLetter:
+			// marks continue a word and a quote may add a contraction to it
+			return cb != classLetter && !unicode.IsMark(b) && b != '\''
+		case ca == classNumber:
+			return cb != classNumber
+		case ca == classOther && !unicode.IsMark(a):
+			return cb == classNumber || cb == classSpace && !isNewline(b)
+		}
+		return isNewline(a) && cb != classSpace && b != '/'
+	}
+	return false
+}
+
+// Split returns the spans p splits input into. They are contiguous and cover input; without a
+// pre-tokenizer there's one span. Invalid UTF-8 bytes count as punctuation.
+func (p PreTokenization) Split(input []byte) []Span {
+	var spans []Span
+	for start := 0; start < len(input); {
+		end := start + p.splitLen(input[start:])
+		spans = append(spans, Span{Start: start, End: end})
+		start = end
+	}
+	return spans
+}
+
+// FinalLen returns the length of the prefix of buf that ends at the last split boundary no further input
+// can move, see fixed, so a streaming encoder can encode it and hold back only the rest. The prefix splits
+// the same on its own as within buf. It is always 0 for PreTokenizeNone, where any later byte can merge
+// with earlier ones.
+func (p PreTokenization) FinalLen(buf []byte) int {
+	n, _ := p.finalLen(buf, 0)
+	return n
+}
+
+// finalLen is FinalLen for a buf whose boundaries before from, a character start, are known not to be
+// fixed. limit is where the characters it checked end, the from of the next call once more is appended.
+func (p PreTokenization) finalLen(buf []byte, from int) (n, limit int) {
+	if p == PreTokenizeNone {
+		return 0, from
+	}
+
+	// an unfinished UTF-8 sequence at the end isn't a character yet
+	limit = len(buf)
+	for i := len(buf) - 1; i >= 0 && i >= len(buf)-utf8.UTFMax; i-- {
+		if utf8.RuneStart(buf[i]) {
+			if !utf8.FullRune(buf[i:]) {
+				limit = i
+			}
+			break
+		}
+	}
+
+	i := from
+	prev, size := utf8.DecodeLastRune(buf[:from])
+	if from == 0 {
+		prev, size = utf8.DecodeRune(buf[:limit])
+		i = size
+	}
+	for ; i < limit; i += size {
+		var r rune
+		r, size = utf8.DecodeRune(buf[i:limit])
+		if p.fixed(prev, r) {
+			n = i
+		}
+		prev = r
+	}
+	return n, max(limit, from)
+}
+
+// maxDigitGroup bounds LoadOptions.DigitGroup, so it fits its byte of the compiled flags.
+const maxDigitGroup = 255
+
+// splitLen is PreTokenization.splitLen with the tokenizer's digit groups cut out of the split, see
+// LoadOptions.DigitGroup.
+func (t *Tokenizer) splitLen(b []byte) int {
+	n := t.preTokenization.splitLen(b)
+	if t.digitGroup > 0 {
+		n = digitGroupLen(b[:n], t.digitGroup)
+	}
+	return n
+}
+
+// digitGroupLen returns where split is cut first when its runs of digits go in groups of at most k: right
+// after the k-th digit of a run that goes on, or len(split) if no run does. The rest, split again from
+// the cut, starts a run of its own, so the groups are counted from where each run starts.
+func digitGroupLen(split []byte, k int) int {
+	digits := 0
+	for i := 0; i < len(split); {
+		r, size := utf8.DecodeRune(split[i:])
+		i += size
+		if preTokenClass(r) != classNumber {
+			digits = 0
+			continue
+		}
+		if digits++; digits == k && i < len(split) {
+			if next, _ := utf8.DecodeRune(split[i:]); preTokenClass(next) == classNumber {
+				return i
+			}
+		}
+	}
+	return len(split)
+}
+
+// PreTokenize splits input with the tokenizer's pre-tokenizer and digit groups, or with GPT-2's regex if
+// it was loaded without one, see PreTokenization.Split. Encoding applies the split only in the first case;
+// otherwise this is for checking a vocab against another implementation's splits and for word-level
+// processing of the same text.
+func (t *Tokenizer) PreTokenize(input []byte) []Span {
+	if t.preTokenization == PreTokenizeNone {
+		return PreTokenizeGPT2.Split(input)
+	}
+	var spans []Span
+	for start := 0; start < len(input); {
+		end := start + t.splitLen(input[start:])
+		spans = append(spans, Span{Start: start, End: end})
+		start = end
+	}
+	return spans
+}
+
+// PreTokenization returns the pre-tokenizer recorded at load time.
+func (t *Tokenizer) PreTokenization() PreTokenization {
+	return t.preTokenization
+}
+
+// DigitGroup returns the digit group size recorded at load time, 0 for none, see LoadOptions.DigitGroup.
+func (t *Tokenizer) DigitGroup() int {
+	return t.digitGroup
+}
+
+// gpt2Len returns the length of GPT-2's regex match at the start of b, which is never empty.
+func gpt2Len(b []byte) int {
+	if n := contractionLen(b, false); n > 0 {
+		return n
+	}
+
+	// the three " ?class+" alternatives: an optional ASCII space, then a run of one class
+	if b[0] == ' ' && len(b) > 1 {
+		r, _ := utf8.DecodeRune(b[1:])
+		if c := preTokenClass(r); c != classSpace {
+			return 1 + runLen(b[1:], c)
+		}
+	}
+	r, _ := utf8.DecodeRune(b)
+	if c := preTokenClass(r); c != classSpace {
+		return runLen(b, c)
+	}
+	return spaceLen(b, false)
+}
+
+// cl100kLen returns the length of cl100k_base's regex match at the start of b, which is never empty.
+func cl100kLen(b []byte) int {
+	if n := contractionLen(b, true); n > 0 {
+		return n
+	}
+
+	// [^\r\n\p{L}\p{N}]?\p{L}+
+	r, size := utf8.DecodeRune(b)
+	c := preTokenClass(r)
+	if c == classLetter {
+		return runLen(b, classLetter)
+	}
+	if c != classNumber && !isNewline(r) {
+		if n := runLen(b[size:], classLetter); n > 0 {
+			return size + n
+		}
+	}
+
+	if c == classNumber {
+		return numberLen(b)
+	}
+	if n := punctLen(b, "\r\n"); n > 0 {
+		return n
+	}
+	return spaceLen(b, true)
+}
+
+// o200kLen returns the length of o200k_base's regex match at the start of b, which is never empty.
+func o200kLen(b []byte) int {
+	// the two word alternatives, each trying the optional leading character first
+	r, size := utf8.DecodeRune(b)
+	starts := []int{0}
+	if c := preTokenClass(r); c != classLetter && c != classNumber && !isNewline(r) {
+		starts = []int{size, 0}
+	}
+	for _, upperFirst := range []bool{false, true} {
+		for _, s := range starts {
+			if n := casedWordLen(b[s:], upperFirst); n > 0 {
+				return s + n
+			}
+		}
+	}
+
+	if preTokenClass(r) == classNumber {
+		return numberLen(b)
+	}
+	if n := punctLen(b, "\r\n/"); n > 0 {
+		return n
+	}
+	return spaceLen(b, true)
+}
+
+// casedWordLen matches o200k's [\p{Lu}\p{Lt}\p{Lm}\p{Lo}\p{M}]*[\p{Ll}\p{Lm}\p{Lo}\p{M}]+ or, if
+// upperFirst is set, [\p{Lu}\p{Lt}\p{Lm}\p{Lo}\p{M}]+[\p{Ll}\p{Lm}\p{Lo}\p{M}]*, then an optional
+// contraction, at the start of b. It returns 0 if neither matches.
+func casedWordLen(b []byte, upperFirst bool) int {
+	// the upper run, remembering where its last character that would also do as lower case starts
+	n, lastLower := 0, -1
+	for n < len(b) {
+		r, size := utf8.DecodeRune(b[n:])
+		if !isUpperish(r) {
+			break
+		}
+		if isLowerish(r) {
+			lastLower = n
+		}
+		n += size
+	}
+
+	lower := n
+	if upperFirst {
+		if n == 0 {
+			return 0
+		}
+	} else if r, _ := utf8.DecodeRune(b[n:]); n == len(b) || !isLowerish(r) {
+		// the lower run needs a character, give it back from the upper run
+		if lastLower < 0 {
+			return 0
+		}
+		lower = lastLower
+	}
+	for lower < len(b) {
+		r, size := utf8.DecodeRune(b[lower:])
+		if !isLowerish(r) {
+			break
+		}
+		lower += size
+	}
+	return lower + contractionLen(b[lower:], true)
+}
+
+func isUpperish(r rune) bool {
+	return unicode.In(r, unicode.Lu, unicode.Lt, unicode.Lm, unicode.Lo, unicode.M)
+}
+
+func isLowerish(r rune) bool {
+	return unicode.In(r, unicode.Ll, unicode.Lm, unicode.Lo, unicode.M)
+}
+
+// contractions are the suffixes after the quote in 's|'t|'re|'ve|'m|'ll|'d.
+var contractions = []string{"s", "t", "re", "ve", "m", "ll", "d"}
+
+// contractionLen matches a contraction at the start of b, ignoring case if fold is set, or returns 0.
+func contractionLen(b []byte, fold bool) int {
+	if len(b) < 2 || b[0] != '\'' {
+		return 0
+	}
+next:
+	for _, c := range contractions {
+		n := 1
+		for _, want := range c {
+			r, size := utf8.DecodeRune(b[n:])
+			if r != want && !(fold && sameFold(r, want)) {
+				continue next
+			}
+			n += size
+		}
+		return n
+	}
+	return 0
+}
+
+// sameFold reports whether r and c are the same letter under Unicode simple case folding, as (?i) matches.
+func sameFold(r, c rune) bool {
+	for f := unicode.SimpleFold(c); f != c; f = unicode.SimpleFold(f) {
+		if f == r {
+			return true
+		}
+	}
+	return false
+}
+
+// numberLen matches \p{N}{1,3} at the start of b.
+func numberLen(b []byte) int {
+	n := 0
+	for range 3 {
+		r, size := utf8.DecodeRune(b[n:])
+		if n == len(b) || preTokenClass(r) != classNumber {
+			break
+		}
+		n += size
+	}
+	return n
+}
+
+// punctLen matches " ?[^\s\p{L}\p{N}]+" followed by any run of the bytes in trail, or returns 0.
+func punctLen(b []byte, trail string) int {
+	n := 0
+	if b[0] == ' ' {
+		n = 1
+	}
+	run := runLen(b[n:], classOther)
+	if run == 0 {
+		return 0
+	}
+	n += run
+	for n < len(b) && strings.IndexByte(trail, b[n]) >= 0 {
+		n++
+	}
+	return n
+}
+
+// spaceLen matches the whitespace alternatives at the start of b, which starts with whitespace:
+// \s*[\r\n]+ if newlines is set, which runs to the last line break, then \s+(?!\S), which stops before
+// the last whitespace character if a non-space follows, and \s+, which takes what's left.
+func spaceLen(b []byte, newlines bool) int {
+	n := runLen(b, classSpace)
+	if newlines {
+		if i := bytes.LastIndexAny(b[:n], "\r\n"); i >= 0 {
+			return i + 1
+		}
+	}
+	if n == len(b) {
+		return n
+	}
+	_, last := utf8.DecodeLastRune(b[:n])
+	if n > last {
+		return n - last
+	}
+	return n
+}
+
+func isNewline(r rune) bool {
+	return r == '\r' || r == '\n'
+}
+
+const (
+	classLetter = iota
+	classNumber
+	classSpace
+	classOther
+)
+
+func preTokenClass(r rune) int {
+	switch {
+	case unicode.IsLetter(r):
+		return classLetter
+	case unicode.IsNumber(r):
+		return classNumber
+	case unicode.IsSpace(r):
+		return classSpace
+	}
+	return classOther
+}
+
+// runLen returns the byte length of the run of class c characters at the start of b.
+func runLen(b []byte, c int) int {
+	n := 0
+	for n < len(b) {
+		r, size := utf8.DecodeRune(b[n:])
+		if preTokenClass(r) != c {
+			break
+		}
+		n += size
+	}
+	return n
+}
@@ -0,0 +1,107 @@
+package core
+
+import (
+	"sync"
+	"sync/atomic"
+)
+
+// ScratchPoolLimits bounds the encode scratch a tokenizer keeps around between calls, see
+// SetScratchPoolLimits and LoadOptions.ScratchPool.
+type ScratchPoolLimits struct {
+	// MaxItemLen is the largest input length, in bytes, whose scratch is kept as is. Scratch that grew
+	// past it (one 100 MB encode, say) is dropped on release instead of pinning that memory for good.
+	MaxItemLen int
+	// MaxItems is how many scratch items the tokenizer keeps, 0 keeps none.
+	MaxItems int
+}
+
+// defaultScratchPoolLimits keeps a few scratch items of up to 64 KiB of input each, about 2 MiB of scratch
+// per item on 64-bit.
+var defaultScratchPoolLimits = ScratchPoolLimits{MaxItemLen: 64 << 10, MaxItems: 4}
+
+// scratchLimits holds the process wide limits, for tokenizers loaded without LoadOptions.ScratchPool.
+var scratchLimits atomic.Pointer[ScratchPoolLimits]
+
+func init() {
+	l := defaultScratchPoolLimits
+	scratchLimits.Store(&l)
+}
+
+// SetScratchPoolLimits replaces the process wide pool limits, which apply to every tokenizer not loaded
+// with its own (see WithScratchPoolLimits). It is safe to call while encodes are running, items already
+// pooled are trimmed as they're next released.
+func SetScratchPoolLimits(l ScratchPoolLimits) {
+	l.MaxItemLen = max(l.MaxItemLen, 0)
+	l.MaxItems = max(l.MaxItems, 0)
+	scratchLimits.Store(&l)
+}
+
+// GetScratchPoolLimits returns the process wide limits in effect.
+func GetScratchPoolLimits() ScratchPoolLimits {
+	return *scratchLimits.Load()
+}
+
+// ScratchPoolStats describes what a tokenizer's pool is holding on to.
+type ScratchPoolStats struct {
+	Items int
+	// RetainedLen is the summed input capacity, in bytes, of the pooled items.
+	RetainedLen int
+}
+
+// scratchPool is a bounded free list. sync.Pool can't cap how much it retains, which is the point here.
+type scratchPool struct {
+	mu   sync.Mutex
+	free []*encodeScratch
+	// limits is set once at load, nil means the process wide ones
+	limits *ScratchPoolLimits
+}
+
+func (p *scratchPool) getLimits() ScratchPoolLimits {
+	if p.limits == nil {
+		return GetScratchPoolLimits()
+	}
+	return *p.limits
+}
+
+func (p *scratchPool) get() *encodeScratch {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	n := len(p.free)
+	if n == 0 {
+		return nil
+	}
+	sc := p.free[n-1]
+	p.free[n-1] = nil
+	p.free = p.free[:n-1]
+	return sc
+}
+
+func (p *scratchPool) put(sc *encodeScratch) {
+	l := p.getLimits()
+	if cap(sc.tokens) > l.MaxItemLen {
+		sc.shrink(l.MaxItemLen)
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if len(p.free) < l.MaxItems {
+		p.free = append(p.free, sc)
+	}
+}
+
+func (p *scratchPool) stats() ScratchPoolStats {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	st := ScratchPoolStats{Items: len(p.free)}
+	for _, sc := range p.free {
+		st.RetainedLen += cap(sc.tokens)
+	}
+	return st
+}
+
+// ScratchPoolStats reports what t's scratch pool currently retains.
+func (t *Tokenizer) ScratchPoolStats() ScratchPoolStats {
+	return t.scratchPool.stats()
+}
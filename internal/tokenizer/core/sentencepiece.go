@@ -0,0 +1,402 @@
+package core
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// ErrUnsupportedSentencePiece is returned for a SentencePiece model this package can't reproduce, e.g. a
+// unigram model or one without byte fallback pieces.
+var ErrUnsupportedSentencePiece = errors.New("unsupported sentencepiece model")
+
+// SentencePiece piece types, from sentencepiece_model.proto.
+const (
+	spNormal      = 1
+	spUnknown     = 2
+	spControl     = 3
+	spUserDefined = 4
+	spUnused      = 5
+	spByte        = 6
+)
+
+// spModelBPE is TrainerSpec.ModelType BPE. The proto default is UNIGRAM.
+const spModelBPE = 2
+
+// spWhitespace is the meta symbol SentencePiece writes in place of a space.
+const spWhitespace = "▁"
+
+type spPiece struct {
+	text  string
+	score float32
+	typ   int
+}
+
+// spModel is the part of a ModelProto that matters for BPE, with the proto defaults filled in.
+type spModel struct {
+	pieces         []spPiece
+	modelType      int
+	normalizer     string
+	addDummyPrefix bool
+	removeExtraWS  bool
+	escapeWS       bool
+}
+
+type sentencePieceSource struct {
+	path string
+	data []byte
+}
+
+// SentencePieceFile reads a SentencePiece .model file from disk, see SentencePieceBytes.
+func SentencePieceFile(path string) Source {
+	return sentencePieceSource{path: path}
+}
+
+// SentencePieceBytes uses an in-memory SentencePiece .model (a serialized ModelProto) trained with the BPE
+// algorithm, like Llama-2's tokenizer.model. Token IDs are the piece IDs.
+//
+// Pieces are mapped to bytes with ▁ read as a space and <0xNN> byte pieces as the byte. Merges come from
+// the scores: the pair of adjacent pieces whose concatenation is the highest scoring piece merges first,
+// which is what SentencePiece's BPE does once the input is split into characters. Characters are kept
+// atomic by merging their UTF-8 bytes before anything else; multi-byte characters that need a partial
+// prefix along the way get extra IDs past the piece IDs, and a prefix whose character isn't in the vocab is
+// spelled out as byte pieces on output, like SentencePiece's byte fallback. The model must have byte
+// pieces for all 256 bytes.
+//
+// Control and unknown pieces (<s>, </s>, <unk>) are reported by SpecialTokens and never produced by a
+// merge. An nmt_nfkc or nfkc normalizer becomes NFKC unless WithNormalization picks another form; any other
+// rule besides identity is ErrUnsupportedSentencePiece. add_dummy_prefix and remove_extra_whitespaces are
+// not applied, a warning is logged when the model asks for them, so callers wanting SentencePiece's IDs
+// prepend the space themselves. Tokenizers loaded this way can't be compiled with MarshalBinary.
+func SentencePieceBytes(data []byte) Source {
+	return sentencePieceSource{data: data}
+}
+
+func (s sentencePieceSource) load(opts LoadOptions) (*Tokenizer, error) {
+	data, source := s.data, "<bytes>"
+	if s.path != "" {
+		var err error
+		if data, err = os.ReadFile(s.path); err != nil {
+			return nil, fmt.Errorf("error while reading sentencepiece model : %w", err)
+		}
+		source = s.path
+	}
+
+	m, err := parseSentencePiece(data)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", source, err)
+	}
+	return m.load(source, opts)
+}
+
+// load builds the tokenizer from a parsed model, logging what configure says will be ignored.
+func (m *spModel) load(source string, opts LoadOptions) (*Tokenizer, error) {
+	norm, warnings, err := m.configure()
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", source, err)
+	}
+	if opts.Normalization == NormalizeNone {
+		opts.Normalization = norm
+	}
+	for _, w := range warnings {
+		opts.logger().Printf("bpetok: %s: %s", source, w)
+	}
+
+	tok, err := m.build(opts)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", source, err)
+	}
+	return tok, nil
+}
+
+// configure checks the trainer and normalizer specs and works out the normalization they ask for, plus
+// warnings for what will be ignored.
+func (m *spModel) configure() (Normalization, []string, error) {
+	if m.modelType != spModelBPE {
+		return 0, nil, fmt.Errorf("%w: model type %d is not BPE", ErrUnsupportedSentencePiece, m.modelType)
+	}
+
+	norm := NormalizeNone
+	switch m.normalizer {
+	case "", "identity":
+	case "nmt_nfkc", "nfkc":
+		norm = NormalizeNFKC
+	default:
+		return 0, nil, fmt.Errorf("%w: normalizer %s", ErrUnsupportedSentencePiece, m.normalizer)
+	}
+
+	var warnings []string
+	if m.addDummyPrefix {
+		warnings = append(warnings, "add_dummy_prefix is not applied")
+	}
+	if m.removeExtraWS {
+		warnings = append(warnings, "remove_extra_whitespaces is not applied")
+	}
+	return norm, warnings, nil
+}
+
+// build turns the pieces into pair tables the way loadTiktoken does for rank files, plus the character
+// chains described on SentencePieceBytes.
+func (m *spModel) build(opts LoadOptions) (*Tokenizer, error) {
+	revVocab := make([][]byte, len(m.pieces))
+	special := make(map[string]int)
+	// ids holds the pieces a merge may consume or produce
+	ids := make(map[string]int, len(m.pieces))
+	var byteToToken [256]int
+	var haveByte [256]bool
+
+	for id, p := range m.pieces {
+		switch p.typ {
+		case spNormal, spUserDefined:
+			text := p.text
+			if m.escapeWS {
+				text = strings.ReplaceAll(text, spWhitespace, " ")
+			}
+			if text == "" {
+				return nil, fmt.Errorf("piece %d is empty", id)
+			}
+			revVocab[id] = []byte(text)
+			ids[text] = id
+		case spByte:
+			b, ok := parseBytePiece(p.text)
+			if !ok {
+				return nil, fmt.Errorf("piece %d: bad byte piece %q", id, p.text)
+			}
+			revVocab[id] = []byte{b}
+		case spControl, spUnknown:
+			revVocab[id] = []byte(p.text)
+			special[p.text] = id
+		case spUnused:
+			revVocab[id] = []byte(p.text)
+		default:
+			return nil, fmt.Errorf("piece %d: unknown type %d", id, p.typ)
+		}
+	}
+
+	// a normal piece for an ASCII character wins over its byte piece, SentencePiece only falls back to
+	// bytes for characters it has no piece for
+	for id, p := range m.pieces {
+		if p.typ == spByte {
+			b := revVocab[id][0]
+			if !haveByte[b] {
+				byteToToken[b], haveByte[b] = id, true
+			}
+		}
+	}
+	for b := range 128 {
+		if id, ok := ids[string(rune(b))]; ok {
+			byteToToken[b], haveByte[b] = id, true
+		}
+	}
+	for b, ok := range haveByte {
+		if !ok {
+			return nil, fmt.Errorf("%w: no byte piece for 0x%02X, byte fallback is required", ErrUnsupportedSentencePiece, b)
+		}
+	}
+
+	for text, id := range opts.SpecialTokens {
+		special[text] = id
+	}
+	opts.SpecialTokens = special
+	revVocab, err := appendSpecialTokens(revVocab, special, false)
+	if err != nil {
+		return nil, fmt.Errorf("failed to add special tokens: %w", err)
+	}
+
+	pairRank := make(map[uint64]int, len(ids)*2)
+	partial := make(map[int][]int)
+
+	// rank 0: spell every multi-byte character piece out of its bytes, left to right. Pieces are walked in
+	// ID order so the prefix IDs are the same on every load.
+	prefixes := make(map[string]int)
+	for id, p := range m.pieces {
+		if p.typ != spNormal && p.typ != spUserDefined {
+			continue
+		}
+		text := string(revVocab[id])
+		r, size := utf8.DecodeRuneInString(text)
+		if size < 2 || size != len(text) || r == utf8.RuneError {
+			continue
+		}
+		left := byteToToken[text[0]]
+		for k := 2; k <= size; k++ {
+			right := byteToToken[text[k-1]]
+			if k == size {
+				pairRank[packPair(left, right)] = 0
+				break
+			}
+			pid, ok := prefixes[text[:k]]
+			if !ok {
+				pid = len(revVocab)
+				revVocab = append(revVocab, []byte(text[:k]))
+				prefixes[text[:k]] = pid
+				for i := range k {
+					partial[pid] = append(partial[pid], byteToToken[text[i]])
+				}
+			}
+			pairRank[packPair(left, right)] = 0
+			left = pid
+		}
+	}
+
+	// then every split of a multi-character piece, in score order
+	order := make([]int, 0, len(ids))
+	for id, p := range m.pieces {
+		if (p.typ == spNormal || p.typ == spUserDefined) && utf8.RuneCount(revVocab[id]) > 1 {
+			order = append(order, id)
+		}
+	}
+	slices.SortFunc(order, func(a, b int) int {
+		if sa, sb := m.pieces[a].score, m.pieces[b].score; sa != sb {
+			if sa > sb {
+				return -1
+			}
+			return 1
+		}
+		return a - b
+	})
+
+	maxRank := 0
+	for i, id := range order {
+		bs := revVocab[id]
+		for k := 1; k < len(bs); k++ {
+			left, ok := ids[string(bs[:k])]
+			if !ok {
+				continue
+			}
+			right, ok := ids[string(bs[k:])]
+			if !ok {
+				continue
+			}
+			pairRank[packPair(left, right)] = i + 1
+			maxRank = i + 1
+		}
+	}
+
+	tok, err := newTokenizer(revVocab, byteToToken, byteToToken, pairRank, maxRank, 0)
+	if err != nil {
+		return nil, err
+	}
+	if len(partial) > 0 {
+		tok.partial = partial
+	}
+	return tok.finishLoad(opts)
+}
+
+// parseBytePiece reads a "<0xNN>" byte piece.
+func parseBytePiece(s string) (byte, bool) {
+	if len(s) != 6 || !strings.HasPrefix(s, "<0x") || s[5] != '>' {
+		return 0, false
+	}
+	v, err := strconv.ParseUint(s[3:5], 16, 8)
+	return byte(v), err == nil
+}
+
+// parseSentencePiece decodes the fields of a ModelProto that build needs. There is no protobuf dependency,
+// the wire format is simple enough to walk by hand and unknown fields are skipped.
+func parseSentencePiece(data []byte) (*spModel, error) {
+	m := &spModel{modelType: 1, addDummyPrefix: true, removeExtraWS: true, escapeWS: true}
+
+	err := walkProto(data, func(field int, v uint64, b []byte) error {
+		switch field {
+		case 1: // pieces
+			p := spPiece{typ: spNormal}
+			err := walkProto(b, func(field int, v uint64, b []byte) error {
+				switch field {
+				case 1:
+					p.text = string(b)
+				case 2:
+					p.score = math.Float32frombits(uint32(v))
+				case 3:
+					p.typ = int(v)
+				}
+				return nil
+			})
+			if err != nil {
+				return fmt.Errorf("piece %d: %w", len(m.pieces), err)
+			}
+			m.pieces = append(m.pieces, p)
+		case 2: // trainer_spec
+			return walkProto(b, func(field int, v uint64, b []byte) error {
+				if field == 3 {
+					m.modelType = int(v)
+				}
+				return nil
+			})
+		case 3: // normalizer_spec
+			return walkProto(b, func(field int, v uint64, b []byte) error {
+				switch field {
+				case 1:
+					m.normalizer = string(b)
+				case 3:
+					m.addDummyPrefix = v != 0
+				case 4:
+					m.removeExtraWS = v != 0
+				case 5:
+					m.escapeWS = v != 0
+				}
+				return nil
+			})
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("malformed sentencepiece model: %w", err)
+	}
+	if len(m.pieces) == 0 {
+		return nil, fmt.Errorf("sentencepiece model has no pieces")
+	}
+	return m, nil
+}
+
+// walkProto calls fn for every field of a protobuf message. Varint and fixed-width values arrive in v,
+// length-delimited ones in b.
+func walkProto(data []byte, fn func(field int, v uint64, b []byte) error) error {
+	for len(data) > 0 {
+		key, n := binary.Uvarint(data)
+		if n <= 0 {
+			return fmt.Errorf("bad field key")
+		}
+		data = data[n:]
+		field := int(key >> 3)
+
+		var v uint64
+		var b []byte
+		switch key & 7 {
+		case 0:
+			if v, n = binary.Uvarint(data); n <= 0 {
+				return fmt.Errorf("field %d: bad varint", field)
+			}
+			data = data[n:]
+		case 1:
+			if len(data) < 8 {
+				return fmt.Errorf("field %d: truncated", field)
+			}
+			v, data = binary.LittleEndian.Uint64(data), data[8:]
+		case 2:
+			l, n := binary.Uvarint(data)
+			if n <= 0 || l > uint64(len(data)-n) {
+				return fmt.Errorf("field %d: truncated", field)
+			}
+			b, data = data[n:n+int(l)], data[n+int(l):]
+		case 5:
+			if len(data) < 4 {
+				return fmt.Errorf("field %d: truncated", field)
+			}
+			v, data = uint64(binary.LittleEndian.Uint32(data)), data[4:]
+		default:
+			return fmt.Errorf("field %d: unsupported wire type %d", field, key&7)
+		}
+
+		if err := fn(field, v, b); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,242 @@
+package core
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+)
+
+// ErrDisallowedSpecial is returned by EncodeWithSpecial when the input holds the text of a special token
+// its SpecialPolicy disallows.
+var ErrDisallowedSpecial = errors.New("disallowed special token in input")
+
+// AllSpecial stands for every special token of the tokenizer in SpecialPolicy's lists.
+const AllSpecial = "all"
+
+// SpecialPolicy decides what EncodeWithSpecial does with special token text in its input, like tiktoken's
+// allowed_special and disallowed_special. The zero value allows none and disallows all, so any special
+// token text fails the call: the safe default for user input, where a literal "<|endoftext|>" would
+// otherwise smuggle a control token into the prompt. Names that aren't special tokens are ignored.
+type SpecialPolicy struct {
+	// Allowed special tokens are encoded as their IDs. It takes precedence over Disallowed.
+	Allowed []string
+	// Disallowed special tokens fail the call with ErrDisallowedSpecial. nil means every special token
+	// not allowed; an empty, non-nil list disallows none. Special tokens in neither list are encoded as
+	// ordinary text.
+	Disallowed []string
+}
+
+// resolve splits the special tokens into the allowed and the disallowed ones under p.
+func (p SpecialPolicy) resolve(special map[string]int) (allowed, disallowed []string) {
+	for name := range special {
+		switch {
+		case inSpecialList(p.Allowed, name):
+			allowed = append(allowed, name)
+		case p.Disallowed == nil || inSpecialList(p.Disallowed, name):
+			disallowed = append(disallowed, name)
+		}
+	}
+	return allowed, disallowed
+}
+
+func inSpecialList(list []string, name string) bool {
+	return slices.Contains(list, AllSpecial) || slices.Contains(list, name)
+}
+
+// EncodeWithSpecial is EncodeOffline with the special tokens registered at load time recognized in input,
+// under p: each occurrence of an allowed special token's text becomes its ID, and the text between them is
+// encoded on its own, so no token spans a special token's edge. If a disallowed one occurs anywhere the
+// call fails with ErrDisallowedSpecial instead. Where occurrences overlap the earliest wins, and the
+// longest of those starting at the same byte.
+func (t *Tokenizer) EncodeWithSpecial(input []byte, p SpecialPolicy) ([]int, error) {
+	allowed, disallowed := p.resolve(t.specialTokens)
+	if m, _ := t.SpecialMatcher(disallowed...).Find(input, 0, false); m.Len > 0 {
+		return nil, fmt.Errorf("%w: %q at byte %d", ErrDisallowedSpecial, input[m.Start:m.Start+m.Len], m.Start)
+	}
+	return t.EncodeWithMatcher(input, t.SpecialMatcher(allowed...)), nil
+}
+
+// EncodeWithMatcher is EncodeWithSpecial with the special tokens m finds allowed and no others checked
+// for. A nil m finds none.
+func (t *Tokenizer) EncodeWithMatcher(input []byte, m *SpecialMatcher) []int {
+	out := make([]int, 0, len(input))
+	emit := func(id int) bool {
+		out = append(out, id)
+		return true
+	}
+
+	start := 0
+	for {
+		match, _ := m.Find(input, start, false)
+		if match.Len == 0 {
+			break
+		}
+		t.encodeFunc(input[start:match.Start], emit)
+		out = append(out, match.ID)
+		start = match.Start + match.Len
+	}
+	t.encodeFunc(input[start:], emit)
+	return out
+}
+
+// SpecialMatcher returns a matcher for the named special tokens, AllSpecial standing for every one. Names
+// that aren't special tokens are ignored; with none left it returns nil, which matches nothing. The
+// matcher over all of them is built once and shared.
+func (t *Tokenizer) SpecialMatcher(names ...string) *SpecialMatcher {
+	var patterns []string
+	for name := range t.specialTokens {
+		if name != "" && inSpecialList(names, name) {
+			patterns = append(patterns, name)
+		}
+	}
+	if len(patterns) == 0 {
+		return nil
+	}
+	if len(patterns) < len(t.specialTokens) {
+		return newSpecialMatcher(patterns, t.specialTokens)
+	}
+	t.specialOnce.Do(func() { t.specialMatcher = newSpecialMatcher(patterns, t.specialTokens) })
+	return t.specialMatcher
+}
+
+// NewSpecialMatcher returns a matcher for the texts in ids, which don't have to be special tokens of any
+// tokenizer, e.g. the added tokens of a wrapper that splits them out itself. Empty texts are skipped; with
+// none left it returns nil, which matches nothing.
+func NewSpecialMatcher(ids map[string]int) *SpecialMatcher {
+	var patterns []string
+	for text := range ids {
+		if text != "" {
+			patterns = append(patterns, text)
+		}
+	}
+	if len(patterns) == 0 {
+		return nil
+	}
+	return newSpecialMatcher(patterns, ids)
+}
+
+// SpecialMatch is one special token occurrence found by a SpecialMatcher: its ID and where its text is in
+// the input. Len is 0 when there is none.
+type SpecialMatch struct {
+	Start, Len int
+	ID         int
+}
+
+// SpecialMatcher is an Aho–Corasick automaton over special token texts: a single pass over the input
+// finds the occurrences of all of them, however many there are (ChatML markers, tool call tokens, a
+// model's hundreds of reserved tokens). It is immutable and safe for concurrent use.
+type SpecialMatcher struct {
+	// classes maps each byte to its column in delta. Bytes no pattern contains share class 0, which
+	// always leads back to the root.
+	classes [256]uint8
+	nclass  int
+	// delta is the transition table, nclass entries per state, with the failure links folded in. The
+	// root is state 0.
+	delta []int32
+	// depth is the length of each state's path from the root: how many of the bytes just read could
+	// still be the start of a pattern.
+	depth []int32
+	// outLen and outID describe the longest pattern ending at each state, outLen 0 if none does.
+	outLen []int32
+	outID  []int32
+}
+
+func newSpecialMatcher(patterns []string, ids map[string]int) *SpecialMatcher {
+	m := &SpecialMatcher{}
+	for _, p := range patterns {
+		for i := 0; i < len(p); i++ {
+			if m.classes[p[i]] == 0 {
+				m.nclass++
+				m.classes[p[i]] = uint8(m.nclass)
+			}
+		}
+	}
+	m.nclass++
+
+	// the trie, -1 for a missing edge
+	newState := func(depth int) int32 {
+		for range m.nclass {
+			m.delta = append(m.delta, -1)
+		}
+		m.depth = append(m.depth, int32(depth))
+		m.outLen = append(m.outLen, 0)
+		m.outID = append(m.outID, 0)
+		return int32(len(m.depth) - 1)
+	}
+	newState(0)
+	for _, p := range patterns {
+		s := int32(0)
+		for i := 0; i < len(p); i++ {
+			e := int(s)*m.nclass + int(m.classes[p[i]])
+			if m.delta[e] < 0 {
+				m.delta[e] = newState(i + 1)
+			}
+			s = m.delta[e]
+		}
+		m.outLen[s], m.outID[s] = int32(len(p)), int32(ids[p])
+	}
+
+	// breadth first, so a state's failure target is complete before the state is
+	fail := make([]int32, len(m.depth))
+	queue := []int32{0}
+	for len(queue) > 0 {
+		s := queue[0]
+		queue = queue[1:]
+		if s != 0 && m.outLen[s] == 0 {
+			m.outLen[s], m.outID[s] = m.outLen[fail[s]], m.outID[fail[s]]
+		}
+		row := int(s) * m.nclass
+		for c := range m.nclass {
+			next := m.delta[row+c]
+			switch {
+			case next >= 0 && c != 0:
+				if s != 0 {
+					fail[next] = m.delta[int(fail[s])*m.nclass+c]
+				}
+				queue = append(queue, next)
+			case s == 0:
+				m.delta[row+c] = 0
+			default:
+				m.delta[row+c] = m.delta[int(fail[s])*m.nclass+c]
+			}
+		}
+	}
+	return m
+}
+
+// Find returns the first special token occurrence in input at or after from, the longest of those
+// starting at the same byte.
+//
+// With more set, input is the buffered part of a stream that goes on: an occurrence only counts once no
+// later byte can turn up an earlier or longer one, and held is where the bytes start that may still
+// become part of one, so input[from:held] is plain text whatever follows. Without more, held is
+// len(input). A nil m finds nothing.
+func (m *SpecialMatcher) Find(input []byte, from int, more bool) (match SpecialMatch, held int) {
+	if m == nil {
+		return SpecialMatch{}, len(input)
+	}
+
+	best := SpecialMatch{Start: -1}
+	s := int32(0)
+	for i := from; i < len(input); i++ {
+		s = m.delta[int(s)*m.nclass+int(m.classes[input[i]])]
+		if n := int(m.outLen[s]); n > 0 {
+			// the longest pattern ending here is the one starting earliest
+			if start := i + 1 - n; best.Start < 0 || start < best.Start || start == best.Start && n > best.Len {
+				best = SpecialMatch{Start: start, Len: n, ID: int(m.outID[s])}
+			}
+		}
+		// every pattern still in progress started after best, so none can replace it
+		if best.Start >= 0 && best.Start < i+1-int(m.depth[s]) {
+			return best, best.Start
+		}
+	}
+
+	if !more {
+		if best.Start >= 0 {
+			return best, best.Start
+		}
+		return SpecialMatch{}, len(input)
+	}
+	return SpecialMatch{}, len(input) - int(m.depth[s])
+}
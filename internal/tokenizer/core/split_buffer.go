@@ -0,0 +1,58 @@
+package core
+
+// SplitBuffer holds back the input of a streaming encoder with a pre-tokenizer until its splits are final,
+// the pre-tokenizer's counterpart of a tail reserve: a split only leaves the buffer once no later input can
+// move its end (see PreTokenization.FinalLen), so no pre-token is ever cut at a Push boundary. Each byte is
+// scanned once, however many pushes a long pre-token (a huge word, a run of whitespace) arrives in.
+type SplitBuffer struct {
+	p   PreTokenization
+	buf []byte
+	// start is where the held bytes begin in buf, scanned how far past it boundaries have been checked
+	start   int
+	scanned int
+}
+
+// NewSplitBuffer returns a buffer for p, or nil for PreTokenizeNone.
+func NewSplitBuffer(p PreTokenization) *SplitBuffer {
+	if p == PreTokenizeNone {
+		return nil
+	}
+	return &SplitBuffer{p: p}
+}
+
+// Push appends chunk and returns the bytes that became final, whole splits that can be encoded on their
+// own, or nothing. The returned slice is valid until the next call.
+func (sb *SplitBuffer) Push(chunk []byte) []byte {
+	if sb.start > 0 {
+		sb.buf = sb.buf[:copy(sb.buf, sb.buf[sb.start:])]
+		sb.start = 0
+	}
+	sb.buf = append(sb.buf, chunk...)
+
+	n, limit := sb.p.finalLen(sb.buf, sb.scanned)
+	sb.start, sb.scanned = n, limit-n
+	return sb.buf[:n]
+}
+
+// Pending returns the bytes held back. The slice is valid until the next call.
+func (sb *SplitBuffer) Pending() []byte {
+	return sb.buf[sb.start:]
+}
+
+// Len returns the number of bytes held back.
+func (sb *SplitBuffer) Len() int {
+	return len(sb.buf) - sb.start
+}
+
+// Reset drops the bytes held back.
+func (sb *SplitBuffer) Reset() {
+	sb.buf = sb.buf[:0]
+	sb.start, sb.scanned = 0, 0
+}
+
+// SetPending replaces the bytes held back with a copy of b, as returned by Pending. They are rescanned on
+// the next Push.
+func (sb *SplitBuffer) SetPending(b []byte) {
+	sb.buf = append(sb.buf[:0], b...)
+	sb.start, sb.scanned = 0, 0
+}
@@ -0,0 +1,45 @@
+package core
+
+// StreamResult is what a streaming encoder reports for one call. It is the one result type the streaming
+// APIs share, so features that need more than the committed IDs add a field here rather than another
+// method.
+type StreamResult struct {
+	// Committed are the token IDs settled by this call, in order, following those of earlier calls. They
+	// never change. The slice follows the encoder's Feed aliasing rules.
+	Committed []int
+
+	// Provisional is how the input held back after Committed would encode if the stream ended now. It is
+	// replaced by the next result rather than extended, and can differ from what is eventually committed
+	// for those bytes. Empty after a flush.
+	Provisional []int
+
+	// ConsumedBytes is how many bytes of the stream, counted since the last flush and after normalization,
+	// all committed IDs so far cover. Input past it is still held by the encoder.
+	ConsumedBytes int
+
+	// Spans are the [Start, End) byte ranges of the Committed IDs, one each, counted like ConsumedBytes,
+	// so they map IDs back to the normalized stream (the source itself without normalization or a prefix
+	// space) for highlighting, alignment or redaction. Only encoders asked for offsets fill them.
+	Spans []Span
+
+	// Revision increases with every result an encoder returns, across streams, so results can be ordered
+	// and a stale Provisional recognised.
+	Revision uint64
+}
+
+// ResultEncoder is an Encoder that can also report each call as a StreamResult. PushResult and
+// FlushResult are Feed and Flush with the richer result; calls of either kind can be mixed on one stream.
+type ResultEncoder interface {
+	Encoder
+	PushResult(chunk []byte) StreamResult
+	FlushResult() StreamResult
+}
+
+// StreamPatch is a streaming encoder's output as edits to what a display shows: drop the last Retract IDs
+// shown, then append Tokens. The IDs shown are the committed ones followed by a provisional tail, so a UI
+// can show tokens as soon as the bytes arrive and patch the tail when a later chunk merges into it.
+// Retract only ever reaches into the provisional tail, never into committed IDs.
+type StreamPatch struct {
+	Retract int
+	Tokens  []int
+}
@@ -0,0 +1,81 @@
+package core
+
+import (
+	"bytes"
+	"slices"
+	"sort"
+)
+
+// substringIndex is a suffix array over the vocab arena with every suffix cut at the end of its token, so
+// the suffixes starting with a pattern form one contiguous, binary searchable run and a match never spans
+// two tokens.
+type substringIndex struct {
+	pos []uint32 // arena offsets, sorted by the token-bounded suffix starting there
+}
+
+func buildSubstringIndex(a *vocabArena) *substringIndex {
+	pos := make([]uint32, 0, len(a.data))
+	ends := make([]uint32, 0, len(a.data))
+	for id := range a.size() {
+		start, end := a.offs[id], a.offs[id+1]
+		for p := start; p < end; p++ {
+			pos = append(pos, p)
+			ends = append(ends, end)
+		}
+	}
+
+	// ends travels with pos, so sort an index permutation rather than pos itself
+	perm := make([]int, len(pos))
+	for i := range perm {
+		perm[i] = i
+	}
+	sort.Slice(perm, func(i, j int) bool {
+		x, y := perm[i], perm[j]
+		return bytes.Compare(a.data[pos[x]:ends[x]], a.data[pos[y]:ends[y]]) < 0
+	})
+
+	sorted := make([]uint32, len(pos))
+	for i, x := range perm {
+		sorted[i] = pos[x]
+	}
+	return &substringIndex{pos: sorted}
+}
+
+// TokensContaining returns the IDs of every token whose bytes contain sub, in ascending order, e.g. to
+// collect the vocab entries a logit-ban list has to cover. An empty sub matches nothing.
+//
+// The suffix array behind it is built on the first call, or at load with WithSubstringIndex, and takes
+// four bytes per vocab byte. Lookups cost a binary search plus the number of matches.
+func (t *Tokenizer) TokensContaining(sub []byte) []int {
+	if len(sub) == 0 {
+		return nil
+	}
+	a := &t.vocab
+	pos := t.substringIndex().pos
+	// the suffix at p, cut to len(sub) and to its token's end, ordered against sub
+	cmpAt := func(i int) int {
+		p := int(pos[i])
+		end := min(p+len(sub), int(a.offs[t.ownerOf(uint32(p))+1]))
+		return bytes.Compare(a.data[p:end], sub)
+	}
+	lo := sort.Search(len(pos), func(i int) bool { return cmpAt(i) >= 0 })
+	hi := lo + sort.Search(len(pos)-lo, func(i int) bool { return cmpAt(lo+i) != 0 })
+
+	var ids []int
+	for _, p := range pos[lo:hi] {
+		ids = append(ids, t.ownerOf(p))
+	}
+	slices.Sort(ids)
+	return slices.Compact(ids)
+}
+
+func (t *Tokenizer) substringIndex() *substringIndex {
+	t.substrOnce.Do(func() { t.substr = buildSubstringIndex(&t.vocab) })
+	return t.substr
+}
+
+// ownerOf returns the token whose bytes hold arena offset p.
+func (t *Tokenizer) ownerOf(p uint32) int {
+	offs := t.vocab.offs
+	return sort.Search(len(offs)-1, func(id int) bool { return offs[id+1] > p })
+}
@@ -0,0 +1,248 @@
+package core
+
+import (
+	"container/list"
+	"errors"
+	"fmt"
+	"hash/maphash"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// ErrTemplateVar is returned by TemplateCache.Render when a placeholder has no value.
+var ErrTemplateVar = errors.New("template variable missing")
+
+// TokenOffsets returns the byte offset each of ids starts at in Decode(ids), plus the total length as a
+// final entry, so token i spans [offs[i], offs[i+1]).
+func (t *Tokenizer) TokenOffsets(ids []int) []int {
+	offs := make([]int, len(ids)+1)
+	for i, id := range ids {
+		offs[i+1] = offs[i] + t.TokenLen(id)
+	}
+	return offs
+}
+
+// TemplateCache encodes prompt templates with {{name}} placeholders. Each template's literal text is
+// encoded once and kept, keyed by a hash of the template; rendering then encodes only the placeholder
+// values plus the few literal bytes around each one that a value can still merge with. Output equals
+// EncodeOffline over the rendered text.
+//
+// A TemplateCache is safe for concurrent use. Tokenizers loaded with a normalization, a prefix space or a
+// pre-tokenizer skip the cache and encode the rendered text in full, since preparing or splitting pieces
+// separately can differ at the seams.
+type TemplateCache struct {
+	tok      *Tokenizer
+	capacity int
+	seed     maphash.Seed
+
+	mu      sync.Mutex
+	entries map[uint64]*list.Element
+	lru     *list.List
+	stats   TemplateCacheStats
+	// reused counts Render's spliced tokens outside mu, see TemplateCacheStats.ReusedTokens
+	reused atomic.Int64
+}
+
+// TemplateCacheStats counts cache traffic since the cache was created.
+type TemplateCacheStats struct {
+	Hits      int64
+	Misses    int64
+	Evictions int64
+	// Entries is the number of templates currently cached.
+	Entries int
+	// ReusedTokens counts the tokens Render took from cached literal encodings instead of encoding them.
+	ReusedTokens int64
+}
+
+// HitRate is Hits over lookups, 0 before the first lookup.
+func (s TemplateCacheStats) HitRate() float64 {
+	if n := s.Hits + s.Misses; n > 0 {
+		return float64(s.Hits) / float64(n)
+	}
+	return 0
+}
+
+// compiledTemplate is a template split around its placeholders: len(literals) == len(names)+1.
+type compiledTemplate struct {
+	text     string
+	names    []string
+	literals []templateLiteral
+}
+
+// templateLiteral is one stretch of literal text. stable is the encoding of its middle, which no value
+// next to it can change; head and tail are the bytes either side that get re-encoded with the neighbouring
+// values. With no stable middle the whole literal sits in head.
+type templateLiteral struct {
+	head   []byte
+	stable []int
+	tail   []byte
+}
+
+// NewTemplateCache returns a cache holding up to capacity compiled templates, least recently used ones are
+// evicted first. capacity < 1 is treated as 1.
+func (t *Tokenizer) NewTemplateCache(capacity int) *TemplateCache {
+	return &TemplateCache{
+		tok:      t,
+		capacity: max(capacity, 1),
+		seed:     maphash.MakeSeed(),
+		entries:  make(map[uint64]*list.Element),
+		lru:      list.New(),
+	}
+}
+
+// Stats returns the cache counters.
+func (c *TemplateCache) Stats() TemplateCacheStats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	st := c.stats
+	st.Entries = c.lru.Len()
+	st.ReusedTokens = c.reused.Load()
+	return st
+}
+
+// Render encodes template with every {{name}} replaced by vars[name]. A placeholder without a value is
+// ErrTemplateVar, an unterminated {{ is a syntax error.
+func (c *TemplateCache) Render(template string, vars map[string]string) ([]int, error) {
+	ct, err := c.lookup(template)
+	if err != nil {
+		return nil, err
+	}
+	for _, name := range ct.names {
+		if _, ok := vars[name]; !ok {
+			return nil, fmt.Errorf("%w: %q", ErrTemplateVar, name)
+		}
+	}
+
+	if c.tok.normalization != NormalizeNone || c.tok.addPrefixSpace || c.tok.preTokenization != PreTokenizeNone {
+		var sb strings.Builder
+		for i, lit := range ct.literals {
+			if i > 0 {
+				sb.WriteString(vars[ct.names[i-1]])
+			}
+			sb.Write(lit.head)
+			sb.Write(c.tok.Decode(lit.stable))
+			sb.Write(lit.tail)
+		}
+		return c.tok.EncodeOffline(c.tok.Prepare([]byte(sb.String())), nil), nil
+	}
+
+	var out []int
+	var pending []byte
+	emit := func(id int) bool {
+		out = append(out, id)
+		return true
+	}
+	for i, lit := range ct.literals {
+		if i > 0 {
+			pending = append(pending, vars[ct.names[i-1]]...)
+		}
+		pending = append(pending, lit.head...)
+		if lit.stable == nil {
+			continue
+		}
+		c.tok.encodeFunc(pending, emit)
+		out = append(out, lit.stable...)
+		c.reused.Add(int64(len(lit.stable)))
+		pending = append(pending[:0], lit.tail...)
+	}
+	c.tok.encodeFunc(pending, emit)
+
+	return out, nil
+}
+
+func (c *TemplateCache) lookup(template string) (*compiledTemplate, error) {
+	key := maphash.String(c.seed, template)
+
+	c.mu.Lock()
+	if el, ok := c.entries[key]; ok && el.Value.(*compiledTemplate).text == template {
+		c.lru.MoveToFront(el)
+		c.stats.Hits++
+		c.mu.Unlock()
+		return el.Value.(*compiledTemplate), nil
+	}
+	c.stats.Misses++
+	c.mu.Unlock()
+
+	// compile outside the lock, two goroutines racing on the same template just both do the work
+	ct, err := c.compile(template)
+	if err != nil {
+		return nil, err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if el, ok := c.entries[key]; ok {
+		// a hash collision or the race above, the newer template wins the slot
+		el.Value = ct
+		c.lru.MoveToFront(el)
+		return ct, nil
+	}
+	c.entries[key] = c.lru.PushFront(ct)
+	for c.lru.Len() > c.capacity {
+		old := c.lru.Back()
+		c.lru.Remove(old)
+		delete(c.entries, maphash.String(c.seed, old.Value.(*compiledTemplate).text))
+		c.stats.Evictions++
+	}
+	return ct, nil
+}
+
+// compile splits template at its placeholders and encodes each literal. Only the first literal can keep its
+// start and only the last its end: everywhere else the bytes up to the literal's first junction no merge
+// can join (see CanJoin), and from its last one, are left for Render. No token spans such a junction
+// whatever the value next to it, so the middle encodes the same on its own as in any rendering.
+func (c *TemplateCache) compile(template string) (*compiledTemplate, error) {
+	ct := &compiledTemplate{text: template}
+
+	rest := template
+	var lits []string
+	for {
+		open := strings.Index(rest, "{{")
+		if open < 0 {
+			lits = append(lits, rest)
+			break
+		}
+		end := strings.Index(rest[open+2:], "}}")
+		if end < 0 {
+			return nil, fmt.Errorf("template: unterminated {{ at offset %d", len(template)-len(rest)+open)
+		}
+		name := strings.TrimSpace(rest[open+2 : open+2+end])
+		if name == "" {
+			return nil, fmt.Errorf("template: empty placeholder at offset %d", len(template)-len(rest)+open)
+		}
+		lits = append(lits, rest[:open])
+		ct.names = append(ct.names, name)
+		rest = rest[open+2+end+2:]
+	}
+
+	ct.literals = make([]templateLiteral, len(lits))
+	for i, lit := range lits {
+		b := []byte(lit)
+		lo, hi := 0, len(b)
+		if i > 0 {
+			lo = 1
+			for lo < len(b) && c.tok.CanJoin(b[lo-1], b[lo]) {
+				lo++
+			}
+		}
+		if i < len(lits)-1 {
+			hi = len(b) - 1
+			for hi > 0 && c.tok.CanJoin(b[hi-1], b[hi]) {
+				hi--
+			}
+		}
+
+		if lo >= hi {
+			ct.literals[i] = templateLiteral{head: b}
+			continue
+		}
+		ct.literals[i] = templateLiteral{
+			head:   b[:lo],
+			stable: c.tok.EncodeOffline(b[lo:hi], nil),
+			tail:   b[hi:],
+		}
+	}
+	return ct, nil
+}
@@ -0,0 +1,131 @@
+package core
+
+import (
+	"io"
+	"math/rand/v2"
+	"slices"
+	"unicode/utf8"
+)
+
+// TextGenerator produces pseudo-text for benchmarks and soak tests by sampling token IDs and decoding
+// them. Unlike uniform random bytes, the output has the token length mix and merge structure of real
+// text, so encoder throughput and token counts on it look like production. It is deterministic for a
+// given seed and not safe for concurrent use.
+//
+// Only tokens that decode to valid UTF-8 on their own are sampled, which keeps every concatenation valid
+// UTF-8. Special tokens are never sampled.
+type TextGenerator struct {
+	tok *Tokenizer
+	rng *rand.Rand
+	ids []int
+	// cum[i] is the summed weight of ids[:i+1], nil when sampling is uniform
+	cum []uint64
+
+	pending []byte // decoded bytes Read hasn't handed out yet
+}
+
+// NewTextGenerator samples from the tokenizer's vocab. freqs, if non-nil, weights each token ID by its
+// entry, e.g. the output of TokenFrequencies over a representative corpus; tokens with a zero count are
+// never sampled. A nil freqs samples uniformly.
+func (t *Tokenizer) NewTextGenerator(seed uint64, freqs []int) *TextGenerator {
+	special := make(map[int]bool, len(t.specialTokens))
+	for _, id := range t.specialTokens {
+		special[id] = true
+	}
+
+	g := &TextGenerator{tok: t, rng: rand.New(rand.NewPCG(seed, seed^0x9e3779b97f4a7c15))}
+	var total uint64
+	for id := range t.VocabSize() {
+		if special[id] || t.partial[id] != nil || !t.ValidID(id) || !utf8.Valid(t.TokenBytes(id)) {
+			continue
+		}
+		if freqs == nil {
+			g.ids = append(g.ids, id)
+			continue
+		}
+		if id >= len(freqs) || freqs[id] <= 0 {
+			continue
+		}
+		total += uint64(freqs[id])
+		g.ids = append(g.ids, id)
+		g.cum = append(g.cum, total)
+	}
+	return g
+}
+
+// TokenFrequencies counts how often each token ID appears in the encoding of corpus. The result is
+// indexed by ID and sized to the vocab.
+func (t *Tokenizer) TokenFrequencies(corpus []byte) []int {
+	freqs := make([]int, t.VocabSize())
+	t.encodeFunc(corpus, func(id int) bool {
+		freqs[id]++
+		return true
+	})
+	return freqs
+}
+
+// NextToken samples one token ID. It returns -1 if there is nothing to sample from, e.g. freqs was all
+// zeros.
+func (g *TextGenerator) NextToken() int {
+	switch {
+	case len(g.ids) == 0:
+		return -1
+	case g.cum == nil:
+		return g.ids[g.rng.IntN(len(g.ids))]
+	}
+	x := g.rng.Uint64N(g.cum[len(g.cum)-1])
+	i, _ := slices.BinarySearch(g.cum, x+1)
+	return g.ids[i]
+}
+
+// Generate returns exactly n bytes of valid UTF-8 text. The last token is cut to fit, with any partial
+// character at the cut replaced by spaces.
+func (g *TextGenerator) Generate(n int) []byte {
+	out := make([]byte, 0, n+g.tok.MaxTokenByteLen)
+	for len(out) < n {
+		id := g.NextToken()
+		if id < 0 {
+			break
+		}
+		out = append(out, g.tok.TokenBytes(id)...)
+	}
+	if len(out) <= n {
+		return out
+	}
+
+	out = out[:n]
+	for i := len(out) - 1; i >= 0 && i >= len(out)-utf8.UTFMax; i-- {
+		if utf8.RuneStart(out[i]) {
+			if !utf8.FullRune(out[i:]) {
+				for j := i; j < len(out); j++ {
+					out[j] = ' '
+				}
+			}
+			break
+		}
+	}
+	return out
+}
+
+// Read fills p with generated text, an endless stream for soak tests. Characters can be split across
+// calls, the concatenation of everything read is valid UTF-8. It only returns io.EOF when there is nothing
+// to sample from.
+func (g *TextGenerator) Read(p []byte) (int, error) {
+	if len(g.ids) == 0 && len(g.pending) == 0 {
+		return 0, io.EOF
+	}
+	n := 0
+	for n < len(p) {
+		if len(g.pending) == 0 {
+			id := g.NextToken()
+			if id < 0 {
+				break
+			}
+			g.pending = g.tok.TokenBytes(id)
+		}
+		k := copy(p[n:], g.pending)
+		g.pending = g.pending[k:]
+		n += k
+	}
+	return n, nil
+}
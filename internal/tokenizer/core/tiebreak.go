@@ -0,0 +1,216 @@
+package core
+
+import (
+	"container/heap"
+	"fmt"
+	"iter"
+	"slices"
+)
+
+// TieBreak orders merge candidates that share a rank. Encoding only depends on it when two candidates of
+// equal rank overlap ("aaa" with the merge a+a), or, in tiktoken rank files where every split of a token
+// carries that token's rank, when two different splits compete.
+type TieBreak int
+
+const (
+	// TieLeftmost merges the leftmost candidate first. It is what the reference BPE implementations do.
+	TieLeftmost TieBreak = iota
+	// TieLowestID merges the candidate with the lowest (left, right) token IDs first, then the leftmost.
+	TieLowestID
+	// TieInsertion merges candidates in the order they were found, the FIFO-within-rank order of the
+	// streaming encoder's bucket heap. The offline queue keeps buckets sorted by position, i.e. leftmost.
+	TieInsertion
+)
+
+// TieBreaks lists every rule, in report order.
+var TieBreaks = []TieBreak{TieLeftmost, TieLowestID, TieInsertion}
+
+func (tb TieBreak) String() string {
+	switch tb {
+	case TieLeftmost:
+		return "leftmost"
+	case TieLowestID:
+		return "lowest-id"
+	case TieInsertion:
+		return "insertion-order"
+	default:
+		return fmt.Sprintf("tiebreak(%d)", int(tb))
+	}
+}
+
+// EncodeTieBreak is an experimental, unoptimised merge loop that orders equal-rank candidates by rule.
+// With TieLeftmost it is the specification EncodeOffline is expected to meet.
+func (t *Tokenizer) EncodeTieBreak(input []byte, rule TieBreak) []int {
+	if t.preTokenization == PreTokenizeNone {
+		return t.mergeTieBreak(input, rule)
+	}
+	var out []int
+	for start := 0; start < len(input); {
+		end := start + t.splitLen(input[start:])
+		out = append(out, t.mergeTieBreak(input[start:end], rule)...)
+		start = end
+	}
+	return out
+}
+
+func (t *Tokenizer) mergeTieBreak(input []byte, rule TieBreak) []int {
+	n := len(input)
+	if n == 0 {
+		return nil
+	}
+
+	tokens := make([]int, n)
+	next := make([]int, n)
+	prev := make([]int, n)
+	live := make([]int, n)
+	for i, b := range input {
+		tokens[i] = t.byteToToken[b]
+		prev[i], next[i] = i-1, i+1
+	}
+	next[n-1] = -1
+
+	q := &tieQueue{rule: rule}
+	push := func(i int) {
+		if i == -1 || next[i] == -1 {
+			return
+		}
+		j := next[i]
+		if rank, ok := t.GetPairRank(tokens[i], tokens[j]); ok {
+			heap.Push(q, tieCandidate{rank: rank, pair: packPair(tokens[i], tokens[j]), pos: i, seq: q.seq,
+				verL: live[i], verR: live[j]})
+			q.seq++
+		}
+	}
+	for i := 0; i != -1; i = next[i] {
+		push(i)
+	}
+
+	for q.Len() > 0 {
+		c := heap.Pop(q).(tieCandidate)
+		i := c.pos
+		j := next[i]
+		if j == -1 || live[i] != c.verL || live[j] != c.verR {
+			continue
+		}
+
+		tokens[i], _ = t.GetPairToken(tokens[i], tokens[j])
+		next[i] = next[j]
+		if next[j] != -1 {
+			prev[next[j]] = i
+		}
+		live[i]++
+		live[j]++
+
+		push(prev[i])
+		push(i)
+	}
+
+	var out []int
+	for i := 0; i != -1; i = next[i] {
+		out = t.AppendToken(out, tokens[i])
+	}
+	return out
+}
+
+type tieCandidate struct {
+	rank       int
+	pair       uint64
+	pos        int
+	seq        int
+	verL, verR int
+}
+
+type tieQueue struct {
+	items []tieCandidate
+	rule  TieBreak
+	seq   int
+}
+
+func (q *tieQueue) Len() int { return len(q.items) }
+
+func (q *tieQueue) Less(i, j int) bool {
+	a, b := q.items[i], q.items[j]
+	if a.rank != b.rank {
+		return a.rank < b.rank
+	}
+	switch q.rule {
+	case TieLowestID:
+		if a.pair != b.pair {
+			return a.pair < b.pair
+		}
+		return a.pos < b.pos
+	case TieInsertion:
+		return a.seq < b.seq
+	default:
+		return a.pos < b.pos
+	}
+}
+
+func (q *tieQueue) Swap(i, j int) { q.items[i], q.items[j] = q.items[j], q.items[i] }
+
+func (q *tieQueue) Push(x any) { q.items = append(q.items, x.(tieCandidate)) }
+
+func (q *tieQueue) Pop() any {
+	c := q.items[len(q.items)-1]
+	q.items = q.items[:len(q.items)-1]
+	return c
+}
+
+// TieBreakReport is the outcome of SimulateTieBreaks.
+type TieBreakReport struct {
+	Docs  int
+	Bytes int
+	// Diverged counts, per rule, the documents whose encoding differs from TieLeftmost's. The entry for
+	// TieLeftmost itself counts documents where EncodeOffline disagrees with it.
+	Diverged map[TieBreak]int
+	// Examples holds the first divergence seen per rule.
+	Examples map[TieBreak]TieBreakDivergence
+}
+
+// TieBreakDivergence pins down one document where a rule changed the output.
+type TieBreakDivergence struct {
+	Doc int
+	// Token is the index of the first differing token.
+	Token     int
+	Want, Got []int
+}
+
+// Rate returns the share of documents on which rule diverged.
+func (r TieBreakReport) Rate(rule TieBreak) float64 {
+	if r.Docs == 0 {
+		return 0
+	}
+	return float64(r.Diverged[rule]) / float64(r.Docs)
+}
+
+// SimulateTieBreaks encodes every document under each tie-break rule and reports how often the outputs
+// disagree with TieLeftmost. It quantifies how much rides on the order the merge heaps pop equal ranks in.
+func (t *Tokenizer) SimulateTieBreaks(docs iter.Seq[[]byte]) TieBreakReport {
+	rep := TieBreakReport{Diverged: map[TieBreak]int{}, Examples: map[TieBreak]TieBreakDivergence{}}
+
+	record := func(rule TieBreak, doc int, want, got []int) {
+		if slices.Equal(want, got) {
+			return
+		}
+		rep.Diverged[rule]++
+		if _, ok := rep.Examples[rule]; ok {
+			return
+		}
+		k := 0
+		for k < len(want) && k < len(got) && want[k] == got[k] {
+			k++
+		}
+		rep.Examples[rule] = TieBreakDivergence{Doc: doc, Token: k, Want: want, Got: got}
+	}
+
+	for doc := range docs {
+		ref := t.EncodeTieBreak(doc, TieLeftmost)
+		record(TieLeftmost, rep.Docs, ref, t.EncodeOffline(doc, nil))
+		for _, rule := range TieBreaks[1:] {
+			record(rule, rep.Docs, ref, t.EncodeTieBreak(doc, rule))
+		}
+		rep.Docs++
+		rep.Bytes += len(doc)
+	}
+	return rep
+}
@@ -0,0 +1,68 @@
+package core
+
+import (
+	"bufio"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+)
+
+// ErrTiktokenIncompatible is returned by WriteTiktoken for a tokenizer a rank file can't describe.
+var ErrTiktokenIncompatible = errors.New("tokenizer has no tiktoken equivalent")
+
+// WriteTiktoken writes the vocab as a tiktoken rank file, one "base64(token) rank" line per token ID in
+// ID order, which TiktokenBytes, tiktoken and tiktoken-rs all read. Special tokens are left out, tiktoken
+// takes those separately (see SpecialTokens), and so are normalization and any pre-tokenization regex.
+// Holes in the vocab are skipped too, TiktokenBytes reads such a file back WithVocabHoles.
+//
+// A rank file has no merges list, the rank of a merge is the ID it produces. That only reproduces this
+// tokenizer's merge order if IDs grow with merge rank, as they do for GPT-2 and anything trained the same
+// way; otherwise, or when special tokens sit between ordinary ones, or for a SentencePiece tokenizer, the
+// error wraps ErrTiktokenIncompatible and nothing is written.
+func (t *Tokenizer) WriteTiktoken(w io.Writer) error {
+	if t.partial != nil {
+		return fmt.Errorf("%w: sentencepiece character prefixes", ErrTiktokenIncompatible)
+	}
+
+	ranked := t.vocab.size()
+	for _, id := range t.specialTokens {
+		ranked = min(ranked, id)
+	}
+	special := make(map[int]bool, len(t.specialTokens))
+	for _, id := range t.specialTokens {
+		special[id] = true
+	}
+	for id := ranked; id < t.vocab.size(); id++ {
+		if !special[id] && t.ValidID(id) {
+			return fmt.Errorf("%w: token %d comes after special tokens", ErrTiktokenIncompatible, id)
+		}
+	}
+
+	keys := t.pairsByRank()
+	last := -1
+	for _, key := range keys {
+		id := t.pairToken[key]
+		if id < last {
+			return fmt.Errorf("%w: merge %d produces token %d after token %d", ErrTiktokenIncompatible, t.pairRank[key], id, last)
+		}
+		last = id
+	}
+
+	bw := bufio.NewWriter(w)
+	var line []byte
+	for id := range ranked {
+		if !t.ValidID(id) {
+			continue
+		}
+		line = base64.StdEncoding.AppendEncode(line[:0], t.vocab.bytes(id))
+		line = append(line, ' ')
+		line = strconv.AppendInt(line, int64(id), 10)
+		line = append(line, '\n')
+		if _, err := bw.Write(line); err != nil {
+			return err
+		}
+	}
+	return bw.Flush()
+}
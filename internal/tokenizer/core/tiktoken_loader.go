@@ -0,0 +1,161 @@
+package core
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"os"
+	"strconv"
+)
+
+// LoadTokenizerFromTiktoken builds a tokenizer from a tiktoken rank file (cl100k_base.tiktoken,
+// o200k_base.tiktoken, ...): one "base64(token) rank" pair per line, where the rank doubles as the token ID.
+func LoadTokenizerFromTiktoken(path string) (*Tokenizer, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("error while reading tiktoken file : %w", err)
+	}
+	return LoadTokenizerFromTiktokenBytes(data)
+}
+
+// LoadTokenizerFromTiktokenBytes is LoadTokenizerFromTiktoken over the file contents. data is not retained.
+//
+// tiktoken has no merges list: it repeatedly merges the adjacent pair whose concatenation has the lowest
+// rank. That maps onto our pair tables by registering every split (a, b) of each token t, with both halves
+// in the vocab, as a merge producing t at rank(t). Candidates of equal rank pop leftmost first, the same
+// tie-break tiktoken uses.
+func LoadTokenizerFromTiktokenBytes(data []byte) (*Tokenizer, error) {
+	return loadTiktoken(data, LoadOptions{})
+}
+
+func loadTiktoken(data []byte, opts LoadOptions) (*Tokenizer, error) {
+	revVocab, err := parseTiktokenRanks(data, opts.AllowVocabHoles || opts.Lenient)
+	if err != nil {
+		return nil, err
+	}
+	if holes := countHoles(revVocab); holes > 0 && !opts.AllowVocabHoles {
+		opts.logger().Printf("bpetok: tiktoken ranks have %d unused ids below %d, loading them as holes", holes, len(revVocab))
+		opts.AllowVocabHoles = true
+	}
+	ranked := len(revVocab)
+
+	revVocab, err = appendSpecialTokens(revVocab, opts.SpecialTokens, opts.AllowVocabHoles)
+	if err != nil {
+		return nil, fmt.Errorf("failed to add special tokens: %w", err)
+	}
+
+	byteToToken, err := buildByteToToken(revVocab)
+	if err != nil {
+		return nil, fmt.Errorf("failed to build bytesToToken : %w", err)
+	}
+
+	ids := make(map[string]int, len(revVocab))
+	for id, bs := range revVocab {
+		if len(bs) > 0 {
+			ids[string(bs)] = id
+		}
+	}
+
+	pairRank := make(map[uint64]int, len(revVocab)*4)
+	maxRank := 0
+	// special tokens are never the product of a merge
+	for id, bs := range revVocab[:ranked] {
+		for k := 1; k < len(bs); k++ {
+			left, ok := ids[string(bs[:k])]
+			if !ok {
+				continue
+			}
+			right, ok := ids[string(bs[k:])]
+			if !ok {
+				continue
+			}
+			pairRank[packPair(left, right)] = id
+			maxRank = max(maxRank, id)
+		}
+	}
+
+	// byte-level inputs are raw bytes here, there is no GPT-2 style unicode alphabet
+	tok, err := newTokenizer(revVocab, byteToToken, byteToToken, pairRank, maxRank, 0)
+	if err != nil {
+		return nil, err
+	}
+	return tok.finishLoad(opts)
+}
+
+// countHoles counts the ranks parseTiktokenRanks left empty.
+func countHoles(revVocab [][]byte) int {
+	n := 0
+	for _, bs := range revVocab {
+		if bs == nil {
+			n++
+		}
+	}
+	return n
+}
+
+// parseTiktokenRanks decodes a rank file into revVocab. Ranks must cover 0..n-1 exactly once, or at most
+// once with allowHoles, which leaves the missing ones empty.
+func parseTiktokenRanks(data []byte, allowHoles bool) ([][]byte, error) {
+	var revVocab [][]byte
+	seen := make(map[string]bool)
+
+	sc := bufio.NewScanner(bytes.NewReader(data))
+	sc.Buffer(make([]byte, 0, 64*1024), 1<<20)
+	line := 0
+	for sc.Scan() {
+		line++
+		raw := bytes.TrimSpace(sc.Bytes())
+		if len(raw) == 0 {
+			continue
+		}
+
+		fields := bytes.Fields(raw)
+		if len(fields) != 2 {
+			return nil, fmt.Errorf("tiktoken line %d: want \"<base64 token> <rank>\", got %q", line, raw)
+		}
+
+		tok := make([]byte, base64.StdEncoding.DecodedLen(len(fields[0])))
+		n, err := base64.StdEncoding.Decode(tok, fields[0])
+		if err != nil {
+			return nil, fmt.Errorf("tiktoken line %d: bad token: %v", line, err)
+		}
+		tok = tok[:n]
+		if len(tok) == 0 {
+			return nil, fmt.Errorf("tiktoken line %d: empty token", line)
+		}
+		if seen[string(tok)] {
+			return nil, fmt.Errorf("tiktoken line %d: duplicate token %q", line, tok)
+		}
+		seen[string(tok)] = true
+
+		rank, err := strconv.Atoi(string(fields[1]))
+		if err != nil || rank < 0 || rank > 1<<31-1 {
+			return nil, fmt.Errorf("tiktoken line %d: bad rank %q", line, fields[1])
+		}
+
+		if rank >= len(revVocab) {
+			if rank > maxHoleyVocab(len(seen)) {
+				return nil, fmt.Errorf("tiktoken line %d: rank %d leaves too large a gap", line, rank)
+			}
+			revVocab = append(revVocab, make([][]byte, rank+1-len(revVocab))...)
+		}
+		if revVocab[rank] != nil {
+			return nil, fmt.Errorf("tiktoken line %d: duplicate rank %d", line, rank)
+		}
+		revVocab[rank] = tok
+	}
+	if err := sc.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read tiktoken file : %w", err)
+	}
+
+	if len(revVocab) == 0 {
+		return nil, fmt.Errorf("tiktoken file has no tokens")
+	}
+	for id, bs := range revVocab {
+		if bs == nil && !allowHoles {
+			return nil, fmt.Errorf("%w: tiktoken ranks missing %d", ErrVocabNotDense, id)
+		}
+	}
+	return revVocab, nil
+}
@@ -2,9 +2,16 @@ package core
 
 import (
 	"bufio"
-	"encoding/json"
+	"bytes"
+	"cmp"
+	"errors"
 	"fmt"
+	"io"
+	"io/fs"
+	"maps"
 	"os"
+	"slices"
+	"sort"
 	"strings"
 	"sync"
 	"unicode/utf8"
@@ -15,8 +22,9 @@ type Encoder interface {
 	/*
 		Feed consumes the next chunk of raw bytes from the input stream. It may emit zero or more
 		completed token IDs.
-		The returned slice is allowed to alias internal memory (zero-copy) so the caller must treat it as read-only
-		and make a copy if they want edits.
+		By default the returned slice is the caller's. An encoder built with zero-copy output returns one that
+		aliases internal memory instead, valid only until the next call on the encoder, so the caller must treat
+		it as read-only and make a copy if they want to keep or edit it. Flush returns under the same rule.
 	*/
 	Feed(chunk []byte) []int
 
@@ -28,26 +36,36 @@ type Encoder interface {
 	Flush() []int
 }
 
-// Decoder interface, no need for flush right now because we won't be maintaining internal buffer
+// Decoder interface
 type Decoder interface {
 	/*
 		Feed consumes token IDs and returns zero or more decoded bytes. Same as the encoder, there is a zero-copy rule;
 		returned slice can alias internal memory, call must treat it as read-only
 	*/
 	Feed(tokens []int) []byte
+
+	/*
+		Flush returns whatever the decoder was still holding back (e.g. the first bytes of a UTF-8 sequence whose
+		remaining bytes never arrived) and resets it for a new stream.
+	*/
+	Flush() []byte
+
+	/*
+		Err returns the error that stopped the stream, e.g. an invalid token ID. Once set, Feed returns
+		nothing more; it stays set through Flush and is cleared by the first Feed of the next stream.
+	*/
+	Err() error
 }
 
 // Tokenizer holds immutable model data derived from a BPE vocab/merges set which is safe for concurrent use.
 // Invariants we maintain:
-//   - revVocab[id] is the exact byte sequence for token ID 'id'.
+//   - vocab.bytes(id) is the exact byte sequence for token ID 'id'.
 //   - For every byte b in [0..255], byteToToken[b] gives a valid base token ID.
 //   - If pairRank[packPair(A,B)] exists, then pairToken[packPair(A,B)] exists and
 //     pairToken[packPair(A,B)] = C is the token ID produced by merging A then B.
 type Tokenizer struct {
-	// for decoding, index = token_id, value is byte sequence
-	RevVocab [][]byte
-	// tokenLen caches the byte length of each token to avoid repeated len(revVocab[id]) lookups
-	tokenLen []int
+	// for decoding, every token's byte sequence packed into one arena indexed by token id
+	vocab vocabArena
 	//  seed the first pass of encoder from raw bytes
 	//  in a byte-level BPE tokenizer, every possible byte 0..255 must have a mapping.
 	byteToToken [256]int
@@ -66,12 +84,59 @@ type Tokenizer struct {
 	pairInfo map[uint64]uint64
 	// pairLookup provides fast O(1) lookup for common pairs using 2D array
 	pairLookup *PairLookup
-	// TODO: for streaming
+	// maxMergeDepth is the height of the deepest merge tree, base tokens have depth 0. Streaming encoders
+	// use it to bound how far back a change at the end of the input can reach.
 	maxMergeDepth   int
 	MaxTokenByteLen int
 	maxRank         int // maximum rank value for bucket queue sizing
-
-	scratchPool sync.Pool
+	droppedMerges   int // merges lines ignored by a lenient load
+	// capped holds the rank of every merge LoadOptions.MaxRank left out, nil without a cap
+	capped map[uint64]int
+	// algorithmVersion is the AlgorithmVersion the tables were built under
+	algorithmVersion int
+
+	scratchPool scratchPool
+
+	// interned is the InternedStrings table, built on first use
+	internOnce sync.Once
+	interned   []string
+
+	// fingerprint is the Fingerprint hash, computed on first use
+	fingerprintOnce sync.Once
+	fingerprint     string
+
+	// joins is the CanJoin bitset over byte pairs, built on first use
+	joinsOnce sync.Once
+	joins     *[256 * 256 / 64]uint64
+
+	// specialMatcher finds every special token, built on first use, see SpecialMatcher
+	specialOnce    sync.Once
+	specialMatcher *SpecialMatcher
+
+	// substr is the TokensContaining index, built on first use or at load with WithSubstringIndex, which
+	// sets substrAtLoad
+	substrOnce   sync.Once
+	substr       *substringIndex
+	substrAtLoad bool
+
+	// partial maps the character prefix tokens a SentencePiece model needs to the byte tokens they stand
+	// for when their character never completes, see SentencePieceBytes. nil for every other format.
+	partial map[int][]int
+
+	// borrowed is set when vocab.data aliases a caller's buffer, see CompiledBorrowed and Freeze
+	borrowed bool
+
+	// normalization, addPrefixSpace, preTokenization, digitGroup and specialTokens are recorded from LoadOptions, see
+	// finishLoad.
+	// specialIDs is the set of specialTokens' IDs, specialRoles maps special_tokens_map.json roles to IDs,
+	// see HFDirFS.
+	normalization   Normalization
+	addPrefixSpace  bool
+	preTokenization PreTokenization
+	digitGroup      int
+	specialTokens   map[string]int
+	specialIDs      map[int]bool
+	specialRoles    map[string]int
 
 	UseUnicodeInitTokens bool // backward-compatible switch
 }
@@ -79,16 +144,70 @@ type Tokenizer struct {
 // LoadTokenizerFromFiles builds a tokenizer from vocab and merges
 // vocabPath and mergesPath are raw file paths
 func LoadTokenizerFromFiles(vocabPath, mergesPath string) (*Tokenizer, error) {
+	return LoadTokenizerFromFilesWithOptions(vocabPath, mergesPath, LoadOptions{})
+}
+
+// LoadTokenizerFromFilesWithOptions is LoadTokenizerFromFiles with knobs, see LoadOptions.
+func LoadTokenizerFromFilesWithOptions(vocabPath, mergesPath string, opts LoadOptions) (*Tokenizer, error) {
 	data, err := os.ReadFile(vocabPath)
 	if err != nil {
 		return nil, fmt.Errorf("error while reading vocab file : %w", err)
 	}
 
-	var vocab map[string]int
-	if err := json.Unmarshal(data, &vocab); err != nil {
+	mergesLines, err := readLines(mergesPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read mergs: %w", err)
+	}
+
+	return loadTokenizer(data, mergesLines, mergesPath, opts)
+}
+
+// LoadTokenizerFromBytes builds a tokenizer from the contents of vocab.json and merges.txt, e.g. assets
+// pulled in with go:embed. Nothing is retained from either slice, the caller is free to reuse them.
+func LoadTokenizerFromBytes(vocab, merges []byte) (*Tokenizer, error) {
+	return LoadTokenizerFromBytesWithOptions(vocab, merges, LoadOptions{})
+}
+
+// LoadTokenizerFromBytesWithOptions is LoadTokenizerFromBytes with knobs, see LoadOptions.
+func LoadTokenizerFromBytesWithOptions(vocab, merges []byte, opts LoadOptions) (*Tokenizer, error) {
+	mergesLines, err := scanLines(bytes.NewReader(merges))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read mergs: %w", err)
+	}
+
+	return loadTokenizer(vocab, mergesLines, "<bytes>", opts)
+}
+
+// LoadTokenizerFromReaders builds a tokenizer from readers over vocab.json and merges.txt. Both are read
+// to EOF and left open.
+func LoadTokenizerFromReaders(vocab, merges io.Reader) (*Tokenizer, error) {
+	return loadTokenizerFromReaders(vocab, merges, "<reader>", LoadOptions{})
+}
+
+// LoadTokenizerFS builds a tokenizer from vocab.json and merges.txt inside fsys, e.g. an embed.FS or a
+// zip archive.
+func LoadTokenizerFS(fsys fs.FS, vocabPath, mergesPath string) (*Tokenizer, error) {
+	return Load(FS(fsys, vocabPath, mergesPath))
+}
+
+// loadTokenizer does the actual build once both inputs are in memory.
+// mergesSource only names the merges input in warnings.
+func loadTokenizer(data []byte, mergesLines []string, mergesSource string, opts LoadOptions) (*Tokenizer, error) {
+	vocab, err := ParseVocabJSON(data)
+	if err != nil {
 		return nil, fmt.Errorf("error while unmarshalling vocab: %w", err)
 	}
 
+	return buildTokenizer(vocab, nil, mergesLines, mergesSource, opts)
+}
+
+// buildTokenizer builds a tokenizer from a decoded GPT-2 style vocab. added holds tokens that sit next to
+// the vocab rather than in it, like tokenizer.json's added_tokens, and is checked and appended the same way
+// as opts.SpecialTokens, without being reported as special.
+func buildTokenizer(vocab map[string]int, added map[string]int, mergesLines []string, mergesSource string, opts LoadOptions) (*Tokenizer, error) {
+	codec := opts.byteCodec()
+	vocab, mergesLines = replaceBytePieces(vocab, mergesLines, codec)
+
 	maxID := -1
 	seen := make(map[int]bool)
 	for _, id := range vocab {
@@ -98,33 +217,48 @@ func LoadTokenizerFromFiles(vocabPath, mergesPath string) (*Tokenizer, error) {
 		}
 	}
 
-	for i := 0; i <= maxID; i++ {
-		if !seen[i] {
-			return nil, fmt.Errorf("vocab not dense and missing %d", i)
+	if !opts.AllowVocabHoles && len(seen) != maxID+1 {
+		if !opts.Lenient {
+			missing := 0
+			for seen[missing] {
+				missing++
+			}
+			return nil, fmt.Errorf("%w: missing %d", ErrVocabNotDense, missing)
 		}
+		opts.logger().Printf("bpetok: vocab has %d unused ids below %d, loading them as holes", maxID+1-len(seen), maxID+1)
+		opts.AllowVocabHoles = true
+	}
+	if opts.AllowVocabHoles && maxID > maxHoleyVocab(len(vocab)) {
+		return nil, fmt.Errorf("vocab id %d leaves too large a gap for %d tokens", maxID, len(vocab))
 	}
 
-	revVocab, err := buildRevVocab(vocab, len(vocab))
+	revVocab, err := buildRevVocab(vocab, maxID+1, opts.AllowVocabHoles, codec)
 	if err != nil {
 		return nil, fmt.Errorf("failed to build revVocab: %w", err)
 	}
 
-	maxLen := 0
-	tokenLen := make([]int, len(revVocab))
-	for id, bytes := range revVocab {
-		n := len(bytes)
-		tokenLen[id] = n
-		if n > maxLen {
-			maxLen = n
+	extra := opts.SpecialTokens
+	if len(added) > 0 {
+		extra = maps.Clone(added)
+		for text, id := range opts.SpecialTokens {
+			if have, ok := extra[text]; ok && have != id {
+				return nil, fmt.Errorf("special token %q has id %d, but the tokenizer already has it as %d", text, id, have)
+			}
+			extra[text] = id
 		}
 	}
 
+	revVocab, err = appendSpecialTokens(revVocab, extra, opts.AllowVocabHoles)
+	if err != nil {
+		return nil, fmt.Errorf("failed to add special tokens: %w", err)
+	}
+
 	byteToToken, err := buildByteToToken(revVocab)
 	if err != nil {
 		return nil, fmt.Errorf("failed to build bytesToToken : %w", err)
 	}
 
-	unicodeByteToToken, err := buildUnicodeByteToToken(vocab)
+	unicodeByteToToken, err := buildUnicodeByteToToken(vocab, codec)
 	if err != nil {
 		return nil, fmt.Errorf("failed to build unicodeByteToToken : %w", err)
 	}
@@ -133,54 +267,145 @@ func LoadTokenizerFromFiles(vocabPath, mergesPath string) (*Tokenizer, error) {
 	// ---------------------------------------------------- onto merges now
 	// --------------------------------------------------------------------
 
-	mergesLines, err := readLines(mergesPath)
-	if err != nil {
-		return nil, fmt.Errorf("failed to read mergs: %w", err)
-	}
-
-	pairRank, maxRank, err := buildPairRank(mergesLines, vocab)
+	pairRank, maxRank, dropped, skipped, err := buildPairRank(mergesLines, vocab, revVocab, unicodeByteToToken, opts.AllowTruncatedMerges || opts.Lenient, opts.Lenient)
 	if err != nil {
 		return nil, fmt.Errorf("error while building pairRank : %w", err)
 	}
+	if skipped > 0 {
+		opts.logger().Printf("bpetok: merges file %s has %d malformed lines, lines naming unknown tokens or repeats of a merge, skipped them", mergesSource, skipped)
+	}
+	if dropped > 0 {
+		opts.logger().Printf("bpetok: merges file %s looks truncated, loaded %d merges and dropped %d trailing lines", mergesSource, len(pairRank), dropped)
+	}
+	dropped += skipped
 
-	pairToken, err := buildPairToken(revVocab, pairRank)
+	tok, err := newTokenizer(revVocab, byteToToken, unicodeByteToToken, pairRank, maxRank, dropped)
 	if err != nil {
-		return nil, fmt.Errorf("failed to build pairToken : %w", err)
+		return nil, err
 	}
+	return tok.finishLoad(opts)
+}
 
-	// Build combined pairInfo map for faster lookups
-	pairInfo := make(map[uint64]uint64, len(pairRank))
-	for key, rank := range pairRank {
-		token := pairToken[key]
-		// Pack rank in upper 32 bits, token in lower 32 bits
-		pairInfo[key] = (uint64(rank) << 32) | uint64(token)
+// newTokenizer builds the lookup structures shared by every vocab format from the decoded vocab and the
+// pair ranks.
+func newTokenizer(revVocab [][]byte, byteToToken, unicodeByteToToken [256]int, pairRank map[uint64]int, maxRank, dropped int) (*Tokenizer, error) {
+	var (
+		pairToken         map[uint64]int
+		arena             vocabArena
+		pairErr, vocabErr error
+	)
+	parallel(
+		func() { pairToken, pairErr = buildPairToken(revVocab, pairRank) },
+		func() { arena, vocabErr = newVocabArena(revVocab) },
+	)
+	if pairErr != nil {
+		return nil, fmt.Errorf("failed to build pairToken : %w", pairErr)
+	}
+	if vocabErr != nil {
+		return nil, fmt.Errorf("failed to build vocab arena : %w", vocabErr)
 	}
 
-	// Build fast lookup structure (2D array for common pairs)
-	pairLookup := NewPairLookup(pairInfo, len(revVocab))
+	// the merge depth only feeds one field, so it's worked out alongside the pair tables
+	var tok *Tokenizer
+	var depth int
+	parallel(
+		func() {
+			tok = assembleTokenizer(arena, byteToToken, unicodeByteToToken, pairRank, pairToken, maxRank, dropped, 0)
+		},
+		func() { depth = buildMaxMergeDepth(pairRank, pairToken) },
+	)
+	tok.maxMergeDepth = depth
+	return tok, nil
+}
+
+// assembleTokenizer derives the merge-loop lookups from the vocab arena and the pair tables. It is the part
+// of loading that every source shares, compiled files included.
+func assembleTokenizer(arena vocabArena, byteToToken, unicodeByteToToken [256]int, pairRank, pairToken map[uint64]int, maxRank, dropped, maxMergeDepth int) *Tokenizer {
+	maxLen := 0
+	var pairInfo map[uint64]uint64
+	var pairLookup *PairLookup
+	parallel(func() {
+		// Build combined pairInfo map for faster lookups
+		pairInfo = make(map[uint64]uint64, len(pairRank))
+		for key, rank := range pairRank {
+			token := pairToken[key]
+			// Pack rank in upper 32 bits, token in lower 32 bits
+			pairInfo[key] = (uint64(rank) << 32) | uint64(token)
+		}
+
+		// Build fast lookup structure (2D array for common pairs)
+		pairLookup = NewPairLookup(pairInfo, arena.size())
+	}, func() {
+		for id := range arena.size() {
+			maxLen = max(maxLen, arena.tokenLen(id))
+		}
+	})
 
 	return &Tokenizer{
-		RevVocab:           revVocab,
-		tokenLen:           tokenLen,
+		vocab:              arena,
 		byteToToken:        byteToToken,
 		unicodeByteToToken: unicodeByteToToken,
 		pairRank:           pairRank,
 		pairToken:          pairToken,
 		pairInfo:           pairInfo,
 		pairLookup:         pairLookup,
-		maxMergeDepth:      0,
+		maxMergeDepth:      maxMergeDepth,
 		MaxTokenByteLen:    maxLen,
 		maxRank:            maxRank,
-	}, nil
+		droppedMerges:      dropped,
+		algorithmVersion:   AlgorithmVersion,
+	}
+}
+
+// Stats summarises load-time properties of a tokenizer, mostly useful for tuning and diagnostics.
+type Stats struct {
+	VocabSize       int
+	Merges          int
+	MaxTokenByteLen int
+	MaxRank         int
+	MaxMergeDepth   int
+	// DroppedMerges is the number of merges lines a lenient load ignored, see LoadOptions.Lenient.
+	DroppedMerges int
+	// CappedMerges is the number of merges LoadOptions.MaxRank left out.
+	CappedMerges int
+	// PairLookup is the dense window picked for the pair table at load time.
+	PairLookup PairLookupConfig
+}
 
+// Stats reports the configuration the tokenizer was built with.
+func (t *Tokenizer) Stats() Stats {
+	return Stats{
+		VocabSize:       t.vocab.size(),
+		Merges:          len(t.pairRank),
+		MaxTokenByteLen: t.MaxTokenByteLen,
+		MaxRank:         t.maxRank,
+		MaxMergeDepth:   t.maxMergeDepth,
+		DroppedMerges:   t.droppedMerges,
+		CappedMerges:    len(t.capped),
+		PairLookup:      t.pairLookup.Config(),
+	}
+}
+
+// VocabSize returns the number of token IDs, valid IDs are [0, VocabSize()).
+func (t *Tokenizer) VocabSize() int {
+	return t.vocab.size()
+}
+
+// TokenBytes returns the raw bytes of the given token ID, or nil if the ID is out of range.
+// The slice aliases the tokenizer's vocab and must be treated as read-only.
+func (t *Tokenizer) TokenBytes(id int) []byte {
+	if id < 0 || id >= t.vocab.size() {
+		return nil
+	}
+	return t.vocab.bytes(id)
 }
 
 // TokenLen returns the byte length of the given token ID
 func (t *Tokenizer) TokenLen(id int) int {
-	if id < 0 || id >= len(t.tokenLen) {
+	if id < 0 || id >= t.vocab.size() {
 		return 0
 	}
-	return t.tokenLen[id]
+	return t.vocab.tokenLen(id)
 }
 
 // GetByteToToken returns the token ID for a given byte
@@ -204,6 +429,52 @@ func (t *Tokenizer) GetMaxRank() int {
 	return t.maxRank
 }
 
+// CappedRank returns the rank of the merge of (a, b) if LoadOptions.MaxRank left it out of the table.
+func (t *Tokenizer) CappedRank(a, b int) (int, bool) {
+	if t.capped == nil {
+		return 0, false
+	}
+	rank, ok := t.capped[uint64(a)<<32|uint64(b)]
+	return rank, ok
+}
+
+// HasCappedRanks reports whether LoadOptions.MaxRank left any merge out.
+func (t *Tokenizer) HasCappedRanks() bool {
+	return len(t.capped) > 0
+}
+
+// capRanks moves every merge ranked above maxRank from the pair tables to capped and rebuilds the lookups
+// and the merge depth from what is left. Only a tokenizer still being loaded may be capped.
+func (t *Tokenizer) capRanks(maxRank int) {
+	if maxRank >= t.maxRank {
+		return
+	}
+	pairRank := make(map[uint64]int, len(t.pairRank))
+	pairToken := make(map[uint64]int, len(t.pairRank))
+	capped := make(map[uint64]int)
+	for key, rank := range t.pairRank {
+		if rank > maxRank {
+			capped[key] = rank
+			continue
+		}
+		pairRank[key], pairToken[key] = rank, t.pairToken[key]
+	}
+	if len(capped) == 0 {
+		return
+	}
+
+	rebuilt := assembleTokenizer(t.vocab, t.byteToToken, t.unicodeByteToToken, pairRank, pairToken, maxRank,
+		t.droppedMerges, buildMaxMergeDepth(pairRank, pairToken))
+	t.pairRank, t.pairToken, t.pairInfo, t.pairLookup = pairRank, pairToken, rebuilt.pairInfo, rebuilt.pairLookup
+	t.maxRank, t.maxMergeDepth = maxRank, rebuilt.maxMergeDepth
+	t.capped = capped
+}
+
+// MaxMergeDepth returns the height of the deepest merge tree in the vocab.
+func (t *Tokenizer) MaxMergeDepth() int {
+	return t.maxMergeDepth
+}
+
 func (t *Tokenizer) GetPairRank(a, b int) (int, bool) {
 	info, ok := t.pairLookup.Lookup(a, b)
 	if !ok {
@@ -223,6 +494,19 @@ func (t *Tokenizer) GetPairToken(a, b int) (int, bool) {
 	return tokenID, true
 }
 
+// pairsByRank returns the keys of pairRank in rank order. Tiktoken and SentencePiece vocabs give many pairs
+// the same rank, ties go by key so the order never depends on map iteration.
+func (t *Tokenizer) pairsByRank() []uint64 {
+	keys := make([]uint64, 0, len(t.pairRank))
+	for key := range t.pairRank {
+		keys = append(keys, key)
+	}
+	slices.SortFunc(keys, func(a, b uint64) int {
+		return cmp.Or(cmp.Compare(t.pairRank[a], t.pairRank[b]), cmp.Compare(a, b))
+	})
+	return keys
+}
+
 // buildByteToToken constructs the [256]int lookup table that maps a single raw
 // byte value (0..255) to the token ID that represents exactly that byte.
 func buildByteToToken(revVocab [][]byte) ([256]int, error) {
@@ -252,13 +536,11 @@ func buildByteToToken(revVocab [][]byte) ([256]int, error) {
 	return table, nil
 }
 
-func buildUnicodeByteToToken(vocab map[string]int) ([256]int, error) {
-	encoder := buildCursedByteEncoder()
+func buildUnicodeByteToToken(vocab map[string]int, codec ByteCodec) ([256]int, error) {
 	var table [256]int
 
 	for b := 0; b < 256; b++ {
-		r := encoder[byte(b)]
-		tokenStr := string(r)
+		tokenStr := codec.ByteText(byte(b))
 
 		id, ok := vocab[tokenStr]
 		if !ok {
@@ -272,36 +554,59 @@ func buildUnicodeByteToToken(vocab map[string]int) ([256]int, error) {
 }
 
 // buildRevVocab takes the parsed vocab.json (tokenString -> id) and returns revVocab[id] = raw bytes for that token id.
-// vocabSize should be the expected number of IDs (e.g. 50257).
-func buildRevVocab(vocab map[string]int, vocabSize int) ([][]byte, error) {
-	if len(vocab) != vocabSize {
+// vocabSize should be the expected number of IDs (e.g. 50257). With allowHoles, IDs missing from vocab are
+// left empty, see LoadOptions.AllowVocabHoles. codec turns each key into its bytes.
+func buildRevVocab(vocab map[string]int, vocabSize int, allowHoles bool, codec ByteCodec) ([][]byte, error) {
+	if len(vocab) != vocabSize && !allowHoles {
 		return nil, fmt.Errorf("vocab length mismatch. expected %d, received. %d", vocabSize, len(vocab))
 	}
 
-	byteDecoder := buildCursedByteDecoder()
-
-	revVocab := make([][]byte, vocabSize)
+	// keys are indexed by id first, which also keeps the parallel decode below from writing a slot twice
+	keys := make([]string, vocabSize)
+	used := make([]bool, vocabSize)
 	for tokenStr, id := range vocab {
 		if id < 0 || id >= vocabSize {
 			return nil, fmt.Errorf("token id out of range : %d", id)
 		}
-
-		tokenBytes, err := decodeTokenString(tokenStr, byteDecoder)
-		if err != nil {
-			return nil, fmt.Errorf("failed to decode token %s at index %d", tokenStr, id)
+		if used[id] {
+			return nil, fmt.Errorf("token id %d is used by more than one token", id)
 		}
+		keys[id], used[id] = tokenStr, true
+	}
 
-		if len(tokenBytes) == 0 {
-			return nil, fmt.Errorf("decoded empty byte sequence for token id %d and token string %s", id, tokenStr)
-		}
+	revVocab := make([][]byte, vocabSize)
+	errs := make([]error, vocabSize)
+	parallelRange(vocabSize, func(lo, hi int) {
+		for id := lo; id < hi; id++ {
+			if !used[id] {
+				continue
+			}
+			tokenStr := keys[id]
 
-		bcopy := make([]byte, len(tokenBytes)) // allocate a brand-new slice of bytes, initially full of zeroes
-		copy(bcopy, tokenBytes)                // copy the actual bytes from tokenBytes into bcopy
-		revVocab[id] = bcopy                   // store the copy in revVocab
+			tokenBytes, err := codec.DecodeToken(tokenStr)
+			if err != nil {
+				errs[id] = fmt.Errorf("failed to decode token %s at index %d", tokenStr, id)
+				continue
+			}
+
+			if len(tokenBytes) == 0 {
+				errs[id] = fmt.Errorf("decoded empty byte sequence for token id %d and token string %s", id, tokenStr)
+				continue
+			}
+
+			bcopy := make([]byte, len(tokenBytes)) // allocate a brand-new slice of bytes, initially full of zeroes
+			copy(bcopy, tokenBytes)                // copy the actual bytes from tokenBytes into bcopy
+			revVocab[id] = bcopy                   // store the copy in revVocab
+		}
+	})
+	for _, err := range errs {
+		if err != nil {
+			return nil, err
+		}
 	}
 
 	// validate all slots
-	for i := 0; i < vocabSize; i++ {
+	for i := 0; i < vocabSize && !allowHoles; i++ {
 		if len(revVocab[i]) == 0 {
 			return nil, fmt.Errorf("revVocab[%d] is unset ", i)
 		}
@@ -309,6 +614,9 @@ func buildRevVocab(vocab map[string]int, vocabSize int) ([][]byte, error) {
 
 	seen := make(map[string]int, vocabSize)
 	for id, b := range revVocab {
+		if len(b) == 0 {
+			continue
+		}
 		k := string(b)
 		if prev, exists := seen[k]; exists {
 			return nil, fmt.Errorf("duplicate byte sequence found. check id %d and %d", prev, id)
@@ -319,6 +627,58 @@ func buildRevVocab(vocab map[string]int, vocabSize int) ([][]byte, error) {
 
 }
 
+// replaceBytePieces rewrites the "<0x0A>" style byte fallback pieces some vocabs use for raw bytes to the
+// codec's spelling of the byte (the GPT-2 unicode stand-in by default), in the vocab and wherever a merge
+// names one, so the rest of loading only deals with the codec's spelling. A piece is only read as its byte
+// when the vocab has no other spelling for that byte; otherwise it is an ordinary token spelling those six
+// characters. Without pieces both are returned as is.
+func replaceBytePieces(vocab map[string]int, mergesLines []string, codec ByteCodec) (map[string]int, []string) {
+	var standIn map[string]string
+	for b := 0; b < 256; b++ {
+		r := codec.ByteText(byte(b))
+		if _, ok := vocab[r]; ok {
+			continue
+		}
+		piece := fmt.Sprintf("<0x%02X>", b)
+		if _, ok := vocab[piece]; !ok {
+			continue
+		}
+		if standIn == nil {
+			standIn = make(map[string]string)
+		}
+		standIn[piece] = r
+	}
+	if standIn == nil {
+		return vocab, mergesLines
+	}
+
+	vocab = maps.Clone(vocab)
+	for piece, r := range standIn {
+		vocab[r] = vocab[piece]
+		delete(vocab, piece)
+	}
+
+	lines := make([]string, len(mergesLines))
+	for i, line := range mergesLines {
+		clean, ok := cleanMergeLine(line)
+		if parts := splitMergeLine(clean); ok && len(parts) == 2 {
+			left, okL := standIn[parts[0]]
+			right, okR := standIn[parts[1]]
+			if okL || okR {
+				if !okL {
+					left = parts[0]
+				}
+				if !okR {
+					right = parts[1]
+				}
+				line = left + " " + right
+			}
+		}
+		lines[i] = line
+	}
+	return vocab, lines
+}
+
 // decodeTokenString turns a vocab.json key (which might contain those weird
 // extended unicode stand-ins for bytes) back into the real raw bytes that
 // token represents
@@ -455,46 +815,182 @@ func packPair(a, b int) uint64 {
 
 // buildPairRank assigns a rank (0 being highest) to each pair of tokens in the merges dataset
 // the merges dataset comes to us as a pair of utf-8 encoded strings, which we map to token ids using vocab
-// the function also contains a validation step that ensures merges doesn't contain duplicate entries
-// Returns the pairRank map, maxRank value, and any error
-func buildPairRank(mergesLines []string, vocabMap map[string]int) (map[uint64]int, int, error) {
+// the function also contains a validation step that ensures merges doesn't contain duplicate entries, and that
+// every merge only uses tokens that are base bytes or the output of an earlier merge. The latter is how we spot
+// a truncated file; with allowTruncated we keep the valid prefix and report how many lines were dropped.
+// Malformed lines fail with ErrMalformedMerges, and only count as truncation when nothing follows them.
+// With skipBad, malformed lines, lines naming unknown tokens and duplicates are skipped on their own and
+// counted in skipped.
+// Returns the pairRank map, maxRank value, dropped line count, skipped line count, and any error
+func buildPairRank(mergesLines []string, vocabMap map[string]int, revVocab [][]byte, baseTokens [256]int, allowTruncated, skipBad bool) (map[uint64]int, int, int, int, error) {
 	pairRank := make(map[uint64]int, len(mergesLines))
 
+	// a merge produces the token holding both sides' bytes. Concatenating the keys would only work for
+	// codecs that spell every byte with its own rune, RawByteCodec's byte pieces don't.
+	byBytes := make(map[string]int, len(vocabMap))
+	for _, id := range vocabMap {
+		byBytes[string(revVocab[id])] = id
+	}
+	merged := func(left, right int) (int, bool) {
+		id, ok := byBytes[string(revVocab[left])+string(revVocab[right])]
+		return id, ok
+	}
+
+	producible := make(map[int]bool, len(vocabMap))
+	for _, id := range baseTokens {
+		producible[id] = true
+	}
+
+	// resolving lines to IDs doesn't depend on the merge order, only the producibility check below does
+	resolved := make([]resolvedMerge, len(mergesLines))
+	parallelRange(len(mergesLines), func(lo, hi int) {
+		for i := lo; i < hi; i++ {
+			resolved[i] = resolveMergeLine(mergesLines[i], vocabMap, merged)
+		}
+	})
+
 	rank := 0
 	maxRank := 0
-	for _, line := range mergesLines {
-		line = strings.TrimSpace(line)
-		if line == "" || strings.HasPrefix(line, "#") { // skip this line
+	skipped := 0
+	for lineNo, r := range resolved {
+		if r.skip {
 			continue
 		}
-		parts := strings.Fields(line)
-		if len(parts) != 2 {
-			return nil, 0, fmt.Errorf("invalid merge line %q, we want exactly two items per line", line)
-		}
-
-		leftStr := parts[0]
-		rightStr := parts[1]
 
-		leftID, ok1 := vocabMap[leftStr]
-		rightID, ok2 := vocabMap[rightStr]
-
-		if !ok1 || !ok2 {
-			return nil, 0, fmt.Errorf("failed to find a vocab entry for an entry in merges. left: %v, right: %v", &leftStr, &rightStr)
+		leftID, rightID, mergedID, err := r.check(producible)
+		if err != nil {
+			// a malformed line is only a sign of truncation when it's the last one, cut in half
+			malformed := errors.Is(err, ErrMalformedMerges) && !lastMerge(resolved, lineNo)
+			if skipBad && (malformed || errors.Is(err, ErrMergeUnknownToken)) {
+				skipped++
+				continue
+			}
+			if malformed {
+				return nil, 0, 0, 0, fmt.Errorf("line %d: %w", lineNo+1, err)
+			}
+			if !allowTruncated {
+				return nil, 0, 0, 0, fmt.Errorf("%w: line %d: %w", ErrTruncatedMerges, lineNo+1, err)
+			}
+			return pairRank, maxRank, countMergeLines(mergesLines[lineNo:]), skipped, nil
 		}
 
 		key := packPair(leftID, rightID)
 		if _, exists := pairRank[key]; exists {
-			return nil, 0, fmt.Errorf("duplicate merge pair found in given merges, (%d, %d)", leftID, rightID)
+			if skipBad {
+				skipped++
+				continue
+			}
+			return nil, 0, 0, 0, fmt.Errorf("%w: line %d: pair (%d, %d) already merged", ErrDuplicateMerge, lineNo+1, leftID, rightID)
 		}
 
 		pairRank[key] = rank
+		producible[mergedID] = true
 		if rank > maxRank {
 			maxRank = rank
 		}
 		rank++
 	}
 
-	return pairRank, maxRank, nil
+	return pairRank, maxRank, 0, skipped, nil
+}
+
+// lastMerge reports whether no merge follows resolved[i].
+func lastMerge(resolved []resolvedMerge, i int) bool {
+	for _, r := range resolved[i+1:] {
+		if !r.skip {
+			return false
+		}
+	}
+	return true
+}
+
+// resolvedMerge is one merges line mapped to token IDs, before the merge order is taken into account.
+type resolvedMerge struct {
+	line                string
+	skip                bool // blank or the version header
+	left, right, merged int
+	err                 error // malformed, or a side missing from the vocab
+	noProduct           bool  // the pair's concatenation isn't in the vocab
+}
+
+// resolveMergeLine maps one "left right" merges line to token IDs. merged finds the token the pair
+// produces.
+func resolveMergeLine(line string, vocabMap map[string]int, merged func(left, right int) (int, bool)) resolvedMerge {
+	line, ok := cleanMergeLine(line)
+	if !ok {
+		return resolvedMerge{skip: true}
+	}
+	r := resolvedMerge{line: line}
+
+	parts := splitMergeLine(line)
+	if len(parts) != 2 {
+		r.err = fmt.Errorf("%w: invalid merge line %+q, we want exactly two items separated by ASCII spaces", ErrMalformedMerges, line)
+		return r
+	}
+
+	leftStr := parts[0]
+	rightStr := parts[1]
+
+	leftID, ok1 := vocabMap[leftStr]
+	rightID, ok2 := vocabMap[rightStr]
+
+	if !ok1 || !ok2 {
+		r.err = fmt.Errorf("%w: failed to find a vocab entry for an entry in merges. left: %+q, right: %+q", ErrMergeUnknownToken, leftStr, rightStr)
+		return r
+	}
+
+	r.left, r.right = leftID, rightID
+	r.merged, ok = merged(leftID, rightID)
+	r.noProduct = !ok
+	return r
+}
+
+// check returns the line's IDs once both sides can actually be produced at this point in the merge order.
+func (r resolvedMerge) check(producible map[int]bool) (int, int, int, error) {
+	if r.err != nil {
+		return 0, 0, 0, r.err
+	}
+
+	if !producible[r.left] || !producible[r.right] {
+		return 0, 0, 0, fmt.Errorf("merge %q uses a token no earlier merge produces", r.line)
+	}
+
+	if r.noProduct {
+		parts := splitMergeLine(r.line)
+		return 0, 0, 0, fmt.Errorf("%w: merge %+q produces %+q which is not in vocab", ErrMergeUnknownToken, r.line, parts[0]+parts[1])
+	}
+
+	return r.left, r.right, r.merged, nil
+}
+
+// mergeLineSpace is what merges lines are trimmed and split on. It is ASCII only: strings.Fields would also
+// split on a non-breaking or ideographic space inside a token and report a confusing field count.
+const mergeLineSpace = " \t\r\n"
+
+// cleanMergeLine trims a merges line and reports whether it holds a merge. Blank lines don't, and neither
+// does the "#version: 0.2" header Hugging Face writes, byte order mark or not; "# #" and friends are real
+// merges in GPT-2, so other lines starting with # count.
+func cleanMergeLine(line string) (string, bool) {
+	line = strings.Trim(strings.TrimPrefix(line, "\ufeff"), mergeLineSpace)
+	return line, line != "" && !strings.HasPrefix(line, "#version")
+}
+
+// splitMergeLine splits a cleaned merges line on runs of ASCII whitespace.
+func splitMergeLine(line string) []string {
+	return strings.FieldsFunc(line, func(r rune) bool {
+		return r < utf8.RuneSelf && strings.ContainsRune(mergeLineSpace, r)
+	})
+}
+
+// countMergeLines counts the lines that would have been treated as merges.
+func countMergeLines(lines []string) int {
+	n := 0
+	for _, line := range lines {
+		if _, ok := cleanMergeLine(line); ok {
+			n++
+		}
+	}
+	return n
 }
 
 // buildPairToken builds a mapping structure that maps a pair of token ids proposed by merges rules to an output token id
@@ -502,7 +998,9 @@ func buildPairToken(revVocab [][]byte, pairRank map[uint64]int) (map[uint64]int,
 	// init bytesToID, which is our temporary "reverse mapping of revVocab"
 	bytesToID := make(map[string]int, len(revVocab))
 	for id, bs := range revVocab {
-		bytesToID[string(bs)] = id
+		if len(bs) > 0 {
+			bytesToID[string(bs)] = id
+		}
 	}
 
 	pairToken := make(map[uint64]int, len(pairRank))
@@ -540,6 +1038,28 @@ func buildPairToken(revVocab [][]byte, pairRank map[uint64]int) (map[uint64]int,
 	return pairToken, nil
 }
 
+// buildMaxMergeDepth returns the height of the deepest merge tree. Merges are replayed in rank order, a
+// merge can only use tokens produced by lower ranks so each depth is final by the time it's read.
+func buildMaxMergeDepth(pairRank map[uint64]int, pairToken map[uint64]int) int {
+	keys := make([]uint64, 0, len(pairRank))
+	for key := range pairRank {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool { return pairRank[keys[i]] < pairRank[keys[j]] })
+
+	depth := make(map[int]int, len(keys))
+	maxDepth := 0
+	for _, key := range keys {
+		d := 1 + max(depth[int(key>>32)], depth[int(key&0xFFFFFFFF)])
+		merged := pairToken[key]
+		if d > depth[merged] {
+			depth[merged] = d
+		}
+		maxDepth = max(maxDepth, d)
+	}
+	return maxDepth
+}
+
 // readLines reads a text file into []string whilst preserving order
 func readLines(path string) ([]string, error) {
 	f, err := os.Open(path)
@@ -548,8 +1068,13 @@ func readLines(path string) ([]string, error) {
 	}
 	defer f.Close()
 
+	return scanLines(f)
+}
+
+// scanLines splits r into lines whilst preserving order
+func scanLines(r io.Reader) ([]string, error) {
 	var out []string
-	sc := bufio.NewScanner(f)
+	sc := bufio.NewScanner(r)
 	for sc.Scan() {
 		out = append(out, sc.Text())
 	}
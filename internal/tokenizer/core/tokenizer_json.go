@@ -0,0 +1,227 @@
+package core
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// ErrUnsupportedTokenizerJSON is returned for a tokenizer.json this package can't reproduce, e.g. a
+// SentencePiece style Metaspace model or a WordPiece vocab.
+var ErrUnsupportedTokenizerJSON = errors.New("unsupported tokenizer.json")
+
+// tokenizerJSON is the part of a HuggingFace tokenizer.json that matters for byte-level BPE. model.vocab is
+// left out, ParseTokenizerJSONVocab reads it without decoding the rest again.
+type tokenizerJSON struct {
+	AddedTokens []struct {
+		ID      int    `json:"id"`
+		Content string `json:"content"`
+		Special bool   `json:"special"`
+	} `json:"added_tokens"`
+	Normalizer   *tokenizerJSONStep `json:"normalizer"`
+	PreTokenizer *tokenizerJSONStep `json:"pre_tokenizer"`
+	Decoder      *tokenizerJSONStep `json:"decoder"`
+	Model        struct {
+		Type         string          `json:"type"`
+		Merges       json.RawMessage `json:"merges"`
+		ByteFallback bool            `json:"byte_fallback"`
+	} `json:"model"`
+}
+
+// tokenizerJSONStep is a normalizer, pre-tokenizer or decoder entry. Sequences nest under one of the
+// plural keys depending on the section.
+type tokenizerJSONStep struct {
+	Type           string `json:"type"`
+	AddPrefixSpace bool   `json:"add_prefix_space"`
+	// UseRegex is ByteLevel's, nil when the file leaves it out, which means true
+	UseRegex      *bool                `json:"use_regex"`
+	Normalizers   []*tokenizerJSONStep `json:"normalizers"`
+	PreTokenizers []*tokenizerJSONStep `json:"pretokenizers"`
+	Decoders      []*tokenizerJSONStep `json:"decoders"`
+}
+
+// flatten lists the steps of a Sequence, or the step itself.
+func (s *tokenizerJSONStep) flatten() []*tokenizerJSONStep {
+	if s == nil {
+		return nil
+	}
+	if s.Type != "Sequence" {
+		return []*tokenizerJSONStep{s}
+	}
+	var out []*tokenizerJSONStep
+	for _, list := range [][]*tokenizerJSONStep{s.Normalizers, s.PreTokenizers, s.Decoders} {
+		for _, child := range list {
+			out = append(out, child.flatten()...)
+		}
+	}
+	return out
+}
+
+type tokenizerJSONSource struct {
+	path string
+	data []byte
+	// label names data in errors and warnings, see HFDirFS
+	label string
+}
+
+// TokenizerJSONFile reads a HuggingFace tokenizer.json from disk, see TokenizerJSONBytes.
+func TokenizerJSONFile(path string) Source {
+	return tokenizerJSONSource{path: path}
+}
+
+// TokenizerJSONBytes uses an in-memory HuggingFace tokenizer.json holding a byte-level BPE model (GPT-2,
+// GPT-Neo, Llama-3 and the like). model.vocab and model.merges, in either the "a b" string or the
+// ["a", "b"] pair form, make up the tokenizer. added_tokens extend the vocab and the special ones are
+// reported by SpecialTokens. An NFC or NFKC normalizer becomes the tokenizer's normalization unless
+// WithNormalization picks another form, ByteLevel's add_prefix_space turns on AddPrefixSpace, and its
+// use_regex, on unless the file turns it off, becomes PreTokenizeGPT2 unless WithPreTokenization picks
+// another pre-tokenizer.
+//
+// The pre-tokenizer must include ByteLevel and the decoder, if any, must be ByteLevel; anything else is
+// ErrUnsupportedTokenizerJSON. Split regexes are not applied, merges run over the raw input, so IDs can
+// differ from HuggingFace's where those would have changed the input; a warning is logged when the file
+// asks for them. The post-processor is ignored.
+func TokenizerJSONBytes(data []byte) Source {
+	return tokenizerJSONSource{data: data}
+}
+
+func (s tokenizerJSONSource) load(opts LoadOptions) (*Tokenizer, error) {
+	data, source := s.data, "<bytes>"
+	if s.label != "" {
+		source = s.label
+	}
+	if s.path != "" {
+		var err error
+		if data, err = os.ReadFile(s.path); err != nil {
+			return nil, fmt.Errorf("error while reading tokenizer.json : %w", err)
+		}
+		source = s.path
+	}
+
+	var tj tokenizerJSON
+	if err := json.Unmarshal(data, &tj); err != nil {
+		return nil, fmt.Errorf("error while unmarshalling tokenizer.json: %w", err)
+	}
+
+	explicitNorm := opts.Normalization != NormalizeNone
+	norm, prefixSpace, preTok, warnings, err := tj.configure()
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", source, err)
+	}
+	if !explicitNorm {
+		opts.Normalization = norm
+	}
+	if opts.PreTokenization == PreTokenizeNone {
+		opts.PreTokenization = preTok
+	}
+	opts.AddPrefixSpace = opts.AddPrefixSpace || prefixSpace
+	for _, w := range warnings {
+		opts.logger().Printf("bpetok: %s: %s", source, w)
+	}
+
+	vocab, err := ParseTokenizerJSONVocab(data)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", source, err)
+	}
+
+	mergesLines, err := tj.mergesLines()
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", source, err)
+	}
+
+	added := make(map[string]int, len(tj.AddedTokens))
+	special := make(map[string]int)
+	for _, at := range tj.AddedTokens {
+		if have, ok := added[at.Content]; ok && have != at.ID {
+			return nil, fmt.Errorf("%s: added token %q listed as both %d and %d", source, at.Content, have, at.ID)
+		}
+		added[at.Content] = at.ID
+		if at.Special {
+			special[at.Content] = at.ID
+		}
+	}
+	for text, id := range opts.SpecialTokens {
+		special[text] = id
+	}
+	opts.SpecialTokens = special
+
+	return buildTokenizer(vocab, added, mergesLines, source, opts)
+}
+
+// configure checks the non-model sections and works out the normalization, prefix space and pre-tokenizer
+// they ask for, plus warnings for what will be ignored.
+func (tj *tokenizerJSON) configure() (norm Normalization, prefixSpace bool, preTok PreTokenization, warnings []string,
+	err error) {
+	if t := tj.Model.Type; t != "" && t != "BPE" {
+		return 0, false, 0, nil, fmt.Errorf("%w: model type %s", ErrUnsupportedTokenizerJSON, t)
+	}
+	if tj.Model.ByteFallback {
+		return 0, false, 0, nil, fmt.Errorf("%w: byte_fallback models are not byte-level", ErrUnsupportedTokenizerJSON)
+	}
+
+	for _, step := range tj.Normalizer.flatten() {
+		switch step.Type {
+		case "NFC":
+			norm = NormalizeNFC
+		case "NFKC":
+			norm = NormalizeNFKC
+		default:
+			return 0, false, 0, nil, fmt.Errorf("%w: normalizer %s", ErrUnsupportedTokenizerJSON, step.Type)
+		}
+	}
+
+	byteLevel := false
+	for _, step := range tj.PreTokenizer.flatten() {
+		switch step.Type {
+		case "ByteLevel":
+			byteLevel = true
+			prefixSpace = prefixSpace || step.AddPrefixSpace
+			if step.UseRegex == nil || *step.UseRegex {
+				preTok = PreTokenizeGPT2
+			}
+		case "Split", "Digits", "Punctuation", "Whitespace", "WhitespaceSplit":
+			warnings = append(warnings, fmt.Sprintf("%s pre-tokenizer is not applied, merges run over the raw input", step.Type))
+		default:
+			return 0, false, 0, nil, fmt.Errorf("%w: pre-tokenizer %s", ErrUnsupportedTokenizerJSON, step.Type)
+		}
+	}
+	if !byteLevel {
+		return 0, false, 0, nil, fmt.Errorf("%w: pre-tokenizer has no ByteLevel step", ErrUnsupportedTokenizerJSON)
+	}
+
+	for _, step := range tj.Decoder.flatten() {
+		if step.Type != "ByteLevel" {
+			return 0, false, 0, nil, fmt.Errorf("%w: decoder %s", ErrUnsupportedTokenizerJSON, step.Type)
+		}
+	}
+
+	return norm, prefixSpace, preTok, warnings, nil
+}
+
+// mergesLines turns model.merges into merges.txt lines. Byte-level tokens never contain a plain space, so
+// both forms map onto "a b" without ambiguity.
+func (tj *tokenizerJSON) mergesLines() ([]string, error) {
+	if len(tj.Model.Merges) == 0 {
+		return nil, nil
+	}
+
+	var lines []string
+	if err := json.Unmarshal(tj.Model.Merges, &lines); err == nil {
+		return lines, nil
+	}
+
+	var pairs [][]string
+	if err := json.Unmarshal(tj.Model.Merges, &pairs); err != nil {
+		return nil, fmt.Errorf("model.merges is neither a list of strings nor a list of pairs: %w", err)
+	}
+	lines = make([]string, len(pairs))
+	for i, p := range pairs {
+		if len(p) != 2 || strings.Contains(p[0], " ") || strings.Contains(p[1], " ") {
+			return nil, fmt.Errorf("model.merges[%d]: bad pair %q", i, p)
+		}
+		lines[i] = p[0] + " " + p[1]
+	}
+	return lines, nil
+}
@@ -0,0 +1,50 @@
+package core
+
+import "fmt"
+
+// vocabArena stores the bytes of every token back to back in a single buffer, token id lives at
+// data[offs[id]:offs[id+1]]. Compared to [][]byte this is one allocation instead of one per token, no
+// slice headers to keep resident, and it is exactly the layout a compiled model can hand us straight
+// from a file (or a mapping of one) without copying.
+type vocabArena struct {
+	data []byte
+	offs []uint32
+}
+
+// newVocabArena packs revVocab into an arena. revVocab is only needed during load.
+func newVocabArena(revVocab [][]byte) (vocabArena, error) {
+	total := 0
+	for _, b := range revVocab {
+		total += len(b)
+	}
+	if uint64(total) > uint64(^uint32(0)) {
+		return vocabArena{}, fmt.Errorf("vocab too large for arena: %d bytes", total)
+	}
+
+	data := make([]byte, 0, total)
+	offs := make([]uint32, len(revVocab)+1)
+	for id, b := range revVocab {
+		offs[id] = uint32(len(data))
+		data = append(data, b...)
+	}
+	offs[len(revVocab)] = uint32(len(data))
+
+	return vocabArena{data: data, offs: offs}, nil
+}
+
+// size is the number of tokens in the arena.
+func (a *vocabArena) size() int {
+	return len(a.offs) - 1
+}
+
+// bytes returns the bytes of token id. The slice is capped so appending to it can never scribble over
+// the next token.
+func (a *vocabArena) bytes(id int) []byte {
+	start, end := a.offs[id], a.offs[id+1]
+	return a.data[start:end:end]
+}
+
+// tokenLen returns the byte length of token id.
+func (a *vocabArena) tokenLen(id int) int {
+	return int(a.offs[id+1] - a.offs[id])
+}
@@ -0,0 +1,423 @@
+package core
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"unicode/utf16"
+	"unicode/utf8"
+)
+
+// ParseVocabJSON parses a vocab.json body: one JSON object mapping token strings to non-negative integer
+// IDs. It does the same job as json.Unmarshal into a map[string]int for a fraction of the time, which
+// matters once vocabs reach a few hundred thousand entries. It is strict: anything that isn't exactly that
+// shape is an error, and so are duplicate keys, which encoding/json would silently collapse.
+func ParseVocabJSON(data []byte) (map[string]int, error) {
+	p := newVocabParser(data)
+	p.skipSpace()
+	vocab, err := p.vocabObject()
+	if err != nil {
+		return nil, err
+	}
+	p.skipSpace()
+	if p.pos != len(p.data) {
+		return nil, p.errorf("trailing data after vocab object")
+	}
+	return vocab, nil
+}
+
+// ParseTokenizerJSONVocab pulls model.vocab out of a HuggingFace tokenizer.json, for TokenizerJSONBytes.
+// Everything else in the file is validated as JSON and skipped without being decoded, nested at most
+// maxSkipDepth deep.
+func ParseTokenizerJSONVocab(data []byte) (map[string]int, error) {
+	p := newVocabParser(data)
+	var vocab map[string]int
+
+	err := p.object(func(key []byte) error {
+		if string(key) != "model" {
+			return p.skipValue()
+		}
+		return p.object(func(key []byte) error {
+			if string(key) != "vocab" {
+				return p.skipValue()
+			}
+			if vocab != nil {
+				return p.errorf("duplicate model.vocab")
+			}
+			v, err := p.vocabObject()
+			vocab = v
+			return err
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	p.skipSpace()
+	if p.pos != len(p.data) {
+		return nil, p.errorf("trailing data after top-level object")
+	}
+	if vocab == nil {
+		return nil, errors.New("vocab json: no model.vocab object")
+	}
+	return vocab, nil
+}
+
+// maxSkipDepth bounds how deeply skipValue nests, encoding/json's own limit, so a file of brackets can't
+// exhaust the stack.
+const maxSkipDepth = 10000
+
+type vocabParser struct {
+	data []byte
+	pos  int
+	// buf holds the current object key
+	buf []byte
+	// depth is how many objects and arrays skipValue is inside
+	depth int
+}
+
+func newVocabParser(data []byte) *vocabParser {
+	return &vocabParser{data: data}
+}
+
+func (p *vocabParser) errorf(format string, args ...any) error {
+	return fmt.Errorf("vocab json: offset %d: %s", p.pos, fmt.Sprintf(format, args...))
+}
+
+func (p *vocabParser) skipSpace() {
+	for p.pos < len(p.data) {
+		switch p.data[p.pos] {
+		case ' ', '\t', '\n', '\r':
+			p.pos++
+		default:
+			return
+		}
+	}
+}
+
+// expect consumes c after optional whitespace.
+func (p *vocabParser) expect(c byte) error {
+	p.skipSpace()
+	if p.pos >= len(p.data) {
+		return p.errorf("unexpected end of input, want %q", c)
+	}
+	if p.data[p.pos] != c {
+		return p.errorf("unexpected %q, want %q", p.data[p.pos], c)
+	}
+	p.pos++
+	return nil
+}
+
+// object walks a JSON object and calls member with the parser positioned on each value. key is only valid
+// during the call.
+func (p *vocabParser) object(member func(key []byte) error) error {
+	if err := p.expect('{'); err != nil {
+		return err
+	}
+	p.skipSpace()
+	if p.pos < len(p.data) && p.data[p.pos] == '}' {
+		p.pos++
+		return nil
+	}
+
+	for {
+		p.skipSpace()
+		key, err := p.appendString(p.buf[:0])
+		if err != nil {
+			return err
+		}
+		p.buf = key
+		if err := p.expect(':'); err != nil {
+			return err
+		}
+		p.skipSpace()
+		if err := member(key); err != nil {
+			return err
+		}
+
+		p.skipSpace()
+		if p.pos >= len(p.data) {
+			return p.errorf("unterminated object")
+		}
+		switch p.data[p.pos] {
+		case ',':
+			p.pos++
+		case '}':
+			p.pos++
+			return nil
+		default:
+			return p.errorf("unexpected %q in object", p.data[p.pos])
+		}
+	}
+}
+
+func (p *vocabParser) vocabObject() (map[string]int, error) {
+	// Keys are collected in one arena and turned into a single string at the end, so the whole vocab costs
+	// one allocation for its keys instead of one per entry.
+	type entry struct{ off, end, id int }
+	var (
+		arena   = make([]byte, 0, len(p.data)/2)
+		entries = make([]entry, 0, len(p.data)/16)
+	)
+
+	err := p.object(func(key []byte) error {
+		id, err := p.id()
+		if err != nil {
+			return err
+		}
+		off := len(arena)
+		arena = append(arena, key...)
+		entries = append(entries, entry{off: off, end: len(arena), id: id})
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	keys := string(arena)
+	vocab := make(map[string]int, len(entries))
+	for _, e := range entries {
+		key := keys[e.off:e.end]
+		if _, dup := vocab[key]; dup {
+			return nil, fmt.Errorf("vocab json: duplicate token %q", key)
+		}
+		vocab[key] = e.id
+	}
+	return vocab, nil
+}
+
+// id parses a non-negative integer that fits in an int32, which is all a token ID can be.
+func (p *vocabParser) id() (int, error) {
+	start := p.pos
+	n := 0
+	for p.pos < len(p.data) && p.data[p.pos] >= '0' && p.data[p.pos] <= '9' {
+		n = n*10 + int(p.data[p.pos]-'0')
+		if n > math.MaxInt32 {
+			return 0, p.errorf("token id out of range")
+		}
+		p.pos++
+	}
+
+	switch {
+	case p.pos == start:
+		return 0, p.errorf("want a non-negative integer token id")
+	case p.pos-start > 1 && p.data[start] == '0':
+		return 0, p.errorf("leading zero in token id")
+	case p.pos < len(p.data) && (p.data[p.pos] == '.' || p.data[p.pos] == 'e' || p.data[p.pos] == 'E'):
+		return 0, p.errorf("token id is not an integer")
+	}
+	return n, nil
+}
+
+// appendString parses a JSON string and appends its unescaped contents to dst.
+func (p *vocabParser) appendString(dst []byte) ([]byte, error) {
+	if p.pos >= len(p.data) || p.data[p.pos] != '"' {
+		return dst, p.errorf("want a string")
+	}
+	p.pos++
+	begin := len(dst)
+
+	for p.pos < len(p.data) {
+		// copy the run up to the next quote, backslash or control character in one go
+		run := p.pos
+		for p.pos < len(p.data) {
+			c := p.data[p.pos]
+			if c == '"' || c == '\\' || c < 0x20 {
+				break
+			}
+			p.pos++
+		}
+		dst = append(dst, p.data[run:p.pos]...)
+		if p.pos >= len(p.data) {
+			break
+		}
+
+		c := p.data[p.pos]
+		p.pos++
+		switch {
+		case c == '"':
+			if !utf8.Valid(dst[begin:]) {
+				return dst, p.errorf("invalid UTF-8 in string")
+			}
+			return dst, nil
+		case c < 0x20:
+			return dst, p.errorf("control character in string")
+		}
+
+		if p.pos >= len(p.data) {
+			break
+		}
+		e := p.data[p.pos]
+		p.pos++
+		switch e {
+		case '"', '\\', '/':
+			dst = append(dst, e)
+		case 'b':
+			dst = append(dst, '\b')
+		case 'f':
+			dst = append(dst, '\f')
+		case 'n':
+			dst = append(dst, '\n')
+		case 'r':
+			dst = append(dst, '\r')
+		case 't':
+			dst = append(dst, '\t')
+		case 'u':
+			r, err := p.hex4()
+			if err != nil {
+				return dst, err
+			}
+			if utf16.IsSurrogate(r) {
+				r = p.lowSurrogate(r)
+			}
+			dst = utf8.AppendRune(dst, r)
+		default:
+			return dst, p.errorf("invalid escape \\%c", e)
+		}
+	}
+	return dst, p.errorf("unterminated string")
+}
+
+// lowSurrogate completes a \u surrogate pair. A lone surrogate becomes U+FFFD, as encoding/json does.
+func (p *vocabParser) lowSurrogate(high rune) rune {
+	if p.pos+6 > len(p.data) || p.data[p.pos] != '\\' || p.data[p.pos+1] != 'u' {
+		return utf8.RuneError
+	}
+	save := p.pos
+	p.pos += 2
+	low, err := p.hex4()
+	if err != nil {
+		p.pos = save
+		return utf8.RuneError
+	}
+	if r := utf16.DecodeRune(high, low); r != utf8.RuneError {
+		return r
+	}
+	p.pos = save
+	return utf8.RuneError
+}
+
+func (p *vocabParser) hex4() (rune, error) {
+	if p.pos+4 > len(p.data) {
+		return 0, p.errorf("short \\u escape")
+	}
+	var r rune
+	for _, c := range p.data[p.pos : p.pos+4] {
+		r <<= 4
+		switch {
+		case c >= '0' && c <= '9':
+			r |= rune(c - '0')
+		case c >= 'a' && c <= 'f':
+			r |= rune(c - 'a' + 10)
+		case c >= 'A' && c <= 'F':
+			r |= rune(c - 'A' + 10)
+		default:
+			return 0, p.errorf("invalid \\u escape")
+		}
+	}
+	p.pos += 4
+	return r, nil
+}
+
+// skipValue validates and skips any JSON value.
+func (p *vocabParser) skipValue() error {
+	p.skipSpace()
+	if p.pos >= len(p.data) {
+		return p.errorf("unexpected end of input")
+	}
+
+	if c := p.data[p.pos]; c == '{' || c == '[' {
+		if p.depth >= maxSkipDepth {
+			return p.errorf("nested deeper than %d levels", maxSkipDepth)
+		}
+		p.depth++
+		defer func() { p.depth-- }()
+	}
+
+	switch c := p.data[p.pos]; {
+	case c == '{':
+		return p.object(func([]byte) error { return p.skipValue() })
+	case c == '[':
+		p.pos++
+		p.skipSpace()
+		if p.pos < len(p.data) && p.data[p.pos] == ']' {
+			p.pos++
+			return nil
+		}
+		for {
+			if err := p.skipValue(); err != nil {
+				return err
+			}
+			p.skipSpace()
+			if p.pos >= len(p.data) {
+				return p.errorf("unterminated array")
+			}
+			switch p.data[p.pos] {
+			case ',':
+				p.pos++
+			case ']':
+				p.pos++
+				return nil
+			default:
+				return p.errorf("unexpected %q in array", p.data[p.pos])
+			}
+		}
+	case c == '"':
+		var err error
+		p.buf, err = p.appendString(p.buf[:0])
+		return err
+	case c == 't':
+		return p.literal("true")
+	case c == 'f':
+		return p.literal("false")
+	case c == 'n':
+		return p.literal("null")
+	case c == '-' || (c >= '0' && c <= '9'):
+		return p.number()
+	default:
+		return p.errorf("unexpected %q", c)
+	}
+}
+
+func (p *vocabParser) literal(lit string) error {
+	if len(p.data)-p.pos < len(lit) || string(p.data[p.pos:p.pos+len(lit)]) != lit {
+		return p.errorf("invalid literal")
+	}
+	p.pos += len(lit)
+	return nil
+}
+
+// number skips a JSON number following the RFC 8259 grammar.
+func (p *vocabParser) number() error {
+	digits := func() int {
+		start := p.pos
+		for p.pos < len(p.data) && p.data[p.pos] >= '0' && p.data[p.pos] <= '9' {
+			p.pos++
+		}
+		return p.pos - start
+	}
+
+	if p.data[p.pos] == '-' {
+		p.pos++
+	}
+	start := p.pos
+	if n := digits(); n == 0 || (n > 1 && p.data[start] == '0') {
+		return p.errorf("invalid number")
+	}
+	if p.pos < len(p.data) && p.data[p.pos] == '.' {
+		p.pos++
+		if digits() == 0 {
+			return p.errorf("invalid number")
+		}
+	}
+	if p.pos < len(p.data) && (p.data[p.pos] == 'e' || p.data[p.pos] == 'E') {
+		p.pos++
+		if p.pos < len(p.data) && (p.data[p.pos] == '+' || p.data[p.pos] == '-') {
+			p.pos++
+		}
+		if digits() == 0 {
+			return p.errorf("invalid number")
+		}
+	}
+	return nil
+}
@@ -0,0 +1,214 @@
+package core
+
+import (
+	"fmt"
+	"unicode/utf8"
+)
+
+// WarningKind identifies a class of non-fatal anomaly seen while encoding.
+type WarningKind int
+
+const (
+	// WarnInvalidUTF8 is raised for input bytes that don't form valid UTF-8. Byte-level BPE encodes them
+	// just fine, but in practice it usually means the caller is feeding us something that isn't text.
+	WarnInvalidUTF8 WarningKind = iota
+	// WarnForcedCommit is raised when a streaming encoder commits tokens a later chunk might still have
+	// merged into because its pending input outgrew the caller's bound. The output may differ from the
+	// offline encoding from there on. Its offset is where the cut fell in the normalized stream.
+	WarnForcedCommit
+	// WarnCappedRank is raised where a merge LoadOptions.MaxRank left out would have joined two adjacent
+	// output tokens, i.e. where the cap changed the IDs. Its offset is where the right-hand token starts in
+	// the normalized stream. Trained merges don't span pre-token splits, so a pair across one is rarely a
+	// left out merge, but it is reported like any other if it is.
+	WarnCappedRank
+
+	numWarningKinds
+)
+
+func (k WarningKind) String() string {
+	switch k {
+	case WarnInvalidUTF8:
+		return "invalid-utf8"
+	case WarnForcedCommit:
+		return "forced-commit"
+	case WarnCappedRank:
+		return "capped-rank"
+	default:
+		return fmt.Sprintf("warning(%d)", int(k))
+	}
+}
+
+// Warning aggregates every occurrence of one kind of anomaly.
+type Warning struct {
+	Kind  WarningKind
+	Count int
+	// FirstOffset is the byte offset of the first occurrence, relative to the start of the stream it
+	// happened in.
+	FirstOffset int64
+	// First describes the first occurrence.
+	First string
+}
+
+// Warnings collects anomalies per kind: a count plus the first occurrence. It never fails the encode,
+// it is purely there so operators can see what their traffic looks like. The zero value is off: Add does
+// nothing until Enable, and encoders skip the checks that feed it, so nobody pays for warnings nobody reads.
+type Warnings struct {
+	byKind [numWarningKinds]Warning
+	on     bool
+}
+
+// Enable turns recording on or off. What was recorded is kept either way.
+func (w *Warnings) Enable(on bool) {
+	w.on = on
+}
+
+// Enabled reports whether Add records anything.
+func (w *Warnings) Enabled() bool {
+	return w.on
+}
+
+// Add records one occurrence. The detail is only formatted for the first occurrence of a kind.
+func (w *Warnings) Add(kind WarningKind, offset int64, format string, args ...any) {
+	if !w.on {
+		return
+	}
+	e := &w.byKind[kind]
+	if e.Count == 0 {
+		e.Kind = kind
+		e.FirstOffset = offset
+		e.First = fmt.Sprintf(format, args...)
+	}
+	e.Count++
+}
+
+// List returns the kinds seen so far, in kind order.
+func (w *Warnings) List() []Warning {
+	var out []Warning
+	for _, e := range w.byKind {
+		if e.Count > 0 {
+			out = append(out, e)
+		}
+	}
+	return out
+}
+
+// Reset forgets everything recorded so far. Recording stays on or off.
+func (w *Warnings) Reset() {
+	w.byKind = [numWarningKinds]Warning{}
+}
+
+// CappedRankTracker finds the places in an encoder's output where a merge left out by LoadOptions.MaxRank
+// would have joined two adjacent tokens, across the calls a stream's output arrives in.
+type CappedRankTracker struct {
+	last    int
+	hasLast bool
+	off     int64 // stream offset of the next output token
+}
+
+// Feed checks ids as the continuation of the stream's output so far and calls capped with the stream
+// offset of the right-hand token and the pair for every adjacent pair t.CappedRank knows. Callers skip it
+// for a tokenizer without HasCappedRanks.
+func (c *CappedRankTracker) Feed(t *Tokenizer, ids []int, capped func(off int64, left, right, rank int)) {
+	for _, id := range ids {
+		if c.hasLast {
+			if rank, ok := t.CappedRank(c.last, id); ok {
+				capped(c.off, c.last, id, rank)
+			}
+		}
+		c.last, c.hasLast = id, true
+		c.off += int64(t.TokenLen(id))
+	}
+}
+
+// Reset starts a new stream.
+func (c *CappedRankTracker) Reset() {
+	*c = CappedRankTracker{}
+}
+
+// Restart continues a stream whose earlier output isn't known, with the next output token at stream
+// offset off, e.g. after restoring a snapshot.
+func (c *CappedRankTracker) Restart(off int64) {
+	*c = CappedRankTracker{off: off}
+}
+
+// UTF8Tracker validates a byte stream as UTF-8 across arbitrary chunk boundaries. Up to three bytes of an
+// incomplete sequence are carried from one Feed to the next.
+type UTF8Tracker struct {
+	carry [utf8.UTFMax]byte
+	n     int
+	off   int64 // stream offset of the next byte to be fed
+}
+
+// Feed validates chunk as the continuation of everything fed so far and calls bad with the stream offset
+// and value of every byte that can't start a valid sequence.
+func (u *UTF8Tracker) Feed(chunk []byte, bad func(off int64, b byte)) {
+	for len(chunk) > 0 {
+		if u.n == 0 {
+			i := 0
+			for i < len(chunk) {
+				if chunk[i] < utf8.RuneSelf {
+					i++
+					continue
+				}
+				if !utf8.FullRune(chunk[i:]) {
+					break
+				}
+				r, size := utf8.DecodeRune(chunk[i:])
+				if r == utf8.RuneError && size == 1 {
+					bad(u.off+int64(i), chunk[i])
+				}
+				i += size
+			}
+			u.n = copy(u.carry[:], chunk[i:])
+			u.off += int64(len(chunk))
+			return
+		}
+
+		// an incomplete sequence is pending, top it up one byte at a time
+		u.carry[u.n] = chunk[0]
+		u.n++
+		chunk = chunk[1:]
+		u.off++
+
+		if !utf8.FullRune(u.carry[:u.n]) {
+			continue
+		}
+
+		r, size := utf8.DecodeRune(u.carry[:u.n])
+		if r != utf8.RuneError || size != 1 {
+			u.n = 0
+			continue
+		}
+
+		// the first pending byte is bad, the ones after it still need checking
+		start := u.off - int64(u.n)
+		bad(start, u.carry[0])
+		var rest [utf8.UTFMax]byte
+		k := copy(rest[:], u.carry[1:u.n])
+		u.n = 0
+		u.off = start + 1
+		u.Feed(rest[:k], bad)
+	}
+}
+
+// State returns the incomplete sequence carried to the next Feed and the stream offset, for saving the
+// tracker, see SetState.
+func (u *UTF8Tracker) State() (carry []byte, off int64) {
+	return u.carry[:u.n], u.off
+}
+
+// SetState puts the tracker back in a state returned by State. carry longer than utf8.UTFMax-1 bytes is
+// cut.
+func (u *UTF8Tracker) SetState(carry []byte, off int64) {
+	u.n = copy(u.carry[:utf8.UTFMax-1], carry)
+	u.off = off
+}
+
+// Finish reports a dangling incomplete sequence at the end of the stream and resets the tracker.
+// Every byte of it is reported, the trailing ones are continuation bytes that can't start a sequence either.
+func (u *UTF8Tracker) Finish(bad func(off int64, b byte)) {
+	for i := 0; i < u.n; i++ {
+		bad(u.off-int64(u.n-i), u.carry[i])
+	}
+	*u = UTF8Tracker{}
+}
@@ -0,0 +1,183 @@
+package differential
+
+import (
+	"bytes"
+	"encoding/base64"
+	"math/rand"
+	"os"
+	"reflect"
+	"testing"
+
+	"github.com/bpetok/internal/tokenizer/core"
+	"github.com/bpetok/internal/tokenizer/streaming_encoder_adaptive"
+	"github.com/bpetok/internal/tokenizer/streaming_encoder_incremental"
+	"github.com/bpetok/internal/tokenizer/streaming_encoder_naive"
+)
+
+// streamer is the Push/Flush surface the streaming engines share.
+type streamer interface {
+	Push(chunk []byte) []int
+	Flush() []int
+}
+
+// differentialEngines are the streaming encoders the differential fuzz referees. Each one gets the same
+// chunking and has to reproduce EncodeOffline exactly.
+var differentialEngines = []struct {
+	name string
+	new  func(tok *core.Tokenizer) streamer
+}{
+	{"naive", func(tok *core.Tokenizer) streamer {
+		return streaming_encoder_naive.NewNaiveStreamingEncoderState(tok)
+	}},
+	{"naive_opts", func(tok *core.Tokenizer) streamer {
+		return streaming_encoder_naive.NewNaiveStreamingEncoderStateWithOpts(tok, core.BaseEncoderState{
+			OptPreAllocScratch: true,
+			OptFlattenLookup:   true,
+			OptHotLoopTighten:  true,
+		})
+	}},
+	{"incremental", func(tok *core.Tokenizer) streamer {
+		return streaming_encoder_incremental.NewStreamingEncoderV2(tok)
+	}},
+	{"incremental_rank_aware", func(tok *core.Tokenizer) streamer {
+		return streaming_encoder_incremental.NewStreamingEncoderV2(tok,
+			streaming_encoder_incremental.WithCommitPolicy(streaming_encoder_incremental.CommitRankAware))
+	}},
+	{"adaptive", func(tok *core.Tokenizer) streamer {
+		return streaming_encoder_adaptive.NewAdaptiveEncoder(tok, streaming_encoder_adaptive.WithThresholds(2, 8))
+	}},
+}
+
+// cutSize turns a cut byte into a chunk size: below 0x80 a small chunk of c%32 bytes, zero meaning an empty
+// Push, above it a large one of 512 bytes up to 64 KiB, big enough for the encoders to hold several KiB
+// back and take their long input paths.
+func cutSize(c byte) int {
+	if c < 0x80 {
+		return int(c % 32)
+	}
+	return (int(c&0x7f) + 1) << 9
+}
+
+// pushCuts feeds input to enc in chunks whose sizes are read from cuts, one byte per chunk (see cutSize),
+// with whatever is left pushed whole. Outputs are copied as they come since the naive encoder reuses its
+// buffer.
+func pushCuts(enc streamer, input, cuts []byte) []int {
+	var out []int
+	pos := 0
+	for _, c := range cuts {
+		if pos >= len(input) {
+			break
+		}
+		end := min(pos+cutSize(c), len(input))
+		out = append(out, enc.Push(input[pos:end])...)
+		pos = end
+	}
+	if pos < len(input) {
+		out = append(out, enc.Push(input[pos:])...)
+	}
+	return append(out, enc.Flush()...)
+}
+
+// FuzzStreamingDifferential drives every streaming engine with the same random chunking and checks they
+// agree with each other and with the offline encoder, the reference both the default and the optimized
+// EncoderState settings have to match, with and without GPT-2's pre-tokenizer. go test -fuzz saves failing
+// inputs under testdata/fuzz, where they stay as regression cases.
+func FuzzStreamingDifferential(f *testing.F) {
+	var toks []*core.Tokenizer
+	for _, p := range []core.PreTokenization{core.PreTokenizeNone, core.PreTokenizeGPT2} {
+		tok, err := core.Load(core.Files("../testdata/gpt2/vocab.json", "../testdata/gpt2/merges.txt"),
+			core.WithPreTokenization(p))
+		if err != nil {
+			f.Fatalf("load tokenizer: %v", err)
+		}
+		toks = append(toks, tok)
+	}
+
+	f.Add([]byte("hello world"), []byte{1, 1, 1})
+	f.Add([]byte("Hello, world! This is bpe-tok :)"), []byte{3, 0, 7, 31, 2})
+	f.Add([]byte("Hello 你好 नमस्ते 👋🏽 café"), []byte{1, 2, 3, 4, 5, 6, 7})
+	f.Add([]byte("                                 indented\n\n\n\ttabs"), []byte{5, 5, 5, 5, 5, 5, 5})
+	f.Add([]byte("\xff\xfe invalid \xe4\xbd utf8 \xf0\x9f"), []byte{1, 0, 1, 0, 1})
+	f.Add([]byte("aaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaa"), []byte{13, 17})
+	f.Add([]byte("we're 'quoted', don't   they'll\n\n x2024"), []byte{1, 1, 2, 1, 1, 3, 1, 1})
+
+	// long inputs, so several KiB are held back at once: prose with splits everywhere, and runs without a
+	// single split point or junction no merge can join, which the encoders have to cut on their own
+	corpus, err := os.ReadFile("../testdata/gpt2/bench_corpus.txt")
+	if err != nil {
+		f.Fatalf("read corpus: %v", err)
+	}
+	prose := corpus[:min(len(corpus), 24<<10)]
+	f.Add(prose, []byte{0x87, 0x9f, 3, 0xff, 0x80, 17})
+	f.Add(prose, []byte{0xbf, 1, 0x8f, 0x83, 0xff})
+	f.Add(bytes.Repeat([]byte("a"), 20<<10), []byte{0x8f, 0x81, 0x9f, 5, 0xff})
+	f.Add(bytes.Repeat([]byte("ab"), 12<<10), []byte{1, 0x87, 0x90})
+	f.Add(bytes.Repeat([]byte("0123456789"), 1200), []byte{0x8b, 0x80, 0xa0})
+	f.Add([]byte(base64.StdEncoding.EncodeToString(prose)), []byte{0x8f, 0x8f, 2, 0x8f, 0xff})
+	f.Add(bytes.Repeat([]byte("東京"), 2000), []byte{0x8f, 0x81, 1, 0xff})
+	f.Add(bytes.Repeat([]byte{0xff, 0xfe, 0xe4}, 4000), []byte{0x88, 7, 0xff})
+
+	f.Fuzz(func(t *testing.T, input, cuts []byte) {
+		for _, tok := range toks {
+			want := tok.EncodeOffline(input, nil)
+			opt := &core.BaseEncoderState{
+				OptPreAllocScratch: true,
+				OptFlattenLookup:   true,
+				OptHotLoopTighten:  true,
+			}
+			if got := tok.EncodeOffline(input, opt); !reflect.DeepEqual(got, want) {
+				t.Fatalf("%v: optimized EncodeOffline %v, want %v", tok.PreTokenization(), got, want)
+			}
+
+			for _, e := range differentialEngines {
+				if got := pushCuts(e.new(tok), input, cuts); !reflect.DeepEqual(got, want) {
+					t.Fatalf("%s, %v: got %v, want %v (input %q, cuts %v)", e.name, tok.PreTokenization(), got, want, input, cuts)
+				}
+			}
+		}
+	})
+}
+
+// TestStreamingDifferential_PreTokenized runs the engines over random text built from the characters the
+// pre-tokenizers treat specially, where a split can move until the characters after it arrive, with and
+// without digit groups.
+func TestStreamingDifferential_PreTokenized(t *testing.T) {
+	r := rand.New(rand.NewSource(11))
+	alphabet := []string{"a", "re", "ll", "s", "t", "RE", "A", "7", " ", "  ", "\n", "\r\n", "\t", "'", "!", "/", "東",
+		"\u0301", "\xe6", "\xff", "\u00a0", "é", "123", "½"}
+
+	configs := []struct {
+		p     core.PreTokenization
+		group int
+	}{
+		{core.PreTokenizeGPT2, 0}, {core.PreTokenizeCL100K, 0}, {core.PreTokenizeO200K, 0},
+		{core.PreTokenizeGPT2, 2}, {core.PreTokenizeCL100K, 1},
+	}
+	for _, c := range configs {
+		p := c.p
+		tok, err := core.Load(core.Files("../testdata/gpt2/vocab.json", "../testdata/gpt2/merges.txt"),
+			core.WithPreTokenization(p), core.WithDigitGroup(c.group))
+		if err != nil {
+			t.Fatalf("load tokenizer: %v", err)
+		}
+
+		for range 300 {
+			var input []byte
+			for range r.Intn(40) {
+				input = append(input, alphabet[r.Intn(len(alphabet))]...)
+			}
+			cuts := make([]byte, r.Intn(20))
+			for i := range cuts {
+				cuts[i] = byte(r.Intn(6))
+			}
+
+			want := tok.EncodeOffline(input, nil)
+			for _, e := range differentialEngines {
+				if got := pushCuts(e.new(tok), input, cuts); !reflect.DeepEqual(got, want) {
+					t.Fatalf("%s, %v group %d: got %v, want %v (input %q, cuts %v)", e.name, p, c.group, got, want,
+						input, cuts)
+				}
+			}
+		}
+	}
+}
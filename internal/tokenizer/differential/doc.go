@@ -0,0 +1,5 @@
+// Package differential checks every streaming encoder against the offline one: the same input, chunked the
+// same way, must encode to the same IDs. go test -fuzz FuzzStreamingDifferential
+// ./internal/tokenizer/differential searches for inputs that don't, saving them under testdata/fuzz where
+// they stay as regression cases. It holds no code of its own, the checks live in its tests.
+package differential
@@ -1,7 +1,10 @@
 package offline_encoder
 
 import (
+	"fmt"
 	"os"
+	"runtime"
+	"slices"
 	"testing"
 
 	"github.com/bpetok/internal/tokenizer/core"
@@ -39,3 +42,73 @@ func loadTestTokenizerB(b *testing.B) *core.Tokenizer {
 	}
 	return tok
 }
+
+func BenchmarkCountTokens(b *testing.B) {
+	tok := loadTestTokenizerB(b)
+	input := mustLoadBenchCorpus(b, "../testdata/gpt2/bench_corpus.txt")
+
+	b.SetBytes(int64(len(input)))
+	b.ReportAllocs()
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		_ = tok.CountTokens(input)
+	}
+}
+
+func BenchmarkParseVocabJSON(b *testing.B) {
+	data := mustLoadBenchCorpus(b, "../testdata/gpt2/vocab.json")
+
+	b.SetBytes(int64(len(data)))
+	b.ReportAllocs()
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		if _, err := core.ParseVocabJSON(data); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkLoadJSON(b *testing.B) {
+	b.ReportAllocs()
+
+	for i := 0; i < b.N; i++ {
+		if _, err := core.LoadTokenizerFromFiles(testVocabPath, testMergesPath); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+// BenchmarkLoadJSONProcs compares the load with its build phases forced sequential (procs=1) against
+// running them on every CPU.
+func BenchmarkLoadJSONProcs(b *testing.B) {
+	for _, procs := range slices.Compact([]int{1, runtime.NumCPU()}) {
+		b.Run(fmt.Sprintf("procs=%d", procs), func(b *testing.B) {
+			defer runtime.GOMAXPROCS(runtime.GOMAXPROCS(procs))
+			b.ReportAllocs()
+
+			for i := 0; i < b.N; i++ {
+				if _, err := core.LoadTokenizerFromFiles(testVocabPath, testMergesPath); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+func BenchmarkLoadCompiled(b *testing.B) {
+	data, err := loadTestTokenizerB(b).MarshalBinary()
+	if err != nil {
+		b.Fatal(err)
+	}
+
+	b.ReportAllocs()
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		if _, err := core.Load(core.Compiled(data)); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
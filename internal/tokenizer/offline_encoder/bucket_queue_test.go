@@ -0,0 +1,28 @@
+package offline_encoder
+
+import (
+	"testing"
+
+	"github.com/bpetok/internal/utils"
+)
+
+func TestBucketQueue_PushBelowCurrentAfterPop(t *testing.T) {
+	bq := utils.NewBucketQueue(10)
+	bq.Push(utils.MergeCand{Rank: 5, Pos: 0})
+	bq.Push(utils.MergeCand{Rank: 7, Pos: 1})
+
+	if c, ok := bq.Pop(); !ok || c.Rank != 5 {
+		t.Fatalf("first pop: got rank %d, %v, want 5", c.Rank, ok)
+	}
+
+	// the merge just popped can create a pair ranked ahead of it
+	bq.Push(utils.MergeCand{Rank: 2, Pos: 3})
+	for _, want := range []int{2, 7} {
+		if c, ok := bq.Pop(); !ok || c.Rank != want {
+			t.Fatalf("got rank %d, %v, want %d", c.Rank, ok, want)
+		}
+	}
+	if _, ok := bq.Pop(); ok || bq.Len() != 0 {
+		t.Fatalf("queue should be empty")
+	}
+}
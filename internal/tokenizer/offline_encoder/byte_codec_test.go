@@ -0,0 +1,114 @@
+package offline_encoder
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+
+	"github.com/bpetok/internal/tokenizer/core"
+)
+
+// rawVocab is a small vocab in RawByteCodec's spelling: the 256 byte tokens, then a few merges.
+func rawVocab(t *testing.T) (vocab, merges []byte) {
+	t.Helper()
+	codec := core.RawByteCodec()
+	m := make(map[string]int)
+	for b := range 256 {
+		m[codec.ByteText(byte(b))] = b
+	}
+	for i, tok := range []string{"he", "ll", "hell", "hello", " w", "é", "\n\n"} {
+		m[tok] = 256 + i
+	}
+	vocab, err := json.Marshal(m)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return vocab, []byte("#version: 0.2\nh e\nl l\nhe ll\nhell o\n<0x20> w\n<0xC3> <0xA9>\n<0x0A> <0x0A>\n")
+}
+
+func TestByteCodec_Raw(t *testing.T) {
+	vocab, merges := rawVocab(t)
+	tok, err := core.Load(core.Bytes(vocab, merges), core.WithByteCodec(core.RawByteCodec()))
+	if err != nil {
+		t.Fatalf("load: %v", err)
+	}
+
+	in := []byte("hello world\n\né\xff")
+	want := []int{259, 260, 'o', 'r', 'l', 'd', 262, 261, 0xff}
+	got := tok.EncodeOffline(in, nil)
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("got %v, want %v", got, want)
+	}
+	if out := tok.Decode(got); string(out) != string(in) {
+		t.Fatalf("decode %q", out)
+	}
+
+	// the default GPT-2 codec reads "<0xC3>" as six characters and finds no "Ġ" for the space byte
+	if _, err := core.Load(core.Bytes(vocab, merges)); err == nil {
+		t.Fatalf("expected the GPT-2 codec to reject a raw vocab")
+	}
+}
+
+func TestByteCodec_GPT2Default(t *testing.T) {
+	explicit, err := core.Load(core.Files(testVocabPath, testMergesPath), core.WithByteCodec(core.GPT2ByteCodec()))
+	if err != nil {
+		t.Fatalf("load: %v", err)
+	}
+	in := []byte("Hello world, the quick brown fox\n\tcafé")
+	if got, want := explicit.EncodeOffline(in, nil), loadTestTokenizer(t).EncodeOffline(in, nil); !reflect.DeepEqual(got, want) {
+		t.Fatalf("got %v, want %v", got, want)
+	}
+
+	codec := core.GPT2ByteCodec()
+	if codec.ByteText(' ') != "Ġ" || codec.ByteText('a') != "a" {
+		t.Fatalf("unexpected GPT-2 spelling %q %q", codec.ByteText(' '), codec.ByteText('a'))
+	}
+	if b, err := codec.DecodeToken("ĠcafÃ©"); err != nil || string(b) != " café" {
+		t.Fatalf("decode %q, %v", b, err)
+	}
+}
+
+func TestByteLevel_MatchesVocabJSON(t *testing.T) {
+	data, _ := readGPT2Assets(t)
+	keys, err := core.ParseVocabJSON(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tok := loadTestTokenizer(t)
+	for key, id := range keys {
+		if got := core.ByteLevelEncode(tok.Vocab().Bytes(id)); got != key {
+			t.Fatalf("id %d: encoded %q, vocab.json has %q", id, got, key)
+		}
+		if b, err := core.ByteLevelDecode(key); err != nil || string(b) != string(tok.Vocab().Bytes(id)) {
+			t.Fatalf("id %d: decoded %q, %v", id, b, err)
+		}
+	}
+
+	all := make([]byte, 256)
+	for i := range all {
+		all[i] = byte(i)
+	}
+	if b, err := core.ByteLevelDecode(core.ByteLevelEncode(all)); err != nil || string(b) != string(all) {
+		t.Fatalf("all bytes round trip: %q, %v", b, err)
+	}
+}
+
+func TestByteCodec_EncodeToken(t *testing.T) {
+	raw := core.RawByteCodec()
+	for _, tok := range []string{"hello", " w", "é", "\n", "\xff", "a"} {
+		s, err := raw.EncodeToken([]byte(tok))
+		if err != nil {
+			t.Fatalf("%q: %v", tok, err)
+		}
+		if b, err := raw.DecodeToken(s); err != nil || string(b) != tok {
+			t.Fatalf("%q spelled %q decodes to %q, %v", tok, s, b, err)
+		}
+	}
+	if _, err := raw.EncodeToken([]byte("\xe4\xbd")); err == nil {
+		t.Fatalf("expected half a character to have no raw spelling")
+	}
+
+	if s, err := core.GPT2ByteCodec().EncodeToken([]byte(" hello")); err != nil || s != "Ġhello" {
+		t.Fatalf("gpt2 spelled %q, %v", s, err)
+	}
+}
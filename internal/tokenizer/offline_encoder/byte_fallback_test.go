@@ -0,0 +1,112 @@
+package offline_encoder
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/bpetok/internal/tokenizer/core"
+)
+
+// byteFallbackGPT2 renames the single-byte tokens of the GPT-2 vocab chosen by rename from their unicode
+// stand-ins to <0xNN> pieces, in the vocab and in the merges that use them.
+func byteFallbackGPT2(t *testing.T, rename func(b byte) bool) (vocab, merges []byte) {
+	t.Helper()
+	vocab, merges = readGPT2Assets(t)
+
+	var m map[string]int
+	if err := json.Unmarshal(vocab, &m); err != nil {
+		t.Fatal(err)
+	}
+	tok := loadTestTokenizer(t)
+	pieces := make(map[string]string)
+	for b := range 256 {
+		if !rename(byte(b)) {
+			continue
+		}
+		id := tok.EncodeOffline([]byte{byte(b)}, nil)[0]
+		for text, have := range m {
+			if have == id {
+				pieces[text] = fmt.Sprintf("<0x%02X>", b)
+				delete(m, text)
+				m[pieces[text]] = id
+				break
+			}
+		}
+	}
+	vocab, err := json.Marshal(m)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	lines := strings.Split(string(merges), "\n")
+	for i, line := range lines {
+		if strings.HasPrefix(line, "#") {
+			continue
+		}
+		fields := strings.Fields(line)
+		for j, f := range fields {
+			if p, ok := pieces[f]; ok {
+				fields[j] = p
+			}
+		}
+		lines[i] = strings.Join(fields, " ")
+	}
+	return vocab, []byte(strings.Join(lines, "\n"))
+}
+
+func TestByteFallback_Load(t *testing.T) {
+	want := loadTestTokenizer(t)
+	in := []byte("Hello world,\n\tbyte pieces <0x0A> don't change merges. café \xff\xfe")
+
+	for name, rename := range map[string]func(byte) bool{
+		"all":        func(byte) bool { return true },
+		"whitespace": func(b byte) bool { return b == ' ' || b == '\n' || b == '\t' },
+	} {
+		t.Run(name, func(t *testing.T) {
+			vocab, merges := byteFallbackGPT2(t, rename)
+			tok, err := core.Load(core.Bytes(vocab, merges))
+			if err != nil {
+				t.Fatalf("load: %v", err)
+			}
+			for b := range 256 {
+				if out := tok.Decode(tok.EncodeOffline([]byte{byte(b)}, nil)); !bytes.Equal(out, []byte{byte(b)}) {
+					t.Fatalf("byte %#x decodes as %q", b, out)
+				}
+			}
+
+			ids := tok.EncodeOffline(in, nil)
+			if wantIDs := want.EncodeOffline(in, nil); !reflect.DeepEqual(ids, wantIDs) {
+				t.Fatalf("got %v, want %v", ids, wantIDs)
+			}
+			if out := tok.Decode(ids); !bytes.Equal(out, in) {
+				t.Fatalf("decode %q", out)
+			}
+		})
+	}
+}
+
+func TestByteFallback_LiteralWithStandIn(t *testing.T) {
+	vocab, merges := readGPT2Assets(t)
+	var m map[string]int
+	if err := json.Unmarshal(vocab, &m); err != nil {
+		t.Fatal(err)
+	}
+	// the vocab has Ċ for '\n', so this is the five characters it spells
+	m["<0x0A>"] = len(m)
+	vocab, err := json.Marshal(m)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tok, err := core.Load(core.Bytes(vocab, merges))
+	if err != nil {
+		t.Fatalf("load: %v", err)
+	}
+	if out := tok.Decode([]int{len(m) - 1}); string(out) != "<0x0A>" {
+		t.Fatalf("decode %q", out)
+	}
+}
@@ -0,0 +1,201 @@
+package offline_encoder
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/bpetok/internal/tokenizer/core"
+)
+
+func TestCompiled_RoundTrip(t *testing.T) {
+	special := map[string]int{"<|endoftext|>": 50256, "<|pad|>": 50257}
+	orig, err := core.Load(core.Files(testVocabPath, testMergesPath),
+		core.WithSpecialTokens(special), core.WithNormalization(core.NormalizeNFC), core.WithAddPrefixSpace(true),
+		core.WithPreTokenization(core.PreTokenizeGPT2), core.WithDigitGroup(3))
+	if err != nil {
+		t.Fatalf("load: %v", err)
+	}
+
+	data, err := orig.MarshalBinary()
+	if err != nil {
+		t.Fatalf("marshal: %v", err)
+	}
+	again, _ := orig.MarshalBinary()
+	if !bytes.Equal(data, again) {
+		t.Fatalf("MarshalBinary isn't deterministic")
+	}
+
+	path := filepath.Join(t.TempDir(), "gpt2.bpetok")
+	if err := os.WriteFile(path, data, 0o644); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	tok, err := core.Load(core.CompiledFile(path))
+	if err != nil {
+		t.Fatalf("load compiled: %v", err)
+	}
+
+	if !reflect.DeepEqual(tok.Stats(), orig.Stats()) {
+		t.Fatalf("stats differ:\n%+v\n%+v", tok.Stats(), orig.Stats())
+	}
+	if !reflect.DeepEqual(tok.SpecialTokens(), special) || tok.Normalization() != core.NormalizeNFC ||
+		!tok.AddPrefixSpace() || tok.PreTokenization() != core.PreTokenizeGPT2 || tok.DigitGroup() != 3 {
+		t.Fatalf("load options lost: %v %v %v %v %d", tok.SpecialTokens(), tok.Normalization(), tok.AddPrefixSpace(),
+			tok.PreTokenization(), tok.DigitGroup())
+	}
+	if tok.Fingerprint() != orig.Fingerprint() {
+		t.Fatalf("compiled fingerprint differs")
+	}
+
+	in, err := os.ReadFile("../testdata/gpt2/bench_corpus.txt")
+	if err != nil {
+		t.Fatalf("read corpus: %v", err)
+	}
+	in = in[:256<<10]
+	if got, want := tok.EncodeOffline(in, nil), orig.EncodeOffline(in, nil); !reflect.DeepEqual(got, want) {
+		t.Fatalf("compiled tokenizer encodes differently")
+	}
+}
+
+func TestCompiled_Tiktoken(t *testing.T) {
+	rankFile, ranks := gpt2AsTiktoken(t)
+	orig, err := core.LoadTokenizerFromTiktokenBytes(rankFile)
+	if err != nil {
+		t.Fatalf("load: %v", err)
+	}
+	data, _ := orig.MarshalBinary()
+
+	tok, err := core.Load(core.Compiled(data))
+	if err != nil {
+		t.Fatalf("load compiled: %v", err)
+	}
+	in := []byte("rank files compile too, ¿sí?")
+	if got, want := tok.EncodeOffline(in, nil), tiktokenReference(ranks, in); !reflect.DeepEqual(got, want) {
+		t.Fatalf("got %v, want %v", got, want)
+	}
+}
+
+func TestCompiled_Deterministic(t *testing.T) {
+	// tiktoken ranks tie for every pair merging into the same token, the output must not follow map order
+	rankFile, _ := gpt2AsTiktoken(t)
+	tok, err := core.LoadTokenizerFromTiktokenBytes(rankFile)
+	if err != nil {
+		t.Fatalf("load: %v", err)
+	}
+	first, err := tok.MarshalBinary()
+	if err != nil {
+		t.Fatalf("marshal: %v", err)
+	}
+	for range 3 {
+		if again, _ := tok.MarshalBinary(); !bytes.Equal(again, first) {
+			t.Fatalf("compiling the same tokenizer twice gave different bytes")
+		}
+	}
+	reloaded, err := core.LoadTokenizerFromTiktokenBytes(rankFile)
+	if err != nil {
+		t.Fatalf("reload: %v", err)
+	}
+	if again, _ := reloaded.MarshalBinary(); !bytes.Equal(again, first) {
+		t.Fatalf("compiling a reload gave different bytes")
+	}
+}
+
+func TestCompiled_RejectsBadData(t *testing.T) {
+	data, err := loadTestTokenizer(t).MarshalBinary()
+	if err != nil {
+		t.Fatalf("marshal: %v", err)
+	}
+
+	resum := func(b []byte) []byte {
+		binary.LittleEndian.PutUint32(b[len(b)-4:], crc32.Checksum(b[:len(b)-4], crc32.MakeTable(crc32.Castagnoli)))
+		return b
+	}
+
+	flipped := bytes.Clone(data)
+	flipped[len(flipped)/2] ^= 0x40
+
+	future := bytes.Clone(data)
+	binary.LittleEndian.PutUint32(future[8:], 99)
+
+	// valid checksum, but the first pair now claims to merge into token 0; the pair table is the last
+	// section since there are no special tokens
+	mispaired := bytes.Clone(data)
+	binary.LittleEndian.PutUint32(mispaired[len(data)-4-16*50000+12:], 0)
+
+	// valid checksum, but maxMergeDepth (after the magic and nine header fields) is one deeper than the
+	// merges give
+	deeper := bytes.Clone(data)
+	binary.LittleEndian.PutUint32(deeper[44:], binary.LittleEndian.Uint32(deeper[44:])+1)
+
+	for name, bad := range map[string][]byte{
+		"empty":      nil,
+		"magic":      append([]byte("NOTBPETK"), data[8:]...),
+		"checksum":   flipped,
+		"truncated":  data[:len(data)/2],
+		"version":    resum(future),
+		"mispaired":  resum(mispaired),
+		"trailing":   resum(append(bytes.Clone(data), 0, 0, 0, 0)),
+		"depth":      resum(deeper),
+		"json input": []byte(`{"a": 0}`),
+	} {
+		_, err := core.Load(core.Compiled(bad))
+		if !errors.Is(err, core.ErrCompiledFormat) {
+			t.Fatalf("%s: expected ErrCompiledFormat, got %v", name, err)
+		}
+	}
+}
+
+func TestCompiled_AlgorithmVersion(t *testing.T) {
+	data, err := loadTestTokenizer(t).MarshalBinary()
+	if err != nil {
+		t.Fatalf("marshal: %v", err)
+	}
+	resum := func(b []byte) []byte {
+		binary.LittleEndian.PutUint32(b[len(b)-4:], crc32.Checksum(b[:len(b)-4], crc32.MakeTable(crc32.Castagnoli)))
+		return b
+	}
+
+	tok, err := core.Load(core.Compiled(data), core.WithAlgorithmVersion(core.AlgorithmVersion))
+	if err != nil {
+		t.Fatalf("pinned to this build's version: %v", err)
+	}
+	if tok.AlgorithmVersion() != core.AlgorithmVersion {
+		t.Fatalf("AlgorithmVersion() = %d, want %d", tok.AlgorithmVersion(), core.AlgorithmVersion)
+	}
+
+	next := core.WithAlgorithmVersion(core.AlgorithmVersion + 1)
+	if _, err := core.Load(core.Files(testVocabPath, testMergesPath), next); !errors.Is(err, core.ErrAlgorithmVersion) {
+		t.Fatalf("files pinned to another version: expected ErrAlgorithmVersion, got %v", err)
+	}
+
+	// a file compiled by a build with a newer algorithm loads, but not under a pin to this build's version
+	newer := bytes.Clone(data)
+	binary.LittleEndian.PutUint32(newer[12:], core.AlgorithmVersion+1)
+	resum(newer)
+	tok, err = core.Load(core.Compiled(newer))
+	if err != nil {
+		t.Fatalf("unpinned load: %v", err)
+	}
+	if tok.AlgorithmVersion() != core.AlgorithmVersion+1 {
+		t.Fatalf("AlgorithmVersion() = %d, want the recorded %d", tok.AlgorithmVersion(), core.AlgorithmVersion+1)
+	}
+	if _, err := core.Load(core.Compiled(newer), core.WithAlgorithmVersion(core.AlgorithmVersion)); !errors.Is(err, core.ErrAlgorithmVersion) {
+		t.Fatalf("pinned load of a newer file: expected ErrAlgorithmVersion, got %v", err)
+	}
+
+	// format version 1 predates the field and counts as algorithm version 1
+	v1 := append(bytes.Clone(data[:12]), data[24:]...)
+	binary.LittleEndian.PutUint32(v1[8:], 1)
+	tok, err = core.Load(core.Compiled(resum(v1)), core.WithAlgorithmVersion(1))
+	if err != nil {
+		t.Fatalf("format version 1: %v", err)
+	}
+	if tok.AlgorithmVersion() != 1 {
+		t.Fatalf("format version 1: AlgorithmVersion() = %d, want 1", tok.AlgorithmVersion())
+	}
+}
@@ -0,0 +1,59 @@
+package offline_encoder
+
+import (
+	"math"
+	"strings"
+	"testing"
+
+	"github.com/bpetok/internal/tokenizer/core"
+)
+
+func TestCountTokens_MatchesEncode(t *testing.T) {
+	tok := loadTestTokenizer(t)
+
+	for _, s := range []string{"", "a", "Hello, world!", "naïve café 😀 " + strings.Repeat("token ", 200)} {
+		if got, want := tok.CountTokens([]byte(s)), len(tok.EncodeOffline([]byte(s), nil)); got != want {
+			t.Fatalf("CountTokens(%q) = %d, want %d", s, got, want)
+		}
+	}
+}
+
+func TestCountTokens_AllocsDontScaleWithInput(t *testing.T) {
+	tok := loadTestTokenizer(t)
+
+	short := []byte(strings.Repeat("the quick brown fox ", 10))
+	long := []byte(strings.Repeat("the quick brown fox ", 500))
+
+	// warm the scratch pool and the queue's buckets up to the long input's size
+	tok.CountTokens(long)
+
+	a := testing.AllocsPerRun(20, func() { tok.CountTokens(short) })
+	b := testing.AllocsPerRun(20, func() { tok.CountTokens(long) })
+	if b > a+1 {
+		t.Fatalf("allocations grow with input: %v for short, %v for long", a, b)
+	}
+}
+
+func TestCompressionStats(t *testing.T) {
+	tok := loadTestTokenizer(t)
+
+	if st := tok.CompressionStats(nil); st != (core.CompressionStats{}) {
+		t.Fatalf("empty input should give zero stats, got %+v", st)
+	}
+
+	// the same token over and over carries no information
+	in := []byte(strings.Repeat(" the", 100))
+	st := tok.CompressionStats(in)
+	if st.Bytes != len(in) || st.Tokens != 100 || st.DistinctTokens != 1 || st.Entropy != 0 || st.BytesPerToken != 4 {
+		t.Fatalf("unexpected stats for a repeated token: %+v", st)
+	}
+
+	text := []byte("Token entropy goes up as the text gets more varied, 1234 ünïcödé!")
+	st = tok.CompressionStats(text)
+	if st.Tokens != tok.CountTokens(text) {
+		t.Fatalf("token count %d disagrees with CountTokens", st.Tokens)
+	}
+	if max := math.Log2(float64(st.DistinctTokens)); st.Entropy <= 0 || st.Entropy > max+1e-9 {
+		t.Fatalf("entropy %v outside (0, %v]", st.Entropy, max)
+	}
+}
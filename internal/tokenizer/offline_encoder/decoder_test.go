@@ -0,0 +1,184 @@
+package offline_encoder
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+	"unicode/utf8"
+
+	"github.com/bpetok/internal/tokenizer/core"
+)
+
+func TestStreamingDecoder_TokenByToken(t *testing.T) {
+	tok := loadTestTokenizer(t)
+
+	for _, s := range []string{
+		"plain ascii text",
+		"Héllo 🌍, नमस्ते दुनिया",
+		"💥🔥 the 💥 — “quotes” 你好",
+	} {
+		ids := tok.EncodeOffline([]byte(s), nil)
+		dec := core.NewStreamingDecoder(tok)
+
+		var got []byte
+		for _, id := range ids {
+			out := dec.Feed([]int{id})
+			if !utf8.Valid(out) {
+				t.Fatalf("%q: Feed returned a partial character %x", s, out)
+			}
+			got = append(got, out...)
+		}
+		got = append(got, dec.Flush()...)
+
+		if string(got) != s {
+			t.Fatalf("roundtrip mismatch: got %q want %q", got, s)
+		}
+	}
+}
+
+func TestStreamingDecoder_HoldsSplitRune(t *testing.T) {
+	tok := loadTestTokenizer(t)
+	dec := core.NewStreamingDecoder(tok)
+
+	euro := []byte("€") // e2 82 ac
+	first := dec.Feed([]int{tok.GetByteToToken('a'), tok.GetByteToToken(euro[0])})
+	if string(first) != "a" {
+		t.Fatalf("expected only the ascii byte, got %q", first)
+	}
+	if out := dec.Feed([]int{tok.GetByteToToken(euro[1])}); out != nil {
+		t.Fatalf("still incomplete, expected nothing, got %x", out)
+	}
+	if out := dec.Feed([]int{tok.GetByteToToken(euro[2])}); string(out) != "€" {
+		t.Fatalf("expected the completed character, got %q", out)
+	}
+	if out := dec.Flush(); out != nil {
+		t.Fatalf("nothing should be pending, got %x", out)
+	}
+}
+
+func TestStreamingDecoder_InvalidBytesPassThrough(t *testing.T) {
+	tok := loadTestTokenizer(t)
+	dec := core.NewStreamingDecoder(tok)
+
+	// 0xff can never start a character, holding it back would stall the stream forever
+	if out := dec.Feed([]int{tok.GetByteToToken(0xff)}); !bytes.Equal(out, []byte{0xff}) {
+		t.Fatalf("expected invalid byte to pass through, got %x", out)
+	}
+
+	// a dangling lead byte comes out on Flush
+	if out := dec.Feed([]int{tok.GetByteToToken(0xe2)}); out != nil {
+		t.Fatalf("expected lead byte to be held, got %x", out)
+	}
+	if out := dec.Flush(); !bytes.Equal(out, []byte{0xe2}) {
+		t.Fatalf("expected held byte on flush, got %x", out)
+	}
+}
+
+func TestStreamingDecoder_Replacement(t *testing.T) {
+	tok := loadTestTokenizer(t)
+	dec := core.NewStreamingDecoder(tok, core.WithReplacement(true))
+
+	// the lone 0xff, and the lead byte "a" cuts short, are each replaced
+	ids := []int{tok.GetByteToToken(0xff), tok.GetByteToToken(0xe2), tok.GetByteToToken('a')}
+	if out := dec.Feed(ids[:1]); string(out) != "\uFFFD" {
+		t.Fatalf("expected the invalid byte replaced, got %q", out)
+	}
+	if out := dec.Feed(ids[1:2]); out != nil {
+		t.Fatalf("expected lead byte to be held, got %x", out)
+	}
+	if out := dec.Feed(ids[2:]); string(out) != "\uFFFDa" {
+		t.Fatalf("expected the broken sequence replaced, got %q", out)
+	}
+
+	// a split character still comes out whole, a dangling one as a single U+FFFD per byte
+	euro := []byte("€")
+	if out := dec.Feed([]int{tok.GetByteToToken(euro[0]), tok.GetByteToToken(euro[1])}); out != nil {
+		t.Fatalf("expected the partial character to be held, got %x", out)
+	}
+	if out := dec.Flush(); string(out) != "\uFFFD\uFFFD" {
+		t.Fatalf("expected the held bytes replaced on flush, got %q", out)
+	}
+
+	// pieced together, the stream is the whole decode made valid
+	all := []byte("caf\xc3 \xe2\x82\xac\xff \xf0\x9f\x8c")
+	var stream []int
+	for _, b := range all {
+		stream = append(stream, tok.GetByteToToken(b))
+	}
+	var got []byte
+	for _, id := range stream {
+		got = append(got, dec.Feed([]int{id})...)
+	}
+	got = append(got, dec.Flush()...)
+	if want := core.AppendValidUTF8(nil, all); !bytes.Equal(got, want) || !utf8.Valid(got) {
+		t.Fatalf("got %q, want %q", got, want)
+	}
+}
+
+func TestDecodeLimited(t *testing.T) {
+	tok := loadTestTokenizer(t)
+	ids := tok.EncodeOffline([]byte("Hello world, again"), nil) // "Hello" " world" "," " again"
+
+	out, err := tok.DecodeLimited(ids, 18)
+	if err != nil || string(out) != "Hello world, again" {
+		t.Fatalf("within limit: %q, %v", out, err)
+	}
+
+	out, err = tok.DecodeLimited(ids, 14)
+	if !errors.Is(err, core.ErrDecodeLimit) {
+		t.Fatalf("expected ErrDecodeLimit, got %v", err)
+	}
+	if string(out) != "Hello world," {
+		t.Fatalf("expected the whole tokens that fit, got %q", out)
+	}
+
+	if out, err := tok.DecodeLimited(ids, 0); !errors.Is(err, core.ErrDecodeLimit) || out != nil {
+		t.Fatalf("zero limit: %q, %v", out, err)
+	}
+}
+
+func TestDecodeLimited_HostileInput(t *testing.T) {
+	tok := loadTestTokenizer(t)
+
+	// the longest token, ten million times over, would be over a gigabyte
+	longest := 0
+	for id := range tok.VocabSize() {
+		if tok.TokenLen(id) > tok.TokenLen(longest) {
+			longest = id
+		}
+	}
+	ids := make([]int, 10_000_000)
+	for i := range ids {
+		ids[i] = longest
+	}
+
+	allocs := testing.AllocsPerRun(1, func() {
+		if _, err := tok.DecodeLimited(ids, 1<<10); !errors.Is(err, core.ErrDecodeLimit) {
+			t.Fatalf("expected ErrDecodeLimit, got %v", err)
+		}
+	})
+	if allocs > 1 {
+		t.Fatalf("expected at most the capped output allocation, got %v", allocs)
+	}
+}
+
+func TestStreamingDecoder_SpecialText(t *testing.T) {
+	const eot = 50256
+	tok, err := core.Load(core.Files("../testdata/gpt2/vocab.json", "../testdata/gpt2/merges.txt"),
+		core.WithSpecialTokens(map[string]int{"<|endoftext|>": eot}))
+	if err != nil {
+		t.Fatalf("failed to load tokenizer: %v", err)
+	}
+	ids := append(tok.EncodeOffline([]byte("one"), nil), eot)
+	ids = append(ids, tok.EncodeOffline([]byte("two"), nil)...)
+
+	if got := core.NewStreamingDecoder(tok).Feed(ids); string(got) != "one<|endoftext|>two" {
+		t.Fatalf("without the option special tokens decode to their text, got %q", got)
+	}
+	if got := core.NewStreamingDecoder(tok, core.WithSpecialText(nil)).Feed(ids); string(got) != "onetwo" {
+		t.Fatalf("expected the special token dropped, got %q", got)
+	}
+	if got := core.NewStreamingDecoder(tok, core.WithSpecialText([]byte(" | "))).Feed(ids); string(got) != "one | two" {
+		t.Fatalf("expected the special token substituted, got %q", got)
+	}
+}
@@ -7,6 +7,8 @@ import (
 	"fmt"
 	"os"
 	"path/filepath"
+	"runtime"
+	"slices"
 	"strings"
 	"testing"
 
@@ -60,6 +62,15 @@ func TestOffline_MinimalMergeTerminates(t *testing.T) {
 	}
 }
 
+func TestLoad_MergesStartingWithHash(t *testing.T) {
+	tok := loadTestTokenizer(t)
+
+	// "# #" is a merge in GPT-2, not a comment, and "##" is a single token
+	if ids := tok.EncodeOffline([]byte("##"), nil); len(ids) != 1 {
+		t.Fatalf("expected \"##\" to merge into one token, got %v", ids)
+	}
+}
+
 func TestOfflineEncodePairMergesCollapseToSingleToken_Small(t *testing.T) {
 	tok := loadTestTokenizer(t)
 
@@ -141,3 +152,26 @@ func TestDecode_RoundTrip(t *testing.T) {
 		t.Fatalf("round-trip mismatch: got %q want %q", out, in)
 	}
 }
+
+func TestEncodeParallel_MatchesOffline(t *testing.T) {
+	tok := loadTestTokenizer(t)
+	corpus, err := os.ReadFile("../testdata/gpt2/bench_corpus.txt")
+	if err != nil {
+		t.Fatalf("read corpus: %v", err)
+	}
+	size := 2 << 20
+	if testing.Short() {
+		size = 1 << 20
+	}
+	input := corpus[:min(len(corpus), size)]
+	// split into pieces even on a single CPU
+	defer runtime.GOMAXPROCS(runtime.GOMAXPROCS(4))
+
+	want := tok.EncodeOffline(input, nil)
+	if got := tok.EncodeParallel(input); !slices.Equal(got, want) {
+		t.Fatalf("got %d tokens, want %d", len(got), len(want))
+	}
+	if got := tok.EncodeParallel(input[:1000]); !slices.Equal(got, tok.EncodeOffline(input[:1000], nil)) {
+		t.Fatalf("small input differs")
+	}
+}
@@ -0,0 +1,62 @@
+package offline_encoder
+
+import (
+	"testing"
+
+	"github.com/bpetok/internal/tokenizer/core"
+)
+
+func TestFingerprint(t *testing.T) {
+	load := func(src core.Source, opts ...core.Option) *core.Tokenizer {
+		t.Helper()
+		tok, err := core.Load(src, opts...)
+		if err != nil {
+			t.Fatalf("load: %v", err)
+		}
+		return tok
+	}
+
+	base := load(core.Files(testVocabPath, testMergesPath))
+	fp := base.Fingerprint()
+	if len(fp) != 64 || fp != base.Fingerprint() {
+		t.Fatalf("fingerprint %q is not a stable hex SHA-256", fp)
+	}
+	if again := load(core.Files(testVocabPath, testMergesPath)).Fingerprint(); again != fp {
+		t.Fatalf("reload changed the fingerprint: %s vs %s", again, fp)
+	}
+	data, err := base.MarshalBinary()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if compiled := load(core.Compiled(data)).Fingerprint(); compiled != fp {
+		t.Fatalf("compiled copy has fingerprint %s, want %s", compiled, fp)
+	}
+
+	for name, tok := range map[string]*core.Tokenizer{
+		"special tokens": load(core.Files(testVocabPath, testMergesPath), core.WithSpecialTokens(map[string]int{"<|endoftext|>": 50256})),
+		"normalization":  load(core.Files(testVocabPath, testMergesPath), core.WithNormalization(core.NormalizeNFC)),
+		"fewer merges":   load(core.Files(testVocabPath, writeTruncatedMerges(t, 1000, ""))),
+	} {
+		if tok.Fingerprint() == fp {
+			t.Errorf("%s: fingerprint unchanged", name)
+		}
+	}
+}
+
+func TestFingerprint_TiedRanks(t *testing.T) {
+	// tiktoken ranks give every pair merging into a token the same rank, the fingerprint must not depend on
+	// the order they come out of the map in
+	rankFile, _ := gpt2AsTiktoken(t)
+	var fp string
+	for i := range 3 {
+		tok, err := core.Load(core.TiktokenBytes(rankFile))
+		if err != nil {
+			t.Fatalf("load: %v", err)
+		}
+		if i == 0 {
+			fp = tok.Fingerprint()
+		} else if got := tok.Fingerprint(); got != fp {
+			t.Fatalf("reload %d changed the fingerprint: %s vs %s", i, got, fp)
+		}
+	}
+}
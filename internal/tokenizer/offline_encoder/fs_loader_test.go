@@ -0,0 +1,85 @@
+package offline_encoder
+
+import (
+	"archive/zip"
+	"bytes"
+	"errors"
+	"io/fs"
+	"os"
+	"reflect"
+	"testing"
+	"testing/fstest"
+
+	"github.com/bpetok/internal/tokenizer/core"
+)
+
+func readGPT2Assets(t *testing.T) (vocab, merges []byte) {
+	t.Helper()
+
+	vocab, err := os.ReadFile(testVocabPath)
+	if err != nil {
+		t.Fatalf("read vocab: %v", err)
+	}
+	merges, err = os.ReadFile(testMergesPath)
+	if err != nil {
+		t.Fatalf("read merges: %v", err)
+	}
+	return vocab, merges
+}
+
+func TestLoadTokenizerFromReaders(t *testing.T) {
+	vocab, merges := readGPT2Assets(t)
+
+	tok, err := core.LoadTokenizerFromReaders(bytes.NewReader(vocab), bytes.NewReader(merges))
+	if err != nil {
+		t.Fatalf("load: %v", err)
+	}
+
+	in := []byte("loaded from a reader")
+	if got, want := tok.EncodeOffline(in, nil), loadTestTokenizer(t).EncodeOffline(in, nil); !reflect.DeepEqual(got, want) {
+		t.Fatalf("got %v, want %v", got, want)
+	}
+}
+
+func TestLoadTokenizerFS(t *testing.T) {
+	vocab, merges := readGPT2Assets(t)
+	want := loadTestTokenizer(t).EncodeOffline([]byte("loaded from an fs.FS"), nil)
+
+	mapFS := fstest.MapFS{
+		"gpt2/vocab.json": {Data: vocab},
+		"gpt2/merges.txt": {Data: merges},
+	}
+
+	var zipped bytes.Buffer
+	zw := zip.NewWriter(&zipped)
+	for name, data := range map[string][]byte{"gpt2/vocab.json": vocab, "gpt2/merges.txt": merges} {
+		w, err := zw.Create(name)
+		if err != nil {
+			t.Fatalf("zip create: %v", err)
+		}
+		if _, err := w.Write(data); err != nil {
+			t.Fatalf("zip write: %v", err)
+		}
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatalf("zip close: %v", err)
+	}
+	zipFS, err := zip.NewReader(bytes.NewReader(zipped.Bytes()), int64(zipped.Len()))
+	if err != nil {
+		t.Fatalf("zip open: %v", err)
+	}
+
+	for name, fsys := range map[string]fs.FS{"map": mapFS, "zip": zipFS} {
+		tok, err := core.LoadTokenizerFS(fsys, "gpt2/vocab.json", "gpt2/merges.txt")
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		if got := tok.EncodeOffline([]byte("loaded from an fs.FS"), nil); !reflect.DeepEqual(got, want) {
+			t.Fatalf("%s: got %v, want %v", name, got, want)
+		}
+	}
+
+	if _, err := core.LoadTokenizerFS(mapFS, "gpt2/vocab.json", "missing.txt"); !errors.Is(err, fs.ErrNotExist) {
+		t.Fatalf("expected fs.ErrNotExist, got %v", err)
+	}
+}
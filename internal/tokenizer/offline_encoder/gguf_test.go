@@ -0,0 +1,205 @@
+package offline_encoder
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/bpetok/internal/tokenizer/core"
+)
+
+// ggufWriter assembles GGUF v3 metadata, no tensors.
+type ggufWriter struct {
+	kv  bytes.Buffer
+	kvs int
+}
+
+func (w *ggufWriter) str(s string) {
+	w.kv.Write(binary.LittleEndian.AppendUint64(nil, uint64(len(s))))
+	w.kv.WriteString(s)
+}
+
+func (w *ggufWriter) u32(v uint32) { w.kv.Write(binary.LittleEndian.AppendUint32(nil, v)) }
+
+func (w *ggufWriter) key(k string, typ uint32) {
+	w.kvs++
+	w.str(k)
+	w.u32(typ)
+}
+
+func (w *ggufWriter) arrayHeader(k string, elem uint32, n int) {
+	w.key(k, 9)
+	w.u32(elem)
+	w.kv.Write(binary.LittleEndian.AppendUint64(nil, uint64(n)))
+}
+
+func (w *ggufWriter) strings(k string, ss []string) {
+	w.arrayHeader(k, 8, len(ss))
+	for _, s := range ss {
+		w.str(s)
+	}
+}
+
+func (w *ggufWriter) bytes() []byte {
+	out := []byte("GGUF")
+	out = binary.LittleEndian.AppendUint32(out, 3)
+	out = binary.LittleEndian.AppendUint64(out, 0)
+	out = binary.LittleEndian.AppendUint64(out, uint64(w.kvs))
+	return append(out, w.kv.Bytes()...)
+}
+
+// gpt2GGUF converts the GPT-2 test vocab the way llama.cpp's convert script does.
+func gpt2GGUF(t *testing.T) []byte {
+	t.Helper()
+
+	data, err := os.ReadFile(testVocabPath)
+	if err != nil {
+		t.Fatalf("read vocab: %v", err)
+	}
+	vocab, err := core.ParseVocabJSON(data)
+	if err != nil {
+		t.Fatalf("parse vocab: %v", err)
+	}
+	tokens := make([]string, len(vocab))
+	for text, id := range vocab {
+		tokens[id] = text
+	}
+	mergesData, err := os.ReadFile(testMergesPath)
+	if err != nil {
+		t.Fatalf("read merges: %v", err)
+	}
+	var merges []string
+	for _, line := range strings.Split(string(mergesData), "\n") {
+		if line != "" && !strings.HasPrefix(line, "#version") {
+			merges = append(merges, line)
+		}
+	}
+
+	var w ggufWriter
+	// metadata the loader has to skip over
+	w.key("general.name", 8)
+	w.str("gpt2")
+	w.arrayHeader("gpt2.some_dims", 4, 3)
+	for i := range 3 {
+		w.u32(uint32(i))
+	}
+	w.arrayHeader("general.nested", 9, 1)
+	w.u32(8)
+	w.kv.Write(binary.LittleEndian.AppendUint64(nil, 1))
+	w.str("x")
+
+	w.key("tokenizer.ggml.model", 8)
+	w.str("gpt2")
+	w.key("tokenizer.ggml.pre", 8)
+	w.str("gpt-2")
+	w.strings("tokenizer.ggml.tokens", tokens)
+	w.arrayHeader("tokenizer.ggml.token_type", 5, len(tokens))
+	for id := range tokens {
+		typ := uint32(1)
+		if tokens[id] == "<|endoftext|>" {
+			typ = 3
+		}
+		w.u32(typ)
+	}
+	w.strings("tokenizer.ggml.merges", merges)
+	return w.bytes()
+}
+
+func TestGGUF_GPT2MatchesVocabAndMerges(t *testing.T) {
+	var logs bytes.Buffer
+	tok, err := core.Load(core.GGUFBytes(gpt2GGUF(t)), core.WithLogger(log.New(&logs, "", 0)))
+	if err != nil {
+		t.Fatalf("load: %v", err)
+	}
+
+	in := []byte("Hello world, the quick brown fox jumps over the lazy dog. ####")
+	if got, want := tok.EncodeOffline(in, nil), loadTestTokenizer(t).EncodeOffline(in, nil); !reflect.DeepEqual(got, want) {
+		t.Fatalf("got %v, want %v", got, want)
+	}
+	if sp := tok.SpecialTokens(); !reflect.DeepEqual(sp, map[string]int{"<|endoftext|>": 50256}) {
+		t.Fatalf("special tokens %v", sp)
+	}
+	if !strings.Contains(logs.String(), "gpt-2") {
+		t.Fatalf("expected a pre-tokenizer warning, got %q", logs.String())
+	}
+}
+
+func TestGGUF_LlamaMatchesSentencePiece(t *testing.T) {
+	sp, err := core.Load(core.SentencePieceBytes(spTestModel(2, false, spTestPieces)))
+	if err != nil {
+		t.Fatalf("load sentencepiece: %v", err)
+	}
+
+	var w ggufWriter
+	w.key("tokenizer.ggml.model", 8)
+	w.str("llama")
+	w.key("tokenizer.ggml.add_space_prefix", 7)
+	w.kv.WriteByte(0)
+
+	var tokens []string
+	var scores []float32
+	var types []uint32
+	for id := range sp.VocabSize() {
+		text := string(sp.TokenBytes(id))
+		typ := uint32(1)
+		switch {
+		case id == 0:
+			typ = 2
+		case id < 3:
+			typ = 3
+		case id < 259:
+			text, typ = fmt.Sprintf("<0x%02X>", id-3), 6
+		case id-259 < len(spTestPieces):
+			text, scores = spTestPieces[id-259].text, append(scores, spTestPieces[id-259].score)
+		default:
+			continue // character prefixes the loader adds itself
+		}
+		if id < 259 {
+			scores = append(scores, 0)
+		}
+		tokens, types = append(tokens, text), append(types, typ)
+	}
+	w.strings("tokenizer.ggml.tokens", tokens)
+	w.arrayHeader("tokenizer.ggml.scores", 6, len(scores))
+	for _, s := range scores {
+		w.u32(math.Float32bits(s))
+	}
+	w.arrayHeader("tokenizer.ggml.token_type", 5, len(types))
+	for _, typ := range types {
+		w.u32(typ)
+	}
+
+	tok, err := core.Load(core.GGUFBytes(w.bytes()))
+	if err != nil {
+		t.Fatalf("load gguf: %v", err)
+	}
+	in := []byte(" the café €₭\n")
+	if got, want := tok.EncodeOffline(in, nil), sp.EncodeOffline(in, nil); !reflect.DeepEqual(got, want) {
+		t.Fatalf("got %v, want %v", got, want)
+	}
+}
+
+func TestGGUF_Unsupported(t *testing.T) {
+	var w ggufWriter
+	w.key("tokenizer.ggml.model", 8)
+	w.str("bert")
+	w.strings("tokenizer.ggml.tokens", []string{"[PAD]"})
+	if _, err := core.Load(core.GGUFBytes(w.bytes())); !errors.Is(err, core.ErrUnsupportedGGUF) {
+		t.Fatalf("expected ErrUnsupportedGGUF, got %v", err)
+	}
+
+	data := gpt2GGUF(t)
+	if _, err := core.Load(core.GGUFBytes(data[:len(data)/2])); err == nil || !strings.Contains(err.Error(), "truncated") {
+		t.Fatalf("expected a truncation error, got %v", err)
+	}
+	if _, err := core.Load(core.GGUFBytes([]byte("GGML...."))); err == nil {
+		t.Fatalf("expected an error for a non-gguf file")
+	}
+}
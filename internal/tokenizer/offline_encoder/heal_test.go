@@ -0,0 +1,53 @@
+package offline_encoder
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestTokenHeal_MatchesWholeEncode(t *testing.T) {
+	tok := loadTestTokenizer(t)
+
+	text := "The quick brown fox jumps over the lazy dog. https://example.com/path?query=token healing " +
+		strings.Repeat("ab", 40) + " naïve café"
+
+	want := tok.EncodeOffline([]byte(text), nil)
+	for cut := 0; cut <= len(text); cut++ {
+		prefix := tok.EncodeOffline([]byte(text[:cut]), nil)
+		got := tok.TokenHeal(prefix, []byte(text[cut:]))
+		if !reflect.DeepEqual(got, want) {
+			t.Fatalf("cut=%d:\ngot  %v\nwant %v", cut, got, want)
+		}
+	}
+}
+
+func TestTokenHeal_ReusesPrefixHead(t *testing.T) {
+	tok := loadTestTokenizer(t)
+
+	prefix := tok.EncodeOffline([]byte(strings.Repeat("The prompt is long. ", 200)), nil)
+	healed := tok.TokenHeal(prefix, []byte("ing"))
+
+	if !reflect.DeepEqual(healed[:len(prefix)/2], prefix[:len(prefix)/2]) {
+		t.Fatalf("the head of a long prefix should be reused unchanged")
+	}
+}
+
+// Ranked from the end of the alphabet down, one more letter re-pairs a whole run, so healing has to back
+// off to a junction no merge can join rather than by a fixed number of bytes.
+func TestTokenHeal_RightToLeftMerges(t *testing.T) {
+	var pairs []string
+	for c := byte('y'); c >= 'a'; c-- {
+		pairs = append(pairs, string([]byte{c, c + 1}))
+	}
+	tok, _ := tinyTiktoken(t, pairs...)
+
+	text := "xyz abcdefghijklmnopqrstuvwxyz.mnopq"
+	want := tok.EncodeOffline([]byte(text), nil)
+	for cut := 0; cut <= len(text); cut++ {
+		prefix := tok.EncodeOffline([]byte(text[:cut]), nil)
+		if got := tok.TokenHeal(prefix, []byte(text[cut:])); !reflect.DeepEqual(got, want) {
+			t.Fatalf("cut=%d:\ngot  %v\nwant %v", cut, got, want)
+		}
+	}
+}
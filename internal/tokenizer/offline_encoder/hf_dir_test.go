@@ -0,0 +1,131 @@
+package offline_encoder
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"testing/fstest"
+
+	"github.com/bpetok/internal/tokenizer/core"
+)
+
+const testSpecialTokensMap = `{
+  "bos_token": "<|endoftext|>",
+  "eos_token": {"content": "<|endoftext|>", "lstrip": false, "normalized": true, "rstrip": false, "single_word": false},
+  "pad_token": "<|pad|>",
+  "additional_special_tokens": ["<|im_start|>", {"content": "<|im_end|>"}]
+}`
+
+func TestHFDir_VocabMergesAndAddedTokens(t *testing.T) {
+	vocab, merges := readGPT2Assets(t)
+	fsys := fstest.MapFS{
+		"gpt2/vocab.json":              {Data: vocab},
+		"gpt2/merges.txt":              {Data: merges},
+		"gpt2/added_tokens.json":       {Data: []byte(`{"<|pad|>": 50257, "<|im_start|>": 50258, "<|im_end|>": 50259, " custom": 50260}`)},
+		"gpt2/special_tokens_map.json": {Data: []byte(testSpecialTokensMap)},
+	}
+
+	tok, err := core.Load(core.HFDirFS(fsys, "gpt2"))
+	if err != nil {
+		t.Fatalf("load: %v", err)
+	}
+	if tok.VocabSize() != 50261 {
+		t.Fatalf("expected added tokens to extend the vocab, got %d", tok.VocabSize())
+	}
+	want := map[string]int{"<|endoftext|>": 50256, "<|pad|>": 50257, "<|im_start|>": 50258, "<|im_end|>": 50259}
+	if got := tok.SpecialTokens(); !reflect.DeepEqual(got, want) {
+		t.Fatalf("SpecialTokens() = %v, want %v", got, want)
+	}
+
+	for name, id := range map[string]int{"bos_token": 50256, "eos_token": 50256, "pad_token": 50257, "<|im_end|>": 50259} {
+		if got, ok := tok.SpecialTokenID(name); !ok || got != id {
+			t.Errorf("SpecialTokenID(%q) = %d, %v, want %d", name, got, ok, id)
+		}
+	}
+	if _, ok := tok.SpecialTokenID("unk_token"); ok {
+		t.Errorf("unk_token isn't in the map")
+	}
+	for id, special := range map[int]bool{50256: true, 50259: true, 50260: false, 0: false} {
+		if tok.IsSpecial(id) != special {
+			t.Errorf("IsSpecial(%d) = %v", id, !special)
+		}
+	}
+	if got := string(tok.Decode([]int{50258, 50260})); got != "<|im_start|> custom" {
+		t.Fatalf("decode added: %q", got)
+	}
+}
+
+func TestHFDir_PrefersTokenizerJSON(t *testing.T) {
+	dir := t.TempDir()
+	data := gpt2TokenizerJSON(t, true, map[string]any{
+		"added_tokens": []any{
+			map[string]any{"id": 50256, "content": "<|endoftext|>", "special": true},
+			map[string]any{"id": 50257, "content": "<|pad|>", "special": false},
+			map[string]any{"id": 50258, "content": "<|im_start|>", "special": true},
+			map[string]any{"id": 50259, "content": "<|im_end|>", "special": true},
+		},
+	})
+	for name, content := range map[string][]byte{
+		"tokenizer.json":          data,
+		"special_tokens_map.json": []byte(testSpecialTokensMap),
+		// would fail to load if it were read
+		"vocab.json": []byte("not json"),
+	} {
+		if err := os.WriteFile(filepath.Join(dir, name), content, 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tok, err := core.Load(core.HFDir(dir))
+	if err != nil {
+		t.Fatalf("load: %v", err)
+	}
+	// <|pad|> isn't special in tokenizer.json, special_tokens_map.json makes it so
+	if id, ok := tok.SpecialTokenID("pad_token"); !ok || id != 50257 || !tok.IsSpecial(50257) {
+		t.Fatalf("pad_token: %d, %v", id, ok)
+	}
+
+	// the roles don't survive compiling, the texts do
+	compiled, err := tok.MarshalBinary()
+	if err != nil {
+		t.Fatal(err)
+	}
+	back, err := core.Load(core.Compiled(compiled))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if id, ok := back.SpecialTokenID("<|pad|>"); !ok || id != 50257 || !back.IsSpecial(50257) {
+		t.Fatalf("compiled <|pad|>: %d, %v", id, ok)
+	}
+}
+
+func TestHFDir_Errors(t *testing.T) {
+	vocab, merges := readGPT2Assets(t)
+	base := func(extra map[string]string) fstest.MapFS {
+		fsys := fstest.MapFS{"vocab.json": {Data: vocab}, "merges.txt": {Data: merges}}
+		for name, content := range extra {
+			fsys[name] = &fstest.MapFile{Data: []byte(content)}
+		}
+		return fsys
+	}
+
+	cases := map[string]struct {
+		fsys fstest.MapFS
+		want string
+	}{
+		"unknown special":   {base(map[string]string{"special_tokens_map.json": `{"eos_token": "<|nope|>"}`}), "<|nope|>"},
+		"bad map":           {base(map[string]string{"special_tokens_map.json": `{"eos_token": 7}`}), "eos_token"},
+		"gap in added":      {base(map[string]string{"added_tokens.json": `{"<|pad|>": 50300}`}), "<|pad|>"},
+		"bad added_tokens":  {base(map[string]string{"added_tokens.json": `[]`}), "added_tokens.json"},
+		"no vocab.json":     {fstest.MapFS{"merges.txt": {Data: merges}}, "vocab"},
+		"object no content": {base(map[string]string{"special_tokens_map.json": `{"eos_token": {}}`}), "content"},
+	}
+	for name, c := range cases {
+		_, err := core.Load(core.HFDirFS(c.fsys, "."))
+		if err == nil || !strings.Contains(err.Error(), c.want) {
+			t.Errorf("%s: expected an error mentioning %q, got %v", name, c.want, err)
+		}
+	}
+}
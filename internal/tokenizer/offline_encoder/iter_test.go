@@ -0,0 +1,64 @@
+package offline_encoder
+
+import (
+	"bytes"
+	"reflect"
+	"slices"
+	"testing"
+)
+
+func TestEncodeSeq_MatchesEncodeOffline(t *testing.T) {
+	tok := loadTestTokenizer(t)
+
+	for _, s := range []string{"", "a", "hello world", "💥🔥 the 💥", "determinism determinism"} {
+		want := tok.EncodeOffline([]byte(s), nil)
+		got := slices.Collect(tok.EncodeSeq([]byte(s)))
+		if len(want) == 0 && len(got) == 0 {
+			continue
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Fatalf("%q: got %v want %v", s, got, want)
+		}
+	}
+}
+
+func TestEncodeSeq_EarlyBreak(t *testing.T) {
+	tok := loadTestTokenizer(t)
+	in := []byte("the quick brown fox jumps over the lazy dog")
+	want := tok.EncodeOffline(in, nil)
+
+	var got []int
+	for id := range tok.EncodeSeq(in) {
+		got = append(got, id)
+		if len(got) == 3 {
+			break
+		}
+	}
+	if !reflect.DeepEqual(got, want[:3]) {
+		t.Fatalf("got %v want %v", got, want[:3])
+	}
+
+	// the scratch handed back after a break must still be usable
+	if again := tok.EncodeOffline(in, nil); !reflect.DeepEqual(again, want) {
+		t.Fatalf("encode after early break mismatch")
+	}
+}
+
+func TestVocabAll(t *testing.T) {
+	tok := loadTestTokenizer(t)
+	v := tok.Vocab()
+
+	n := 0
+	for id, b := range v.All() {
+		if id != n {
+			t.Fatalf("ids out of order: got %d want %d", id, n)
+		}
+		if !bytes.Equal(b, v.Bytes(id)) {
+			t.Fatalf("token %d bytes mismatch", id)
+		}
+		n++
+	}
+	if n != v.Len() {
+		t.Fatalf("yielded %d tokens, vocab has %d", n, v.Len())
+	}
+}
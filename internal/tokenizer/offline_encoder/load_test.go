@@ -0,0 +1,207 @@
+package offline_encoder
+
+import (
+	"bytes"
+	"errors"
+	"log"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/bpetok/internal/tokenizer/core"
+)
+
+func TestLoadOptions_SourcesAgree(t *testing.T) {
+	vocab, err := os.ReadFile(testVocabPath)
+	if err != nil {
+		t.Fatalf("read vocab: %v", err)
+	}
+	merges, err := os.ReadFile(testMergesPath)
+	if err != nil {
+		t.Fatalf("read merges: %v", err)
+	}
+	rankFile, _ := gpt2AsTiktoken(t)
+
+	in := []byte("Hello world, the quick brown fox jumps over the lazy dog.")
+	want := loadTestTokenizer(t).EncodeOffline(in, nil)
+
+	for name, src := range map[string]core.Source{
+		"files":    core.Files(testVocabPath, testMergesPath),
+		"bytes":    core.Bytes(vocab, merges),
+		"tiktoken": core.TiktokenBytes(rankFile),
+	} {
+		tok, err := core.Load(src)
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		if got := tok.EncodeOffline(in, nil); !reflect.DeepEqual(got, want) {
+			t.Fatalf("%s: got %v, want %v", name, got, want)
+		}
+	}
+}
+
+func TestLoadOptions_Strict(t *testing.T) {
+	merges := writeTruncatedMerges(t, 1000, "Ġt")
+
+	if _, err := core.Load(core.Files(testVocabPath, merges)); !errors.Is(err, core.ErrTruncatedMerges) {
+		t.Fatalf("expected ErrTruncatedMerges by default, got %v", err)
+	}
+
+	var logs bytes.Buffer
+	tok, err := core.Load(core.Files(testVocabPath, merges), core.WithStrict(false), core.WithLogger(log.New(&logs, "", 0)))
+	if err != nil {
+		t.Fatalf("lenient load failed: %v", err)
+	}
+	if tok.Stats().DroppedMerges != 1 || !strings.Contains(logs.String(), "truncated") {
+		t.Fatalf("expected one dropped line and a warning, got %d and %q", tok.Stats().DroppedMerges, logs.String())
+	}
+}
+
+// mergesWithBadLines splices lines into the GPT-2 merges right after the first 100 merges.
+func mergesWithBadLines(t *testing.T, bad ...string) []byte {
+	t.Helper()
+	_, merges := readGPT2Assets(t)
+	lines := strings.SplitAfter(string(merges), "\n")
+	var out []string
+	out = append(out, lines[:101]...)
+	for _, l := range bad {
+		out = append(out, l+"\n")
+	}
+	out = append(out, lines[101:]...)
+	return []byte(strings.Join(out, ""))
+}
+
+func TestLoadOptions_StrictErrors(t *testing.T) {
+	vocab, _ := readGPT2Assets(t)
+
+	_, err := core.Load(core.Bytes(vocab, mergesWithBadLines(t, "Ġt notatoken")))
+	if !errors.Is(err, core.ErrMergeUnknownToken) || !errors.Is(err, core.ErrTruncatedMerges) {
+		t.Fatalf("expected ErrMergeUnknownToken within ErrTruncatedMerges, got %v", err)
+	}
+	_, err = core.Load(core.Bytes(vocab, mergesWithBadLines(t, "Ġt he x")))
+	if !errors.Is(err, core.ErrMalformedMerges) || errors.Is(err, core.ErrTruncatedMerges) {
+		t.Fatalf("expected ErrMalformedMerges, not a truncation, got %v", err)
+	}
+	if _, err := core.Load(core.Bytes(vocab, mergesWithBadLines(t, "Ġ t"))); !errors.Is(err, core.ErrDuplicateMerge) {
+		t.Fatalf("expected ErrDuplicateMerge, got %v", err)
+	}
+
+	holey, merges := holeyGPT2Vocab(t)
+	if _, err := core.Load(core.Bytes(holey, merges)); !errors.Is(err, core.ErrVocabNotDense) {
+		t.Fatalf("expected ErrVocabNotDense, got %v", err)
+	}
+	rankFile, _ := gpt2AsTiktoken(t)
+	gappy := bytes.Replace(rankFile, []byte(" 50255\n"), []byte(" 50400\n"), 1)
+	if _, err := core.Load(core.TiktokenBytes(gappy)); !errors.Is(err, core.ErrVocabNotDense) {
+		t.Fatalf("expected ErrVocabNotDense from tiktoken ranks, got %v", err)
+	}
+}
+
+func TestLoadOptions_LenientSkipsBadMerges(t *testing.T) {
+	vocab, _ := readGPT2Assets(t)
+	merges := mergesWithBadLines(t, "Ġt notatoken", "Ġ t", "notatoken x", "Ġt he x")
+
+	var logs bytes.Buffer
+	tok, err := core.Load(core.Bytes(vocab, merges), core.WithStrict(false), core.WithLogger(log.New(&logs, "", 0)))
+	if err != nil {
+		t.Fatalf("lenient load failed: %v", err)
+	}
+	if st := tok.Stats(); st.Merges != 50000 || st.DroppedMerges != 4 {
+		t.Fatalf("expected 50000 merges and 4 skipped lines, got %+v", st)
+	}
+	if !strings.Contains(logs.String(), "skipped") {
+		t.Fatalf("expected a warning, got %q", logs.String())
+	}
+
+	in := []byte("Hello world, the quick brown fox jumps over the lazy dog.")
+	if got, want := tok.EncodeOffline(in, nil), loadTestTokenizer(t).EncodeOffline(in, nil); !reflect.DeepEqual(got, want) {
+		t.Fatalf("got %v, want %v", got, want)
+	}
+}
+
+func TestLoadOptions_LenientVocabGaps(t *testing.T) {
+	holey, merges := holeyGPT2Vocab(t)
+
+	var logs bytes.Buffer
+	tok, err := core.Load(core.Bytes(holey, merges), core.WithStrict(false), core.WithLogger(log.New(&logs, "", 0)))
+	if err != nil {
+		t.Fatalf("lenient load failed: %v", err)
+	}
+	if tok.ValidID(50256) || !tok.ValidID(50300) {
+		t.Fatalf("expected 50256 to be a hole and 50300 to decode")
+	}
+	if !strings.Contains(logs.String(), "44 unused ids") {
+		t.Fatalf("expected a warning, got %q", logs.String())
+	}
+}
+
+func TestLoadOptions_SpecialTokens(t *testing.T) {
+	base := loadTestTokenizer(t)
+	n := base.VocabSize()
+
+	special := map[string]int{
+		"<|endoftext|>": 50256, // already in the GPT-2 vocab
+		"<|fim|>":       n,
+		"<|sep|>":       n + 1,
+	}
+	tok, err := core.Load(core.Files(testVocabPath, testMergesPath), core.WithSpecialTokens(special))
+	if err != nil {
+		t.Fatalf("load: %v", err)
+	}
+	if tok.VocabSize() != n+2 {
+		t.Fatalf("expected vocab to grow to %d, got %d", n+2, tok.VocabSize())
+	}
+	if got := string(tok.Decode([]int{n + 1, n})); got != "<|sep|><|fim|>" {
+		t.Fatalf("decode specials: %q", got)
+	}
+	if !reflect.DeepEqual(tok.SpecialTokens(), special) {
+		t.Fatalf("SpecialTokens() = %v", tok.SpecialTokens())
+	}
+
+	// specials only decode, the merge loop never produces them
+	in := []byte("<|fim|> plain text")
+	if got, want := tok.EncodeOffline(in, nil), base.EncodeOffline(in, nil); !reflect.DeepEqual(got, want) {
+		t.Fatalf("specials changed ordinary encoding: %v vs %v", got, want)
+	}
+
+	for name, bad := range map[string]map[string]int{
+		"gap":      {"<|fim|>": n + 1},
+		"mismatch": {"<|fim|>": 50256},
+		"negative": {"<|fim|>": -1},
+		"empty":    {"": n},
+	} {
+		if _, err := core.Load(core.Files(testVocabPath, testMergesPath), core.WithSpecialTokens(bad)); err == nil {
+			t.Fatalf("%s: expected an error", name)
+		}
+	}
+}
+
+func TestLoadOptions_MemoryBudget(t *testing.T) {
+	tok := loadTestTokenizer(t)
+	need := tok.MemoryFootprint()
+	if need <= 0 {
+		t.Fatalf("MemoryFootprint() = %d", need)
+	}
+
+	if _, err := core.Load(core.Files(testVocabPath, testMergesPath), core.WithMemoryBudget(need)); err != nil {
+		t.Fatalf("load within budget: %v", err)
+	}
+	_, err := core.Load(core.Files(testVocabPath, testMergesPath), core.WithMemoryBudget(need/2))
+	if !errors.Is(err, core.ErrMemoryBudget) {
+		t.Fatalf("expected ErrMemoryBudget, got %v", err)
+	}
+}
+
+func TestLoadOptions_Normalization(t *testing.T) {
+	tok, err := core.Load(core.Files(testVocabPath, testMergesPath), core.WithNormalization(core.NormalizeNFC))
+	if err != nil {
+		t.Fatalf("load: %v", err)
+	}
+	if tok.Normalization() != core.NormalizeNFC {
+		t.Fatalf("Normalization() = %v", tok.Normalization())
+	}
+	if got := string(tok.Normalization().Apply([]byte("é"))); got != "é" {
+		t.Fatalf("Apply: %q", got)
+	}
+}
@@ -0,0 +1,162 @@
+package offline_encoder
+
+import (
+	"bytes"
+	"errors"
+	"log"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/bpetok/internal/tokenizer/core"
+)
+
+const (
+	testVocabPath  = "../testdata/gpt2/vocab.json"
+	testMergesPath = "../testdata/gpt2/merges.txt"
+)
+
+// writeTruncatedMerges writes the first n lines of the GPT-2 merges plus an optional half line.
+func writeTruncatedMerges(t *testing.T, n int, tail string) string {
+	t.Helper()
+
+	data, err := os.ReadFile(testMergesPath)
+	if err != nil {
+		t.Fatalf("read merges: %v", err)
+	}
+	lines := strings.SplitAfter(string(data), "\n")
+
+	path := filepath.Join(t.TempDir(), "merges.txt")
+	if err := os.WriteFile(path, []byte(strings.Join(lines[:n], "")+tail), 0o644); err != nil {
+		t.Fatalf("write merges: %v", err)
+	}
+	return path
+}
+
+func TestLoad_GPT2KeepsHashMerges(t *testing.T) {
+	tok := loadTestTokenizer(t)
+
+	// "# #" is a real merge, not a comment, so the full file yields 50000 merges
+	if got := tok.Stats().Merges; got != 50000 {
+		t.Fatalf("expected 50000 merges, got %d", got)
+	}
+
+	ids := tok.EncodeOffline([]byte("####"), nil)
+	if len(ids) != 1 {
+		t.Fatalf("expected #### to merge into one token, got %v", ids)
+	}
+}
+
+func TestLoad_MergesFormatting(t *testing.T) {
+	vocab, merges := readGPT2Assets(t)
+	base := loadTestTokenizer(t)
+
+	// a BOM before the header, CRLF endings, and tabs or runs of spaces between and around the tokens
+	messy := "\ufeff" + strings.ReplaceAll(string(merges), "\n", "\r\n")
+	messy = strings.Replace(messy, "\r\nĠ t\r\n", "\r\n  Ġ\tt \r\n", 1)
+	messy = strings.Replace(messy, "\r\nh e\r\n", "\r\nh    e\r\n\r\n", 1)
+
+	tok, err := core.Load(core.Bytes(vocab, []byte(messy)))
+	if err != nil {
+		t.Fatalf("load: %v", err)
+	}
+	if got := tok.Stats().Merges; got != 50000 {
+		t.Fatalf("expected 50000 merges, got %d", got)
+	}
+	in := []byte("Hello world, the quick brown fox")
+	if got, want := tok.EncodeOffline(in, nil), base.EncodeOffline(in, nil); !reflect.DeepEqual(got, want) {
+		t.Fatalf("got %v, want %v", got, want)
+	}
+}
+
+func TestLoad_MergesUnicodeWhitespace(t *testing.T) {
+	vocab, merges := readGPT2Assets(t)
+
+	// a non-breaking space is part of the token, not a separator, so this is one unknown token rather than
+	// a line with three fields
+	bad := strings.Replace(string(merges), "\nh e\n", "\nh\u00a0e e\n", 1)
+	_, err := core.Load(core.Bytes(vocab, []byte(bad)))
+	if !errors.Is(err, core.ErrMergeUnknownToken) {
+		t.Fatalf("expected ErrMergeUnknownToken, got %v", err)
+	}
+	if !strings.Contains(err.Error(), `"h\u00a0e"`) || !strings.Contains(err.Error(), "line ") {
+		t.Fatalf("error should name the line and show the space: %v", err)
+	}
+}
+
+func TestLoad_TruncatedMergesStrict(t *testing.T) {
+	merges := writeTruncatedMerges(t, 1000, "Ġt")
+
+	_, err := core.LoadTokenizerFromFiles(testVocabPath, merges)
+	if !errors.Is(err, core.ErrTruncatedMerges) {
+		t.Fatalf("expected ErrTruncatedMerges, got %v", err)
+	}
+	if !strings.Contains(err.Error(), "line 1001") {
+		t.Fatalf("error should point at the broken line: %v", err)
+	}
+}
+
+func TestLoad_TruncatedMergesLenient(t *testing.T) {
+	merges := writeTruncatedMerges(t, 1000, "Ġt")
+
+	var logs bytes.Buffer
+	tok, err := core.LoadTokenizerFromFilesWithOptions(testVocabPath, merges, core.LoadOptions{
+		AllowTruncatedMerges: true,
+		Logger:               log.New(&logs, "", 0),
+	})
+	if err != nil {
+		t.Fatalf("lenient load failed: %v", err)
+	}
+
+	st := tok.Stats()
+	if st.Merges != 999 || st.DroppedMerges != 1 {
+		t.Fatalf("expected 999 merges and 1 dropped line, got %d and %d", st.Merges, st.DroppedMerges)
+	}
+	if !strings.Contains(logs.String(), "truncated") {
+		t.Fatalf("expected a truncation warning, got %q", logs.String())
+	}
+
+	in := []byte("degraded but working, the quick brown fox")
+	if got := tok.Decode(tok.EncodeOffline(in, nil)); !bytes.Equal(got, in) {
+		t.Fatalf("roundtrip mismatch: %q", got)
+	}
+}
+
+func TestLoad_MergeWithUnproducibleConstituent(t *testing.T) {
+	// "Ġt he" needs "Ġt", which only exists because of the first merge "Ġ t"
+	path := filepath.Join(t.TempDir(), "merges.txt")
+	if err := os.WriteFile(path, []byte("#version: 0.2\nh e\nĠt he\n"), 0o644); err != nil {
+		t.Fatalf("write merges: %v", err)
+	}
+
+	_, err := core.LoadTokenizerFromFiles(testVocabPath, path)
+	if !errors.Is(err, core.ErrTruncatedMerges) {
+		t.Fatalf("expected ErrTruncatedMerges, got %v", err)
+	}
+
+	tok, err := core.LoadTokenizerFromFilesWithOptions(testVocabPath, path, core.LoadOptions{
+		AllowTruncatedMerges: true,
+		Logger:               log.New(&bytes.Buffer{}, "", 0),
+	})
+	if err != nil {
+		t.Fatalf("lenient load failed: %v", err)
+	}
+	if st := tok.Stats(); st.Merges != 1 || st.DroppedMerges != 1 {
+		t.Fatalf("expected 1 merge and 1 dropped line, got %+v", st)
+	}
+}
+
+func TestLoad_MaxMergeDepth(t *testing.T) {
+	tok := loadTestTokenizer(t)
+
+	// every merge adds at least one byte, so a tree can't be deeper than its token is long
+	d := tok.MaxMergeDepth()
+	if d < 1 || d >= tok.MaxTokenByteLen {
+		t.Fatalf("implausible merge depth %d for max token length %d", d, tok.MaxTokenByteLen)
+	}
+	if tok.Stats().MaxMergeDepth != d {
+		t.Fatalf("Stats disagrees with MaxMergeDepth")
+	}
+}
@@ -0,0 +1,126 @@
+package offline_encoder
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"testing"
+	"time"
+
+	"github.com/bpetok/internal/tokenizer/core"
+)
+
+// isMapped reports whether path is mapped into the process.
+func isMapped(t *testing.T, path string) bool {
+	t.Helper()
+	maps, err := os.ReadFile("/proc/self/maps")
+	if err != nil {
+		t.Fatalf("read maps: %v", err)
+	}
+	return bytes.Contains(maps, []byte(path))
+}
+
+func writeCompiled(t *testing.T) (string, *core.Tokenizer) {
+	t.Helper()
+	gpt2 := loadTestTokenizer(t)
+	data, err := gpt2.MarshalBinary()
+	if err != nil {
+		t.Fatalf("MarshalBinary: %v", err)
+	}
+	path := filepath.Join(t.TempDir(), "gpt2.bpetok")
+	if err := os.WriteFile(path, data, 0o644); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	return path, gpt2
+}
+
+func TestCompiledMapped_MatchesFiles(t *testing.T) {
+	path, gpt2 := writeCompiled(t)
+	tok, err := core.Load(core.CompiledMapped(path), core.WithSpecialTokens(map[string]int{"<|endoftext|>": 50256}))
+	if err != nil {
+		t.Fatalf("load: %v", err)
+	}
+	if tok.Owned() {
+		t.Fatalf("a mapped tokenizer doesn't own its vocab")
+	}
+	text := []byte("Héllo mapped world, the quick brown fox<|endoftext|>")
+	ids := tok.EncodeOffline(text, nil)
+	if want := gpt2.EncodeOffline(text, nil); !reflect.DeepEqual(ids, want) {
+		t.Fatalf("got %v, want %v", ids, want)
+	}
+	if got := tok.Decode(ids); !bytes.Equal(got, text) {
+		t.Fatalf("decoded %q", got)
+	}
+	if !tok.IsSpecial(50256) || tok.Fingerprint() == gpt2.Fingerprint() {
+		t.Fatalf("load options were not applied")
+	}
+
+	if _, err := core.Load(core.CompiledMapped(filepath.Join(t.TempDir(), "missing"))); err == nil {
+		t.Fatalf("expected an error for a missing file")
+	}
+	bad := filepath.Join(t.TempDir(), "bad")
+	if err := os.WriteFile(bad, []byte("not a compiled tokenizer"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := core.Load(core.CompiledMapped(bad)); err == nil {
+		t.Fatalf("expected an error for a corrupt file")
+	}
+	if runtime.GOOS == "linux" && isMapped(t, bad) {
+		t.Fatalf("a failed load left its mapping behind")
+	}
+}
+
+// TestCompiledMapped_Lifetime checks the mapping outlives every user of the tokenizer and no longer: it
+// stays while a decoder built on the tokenizer is alive, goes once nothing refers to it, and what was
+// handed out before (decoded bytes, a frozen copy) stays valid after.
+func TestCompiledMapped_Lifetime(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("needs /proc/self/maps")
+	}
+	path, gpt2 := writeCompiled(t)
+	text := []byte("Tokens decoded from a mapping must survive it: ünïcödé 東京")
+	want := gpt2.EncodeOffline(text, nil)
+
+	tok, err := core.Load(core.CompiledMapped(path))
+	if err != nil {
+		t.Fatalf("load: %v", err)
+	}
+	if !isMapped(t, path) {
+		t.Fatalf("the file is not mapped")
+	}
+	decoded := tok.Decode(want)
+	frozen := tok.Freeze()
+	dec := core.NewStreamingDecoder(tok)
+	tok = nil
+
+	collect := func() {
+		for i := 0; i < 50 && isMapped(t, path); i++ {
+			runtime.GC()
+			time.Sleep(10 * time.Millisecond)
+		}
+	}
+	collect()
+	if !isMapped(t, path) {
+		t.Fatalf("the mapping was released while a decoder still used it")
+	}
+	if got := dec.Feed(want); !bytes.Equal(got, text) {
+		t.Fatalf("decoder got %q", got)
+	}
+
+	dec = nil
+	collect()
+	if isMapped(t, path) {
+		t.Fatalf("the mapping outlived the tokenizer")
+	}
+	if !bytes.Equal(decoded, text) {
+		t.Fatalf("Decode output changed after the mapping went: %q", decoded)
+	}
+	if got := frozen.EncodeOffline(text, nil); !reflect.DeepEqual(got, want) || !frozen.Owned() {
+		t.Fatalf("frozen copy encodes %v, want %v", got, want)
+	}
+	if got := frozen.Decode(want); !bytes.Equal(got, text) {
+		t.Fatalf("frozen copy decodes %q", got)
+	}
+}
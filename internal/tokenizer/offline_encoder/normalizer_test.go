@@ -0,0 +1,65 @@
+package offline_encoder
+
+import (
+	"math/rand"
+	"testing"
+
+	"github.com/bpetok/internal/tokenizer/core"
+	"golang.org/x/text/unicode/norm"
+)
+
+var normalizerInputs = []string{
+	"plain ascii",
+	"café and résumé",  // decomposed accents
+	"ą́ ȫ multi marks", // stacked combining marks
+	"한국어 각",             // conjoining jamo
+	"ﬁ ligature ① ＡＢＣ",    // compatibility characters
+	"́ leading mark, then é",
+}
+
+func normalizeChunked(n core.Normalization, in []byte, r *rand.Rand) []byte {
+	sn := core.NewStreamNormalizer(n)
+	var out []byte
+	for pos := 0; pos < len(in); {
+		end := min(pos+1+r.Intn(4), len(in))
+		out = append(out, sn.Push(in[pos:end])...)
+		pos = end
+	}
+	return append(out, sn.Flush()...)
+}
+
+func TestStreamNormalizer_MatchesOneShot(t *testing.T) {
+	r := rand.New(rand.NewSource(7))
+	forms := map[core.Normalization]norm.Form{core.NormalizeNFC: norm.NFC, core.NormalizeNFKC: norm.NFKC}
+
+	for n, form := range forms {
+		for _, s := range normalizerInputs {
+			want := form.String(s)
+			for iter := 0; iter < 20; iter++ {
+				if got := normalizeChunked(n, []byte(s), r); string(got) != want {
+					t.Fatalf("%v %q: got %q want %q", n, s, got, want)
+				}
+			}
+		}
+	}
+}
+
+func TestStreamNormalizer_CarriesCombiningMark(t *testing.T) {
+	sn := core.NewStreamNormalizer(core.NormalizeNFC)
+
+	out := string(sn.Push([]byte("cafe")))
+	if out != "caf" || sn.Pending() != 1 {
+		t.Fatalf("expected the trailing starter to be held back, got %q pending=%d", out, sn.Pending())
+	}
+	out += string(sn.Push([]byte("́!")))
+	out += string(sn.Flush())
+	if out != "café!" {
+		t.Fatalf("got %q", out)
+	}
+}
+
+func TestStreamNormalizer_None(t *testing.T) {
+	if core.NewStreamNormalizer(core.NormalizeNone) != nil {
+		t.Fatalf("NormalizeNone should not build a normalizer")
+	}
+}
@@ -0,0 +1,179 @@
+package offline_encoder
+
+import (
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"os"
+	"reflect"
+	"slices"
+	"sync"
+	"testing"
+
+	"github.com/bpetok/internal/tokenizer/core"
+)
+
+// scribble overwrites every buffer with junk, the way a caller reusing its read buffer would.
+func scribble(bufs [][]byte, round byte) {
+	for _, b := range bufs {
+		for i := range b {
+			b[i] = round ^ byte(i)
+		}
+	}
+}
+
+// TestLoad_OwnsItsMemory loads from every in-memory source, then overwrites the source buffers while other
+// goroutines encode. Any slice the tokenizer kept pointing into them shows up as a mismatch, and as a data
+// race under -race.
+func TestLoad_OwnsItsMemory(t *testing.T) {
+	read := func(path string) []byte {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			t.Fatalf("read %s: %v", path, err)
+		}
+		return data
+	}
+	gpt2 := loadTestTokenizer(t)
+	compiled, err := gpt2.MarshalBinary()
+	if err != nil {
+		t.Fatalf("MarshalBinary: %v", err)
+	}
+	var ranks bytes.Buffer
+	for id := range gpt2.VocabSize() {
+		fmt.Fprintf(&ranks, "%s %d\n", base64.StdEncoding.EncodeToString(gpt2.TokenBytes(id)), id)
+	}
+
+	text := []byte("Hello world, the quick brown fox jumps over the lazy dog. ####")
+	cases := []struct {
+		name string
+		bufs [][]byte
+		src  func(bufs [][]byte) core.Source
+		in   []byte
+	}{
+		{"Bytes", [][]byte{read(testVocabPath), read(testMergesPath)}, func(b [][]byte) core.Source { return core.Bytes(b[0], b[1]) }, text},
+		{"TiktokenBytes", [][]byte{ranks.Bytes()}, func(b [][]byte) core.Source { return core.TiktokenBytes(b[0]) }, text},
+		{"TokenizerJSONBytes", [][]byte{gpt2TokenizerJSON(t, false, nil)}, func(b [][]byte) core.Source { return core.TokenizerJSONBytes(b[0]) }, text},
+		{"Compiled", [][]byte{compiled}, func(b [][]byte) core.Source { return core.Compiled(b[0]) }, text},
+		{"SentencePieceBytes", [][]byte{spTestModel(2, false, spTestPieces)}, func(b [][]byte) core.Source { return core.SentencePieceBytes(b[0]) }, []byte(" the café €₭")},
+		{"GGUFBytes", [][]byte{gpt2GGUF(t)}, func(b [][]byte) core.Source { return core.GGUFBytes(b[0]) }, text},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			tok, err := core.Load(tc.src(tc.bufs))
+			if err != nil {
+				t.Fatalf("load: %v", err)
+			}
+			if !tok.Owned() {
+				t.Fatalf("tokenizer should own its memory")
+			}
+			want := tok.EncodeOffline(tc.in, nil)
+
+			var wg sync.WaitGroup
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				for round := range 20 {
+					scribble(tc.bufs, byte(round))
+				}
+			}()
+			errs := make(chan string, 4)
+			for range 4 {
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					for range 20 {
+						got := tok.EncodeOffline(tc.in, nil)
+						if !reflect.DeepEqual(got, want) || !bytes.Equal(tok.Decode(got), tc.in) {
+							errs <- fmt.Sprintf("encoding changed after the source was overwritten: %v", got)
+							return
+						}
+					}
+				}()
+			}
+			wg.Wait()
+			close(errs)
+			for msg := range errs {
+				t.Fatal(msg)
+			}
+		})
+	}
+}
+
+func TestCompiledBorrowed_Freeze(t *testing.T) {
+	data, err := loadTestTokenizer(t).MarshalBinary()
+	if err != nil {
+		t.Fatalf("MarshalBinary: %v", err)
+	}
+	orig := slices.Clone(data)
+
+	tok, err := core.Load(core.CompiledBorrowed(data))
+	if err != nil {
+		t.Fatalf("load: %v", err)
+	}
+	if tok.Owned() {
+		t.Fatalf("a borrowed tokenizer shouldn't report owning its memory")
+	}
+	in := []byte("borrowed, then frozen")
+	want := tok.EncodeOffline(in, nil)
+
+	frozen := tok.Freeze()
+	if !frozen.Owned() || frozen.Freeze() != frozen {
+		t.Fatalf("Freeze should return an owning tokenizer, and freezing that again is a no-op")
+	}
+
+	scribble([][]byte{data}, 0x5a)
+	if got := frozen.EncodeOffline(in, nil); !reflect.DeepEqual(got, want) || !bytes.Equal(frozen.Decode(got), in) {
+		t.Fatalf("frozen tokenizer changed with the borrowed buffer: %v", got)
+	}
+	// the borrowed one really did alias data, which is the point of borrowing
+	if bytes.Equal(tok.Decode(want), in) {
+		t.Fatalf("expected the borrowed tokenizer to see the overwritten buffer")
+	}
+	copy(data, orig)
+}
+
+func TestFreeze_CoversEveryField(t *testing.T) {
+	// Freeze copies a tokenizer field by field, so a new field must land in one of these sets: shared or
+	// copied as is, or deliberately left to start fresh in the frozen tokenizer.
+	copied := map[string]bool{
+		"byteToToken": true, "unicodeByteToToken": true, "pairRank": true, "pairToken": true, "pairInfo": true,
+		"pairLookup": true, "maxMergeDepth": true, "MaxTokenByteLen": true, "maxRank": true, "droppedMerges": true,
+		"capped": true, "algorithmVersion": true, "partial": true, "normalization": true, "addPrefixSpace": true,
+		"preTokenization": true, "digitGroup": true, "specialTokens": true, "specialIDs": true,
+		"specialRoles": true, "UseUnicodeInitTokens": true, "substrAtLoad": true,
+	}
+	fresh := map[string]bool{
+		"vocab": true, "borrowed": true, "scratchPool": true, "internOnce": true, "interned": true,
+		"fingerprintOnce": true, "fingerprint": true, "joinsOnce": true, "joins": true, "specialOnce": true,
+		"specialMatcher": true, "substrOnce": true, "substr": true,
+	}
+
+	data, err := loadTestTokenizer(t).MarshalBinary()
+	if err != nil {
+		t.Fatalf("MarshalBinary: %v", err)
+	}
+	tok, err := core.Load(core.CompiledBorrowed(data),
+		core.WithSpecialTokens(map[string]int{"<|endoftext|>": 50256}), core.WithAddPrefixSpace(true),
+		core.WithPreTokenization(core.PreTokenizeGPT2), core.WithDigitGroup(3))
+	if err != nil {
+		t.Fatalf("load: %v", err)
+	}
+	frozen := tok.Freeze()
+
+	orig, got := reflect.ValueOf(tok).Elem(), reflect.ValueOf(frozen).Elem()
+	for i := range orig.NumField() {
+		name := orig.Type().Field(i).Name
+		switch {
+		case fresh[name]:
+		case !copied[name]:
+			t.Errorf("Freeze doesn't say whether to copy Tokenizer.%s, add it to Freeze and this test", name)
+		case orig.Field(i).Kind() == reflect.Map || orig.Field(i).Kind() == reflect.Pointer:
+			if orig.Field(i).Pointer() != got.Field(i).Pointer() {
+				t.Errorf("Freeze should share Tokenizer.%s", name)
+			}
+		case fmt.Sprint(orig.Field(i)) != fmt.Sprint(got.Field(i)):
+			t.Errorf("Freeze dropped Tokenizer.%s: %v, want %v", name, got.Field(i), orig.Field(i))
+		}
+	}
+}
@@ -0,0 +1,59 @@
+package offline_encoder
+
+import (
+	"maps"
+	"slices"
+	"testing"
+
+	"github.com/bpetok/internal/tokenizer/core"
+)
+
+func TestPairCounts_Serial(t *testing.T) {
+	tok := loadTestTokenizer(t)
+
+	var pc core.PairCounts
+	tok.CountPairs([]byte("the cat and the cat"), &pc)
+	tok.CountPairs([]byte(" cat"), &pc)
+
+	ids := tok.EncodeOffline([]byte("the cat and the cat"), nil)
+	the, cat, spaceThe := ids[0], ids[1], ids[3]
+	if got := pc.Get(the, cat); got != 1 {
+		t.Fatalf("(the, cat) counted %d times", got)
+	}
+	if got := pc.Get(spaceThe, cat); got != 1 {
+		t.Fatalf("( the, cat) counted %d times", got)
+	}
+	// the second document is a single token, so it adds no pair, and nothing spans the two
+	if pc.Docs != 2 || pc.Tokens != int64(len(ids)+1) || pc.Len() != len(ids)-1 {
+		t.Fatalf("docs %d, tokens %d, pairs %d", pc.Docs, pc.Tokens, pc.Len())
+	}
+	if top := pc.Top(1); len(top) != 1 || top[0].Count != 1 {
+		t.Fatalf("top %v", top)
+	}
+}
+
+func TestPairCounts_ParallelMatchesSerial(t *testing.T) {
+	tok := loadTestTokenizer(t)
+	gen := tok.NewTextGenerator(9, nil)
+
+	docs := make([][]byte, 200)
+	for i := range docs {
+		docs[i] = gen.Generate(100 + i)
+	}
+
+	var serial core.PairCounts
+	for _, doc := range docs {
+		tok.CountPairs(doc, &serial)
+	}
+	parallel := tok.CountPairsParallel(slices.Values(docs), 4)
+
+	if parallel.Docs != serial.Docs || parallel.Tokens != serial.Tokens {
+		t.Fatalf("parallel counted %d docs / %d tokens, serial %d / %d", parallel.Docs, parallel.Tokens, serial.Docs, serial.Tokens)
+	}
+	if !maps.Equal(maps.Collect(parallel.All()), maps.Collect(serial.All())) {
+		t.Fatalf("parallel and serial pair counts differ")
+	}
+	if !slices.Equal(parallel.Top(20), serial.Top(20)) {
+		t.Fatalf("Top isn't deterministic")
+	}
+}
@@ -0,0 +1,60 @@
+package offline_encoder
+
+import (
+	"testing"
+
+	"github.com/bpetok/internal/tokenizer/core"
+)
+
+func TestPairLookup_StatsReportsWindow(t *testing.T) {
+	tok := loadTestTokenizer(t)
+
+	st := tok.Stats()
+	cfg := st.PairLookup
+	if cfg.Size <= 0 || cfg.Size > 2048 {
+		t.Fatalf("unexpected fast table size %d", cfg.Size)
+	}
+	if cfg.Base < 0 || cfg.Base >= st.VocabSize {
+		t.Fatalf("unexpected fast table base %d", cfg.Base)
+	}
+	if cfg.FastPairs+cfg.FallbackPairs != st.Merges {
+		t.Fatalf("pair split %d+%d does not add up to %d merges", cfg.FastPairs, cfg.FallbackPairs, st.Merges)
+	}
+	if cfg.SampleCoverage <= 0 || cfg.SampleCoverage > 1 {
+		t.Fatalf("coverage out of range: %v", cfg.SampleCoverage)
+	}
+}
+
+func TestPairLookup_HighIDWindow(t *testing.T) {
+	// every pair lives in [40000, 40300), a fixed [0,2048) table would miss all of them
+	const vocabSize = 50000
+	info := make(map[uint64]uint64)
+	rank := uint64(0)
+	for a := 40000; a < 40300; a += 3 {
+		b := a + 1
+		info[(uint64(a)<<32)|uint64(b)] = (rank << 32) | uint64(a+2)
+		rank++
+	}
+	// a handful of rare pairs elsewhere
+	info[(uint64(5)<<32)|uint64(7)] = (rank << 32) | 9
+
+	pl := core.NewPairLookup(info, vocabSize)
+	cfg := pl.Config()
+	if cfg.Base > 40000 || cfg.Base+cfg.Size < 40300 {
+		t.Fatalf("window [%d,%d) does not cover the dense region", cfg.Base, cfg.Base+cfg.Size)
+	}
+
+	for key, want := range info {
+		a, b := int(key>>32), int(key&0xFFFFFFFF)
+		got, ok := pl.Lookup(a, b)
+		if !ok || got != want {
+			t.Fatalf("lookup(%d,%d) = %d,%v want %d", a, b, got, ok, want)
+		}
+	}
+	if _, ok := pl.Lookup(40001, 40000); ok {
+		t.Fatalf("unexpected hit for reversed pair")
+	}
+	if _, ok := pl.Lookup(-1, 3); ok {
+		t.Fatalf("unexpected hit for negative id")
+	}
+}
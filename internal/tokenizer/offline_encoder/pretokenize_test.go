@@ -0,0 +1,278 @@
+package offline_encoder
+
+import (
+	"math/rand"
+	"os"
+	"reflect"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/bpetok/internal/tokenizer/core"
+)
+
+func TestPreTokenize_GPT2Splits(t *testing.T) {
+	tok := loadTestTokenizer(t)
+
+	cases := []struct {
+		in   string
+		want []string
+	}{
+		{"Hello, world!", []string{"Hello", ",", " world", "!"}},
+		{"I'm  here", []string{"I", "'m", " ", " here"}},
+		{"we'll see they're 'quoted'", []string{"we", "'ll", " see", " they", "'re", " '", "quoted", "'"}},
+		{"DON'T", []string{"DON", "'", "T"}},
+		{"   leading", []string{"  ", " leading"}},
+		{"trailing   ", []string{"trailing", "   "}},
+		{"a\n\nb", []string{"a", "\n", "\n", "b"}},
+		{"x2024y 42", []string{"x", "2024", "y", " 42"}},
+		{"café 東京 ½", []string{"café", " 東京", " ½"}},
+		{"f(x) => x**2;\n\tdone", []string{"f", "(", "x", ")", " =>", " x", "**", "2", ";", "\n", "\t", "done"}},
+		{"bad \xff\xfe bytes", []string{"bad", " \xff\xfe", " bytes"}},
+		{" ", []string{" "}},
+	}
+	for _, c := range cases {
+		var got []string
+		for _, s := range tok.PreTokenize([]byte(c.in)) {
+			got = append(got, c.in[s.Start:s.End])
+		}
+		if !reflect.DeepEqual(got, c.want) {
+			t.Errorf("%q:\ngot  %q\nwant %q", c.in, got, c.want)
+		}
+	}
+}
+
+func TestPreTokenize_TiktokenSplits(t *testing.T) {
+	cases := []struct {
+		p    core.PreTokenization
+		in   string
+		want []string
+	}{
+		{core.PreTokenizeCL100K, "Hello world", []string{"Hello", " world"}},
+		{core.PreTokenizeCL100K, "I'M here, don't", []string{"I", "'M", " here", ",", " don", "'t"}},
+		{core.PreTokenizeCL100K, "12345", []string{"123", "45"}},
+		{core.PreTokenizeCL100K, "$100 (hello) x", []string{"$", "100", " (", "hello", ")", " x"}},
+		{core.PreTokenizeCL100K, "foo!!\n\nbar", []string{"foo", "!!\n\n", "bar"}},
+		{core.PreTokenizeCL100K, "a  \n b\r\nc", []string{"a", "  \n", " b", "\r\n", "c"}},
+		{core.PreTokenizeCL100K, "  hello (world", []string{" ", " hello", " (", "world"}},
+		{core.PreTokenizeCL100K, "Über 東京", []string{"Über", " 東京"}},
+		{core.PreTokenizeO200K, "HelloWorld abcDEF ABCdef", []string{"Hello", "World", " abc", "DEF", " ABCdef"}},
+		{core.PreTokenizeO200K, "they're THEY'RE I'm", []string{"they're", " THEY'RE", " I'm"}},
+		{core.PreTokenizeO200K, "1234", []string{"123", "4"}},
+		{core.PreTokenizeO200K, "a/b x!/\ny", []string{"a", "/b", " x", "!/\n", "y"}},
+		{core.PreTokenizeO200K, "東京abc 東AB1", []string{"東京abc", " 東", "AB", "1"}},
+		{core.PreTokenizeO200K, "  hello $100", []string{" ", " hello", " $", "100"}},
+	}
+	for _, c := range cases {
+		var got []string
+		for _, s := range c.p.Split([]byte(c.in)) {
+			got = append(got, c.in[s.Start:s.End])
+		}
+		if !reflect.DeepEqual(got, c.want) {
+			t.Errorf("%v %q:\ngot  %q\nwant %q", c.p, c.in, got, c.want)
+		}
+	}
+}
+
+func TestPreTokenize_DigitGroup(t *testing.T) {
+	cases := []struct {
+		p     core.PreTokenization
+		group int
+		in    string
+		want  []string
+	}{
+		{core.PreTokenizeGPT2, 3, "x 1234567 42", []string{"x", " 123", "456", "7", " 42"}},
+		{core.PreTokenizeGPT2, 1, "a2024 ½3", []string{"a", "2", "0", "2", "4", " ½", "3"}},
+		{core.PreTokenizeCL100K, 1, "$100 v2", []string{"$", "1", "0", "0", " v", "2"}},
+		{core.PreTokenizeCL100K, 2, "123456 1", []string{"12", "34", "56", " ", "1"}},
+		{core.PreTokenizeCL100K, 4, "12345", []string{"123", "45"}},
+		{core.PreTokenizeO200K, 1, "AB12", []string{"AB", "1", "2"}},
+	}
+	plain := loadTestTokenizer(t)
+	data, err := plain.MarshalBinary()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, c := range cases {
+		tok, err := core.Load(core.Compiled(data), core.WithPreTokenization(c.p), core.WithDigitGroup(c.group))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var got []string
+		var want []int
+		for _, s := range tok.PreTokenize([]byte(c.in)) {
+			got = append(got, c.in[s.Start:s.End])
+			want = append(want, plain.EncodeOffline([]byte(c.in[s.Start:s.End]), nil)...)
+		}
+		if !reflect.DeepEqual(got, c.want) {
+			t.Errorf("%v group %d %q:\ngot  %q\nwant %q", c.p, c.group, c.in, got, c.want)
+		}
+		// encoding merges within the same splits
+		if ids := tok.EncodeOffline([]byte(c.in), nil); !slices.Equal(ids, want) {
+			t.Errorf("%v group %d %q: encoded %v, splits encode to %v", c.p, c.group, c.in, ids, want)
+		}
+	}
+
+	for _, opts := range [][]core.Option{
+		{core.WithDigitGroup(1)},
+		{core.WithPreTokenization(core.PreTokenizeGPT2), core.WithDigitGroup(-1)},
+		{core.WithPreTokenization(core.PreTokenizeGPT2), core.WithDigitGroup(256)},
+	} {
+		if _, err := core.Load(core.Compiled(data), opts...); err == nil {
+			t.Errorf("load with options %d should fail", len(opts))
+		}
+	}
+}
+
+func TestPreTokenize_CoversInput(t *testing.T) {
+	tok := loadTestTokenizer(t)
+	r := rand.New(rand.NewSource(3))
+	alphabet := []string{"a", "Z", "é", "東", "7", "½", " ", "  ", "\n", "\t", "'", "'s", "!", "\xff", "😀", "\u00a0"}
+
+	for range 500 {
+		var in []byte
+		for range r.Intn(30) {
+			in = append(in, alphabet[r.Intn(len(alphabet))]...)
+		}
+		pos := 0
+		for _, s := range tok.PreTokenize(in) {
+			if s.Start != pos || s.End <= s.Start {
+				t.Fatalf("%q: span %v after offset %d", in, s, pos)
+			}
+			pos = s.End
+		}
+		if pos != len(in) {
+			t.Fatalf("%q: spans stop at %d", in, pos)
+		}
+	}
+}
+
+func loadPreTokenized(t *testing.T) *core.Tokenizer {
+	t.Helper()
+	tok, err := core.Load(core.Files(testVocabPath, testMergesPath), core.WithPreTokenization(core.PreTokenizeGPT2))
+	if err != nil {
+		t.Fatalf("load: %v", err)
+	}
+	return tok
+}
+
+// TestPreTokenization_MatchesReference checks the pre-tokenized encoding against GPT-2's reference
+// procedure, the one tiktoken's gpt2 encoding and Hugging Face implement: split with the regex, then merge
+// each piece on its own.
+func TestPreTokenization_MatchesReference(t *testing.T) {
+	tok, raw := loadPreTokenized(t), loadTestTokenizer(t)
+	_, ranks := gpt2AsTiktoken(t)
+
+	if got, want := tok.EncodeOffline([]byte("The quick brown fox jumps over the lazy dog"), nil),
+		[]int{464, 2068, 7586, 21831, 18045, 625, 262, 16931, 3290}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("got %v, want %v", got, want)
+	}
+
+	corpus, err := os.ReadFile("../testdata/gpt2/bench_corpus.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	inputs := strings.SplitAfterN(string(corpus), "\n", 500)
+	inputs = append(inputs[:len(inputs)-1], "a\u00a0\u00a0b", "we'll see they're 'quoted'", "x2024y  42!!\n\n\tdone", "naïve café 😀 東京", "bad \xff\xfe bytes")
+
+	crossing := 0
+	for _, in := range inputs {
+		var want []int
+		for _, s := range tok.PreTokenize([]byte(in)) {
+			want = append(want, tiktokenReference(ranks, []byte(in[s.Start:s.End]))...)
+		}
+		if got := tok.EncodeOffline([]byte(in), nil); !reflect.DeepEqual(got, want) {
+			t.Fatalf("%q:\ngot  %v\nwant %v", in, got, want)
+		}
+		if got := tok.CountTokens([]byte(in)); got != len(want) {
+			t.Fatalf("%q: CountTokens %d, want %d", in, got, len(want))
+		}
+		if !reflect.DeepEqual(raw.EncodeOffline([]byte(in), nil), want) {
+			crossing++
+		}
+	}
+	// the point of the split: raw merging crosses word boundaries somewhere in a corpus this size
+	if crossing == 0 {
+		t.Fatalf("raw and pre-tokenized encodings agree on every input")
+	}
+}
+
+// TestPreTokenization_FinalLen checks that the prefix FinalLen reports is made of splits that stay put
+// whatever follows, and that it does let ordinary text through.
+func TestPreTokenization_FinalLen(t *testing.T) {
+	r := rand.New(rand.NewSource(9))
+	alphabet := []string{"a", "re", "ll", "s", "t", "RE", "A", "7", "123", " ", "  ", "\n", "\r\n", "'", "!", "/",
+		"東", "\u0301", "\xe6", "\xff", "\u00a0"}
+
+	for _, p := range []core.PreTokenization{core.PreTokenizeGPT2, core.PreTokenizeCL100K, core.PreTokenizeO200K} {
+		if n := p.FinalLen([]byte("Hello world, how are")); n != len("Hello world, how") {
+			t.Errorf("%v: FinalLen %d", p, n)
+		}
+		for range 2000 {
+			var in []byte
+			for range r.Intn(20) {
+				in = append(in, alphabet[r.Intn(len(alphabet))]...)
+			}
+			full := p.Split(in)
+			for i := 0; i <= len(in); i++ {
+				n := p.FinalLen(in[:i])
+				prefix := p.Split(in[:n])
+				if len(prefix) > len(full) || !slices.Equal(prefix, full[:len(prefix)]) {
+					t.Fatalf("%v: %q cut at %d: FinalLen %d gives splits %v, the whole input %v", p, in, i, n, prefix, full)
+				}
+			}
+		}
+	}
+}
+
+func TestSplitBuffer(t *testing.T) {
+	if core.NewSplitBuffer(core.PreTokenizeNone) != nil {
+		t.Fatalf("NewSplitBuffer(PreTokenizeNone) should be nil")
+	}
+
+	r := rand.New(rand.NewSource(10))
+	alphabet := []string{"a", "re", "ll", "s", "RE", "7", "123", " ", "  ", "\n", "\r\n", "'", "!", "東", "́",
+		"\xe6", "\xff"}
+	for _, p := range []core.PreTokenization{core.PreTokenizeGPT2, core.PreTokenizeCL100K, core.PreTokenizeO200K} {
+		sb := core.NewSplitBuffer(p)
+		for range 500 {
+			var in []byte
+			for range r.Intn(30) {
+				in = append(in, alphabet[r.Intn(len(alphabet))]...)
+			}
+
+			// every push releases exactly what FinalLen allows of all the input held so far
+			var out, held []byte
+			for pos := 0; pos < len(in); {
+				end := min(pos+1+r.Intn(6), len(in))
+				held = append(held, in[pos:end]...)
+				final := sb.Push(in[pos:end])
+				if n := p.FinalLen(held); !slices.Equal(final, held[:n]) {
+					t.Fatalf("%v: %q at %d: Push released %q, FinalLen %d", p, in, end, final, n)
+				}
+				out = append(out, final...)
+				held = held[len(final):]
+				if !slices.Equal(sb.Pending(), held) || sb.Len() != len(held) {
+					t.Fatalf("%v: %q at %d: Pending %q, want %q", p, in, end, sb.Pending(), held)
+				}
+				pos = end
+			}
+			if got := append(out, sb.Pending()...); !slices.Equal(got, in) {
+				t.Fatalf("%v: released and pending %q, want %q", p, got, in)
+			}
+			sb.Reset()
+		}
+	}
+
+	// a pre-token arriving a byte at a time is scanned once, not once per push
+	sb := core.NewSplitBuffer(core.PreTokenizeGPT2)
+	for i := range 1 << 20 {
+		if final := sb.Push([]byte{"ab"[i%2]}); len(final) != 0 {
+			t.Fatalf("a single word was released at %d", i)
+		}
+	}
+	if final := sb.Push([]byte(" x")); len(final) != 1<<20 {
+		t.Fatalf("the word should be released once it ends, got %d bytes", len(final))
+	}
+}
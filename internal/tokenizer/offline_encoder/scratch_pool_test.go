@@ -0,0 +1,101 @@
+package offline_encoder
+
+import (
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/bpetok/internal/tokenizer/core"
+)
+
+func loadWithScratchLimits(t *testing.T, l core.ScratchPoolLimits) *core.Tokenizer {
+	t.Helper()
+	tok, err := core.Load(core.Files(testVocabPath, testMergesPath), core.WithScratchPoolLimits(l))
+	if err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+	return tok
+}
+
+func TestScratchPool_DropsOversizedScratch(t *testing.T) {
+	tok := loadWithScratchLimits(t, core.ScratchPoolLimits{MaxItemLen: 1 << 10, MaxItems: 4})
+
+	tok.CountTokens([]byte(strings.Repeat("big input ", 10_000)))
+
+	st := tok.ScratchPoolStats()
+	if st.RetainedLen > 1<<10 {
+		t.Fatalf("pool retained scratch for %d bytes of input, limit is %d", st.RetainedLen, 1<<10)
+	}
+
+	small := []byte("small input")
+	tok.CountTokens(small)
+	if st := tok.ScratchPoolStats(); st.Items == 0 || st.RetainedLen < len(small) {
+		t.Fatalf("small scratch should be kept, got %+v", st)
+	}
+}
+
+func TestScratchPool_DefaultLimits(t *testing.T) {
+	tok := loadTestTokenizer(t)
+
+	tok.CountTokens([]byte(strings.Repeat("big input ", 100_000)))
+	if st := tok.ScratchPoolStats(); st.RetainedLen > 64<<10 {
+		t.Fatalf("default pool retained scratch for %d bytes of input", st.RetainedLen)
+	}
+}
+
+func TestScratchPool_MaxItems(t *testing.T) {
+	tok := loadWithScratchLimits(t, core.ScratchPoolLimits{MaxItemLen: 1 << 20, MaxItems: 2})
+
+	in := []byte("concurrent encodes each take their own scratch")
+	want := tok.CountTokens(in)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 16; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if got := tok.CountTokens(in); got != want {
+				t.Errorf("got %d tokens want %d", got, want)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if st := tok.ScratchPoolStats(); st.Items > 2 {
+		t.Fatalf("pool holds %d items, limit is 2", st.Items)
+	}
+
+	none := loadWithScratchLimits(t, core.ScratchPoolLimits{MaxItemLen: 1 << 20, MaxItems: 0})
+	none.CountTokens(in)
+	none.CountTokens(in)
+	if st := none.ScratchPoolStats(); st.Items != 0 {
+		t.Fatalf("MaxItems 0 must keep nothing, got %d items", st.Items)
+	}
+}
+
+func TestScratchPool_GlobalLimits(t *testing.T) {
+	old := core.GetScratchPoolLimits()
+	t.Cleanup(func() { core.SetScratchPoolLimits(old) })
+
+	tok := loadTestTokenizer(t)
+	own := loadWithScratchLimits(t, core.ScratchPoolLimits{MaxItemLen: 1 << 20, MaxItems: 4})
+	core.SetScratchPoolLimits(core.ScratchPoolLimits{MaxItemLen: 1 << 10, MaxItems: 1})
+
+	// a change applies to tokenizers already loaded, concurrent encodes included
+	big := []byte(strings.Repeat("big input ", 10_000))
+	var wg sync.WaitGroup
+	for range 8 {
+		wg.Go(func() {
+			tok.CountTokens(big)
+			own.CountTokens(big)
+		})
+	}
+	wg.Wait()
+	if st := tok.ScratchPoolStats(); st.Items > 1 || st.RetainedLen > 1<<10 {
+		t.Fatalf("global limits ignored: %+v", st)
+	}
+	// WithScratchPoolLimits wins over them
+	if st := own.ScratchPoolStats(); st.RetainedLen <= 1<<10 {
+		t.Fatalf("per-load limits should override the global ones: %+v", st)
+	}
+}
@@ -0,0 +1,136 @@
+package offline_encoder
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/bpetok/internal/tokenizer/core"
+)
+
+type spTestPiece struct {
+	text  string
+	score float32
+	typ   int
+}
+
+func protoField(buf []byte, field int, wire uint64) []byte {
+	return binary.AppendUvarint(buf, uint64(field)<<3|wire)
+}
+
+func protoBytes(buf []byte, field int, b []byte) []byte {
+	buf = protoField(buf, field, 2)
+	buf = binary.AppendUvarint(buf, uint64(len(b)))
+	return append(buf, b...)
+}
+
+func protoVarint(buf []byte, field int, v uint64) []byte {
+	return binary.AppendUvarint(protoField(buf, field, 0), v)
+}
+
+// spTestModel serializes a ModelProto: the usual <unk>, <s>, </s> and 256 byte pieces, then pieces.
+func spTestModel(modelType int, addDummyPrefix bool, pieces []spTestPiece) []byte {
+	all := []spTestPiece{{"<unk>", 0, 2}, {"<s>", 0, 3}, {"</s>", 0, 3}}
+	for b := range 256 {
+		all = append(all, spTestPiece{fmt.Sprintf("<0x%02X>", b), 0, 6})
+	}
+	all = append(all, pieces...)
+
+	var out []byte
+	for _, p := range all {
+		var msg []byte
+		msg = protoBytes(msg, 1, []byte(p.text))
+		msg = protoField(msg, 2, 5)
+		msg = binary.LittleEndian.AppendUint32(msg, math.Float32bits(p.score))
+		msg = protoVarint(msg, 3, uint64(p.typ))
+		out = protoBytes(out, 1, msg)
+	}
+
+	trainer := protoVarint(nil, 3, uint64(modelType))
+	trainer = protoVarint(trainer, 35, 1) // byte_fallback, skipped as an unknown field
+	out = protoBytes(out, 2, trainer)
+
+	normalizer := protoBytes(nil, 1, []byte("identity"))
+	dummy := uint64(0)
+	if addDummyPrefix {
+		dummy = 1
+	}
+	normalizer = protoVarint(normalizer, 3, dummy)
+	normalizer = protoVarint(normalizer, 4, 0)
+	return protoBytes(out, 3, normalizer)
+}
+
+// spTestPieces start at ID 259. "€" and "₭" share their first two bytes, only "€" is a piece.
+var spTestPieces = []spTestPiece{
+	{"▁t", -1, 1},   // 259
+	{"he", -2, 1},   // 260
+	{"▁the", -3, 1}, // 261
+	{"ca", -4, 1},   // 262
+	{"caf", -5, 1},  // 263
+	{"café", -6, 1}, // 264
+	{"▁", -7, 1},    // 265
+	{"t", -8, 1},    // 266
+	{"h", -9, 1},    // 267
+	{"e", -10, 1},   // 268
+	{"a", -11, 1},   // 269
+	{"c", -12, 1},   // 270
+	{"f", -13, 1},   // 271
+	{"é", -14, 1},   // 272
+	{"€", -15, 1},   // 273
+}
+
+func TestSentencePiece_Encode(t *testing.T) {
+	tok, err := core.Load(core.SentencePieceBytes(spTestModel(2, false, spTestPieces)))
+	if err != nil {
+		t.Fatalf("load: %v", err)
+	}
+
+	in := []byte(" the café €₭\n")
+	// "₭" never completes its prefix, so it falls back to <0xE2> <0x82> <0xAD>, and "\n" has only its byte
+	want := []int{261, 265, 264, 265, 273, 3 + 0xE2, 3 + 0x82, 3 + 0xAD, 3 + '\n'}
+	got := tok.EncodeOffline(in, nil)
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("got %v, want %v", got, want)
+	}
+	if n := tok.CountTokens(in); n != len(want) {
+		t.Fatalf("CountTokens %d, want %d", n, len(want))
+	}
+	if out := tok.Decode(got); !bytes.Equal(out, in) {
+		t.Fatalf("round trip gave %q", out)
+	}
+
+	if sp := tok.SpecialTokens(); !reflect.DeepEqual(sp, map[string]int{"<unk>": 0, "<s>": 1, "</s>": 2}) {
+		t.Fatalf("special tokens %v", sp)
+	}
+	if _, err := tok.MarshalBinary(); !errors.Is(err, core.ErrCompiledFormat) {
+		t.Fatalf("expected MarshalBinary to refuse, got %v", err)
+	}
+}
+
+func TestSentencePiece_Unsupported(t *testing.T) {
+	_, err := core.Load(core.SentencePieceBytes(spTestModel(1, false, spTestPieces)))
+	if !errors.Is(err, core.ErrUnsupportedSentencePiece) {
+		t.Fatalf("unigram model: expected ErrUnsupportedSentencePiece, got %v", err)
+	}
+
+	if _, err := core.Load(core.SentencePieceBytes([]byte{0x0a, 0x05, 0x01})); err == nil {
+		t.Fatalf("expected an error for a truncated model")
+	}
+}
+
+func TestSentencePiece_WarnsAboutDummyPrefix(t *testing.T) {
+	var logs bytes.Buffer
+	_, err := core.Load(core.SentencePieceBytes(spTestModel(2, true, spTestPieces)), core.WithLogger(log.New(&logs, "", 0)))
+	if err != nil {
+		t.Fatalf("load: %v", err)
+	}
+	if !strings.Contains(logs.String(), "add_dummy_prefix") {
+		t.Fatalf("expected a warning, got %q", logs.String())
+	}
+}
@@ -0,0 +1,195 @@
+package offline_encoder
+
+import (
+	"errors"
+	"maps"
+	"math/rand/v2"
+	"reflect"
+	"slices"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/bpetok/internal/tokenizer/core"
+)
+
+func TestEncodeWithSpecial(t *testing.T) {
+	base := loadTestTokenizer(t)
+	n := base.VocabSize()
+	tok, err := core.Load(core.Files(testVocabPath, testMergesPath), core.WithSpecialTokens(map[string]int{
+		"<|endoftext|>": 50256,
+		"<|fim|>":       n,
+		"<|fim|>x":      n + 1,
+	}))
+	if err != nil {
+		t.Fatalf("load: %v", err)
+	}
+	enc := func(s string) []int { return tok.EncodeOffline([]byte(s), nil) }
+
+	cases := []struct {
+		in   string
+		want []int
+	}{
+		{"", []int{}},
+		{"plain text", enc("plain text")},
+		{"<|endoftext|>", []int{50256}},
+		{"Hi<|endoftext|>world <|fim|><|fim|>", slices.Concat(enc("Hi"), []int{50256}, enc("world "), []int{n, n})},
+		// the longest special token wins where two start together
+		{"a<|fim|>xy<|fim|>", slices.Concat(enc("a"), []int{n + 1}, enc("y"), []int{n})},
+		{"<|endoftext", enc("<|endoftext")},
+	}
+	all := core.SpecialPolicy{Allowed: []string{core.AllSpecial}}
+	for _, c := range cases {
+		if got, err := tok.EncodeWithSpecial([]byte(c.in), all); err != nil || !reflect.DeepEqual(got, c.want) {
+			t.Errorf("%q: got %v, %v, want %v", c.in, got, err, c.want)
+		}
+	}
+
+	// without special tokens registered it is EncodeOffline
+	in := []byte("Hello<|endoftext|>world")
+	got, err := base.EncodeWithSpecial(in, core.SpecialPolicy{})
+	if err != nil || !reflect.DeepEqual(got, base.EncodeOffline(in, nil)) {
+		t.Fatalf("no specials: got %v, %v", got, err)
+	}
+	if slices.Contains(tok.EncodeOffline(in, nil), 50256) {
+		t.Fatalf("EncodeOffline should merge the special token's text like any other")
+	}
+}
+
+func TestEncodeWithSpecial_Policy(t *testing.T) {
+	n := loadTestTokenizer(t).VocabSize()
+	tok, err := core.Load(core.Files(testVocabPath, testMergesPath), core.WithSpecialTokens(map[string]int{
+		"<|endoftext|>": 50256,
+		"<|fim|>":       n,
+	}))
+	if err != nil {
+		t.Fatalf("load: %v", err)
+	}
+	enc := func(s string) []int { return tok.EncodeOffline([]byte(s), nil) }
+	in := "a<|fim|>b<|endoftext|>"
+	both := slices.Concat(enc("a"), []int{n}, enc("b"), []int{50256})
+
+	cases := []struct {
+		name   string
+		policy core.SpecialPolicy
+		want   []int // nil for ErrDisallowedSpecial
+	}{
+		{"default", core.SpecialPolicy{}, nil},
+		{"allow all", core.SpecialPolicy{Allowed: []string{core.AllSpecial}}, both},
+		{"allow one, disallow the rest", core.SpecialPolicy{Allowed: []string{"<|fim|>"}}, nil},
+		{"allow one, the rest as text", core.SpecialPolicy{Allowed: []string{"<|fim|>"}, Disallowed: []string{}},
+			slices.Concat(enc("a"), []int{n}, enc("b<|endoftext|>"))},
+		{"all as text", core.SpecialPolicy{Disallowed: []string{}}, enc(in)},
+		{"disallow one", core.SpecialPolicy{Disallowed: []string{"<|endoftext|>"}}, nil},
+		{"disallow another", core.SpecialPolicy{Disallowed: []string{"<|im_start|>"}}, enc(in)},
+		{"allowed wins", core.SpecialPolicy{Allowed: []string{"<|fim|>", "<|endoftext|>"},
+			Disallowed: []string{core.AllSpecial}}, both},
+	}
+	for _, c := range cases {
+		got, err := tok.EncodeWithSpecial([]byte(in), c.policy)
+		if c.want == nil {
+			if !errors.Is(err, core.ErrDisallowedSpecial) {
+				t.Errorf("%s: expected ErrDisallowedSpecial, got %v, %v", c.name, got, err)
+			}
+			continue
+		}
+		if err != nil || !reflect.DeepEqual(got, c.want) {
+			t.Errorf("%s: got %v, %v, want %v", c.name, got, err, c.want)
+		}
+	}
+
+	// the error names the token and where it is, for the server to report back
+	if _, err := tok.EncodeWithSpecial([]byte("hi <|endoftext|>"), core.SpecialPolicy{}); err == nil ||
+		err.Error() != `disallowed special token in input: "<|endoftext|>" at byte 3` {
+		t.Fatalf("error: %v", err)
+	}
+}
+
+func TestSpecialMatcher(t *testing.T) {
+	n := loadTestTokenizer(t).VocabSize()
+	// overlapping texts over a small alphabet, so random input is dense with partial and nested matches
+	special := map[string]int{}
+	for i, s := range []string{"<|im_start|>", "<|im_end|>", "<|im|>", "ab", "abab", "bab", "cb", "aab", "<|"} {
+		special[s] = n + i
+	}
+	for i := range 200 {
+		special["<|reserved_"+strconv.Itoa(i)+"|>"] = n + len(special)
+	}
+	tok, err := core.Load(core.Files(testVocabPath, testMergesPath), core.WithSpecialTokens(special))
+	if err != nil {
+		t.Fatalf("load: %v", err)
+	}
+
+	// naive returns the earliest occurrence at or after from, the longest of those
+	naive := func(in string, from int, names []string) core.SpecialMatch {
+		best := core.SpecialMatch{Start: len(in)}
+		for _, s := range names {
+			i := strings.Index(in[from:], s)
+			if i < 0 {
+				continue
+			}
+			if i += from; i < best.Start || i == best.Start && len(s) > best.Len {
+				best = core.SpecialMatch{Start: i, Len: len(s), ID: special[s]}
+			}
+		}
+		if best.Len == 0 {
+			return core.SpecialMatch{}
+		}
+		return best
+	}
+
+	subset := []string{"ab", "bab", "<|im_end|>", "<|reserved_7|>"}
+	all := slices.Collect(maps.Keys(special))
+	// tails are what a stream might go on with: every suffix of the short texts
+	var tails []string
+	for _, s := range append(subset, "<|im_start|>", "abab", "aab", "<|im|>") {
+		for i := range s {
+			tails = append(tails, s[i:])
+		}
+	}
+	rng := rand.New(rand.NewPCG(1, 2))
+	alphabet := []string{"a", "b", "c", "<|", "|>", "im_", "start", "end", "reserved_", "1", "7", "<|im_end|>"}
+	for range 300 {
+		var sb strings.Builder
+		for range rng.IntN(20) {
+			sb.WriteString(alphabet[rng.IntN(len(alphabet))])
+		}
+		in := sb.String()
+		for _, c := range []struct {
+			m     *core.SpecialMatcher
+			names []string
+		}{{tok.SpecialMatcher(core.AllSpecial), all}, {tok.SpecialMatcher(subset...), subset}} {
+			for from := 0; from <= len(in); from++ {
+				want := naive(in, from, c.names)
+				if got, _ := c.m.Find([]byte(in), from, false); got != want {
+					t.Fatalf("Find(%q, %d): got %+v, want %+v", in, from, got, want)
+				}
+
+				// in a stream, a match is only reported once it is certain, and no continuation can make
+				// one start before held
+				got, held := c.m.Find([]byte(in), from, true)
+				if got.Len > 0 && got != want {
+					t.Fatalf("Find(%q, %d, more): got %+v, want %+v", in, from, got, want)
+				}
+				if got.Len == 0 && want.Len > 0 && want.Start < held {
+					t.Fatalf("Find(%q, %d, more): held %d past the match at %d", in, from, held, want.Start)
+				}
+				if got.Len > 0 {
+					continue
+				}
+				for _, s := range tails {
+					if m := naive(in+s, from, c.names); m.Len > 0 && m.Start < held {
+						t.Fatalf("Find(%q, %d, more): held %d, but %q would match at %d", in, from, held, s, m.Start)
+					}
+				}
+			}
+		}
+	}
+
+	if m := tok.SpecialMatcher("<|unknown|>"); m != nil {
+		t.Fatalf("SpecialMatcher of unknown names: got %v, want nil", m)
+	}
+	if tok.SpecialMatcher(core.AllSpecial) != tok.SpecialMatcher(core.AllSpecial) {
+		t.Fatalf("the matcher over all special tokens should be built once")
+	}
+}
@@ -0,0 +1,46 @@
+package offline_encoder
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+
+	"github.com/bpetok/internal/tokenizer/core"
+)
+
+func TestTokensContaining_MatchesScan(t *testing.T) {
+	lazy := loadTestTokenizer(t)
+	eager, err := core.Load(core.Files(testVocabPath, testMergesPath), core.WithSubstringIndex(true))
+	if err != nil {
+		t.Fatalf("load: %v", err)
+	}
+
+	for _, sub := range []string{"the", "ing", " the", "fox", "\n", "\xe4", "ä½", "zzzzqx", "<|endoftext|>", "a"} {
+		var want []int
+		for id, bs := range lazy.Vocab().All() {
+			if bytes.Contains(bs, []byte(sub)) {
+				want = append(want, id)
+			}
+		}
+		for name, tok := range map[string]*core.Tokenizer{"lazy": lazy, "eager": eager} {
+			if got := tok.TokensContaining([]byte(sub)); !reflect.DeepEqual(got, want) {
+				t.Fatalf("%s %q: got %d ids, want %d", name, sub, len(got), len(want))
+			}
+		}
+	}
+
+	if got := lazy.TokensContaining(nil); got != nil {
+		t.Fatalf("empty pattern matched %v", got)
+	}
+}
+
+func TestTokensContaining_VocabHoles(t *testing.T) {
+	vocab, merges := holeyGPT2Vocab(t)
+	tok, err := core.Load(core.Bytes(vocab, merges), core.WithVocabHoles(true))
+	if err != nil {
+		t.Fatalf("load: %v", err)
+	}
+	if got := tok.TokensContaining([]byte("endoftext")); !reflect.DeepEqual(got, []int{50300}) {
+		t.Fatalf("got %v", got)
+	}
+}
@@ -0,0 +1,133 @@
+package offline_encoder
+
+import (
+	"errors"
+	"math/rand"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/bpetok/internal/tokenizer/core"
+)
+
+func renderTemplate(template string, vars map[string]string) string {
+	for name, v := range vars {
+		template = strings.ReplaceAll(template, "{{"+name+"}}", v)
+	}
+	return template
+}
+
+func TestTemplateCache_MatchesFullEncode(t *testing.T) {
+	tok := loadTestTokenizer(t)
+	c := tok.NewTemplateCache(8)
+
+	templates := []string{
+		"{{question}}",
+		"You are a helpful assistant. Answer the question below as briefly as you can, without repeating it.\n\nQuestion: {{question}}\nAnswer:",
+		"{{a}}{{b}}",
+		"Dear {{name}},\n\nThank you for your order #{{order}}. It will ship on {{date}}. Kind regards, the shipping team at the warehouse.",
+		"no placeholders at all, just text",
+		// literals well past CommitGuard, so Render splices cached middles in
+		strings.Repeat("The quick brown fox jumps over the lazy dog. ", 60) + "{{a}}" +
+			strings.Repeat("Pack my box with five dozen liquor jugs! ", 60) + "{{b}}" + strings.Repeat("end ", 300),
+	}
+	values := []string{"", " ", "Bob", "what's the capital of France?", "ing", "#", "\n\n", "12345", "héllo wörld", "aaaaaaaaaaaaaaaaaaaaaaa"}
+
+	rng := rand.New(rand.NewSource(1))
+	for round := 0; round < 50; round++ {
+		for _, tmpl := range templates {
+			vars := map[string]string{}
+			for _, name := range []string{"question", "a", "b", "name", "order", "date"} {
+				vars[name] = values[rng.Intn(len(values))]
+			}
+
+			got, err := c.Render(tmpl, vars)
+			if err != nil {
+				t.Fatalf("render %q: %v", tmpl, err)
+			}
+			want := tok.EncodeOffline([]byte(renderTemplate(tmpl, vars)), nil)
+			if len(want) == 0 {
+				want = nil
+			}
+			if !reflect.DeepEqual(got, want) {
+				t.Fatalf("template %q with %v:\ngot  %v\nwant %v", tmpl, vars, got, want)
+			}
+		}
+	}
+
+	st := c.Stats()
+	if st.Misses != int64(len(templates)) || st.Hits != int64(49*len(templates)) || st.Entries != len(templates) {
+		t.Fatalf("unexpected stats %+v", st)
+	}
+	if r := st.HitRate(); r < 0.97 || r > 0.99 {
+		t.Fatalf("hit rate %v", r)
+	}
+}
+
+func TestTemplateCache_ShortTemplateReuses(t *testing.T) {
+	tok := loadTestTokenizer(t)
+	c := tok.NewTemplateCache(1)
+
+	// a typical prompt, every literal far shorter than CommitGuard
+	tmpl := "You are a helpful assistant. Answer the question below as briefly as you can.\n\nQuestion: {{question}}\nAnswer:"
+	literal := tok.EncodeOffline([]byte(strings.Replace(tmpl, "{{question}}", "", 1)), nil)
+	for _, q := range []string{"what's the capital of France?", "", "ing", "\n\n"} {
+		before := c.Stats().ReusedTokens
+		got, err := c.Render(tmpl, map[string]string{"question": q})
+		if err != nil {
+			t.Fatalf("render: %v", err)
+		}
+		if want := tok.EncodeOffline([]byte(strings.Replace(tmpl, "{{question}}", q, 1)), nil); !reflect.DeepEqual(got, want) {
+			t.Fatalf("question %q:\ngot  %v\nwant %v", q, got, want)
+		}
+		// all but the word or so either side of the placeholder comes from the cache
+		if reused := c.Stats().ReusedTokens - before; reused < int64(len(literal))-6 {
+			t.Fatalf("question %q: only %d of the literal's %d tokens came from the cache", q, reused, len(literal))
+		}
+	}
+}
+
+func TestTemplateCache_Eviction(t *testing.T) {
+	tok := loadTestTokenizer(t)
+	c := tok.NewTemplateCache(2)
+
+	for _, tmpl := range []string{"a {{x}}", "b {{x}}", "a {{x}}", "c {{x}}", "b {{x}}"} {
+		if _, err := c.Render(tmpl, map[string]string{"x": "y"}); err != nil {
+			t.Fatalf("render: %v", err)
+		}
+	}
+	// "a" is touched before "c" arrives, so "b" goes first and has to be compiled again
+	st := c.Stats()
+	if st.Hits != 1 || st.Misses != 4 || st.Evictions != 2 || st.Entries != 2 {
+		t.Fatalf("unexpected stats %+v", st)
+	}
+}
+
+func TestTemplateCache_Errors(t *testing.T) {
+	c := loadTestTokenizer(t).NewTemplateCache(4)
+
+	if _, err := c.Render("hi {{name}}", nil); !errors.Is(err, core.ErrTemplateVar) {
+		t.Fatalf("expected ErrTemplateVar, got %v", err)
+	}
+	for _, bad := range []string{"hi {{name", "hi {{ }}"} {
+		if _, err := c.Render(bad, nil); err == nil {
+			t.Fatalf("%q: expected a syntax error", bad)
+		}
+	}
+}
+
+func TestTokenOffsets(t *testing.T) {
+	tok := loadTestTokenizer(t)
+	in := "Hello world, again"
+	ids := tok.EncodeOffline([]byte(in), nil)
+
+	offs := tok.TokenOffsets(ids)
+	if offs[0] != 0 || offs[len(ids)] != len(in) {
+		t.Fatalf("offsets %v don't span the input", offs)
+	}
+	for i, id := range ids {
+		if got := in[offs[i]:offs[i+1]]; got != string(tok.Decode([]int{id})) {
+			t.Fatalf("token %d spans %q", i, got)
+		}
+	}
+}
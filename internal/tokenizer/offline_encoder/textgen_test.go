@@ -0,0 +1,85 @@
+package offline_encoder
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"testing"
+	"unicode/utf8"
+)
+
+func TestTextGenerator_ExactSizeAndDeterministic(t *testing.T) {
+	tok := loadTestTokenizer(t)
+
+	for _, n := range []int{0, 1, 7, 4096} {
+		a := tok.NewTextGenerator(42, nil).Generate(n)
+		b := tok.NewTextGenerator(42, nil).Generate(n)
+		if len(a) != n || !utf8.Valid(a) {
+			t.Fatalf("n=%d: got %d bytes, valid UTF-8 %v", n, len(a), utf8.Valid(a))
+		}
+		if !bytes.Equal(a, b) {
+			t.Fatalf("n=%d: same seed gave different text", n)
+		}
+	}
+
+	var buf [1000]byte
+	g := tok.NewTextGenerator(1, nil)
+	var all []byte
+	for range 10 {
+		n, err := g.Read(buf[:1+len(all)%997])
+		if err != nil {
+			t.Fatalf("Read: %v", err)
+		}
+		all = append(all, buf[:n]...)
+	}
+	if !utf8.Valid(all) {
+		t.Fatalf("stream read back isn't valid UTF-8")
+	}
+}
+
+func TestTextGenerator_Weighted(t *testing.T) {
+	tok := loadTestTokenizer(t)
+
+	freqs := tok.TokenFrequencies([]byte("the cat sat on the mat, the end"))
+	g := tok.NewTextGenerator(3, freqs)
+	the := tok.EncodeOffline([]byte(" the"), nil)[0]
+
+	seen := 0
+	for range 1000 {
+		id := g.NextToken()
+		if freqs[id] == 0 {
+			t.Fatalf("sampled token %d, which isn't in the corpus", id)
+		}
+		if id == the {
+			seen++
+		}
+	}
+	// " the" is 2 of the 10 tokens, allow plenty of slack
+	if seen < 120 || seen > 280 {
+		t.Fatalf("sampled \" the\" %d times out of 1000", seen)
+	}
+
+	empty := tok.NewTextGenerator(3, make([]int, tok.VocabSize()))
+	if id := empty.NextToken(); id != -1 {
+		t.Fatalf("expected -1 from an empty distribution, got %d", id)
+	}
+	if _, err := empty.Read(make([]byte, 8)); err != io.EOF {
+		t.Fatalf("expected io.EOF, got %v", err)
+	}
+}
+
+func BenchmarkEncodeOffline_Generated(b *testing.B) {
+	tok := loadTestTokenizerB(b)
+	corpus, err := os.ReadFile("../testdata/gpt2/bench_corpus.txt")
+	if err != nil {
+		b.Fatalf("read corpus: %v", err)
+	}
+	input := tok.NewTextGenerator(1, tok.TokenFrequencies(corpus)).Generate(1 << 20)
+
+	b.SetBytes(int64(len(input)))
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		_ = tok.EncodeOffline(input, nil)
+	}
+}
@@ -0,0 +1,98 @@
+package offline_encoder
+
+import (
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"math/rand"
+	"os"
+	"reflect"
+	"slices"
+	"testing"
+
+	"github.com/bpetok/internal/tokenizer/core"
+)
+
+// tinyTiktoken builds a rank file tokenizer over the 256 single bytes plus extra, ranked in order.
+func tinyTiktoken(t *testing.T, extra ...string) (*core.Tokenizer, map[string]int) {
+	t.Helper()
+
+	ranks := make(map[string]int)
+	var sb bytes.Buffer
+	for id := range 256 + len(extra) {
+		tok := string([]byte{byte(id)})
+		if id >= 256 {
+			tok = extra[id-256]
+		}
+		ranks[tok] = id
+		fmt.Fprintf(&sb, "%s %d\n", base64.StdEncoding.EncodeToString([]byte(tok)), id)
+	}
+
+	tok, err := core.LoadTokenizerFromTiktokenBytes(sb.Bytes())
+	if err != nil {
+		t.Fatalf("load: %v", err)
+	}
+	return tok, ranks
+}
+
+// A rank file can rank a token below one of its parts: here (aaa, b) -> aaab only exists after aaa (259)
+// is merged, yet outranks it. The offline queue used to keep draining from 259 and merged (b, aaa) first.
+func TestTieBreak_PairRankedBelowItsParts(t *testing.T) {
+	tok, ranks := tinyTiktoken(t, "aa", "aab", "aaab", "aaa", "baaa")
+
+	in := []byte("baaab")
+	want := tiktokenReference(ranks, in) // b aaab
+	if got := tok.EncodeOffline(in, nil); !reflect.DeepEqual(got, want) {
+		t.Fatalf("EncodeOffline = %v, want %v", got, want)
+	}
+	if got := tok.CountTokens(in); got != len(want) {
+		t.Fatalf("CountTokens = %d, want %d", got, len(want))
+	}
+	for _, rule := range core.TieBreaks {
+		if got := tok.EncodeTieBreak(in, rule); !reflect.DeepEqual(got, want) {
+			t.Fatalf("%v: got %v, want %v", rule, got, want)
+		}
+	}
+}
+
+func TestTieBreak_RulesMatchReference(t *testing.T) {
+	rankFile, ranks := gpt2AsTiktoken(t)
+	tok, err := core.LoadTokenizerFromTiktokenBytes(rankFile)
+	if err != nil {
+		t.Fatalf("load: %v", err)
+	}
+
+	rng := rand.New(rand.NewSource(3))
+	alphabet := []byte("aab  ..==--\n\nthe")
+	for range 300 {
+		in := make([]byte, 1+rng.Intn(24))
+		for i := range in {
+			in[i] = alphabet[rng.Intn(len(alphabet))]
+		}
+		want := tiktokenReference(ranks, in)
+		for _, rule := range core.TieBreaks {
+			if got := tok.EncodeTieBreak(in, rule); !reflect.DeepEqual(got, want) {
+				t.Fatalf("%v on %q: got %v, want %v", rule, in, got, want)
+			}
+		}
+	}
+}
+
+func TestSimulateTieBreaks_GPT2Corpus(t *testing.T) {
+	tok := loadTestTokenizer(t)
+	corpus, err := os.ReadFile("../testdata/gpt2/bench_corpus.txt")
+	if err != nil {
+		t.Fatalf("read corpus: %v", err)
+	}
+	lines := bytes.Split(corpus[:64<<10], []byte("\n"))
+
+	rep := tok.SimulateTieBreaks(slices.Values(lines))
+	if rep.Docs != len(lines) || rep.Bytes != 64<<10-(len(lines)-1) {
+		t.Fatalf("counted %d docs and %d bytes", rep.Docs, rep.Bytes)
+	}
+	for _, rule := range core.TieBreaks {
+		if rep.Diverged[rule] != 0 || rep.Rate(rule) != 0 {
+			t.Fatalf("%v diverged on %d docs, first %+v", rule, rep.Diverged[rule], rep.Examples[rule])
+		}
+	}
+}
@@ -0,0 +1,81 @@
+package offline_encoder
+
+import (
+	"bytes"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/bpetok/internal/tokenizer/core"
+)
+
+func TestWriteTiktoken_GPT2(t *testing.T) {
+	tok := loadTestTokenizer(t)
+
+	var buf bytes.Buffer
+	if err := tok.WriteTiktoken(&buf); err != nil {
+		t.Fatalf("WriteTiktoken: %v", err)
+	}
+	if want, _ := gpt2AsTiktoken(t); !bytes.Equal(buf.Bytes(), want) {
+		t.Fatalf("rank file differs from the reference layout")
+	}
+
+	back, err := core.Load(core.TiktokenBytes(buf.Bytes()))
+	if err != nil {
+		t.Fatalf("reload: %v", err)
+	}
+	g := tok.NewTextGenerator(11, nil)
+	inputs := []string{"Hello world, this is a tiktoken rank file.", "naïve café 😀 東京", "  x := y<<2 // ok\n"}
+	for range 50 {
+		inputs = append(inputs, string(g.Generate(200)))
+	}
+	for _, in := range inputs {
+		want := tok.EncodeOffline([]byte(in), nil)
+		if got := back.EncodeOffline([]byte(in), nil); !reflect.DeepEqual(got, want) {
+			t.Fatalf("%q:\ngot  %v\nwant %v", in, got, want)
+		}
+	}
+}
+
+func TestWriteTiktoken_LeavesOutSpecialTokens(t *testing.T) {
+	vocab, merges := readGPT2Assets(t)
+	tok, err := core.Load(core.Bytes(vocab, merges), core.WithSpecialTokens(map[string]int{"<|fim|>": 50257}))
+	if err != nil {
+		t.Fatalf("load: %v", err)
+	}
+
+	var buf bytes.Buffer
+	if err := tok.WriteTiktoken(&buf); err != nil {
+		t.Fatalf("WriteTiktoken: %v", err)
+	}
+	if lines := bytes.Count(buf.Bytes(), []byte("\n")); lines != 50257 {
+		t.Fatalf("wrote %d lines, want the 50257 ordinary tokens", lines)
+	}
+}
+
+func TestWriteTiktoken_Incompatible(t *testing.T) {
+	vocab, merges := readGPT2Assets(t)
+	// swap the first two merges, "Ġ a" (ID 257) now ranks ahead of "Ġ t" (ID 256)
+	lines := strings.SplitN(string(merges), "\n", 4)
+	lines[1], lines[2] = lines[2], lines[1]
+	tok, err := core.Load(core.Bytes(vocab, []byte(strings.Join(lines, "\n"))))
+	if err != nil {
+		t.Fatalf("load: %v", err)
+	}
+
+	sp, err := core.Load(core.SentencePieceBytes(spTestModel(2, false, spTestPieces)))
+	if err != nil {
+		t.Fatalf("load sentencepiece: %v", err)
+	}
+
+	for name, tok := range map[string]*core.Tokenizer{"merge order": tok, "sentencepiece": sp} {
+		var buf bytes.Buffer
+		if err := tok.WriteTiktoken(&buf); !errors.Is(err, core.ErrTiktokenIncompatible) {
+			t.Fatalf("%s: expected ErrTiktokenIncompatible, got %v", name, err)
+		}
+		if buf.Len() != 0 {
+			t.Fatalf("%s: wrote %d bytes before failing", name, buf.Len())
+		}
+	}
+}
@@ -0,0 +1,134 @@
+package offline_encoder
+
+import (
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/bpetok/internal/tokenizer/core"
+)
+
+// tiktokenReference is tiktoken's byte pair merge written the slow, obvious way: merge the leftmost adjacent
+// pair whose concatenation has the lowest rank until none is left.
+func tiktokenReference(ranks map[string]int, input []byte) []int {
+	parts := make([]string, len(input))
+	for i, b := range input {
+		parts[i] = string([]byte{b})
+	}
+
+	for {
+		best, at := -1, -1
+		for i := 0; i+1 < len(parts); i++ {
+			if r, ok := ranks[parts[i]+parts[i+1]]; ok && (best < 0 || r < best) {
+				best, at = r, i
+			}
+		}
+		if at < 0 {
+			break
+		}
+		parts[at] += parts[at+1]
+		parts = append(parts[:at+1], parts[at+2:]...)
+	}
+
+	ids := make([]int, len(parts))
+	for i, p := range parts {
+		ids[i] = ranks[p]
+	}
+	return ids
+}
+
+// gpt2AsTiktoken writes the GPT-2 vocab out in rank file form, IDs as ranks.
+func gpt2AsTiktoken(t *testing.T) ([]byte, map[string]int) {
+	t.Helper()
+	tok := loadTestTokenizer(t)
+
+	var buf bytes.Buffer
+	ranks := make(map[string]int, tok.VocabSize())
+	for id, bs := range tok.Vocab().All() {
+		fmt.Fprintf(&buf, "%s %d\n", base64.StdEncoding.EncodeToString(bs), id)
+		ranks[string(bs)] = id
+	}
+	return buf.Bytes(), ranks
+}
+
+func TestLoadTiktoken_MatchesReferenceMerge(t *testing.T) {
+	data, ranks := gpt2AsTiktoken(t)
+
+	tok, err := core.LoadTokenizerFromTiktokenBytes(data)
+	if err != nil {
+		t.Fatalf("load: %v", err)
+	}
+	if tok.VocabSize() != len(ranks) {
+		t.Fatalf("vocab size %d, want %d", tok.VocabSize(), len(ranks))
+	}
+
+	inputs := []string{
+		"Hello world, this is a tiktoken rank file.",
+		"  indented\n\tcode(x) => x*2 // comment",
+		"naïve café 😀 東京",
+	}
+	r := rand.New(rand.NewSource(5))
+	for i := 0; i < 20; i++ {
+		b := make([]byte, 1+r.Intn(40))
+		for j := range b {
+			b[j] = " etaoinshrdlu.,"[r.Intn(15)]
+		}
+		inputs = append(inputs, string(b))
+	}
+
+	for _, in := range inputs {
+		got := tok.EncodeOffline([]byte(in), nil)
+		if want := tiktokenReference(ranks, []byte(in)); !reflect.DeepEqual(got, want) {
+			t.Fatalf("%q:\ngot  %v\nwant %v", in, got, want)
+		}
+		if dec := tok.Decode(got); string(dec) != in {
+			t.Fatalf("round trip: got %q want %q", dec, in)
+		}
+	}
+}
+
+func TestLoadTiktoken_FromFile(t *testing.T) {
+	data, _ := gpt2AsTiktoken(t)
+	path := filepath.Join(t.TempDir(), "gpt2.tiktoken")
+	if err := os.WriteFile(path, data, 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	tok, err := core.LoadTokenizerFromTiktoken(path)
+	if err != nil {
+		t.Fatalf("load: %v", err)
+	}
+	if tok.Stats().MaxMergeDepth < 1 {
+		t.Fatalf("expected merges to be derived from the ranks")
+	}
+}
+
+func TestLoadTiktoken_Rejects(t *testing.T) {
+	var base strings.Builder
+	for b := 0; b < 256; b++ {
+		fmt.Fprintf(&base, "%s %d\n", base64.StdEncoding.EncodeToString([]byte{byte(b)}), b)
+	}
+
+	for name, extra := range map[string]string{
+		"bad base64":     "!!!! 256\n",
+		"missing rank":   "YWI=\n",
+		"negative rank":  "YWI= -1\n",
+		"duplicate rank": "YWI= 10\n",
+		"duplicate tok":  "YQ== 256\n",
+		"gap":            "YWI= 300\n",
+	} {
+		if _, err := core.LoadTokenizerFromTiktokenBytes([]byte(base.String() + extra)); err == nil {
+			t.Errorf("%s: expected an error", name)
+		}
+	}
+
+	if _, err := core.LoadTokenizerFromTiktokenBytes([]byte("YQ== 0\n")); err == nil {
+		t.Errorf("expected an error for a vocab without every byte")
+	}
+}
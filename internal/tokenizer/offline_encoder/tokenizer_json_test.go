@@ -0,0 +1,207 @@
+package offline_encoder
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"log"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/bpetok/internal/tokenizer/core"
+)
+
+// gpt2TokenizerJSON assembles a tokenizer.json around the GPT-2 test vocab. pairs picks the newer
+// [["a", "b"], ...] merges form over "a b" strings, rest is merged into the top-level object.
+func gpt2TokenizerJSON(t *testing.T, pairs bool, rest map[string]any) []byte {
+	t.Helper()
+
+	vocab, err := os.ReadFile(testVocabPath)
+	if err != nil {
+		t.Fatalf("read vocab: %v", err)
+	}
+	mergesData, err := os.ReadFile(testMergesPath)
+	if err != nil {
+		t.Fatalf("read merges: %v", err)
+	}
+
+	var merges []any
+	for _, line := range strings.Split(string(mergesData), "\n") {
+		if line == "" || strings.HasPrefix(line, "#version") {
+			continue
+		}
+		if pairs {
+			a, b, _ := strings.Cut(line, " ")
+			merges = append(merges, []string{a, b})
+		} else {
+			merges = append(merges, line)
+		}
+	}
+
+	doc := map[string]any{
+		"version":        "1.0",
+		"added_tokens":   []any{},
+		"normalizer":     nil,
+		"pre_tokenizer":  map[string]any{"type": "ByteLevel", "add_prefix_space": false, "trim_offsets": true},
+		"post_processor": nil,
+		"decoder":        map[string]any{"type": "ByteLevel"},
+		"model": map[string]any{
+			"type":   "BPE",
+			"vocab":  json.RawMessage(vocab),
+			"merges": merges,
+		},
+	}
+	for k, v := range rest {
+		doc[k] = v
+	}
+
+	data, err := json.Marshal(doc)
+	if err != nil {
+		t.Fatalf("marshal tokenizer.json: %v", err)
+	}
+	return data
+}
+
+func TestTokenizerJSON_MatchesVocabAndMerges(t *testing.T) {
+	in := []byte("Hello world, the quick brown fox jumps over the lazy dog. ####")
+	want := loadTestTokenizer(t).EncodeOffline(in, nil)
+
+	for _, pairs := range []bool{false, true} {
+		tok, err := core.Load(core.TokenizerJSONBytes(gpt2TokenizerJSON(t, pairs, nil)))
+		if err != nil {
+			t.Fatalf("pairs=%v: %v", pairs, err)
+		}
+		if got := tok.EncodeOffline(in, nil); !reflect.DeepEqual(got, want) {
+			t.Fatalf("pairs=%v: got %v, want %v", pairs, got, want)
+		}
+		if tok.Stats().Merges != 50000 {
+			t.Fatalf("pairs=%v: %d merges", pairs, tok.Stats().Merges)
+		}
+	}
+}
+
+func TestTokenizerJSON_FromFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "tokenizer.json")
+	if err := os.WriteFile(path, gpt2TokenizerJSON(t, false, nil), 0o644); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	tok, err := core.Load(core.TokenizerJSONFile(path))
+	if err != nil {
+		t.Fatalf("load: %v", err)
+	}
+	if tok.VocabSize() != 50257 {
+		t.Fatalf("vocab size %d", tok.VocabSize())
+	}
+}
+
+func TestTokenizerJSON_AddedTokens(t *testing.T) {
+	data := gpt2TokenizerJSON(t, false, map[string]any{
+		"added_tokens": []any{
+			map[string]any{"id": 50256, "content": "<|endoftext|>", "special": true},
+			map[string]any{"id": 50257, "content": "<|pad|>", "special": true},
+			map[string]any{"id": 50258, "content": " custom", "special": false},
+		},
+	})
+
+	tok, err := core.Load(core.TokenizerJSONBytes(data))
+	if err != nil {
+		t.Fatalf("load: %v", err)
+	}
+	if tok.VocabSize() != 50259 {
+		t.Fatalf("expected added tokens to extend the vocab, got %d", tok.VocabSize())
+	}
+	if got := string(tok.Decode([]int{50257, 50258})); got != "<|pad|> custom" {
+		t.Fatalf("decode added: %q", got)
+	}
+	want := map[string]int{"<|endoftext|>": 50256, "<|pad|>": 50257}
+	if got := tok.SpecialTokens(); !reflect.DeepEqual(got, want) {
+		t.Fatalf("SpecialTokens() = %v, want %v", got, want)
+	}
+
+	// a caller supplied special has to agree with the file
+	_, err = core.Load(core.TokenizerJSONBytes(data), core.WithSpecialTokens(map[string]int{"<|pad|>": 50259}))
+	if err == nil {
+		t.Fatalf("expected conflicting special token to fail")
+	}
+}
+
+func TestTokenizerJSON_Sections(t *testing.T) {
+	nfc := map[string]any{"type": "Sequence", "normalizers": []any{map[string]any{"type": "NFC"}}}
+	tok, err := core.Load(core.TokenizerJSONBytes(gpt2TokenizerJSON(t, false, map[string]any{"normalizer": nfc})))
+	if err != nil {
+		t.Fatalf("load: %v", err)
+	}
+	if tok.Normalization() != core.NormalizeNFC {
+		t.Fatalf("normalizer section ignored, got %v", tok.Normalization())
+	}
+	tok, err = core.Load(core.TokenizerJSONBytes(gpt2TokenizerJSON(t, false, map[string]any{"normalizer": nfc})),
+		core.WithNormalization(core.NormalizeNFKC))
+	if err != nil || tok.Normalization() != core.NormalizeNFKC {
+		t.Fatalf("WithNormalization should win: %v, %v", err, tok.Normalization())
+	}
+
+	prefix := map[string]any{"pre_tokenizer": map[string]any{"type": "ByteLevel", "add_prefix_space": true}}
+	tok, err = core.Load(core.TokenizerJSONBytes(gpt2TokenizerJSON(t, false, prefix)))
+	if err != nil || !tok.AddPrefixSpace() {
+		t.Fatalf("add_prefix_space ignored: %v, %v", err, tok.AddPrefixSpace())
+	}
+	if got := string(tok.Prepare([]byte("Hello"))); got != " Hello" {
+		t.Fatalf("Prepare: got %q, want \" Hello\"", got)
+	}
+
+	// ByteLevel's use_regex, on unless turned off, is the GPT-2 split
+	tok, err = core.Load(core.TokenizerJSONBytes(gpt2TokenizerJSON(t, false, nil)))
+	if err != nil || tok.PreTokenization() != core.PreTokenizeGPT2 {
+		t.Fatalf("use_regex default: %v, %v", err, tok.PreTokenization())
+	}
+	split, err := core.Load(core.Files(testVocabPath, testMergesPath), core.WithPreTokenization(core.PreTokenizeGPT2))
+	if err != nil {
+		t.Fatalf("load: %v", err)
+	}
+	// merged over the raw input, ''t and \n\nRE encode differently
+	in := []byte("it said ''twas fine\n\nRE: 's!")
+	if got, want := tok.EncodeOffline(in, nil), split.EncodeOffline(in, nil); !reflect.DeepEqual(got, want) {
+		t.Fatalf("use_regex: got %v, want %v", got, want)
+	}
+	noRegex := map[string]any{"pre_tokenizer": map[string]any{"type": "ByteLevel", "use_regex": false}}
+	tok, err = core.Load(core.TokenizerJSONBytes(gpt2TokenizerJSON(t, false, noRegex)))
+	if err != nil || tok.PreTokenization() != core.PreTokenizeNone {
+		t.Fatalf("use_regex false: %v, %v", err, tok.PreTokenization())
+	}
+	tok, err = core.Load(core.TokenizerJSONBytes(gpt2TokenizerJSON(t, false, nil)),
+		core.WithPreTokenization(core.PreTokenizeCL100K))
+	if err != nil || tok.PreTokenization() != core.PreTokenizeCL100K {
+		t.Fatalf("WithPreTokenization should win: %v, %v", err, tok.PreTokenization())
+	}
+
+	// Llama-3 style: a regex split in front of a regex free ByteLevel loads, with a warning
+	llama := map[string]any{"type": "Sequence", "pretokenizers": []any{
+		map[string]any{"type": "Split", "pattern": map[string]any{"Regex": `\p{L}+`}, "behavior": "Isolated"},
+		map[string]any{"type": "ByteLevel", "add_prefix_space": false, "use_regex": false},
+	}}
+	var logs bytes.Buffer
+	_, err = core.Load(core.TokenizerJSONBytes(gpt2TokenizerJSON(t, false, map[string]any{"pre_tokenizer": llama})),
+		core.WithLogger(log.New(&logs, "", 0)))
+	if err != nil {
+		t.Fatalf("llama style pre-tokenizer: %v", err)
+	}
+	if !strings.Contains(logs.String(), "Split pre-tokenizer is not applied") {
+		t.Fatalf("expected a warning about Split, got %q", logs.String())
+	}
+
+	for name, rest := range map[string]map[string]any{
+		"metaspace":  {"pre_tokenizer": map[string]any{"type": "Metaspace", "replacement": "▁"}},
+		"no pretok":  {"pre_tokenizer": nil},
+		"decoder":    {"decoder": map[string]any{"type": "WordPiece"}},
+		"normalizer": {"normalizer": map[string]any{"type": "Lowercase"}},
+		"model":      {"model": map[string]any{"type": "WordPiece", "vocab": map[string]int{"a": 0}}},
+	} {
+		_, err := core.Load(core.TokenizerJSONBytes(gpt2TokenizerJSON(t, false, rest)))
+		if !errors.Is(err, core.ErrUnsupportedTokenizerJSON) {
+			t.Fatalf("%s: expected ErrUnsupportedTokenizerJSON, got %v", name, err)
+		}
+	}
+}
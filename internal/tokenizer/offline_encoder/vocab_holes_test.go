@@ -0,0 +1,112 @@
+package offline_encoder
+
+import (
+	"bytes"
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/bpetok/internal/tokenizer/core"
+)
+
+// holeyGPT2Vocab moves <|endoftext|> from 50256 to 50300, leaving IDs 50256-50299 unused.
+func holeyGPT2Vocab(t *testing.T) (vocab, merges []byte) {
+	t.Helper()
+	vocab, merges = readGPT2Assets(t)
+
+	var m map[string]int
+	if err := json.Unmarshal(vocab, &m); err != nil {
+		t.Fatal(err)
+	}
+	m["<|endoftext|>"] = 50300
+	vocab, err := json.Marshal(m)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return vocab, merges
+}
+
+func TestVocabHoles_Load(t *testing.T) {
+	vocab, merges := holeyGPT2Vocab(t)
+
+	if _, err := core.Load(core.Bytes(vocab, merges)); err == nil || !strings.Contains(err.Error(), "missing 50256") {
+		t.Fatalf("expected the dense default to refuse, got %v", err)
+	}
+
+	tok, err := core.Load(core.Bytes(vocab, merges), core.WithVocabHoles(true),
+		core.WithSpecialTokens(map[string]int{"<|pad|>": 50270, "<|sep|>": 50310}))
+	if err != nil {
+		t.Fatalf("load: %v", err)
+	}
+	if tok.VocabSize() != 50311 {
+		t.Fatalf("vocab size %d", tok.VocabSize())
+	}
+	for id, valid := range map[int]bool{0: true, 50256: false, 50270: true, 50299: false, 50300: true, 50305: false, 50310: true, 50311: false, -1: false} {
+		if tok.ValidID(id) != valid {
+			t.Errorf("ValidID(%d) = %v", id, !valid)
+		}
+	}
+
+	in := []byte("Hello world, holes in the vocab don't change merges.")
+	want := loadTestTokenizer(t).EncodeOffline(in, nil)
+	got := tok.EncodeOffline(in, nil)
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("got %v, want %v", got, want)
+	}
+	if out := tok.Decode([]int{50300, 50256, 50270}); string(out) != "<|endoftext|><|pad|>" {
+		t.Fatalf("decode %q", out)
+	}
+
+	// holes survive the compiled format
+	data, err := tok.MarshalBinary()
+	if err != nil {
+		t.Fatal(err)
+	}
+	back, err := core.Load(core.Compiled(data))
+	if err != nil {
+		t.Fatalf("compiled: %v", err)
+	}
+	if back.ValidID(50256) || !back.ValidID(50300) {
+		t.Fatalf("compiled tokenizer lost its holes")
+	}
+}
+
+func TestVocabHoles_Tiktoken(t *testing.T) {
+	vocab, merges := holeyGPT2Vocab(t)
+	tok, err := core.Load(core.Bytes(vocab, merges), core.WithVocabHoles(true))
+	if err != nil {
+		t.Fatalf("load: %v", err)
+	}
+
+	var buf bytes.Buffer
+	if err := tok.WriteTiktoken(&buf); err != nil {
+		t.Fatalf("WriteTiktoken: %v", err)
+	}
+	if _, err := core.Load(core.TiktokenBytes(buf.Bytes())); err == nil {
+		t.Fatalf("expected the dense default to refuse a rank file with gaps")
+	}
+	back, err := core.Load(core.TiktokenBytes(buf.Bytes()), core.WithVocabHoles(true))
+	if err != nil {
+		t.Fatalf("reload: %v", err)
+	}
+	if back.VocabSize() != tok.VocabSize() || back.ValidID(50256) || !back.ValidID(50300) {
+		t.Fatalf("reloaded vocab size %d", back.VocabSize())
+	}
+}
+
+func TestVocabHoles_Rejects(t *testing.T) {
+	vocab, merges := readGPT2Assets(t)
+
+	cases := map[string]map[string]int{
+		"huge gap":   {"<|far|>": 1 << 30},
+		"shared id":  {"<|a|>": 50300, "<|b|>": 50300},
+		"taken hole": {"<|a|>": 100},
+	}
+	for name, special := range cases {
+		_, err := core.Load(core.Bytes(vocab, merges), core.WithVocabHoles(true), core.WithSpecialTokens(special))
+		if err == nil {
+			t.Errorf("%s: expected an error", name)
+		}
+	}
+}
@@ -0,0 +1,104 @@
+package offline_encoder
+
+import (
+	"encoding/json"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/bpetok/internal/tokenizer/core"
+)
+
+func TestParseVocabJSON_MatchesEncodingJSON(t *testing.T) {
+	data, err := os.ReadFile("../testdata/gpt2/vocab.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := core.ParseVocabJSON(data)
+	if err != nil {
+		t.Fatalf("ParseVocabJSON: %v", err)
+	}
+	var want map[string]int
+	if err := json.Unmarshal(data, &want); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("parsed %d entries, encoding/json got %d", len(got), len(want))
+	}
+}
+
+func TestParseVocabJSON_Escapes(t *testing.T) {
+	in := `{"a":0, "\"q\"":1,"\\":2,"\u0120x":3,"\ud83d\ude00":4,"\/\n\t":5, "\ud800":6}`
+
+	got, err := core.ParseVocabJSON([]byte(in))
+	if err != nil {
+		t.Fatalf("ParseVocabJSON: %v", err)
+	}
+	var want map[string]int
+	if err := json.Unmarshal([]byte(in), &want); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("got %q want %q", got, want)
+	}
+}
+
+func TestParseVocabJSON_Rejects(t *testing.T) {
+	for _, in := range []string{
+		``,
+		`[]`,
+		`{"a":1,}`,
+		`{"a":-1}`,
+		`{"a":1.5}`,
+		`{"a":01}`,
+		`{"a":"1"}`,
+		`{"a":1,"a":2}`,
+		`{"a":1} x`,
+		`{"a":99999999999}`,
+		"{\"a\x01\":1}",
+		`{"\x":1}`,
+		"{\"\xff\":1}",
+	} {
+		if _, err := core.ParseVocabJSON([]byte(in)); err == nil {
+			t.Errorf("expected an error for %q", in)
+		}
+	}
+}
+
+func TestParseTokenizerJSONVocab(t *testing.T) {
+	in := `{
+		"version": "1.0",
+		"added_tokens": [{"id": 2, "content": "<s>", "special": true, "lstrip": false}],
+		"normalizer": null,
+		"pre_tokenizer": {"type": "ByteLevel", "add_prefix_space": false, "trim_offsets": true},
+		"model": {"type": "BPE", "dropout": null, "vocab": {"a": 0, "b": 1, "<s>": 2}, "merges": ["a b"], "x": -1.5e3}
+	}`
+
+	got, err := core.ParseTokenizerJSONVocab([]byte(in))
+	if err != nil {
+		t.Fatalf("ParseTokenizerJSONVocab: %v", err)
+	}
+	if want := map[string]int{"a": 0, "b": 1, "<s>": 2}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("got %v want %v", got, want)
+	}
+
+	if _, err := core.ParseTokenizerJSONVocab([]byte(`{"model": {"type": "BPE"}}`)); err == nil {
+		t.Fatalf("expected an error without model.vocab")
+	}
+	if _, err := core.ParseTokenizerJSONVocab([]byte(`{"model": {"vocab": {}}, "x": [1,]}`)); err == nil {
+		t.Fatalf("expected an error for malformed JSON outside the vocab")
+	}
+
+	// nesting is capped where encoding/json caps it, rather than recursing until the stack runs out
+	nested := func(n int) []byte {
+		return []byte(`{"model": {"vocab": {}}, "x": ` + strings.Repeat("[", n) + strings.Repeat("]", n) + `}`)
+	}
+	if _, err := core.ParseTokenizerJSONVocab(nested(100)); err != nil {
+		t.Fatalf("100 levels: %v", err)
+	}
+	if _, err := core.ParseTokenizerJSONVocab(nested(1 << 20)); err == nil || !strings.Contains(err.Error(), "nested deeper") {
+		t.Fatalf("expected a nesting error, got %v", err)
+	}
+}
@@ -0,0 +1,75 @@
+package offline_encoder
+
+import (
+	"bytes"
+	"testing"
+	"unicode/utf8"
+)
+
+func TestTokenBytes_MatchesDecode(t *testing.T) {
+	tok := loadTestTokenizer(t)
+
+	for id := 0; id < tok.VocabSize(); id++ {
+		b := tok.TokenBytes(id)
+		if len(b) == 0 || len(b) != tok.TokenLen(id) {
+			t.Fatalf("token %d: bytes %q vs len %d", id, b, tok.TokenLen(id))
+		}
+		if !bytes.Equal(b, tok.Decode([]int{id})) {
+			t.Fatalf("token %d: TokenBytes and Decode disagree", id)
+		}
+	}
+
+	if tok.TokenBytes(-1) != nil || tok.TokenBytes(tok.VocabSize()) != nil {
+		t.Fatalf("out of range ids must return nil")
+	}
+}
+
+func TestTokenBytes_AppendDoesNotClobberArena(t *testing.T) {
+	tok := loadTestTokenizer(t)
+
+	a := tok.TokenBytes(100)
+	next := append([]byte(nil), tok.TokenBytes(101)...)
+
+	if cap(a) != len(a) {
+		t.Fatalf("token view must be capped, len=%d cap=%d", len(a), cap(a))
+	}
+	_ = append(a, 'X', 'X', 'X')
+
+	if !bytes.Equal(tok.TokenBytes(101), next) {
+		t.Fatalf("appending to one token's view corrupted the next token")
+	}
+}
+
+func TestInternedStrings(t *testing.T) {
+	tok := loadTestTokenizer(t)
+	table := tok.InternedStrings()
+
+	if len(table) != tok.VocabSize() {
+		t.Fatalf("expected %d strings, got %d", tok.VocabSize(), len(table))
+	}
+
+	for id, want := range map[int]string{
+		262:                      " the",
+		198:                      `\n`,
+		197:                      `\t`,
+		50256:                    "<|endoftext|>",
+		tok.GetByteToToken(0xf0): `\xf0`,
+	} {
+		if table[id] != want {
+			t.Fatalf("token %d: got %q, want %q", id, table[id], want)
+		}
+	}
+
+	for id, s := range table {
+		if !utf8.ValidString(s) {
+			t.Fatalf("token %d: %q is not valid UTF-8", id, s)
+		}
+	}
+
+	if allocs := testing.AllocsPerRun(10, func() { _ = tok.InternedStrings() }); allocs != 0 {
+		t.Fatalf("expected a cached table, got %v allocs per call", allocs)
+	}
+	if again := tok.InternedStrings(); &again[0] != &table[0] {
+		t.Fatalf("expected the same table on every call")
+	}
+}
@@ -0,0 +1,163 @@
+package offline_encoder
+
+import (
+	"math/rand"
+	"reflect"
+	"testing"
+	"unicode/utf8"
+
+	"github.com/bpetok/internal/tokenizer/core"
+)
+
+// invalidOffsets is the one-shot reference: offsets of every byte that can't start a valid sequence.
+func invalidOffsets(in []byte) []int64 {
+	var out []int64
+	for i := 0; i < len(in); {
+		r, size := utf8.DecodeRune(in[i:])
+		if r == utf8.RuneError && size == 1 {
+			out = append(out, int64(i))
+		}
+		i += size
+	}
+	return out
+}
+
+func trackChunks(in []byte, sizes []int) []int64 {
+	var u core.UTF8Tracker
+	var out []int64
+	bad := func(off int64, _ byte) { out = append(out, off) }
+
+	pos, k := 0, 0
+	for pos < len(in) {
+		end := min(pos+sizes[k%len(sizes)], len(in))
+		u.Feed(in[pos:end], bad)
+		pos = end
+		k++
+	}
+	u.Finish(bad)
+	return out
+}
+
+func TestUTF8Tracker_MatchesOneShotAcrossSplits(t *testing.T) {
+	cases := [][]byte{
+		[]byte("plain ascii"),
+		[]byte("Héllo 🌍 नमस्ते"),
+		{0xff, 'a', 0xc3},
+		{'a', 0xe2, 0x82},         // truncated euro sign at the end
+		{0xe2, 0x28, 0xa1, 'x'},   // bad continuation
+		{0xf0, 0x9f, 0x8c, 0x41},  // 4-byte lead cut short by ascii
+		{0xc3, 0xa9, 0x80, 0x80},  // stray continuation bytes
+		{0xed, 0xa0, 0x80, 'z'},   // surrogate half
+		[]byte("🌍🌍\xf0\x9f\x8c🌍"), // incomplete rune between valid ones
+	}
+
+	for _, in := range cases {
+		want := invalidOffsets(in)
+		for _, sizes := range [][]int{{len(in)}, {1}, {2}, {3}, {1, 2, 5}} {
+			if got := trackChunks(in, sizes); !reflect.DeepEqual(got, want) {
+				t.Fatalf("%q sizes=%v: got %v want %v", in, sizes, got, want)
+			}
+		}
+	}
+}
+
+func TestUTF8Tracker_Randomized(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+	for iter := 0; iter < 500; iter++ {
+		in := make([]byte, r.Intn(64))
+		for i := range in {
+			if r.Intn(3) == 0 {
+				in[i] = byte(0x80 + r.Intn(0x80))
+			} else {
+				in[i] = []byte("aé🌍")[r.Intn(7)]
+			}
+		}
+		sizes := []int{1 + r.Intn(5), 1 + r.Intn(5)}
+		if got, want := trackChunks(in, sizes), invalidOffsets(in); !reflect.DeepEqual(got, want) {
+			t.Fatalf("%x sizes=%v: got %v want %v", in, sizes, got, want)
+		}
+	}
+}
+
+func TestWarnings_AggregatesPerKind(t *testing.T) {
+	var w core.Warnings
+	w.Add(core.WarnInvalidUTF8, 1, "byte 0x%02x", 0x80)
+	if len(w.List()) != 0 {
+		t.Fatalf("recorded while off: %v", w.List())
+	}
+
+	w.Enable(true)
+	w.Add(core.WarnInvalidUTF8, 7, "byte 0x%02x", 0xff)
+	w.Add(core.WarnInvalidUTF8, 9, "byte 0x%02x", 0xfe)
+
+	list := w.List()
+	if len(list) != 1 {
+		t.Fatalf("expected one kind, got %v", list)
+	}
+	if got := list[0]; got.Count != 2 || got.FirstOffset != 7 || got.First != "byte 0xff" {
+		t.Fatalf("unexpected aggregate %+v", got)
+	}
+
+	w.Reset()
+	if len(w.List()) != 0 {
+		t.Fatalf("reset did not clear warnings")
+	}
+}
+
+func TestMaxRank_LeavesMergesOut(t *testing.T) {
+	full := loadTestTokenizer(t)
+	capped, err := core.Load(core.Files(testVocabPath, testMergesPath), core.WithMaxRank(999))
+	if err != nil {
+		t.Fatalf("load: %v", err)
+	}
+	// the header plus the first 1000 merges, ranks 0 to 999
+	truncated, err := core.Load(core.Files(testVocabPath, writeTruncatedMerges(t, 1001, "")))
+	if err != nil {
+		t.Fatalf("load truncated: %v", err)
+	}
+
+	st := capped.Stats()
+	if st.Merges != 1000 || st.MaxRank != 999 || st.CappedMerges != full.Stats().Merges-1000 || !capped.HasCappedRanks() {
+		t.Fatalf("stats %+v", st)
+	}
+	if full.HasCappedRanks() {
+		t.Fatalf("an uncapped load reports capped ranks")
+	}
+
+	input := []byte("The quick brown fox jumps over the lazy dog. Héllo 🌍, tokenization!")
+	ids := capped.EncodeOffline(input, nil)
+	if want := truncated.EncodeOffline(input, nil); !reflect.DeepEqual(ids, want) {
+		t.Fatalf("capped encoding %v, want the truncated file's %v", ids, want)
+	}
+
+	// the tracker reports every adjacent pair the cap left unmerged, at the offset of its right-hand token,
+	// however the output is split
+	var want [][3]int64
+	var off int64
+	for i, id := range ids {
+		if i > 0 {
+			if _, ok := capped.CappedRank(ids[i-1], id); ok {
+				want = append(want, [3]int64{off, int64(ids[i-1]), int64(id)})
+			}
+		}
+		off += int64(capped.TokenLen(id))
+	}
+	if len(want) == 0 {
+		t.Fatalf("no capped pair in %v", ids)
+	}
+	for _, chunk := range []int{1, 2, 5, len(ids)} {
+		var c core.CappedRankTracker
+		var got [][3]int64
+		for pos := 0; pos < len(ids); pos += chunk {
+			c.Feed(capped, ids[pos:min(pos+chunk, len(ids))], func(off int64, left, right, rank int) {
+				if rank <= 999 {
+					t.Fatalf("pair (%d, %d) reported with rank %d", left, right, rank)
+				}
+				got = append(got, [3]int64{off, int64(left), int64(right)})
+			})
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Fatalf("chunk=%d: %v, want %v", chunk, got, want)
+		}
+	}
+}
@@ -0,0 +1,253 @@
+// Package streaming_encoder_adaptive picks between the naive and the incremental streaming encoder at run
+// time, based on the chunk sizes a stream actually arrives in.
+package streaming_encoder_adaptive
+
+import (
+	"github.com/bpetok/internal/tokenizer/core"
+	"github.com/bpetok/internal/tokenizer/streaming_encoder_incremental"
+	"github.com/bpetok/internal/tokenizer/streaming_encoder_naive"
+)
+
+// minObservations is how many pushes have to go by after a switch before the encoder considers switching
+// back, so a single odd chunk can't make it flap.
+const minObservations = 8
+
+// AdaptiveEncoder streams through whichever engine suits the chunk sizes it sees. The naive engine
+// moves its held back tail on every push and encodes in batches, which is cheap next to a large chunk; the
+// incremental one keeps the merge state between pushes, which wins when chunks are small. It starts naive
+// and tracks a moving average of chunk sizes: below the small threshold it moves to incremental, above the
+// large one back to naive.
+//
+// A switch hands the bytes the current engine holds back over to the other one, which re-encodes them
+// from the last committed boundary. Both engines only commit at junctions no merge can join, so that
+// boundary is one the whole stream has and output always equals EncodeOffline over it.
+type AdaptiveEncoder struct {
+	tok         *core.Tokenizer
+	naive       *streaming_encoder_naive.NaiveStreamingEncoderState
+	incremental *streaming_encoder_incremental.StreamingEncoderV2
+	useInc      bool
+
+	small, large int
+	// avg is the moving average chunk size in 1/8 bytes, sinceSwitch counts pushes since the last switch
+	avg         int
+	sinceSwitch int
+	switches    int
+
+	// normalization, the prefix space and the checks behind warnings happen here, once, so the engines
+	// only ever see final bytes
+	normalizer  *core.StreamNormalizer
+	prefixSpace bool
+	started     bool
+	utf8        core.UTF8Tracker
+	capped      core.CappedRankTracker
+	warnings    core.Warnings
+
+	// the engines return slices they reuse, copied straight into out, or into outBuf in zero-copy mode
+	zeroCopy bool
+	outBuf   []int
+}
+
+// Option configures an AdaptiveEncoder.
+type Option func(*AdaptiveEncoder)
+
+// WithThresholds sets the average chunk sizes, in bytes, below which the encoder moves to the incremental
+// engine and above which it moves back to the naive one. small is clamped to at most large.
+func WithThresholds(small, large int) Option {
+	return func(ae *AdaptiveEncoder) {
+		ae.small, ae.large = min(small, large), large
+	}
+}
+
+// WithZeroCopyOutput makes Push and Flush return slices backed by a buffer the encoder reuses. They are only
+// valid until the next call.
+func WithZeroCopyOutput(on bool) Option {
+	return func(ae *AdaptiveEncoder) {
+		ae.zeroCopy = on
+	}
+}
+
+// WithWarnings makes the encoder record non-fatal anomalies for Warnings, see core.Warnings. Off by
+// default, which also skips the checks behind them.
+func WithWarnings(on bool) Option {
+	return func(ae *AdaptiveEncoder) {
+		ae.warnings.Enable(on)
+	}
+}
+
+// NewAdaptiveEncoder returns an encoder over tok that normalizes its input and adds a prefix space the way
+// tok was loaded to. The
+// default thresholds are MaxTokenByteLen and four times that: below the first the naive engine spends
+// more time moving its held back tail than on new input.
+func NewAdaptiveEncoder(tok *core.Tokenizer, opts ...Option) *AdaptiveEncoder {
+	ae := &AdaptiveEncoder{
+		tok:   tok,
+		naive: streaming_encoder_naive.NewNaiveStreamingEncoderStateWithOpts(tok, core.BaseEncoderState{OptNoCopyReturn: true}),
+		incremental: streaming_encoder_incremental.NewStreamingEncoderV2(tok,
+			streaming_encoder_incremental.WithNormalization(core.NormalizeNone),
+			streaming_encoder_incremental.WithPrefixSpace(false),
+			streaming_encoder_incremental.WithZeroCopyOutput(true)),
+		small:       tok.MaxTokenByteLen,
+		large:       4 * tok.MaxTokenByteLen,
+		normalizer:  core.NewStreamNormalizer(tok.Normalization()),
+		prefixSpace: tok.AddPrefixSpace(),
+	}
+	for _, opt := range opts {
+		opt(ae)
+	}
+	ae.avg = 8 * ae.large
+	return ae
+}
+
+// Feed implements core.Encoder.
+func (ae *AdaptiveEncoder) Feed(chunk []byte) []int {
+	return ae.Push(chunk)
+}
+
+// Push encodes the next chunk and returns the tokens that became final.
+func (ae *AdaptiveEncoder) Push(chunk []byte) []int {
+	if len(chunk) == 0 {
+		return nil
+	}
+	out := append(ae.newOut(), ae.observe(len(chunk))...)
+
+	if ae.warnings.Enabled() {
+		ae.utf8.Feed(chunk, ae.invalidUTF8)
+	}
+	if ae.normalizer != nil {
+		chunk = ae.normalizer.Push(chunk)
+	}
+	if chunk = ae.prefix(chunk); len(chunk) > 0 {
+		out = append(out, ae.push(chunk)...)
+	}
+	return ae.finishOut(out)
+}
+
+// Flush emits everything still pending and leaves the encoder ready for a new stream. The engine in use
+// and the chunk size average carry over, streams from one source tend to look alike.
+func (ae *AdaptiveEncoder) Flush() []int {
+	if ae.warnings.Enabled() {
+		ae.utf8.Finish(ae.invalidUTF8)
+	}
+
+	var rest []byte
+	if ae.normalizer != nil {
+		rest = ae.normalizer.Flush()
+	}
+	out := append(ae.newOut(), ae.push(ae.prefix(rest))...)
+	ae.started = false
+	if ae.useInc {
+		out = append(out, ae.incremental.Flush()...)
+	} else {
+		out = append(out, ae.naive.Flush()...)
+	}
+	out = ae.finishOut(out)
+	ae.capped.Reset()
+	return out
+}
+
+// newOut returns the slice a call collects its output in: the reused buffer in zero-copy mode, none
+// otherwise.
+func (ae *AdaptiveEncoder) newOut() []int {
+	if ae.zeroCopy {
+		return ae.outBuf[:0]
+	}
+	return nil
+}
+
+// finishOut keeps a grown buffer for the next call and maps empty output to nil. It is where the output is
+// checked for capped ranks.
+func (ae *AdaptiveEncoder) finishOut(out []int) []int {
+	if ae.warnings.Enabled() && ae.tok.HasCappedRanks() {
+		ae.capped.Feed(ae.tok, out, ae.cappedRank)
+	}
+	if ae.zeroCopy {
+		ae.outBuf = out[:0]
+	}
+	if len(out) == 0 {
+		return nil
+	}
+	return out
+}
+
+// prefix adds the prefix space to the stream's first normalized bytes, if it is on.
+func (ae *AdaptiveEncoder) prefix(chunk []byte) []byte {
+	if !ae.prefixSpace || ae.started || len(chunk) == 0 {
+		return chunk
+	}
+	ae.started = true
+	return core.PrefixSpace(chunk)
+}
+
+func (ae *AdaptiveEncoder) push(b []byte) []int {
+	if len(b) == 0 {
+		return nil
+	}
+	if ae.useInc {
+		return ae.incremental.Push(b)
+	}
+	return ae.naive.Push(b)
+}
+
+// observe folds a chunk size into the average and switches engines when it crosses a threshold, returning
+// whatever the switch committed.
+func (ae *AdaptiveEncoder) observe(n int) []int {
+	ae.avg += n - ae.avg/8
+	ae.sinceSwitch++
+	if ae.sinceSwitch < minObservations {
+		return nil
+	}
+
+	avg := ae.avg / 8
+	switch {
+	case !ae.useInc && avg < ae.small:
+		return ae.handoff(true)
+	case ae.useInc && avg > ae.large:
+		return ae.handoff(false)
+	}
+	return nil
+}
+
+// handoff moves the stream to the other engine. The bytes the old one held back go in as the start of the
+// new one's stream: their tokens were never emitted, and they begin at a junction no merge can join, so
+// nothing is lost, repeated or merged differently. The new engine may commit some of them straight away.
+func (ae *AdaptiveEncoder) handoff(toInc bool) []int {
+	var pending []byte
+	if toInc {
+		pending = ae.naive.TakePending()
+	} else {
+		pending = ae.incremental.TakePending()
+	}
+	ae.useInc = toInc
+	ae.sinceSwitch = 0
+	ae.switches++
+	return ae.push(pending)
+}
+
+// Incremental reports whether the incremental engine is the one in use.
+func (ae *AdaptiveEncoder) Incremental() bool {
+	return ae.useInc
+}
+
+// Switches returns how many times the encoder has changed engines.
+func (ae *AdaptiveEncoder) Switches() int {
+	return ae.switches
+}
+
+// Warnings returns the non-fatal anomalies seen so far, nil unless WithWarnings is on. They accumulate
+// across streams until ResetWarnings is called.
+func (ae *AdaptiveEncoder) Warnings() []core.Warning {
+	return ae.warnings.List()
+}
+
+// ResetWarnings clears the recorded warnings.
+func (ae *AdaptiveEncoder) ResetWarnings() {
+	ae.warnings.Reset()
+}
+
+func (ae *AdaptiveEncoder) invalidUTF8(off int64, b byte) {
+	ae.warnings.Add(core.WarnInvalidUTF8, off, "invalid UTF-8 byte 0x%02x", b)
+}
+
+func (ae *AdaptiveEncoder) cappedRank(off int64, left, right, rank int) {
+	ae.warnings.Add(core.WarnCappedRank, off, "tokens %d and %d left unmerged, their merge has rank %d", left, right, rank)
+}
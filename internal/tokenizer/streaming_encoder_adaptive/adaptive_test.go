@@ -0,0 +1,163 @@
+package streaming_encoder_adaptive
+
+import (
+	"encoding/base64"
+	"fmt"
+	"math/rand"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/bpetok/internal/tokenizer/core"
+	"golang.org/x/text/unicode/norm"
+)
+
+func loadTestTokenizer(t *testing.T, opts ...core.Option) *core.Tokenizer {
+	t.Helper()
+	tok, err := core.Load(core.Files("../testdata/gpt2/vocab.json", "../testdata/gpt2/merges.txt"), opts...)
+	if err != nil {
+		t.Fatalf("load tokenizer: %v", err)
+	}
+	return tok
+}
+
+// pushPhases feeds input in phases of large and small chunks, returning everything emitted.
+func pushPhases(ae *AdaptiveEncoder, input []byte, r *rand.Rand) []int {
+	var out []int
+	for pos, phase := 0, 0; pos < len(input); phase++ {
+		for range 40 {
+			if pos >= len(input) {
+				break
+			}
+			n := 1 + r.Intn(6)
+			if phase%2 == 0 {
+				n = 2000 + r.Intn(3000)
+			}
+			end := min(pos+n, len(input))
+			out = append(out, ae.Push(input[pos:end])...)
+			pos = end
+		}
+	}
+	return append(out, ae.Flush()...)
+}
+
+func TestAdaptive_MatchesOfflineAcrossSwitches(t *testing.T) {
+	tok := loadTestTokenizer(t)
+	input, err := os.ReadFile("../testdata/gpt2/bench_corpus.txt")
+	if err != nil {
+		t.Fatalf("read corpus: %v", err)
+	}
+	input = input[:min(len(input), 200<<10)]
+	want := tok.EncodeOffline(input, nil)
+
+	r := rand.New(rand.NewSource(21))
+	ae := NewAdaptiveEncoder(tok)
+	for stream := range 3 {
+		got := pushPhases(ae, input, r)
+		if !reflect.DeepEqual(got, want) {
+			i := 0
+			for i < len(got) && i < len(want) && got[i] == want[i] {
+				i++
+			}
+			t.Fatalf("stream %d: output differs from EncodeOffline at token %d of %d", stream, i, len(want))
+		}
+	}
+	if ae.Switches() < 4 {
+		t.Fatalf("expected the encoder to switch both ways, got %d switches", ae.Switches())
+	}
+}
+
+// With pairs of adjacent letters ranked from the end of the alphabet down, one more letter re-pairs a
+// whole run. Both engines commit only at junctions no merge can join, so the bytes handed over at a switch
+// still start at a boundary the whole stream has.
+func TestAdaptive_RightToLeftMergesAcrossSwitches(t *testing.T) {
+	var sb strings.Builder
+	for id := range 256 {
+		fmt.Fprintf(&sb, "%s %d\n", base64.StdEncoding.EncodeToString([]byte{byte(id)}), id)
+	}
+	for i := range 25 {
+		fmt.Fprintf(&sb, "%s %d\n", base64.StdEncoding.EncodeToString([]byte{'y' - byte(i), 'z' - byte(i)}), 256+i)
+	}
+	tok, err := core.LoadTokenizerFromTiktokenBytes([]byte(sb.String()))
+	if err != nil {
+		t.Fatalf("load: %v", err)
+	}
+
+	r := rand.New(rand.NewSource(4))
+	var input []byte
+	for len(input) < 60<<10 {
+		start := r.Intn(26)
+		for c := start; c < min(26, start+1+r.Intn(26)); c++ {
+			input = append(input, 'a'+byte(c))
+		}
+	}
+	want := tok.EncodeOffline(input, nil)
+
+	ae := NewAdaptiveEncoder(tok, WithThresholds(8, 32))
+	var got []int
+	for pos, push := 0, 0; pos < len(input); push++ {
+		n := 1 + r.Intn(6)
+		if push%120 >= 100 {
+			n = 500 + r.Intn(1000)
+		}
+		end := min(pos+n, len(input))
+		got = append(got, ae.Push(input[pos:end])...)
+		pos = end
+	}
+	got = append(got, ae.Flush()...)
+
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("output differs from EncodeOffline: got %d tokens, want %d", len(got), len(want))
+	}
+	if ae.Switches() < 4 {
+		t.Fatalf("expected the encoder to switch both ways, got %d switches", ae.Switches())
+	}
+}
+
+func TestAdaptive_Thresholds(t *testing.T) {
+	tok := loadTestTokenizer(t)
+
+	ae := NewAdaptiveEncoder(tok, WithThresholds(10, 20))
+	for range minObservations + 20 {
+		ae.Push([]byte("a b "))
+	}
+	if !ae.Incremental() {
+		t.Fatalf("small chunks should have moved the encoder to the incremental engine")
+	}
+	for range minObservations + 20 {
+		ae.Push([]byte(" a fairly long chunk of text, longer than twenty bytes"))
+	}
+	if ae.Incremental() {
+		t.Fatalf("large chunks should have moved the encoder back to the naive engine")
+	}
+	ae.Flush()
+}
+
+func TestAdaptive_NormalizesOnce(t *testing.T) {
+	tok := loadTestTokenizer(t, core.WithNormalization(core.NormalizeNFC))
+	input := []byte("Zoë and Chloë went to the crème brûlée stand. \xff ")
+	for range 6 {
+		input = append(input, input...)
+	}
+	want := tok.EncodeOffline(norm.NFC.Bytes(input), nil)
+
+	r := rand.New(rand.NewSource(4))
+	ae := NewAdaptiveEncoder(tok, WithThresholds(10, 20), WithWarnings(true))
+	var got []int
+	for pos := 0; pos < len(input); {
+		end := min(pos+1+r.Intn(12), len(input))
+		got = append(got, ae.Push(input[pos:end])...)
+		pos = end
+	}
+	got = append(got, ae.Flush()...)
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("output differs from EncodeOffline over the NFC input")
+	}
+	if ae.Switches() == 0 {
+		t.Fatalf("expected at least one switch")
+	}
+	if w := ae.Warnings(); len(w) != 1 || w[0].Count != 64 {
+		t.Fatalf("expected each invalid byte counted once, got %+v", w)
+	}
+}
@@ -0,0 +1,49 @@
+package streaming_encoder_incremental
+
+import (
+	"os"
+	"testing"
+
+	"github.com/bpetok/internal/tokenizer/core"
+)
+
+// BenchmarkStreamingV2_Push streams the corpus in small chunks through one encoder, with a fresh output
+// slice per call and in zero-copy mode. allocs/op is per stream; CommitRankAware emits on nearly every
+// Push, which is where the output slices add up.
+func BenchmarkStreamingV2_Push(b *testing.B) {
+	tok, err := core.LoadTokenizerFromFiles("../testdata/gpt2/vocab.json", "../testdata/gpt2/merges.txt")
+	if err != nil {
+		b.Fatalf("load tokenizer: %v", err)
+	}
+	input, err := os.ReadFile("../testdata/gpt2/bench_corpus.txt")
+	if err != nil {
+		b.Fatalf("read corpus: %v", err)
+	}
+	input = input[:min(len(input), 256<<10)]
+
+	const chunkSize = 64
+	for _, policy := range []struct {
+		name string
+		p    CommitPolicy
+	}{{"reserve", CommitReserve}, {"rank-aware", CommitRankAware}} {
+		for _, zeroCopy := range []bool{false, true} {
+			name := policy.name + "/alloc"
+			if zeroCopy {
+				name = policy.name + "/zero-copy"
+			}
+			b.Run(name, func(b *testing.B) {
+				se := NewStreamingEncoderV2(tok, WithCommitPolicy(policy.p), WithZeroCopyOutput(zeroCopy))
+				b.SetBytes(int64(len(input)))
+				b.ReportAllocs()
+				b.ResetTimer()
+
+				for i := 0; i < b.N; i++ {
+					for pos := 0; pos < len(input); pos += chunkSize {
+						_ = se.Push(input[pos:min(pos+chunkSize, len(input))])
+					}
+					_ = se.Flush()
+				}
+			})
+		}
+	}
+}
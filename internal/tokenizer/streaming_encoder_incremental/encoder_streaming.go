@@ -1,15 +1,24 @@
 package streaming_encoder_incremental
 
 import (
+	"unicode/utf8"
+
 	"github.com/bpetok/internal/tokenizer/core"
 )
 
-type heapInterface interface {
-	Push(c mergeCandidate)
-	Pop() (mergeCandidate, bool)
-	Empty() bool
-	Reset()
-}
+// reserveBatch is how many times its tail reserve the pending run grows to before Push commits the part in
+// front of it, see commitReserved. Each commit re-encodes the run, so this keeps that linear overall.
+const reserveBatch = 4
+
+// defaultLongRun is the pending run size that triggers a long run commit, big enough that ordinary text
+// never gets there and small enough that a multi-megabyte blob doesn't pin its whole list in memory.
+const defaultLongRun = 64 << 10
+
+// defaultParallelPush is the chunk size from which push encodes in parallel, see pushParallel.
+const defaultParallelPush = 1 << 20
+
+// compactMin is the node array length below which dead and committed slots are left alone, see compact.
+const compactMin = 4 << 10
 
 type StreamingEncoderV2 struct {
 	tok *core.Tokenizer
@@ -20,28 +29,105 @@ type StreamingEncoderV2 struct {
 	live    []uint32
 	liveGen uint32
 
-	heap heapInterface
+	heap MergeQueue
 
 	head int
 	tail int
-
-	outBuf           []int
-	tailReserve      int
+	// nodes counts the live nodes in the list, see compact
+	nodes int
+
+	outBuf      []int
+	zeroCopy    bool
+	tailReserve int
+	// commitPolicy is set by WithCommitPolicy. joinChecked is how many pending bytes from the head have had
+	// their junctions checked by commitJoinFree, joinBuf its scratch buffer.
+	commitPolicy CommitPolicy
+	joinChecked  int
+	joinBuf      []byte
+	// pending is the number of input bytes held in the list, longRun is the size at which a single
+	// uncommitted run gets force-committed (0 disables it), see commitLongRun. maxPending is the bound set by
+	// WithMaxPendingBytes, 0 for none, see commitForced.
+	pending          int
+	longRun          int
+	maxPending       int
 	syntheticLengths map[int]int
+
+	// streamBytes counts the bytes merged since the last Flush, revision the results handed out, see
+	// PushResult
+	streamBytes int
+	revision    uint64
+	provBuf     []int
+	// shown is the provisional tail the last PushPatch left on display
+	shown []int
+	// parallelPush is the chunk size from which push encodes in parallel, 0 for never, see pushParallel;
+	// maxChunk is the most push feeds the merge loop at once, 0 for no limit, see WithMaxChunk
+	parallelPush int
+	maxChunk     int
+	// offsets is set by WithOffsets, spanBuf holds the spans in zero-copy mode
+	offsets bool
+	spanBuf []core.Span
+
+	// utf8 and capped feed warnings, they only run with WithWarnings on
+	utf8     core.UTF8Tracker
+	capped   core.CappedRankTracker
+	warnings core.Warnings
+	stats    Stats
+	// checkInvariants is set by WithInvariantChecks, see checkList
+	checkInvariants bool
+	// onMerge is set by WithOnMerge
+	onMerge func(left, right, merged, pos int)
+
+	// normalizer, when set, rewrites the input before it reaches appendBytes. It carries an unfinished
+	// character + combining marks across Push calls.
+	normalizer *core.StreamNormalizer
+	// prefixSpace puts a space in front of the stream's first normalized byte unless it is one, see
+	// core.LoadOptions.AddPrefixSpace; started is set once that byte went through.
+	prefixSpace bool
+	started     bool
+
+	// splits holds the input of a tokenizer with a pre-tokenizer that isn't final yet, see pushSplits, nil
+	// for one without. The list stays empty for those.
+	splits *core.SplitBuffer
+
+	// special, when set, finds the special tokens to emit as their IDs, see WithAllowedSpecial. held is
+	// the normalized input that may still turn out to start one, not pushed yet.
+	special *core.SpecialMatcher
+	held    []byte
 }
 
-func NewStreamingEncoderV2(tok *core.Tokenizer) *StreamingEncoderV2 {
-	tok.UseUnicodeInitTokens = true
+// NewStreamingEncoderV2 returns an encoder over tok. It normalizes its input and adds a prefix space the way
+// tok was loaded to, unless WithNormalization or WithPrefixSpace say otherwise.
+func NewStreamingEncoderV2(tok *core.Tokenizer, opts ...Option) *StreamingEncoderV2 {
 	maxRank := tok.GetMaxRank()
-	return &StreamingEncoderV2{
-		tok:         tok,
-		head:        -1,
-		tail:        -1,
-		liveGen:     1,
-		outBuf:      make([]int, 0, 128),
-		heap:        newMergeHeapWithMaxRank(maxRank),
-		tailReserve: tok.MaxTokenByteLen - 1,
-	}
+	se := &StreamingEncoderV2{
+		tok:          tok,
+		head:         -1,
+		tail:         -1,
+		liveGen:      1,
+		outBuf:       make([]int, 0, 128),
+		heap:         newMergeHeapWithMaxRank(maxRank),
+		tailReserve:  tok.MaxTokenByteLen - 1,
+		longRun:      defaultLongRun,
+		parallelPush: defaultParallelPush,
+		normalizer:   core.NewStreamNormalizer(tok.Normalization()),
+		prefixSpace:  tok.AddPrefixSpace(),
+		splits:       core.NewSplitBuffer(tok.PreTokenization()),
+	}
+	for _, opt := range opts {
+		opt(se)
+	}
+	return se
+}
+
+// NewStreamingEncoderV2WithNormalizer returns an encoder that normalizes its input with n before merging.
+// Output matches EncodeOffline over the normalized concatenation of every chunk.
+func NewStreamingEncoderV2WithNormalizer(tok *core.Tokenizer, n core.Normalization) *StreamingEncoderV2 {
+	return NewStreamingEncoderV2(tok, WithNormalization(n))
+}
+
+// Feed implements core.Encoder, it is Push under the name the public contract uses.
+func (se *StreamingEncoderV2) Feed(chunk []byte) []int {
+	return se.Push(chunk)
 }
 
 func (se *StreamingEncoderV2) Push(chunk []byte) []int {
@@ -49,13 +135,92 @@ func (se *StreamingEncoderV2) Push(chunk []byte) []int {
 		return nil
 	}
 
+	if se.warnings.Enabled() {
+		se.utf8.Feed(chunk, se.invalidUTF8)
+	}
+
+	if se.normalizer != nil {
+		chunk = se.normalizer.Push(chunk)
+	}
+
+	return se.finishOut(se.feed(se.prefix(chunk), se.newOut(), true))
+}
+
+// prefix adds the prefix space to the stream's first normalized bytes, if it is on.
+func (se *StreamingEncoderV2) prefix(chunk []byte) []byte {
+	if !se.prefixSpace || se.started || len(chunk) == 0 {
+		return chunk
+	}
+	se.started = true
+	return core.PrefixSpace(chunk)
+}
+
+// feed routes normalized input to push, through the special token matcher when there is one. more is
+// false at the end of the stream.
+func (se *StreamingEncoderV2) feed(chunk []byte, out []int, more bool) []int {
+	if se.special == nil {
+		return se.push(chunk, out)
+	}
+	return se.pushSpecial(chunk, out, more)
+}
+
+// pushSpecial is push for an encoder with allowed special tokens. Tokens never span one, so the text
+// before a special token is committed whole, then its ID; bytes that could be the start of one are held
+// until the next chunk decides.
+func (se *StreamingEncoderV2) pushSpecial(chunk []byte, out []int, more bool) []int {
+	se.held = append(se.held, chunk...)
+	from := 0
+	for {
+		m, held := se.special.Find(se.held, from, more)
+		if m.Len == 0 {
+			out = se.push(se.held[from:held], out)
+			from = held
+			break
+		}
+		out = se.push(se.held[from:m.Start], out)
+		out = se.commitAll(out)
+		out = append(out, m.ID)
+		se.streamBytes += m.Len
+		from = m.Start + m.Len
+	}
+	se.held = se.held[:copy(se.held, se.held[from:])]
+	return out
+}
+
+// push runs the merge loop over bytes that are final, i.e. already normalized, and appends whatever it
+// commits to out. A chunk over maxChunk goes through in pieces, as if pushed one by one, unless the tail
+// reserve is 0, whose output would then differ.
+func (se *StreamingEncoderV2) push(chunk []byte, out []int) []int {
+	if se.maxChunk > 0 && se.tailReserve > 0 {
+		for len(chunk) > se.maxChunk {
+			out = se.pushRound(chunk[:se.maxChunk], out)
+			chunk = chunk[se.maxChunk:]
+		}
+	}
+	return se.pushRound(chunk, out)
+}
+
+// pushRound is one round of push's merge loop, over the whole of chunk.
+func (se *StreamingEncoderV2) pushRound(chunk []byte, out []int) []int {
+	if len(chunk) == 0 {
+		return out
+	}
+	se.streamBytes += len(chunk)
+	if se.splits != nil {
+		return se.pushSplits(chunk, out)
+	}
+
 	se.heap.Reset()
+	if se.parallelPush > 0 && len(chunk) >= se.parallelPush {
+		out, chunk = se.pushParallel(chunk, out)
+	}
+	se.compact()
 
 	oldTail := se.tail
 
 	newNodes := se.appendBytes(chunk)
 	if len(newNodes) == 0 {
-		return nil
+		return out
 	}
 
 	if oldTail != -1 {
@@ -66,8 +231,6 @@ func (se *StreamingEncoderV2) Push(chunk []byte) []int {
 
 	se.runMerges()
 
-	out := []int{}
-
 	se.commitStablePrefix(&out)
 
 	if se.tail != -1 {
@@ -83,39 +246,150 @@ func (se *StreamingEncoderV2) Push(chunk []byte) []int {
 		}
 	}
 
-	if len(out) == 0 {
-		return nil
+	se.commitJoinFree(&out)
+	se.commitReserved(&out)
+	se.commitLongRun(&out)
+	se.commitForced(&out)
+
+	if se.checkInvariants {
+		se.checkList("push")
 	}
 	return out
 }
 
+// Flush emits everything still pending and leaves the encoder ready for a new stream.
+// The pending tokens are speculative (they were merged before the rest of the stream was known), so we
+// re-encode their bytes rather than emitting them as-is.
 func (se *StreamingEncoderV2) Flush() []int {
-	if se.head == -1 {
-		return nil
+	if se.warnings.Enabled() {
+		se.utf8.Finish(se.invalidUTF8)
 	}
 
-	out := make([]int, 0, 16)
-	se.commitPrefix(&out)
+	out := se.finishOut(se.flushPending())
+	se.capped.Reset()
+	se.streamBytes = 0
+	se.started = false
+	se.shown = se.shown[:0]
+	return out
+}
 
-	buf := make([]byte, 0, 64)
-	for idx := se.head; idx != -1; idx = se.next[idx] {
-		tokID := se.tokens[idx]
-		buf = append(buf, se.tok.RevVocab[tokID]...)
+// FlushSoft emits everything held back, like Flush, but the stream goes on: the next Push continues it
+// as if the input had a hard split here, so no token spans the checkpoint. The prefix space isn't added
+// again and stream offsets keep counting. It is for callers that need output at record boundaries without
+// starting a new stream for each record.
+func (se *StreamingEncoderV2) FlushSoft() []int {
+	return se.finishOut(se.flushPending())
+}
+
+// flushPending pushes what the normalizer carries as the end of the input and commits everything pending.
+func (se *StreamingEncoderV2) flushPending() []int {
+	out := se.newOut()
+	var rest []byte
+	if se.normalizer != nil {
+		rest = se.normalizer.Flush()
+	}
+	out = se.feed(se.prefix(rest), out, false)
+	return se.commitAll(out)
+}
+
+// commitAll encodes the pending bytes offline, appends them to out and empties the list.
+func (se *StreamingEncoderV2) commitAll(out []int) []int {
+	if se.head == -1 && se.pending == 0 {
+		return out
 	}
 
+	buf := se.pendingBytes()
 	if len(buf) > 0 {
-		rem := se.tok.EncodeOffline(buf, nil)
-		out = append(out, rem...)
+		out = append(out, se.tok.EncodeOffline(buf, nil)...)
 	}
+	se.resetList()
+	return out
+}
 
-	se.head = -1
-	se.tail = -1
+// TakePending ends the stream without encoding what is held back: it returns the bytes of the pending
+// tokens plus anything the normalizer carries (normalized), and resets the encoder as Flush would. Pushing
+// them into another encoder continues the stream exactly where this one's output stopped, as long as that
+// one doesn't add a prefix space of its own (see WithPrefixSpace): the bytes already have it.
+func (se *StreamingEncoderV2) TakePending() []byte {
+	buf := append(se.pendingBytes(), se.held...)
+	se.held = se.held[:0]
+	if se.normalizer != nil {
+		buf = append(buf, se.normalizer.Flush()...)
+	}
+	buf = se.prefix(buf)
+	se.utf8 = core.UTF8Tracker{}
+	se.capped.Reset()
+	se.streamBytes = 0
+	se.started = false
+	se.shown = se.shown[:0]
+	se.resetList()
+	return buf
+}
 
-	se.heap = newMergeHeap()
+// Reset abandons the stream without emitting anything: the pending tokens, the bytes held for the special
+// token matcher and the normalizer's carry are dropped, and the encoder is ready for a new stream. Its
+// arrays, heap and buffers are kept for reuse, so a pooled encoder doesn't allocate them again. Warnings
+// are kept too, like across Flush.
+func (se *StreamingEncoderV2) Reset() {
+	se.held = se.held[:0]
+	se.shown = se.shown[:0]
+	if se.normalizer != nil {
+		se.normalizer.Reset()
+	}
+	se.utf8 = core.UTF8Tracker{}
+	se.capped.Reset()
+	se.streamBytes = 0
+	se.started = false
+	se.resetList()
+	se.tokens = se.tokens[:0]
+	se.prev = se.prev[:0]
+	se.next = se.next[:0]
+	se.live = se.live[:0]
+}
 
+// newOut returns the slice a call collects its output in: the reused buffer in zero-copy mode, a fresh
+// one otherwise.
+func (se *StreamingEncoderV2) newOut() []int {
+	if se.zeroCopy {
+		return se.outBuf[:0]
+	}
+	return []int{}
+}
+
+// finishOut keeps a grown buffer for the next call and maps empty output to nil. It is where the output is
+// checked for capped ranks.
+func (se *StreamingEncoderV2) finishOut(out []int) []int {
+	if se.warnings.Enabled() && se.tok.HasCappedRanks() {
+		se.capped.Feed(se.tok, out, se.cappedRank)
+	}
+	if se.zeroCopy {
+		se.outBuf = out[:0]
+	}
+	if len(out) == 0 {
+		return nil
+	}
 	return out
 }
 
+// Warnings returns the non-fatal anomalies seen so far, nil unless WithWarnings is on. They accumulate
+// across streams until ResetWarnings is called.
+func (se *StreamingEncoderV2) Warnings() []core.Warning {
+	return se.warnings.List()
+}
+
+// ResetWarnings clears the recorded warnings.
+func (se *StreamingEncoderV2) ResetWarnings() {
+	se.warnings.Reset()
+}
+
+func (se *StreamingEncoderV2) invalidUTF8(off int64, b byte) {
+	se.warnings.Add(core.WarnInvalidUTF8, off, "invalid UTF-8 byte 0x%02x", b)
+}
+
+func (se *StreamingEncoderV2) cappedRank(off int64, left, right, rank int) {
+	se.warnings.Add(core.WarnCappedRank, off, "tokens %d and %d left unmerged, their merge has rank %d", left, right, rank)
+}
+
 func (se *StreamingEncoderV2) appendBytes(chunk []byte) []int {
 	if len(chunk) == 0 {
 		return nil
@@ -123,6 +397,8 @@ func (se *StreamingEncoderV2) appendBytes(chunk []byte) []int {
 
 	count := len(chunk)
 	start := len(se.tokens)
+	se.pending += count
+	se.nodes += count
 	end := start + count - 1
 
 	newIndices := make([]int, count)
@@ -136,7 +412,7 @@ func (se *StreamingEncoderV2) appendBytes(chunk []byte) []int {
 		idx := start + i
 		newIndices[i] = idx
 
-		se.tokens[idx] = se.tok.GetByteToInitialToken(chunk[i])
+		se.tokens[idx] = se.tok.GetByteToUnicodeToken(chunk[i])
 
 		se.liveGen++
 		se.live[idx] = se.liveGen
@@ -225,13 +501,14 @@ func (se *StreamingEncoderV2) maybeAddCandidate(i, j int) {
 		return
 	}
 
-	se.heap.Push(mergeCandidate{
+	se.heap.Push(MergeCandidate{
 		leftIndex:  i,
 		rightIndex: j,
 		rank:       rank,
 		liveLeft:   se.live[i],
 		liveRight:  se.live[j],
 	})
+	se.stats.HeapPushes++
 }
 
 func (se *StreamingEncoderV2) runMerges() {
@@ -242,16 +519,23 @@ func (se *StreamingEncoderV2) runMerges() {
 		}
 
 		if !se.isValidCandidate(cand) {
+			se.stats.StaleCandidates++
 			continue
 		}
 
+		if se.onMerge != nil {
+			se.reportMerge(cand)
+		}
 		se.performMerge(cand)
+		if se.checkInvariants {
+			se.checkList("merge")
+		}
 
 		se.updateFrontierAfterMerge(cand)
 	}
 }
 
-func (se *StreamingEncoderV2) isValidCandidate(c mergeCandidate) bool {
+func (se *StreamingEncoderV2) isValidCandidate(c MergeCandidate) bool {
 	i := c.leftIndex
 	j := c.rightIndex
 
@@ -286,7 +570,7 @@ func (se *StreamingEncoderV2) isValidCandidate(c mergeCandidate) bool {
 	return true
 }
 
-func (se *StreamingEncoderV2) performMerge(c mergeCandidate) {
+func (se *StreamingEncoderV2) performMerge(c MergeCandidate) {
 	i := c.leftIndex
 	j := c.rightIndex
 
@@ -301,12 +585,14 @@ func (se *StreamingEncoderV2) performMerge(c mergeCandidate) {
 	}
 
 	se.tokens[i] = mergedID
+	se.stats.Merges++
 	se.liveGen++
 	se.live[i] = se.liveGen
 
 	se.live[j] = 0
 	se.prev[j] = -1
 	se.next[j] = -1
+	se.nodes--
 
 	if k != -1 {
 		se.next[k] = i
@@ -328,7 +614,21 @@ func (se *StreamingEncoderV2) performMerge(c mergeCandidate) {
 	}
 }
 
-func (se *StreamingEncoderV2) updateFrontierAfterMerge(c mergeCandidate) {
+// reportMerge calls onMerge for a candidate about to be merged. Nodes don't know their offsets, so it
+// walks the list from the head to find it.
+func (se *StreamingEncoderV2) reportMerge(c MergeCandidate) {
+	merged, ok := se.tok.GetPairToken(se.tokens[c.leftIndex], se.tokens[c.rightIndex])
+	if !ok {
+		return
+	}
+	pos := se.streamBytes - se.pending
+	for idx := se.head; idx != c.leftIndex; idx = se.next[idx] {
+		pos += se.tokenLen(se.tokens[idx])
+	}
+	se.onMerge(se.tokens[c.leftIndex], se.tokens[c.rightIndex], merged, pos)
+}
+
+func (se *StreamingEncoderV2) updateFrontierAfterMerge(c MergeCandidate) {
 	i := c.leftIndex
 
 	k := se.prev[i]
@@ -339,6 +639,9 @@ func (se *StreamingEncoderV2) updateFrontierAfterMerge(c mergeCandidate) {
 	se.maybeAddCandidate(i, l)
 }
 
+// commitStablePrefix commits every token but the last straight from the list when the tail reserve is 0,
+// trading exactness for latency: a later chunk may still have merged into them. Other reserves commit
+// through commitReserved.
 func (se *StreamingEncoderV2) commitStablePrefix(out *[]int) {
 	if se.tailReserve > 0 {
 		return
@@ -347,24 +650,25 @@ func (se *StreamingEncoderV2) commitStablePrefix(out *[]int) {
 	se.commitPrefix(out)
 }
 
+// tokenLen is the byte length of a token in the list, which tests may fake with syntheticLengths.
+func (se *StreamingEncoderV2) tokenLen(tokID int) int {
+	if se.syntheticLengths != nil {
+		if len, ok := se.syntheticLengths[tokID]; ok {
+			return len
+		}
+	}
+	return se.tok.TokenLen(tokID)
+}
+
 func (se *StreamingEncoderV2) commitPrefix(out *[]int) {
 	if se.head == -1 {
 		return
 	}
 
-	getTokenLen := func(tokID int) int {
-		if se.syntheticLengths != nil {
-			if len, ok := se.syntheticLengths[tokID]; ok {
-				return len
-			}
-		}
-		return se.tok.TokenLen(tokID)
-	}
-
 	totalLen := 0
 	for idx := se.head; idx != -1; idx = se.next[idx] {
 		tokID := se.tokens[idx]
-		totalLen += getTokenLen(tokID)
+		totalLen += se.tokenLen(tokID)
 	}
 
 	committed := 0
@@ -373,7 +677,7 @@ func (se *StreamingEncoderV2) commitPrefix(out *[]int) {
 	idx := se.head
 	for idx != -1 {
 		tokID := se.tokens[idx]
-		tokLen := getTokenLen(tokID)
+		tokLen := se.tokenLen(tokID)
 
 		remainingAfter := totalLen - (committed + tokLen)
 
@@ -381,9 +685,12 @@ func (se *StreamingEncoderV2) commitPrefix(out *[]int) {
 			break
 		}
 
-		*out = append(*out, tokID)
+		*out = se.tok.AppendToken(*out, tokID)
 		committed += tokLen
 		lastCommitted = idx
+		se.pending -= tokLen
+		se.nodes--
+		se.joinChecked = max(se.joinChecked-tokLen, 0)
 
 		idx = se.next[idx]
 	}
@@ -404,3 +711,259 @@ func (se *StreamingEncoderV2) commitPrefix(out *[]int) {
 		se.tail = -1
 	}
 }
+
+// commitJoinFree commits, under CommitRankAware, everything in front of the last junction between pending
+// tokens that no merge can join. Nothing on either side of it can ever merge across it, so the bytes in
+// front encode the same on their own as in the whole stream; they are re-encoded offline, since the list's
+// tokens were merged before the rest of the stream arrived. Merges only remove junctions, so the ones
+// checked before stay joinable and the walk back from the tail stops at joinChecked.
+func (se *StreamingEncoderV2) commitJoinFree(out *[]int) {
+	if se.commitPolicy != CommitRankAware || se.head == -1 {
+		return
+	}
+
+	pos, cut, cutNode := se.pending, 0, -1
+	tailStart := pos - se.tok.TokenLen(se.tokens[se.tail])
+	for idx := se.tail; idx != se.head; idx = se.prev[idx] {
+		right := se.tok.TokenBytes(se.tokens[idx])
+		if pos -= len(right); pos <= se.joinChecked {
+			break
+		}
+		left := se.tok.TokenBytes(se.tokens[se.prev[idx]])
+		if !se.tok.CanJoin(left[len(left)-1], right[0]) {
+			cut, cutNode = pos, idx
+			break
+		}
+	}
+	se.joinChecked = max(se.joinChecked, tailStart)
+	if cutNode == -1 {
+		return
+	}
+
+	buf := se.joinBuf[:0]
+	for idx := se.head; idx != cutNode; idx = se.next[idx] {
+		buf = append(buf, se.tok.TokenBytes(se.tokens[idx])...)
+		se.nodes--
+	}
+	se.joinBuf = buf
+	for id := range se.tok.EncodeSeq(buf) {
+		*out = append(*out, id)
+	}
+
+	se.head = cutNode
+	se.prev[cutNode] = -1
+	se.pending -= cut
+	se.joinChecked -= cut
+}
+
+// commitReserved commits what lies in front of the tail reserve once the pending run has grown to
+// reserveBatch times it, so Push emits tokens as the stream goes rather than at Flush. The reserve is at
+// least CommitGuard bytes, and the cut goes at the last junction in front of it that no merge can join,
+// which is what keeps the commit exact, see commitCut. Output therefore comes in batches: nothing is
+// committed until reserveBatch*CommitGuard bytes are pending (4 KiB for GPT-2), then all but the reserve
+// and the bytes back to that junction at once. That is a known limitation of the default policy,
+// CommitRankAware commits at the last such junction without a reserve and emits about a word behind the
+// input. A tail reserve of 0 commits from the list directly instead, see commitStablePrefix.
+func (se *StreamingEncoderV2) commitReserved(out *[]int) {
+	if se.tailReserve <= 0 || se.head == -1 {
+		return
+	}
+	hold := max(se.tailReserve, se.tok.CommitGuard())
+	if se.pending < reserveBatch*hold {
+		return
+	}
+	se.commitCut(out, hold)
+}
+
+// commitLongRun commits a run that has grown past longRun bytes without anything being committed, e.g. a
+// base64 blob with no spaces, up to its last junction in front of the last CommitGuard bytes that no merge
+// can join, see commitCut. A run without such a junction stays pending; WithMaxPendingBytes bounds it.
+func (se *StreamingEncoderV2) commitLongRun(out *[]int) {
+	if se.longRun <= 0 || se.pending < se.longRun || se.head == -1 {
+		return
+	}
+	se.commitCut(out, se.tok.CommitGuard())
+}
+
+// commitForced brings the pending input back under maxPending once it is over. An exact commitCut keeping
+// half of it is tried first; when no junction that far back is safe, the run is encoded offline and its
+// oldest tokens are committed anyway, keeping about half of maxPending, and reported as a forced commit.
+// With a pre-tokenizer the held back split is cut at a character boundary instead, and its front encoded
+// on its own.
+func (se *StreamingEncoderV2) commitForced(out *[]int) {
+	if se.maxPending <= 0 || se.pending <= se.maxPending {
+		return
+	}
+	keep := se.maxPending / 2
+	if se.splits != nil {
+		buf := se.splits.Pending()
+		cut := len(buf) - keep
+		for cut > 0 && !utf8.RuneStart(buf[cut]) {
+			cut--
+		}
+		if cut == 0 {
+			return
+		}
+		se.forcedWarning(cut)
+		for id := range se.tok.EncodeSeq(buf[:cut]) {
+			*out = append(*out, id)
+		}
+		se.splits.SetPending(buf[cut:])
+		se.pending = se.splits.Len()
+		return
+	}
+
+	se.commitCut(out, keep)
+	if se.pending <= se.maxPending {
+		return
+	}
+
+	buf := se.pendingBytes()
+	ids := se.tok.EncodeOffline(buf, nil)
+	cut, consumed := 0, 0
+	for _, id := range ids[:len(ids)-1] {
+		n := se.tok.TokenLen(id)
+		if cut > 0 && consumed+n > len(buf)-keep {
+			break
+		}
+		cut++
+		consumed += n
+	}
+	if cut == 0 {
+		return
+	}
+	se.forcedWarning(consumed)
+	*out = append(*out, ids[:cut]...)
+
+	se.resetList()
+	se.compact()
+
+	newNodes := se.appendBytes(buf[consumed:])
+	se.seedAdjacency(newNodes)
+	se.runMerges()
+}
+
+// forcedWarning records a forced commit of the first n pending bytes.
+func (se *StreamingEncoderV2) forcedWarning(n int) {
+	off := int64(se.streamBytes - se.pending + n)
+	se.warnings.Add(core.WarnForcedCommit, off, "forced a commit with %d bytes pending (max %d)", se.pending, se.maxPending)
+}
+
+// commitCut commits the pending run up to the last junction at least hold bytes before its end that no
+// merge can join. Like commitJoinFree's, nothing on either side of such a junction can merge across it, so
+// the bytes in front encode offline to the IDs the whole stream does (the list head is always a committed
+// boundary); hold only decides how much stays pending. No byte count makes a cut exact on its own: ranks
+// that pair tokens up from the right can re-pair a run all the way back from its end when one byte is
+// appended. When no such junction lies in front of the hold, nothing is committed. The tail is then
+// rebuilt as a fresh list, which also drops the dead nodes that built up.
+func (se *StreamingEncoderV2) commitCut(out *[]int, hold int) {
+	buf := se.pendingBytes()
+	cut := min(len(buf)-hold, len(buf)-1)
+	for cut > 0 && se.tok.CanJoin(buf[cut-1], buf[cut]) {
+		cut--
+	}
+	if cut <= 0 {
+		return
+	}
+
+	for id := range se.tok.EncodeSeq(buf[:cut]) {
+		*out = append(*out, id)
+	}
+
+	se.resetList()
+	se.compact()
+
+	newNodes := se.appendBytes(buf[cut:])
+	se.seedAdjacency(newNodes)
+	se.runMerges()
+}
+
+// pushParallel commits the pending tokens and most of a large chunk with core.EncodeParallel: everything
+// up to the last junction in chunk that no merge can join, which, like commitJoinFree's, nothing after it
+// can change. It returns the rest of chunk, for push to go on with as usual.
+func (se *StreamingEncoderV2) pushParallel(chunk []byte, out []int) ([]int, []byte) {
+	cut := len(chunk) - 1
+	for cut > 0 && se.tok.CanJoin(chunk[cut-1], chunk[cut]) {
+		cut--
+	}
+	if cut == 0 {
+		return out, chunk
+	}
+
+	buf := append(se.pendingBytes(), chunk[:cut]...)
+	out = append(out, se.tok.EncodeParallel(buf)...)
+	se.resetList()
+	return out, chunk[cut:]
+}
+
+// pushSplits is push for a tokenizer with a pre-tokenizer. Tokens never cross its splits, so there's no
+// merge state to carry: whole splits are encoded offline as soon as no later input can change them, and
+// only the bytes after the last such split are held back.
+func (se *StreamingEncoderV2) pushSplits(chunk []byte, out []int) []int {
+	for id := range se.tok.EncodeSeq(se.splits.Push(chunk)) {
+		out = append(out, id)
+	}
+	se.pending = se.splits.Len()
+	se.commitForced(&out)
+	return out
+}
+
+// pendingBytes concatenates the bytes of every token still in the list, or the held back splits.
+func (se *StreamingEncoderV2) pendingBytes() []byte {
+	if se.splits != nil {
+		return append([]byte(nil), se.splits.Pending()...)
+	}
+	buf := make([]byte, 0, se.pending)
+	for idx := se.head; idx != -1; idx = se.next[idx] {
+		buf = append(buf, se.tok.TokenBytes(se.tokens[idx])...)
+	}
+	return buf
+}
+
+// compact reclaims the slots of merged-away and committed nodes once they outnumber the live ones, so the
+// node arrays stay proportional to the uncommitted tail however long the stream runs. The live nodes move
+// to the front in list order, which is index order since nodes are only appended and a merge keeps the left
+// index, so the copy can go in place. Arrays left far bigger than needed, e.g. after a long run was
+// committed, are swapped for smaller ones. Merge candidates hold indices, so it must only run with the
+// heap empty.
+func (se *StreamingEncoderV2) compact() {
+	if len(se.tokens) < compactMin || len(se.tokens) <= 2*se.nodes {
+		return
+	}
+
+	tokens, prev, next, live := se.tokens[:0], se.prev[:0], se.next[:0], se.live[:0]
+	if size := max(2*se.nodes, compactMin); cap(se.tokens) > 4*size {
+		tokens, prev, next = make([]int, 0, size), make([]int, 0, size), make([]int, 0, size)
+		live = make([]uint32, 0, size)
+	}
+	for idx := se.head; idx != -1; {
+		// read the successor first, the copy may overwrite this very slot
+		succ := se.next[idx]
+		n := len(tokens)
+		tokens = append(tokens, se.tokens[idx])
+		live = append(live, se.live[idx])
+		prev = append(prev, n-1)
+		next = append(next, n+1)
+		idx = succ
+	}
+
+	se.tokens, se.prev, se.next, se.live = tokens, prev, next, live
+	se.head, se.tail = -1, -1
+	if n := len(tokens); n > 0 {
+		next[n-1] = -1
+		se.head, se.tail = 0, n-1
+	}
+}
+
+// resetList forgets the pending tokens. The backing arrays are kept until the next compact.
+func (se *StreamingEncoderV2) resetList() {
+	se.head = -1
+	se.tail = -1
+	se.pending = 0
+	se.nodes = 0
+	se.joinChecked = 0
+	if se.splits != nil {
+		se.splits.Reset()
+	}
+	se.heap.Reset()
+}
@@ -1,17 +1,20 @@
 package streaming_encoder_incremental
 
 import (
+	"encoding/base64"
+	"fmt"
 	"github.com/bpetok/internal/tokenizer/core"
 	"math/rand"
 	"reflect"
+	"strings"
 	"testing"
 )
 
 type mockHeap struct {
-	items []mergeCandidate
+	items []MergeCandidate
 }
 
-func (h *mockHeap) Push(c mergeCandidate) {
+func (h *mockHeap) Push(c MergeCandidate) {
 
 	for _, existing := range h.items {
 		if existing.leftIndex == c.leftIndex && existing.rightIndex == c.rightIndex {
@@ -21,7 +24,7 @@ func (h *mockHeap) Push(c mergeCandidate) {
 	h.items = append(h.items, c)
 }
 
-func (h *mockHeap) Pop() (mergeCandidate, bool) { return mergeCandidate{}, false }
+func (h *mockHeap) Pop() (MergeCandidate, bool) { return MergeCandidate{}, false }
 func (h *mockHeap) Empty() bool                 { return len(h.items) == 0 }
 func (h *mockHeap) Reset() {
 	h.items = h.items[:0]
@@ -31,18 +34,18 @@ func (h *mockHeap) Reset() {
 
 // spyHeapForFrontierTest wraps an existing heap to spy on Push calls
 type spyHeapForFrontierTest struct {
-	wrapped heapInterface
-	onPush  func(mergeCandidate)
+	wrapped MergeQueue
+	onPush  func(MergeCandidate)
 }
 
-func (s *spyHeapForFrontierTest) Push(c mergeCandidate) {
+func (s *spyHeapForFrontierTest) Push(c MergeCandidate) {
 	if s.onPush != nil {
 		s.onPush(c)
 	}
 	s.wrapped.Push(c)
 }
 
-func (s *spyHeapForFrontierTest) Pop() (mergeCandidate, bool) {
+func (s *spyHeapForFrontierTest) Pop() (MergeCandidate, bool) {
 	return s.wrapped.Pop()
 }
 
@@ -94,7 +97,7 @@ func TestPerformMerge_MiddleOfList(t *testing.T) {
 		t.Fatalf("test assumption: 'h' 'e' must be mergeable")
 	}
 
-	cand := mergeCandidate{
+	cand := MergeCandidate{
 		leftIndex:  left,
 		rightIndex: right,
 		rank:       0,
@@ -144,7 +147,7 @@ func TestPerformMerge_AtTail(t *testing.T) {
 		t.Fatalf("test assumption: 'l' 'o' must be mergeable")
 	}
 
-	cand := mergeCandidate{
+	cand := MergeCandidate{
 		leftIndex:  left,
 		rightIndex: right,
 		liveLeft:   se.live[left],
@@ -187,7 +190,7 @@ func TestPerformMerge_TokenIdCorrectness(t *testing.T) {
 		t.Fatalf("'er' must be mergeable")
 	}
 
-	se.performMerge(mergeCandidate{
+	se.performMerge(MergeCandidate{
 		leftIndex:  indices[0],
 		rightIndex: indices[1],
 		liveLeft:   se.live[indices[0]],
@@ -214,7 +217,7 @@ func TestPerformMerge_NoOverDelete(t *testing.T) {
 		t.Skip("GPT2 merges might differ; skipping test if 'th' is not mergeable")
 	}
 
-	se.performMerge(mergeCandidate{
+	se.performMerge(MergeCandidate{
 		leftIndex:  indices[0],
 		rightIndex: indices[1],
 		liveLeft:   se.live[indices[0]],
@@ -310,10 +313,9 @@ func TestCommitPrefix_NoCommit(t *testing.T) {
 		t.Fatalf("load tokenizer: %v", err)
 	}
 
-	se := NewStreamingEncoderV2(tok)
+	se := NewStreamingEncoderV2(tok, WithTailReserve(2))
 
 	newSyntheticList(se, []int{1, 1, 1})
-	se.tailReserve = 2
 
 	out := []int{}
 	se.commitPrefix(&out)
@@ -332,10 +334,9 @@ func TestCommitPrefix_CommitOne(t *testing.T) {
 		t.Fatalf("load tokenizer: %v", err)
 	}
 
-	se := NewStreamingEncoderV2(tok)
+	se := NewStreamingEncoderV2(tok, WithTailReserve(2))
 
 	indices := newSyntheticList(se, []int{1, 1, 1, 1})
-	se.tailReserve = 2
 
 	out := []int{}
 	se.commitPrefix(&out)
@@ -357,10 +358,9 @@ func TestCommitPrefix_CommitMultiple(t *testing.T) {
 		t.Fatalf("load tokenizer: %v", err)
 	}
 
-	se := NewStreamingEncoderV2(tok)
+	se := NewStreamingEncoderV2(tok, WithTailReserve(2))
 
 	indices := newSyntheticList(se, []int{1, 1, 1, 1, 1})
-	se.tailReserve = 2
 
 	out := []int{}
 	se.commitPrefix(&out)
@@ -385,12 +385,10 @@ func TestCommitPrefix_CommitAllOnFlush(t *testing.T) {
 		t.Fatalf("load tokenizer: %v", err)
 	}
 
-	se := NewStreamingEncoderV2(tok)
+	se := NewStreamingEncoderV2(tok, WithTailReserve(0))
 
 	_ = newSyntheticList(se, []int{1, 1, 1})
 
-	se.tailReserve = 0
-
 	out := []int{}
 	se.commitPrefix(&out)
 
@@ -422,7 +420,7 @@ func TestUpdateFrontierAfterMerge_LeftNeighborMergeable(t *testing.T) {
 		t.Fatalf("'h','e' must be mergeable")
 	}
 
-	c := mergeCandidate{i, j, rank, se.live[i], se.live[j]}
+	c := MergeCandidate{i, j, rank, se.live[i], se.live[j]}
 	se.performMerge(c)
 
 	se.updateFrontierAfterMerge(c)
@@ -463,7 +461,7 @@ func TestUpdateFrontierAfterMerge_RightNeighborMergeable(t *testing.T) {
 		t.Fatalf("'i','n' must be mergeable (forming 'in')")
 	}
 
-	cIN := mergeCandidate{
+	cIN := MergeCandidate{
 		leftIndex:  idxI,
 		rightIndex: idxN,
 		rank:       rankIN,
@@ -481,7 +479,7 @@ func TestUpdateFrontierAfterMerge_RightNeighborMergeable(t *testing.T) {
 		t.Fatalf("'in','g' must be mergeable (forming 'ing')")
 	}
 
-	cING := mergeCandidate{
+	cING := MergeCandidate{
 		leftIndex:  idxI,
 		rightIndex: idxG,
 		rank:       rankING,
@@ -532,7 +530,7 @@ func TestUpdateFrontierAfterMerge_NoPushIfNotMergeable(t *testing.T) {
 		t.Fatalf("'e','r' must be mergeable")
 	}
 
-	c := mergeCandidate{i, j, rank, se.live[i], se.live[j]}
+	c := MergeCandidate{i, j, rank, se.live[i], se.live[j]}
 	se.performMerge(c)
 
 	_, ok = tok.GetPairRank(se.tokens[i], se.tokens[l])
@@ -562,7 +560,7 @@ func TestUpdateFrontierAfterMerge_LiveVersionCaptured(t *testing.T) {
 	j := nodes[2]
 
 	rank, _ := tok.GetPairRank(se.tokens[i], se.tokens[j])
-	c := mergeCandidate{i, j, rank, se.live[i], se.live[j]}
+	c := MergeCandidate{i, j, rank, se.live[i], se.live[j]}
 
 	se.performMerge(c)
 
@@ -597,7 +595,7 @@ func TestUpdateFrontierAfterMerge_NoUnexpectedDuplicates(t *testing.T) {
 	j := nodes[2]
 
 	rank, _ := tok.GetPairRank(se.tokens[i], se.tokens[j])
-	c := mergeCandidate{i, j, rank, se.live[i], se.live[j]}
+	c := MergeCandidate{i, j, rank, se.live[i], se.live[j]}
 
 	se.performMerge(c)
 
@@ -624,7 +622,7 @@ func TestPerformMerge_Simple(t *testing.T) {
 		t.Fatalf("test assumption: 'er' should be mergeable")
 	}
 
-	c := mergeCandidate{
+	c := MergeCandidate{
 		leftIndex:  i,
 		rightIndex: j,
 		rank:       0,
@@ -659,7 +657,7 @@ func TestPerformMerge_LiveVersionUpdated(t *testing.T) {
 	rightTok := se.tokens[j]
 	mergedID, _ := se.tok.GetPairToken(leftTok, rightTok)
 
-	c := mergeCandidate{
+	c := MergeCandidate{
 		leftIndex:  i,
 		rightIndex: j,
 		rank:       0,
@@ -705,7 +703,7 @@ func TestPerformMerge_MiddleThreeNodes(t *testing.T) {
 		t.Fatalf("test assumption: 'i','j' must be mergeable in GPT2")
 	}
 
-	c := mergeCandidate{
+	c := MergeCandidate{
 		leftIndex:  i,
 		rightIndex: j,
 		rank:       0,
@@ -745,7 +743,7 @@ func TestPerformMerge_UpdatesHead(t *testing.T) {
 	rightTok := se.tokens[j]
 	mergedID, _ := se.tok.GetPairToken(leftTok, rightTok)
 
-	c := mergeCandidate{
+	c := MergeCandidate{
 		leftIndex:  i,
 		rightIndex: j,
 		rank:       0,
@@ -778,7 +776,7 @@ func TestPerformMerge_UpdatesTail(t *testing.T) {
 	rightTok := se.tokens[j]
 	mergedID, _ := tok.GetPairToken(leftTok, rightTok)
 
-	c := mergeCandidate{
+	c := MergeCandidate{
 		leftIndex:  i,
 		rightIndex: j,
 		rank:       0,
@@ -829,7 +827,7 @@ func TestPerformMerge_MultipleMerges(t *testing.T) {
 			continue
 		}
 
-		c1 := mergeCandidate{i1, j1, rank1, se.live[i1], se.live[j1]}
+		c1 := MergeCandidate{i1, j1, rank1, se.live[i1], se.live[j1]}
 		se.performMerge(c1)
 
 		if se.next[i1] != nodes[2] {
@@ -843,7 +841,7 @@ func TestPerformMerge_MultipleMerges(t *testing.T) {
 
 		found = true
 
-		c2 := mergeCandidate{i2, j2, rank2, se.live[i2], se.live[j2]}
+		c2 := MergeCandidate{i2, j2, rank2, se.live[i2], se.live[j2]}
 		se.performMerge(c2)
 
 		if se.next[i2] != -1 {
@@ -871,7 +869,7 @@ func TestIsValidCandidate_Valid(t *testing.T) {
 		t.Fatalf("test assumption broken: 'er' must be mergeable in GPT-2")
 	}
 
-	cand := mergeCandidate{
+	cand := MergeCandidate{
 		leftIndex:  i,
 		rightIndex: j,
 		rank:       rank,
@@ -891,7 +889,7 @@ func TestIsValidCandidate_LiveVersionMismatch(t *testing.T) {
 	rightTok := se.tokens[j]
 	rank, _ := se.tok.GetPairRank(leftTok, rightTok)
 
-	cand := mergeCandidate{
+	cand := MergeCandidate{
 		leftIndex:  i,
 		rightIndex: j,
 		rank:       rank,
@@ -914,7 +912,7 @@ func TestIsValidCandidate_AdjacencyMismatch(t *testing.T) {
 	rightTok := se.tokens[j]
 	rank, _ := se.tok.GetPairRank(leftTok, rightTok)
 
-	cand := mergeCandidate{
+	cand := MergeCandidate{
 		leftIndex:  i,
 		rightIndex: j,
 		rank:       rank,
@@ -936,7 +934,7 @@ func TestIsValidCandidate_RankMismatch(t *testing.T) {
 	rightTok := se.tokens[j]
 	rank, _ := se.tok.GetPairRank(leftTok, rightTok)
 
-	cand := mergeCandidate{
+	cand := MergeCandidate{
 		leftIndex:  i,
 		rightIndex: j,
 		rank:       rank,
@@ -958,7 +956,7 @@ func TestIsValidCandidate_DeadNode(t *testing.T) {
 	rightTok := se.tokens[j]
 	rank, _ := se.tok.GetPairRank(leftTok, rightTok)
 
-	cand := mergeCandidate{
+	cand := MergeCandidate{
 		leftIndex:  i,
 		rightIndex: j,
 		rank:       rank,
@@ -982,7 +980,7 @@ func TestIsValidCandidate_PairNoLongerMergeable(t *testing.T) {
 	rightTok := se.tokens[j]
 	rank, _ := se.tok.GetPairRank(leftTok, rightTok)
 
-	cand := mergeCandidate{
+	cand := MergeCandidate{
 		leftIndex:  i,
 		rightIndex: j,
 		rank:       rank,
@@ -1604,7 +1602,7 @@ func TestStreaming_FrontierConsistency(t *testing.T) {
 
 	spyHeap := &spyHeapForFrontierTest{
 		wrapped: originalHeap,
-		onPush: func(c mergeCandidate) {
+		onPush: func(c MergeCandidate) {
 			seen[[2]int{c.leftIndex, c.rightIndex}] = true
 		},
 	}
@@ -1709,8 +1707,7 @@ func TestStreaming_TailReserveSweep(t *testing.T) {
 	input := []byte("The quick brown fox jumped over the log while thinking about tokens")
 
 	for tailRes := 0; tailRes <= 10; tailRes++ {
-		se := NewStreamingEncoderV2(tok)
-		se.tailReserve = tailRes
+		se := NewStreamingEncoderV2(tok, WithTailReserve(tailRes))
 
 		// random push pattern
 		out := []int{}
@@ -1767,6 +1764,80 @@ func TestStreaming_Stress1MB(t *testing.T) {
 	}
 }
 
+func TestStreaming_ResetKeepsBuffers(t *testing.T) {
+	tok, err := core.Load(core.Files("../testdata/gpt2/vocab.json", "../testdata/gpt2/merges.txt"),
+		core.WithNormalization(core.NormalizeNFC), core.WithAddPrefixSpace(true))
+	if err != nil {
+		t.Fatalf("load tokenizer: %v", err)
+	}
+	se := NewStreamingEncoderV2(tok, WithWarnings(true))
+
+	// an abandoned stream ending in a normalizer carry and a partial character
+	se.Push([]byte("an abandoned request that never finished cafe"))
+	se.Push([]byte("\xe6\x9d"))
+	capTokens := cap(se.tokens)
+	se.Reset()
+
+	if se.pending != 0 || se.head != -1 || len(se.tokens) != 0 || cap(se.tokens) != capTokens {
+		t.Fatalf("reset left pending=%d head=%d len=%d cap=%d", se.pending, se.head, len(se.tokens),
+			cap(se.tokens))
+	}
+	if out := se.Flush(); len(out) != 0 {
+		t.Fatalf("flush after reset emitted %v", out)
+	}
+
+	input := []byte("a fresh stream")
+	var out []int
+	for i := 0; i < len(input); i += 3 {
+		out = append(out, se.Push(input[i:min(i+3, len(input))])...)
+	}
+	out = append(out, se.Flush()...)
+	if want := tok.EncodeOffline(tok.Prepare(input), nil); !reflect.DeepEqual(out, want) {
+		t.Fatalf("after reset: got %v, want %v", out, want)
+	}
+	if w := se.Warnings(); len(w) != 0 {
+		t.Fatalf("the abandoned partial character was reported: %v", w)
+	}
+}
+
+func TestStreaming_FlushSoftSplitsRecords(t *testing.T) {
+	tok, err := core.Load(core.Files("../testdata/gpt2/vocab.json", "../testdata/gpt2/merges.txt"),
+		core.WithNormalization(core.NormalizeNFC), core.WithAddPrefixSpace(true))
+	if err != nil {
+		t.Fatalf("load tokenizer: %v", err)
+	}
+	records := []string{"first record ends in cafe", "́ but the accent starts the next", "{\"id\": 3}"}
+
+	for _, opts := range [][]Option{nil, {WithAllowedSpecial(core.AllSpecial)}, {WithCommitPolicy(CommitRankAware)}} {
+		se := NewStreamingEncoderV2(tok, opts...)
+		var got, want []int
+		for i, r := range records {
+			in := []byte(r)
+			if i == 0 {
+				in = core.PrefixSpace(in)
+			}
+			want = append(want, tok.EncodeOffline(core.NormalizeNFC.Apply(in), nil)...)
+
+			for pos := 0; pos < len(r); pos += 4 {
+				got = append(got, se.Push([]byte(r[pos:min(pos+4, len(r))]))...)
+			}
+			got = append(got, se.FlushSoft()...)
+			if se.PendingBytes() != 0 {
+				t.Fatalf("%d bytes pending after FlushSoft", se.PendingBytes())
+			}
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Fatalf("got %v, want %v", got, want)
+		}
+		if se.streamBytes == 0 {
+			t.Fatalf("FlushSoft ended the stream")
+		}
+		if out := se.Flush(); len(out) != 0 {
+			t.Fatalf("Flush after FlushSoft emitted %v", out)
+		}
+	}
+}
+
 func intSliceToBytes(xs []int) []byte {
 	b := make([]byte, len(xs)*4)
 	for i, v := range xs {
@@ -1845,3 +1916,70 @@ func newSyntheticList(se *StreamingEncoderV2, lens []int) (indices []int) {
 
 	return indices
 }
+
+// rightToLeftTokenizer builds a rank file tokenizer over the 256 single bytes plus the pairs of adjacent
+// letters ranked from the end of the alphabet down: yz first, ab last. Every pair is one merge deep, yet
+// appending a letter to a run re-pairs the whole run, so no hold-back of any length makes a cut exact.
+func rightToLeftTokenizer(t *testing.T) *core.Tokenizer {
+	t.Helper()
+
+	var sb strings.Builder
+	for id := range 256 {
+		fmt.Fprintf(&sb, "%s %d\n", base64.StdEncoding.EncodeToString([]byte{byte(id)}), id)
+	}
+	for i := range 25 {
+		pair := []byte{'y' - byte(i), 'z' - byte(i)}
+		fmt.Fprintf(&sb, "%s %d\n", base64.StdEncoding.EncodeToString(pair), 256+i)
+	}
+	tok, err := core.LoadTokenizerFromTiktokenBytes([]byte(sb.String()))
+	if err != nil {
+		t.Fatalf("load: %v", err)
+	}
+	return tok
+}
+
+// letterRuns returns n bytes of runs of adjacent letters with random starts and lengths.
+func letterRuns(r *rand.Rand, n int) []byte {
+	var b []byte
+	for len(b) < n {
+		start := r.Intn(26)
+		for c := start; c < min(26, start+1+r.Intn(26)); c++ {
+			b = append(b, 'a'+byte(c))
+		}
+	}
+	return b[:n]
+}
+
+func TestCommitReserved_RightToLeftMerges(t *testing.T) {
+	tok := rightToLeftTokenizer(t)
+
+	// a..x pairs up as ab cd .. wx, a..y as a bc de .. xy
+	input := []byte("abcdefghijklmnopqrstuvwxy")
+	for _, opts := range [][]Option{nil, {WithTailReserve(1)}, {WithTailReserve(3)}} {
+		se := NewStreamingEncoderV2(tok, opts...)
+		out := append(se.Feed(input[:24]), se.Feed(input[24:])...)
+		out = append(out, se.Flush()...)
+		if want := tok.EncodeOffline(input, nil); !reflect.DeepEqual(out, want) {
+			t.Fatalf("%d options: got %v, want %v", len(opts), out, want)
+		}
+	}
+
+	r := rand.New(rand.NewSource(3))
+	for range 200 {
+		input := letterRuns(r, 1+r.Intn(400))
+		want := tok.EncodeOffline(input, nil)
+		for _, opts := range [][]Option{nil, {WithTailReserve(1)}, {WithTailReserve(5)}} {
+			se := NewStreamingEncoderV2(tok, opts...)
+			var out []int
+			for pos := 0; pos < len(input); {
+				end := min(pos+1+r.Intn(40), len(input))
+				out = append(out, se.Push(input[pos:end])...)
+				pos = end
+			}
+			out = append(out, se.Flush()...)
+			if !reflect.DeepEqual(out, want) {
+				t.Fatalf("%d options: got %v, want %v (input %q)", len(opts), out, want, input)
+			}
+		}
+	}
+}
@@ -1,6 +1,18 @@
 package streaming_encoder_incremental
 
-type mergeCandidate struct {
+// MergeQueue orders the merge candidates of a StreamingEncoderV2, see WithMergeQueue. Pop returns the
+// candidate with the lowest Rank, candidates of equal rank in the order they were pushed, which is the
+// order offline encoding merges in; false means the queue is empty. Reset empties it. Candidates may go
+// stale while queued, the encoder skips those, so a queue never needs to look inside one beyond its Rank.
+type MergeQueue interface {
+	Push(c MergeCandidate)
+	Pop() (MergeCandidate, bool)
+	Empty() bool
+	Reset()
+}
+
+// MergeCandidate is a pair of adjacent tokens in the encoder's list that a merge can join.
+type MergeCandidate struct {
 	leftIndex  int
 	rightIndex int
 	rank       int
@@ -8,32 +20,44 @@ type mergeCandidate struct {
 	liveRight  uint32
 }
 
+// Rank is the priority of the pair's merge, lower merges first.
+func (c MergeCandidate) Rank() int {
+	return c.rank
+}
+
 type mergeHeap struct {
-	buckets    [][]mergeCandidate
+	buckets [][]MergeCandidate
+	// heads[r] is the index of the next candidate to pop from buckets[r], see utils.BucketQueue: popping
+	// by index keeps each bucket's backing array, so a warm heap stops allocating
+	heads      []int32
 	current    int
 	totalCount int
 }
 
 func newMergeHeap() *mergeHeap {
 	return &mergeHeap{
-		buckets: make([][]mergeCandidate, 0),
+		buckets: make([][]MergeCandidate, 0),
 		current: 0,
 	}
 }
 
 func newMergeHeapWithMaxRank(maxRank int) *mergeHeap {
 	return &mergeHeap{
-		buckets: make([][]mergeCandidate, maxRank+1),
+		buckets: make([][]MergeCandidate, maxRank+1),
+		heads:   make([]int32, maxRank+1),
 		current: 0,
 	}
 }
 
-func (h *mergeHeap) Push(c mergeCandidate) {
+func (h *mergeHeap) Push(c MergeCandidate) {
 	rank := c.rank
 	if rank >= len(h.buckets) {
-		newBuckets := make([][]mergeCandidate, rank+1)
+		newBuckets := make([][]MergeCandidate, rank+1)
 		copy(newBuckets, h.buckets)
 		h.buckets = newBuckets
+		newHeads := make([]int32, rank+1)
+		copy(newHeads, h.heads)
+		h.heads = newHeads
 	}
 
 	h.buckets[rank] = append(h.buckets[rank], c)
@@ -44,23 +68,30 @@ func (h *mergeHeap) Push(c mergeCandidate) {
 	}
 }
 
-func (h *mergeHeap) Pop() (mergeCandidate, bool) {
+func (h *mergeHeap) Pop() (MergeCandidate, bool) {
 	if h.totalCount == 0 {
-		return mergeCandidate{}, false
+		return MergeCandidate{}, false
 	}
 
-	for h.current < len(h.buckets) && len(h.buckets[h.current]) == 0 {
+	for h.current < len(h.buckets) && int(h.heads[h.current]) == len(h.buckets[h.current]) {
 		h.current++
 	}
 
 	if h.current >= len(h.buckets) {
 		h.current = 0
-		return mergeCandidate{}, false
+		return MergeCandidate{}, false
 	}
 
 	bucket := h.buckets[h.current]
-	c := bucket[0]
-	h.buckets[h.current] = bucket[1:]
+	head := h.heads[h.current]
+	c := bucket[head]
+	if int(head)+1 == len(bucket) {
+		// drained, rewind so the next push reuses the whole backing array
+		h.buckets[h.current] = bucket[:0]
+		h.heads[h.current] = 0
+	} else {
+		h.heads[h.current] = head + 1
+	}
 	h.totalCount--
 
 	return c, true
@@ -70,11 +101,96 @@ func (h *mergeHeap) Empty() bool {
 	return h.totalCount == 0
 }
 
+// Reset empties the heap but keeps the buckets. A drained heap has every bucket rewound already, see Pop,
+// which spares the walk over maxRank buckets on every Push.
 func (h *mergeHeap) Reset() {
+	h.current = 0
+	if h.totalCount == 0 {
+		return
+	}
 	for i := range h.buckets {
 		h.buckets[i] = h.buckets[i][:0]
+		h.heads[i] = 0
 	}
 
 	h.totalCount = 0
-	h.current = 0
+}
+
+// binaryMergeHeap is a plain binary min-heap over rank. Ties pop in push order, like the buckets, so both
+// heaps drive the same merges. It uses memory proportional to the live candidates rather than to maxRank,
+// which is the better trade for very large vocabs with short streams.
+type binaryMergeHeap struct {
+	items []seqCandidate
+	seq   uint64
+}
+
+type seqCandidate struct {
+	MergeCandidate
+	seq uint64
+}
+
+func newBinaryMergeHeap() *binaryMergeHeap {
+	return &binaryMergeHeap{items: make([]seqCandidate, 0, 64)}
+}
+
+func (h *binaryMergeHeap) less(a, b seqCandidate) bool {
+	if a.rank != b.rank {
+		return a.rank < b.rank
+	}
+	return a.seq < b.seq
+}
+
+func (h *binaryMergeHeap) Push(c MergeCandidate) {
+	h.items = append(h.items, seqCandidate{MergeCandidate: c, seq: h.seq})
+	h.seq++
+
+	i := len(h.items) - 1
+	for i > 0 {
+		parent := (i - 1) / 2
+		if !h.less(h.items[i], h.items[parent]) {
+			break
+		}
+		h.items[i], h.items[parent] = h.items[parent], h.items[i]
+		i = parent
+	}
+}
+
+func (h *binaryMergeHeap) Pop() (MergeCandidate, bool) {
+	n := len(h.items)
+	if n == 0 {
+		return MergeCandidate{}, false
+	}
+
+	top := h.items[0].MergeCandidate
+	h.items[0] = h.items[n-1]
+	h.items = h.items[:n-1]
+	n--
+
+	i := 0
+	for {
+		l := 2*i + 1
+		if l >= n {
+			break
+		}
+		smallest := l
+		if r := l + 1; r < n && h.less(h.items[r], h.items[l]) {
+			smallest = r
+		}
+		if !h.less(h.items[smallest], h.items[i]) {
+			break
+		}
+		h.items[i], h.items[smallest] = h.items[smallest], h.items[i]
+		i = smallest
+	}
+
+	return top, true
+}
+
+func (h *binaryMergeHeap) Empty() bool {
+	return len(h.items) == 0
+}
+
+func (h *binaryMergeHeap) Reset() {
+	h.items = h.items[:0]
+	h.seq = 0
 }
@@ -0,0 +1,90 @@
+package streaming_encoder_incremental
+
+import (
+	"fmt"
+	"strings"
+)
+
+// dumpSlots is how many node slots a violation dump lists, from the head on.
+const dumpSlots = 64
+
+// checkList panics if the list breaks an invariant, with a dump of the encoder's state: where says what
+// just ran. It is on with WithInvariantChecks and walks the whole list, so it is for tests and debugging,
+// not for production streams.
+func (se *StreamingEncoderV2) checkList(where string) {
+	if msg := se.listViolation(); msg != "" {
+		panic(fmt.Sprintf("streaming encoder: invariant violated after %s: %s\n%s", where, msg, se.dumpList()))
+	}
+}
+
+// listViolation returns what is wrong with the list, or "" if nothing is: head and tail agree with the
+// links, the walk from the head ends at the tail without a cycle, every node on it is live, indices
+// increase along it (see compact), and nodes and pending match what is on it.
+func (se *StreamingEncoderV2) listViolation() string {
+	if (se.head == -1) != (se.tail == -1) {
+		return fmt.Sprintf("head %d but tail %d", se.head, se.tail)
+	}
+	if se.head == -1 {
+		if se.nodes != 0 || se.splits == nil && se.pending != 0 {
+			return fmt.Sprintf("empty list with nodes=%d pending=%d", se.nodes, se.pending)
+		}
+		return ""
+	}
+	n := len(se.tokens)
+	if len(se.prev) != n || len(se.next) != n || len(se.live) != n {
+		return fmt.Sprintf("array lengths tokens=%d prev=%d next=%d live=%d", n, len(se.prev), len(se.next),
+			len(se.live))
+	}
+	if se.head < 0 || se.head >= n || se.tail < 0 || se.tail >= n {
+		return fmt.Sprintf("head %d or tail %d out of range", se.head, se.tail)
+	}
+	if se.prev[se.head] != -1 {
+		return fmt.Sprintf("head %d has prev %d", se.head, se.prev[se.head])
+	}
+
+	count, bytes, last := 0, 0, -1
+	for idx := se.head; idx != -1; idx = se.next[idx] {
+		if idx < 0 || idx >= n {
+			return fmt.Sprintf("node %d links to %d, out of range", last, idx)
+		}
+		if count++; count > n {
+			return "cycle in the next links"
+		}
+		if idx <= last {
+			return fmt.Sprintf("node %d follows %d", idx, last)
+		}
+		if se.prev[idx] != last {
+			return fmt.Sprintf("node %d has prev %d, follows %d", idx, se.prev[idx], last)
+		}
+		if se.live[idx] == 0 {
+			return fmt.Sprintf("dead node %d on the list", idx)
+		}
+		bytes += se.tokenLen(se.tokens[idx])
+		last = idx
+	}
+	if last != se.tail {
+		return fmt.Sprintf("list ends at %d, tail is %d", last, se.tail)
+	}
+	if count != se.nodes {
+		return fmt.Sprintf("%d nodes on the list, nodes=%d", count, se.nodes)
+	}
+	if bytes != se.pending {
+		return fmt.Sprintf("%d bytes on the list, pending=%d", bytes, se.pending)
+	}
+	return ""
+}
+
+// dumpList describes the list and its first dumpSlots slots, one per line.
+func (se *StreamingEncoderV2) dumpList() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "head=%d tail=%d nodes=%d pending=%d slots=%d liveGen=%d\n", se.head, se.tail, se.nodes,
+		se.pending, len(se.tokens), se.liveGen)
+	for idx := range min(len(se.tokens), len(se.prev), len(se.next), len(se.live), dumpSlots) {
+		fmt.Fprintf(&b, "  [%d] token=%d %q prev=%d next=%d live=%d\n", idx, se.tokens[idx],
+			se.tok.TokenBytes(se.tokens[idx]), se.prev[idx], se.next[idx], se.live[idx])
+	}
+	if len(se.tokens) > dumpSlots {
+		fmt.Fprintf(&b, "  ... %d more\n", len(se.tokens)-dumpSlots)
+	}
+	return b.String()
+}
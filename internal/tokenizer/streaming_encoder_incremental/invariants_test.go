@@ -0,0 +1,69 @@
+package streaming_encoder_incremental
+
+import (
+	"math/rand/v2"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/bpetok/internal/tokenizer/core"
+)
+
+func TestInvariantChecks_HoldAcrossCommits(t *testing.T) {
+	tok, err := core.LoadTokenizerFromFiles("../testdata/gpt2/vocab.json", "../testdata/gpt2/merges.txt")
+	if err != nil {
+		t.Fatalf("load tokenizer: %v", err)
+	}
+	corpus, err := os.ReadFile("../testdata/gpt2/bench_corpus.txt")
+	if err != nil {
+		t.Fatalf("read corpus: %v", err)
+	}
+	input := corpus[:min(len(corpus), 16<<10)]
+	want := tok.EncodeOffline(input, nil)
+
+	rng := rand.New(rand.NewPCG(1, 2))
+	for _, c := range []struct {
+		opts []Option
+		// forced commits don't match the offline encoding
+		exact bool
+	}{
+		{nil, true},
+		{[]Option{WithTailReserve(1)}, true},
+		{[]Option{WithCommitPolicy(CommitRankAware)}, true},
+		{[]Option{WithHeap(HeapBinary), WithLongRunCommit(2 << 10)}, true},
+		{[]Option{WithMaxPendingBytes(600)}, false},
+	} {
+		se := NewStreamingEncoderV2(tok, append(c.opts, WithInvariantChecks(true))...)
+		var got []int
+		for pos := 0; pos < len(input); {
+			end := min(pos+1+rng.IntN(200), len(input))
+			got = append(got, se.Push(input[pos:end])...)
+			pos = end
+		}
+		got = append(got, se.Flush()...)
+		if c.exact && !reflect.DeepEqual(got, want) {
+			t.Fatalf("got %d tokens, want %d", len(got), len(want))
+		}
+	}
+}
+
+func TestInvariantChecks_PanicsWithDump(t *testing.T) {
+	tok, err := core.LoadTokenizerFromFiles("../testdata/gpt2/vocab.json", "../testdata/gpt2/merges.txt")
+	if err != nil {
+		t.Fatalf("load tokenizer: %v", err)
+	}
+	se := NewStreamingEncoderV2(tok, WithInvariantChecks(true))
+	se.Push([]byte("a list about to be broken"))
+
+	// a node merged away that is still linked in
+	se.live[se.head] = 0
+	defer func() {
+		msg, _ := recover().(string)
+		if !strings.Contains(msg, "invariant violated after") || !strings.Contains(msg, "dead node") {
+			t.Fatalf("got panic %q", msg)
+		}
+	}()
+	se.Push([]byte("!"))
+	t.Fatalf("no panic")
+}
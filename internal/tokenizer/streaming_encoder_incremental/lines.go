@@ -0,0 +1,115 @@
+package streaming_encoder_incremental
+
+import (
+	"bytes"
+
+	"github.com/bpetok/internal/tokenizer/core"
+)
+
+// LineEncoder tokenizes newline separated records (logs, NDJSON, JSONL corpora) one line at a time. Every
+// completed line comes back as its own token array and no merge ever spans a line break, so each array
+// equals EncodeOffline over that line alone. Within a line the bytes still go through a
+// StreamingEncoderV2, so a single huge line doesn't have to be buffered before it's merged.
+type LineEncoder struct {
+	enc         *StreamingEncoderV2
+	keepNewline bool
+
+	// cur collects the current line's IDs across Push calls
+	cur []int
+	// cr is a '\r' held back in strip mode until we know whether '\n' follows it
+	cr bool
+	// open is set once the current line has seen any byte
+	open bool
+}
+
+// NewLineEncoder returns a line encoder over tok. keepNewline leaves the '\n' at the end of each line's
+// input, where it is encoded with the line; otherwise the '\n' and a '\r' right before it are stripped.
+// opts configure the per-line StreamingEncoderV2; WithZeroCopyOutput is ignored, line arrays are always
+// owned by the caller.
+func NewLineEncoder(tok *core.Tokenizer, keepNewline bool, opts ...Option) *LineEncoder {
+	enc := NewStreamingEncoderV2(tok, opts...)
+	enc.zeroCopy = false
+	return &LineEncoder{enc: enc, keepNewline: keepNewline}
+}
+
+// Push consumes chunk and returns the token arrays of the lines it completes, in order. A line with nothing
+// left after stripping comes back as an empty, non-nil array so line numbers stay aligned.
+func (le *LineEncoder) Push(chunk []byte) [][]int {
+	var lines [][]int
+	for len(chunk) > 0 {
+		le.open = true
+
+		nl := bytes.IndexByte(chunk, '\n')
+		if nl < 0 {
+			le.feed(chunk, false)
+			break
+		}
+
+		le.feed(chunk[:nl+1], true)
+		lines = append(lines, le.endLine())
+		chunk = chunk[nl+1:]
+	}
+	return lines
+}
+
+// Flush returns the unterminated last line, or nil if the input ended with a newline (or was empty), and
+// readies the encoder for a new stream.
+func (le *LineEncoder) Flush() []int {
+	if !le.open {
+		return nil
+	}
+	if le.cr {
+		le.cur = append(le.cur, le.enc.Push([]byte{'\r'})...)
+		le.cr = false
+	}
+	return le.endLine()
+}
+
+// Warnings returns what the underlying encoder has recorded, see StreamingEncoderV2.Warnings.
+func (le *LineEncoder) Warnings() []core.Warning {
+	return le.enc.Warnings()
+}
+
+// ResetWarnings clears the recorded warnings.
+func (le *LineEncoder) ResetWarnings() {
+	le.enc.ResetWarnings()
+}
+
+// feed passes part of the current line to the encoder. terminated says b ends with the line's '\n'.
+func (le *LineEncoder) feed(b []byte, terminated bool) {
+	if le.keepNewline {
+		le.cur = append(le.cur, le.enc.Push(b)...)
+		return
+	}
+
+	if terminated {
+		b = b[:len(b)-1]
+		switch {
+		case len(b) > 0 && b[len(b)-1] == '\r':
+			b = b[:len(b)-1]
+		case len(b) == 0 && le.cr:
+			// the '\r' held back from the previous chunk was the first half of this "\r\n"
+			le.cr = false
+		}
+	}
+
+	if le.cr {
+		le.cur = append(le.cur, le.enc.Push([]byte{'\r'})...)
+		le.cr = false
+	}
+	if !terminated && len(b) > 0 && b[len(b)-1] == '\r' {
+		b, le.cr = b[:len(b)-1], true
+	}
+	if len(b) > 0 {
+		le.cur = append(le.cur, le.enc.Push(b)...)
+	}
+}
+
+func (le *LineEncoder) endLine() []int {
+	line := append(le.cur, le.enc.Flush()...)
+	if line == nil {
+		line = []int{}
+	}
+	le.cur, le.open = nil, false
+	return line
+}
@@ -0,0 +1,94 @@
+package streaming_encoder_incremental
+
+import (
+	"bytes"
+	"math/rand"
+	"reflect"
+	"testing"
+
+	"github.com/bpetok/internal/tokenizer/core"
+)
+
+// expectedLines encodes each line of input on its own, the way LineEncoder should.
+func expectedLines(tok *core.Tokenizer, input []byte, keepNewline bool) [][]int {
+	var want [][]int
+	for len(input) > 0 {
+		line := input
+		nl := bytes.IndexByte(input, '\n')
+		if nl >= 0 {
+			line, input = input[:nl+1], input[nl+1:]
+		} else {
+			input = nil
+		}
+		// an unterminated last line keeps a trailing '\r', there is no line break for it to be part of
+		if !keepNewline && nl >= 0 {
+			line = bytes.TrimSuffix(bytes.TrimSuffix(line, []byte("\n")), []byte("\r"))
+		}
+		ids := tok.EncodeOffline(line, nil)
+		if ids == nil {
+			ids = []int{}
+		}
+		want = append(want, ids)
+	}
+	return want
+}
+
+func TestLineEncoder_MatchesPerLineEncode(t *testing.T) {
+	tok, err := core.LoadTokenizerFromFiles("../testdata/gpt2/vocab.json", "../testdata/gpt2/merges.txt")
+	if err != nil {
+		t.Fatalf("load tokenizer: %v", err)
+	}
+
+	input := []byte("{\"id\": 1, \"text\": \"hello world\"}\n\n{\"id\": 2, \"text\": \"the quick brown fox\"}\r\n" +
+		"\r\n2024-01-01T00:00:00Z INFO started\n\n\nlast line without newline")
+
+	rng := rand.New(rand.NewSource(7))
+	for _, keep := range []bool{true, false} {
+		want := expectedLines(tok, input, keep)
+
+		for round := 0; round < 30; round++ {
+			le := NewLineEncoder(tok, keep)
+
+			var got [][]int
+			for pos := 0; pos < len(input); {
+				n := min(1+rng.Intn(12), len(input)-pos)
+				got = append(got, le.Push(input[pos:pos+n])...)
+				pos += n
+			}
+			if last := le.Flush(); last != nil {
+				got = append(got, last)
+			}
+
+			if !reflect.DeepEqual(got, want) {
+				t.Fatalf("keep=%v round %d:\ngot  %v\nwant %v", keep, round, got, want)
+			}
+		}
+	}
+}
+
+func TestLineEncoder_CRLFSplitAcrossChunks(t *testing.T) {
+	tok, err := core.LoadTokenizerFromFiles("../testdata/gpt2/vocab.json", "../testdata/gpt2/merges.txt")
+	if err != nil {
+		t.Fatalf("load tokenizer: %v", err)
+	}
+
+	le := NewLineEncoder(tok, false)
+	var got [][]int
+	for _, chunk := range []string{"a\r", "\r", "\nb\r", "\n", "c\r"} {
+		got = append(got, le.Push([]byte(chunk))...)
+	}
+	got = append(got, le.Flush())
+
+	want := [][]int{
+		tok.EncodeOffline([]byte("a\r"), nil),
+		tok.EncodeOffline([]byte("b"), nil),
+		tok.EncodeOffline([]byte("c\r"), nil),
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("got %v want %v", got, want)
+	}
+
+	if le.Flush() != nil {
+		t.Fatalf("flush after flush should be empty")
+	}
+}
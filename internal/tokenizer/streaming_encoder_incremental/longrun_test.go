@@ -0,0 +1,425 @@
+package streaming_encoder_incremental
+
+import (
+	"encoding/base64"
+	"math/rand"
+	"os"
+	"reflect"
+	"runtime"
+	"testing"
+
+	"github.com/bpetok/internal/tokenizer/core"
+)
+
+func TestLongRunCommit_Base64BlobMatchesOffline(t *testing.T) {
+	tok, err := core.LoadTokenizerFromFiles("../testdata/gpt2/vocab.json", "../testdata/gpt2/merges.txt")
+	if err != nil {
+		t.Fatalf("load tokenizer: %v", err)
+	}
+
+	raw := make([]byte, 96<<10)
+	rand.New(rand.NewSource(11)).Read(raw)
+	input := []byte(base64.StdEncoding.EncodeToString(raw))
+
+	se := NewStreamingEncoderV2(tok, WithLongRunCommit(16<<10))
+
+	var out []int
+	committedEarly := false
+	for pos := 0; pos < len(input); pos += 1000 {
+		got := se.Push(input[pos:min(pos+1000, len(input))])
+		if len(got) > 0 {
+			committedEarly = true
+		}
+		out = append(out, got...)
+
+		if se.pending > 16<<10+1000 {
+			t.Fatalf("pending run grew to %d bytes", se.pending)
+		}
+	}
+	out = append(out, se.Flush()...)
+
+	if !committedEarly {
+		t.Fatalf("expected tokens to be committed before Flush")
+	}
+	if want := tok.EncodeOffline(input, nil); !reflect.DeepEqual(out, want) {
+		t.Fatalf("mismatch: got %d tokens, want %d", len(out), len(want))
+	}
+}
+
+func TestLongRunCommit_Disabled(t *testing.T) {
+	tok, err := core.LoadTokenizerFromFiles("../testdata/gpt2/vocab.json", "../testdata/gpt2/merges.txt")
+	if err != nil {
+		t.Fatalf("load tokenizer: %v", err)
+	}
+
+	input := make([]byte, 8<<10)
+	se := NewStreamingEncoderV2(tok, WithLongRunCommit(0), WithTailReserve(len(input)))
+	for i := range input {
+		input[i] = 'a' + byte(i%26)
+	}
+	if got := se.Push(input); len(got) != 0 {
+		t.Fatalf("nothing should be committed with the policy off, got %d tokens", len(got))
+	}
+	if se.pending != len(input) {
+		t.Fatalf("pending = %d, want %d", se.pending, len(input))
+	}
+}
+
+func TestLongRunCommit_GeneratedTextMatchesOffline(t *testing.T) {
+	tok, err := core.LoadTokenizerFromFiles("../testdata/gpt2/vocab.json", "../testdata/gpt2/merges.txt")
+	if err != nil {
+		t.Fatalf("load tokenizer: %v", err)
+	}
+	corpus, err := os.ReadFile("../testdata/gpt2/bench_corpus.txt")
+	if err != nil {
+		t.Fatalf("read corpus: %v", err)
+	}
+
+	// text with the token mix of the corpus, rather than random bytes that barely merge
+	input := tok.NewTextGenerator(5, tok.TokenFrequencies(corpus[:256<<10])).Generate(64 << 10)
+	rng := rand.New(rand.NewSource(5))
+
+	se := NewStreamingEncoderV2(tok, WithLongRunCommit(8<<10))
+	var out []int
+	for pos := 0; pos < len(input); {
+		end := min(pos+1+rng.Intn(2000), len(input))
+		out = append(out, se.Push(input[pos:end])...)
+		pos = end
+	}
+	out = append(out, se.Flush()...)
+
+	if want := tok.EncodeOffline(input, nil); !reflect.DeepEqual(out, want) {
+		t.Fatalf("mismatch: got %d tokens, want %d", len(out), len(want))
+	}
+}
+
+func TestTailReserve_CommitsMidStream(t *testing.T) {
+	tok, err := core.LoadTokenizerFromFiles("../testdata/gpt2/vocab.json", "../testdata/gpt2/merges.txt")
+	if err != nil {
+		t.Fatalf("load tokenizer: %v", err)
+	}
+	corpus, err := os.ReadFile("../testdata/gpt2/bench_corpus.txt")
+	if err != nil {
+		t.Fatalf("read corpus: %v", err)
+	}
+	input := corpus[:min(len(corpus), 256<<10)]
+	rng := rand.New(rand.NewSource(23))
+
+	for _, reserve := range []int{tok.MaxTokenByteLen - 1, 3000} {
+		se := NewStreamingEncoderV2(tok, WithTailReserve(reserve))
+		bound := reserveBatch*max(reserve, tok.CommitGuard()) + 2000
+
+		var out []int
+		pushes, emitting := 0, 0
+		for pos := 0; pos < len(input); {
+			end := min(pos+1+rng.Intn(2000), len(input))
+			got := se.Push(input[pos:end])
+			if pushes++; len(got) > 0 {
+				emitting++
+			}
+			out = append(out, got...)
+			if se.pending > bound {
+				t.Fatalf("reserve %d: %d bytes pending", reserve, se.pending)
+			}
+			pos = end
+		}
+		out = append(out, se.Flush()...)
+
+		// at least once per bound bytes
+		if emitting < len(input)/bound {
+			t.Fatalf("reserve %d: only %d of %d pushes emitted tokens", reserve, emitting, pushes)
+		}
+		if want := tok.EncodeOffline(input, nil); !reflect.DeepEqual(out, want) {
+			t.Fatalf("reserve %d: got %d tokens, want %d", reserve, len(out), len(want))
+		}
+	}
+}
+
+// TestCompact_BoundedMemory streams a few megabytes (128 MiB with BPETOK_LONG_TESTS=1 and without -short)
+// and checks that the node arrays stay proportional to what is pending, with commits during Push and across
+// Flushes.
+func TestCompact_BoundedMemory(t *testing.T) {
+	tok, err := core.LoadTokenizerFromFiles("../testdata/gpt2/vocab.json", "../testdata/gpt2/merges.txt")
+	if err != nil {
+		t.Fatalf("load tokenizer: %v", err)
+	}
+	corpus, err := os.ReadFile("../testdata/gpt2/bench_corpus.txt")
+	if err != nil {
+		t.Fatalf("read corpus: %v", err)
+	}
+
+	total := 4 << 20
+	if !testing.Short() && os.Getenv("BPETOK_LONG_TESTS") == "1" {
+		total = 128 << 20
+	}
+	const chunk = 4 << 10
+
+	cases := []struct {
+		name       string
+		opts       []Option
+		flushEvery int
+		bound      int
+	}{
+		{"tail reserve 0", []Option{WithTailReserve(0)}, 0, 4 * compactMin},
+		{"flush", nil, 64 << 10, 4 * (64<<10 + chunk)},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			se := NewStreamingEncoderV2(tok, c.opts...)
+			var emitted int
+			for pos := 0; pos < total; pos += chunk {
+				start := pos % (len(corpus) - chunk)
+				emitted += len(se.Push(corpus[start : start+chunk]))
+				if c.flushEvery > 0 && (pos+chunk)%c.flushEvery == 0 {
+					emitted += len(se.Flush())
+				}
+				if n := cap(se.tokens); n > c.bound {
+					t.Fatalf("after %d bytes the node arrays hold %d slots for %d pending bytes", pos+chunk, n,
+						se.pending)
+				}
+			}
+			emitted += len(se.Flush())
+			if emitted == 0 {
+				t.Fatalf("nothing emitted")
+			}
+		})
+	}
+}
+
+// TestCompact_MatchesOffline checks that compacting between pushes doesn't change the output, in one long
+// stream and in many short ones.
+func TestCompact_MatchesOffline(t *testing.T) {
+	tok, err := core.LoadTokenizerFromFiles("../testdata/gpt2/vocab.json", "../testdata/gpt2/merges.txt")
+	if err != nil {
+		t.Fatalf("load tokenizer: %v", err)
+	}
+	corpus, err := os.ReadFile("../testdata/gpt2/bench_corpus.txt")
+	if err != nil {
+		t.Fatalf("read corpus: %v", err)
+	}
+	input := corpus[:min(len(corpus), 1<<20)]
+	rng := rand.New(rand.NewSource(17))
+
+	for _, streamLen := range []int{len(input), 10 << 10} {
+		se := NewStreamingEncoderV2(tok, WithLongRunCommit(8<<10))
+		compacted := false
+		for start := 0; start < len(input); start += streamLen {
+			stream := input[start:min(start+streamLen, len(input))]
+			var out []int
+			for pos := 0; pos < len(stream); {
+				end := min(pos+1+rng.Intn(3000), len(stream))
+				before := len(se.tokens)
+				out = append(out, se.Push(stream[pos:end])...)
+				if len(se.tokens) < before {
+					compacted = true
+				}
+				pos = end
+			}
+			out = append(out, se.Flush()...)
+
+			if want := tok.EncodeOffline(stream, nil); !reflect.DeepEqual(out, want) {
+				t.Fatalf("stream at %d: got %d tokens, want %d", start, len(out), len(want))
+			}
+		}
+		if !compacted {
+			t.Fatalf("streams of %d bytes: the node arrays were never compacted", streamLen)
+		}
+	}
+}
+
+func TestCommitRankAware_LowLatency(t *testing.T) {
+	tok, err := core.LoadTokenizerFromFiles("../testdata/gpt2/vocab.json", "../testdata/gpt2/merges.txt")
+	if err != nil {
+		t.Fatalf("load tokenizer: %v", err)
+	}
+	corpus, err := os.ReadFile("../testdata/gpt2/bench_corpus.txt")
+	if err != nil {
+		t.Fatalf("read corpus: %v", err)
+	}
+	input := corpus[:min(len(corpus), 128<<10)]
+	rng := rand.New(rand.NewSource(29))
+
+	for _, opts := range [][]Option{
+		{WithCommitPolicy(CommitRankAware)},
+		{WithCommitPolicy(CommitRankAware), WithLongRunCommit(2 << 10), WithHeap(HeapBinary)},
+	} {
+		se := NewStreamingEncoderV2(tok, opts...)
+		var out []int
+		maxPending := 0
+		for pos := 0; pos < len(input); {
+			end := min(pos+1+rng.Intn(40), len(input))
+			out = append(out, se.Push(input[pos:end])...)
+			maxPending = max(maxPending, se.pending)
+			pos = end
+		}
+		out = append(out, se.Flush()...)
+
+		if want := tok.EncodeOffline(input, nil); !reflect.DeepEqual(out, want) {
+			t.Fatalf("got %d tokens, want %d", len(out), len(want))
+		}
+		// words end at junctions nothing joins, so only about a word and a chunk stay pending
+		if maxPending > 400 {
+			t.Fatalf("up to %d bytes pending", maxPending)
+		}
+	}
+
+	// a run with no such junction falls back to the other commits
+	se := NewStreamingEncoderV2(tok, WithCommitPolicy(CommitRankAware))
+	run := make([]byte, 8<<10)
+	for i := range run {
+		run[i] = 'a' + byte(i%26)
+	}
+	out := se.Push(run)
+	if out = append(out, se.Flush()...); !reflect.DeepEqual(out, tok.EncodeOffline(run, nil)) {
+		t.Fatalf("run of letters: mismatch")
+	}
+}
+
+func TestMaxPendingBytes_BoundsAdversarialRun(t *testing.T) {
+	plain, err := core.LoadTokenizerFromFiles("../testdata/gpt2/vocab.json", "../testdata/gpt2/merges.txt")
+	if err != nil {
+		t.Fatalf("load tokenizer: %v", err)
+	}
+	split, err := core.Load(core.Files("../testdata/gpt2/vocab.json", "../testdata/gpt2/merges.txt"),
+		core.WithPreTokenization(core.PreTokenizeGPT2))
+	if err != nil {
+		t.Fatalf("load tokenizer: %v", err)
+	}
+
+	// a bound under twice CommitGuard leaves no room for an exact cut, and for the pre-tokenizer the run is
+	// one word that never ends
+	const bound, chunk = 1024, 500
+	input := make([]byte, 64<<10)
+	for i := range input {
+		input[i] = 'a'
+	}
+	for name, tok := range map[string]*core.Tokenizer{"plain": plain, "pre-tokenized": split} {
+		se := NewStreamingEncoderV2(tok, WithMaxPendingBytes(bound), WithLongRunCommit(0), WithTailReserve(len(input)),
+			WithWarnings(true))
+		var out []int
+		for pos := 0; pos < len(input); pos += chunk {
+			out = append(out, se.Push(input[pos:min(pos+chunk, len(input))])...)
+			if se.PendingBytes() > bound {
+				t.Fatalf("%s: %d bytes pending", name, se.PendingBytes())
+			}
+		}
+		out = append(out, se.Flush()...)
+
+		if got := tok.Decode(out); string(got) != string(input) {
+			t.Fatalf("%s: output doesn't decode to the input", name)
+		}
+		w := se.Warnings()
+		if len(w) != 1 || w[0].Kind != core.WarnForcedCommit || w[0].Count < len(input)/bound {
+			t.Fatalf("%s: warnings %v", name, w)
+		}
+	}
+
+	// ordinary text gets exact cuts and no warning
+	corpus, err := os.ReadFile("../testdata/gpt2/bench_corpus.txt")
+	if err != nil {
+		t.Fatalf("read corpus: %v", err)
+	}
+	text := corpus[:min(len(corpus), 64<<10)]
+	se := NewStreamingEncoderV2(plain, WithMaxPendingBytes(8<<10), WithLongRunCommit(0), WithTailReserve(len(text)),
+		WithWarnings(true))
+	var out []int
+	for pos := 0; pos < len(text); pos += chunk {
+		out = append(out, se.Push(text[pos:min(pos+chunk, len(text))])...)
+	}
+	out = append(out, se.Flush()...)
+	if want := plain.EncodeOffline(text, nil); !reflect.DeepEqual(out, want) {
+		t.Fatalf("text: got %d tokens, want %d", len(out), len(want))
+	}
+	if w := se.Warnings(); len(w) != 0 {
+		t.Fatalf("text: warnings %v", w)
+	}
+}
+
+func TestParallelPush_MatchesOffline(t *testing.T) {
+	tok, err := core.LoadTokenizerFromFiles("../testdata/gpt2/vocab.json", "../testdata/gpt2/merges.txt")
+	if err != nil {
+		t.Fatalf("load tokenizer: %v", err)
+	}
+	corpus, err := os.ReadFile("../testdata/gpt2/bench_corpus.txt")
+	if err != nil {
+		t.Fatalf("read corpus: %v", err)
+	}
+	input := corpus[:min(len(corpus), 2<<20)]
+	defer runtime.GOMAXPROCS(runtime.GOMAXPROCS(4))
+
+	se := NewStreamingEncoderV2(tok, WithParallelPush(512<<10))
+	var out []int
+	for pos := 0; pos < len(input); pos += 700 << 10 {
+		out = append(out, se.Push(input[pos:min(pos+700<<10, len(input))])...)
+		if pos == 0 && se.Stats().Merges > 1000 {
+			t.Fatalf("the first chunk went through the list, %d merges", se.Stats().Merges)
+		}
+	}
+	out = append(out, se.Flush()...)
+	if want := tok.EncodeOffline(input, nil); !reflect.DeepEqual(out, want) {
+		t.Fatalf("got %d tokens, want %d", len(out), len(want))
+	}
+}
+
+// TestMaxChunk_SplitsHugePush checks that one huge Push goes through the list in pieces, with the output of
+// a single round.
+func TestMaxChunk_SplitsHugePush(t *testing.T) {
+	tok, err := core.LoadTokenizerFromFiles("../testdata/gpt2/vocab.json", "../testdata/gpt2/merges.txt")
+	if err != nil {
+		t.Fatalf("load tokenizer: %v", err)
+	}
+	corpus, err := os.ReadFile("../testdata/gpt2/bench_corpus.txt")
+	if err != nil {
+		t.Fatalf("read corpus: %v", err)
+	}
+	input := corpus[:min(len(corpus), 1<<20)]
+	want := tok.EncodeOffline(input, nil)
+
+	const maxChunk = 32 << 10
+	for _, opts := range [][]Option{
+		{WithMaxChunk(maxChunk), WithParallelPush(0)},
+		{WithMaxChunk(maxChunk), WithCommitPolicy(CommitRankAware)},
+	} {
+		se := NewStreamingEncoderV2(tok, opts...)
+		out := append([]int(nil), se.Push(input)...)
+		if n := cap(se.tokens); n > 4*maxChunk {
+			t.Fatalf("a %d byte push left %d node slots", len(input), n)
+		}
+		out = append(out, se.Flush()...)
+		if !reflect.DeepEqual(out, want) {
+			t.Fatalf("got %d tokens, want %d", len(out), len(want))
+		}
+	}
+
+	// tail reserve 0 output depends on the chunking, so the chunk goes through whole
+	se := NewStreamingEncoderV2(tok, WithMaxChunk(maxChunk), WithTailReserve(0), WithParallelPush(0))
+	se.Push(input)
+	if n := cap(se.tokens); n < len(input)/4 {
+		t.Fatalf("tail reserve 0: node slots %d, the push should have been one round", n)
+	}
+}
+
+// A long run commit cuts at junctions no merge can join, so it stays exact on a vocabulary whose merges
+// reach all the way back through a run, where a fixed hold-back would not.
+func TestLongRunCommit_RightToLeftMergesMatchOffline(t *testing.T) {
+	tok := rightToLeftTokenizer(t)
+
+	r := rand.New(rand.NewSource(9))
+	for range 100 {
+		input := letterRuns(r, 1+r.Intn(2000))
+		want := tok.EncodeOffline(input, nil)
+		for _, n := range []int{16, 64} {
+			se := NewStreamingEncoderV2(tok, WithLongRunCommit(n), WithTailReserve(len(input)))
+			var out []int
+			for pos := 0; pos < len(input); {
+				end := min(pos+1+r.Intn(50), len(input))
+				out = append(out, se.Push(input[pos:end])...)
+				pos = end
+			}
+			out = append(out, se.Flush()...)
+			if !reflect.DeepEqual(out, want) {
+				t.Fatalf("long run %d: got %v, want %v (input %q)", n, out, want, input)
+			}
+		}
+	}
+}
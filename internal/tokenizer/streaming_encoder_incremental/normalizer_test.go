@@ -0,0 +1,45 @@
+package streaming_encoder_incremental
+
+import (
+	"math/rand"
+	"reflect"
+	"testing"
+
+	"github.com/bpetok/internal/tokenizer/core"
+	"golang.org/x/text/unicode/norm"
+)
+
+func TestStreamingNormalizer_MatchesOfflineNormalized(t *testing.T) {
+	tok, err := core.LoadTokenizerFromFiles("../testdata/gpt2/vocab.json", "../testdata/gpt2/merges.txt")
+	if err != nil {
+		t.Fatalf("load tokenizer: %v", err)
+	}
+
+	inputs := []string{
+		"café au lait, résumé attached",
+		"Zoë and Chloë went to the crème brûlée stand",
+		"no marks at all here",
+	}
+
+	r := rand.New(rand.NewSource(3))
+	for _, s := range inputs {
+		want := tok.EncodeOffline(norm.NFC.Bytes([]byte(s)), nil)
+
+		for iter := 0; iter < 25; iter++ {
+			se := NewStreamingEncoderV2WithNormalizer(tok, core.NormalizeNFC)
+
+			var out []int
+			in := []byte(s)
+			for pos := 0; pos < len(in); {
+				end := min(pos+1+r.Intn(5), len(in))
+				out = append(out, se.Push(in[pos:end])...)
+				pos = end
+			}
+			out = append(out, se.Flush()...)
+
+			if !reflect.DeepEqual(out, want) {
+				t.Fatalf("%q mismatch:\ngot  %v\nwant %v", s, out, want)
+			}
+		}
+	}
+}
@@ -0,0 +1,185 @@
+package streaming_encoder_incremental
+
+import "github.com/bpetok/internal/tokenizer/core"
+
+// HeapKind selects the priority queue that orders merge candidates.
+type HeapKind int
+
+const (
+	// HeapBucket is one bucket per merge rank, O(1) push and amortized O(1) pop. It preallocates maxRank+1
+	// bucket headers. This is the default.
+	HeapBucket HeapKind = iota
+	// HeapBinary is a binary heap, O(log n) per operation but sized by the candidates actually pending.
+	HeapBinary
+)
+
+// CommitPolicy picks what Push commits on top of the tail reserve, see WithCommitPolicy.
+type CommitPolicy int
+
+const (
+	// CommitReserve commits only what lies in front of the tail reserve, see WithTailReserve. This is the
+	// default.
+	CommitReserve CommitPolicy = iota
+	// CommitRankAware also commits everything in front of the last junction between pending tokens that
+	// no merge in the table can join (see core.Tokenizer.CanJoin), which no later input can change. For
+	// GPT-2 that is the end of most words, so tokens come out about a word behind the input.
+	CommitRankAware
+)
+
+// Option configures a StreamingEncoderV2 at construction time.
+type Option func(*StreamingEncoderV2)
+
+// WithTailReserve sets how many trailing bytes are held back from commits during Push, at least CommitGuard
+// bytes however small n is. Once reserveBatch times that many are pending, Push commits up to the last
+// junction in front of the reserve that no merge can join, which is what keeps the commits exact, so under
+// CommitReserve output comes in batches of a few KiB, see commitReserved. 0 instead commits all but
+// the last token on every Push, which may emit tokens a later chunk would have merged into.
+func WithTailReserve(n int) Option {
+	return func(se *StreamingEncoderV2) {
+		se.tailReserve = max(n, 0)
+	}
+}
+
+// WithCommitPolicy picks what Push commits, see CommitPolicy. The output is the same under every policy,
+// only when it comes out differs.
+func WithCommitPolicy(p CommitPolicy) Option {
+	return func(se *StreamingEncoderV2) {
+		se.commitPolicy = p
+	}
+}
+
+// WithHeap picks the merge candidate queue.
+func WithHeap(kind HeapKind) Option {
+	return func(se *StreamingEncoderV2) {
+		switch kind {
+		case HeapBinary:
+			se.heap = newBinaryMergeHeap()
+		default:
+			se.heap = newMergeHeapWithMaxRank(se.tok.GetMaxRank())
+		}
+	}
+}
+
+// WithMergeQueue makes the encoder order its merge candidates with q, e.g. to try another priority queue
+// against the built-in ones. q must be empty and not shared with another encoder; the output doesn't depend
+// on it as long as it keeps the MergeQueue contract.
+func WithMergeQueue(q MergeQueue) Option {
+	return func(se *StreamingEncoderV2) {
+		se.heap = q
+	}
+}
+
+// WithWarnings makes the encoder record non-fatal anomalies for Warnings: invalid UTF-8 input, forced
+// commits and capped ranks, see core.WarningKind. Off by default, which also skips the checks behind them.
+func WithWarnings(on bool) Option {
+	return func(se *StreamingEncoderV2) {
+		se.warnings.Enable(on)
+	}
+}
+
+// WithZeroCopyOutput makes Push and Flush return slices backed by a buffer the encoder reuses. They are only
+// valid until the next call on the encoder, but steady-state encoding no longer allocates an output slice
+// per call.
+func WithZeroCopyOutput(on bool) Option {
+	return func(se *StreamingEncoderV2) {
+		se.zeroCopy = on
+	}
+}
+
+// WithOffsets makes PushResult and FlushResult fill StreamResult.Spans with the byte range each committed
+// ID covers in the stream. In zero-copy mode the spans share its aliasing rules.
+func WithOffsets(on bool) Option {
+	return func(se *StreamingEncoderV2) {
+		se.offsets = on
+	}
+}
+
+// WithNormalization normalizes the input before merging, see core.StreamNormalizer. It overrides the
+// normalization the tokenizer was loaded with.
+func WithNormalization(n core.Normalization) Option {
+	return func(se *StreamingEncoderV2) {
+		se.normalizer = core.NewStreamNormalizer(n)
+	}
+}
+
+// WithPrefixSpace turns the prefix space on or off, overriding how the tokenizer was loaded, see
+// core.LoadOptions.AddPrefixSpace. Off is for input that continues a stream, such as bytes from another
+// encoder's TakePending.
+func WithPrefixSpace(on bool) Option {
+	return func(se *StreamingEncoderV2) {
+		se.prefixSpace = on
+	}
+}
+
+// WithLongRunCommit sets how many uncommitted bytes may pile up before the encoder commits the run up to
+// its last junction that no merge can join, which keeps the output exact, see commitLongRun. n <= 0 turns
+// it off.
+func WithLongRunCommit(n int) Option {
+	return func(se *StreamingEncoderV2) {
+		se.longRun = max(n, 0)
+	}
+}
+
+// WithMaxPendingBytes bounds the input held back between Push calls to n bytes, for services that must
+// bound memory and latency whatever their input, e.g. a run adversarial enough that no cut in it is safe.
+// Past n, Push commits what it can exactly and, if that isn't enough, force-commits the oldest tokens down
+// to n/2 pending bytes, which a later chunk might have merged differently; each such commit is reported as
+// a core.WarnForcedCommit warning under WithWarnings. n <= 0, the default, sets no bound.
+func WithMaxPendingBytes(n int) Option {
+	return func(se *StreamingEncoderV2) {
+		se.maxPending = max(n, 0)
+	}
+}
+
+// WithInvariantChecks makes the encoder check its merge list after every merge and every Push, and panic
+// with a dump of it when the links, head and tail, live flags or byte counts don't add up. Each check walks
+// the whole list, so this is for tests and for work on the merge logic.
+func WithInvariantChecks(on bool) Option {
+	return func(se *StreamingEncoderV2) {
+		se.checkInvariants = on
+	}
+}
+
+// WithOnMerge calls fn for every merge in the encoder's list, with the two tokens, the one they merge
+// into and its byte offset in the stream (normalized, counted from the last Flush), e.g. to trace or
+// visualize how a stream converges to its tokens. Finding the offset walks the list, so each merge costs
+// time proportional to the pending tokens. Commits that re-encode the tail rebuild it, reporting its merges
+// again; bytes encoded offline on their way out, and all input under a pre-tokenizer, which keeps no list,
+// are not reported.
+func WithOnMerge(fn func(left, right, merged, pos int)) Option {
+	return func(se *StreamingEncoderV2) {
+		se.onMerge = fn
+	}
+}
+
+// WithParallelPush sets the chunk size, 1 MiB by default, from which Push encodes the chunk on several CPUs:
+// everything up to its last junction no merge can join (see core.Tokenizer.CanJoin) is committed at once
+// through core.EncodeParallel, the output staying what it would have been. n <= 0 turns it off. Under a
+// pre-tokenizer chunks are never split this way.
+func WithParallelPush(n int) Option {
+	return func(se *StreamingEncoderV2) {
+		se.parallelPush = max(n, 0)
+	}
+}
+
+// WithMaxChunk makes Push feed a chunk longer than n bytes to the merge loop n bytes at a time, so one huge
+// chunk doesn't build a list, heap and scratch sized for all of it at once or hold the merge loop for as
+// long. The pieces commit what separate Push calls would have, so the stream's IDs are the same, though the
+// call may return a few more or fewer of them than one round would. With WithTailReserve(0), whose IDs
+// depend on the chunking, chunks are never split. WithParallelPush only sees pieces of up to n bytes.
+// n <= 0, the default, sets no limit.
+func WithMaxChunk(n int) Option {
+	return func(se *StreamingEncoderV2) {
+		se.maxChunk = max(n, 0)
+	}
+}
+
+// WithAllowedSpecial makes the encoder emit the named special tokens as their IDs wherever their text
+// appears in the stream, core.AllSpecial standing for all of them; the output matches EncodeWithSpecial
+// with those allowed and none disallowed. Up to the longest token's length of bytes may be held back
+// between Push calls while they could still be the start of one.
+func WithAllowedSpecial(names ...string) Option {
+	return func(se *StreamingEncoderV2) {
+		se.special = se.tok.SpecialMatcher(names...)
+	}
+}
@@ -0,0 +1,233 @@
+package streaming_encoder_incremental
+
+import (
+	"math/rand/v2"
+	"reflect"
+	"slices"
+	"testing"
+
+	"github.com/bpetok/internal/tokenizer/core"
+)
+
+func TestOptions_BinaryHeapMatchesBucketHeap(t *testing.T) {
+	tok, err := core.LoadTokenizerFromFiles("../testdata/gpt2/vocab.json", "../testdata/gpt2/merges.txt")
+	if err != nil {
+		t.Fatalf("load tokenizer: %v", err)
+	}
+
+	input := []byte("The quick brown fox jumped over the log while thinking about tokens, 東京 and café")
+
+	for _, chunk := range []int{1, 3, 7, len(input)} {
+		run := func(se *StreamingEncoderV2) []int {
+			var out []int
+			for pos := 0; pos < len(input); pos += chunk {
+				out = append(out, se.Push(input[pos:min(pos+chunk, len(input))])...)
+			}
+			return append(out, se.Flush()...)
+		}
+
+		bucket := run(NewStreamingEncoderV2(tok, WithHeap(HeapBucket)))
+		binary := run(NewStreamingEncoderV2(tok, WithHeap(HeapBinary)))
+		if !reflect.DeepEqual(bucket, binary) {
+			t.Fatalf("chunk=%d: heaps disagree:\nbucket %v\nbinary %v", chunk, bucket, binary)
+		}
+	}
+}
+
+// pairingQueue is a MergeQueue the package doesn't ship: a pairing heap over (rank, push order).
+type pairingQueue struct {
+	root *pairingNode
+	seq  uint64
+	n    int
+}
+
+type pairingNode struct {
+	c       MergeCandidate
+	seq     uint64
+	child   *pairingNode
+	sibling *pairingNode
+}
+
+func (q *pairingQueue) meld(a, b *pairingNode) *pairingNode {
+	if a == nil {
+		return b
+	}
+	if b == nil {
+		return a
+	}
+	if b.c.Rank() < a.c.Rank() || b.c.Rank() == a.c.Rank() && b.seq < a.seq {
+		a, b = b, a
+	}
+	b.sibling, a.child = a.child, b
+	return a
+}
+
+func (q *pairingQueue) Push(c MergeCandidate) {
+	q.root = q.meld(q.root, &pairingNode{c: c, seq: q.seq})
+	q.seq++
+	q.n++
+}
+
+func (q *pairingQueue) Pop() (MergeCandidate, bool) {
+	if q.root == nil {
+		return MergeCandidate{}, false
+	}
+	top := q.root.c
+	var pairs []*pairingNode
+	for n := q.root.child; n != nil; {
+		a, b := n, n.sibling
+		if b == nil {
+			a.sibling, n = nil, nil
+		} else {
+			n = b.sibling
+			a.sibling, b.sibling = nil, nil
+		}
+		pairs = append(pairs, q.meld(a, b))
+	}
+	q.root = nil
+	for i := len(pairs) - 1; i >= 0; i-- {
+		q.root = q.meld(q.root, pairs[i])
+	}
+	q.n--
+	return top, true
+}
+
+func (q *pairingQueue) Empty() bool { return q.n == 0 }
+
+func (q *pairingQueue) Reset() { q.root, q.n = nil, 0 }
+
+func TestOptions_CustomMergeQueue(t *testing.T) {
+	tok, err := core.LoadTokenizerFromFiles("../testdata/gpt2/vocab.json", "../testdata/gpt2/merges.txt")
+	if err != nil {
+		t.Fatalf("load tokenizer: %v", err)
+	}
+	input := []byte("a priority queue from outside the package, drained in rank order: 東京, café, aaaaaaaa")
+
+	for _, chunk := range []int{1, 5, len(input)} {
+		se := NewStreamingEncoderV2(tok, WithMergeQueue(&pairingQueue{}))
+		var out []int
+		for pos := 0; pos < len(input); pos += chunk {
+			out = append(out, se.Push(input[pos:min(pos+chunk, len(input))])...)
+		}
+		out = append(out, se.Flush()...)
+		if want := tok.EncodeOffline(input, nil); !reflect.DeepEqual(out, want) {
+			t.Fatalf("chunk=%d: got %v, want %v", chunk, out, want)
+		}
+	}
+}
+
+func TestOptions_OnMerge(t *testing.T) {
+	tok, err := core.LoadTokenizerFromFiles("../testdata/gpt2/vocab.json", "../testdata/gpt2/merges.txt")
+	if err != nil {
+		t.Fatalf("load tokenizer: %v", err)
+	}
+	input := []byte("watching a stream converge, one merge at a time")
+
+	merges := 0
+	se := NewStreamingEncoderV2(tok, WithOnMerge(func(left, right, merged, pos int) {
+		merges++
+		l, r, m := tok.TokenBytes(left), tok.TokenBytes(right), tok.TokenBytes(merged)
+		if string(m) != string(l)+string(r) {
+			t.Fatalf("%q + %q reported as merging into %q", l, r, m)
+		}
+		if pos+len(m) > len(input) || string(input[pos:pos+len(m)]) != string(m) {
+			t.Fatalf("%q reported at offset %d", m, pos)
+		}
+	}))
+	var out []int
+	for pos := 0; pos < len(input); pos += 6 {
+		out = append(out, se.Push(input[pos:min(pos+6, len(input))])...)
+	}
+	// nothing was committed before Flush, so every merge from bytes to the final tokens happened in the
+	// list, and Flush re-encodes it the same way
+	if len(out) != 0 {
+		t.Fatalf("committed %v before Flush", out)
+	}
+	out = se.Flush()
+	if merges != len(input)-len(out) {
+		t.Fatalf("%d merges reported, want %d", merges, len(input)-len(out))
+	}
+}
+
+func TestOptions_ZeroCopyOutputReusesBuffer(t *testing.T) {
+	tok, err := core.LoadTokenizerFromFiles("../testdata/gpt2/vocab.json", "../testdata/gpt2/merges.txt")
+	if err != nil {
+		t.Fatalf("load tokenizer: %v", err)
+	}
+
+	se := NewStreamingEncoderV2(tok, WithZeroCopyOutput(true))
+
+	first := se.Push([]byte("hello there"))
+	first = append([]int(nil), first...)
+	a := se.Flush()
+	if len(a) == 0 {
+		t.Fatalf("expected flush output")
+	}
+	want := tok.EncodeOffline([]byte("hello there"), nil)
+	if got := append(first, a...); !reflect.DeepEqual(got, want) {
+		t.Fatalf("got %v want %v", got, want)
+	}
+
+	se.Push([]byte("general kenobi"))
+	b := se.Flush()
+	if &a[0] != &b[0] {
+		t.Fatalf("zero-copy flushes should share the output buffer")
+	}
+}
+
+func TestOptions_TailReserveClamped(t *testing.T) {
+	tok, err := core.LoadTokenizerFromFiles("../testdata/gpt2/vocab.json", "../testdata/gpt2/merges.txt")
+	if err != nil {
+		t.Fatalf("load tokenizer: %v", err)
+	}
+
+	if se := NewStreamingEncoderV2(tok, WithTailReserve(-5)); se.tailReserve != 0 {
+		t.Fatalf("negative tail reserve should clamp to 0, got %d", se.tailReserve)
+	}
+}
+
+func TestOptions_AllowedSpecialMatchesOffline(t *testing.T) {
+	base, err := core.LoadTokenizerFromFiles("../testdata/gpt2/vocab.json", "../testdata/gpt2/merges.txt")
+	if err != nil {
+		t.Fatalf("load tokenizer: %v", err)
+	}
+	n := base.VocabSize()
+	special := map[string]int{"<|endoftext|>": 50256, "<|im_start|>": n, "<|im_end|>": n + 1, "<|im|>": n + 2}
+
+	input := []byte("<|im_start|>user\nHi<|im|> there <|im_end|><|endoftext|>\n<|im_<|im_end|x<|im_end|>" +
+		"<|im_start|>assistant\nThe quick brown fox, 東京 and café<|im_end|><|im")
+	rng := rand.New(rand.NewPCG(3, 4))
+	for _, pre := range []core.PreTokenization{core.PreTokenizeNone, core.PreTokenizeGPT2} {
+		tok, err := core.Load(core.Files("../testdata/gpt2/vocab.json", "../testdata/gpt2/merges.txt"),
+			core.WithSpecialTokens(special), core.WithPreTokenization(pre))
+		if err != nil {
+			t.Fatalf("load tokenizer: %v", err)
+		}
+
+		for _, names := range [][]string{{core.AllSpecial}, {"<|im_start|>", "<|im_end|>"}} {
+			want, err := tok.EncodeWithSpecial(input, core.SpecialPolicy{Allowed: names, Disallowed: []string{}})
+			if err != nil {
+				t.Fatalf("EncodeWithSpecial: %v", err)
+			}
+
+			se := NewStreamingEncoderV2(tok, WithAllowedSpecial(names...))
+			for range 50 {
+				var got []int
+				for pos := 0; pos < len(input); {
+					end := min(pos+1+rng.IntN(16), len(input))
+					res := se.PushResult(input[pos:end])
+					got = append(got, res.Committed...)
+					all := append(slices.Clone(got), res.Provisional...)
+					if end == len(input) && !reflect.DeepEqual(all, want) {
+						t.Fatalf("%v %v: committed + provisional\ngot  %v\nwant %v", pre, names, all, want)
+					}
+					pos = end
+				}
+				got = append(got, se.Flush()...)
+				if !reflect.DeepEqual(got, want) {
+					t.Fatalf("%v %v:\ngot  %v\nwant %v", pre, names, got, want)
+				}
+			}
+		}
+	}
+}
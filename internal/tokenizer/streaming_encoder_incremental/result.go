@@ -0,0 +1,124 @@
+package streaming_encoder_incremental
+
+import "github.com/bpetok/internal/tokenizer/core"
+
+// PushResult is Push reporting through a core.StreamResult. Provisional re-encodes the held back tail on
+// every call, which costs up to reserveBatch tail reserves of offline encoding; use Push when it isn't
+// needed.
+func (se *StreamingEncoderV2) PushResult(chunk []byte) core.StreamResult {
+	start := se.streamBytes - se.pending
+	committed := se.Push(chunk)
+
+	se.revision++
+	return core.StreamResult{
+		Committed:     committed,
+		Provisional:   se.provisional(),
+		ConsumedBytes: se.streamBytes - se.pending,
+		Spans:         se.spans(start, committed),
+		Revision:      se.revision,
+	}
+}
+
+// FlushResult is Flush reporting through a core.StreamResult: everything is committed, so ConsumedBytes
+// is the length of the whole stream.
+func (se *StreamingEncoderV2) FlushResult() core.StreamResult {
+	start := se.streamBytes - se.pending
+	committed := se.Flush()
+	consumed := start
+	for _, id := range committed {
+		consumed += se.tok.TokenLen(id)
+	}
+
+	se.revision++
+	return core.StreamResult{
+		Committed:     committed,
+		ConsumedBytes: consumed,
+		Spans:         se.spans(start, committed),
+		Revision:      se.revision,
+	}
+}
+
+// spans returns the byte ranges of ids committed from stream offset start on, nil unless WithOffsets is
+// on. In zero-copy mode the slice reuses spanBuf.
+func (se *StreamingEncoderV2) spans(start int, ids []int) []core.Span {
+	if !se.offsets || len(ids) == 0 {
+		return nil
+	}
+	spans := []core.Span{}
+	if se.zeroCopy {
+		spans = se.spanBuf[:0]
+	}
+	for _, id := range ids {
+		end := start + se.tok.TokenLen(id)
+		spans = append(spans, core.Span{Start: start, End: end})
+		start = end
+	}
+	if se.zeroCopy {
+		se.spanBuf = spans
+	}
+	return spans
+}
+
+// provisional encodes the pending list plus whatever is held for the special token matcher or the
+// normalizer still carries, the way Flush would. In zero-copy mode the slice reuses provBuf.
+func (se *StreamingEncoderV2) provisional() []int {
+	buf := append(se.pendingBytes(), se.held...)
+	if se.normalizer != nil {
+		buf = se.normalizer.Peek(buf)
+	}
+	if se.prefixSpace && !se.started {
+		buf = core.PrefixSpace(buf)
+	}
+	if len(buf) == 0 {
+		return nil
+	}
+
+	out := []int{}
+	if se.zeroCopy {
+		out = se.provBuf[:0]
+	}
+	if se.special != nil {
+		out = append(out, se.tok.EncodeWithMatcher(buf, se.special)...)
+	} else {
+		for id := range se.tok.EncodeSeq(buf) {
+			out = append(out, id)
+		}
+	}
+	if se.zeroCopy {
+		se.provBuf = out
+	}
+	return out
+}
+
+// PushPatch is Push reporting through a core.StreamPatch against what the previous patches of the stream
+// built up; other calls in between leave the display out of step. Like PushResult it re-encodes the held
+// back tail on every call. Tokens is never aliased.
+func (se *StreamingEncoderV2) PushPatch(chunk []byte) core.StreamPatch {
+	res := se.PushResult(chunk)
+	return se.patch(res.Committed, res.Provisional)
+}
+
+// FlushPatch is Flush reporting through a core.StreamPatch: afterwards everything shown is committed and
+// the next patch starts a new stream.
+func (se *StreamingEncoderV2) FlushPatch() core.StreamPatch {
+	// Flush forgets what is shown, for streams that end without a patch
+	shown := se.shown
+	committed := se.FlushResult().Committed
+	se.shown = shown
+	return se.patch(committed, nil)
+}
+
+// patch diffs the IDs following the ones committed so far, committed then provisional, against the
+// provisional tail the last patch left shown.
+func (se *StreamingEncoderV2) patch(committed, provisional []int) core.StreamPatch {
+	cur := make([]int, 0, len(committed)+len(provisional))
+	cur = append(append(cur, committed...), provisional...)
+
+	common := 0
+	for common < min(len(se.shown), len(cur)) && se.shown[common] == cur[common] {
+		common++
+	}
+	p := core.StreamPatch{Retract: len(se.shown) - common, Tokens: cur[common:]}
+	se.shown = append(se.shown[:0], provisional...)
+	return p
+}
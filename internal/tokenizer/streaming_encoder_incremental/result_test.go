@@ -0,0 +1,165 @@
+package streaming_encoder_incremental
+
+import (
+	"math/rand"
+	"reflect"
+	"testing"
+
+	"github.com/bpetok/internal/tokenizer/core"
+)
+
+func TestPushResult_MatchesOffline(t *testing.T) {
+	tok, err := core.LoadTokenizerFromFiles("../testdata/gpt2/vocab.json", "../testdata/gpt2/merges.txt")
+	if err != nil {
+		t.Fatalf("load tokenizer: %v", err)
+	}
+
+	in := []byte("The quick brown fox jumps over the lazy dog. Streaming results, 12345 and naïve café 😀!")
+	want := tok.EncodeOffline(in, nil)
+	r := rand.New(rand.NewSource(9))
+
+	se := NewStreamingEncoderV2(tok)
+	var lastRev uint64
+	for iter := 0; iter < 20; iter++ {
+		var committed []int
+		for pos := 0; pos < len(in); {
+			end := min(pos+1+r.Intn(8), len(in))
+			res := se.PushResult(in[pos:end])
+			pos = end
+
+			if res.Revision <= lastRev {
+				t.Fatalf("revision %d after %d", res.Revision, lastRev)
+			}
+			lastRev = res.Revision
+
+			committed = append(committed, res.Committed...)
+			if got := len(tok.Decode(committed)); res.ConsumedBytes != got {
+				t.Fatalf("ConsumedBytes %d, committed IDs cover %d", res.ConsumedBytes, got)
+			}
+			// what's committed plus the provisional tail is the whole prefix
+			if got := string(tok.Decode(append(committed[:len(committed):len(committed)], res.Provisional...))); got != string(in[:pos]) {
+				t.Fatalf("committed+provisional decode to %q, want %q", got, in[:pos])
+			}
+		}
+
+		res := se.FlushResult()
+		committed = append(committed, res.Committed...)
+		if !reflect.DeepEqual(committed, want) {
+			t.Fatalf("iter %d: got %v, want %v", iter, committed, want)
+		}
+		if res.ConsumedBytes != len(in) || res.Provisional != nil {
+			t.Fatalf("flush: consumed %d of %d, provisional %v", res.ConsumedBytes, len(in), res.Provisional)
+		}
+		lastRev = res.Revision
+	}
+}
+
+func TestPushResult_ProvisionalIncludesNormalizerCarry(t *testing.T) {
+	tok, err := core.LoadTokenizerFromFiles("../testdata/gpt2/vocab.json", "../testdata/gpt2/merges.txt")
+	if err != nil {
+		t.Fatalf("load tokenizer: %v", err)
+	}
+
+	se := NewStreamingEncoderV2(tok, WithNormalization(core.NormalizeNFC), WithZeroCopyOutput(true))
+	// the trailing "e" may still take a combining accent, so the normalizer holds it
+	res := se.PushResult([]byte("a cafe"))
+	if got := string(tok.Decode(append(append([]int{}, res.Committed...), res.Provisional...))); got != "a cafe" {
+		t.Fatalf("committed+provisional decode to %q", got)
+	}
+	if res.ConsumedBytes+len(tok.Decode(res.Provisional)) != len("a cafe") {
+		t.Fatalf("consumed %d", res.ConsumedBytes)
+	}
+
+	committed := append([]int{}, res.Committed...)
+	committed = append(committed, se.PushResult([]byte("\u0301 au lait")).Committed...)
+	res = se.FlushResult()
+	committed = append(committed, res.Committed...)
+	if got := string(tok.Decode(committed)); got != "a café au lait" {
+		t.Fatalf("decoded %q", got)
+	}
+	if res.ConsumedBytes != len("a café au lait") {
+		t.Fatalf("consumed %d after flush", res.ConsumedBytes)
+	}
+}
+
+func TestPushResult_Spans(t *testing.T) {
+	tok, err := core.LoadTokenizerFromFiles("../testdata/gpt2/vocab.json", "../testdata/gpt2/merges.txt")
+	if err != nil {
+		t.Fatalf("load tokenizer: %v", err)
+	}
+	in := []byte("Redact the name Alice Smith<|endoftext|>, then map each token back to its bytes. café 😀")
+
+	for _, opts := range [][]Option{
+		{WithOffsets(true), WithAllowedSpecial(core.AllSpecial)},
+		{WithOffsets(true), WithCommitPolicy(CommitRankAware), WithZeroCopyOutput(true)},
+	} {
+		se := NewStreamingEncoderV2(tok, opts...)
+		next := 0
+		check := func(res core.StreamResult) {
+			if len(res.Spans) != len(res.Committed) {
+				t.Fatalf("%d spans for %d IDs", len(res.Spans), len(res.Committed))
+			}
+			for i, s := range res.Spans {
+				if s.Start != next || string(in[s.Start:s.End]) != string(tok.TokenBytes(res.Committed[i])) {
+					t.Fatalf("span %v for %q, next byte %d", s, tok.TokenBytes(res.Committed[i]), next)
+				}
+				next = s.End
+			}
+			if next != res.ConsumedBytes {
+				t.Fatalf("spans end at %d, ConsumedBytes %d", next, res.ConsumedBytes)
+			}
+		}
+		for pos := 0; pos < len(in); pos += 5 {
+			check(se.PushResult(in[pos:min(pos+5, len(in))]))
+		}
+		check(se.FlushResult())
+		if next != len(in) {
+			t.Fatalf("spans cover %d of %d bytes", next, len(in))
+		}
+	}
+
+	se := NewStreamingEncoderV2(tok)
+	if res := se.FlushResult(); res.Spans != nil {
+		t.Fatalf("spans without WithOffsets")
+	}
+	se.PushResult(in)
+	if res := se.FlushResult(); len(res.Committed) == 0 || res.Spans != nil {
+		t.Fatalf("spans without WithOffsets: %v", res.Spans)
+	}
+}
+
+func TestPushPatch_DisplayConverges(t *testing.T) {
+	tok, err := core.LoadTokenizerFromFiles("../testdata/gpt2/vocab.json", "../testdata/gpt2/merges.txt")
+	if err != nil {
+		t.Fatalf("load tokenizer: %v", err)
+	}
+	in := []byte("tokens show up at once and get patched when a merge lands across the boundary: international")
+
+	se := NewStreamingEncoderV2(tok, WithZeroCopyOutput(true))
+	for iter := 0; iter < 2; iter++ {
+		var shown []int
+		apply := func(p core.StreamPatch) {
+			if p.Retract > len(shown) {
+				t.Fatalf("retract %d of %d shown", p.Retract, len(shown))
+			}
+			shown = append(shown[:len(shown)-p.Retract], p.Tokens...)
+		}
+		retracted := 0
+		for pos := 0; pos < len(in); pos += 3 {
+			p := se.PushPatch(in[pos:min(pos+3, len(in))])
+			retracted += p.Retract
+			apply(p)
+			// the display always decodes to everything pushed so far
+			if got := string(tok.Decode(shown)); got != string(in[:min(pos+3, len(in))]) {
+				t.Fatalf("display decodes to %q", got)
+			}
+		}
+		apply(se.FlushPatch())
+		if want := tok.EncodeOffline(in, nil); !reflect.DeepEqual(shown, want) {
+			t.Fatalf("iter %d: display %v, want %v", iter, shown, want)
+		}
+		if retracted == 0 {
+			t.Fatalf("no merge ever landed across a chunk boundary")
+		}
+	}
+}
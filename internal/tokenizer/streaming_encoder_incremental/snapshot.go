@@ -0,0 +1,135 @@
+package streaming_encoder_incremental
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+)
+
+// Snapshot layout, integers are uvarints:
+//
+//	magic "BPETOKS\x00", version, flags (bit 0: the prefix space went out), streamBytes, revision,
+//	UTF-8 tracker offset, then four length-prefixed byte strings: the UTF-8 tracker's carry, the
+//	normalizer's carry, the pending bytes (list or split buffer), the bytes held for the special token
+//	matcher
+//	crc32c of everything before it (4 bytes, little-endian)
+const (
+	snapshotMagic   = "BPETOKS\x00"
+	snapshotVersion = 1
+
+	snapshotStarted = 1
+)
+
+// ErrSnapshotFormat is returned by Restore for data that isn't a snapshot this version can read or that
+// fails its checksum.
+var ErrSnapshotFormat = errors.New("bad encoder snapshot")
+
+var snapshotCRC = crc32.MakeTable(crc32.Castagnoli)
+
+// Snapshot returns the state of the current stream, for Restore to pick it up in another encoder, possibly
+// in another process, without pushing the stream's history again. Like TakePending it holds the input not
+// committed yet rather than the merge list, so it is small (about a tail reserve) and the restored encoder
+// rebuilds its list from it; unlike TakePending it leaves the stream going. Options, warnings and the
+// tokenizer aren't part of it: restore into an encoder built the same way over the same tokenizer.
+func (se *StreamingEncoderV2) Snapshot() []byte {
+	b := []byte(snapshotMagic)
+	flags := 0
+	if se.started {
+		flags |= snapshotStarted
+	}
+	carry, off := se.utf8.State()
+	for _, v := range []uint64{snapshotVersion, uint64(flags), uint64(se.streamBytes), se.revision, uint64(off)} {
+		b = binary.AppendUvarint(b, v)
+	}
+
+	var normCarry, pending []byte
+	if se.normalizer != nil {
+		normCarry = se.normalizer.Carry()
+	}
+	if se.splits != nil {
+		pending = se.splits.Pending()
+	} else {
+		pending = se.pendingBytes()
+	}
+	for _, s := range [][]byte{carry, normCarry, pending, se.held} {
+		b = binary.AppendUvarint(b, uint64(len(s)))
+		b = append(b, s...)
+	}
+	return binary.LittleEndian.AppendUint32(b, crc32.Checksum(b, snapshotCRC))
+}
+
+// Restore abandons the current stream, as Reset does, and continues the one data was taken from by
+// Snapshot: pushing the rest of that stream gives the IDs the original encoder would have. Nothing is
+// emitted by Restore itself. On error the encoder is left reset.
+func (se *StreamingEncoderV2) Restore(data []byte) error {
+	se.Reset()
+
+	bad := func(format string, args ...any) error {
+		return fmt.Errorf("%w: %s", ErrSnapshotFormat, fmt.Sprintf(format, args...))
+	}
+	if len(data) < len(snapshotMagic)+4 || string(data[:len(snapshotMagic)]) != snapshotMagic {
+		return bad("no snapshot header")
+	}
+	body := data[:len(data)-4]
+	if binary.LittleEndian.Uint32(data[len(body):]) != crc32.Checksum(body, snapshotCRC) {
+		return bad("checksum mismatch")
+	}
+
+	r := body[len(snapshotMagic):]
+	uv := func() uint64 {
+		v, n := binary.Uvarint(r)
+		if n <= 0 {
+			r = nil
+			return 0
+		}
+		r = r[n:]
+		return v
+	}
+	bytesField := func() []byte {
+		n := uv()
+		if n > uint64(len(r)) {
+			r = nil
+			return nil
+		}
+		s := r[:n]
+		r = r[n:]
+		return s
+	}
+
+	if v := uv(); v != snapshotVersion {
+		return bad("version %d", v)
+	}
+	flags, streamBytes, revision, off := uv(), uv(), uv(), uv()
+	carry, normCarry, pending, held := bytesField(), bytesField(), bytesField(), bytesField()
+	if r == nil || len(r) != 0 {
+		return bad("truncated or trailing data")
+	}
+	if flags&^snapshotStarted != 0 {
+		return bad("unknown flags %#x", flags)
+	}
+	if len(normCarry) > 0 && se.normalizer == nil || len(held) > 0 && se.special == nil {
+		return bad("state for a normalizer or special tokens this encoder doesn't have")
+	}
+
+	se.started = flags&snapshotStarted != 0
+	se.streamBytes = int(streamBytes)
+	se.revision = revision
+	se.utf8.SetState(carry, int64(off))
+	se.capped.Restart(int64(se.streamBytes - len(pending)))
+	if se.normalizer != nil {
+		se.normalizer.SetCarry(normCarry)
+	}
+	se.held = append(se.held, held...)
+
+	if se.splits != nil {
+		se.splits.SetPending(pending)
+		se.pending = len(pending)
+		return nil
+	}
+	// the list is rebuilt the way commitCut rebuilds its tail, without committing anything
+	newNodes := se.appendBytes(pending)
+	se.seedAdjacency(newNodes)
+	se.runMerges()
+	return nil
+}
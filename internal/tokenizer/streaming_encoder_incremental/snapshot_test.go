@@ -0,0 +1,109 @@
+package streaming_encoder_incremental
+
+import (
+	"errors"
+	"math/rand/v2"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/bpetok/internal/tokenizer/core"
+)
+
+// TestSnapshot_RestoreContinuesStream moves a stream to a fresh encoder through a snapshot at random
+// points and checks the output against the same chunks pushed into one encoder.
+func TestSnapshot_RestoreContinuesStream(t *testing.T) {
+	corpus, err := os.ReadFile("../testdata/gpt2/bench_corpus.txt")
+	if err != nil {
+		t.Fatalf("read corpus: %v", err)
+	}
+	text := string(corpus[:min(len(corpus), 32<<10)])
+	input := []byte(strings.ReplaceAll(text, ". ", ".<|endoftext|> café ") + "\xe6\x9d")
+
+	configs := []struct {
+		name string
+		load []core.Option
+		opts []Option
+	}{
+		{"plain", nil, nil},
+		{"normalized", []core.Option{core.WithNormalization(core.NormalizeNFC), core.WithAddPrefixSpace(true)}, nil},
+		{"pre-tokenized", []core.Option{core.WithPreTokenization(core.PreTokenizeGPT2)}, nil},
+		{"special", nil, []Option{WithAllowedSpecial(core.AllSpecial)}},
+	}
+	rng := rand.New(rand.NewPCG(7, 8))
+	for _, c := range configs {
+		tok, err := core.Load(core.Files("../testdata/gpt2/vocab.json", "../testdata/gpt2/merges.txt"), c.load...)
+		if err != nil {
+			t.Fatalf("load tokenizer: %v", err)
+		}
+
+		var cuts []int
+		for pos := 0; pos < len(input); {
+			pos = min(pos+1+rng.IntN(700), len(input))
+			cuts = append(cuts, pos)
+		}
+
+		opts := append([]Option{WithWarnings(true)}, c.opts...)
+		ref := NewStreamingEncoderV2(tok, opts...)
+		se := NewStreamingEncoderV2(tok, opts...)
+		var want, got []int
+		prev := 0
+		for i, end := range cuts {
+			want = append(want, ref.Push(input[prev:end])...)
+			got = append(got, se.Push(input[prev:end])...)
+			prev = end
+
+			if i%3 == 0 {
+				restored := NewStreamingEncoderV2(tok, opts...)
+				if err := restored.Restore(se.Snapshot()); err != nil {
+					t.Fatalf("%s: Restore: %v", c.name, err)
+				}
+				se = restored
+			}
+		}
+		want = append(want, ref.Flush()...)
+		got = append(got, se.Flush()...)
+
+		if !reflect.DeepEqual(got, want) {
+			t.Fatalf("%s: got %d tokens, want %d", c.name, len(got), len(want))
+		}
+		if !reflect.DeepEqual(se.Warnings(), ref.Warnings()) {
+			t.Fatalf("%s: warnings %v, want %v", c.name, se.Warnings(), ref.Warnings())
+		}
+	}
+}
+
+func TestSnapshot_RejectsBadData(t *testing.T) {
+	tok, err := core.LoadTokenizerFromFiles("../testdata/gpt2/vocab.json", "../testdata/gpt2/merges.txt")
+	if err != nil {
+		t.Fatalf("load tokenizer: %v", err)
+	}
+	se := NewStreamingEncoderV2(tok)
+	se.Push([]byte("some pending text"))
+	snap := se.Snapshot()
+
+	flipped := append([]byte(nil), snap...)
+	flipped[len(snapshotMagic)+3] ^= 1
+	for name, data := range map[string][]byte{
+		"empty":     nil,
+		"truncated": snap[:len(snap)-1],
+		"flipped":   flipped,
+		"magic":     append([]byte("BPETOKC\x00"), snap[len(snapshotMagic):]...),
+	} {
+		restored := NewStreamingEncoderV2(tok)
+		restored.Push([]byte("abandoned"))
+		if err := restored.Restore(data); !errors.Is(err, ErrSnapshotFormat) {
+			t.Fatalf("%s: got %v, want ErrSnapshotFormat", name, err)
+		}
+		if out := restored.Flush(); len(out) != 0 {
+			t.Fatalf("%s: encoder not reset after a failed Restore, flushed %v", name, out)
+		}
+	}
+
+	normalized := NewStreamingEncoderV2(tok, WithNormalization(core.NormalizeNFC))
+	normalized.Push([]byte("cafe"))
+	if err := NewStreamingEncoderV2(tok).Restore(normalized.Snapshot()); !errors.Is(err, ErrSnapshotFormat) {
+		t.Fatalf("normalizer carry into an encoder without one: got %v", err)
+	}
+}
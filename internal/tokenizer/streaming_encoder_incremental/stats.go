@@ -0,0 +1,41 @@
+package streaming_encoder_incremental
+
+// Stats counts the merge loop's work, for tuning the tail reserve and telling why a stream is slow: many
+// stale candidates per merge mean a lot of churn near the end of the list. The counters add up across
+// streams until ResetStats.
+type Stats struct {
+	// Merges is the number of merges performed in the list. Bytes re-encoded offline at commits aren't
+	// counted.
+	Merges uint64
+	// StaleCandidates is the number of queued candidates dropped because a merge had already changed one
+	// of their tokens.
+	StaleCandidates uint64
+	// HeapPushes is the number of candidates queued.
+	HeapPushes uint64
+}
+
+// Stats returns the counters so far.
+func (se *StreamingEncoderV2) Stats() Stats {
+	return se.stats
+}
+
+// ResetStats zeroes the counters.
+func (se *StreamingEncoderV2) ResetStats() {
+	se.stats = Stats{}
+}
+
+// PendingBytes returns how many bytes of input the encoder holds back: those of the pending tokens or
+// splits, the ones held for the special token matcher and the ones the normalizer carries.
+func (se *StreamingEncoderV2) PendingBytes() int {
+	n := se.pending + len(se.held)
+	if se.normalizer != nil {
+		n += se.normalizer.Pending()
+	}
+	return n
+}
+
+// PendingNodes returns the number of tokens in the merge list, 0 with a pre-tokenizer, which holds back
+// bytes instead.
+func (se *StreamingEncoderV2) PendingNodes() int {
+	return se.nodes
+}
@@ -0,0 +1,42 @@
+package streaming_encoder_incremental
+
+import (
+	"testing"
+
+	"github.com/bpetok/internal/tokenizer/core"
+)
+
+func TestStats_CountsMergeWork(t *testing.T) {
+	tok, err := core.LoadTokenizerFromFiles("../testdata/gpt2/vocab.json", "../testdata/gpt2/merges.txt")
+	if err != nil {
+		t.Fatalf("load tokenizer: %v", err)
+	}
+	se := NewStreamingEncoderV2(tok, WithNormalization(core.NormalizeNFC))
+
+	se.Push([]byte("hello wor"))
+	if se.PendingBytes() != 9 || se.PendingNodes() != len(tok.EncodeOffline([]byte("hello wor"), nil)) {
+		t.Fatalf("pending %d bytes in %d nodes", se.PendingBytes(), se.PendingNodes())
+	}
+	// the normalizer holds "e" back in case a combining mark follows
+	se.Push([]byte("ld, cafe"))
+	if se.PendingBytes() != 17 {
+		t.Fatalf("pending %d bytes, want 17", se.PendingBytes())
+	}
+
+	st := se.Stats()
+	if st.Merges == 0 || st.HeapPushes < st.Merges {
+		t.Fatalf("implausible stats %+v", st)
+	}
+	se.Flush()
+	if se.PendingBytes() != 0 || se.PendingNodes() != 0 {
+		t.Fatalf("pending %d bytes in %d nodes after Flush", se.PendingBytes(), se.PendingNodes())
+	}
+	if after := se.Stats(); after.Merges < st.Merges || after.HeapPushes < st.HeapPushes {
+		t.Fatalf("counters went back at Flush: %+v, was %+v", after, st)
+	}
+
+	se.ResetStats()
+	if se.Stats() != (Stats{}) {
+		t.Fatalf("ResetStats left %+v", se.Stats())
+	}
+}
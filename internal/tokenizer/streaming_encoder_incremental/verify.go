@@ -0,0 +1,239 @@
+package streaming_encoder_incremental
+
+import (
+	"fmt"
+	"slices"
+
+	"github.com/bpetok/internal/tokenizer/core"
+)
+
+// divergenceContext is how many IDs, and four times as many bytes, a Divergence shows on either side of
+// where the two encodings part.
+const divergenceContext = 8
+
+// Divergence is where a VerifyingEncoder's streamed IDs first differ from the offline encoding of the
+// same stream.
+type Divergence struct {
+	// Index is the position among the stream's IDs of the first one that differs, Offset the byte where it
+	// starts in the normalized stream, both counted from the last Flush.
+	Index, Offset int
+	// Before holds up to divergenceContext IDs both sides agree on right before Index, Got and Want up to as
+	// many from Index on as the streaming encoder and EncodeOffline had produced. When one side ends the
+	// stream with IDs the other doesn't have, its counterpart is empty.
+	Before, Got, Want []int
+	// Text is the normalized input around Offset, starting at byte TextStart of the stream.
+	Text      []byte
+	TextStart int
+}
+
+func (d *Divergence) Error() string {
+	return fmt.Sprintf("streaming encoder diverged at ID %d (byte %d): got %v, want %v after %v in %q",
+		d.Index, d.Offset, d.Got, d.Want, d.Before, d.Text)
+}
+
+// VerifyingEncoder is a StreamingEncoderV2 checked against EncodeOffline as the stream goes, as a safety net
+// for the streaming path in staging. It encodes a copy of the input offline in pieces no later input can
+// change: whole splits under a pre-tokenizer, up to the last junction no merge can join (see
+// core.Tokenizer.CanJoin) otherwise, and the whole stream at once when special tokens are on. Every ID
+// the encoder commits is compared as soon as its offline counterpart is known. Its output is the
+// encoder's, unchanged; the checks cost about an offline encode of the input on top.
+type VerifyingEncoder struct {
+	se           *StreamingEncoderV2
+	onDivergence func(*Divergence)
+
+	// the offline side normalizes and adds the prefix space on its own, as Prepare would
+	normalizer *core.StreamNormalizer
+	splits     *core.SplitBuffer
+	started    bool
+
+	// buf holds the normalized stream from byte bufStart on. want holds the offline IDs of buf up to
+	// encoded and got the streamed IDs, both from the first not compared yet, which is ID index of the
+	// stream and starts at byte offset. scanned is how far buf has been checked for junctions.
+	buf       []byte
+	bufStart  int
+	encoded   int
+	scanned   int
+	want, got []int
+	index     int
+	offset    int
+	before    []int
+	diverged  bool
+}
+
+// NewVerifyingEncoder returns a StreamingEncoderV2 built from opts, checked as it goes. onDivergence is
+// called with the first divergence of a stream, from the Push or Flush that finds it; the rest of the
+// stream goes unchecked.
+func NewVerifyingEncoder(tok *core.Tokenizer, onDivergence func(*Divergence), opts ...Option) *VerifyingEncoder {
+	se := NewStreamingEncoderV2(tok, opts...)
+	ve := &VerifyingEncoder{
+		se:           se,
+		onDivergence: onDivergence,
+		normalizer:   se.normalizer.Fresh(),
+	}
+	if se.splits != nil {
+		ve.splits = core.NewSplitBuffer(tok.PreTokenization())
+	}
+	return ve
+}
+
+// Feed implements core.Encoder.
+func (ve *VerifyingEncoder) Feed(chunk []byte) []int {
+	return ve.Push(chunk)
+}
+
+// Push is the encoder's Push, with its output checked.
+func (ve *VerifyingEncoder) Push(chunk []byte) []int {
+	out := ve.se.Push(chunk)
+	if ve.diverged || len(chunk) == 0 {
+		return out
+	}
+
+	if ve.normalizer != nil {
+		chunk = ve.normalizer.Push(chunk)
+	}
+	ve.expect(ve.prefix(chunk), false)
+	ve.got = append(ve.got, out...)
+	ve.compare()
+	return out
+}
+
+// Flush is the encoder's Flush, with the rest of the stream checked.
+func (ve *VerifyingEncoder) Flush() []int {
+	out := ve.se.Flush()
+	if !ve.diverged {
+		var rest []byte
+		if ve.normalizer != nil {
+			rest = ve.normalizer.Flush()
+		}
+		ve.expect(ve.prefix(rest), true)
+		ve.got = append(ve.got, out...)
+		ve.compare()
+		if !ve.diverged && len(ve.got)+len(ve.want) > 0 {
+			ve.report()
+		}
+	}
+	ve.reset()
+	return out
+}
+
+// Reset abandons the stream unchecked, see StreamingEncoderV2.Reset.
+func (ve *VerifyingEncoder) Reset() {
+	ve.se.Reset()
+	ve.reset()
+}
+
+// Warnings returns the encoder's warnings.
+func (ve *VerifyingEncoder) Warnings() []core.Warning {
+	return ve.se.Warnings()
+}
+
+// ResetWarnings clears the encoder's warnings.
+func (ve *VerifyingEncoder) ResetWarnings() {
+	ve.se.ResetWarnings()
+}
+
+// prefix adds the prefix space to the stream's first normalized bytes, if the encoder adds one.
+func (ve *VerifyingEncoder) prefix(chunk []byte) []byte {
+	if !ve.se.prefixSpace || ve.started || len(chunk) == 0 {
+		return chunk
+	}
+	ve.started = true
+	return core.PrefixSpace(chunk)
+}
+
+// expect appends normalized bytes to buf and encodes offline what of buf has become final, all of it at the
+// end of the stream.
+func (ve *VerifyingEncoder) expect(b []byte, end bool) {
+	ve.buf = append(ve.buf, b...)
+	final := ve.encoded
+	switch {
+	case end:
+		final = len(ve.buf)
+	case ve.se.special != nil:
+		// a special token's text can start anywhere, leave it all to the end
+	case ve.splits != nil:
+		final += len(ve.splits.Push(b))
+	default:
+		for i := max(ve.scanned, ve.encoded+1); i < len(ve.buf); i++ {
+			if !ve.se.tok.CanJoin(ve.buf[i-1], ve.buf[i]) {
+				final = i
+			}
+		}
+		ve.scanned = len(ve.buf)
+	}
+	if final == ve.encoded {
+		return
+	}
+
+	part := ve.buf[ve.encoded:final]
+	if ve.se.special != nil {
+		ve.want = append(ve.want, ve.se.tok.EncodeWithMatcher(part, ve.se.special)...)
+	} else {
+		ve.want = append(ve.want, ve.se.tok.EncodeOffline(part, nil)...)
+	}
+	ve.encoded = final
+}
+
+// compare matches got against want as far as both go, reporting the first ID that differs, and drops the
+// matched IDs and the bytes no Divergence can show anymore.
+func (ve *VerifyingEncoder) compare() {
+	n := 0
+	for n < min(len(ve.got), len(ve.want)) && ve.got[n] == ve.want[n] {
+		n++
+	}
+	for _, id := range ve.want[:n] {
+		ve.offset += ve.se.tok.TokenLen(id)
+	}
+	ve.before = append(ve.before, ve.want[:n]...)
+	ve.before = ve.before[:copy(ve.before, ve.before[max(len(ve.before)-divergenceContext, 0):])]
+	ve.got = ve.got[:copy(ve.got, ve.got[n:])]
+	ve.want = ve.want[:copy(ve.want, ve.want[n:])]
+	ve.index += n
+
+	if len(ve.got) > 0 && len(ve.want) > 0 {
+		ve.report()
+		return
+	}
+	if drop := ve.offset - 4*divergenceContext - ve.bufStart; drop > len(ve.buf)/2 {
+		ve.buf = ve.buf[:copy(ve.buf, ve.buf[drop:])]
+		ve.bufStart += drop
+		ve.encoded -= drop
+		ve.scanned = max(ve.scanned-drop, 0)
+	}
+}
+
+// report hands the divergence at index to onDivergence and stops checking the stream.
+func (ve *VerifyingEncoder) report() {
+	ve.diverged = true
+	if ve.onDivergence == nil {
+		return
+	}
+
+	start := max(ve.offset-4*divergenceContext, ve.bufStart)
+	end := min(ve.offset+4*divergenceContext, ve.bufStart+len(ve.buf))
+	ve.onDivergence(&Divergence{
+		Index:     ve.index,
+		Offset:    ve.offset,
+		Before:    slices.Clone(ve.before),
+		Got:       slices.Clone(ve.got[:min(len(ve.got), divergenceContext)]),
+		Want:      slices.Clone(ve.want[:min(len(ve.want), divergenceContext)]),
+		Text:      slices.Clone(ve.buf[start-ve.bufStart : end-ve.bufStart]),
+		TextStart: start,
+	})
+}
+
+// reset readies the offline side for a new stream.
+func (ve *VerifyingEncoder) reset() {
+	if ve.normalizer != nil {
+		ve.normalizer.Reset()
+	}
+	if ve.splits != nil {
+		ve.splits.Reset()
+	}
+	ve.started = false
+	ve.buf = ve.buf[:0]
+	ve.bufStart, ve.encoded, ve.scanned = 0, 0, 0
+	ve.want, ve.got, ve.before = ve.want[:0], ve.got[:0], ve.before[:0]
+	ve.index, ve.offset = 0, 0
+	ve.diverged = false
+}
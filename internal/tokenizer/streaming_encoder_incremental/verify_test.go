@@ -0,0 +1,99 @@
+package streaming_encoder_incremental
+
+import (
+	"bytes"
+	"math/rand/v2"
+	"os"
+	"reflect"
+	"testing"
+
+	"github.com/bpetok/internal/tokenizer/core"
+)
+
+func TestVerifyingEncoder_NoFalseAlarms(t *testing.T) {
+	corpus, err := os.ReadFile("../testdata/gpt2/bench_corpus.txt")
+	if err != nil {
+		t.Fatalf("read corpus: %v", err)
+	}
+	input := append(corpus[:min(len(corpus), 32<<10)], "<|endoftext|> café ́e 東京\xff"...)
+
+	rng := rand.New(rand.NewPCG(5, 6))
+	for _, load := range [][]core.Option{
+		nil,
+		{core.WithPreTokenization(core.PreTokenizeGPT2)},
+		{core.WithNormalization(core.NormalizeNFC), core.WithAddPrefixSpace(true)},
+	} {
+		tok, err := core.Load(core.Files("../testdata/gpt2/vocab.json", "../testdata/gpt2/merges.txt"), load...)
+		if err != nil {
+			t.Fatalf("load tokenizer: %v", err)
+		}
+		for _, opts := range [][]Option{
+			nil,
+			{WithCommitPolicy(CommitRankAware), WithZeroCopyOutput(true)},
+			{WithAllowedSpecial(core.AllSpecial)},
+		} {
+			ve := NewVerifyingEncoder(tok, func(d *Divergence) { t.Fatalf("false alarm: %v", d) }, opts...)
+			for range 2 {
+				var got []int
+				for pos := 0; pos < len(input); {
+					end := min(pos+1+rng.IntN(300), len(input))
+					got = append(got, ve.Push(input[pos:end])...)
+					pos = end
+				}
+				got = append(got, ve.Flush()...)
+				if len(got) == 0 {
+					t.Fatalf("nothing emitted")
+				}
+			}
+		}
+	}
+}
+
+func TestVerifyingEncoder_ReportsFirstDivergence(t *testing.T) {
+	tok, err := core.LoadTokenizerFromFiles("../testdata/gpt2/vocab.json", "../testdata/gpt2/merges.txt")
+	if err != nil {
+		t.Fatalf("load tokenizer: %v", err)
+	}
+	input := []byte("The quick brown fox jumps over the lazy dog while thinking about tokens")
+
+	// a tail reserve of 0 commits tokens later chunks would have merged into
+	var reports []*Divergence
+	ve := NewVerifyingEncoder(tok, func(d *Divergence) { reports = append(reports, d) }, WithTailReserve(0))
+	var got []int
+	for pos := 0; pos < len(input); pos += 3 {
+		got = append(got, ve.Push(input[pos:min(pos+3, len(input))])...)
+	}
+	got = append(got, ve.Flush()...)
+
+	want := tok.EncodeOffline(input, nil)
+	index, offset := 0, 0
+	for got[index] == want[index] {
+		offset += tok.TokenLen(want[index])
+		index++
+	}
+	if len(reports) != 1 {
+		t.Fatalf("got %d reports, want 1", len(reports))
+	}
+	d := reports[0]
+	if d.Index != index || d.Offset != offset {
+		t.Fatalf("reported ID %d at byte %d, want ID %d at byte %d", d.Index, d.Offset, index, offset)
+	}
+	if len(d.Got) == 0 || len(d.Want) == 0 || d.Got[0] != got[index] || d.Want[0] != want[index] {
+		t.Fatalf("got %v want %v, the streams part at %d and %d", d.Got, d.Want, got[index], want[index])
+	}
+	if !reflect.DeepEqual(d.Before, want[max(index-divergenceContext, 0):index]) {
+		t.Fatalf("before: got %v, want %v", d.Before, want[max(index-divergenceContext, 0):index])
+	}
+	if !bytes.Equal(d.Text, input[d.TextStart:d.TextStart+len(d.Text)]) || d.TextStart > offset ||
+		d.TextStart+len(d.Text) <= offset {
+		t.Fatalf("text %q from %d doesn't cover byte %d", d.Text, d.TextStart, offset)
+	}
+
+	// the next stream is checked afresh
+	reports = nil
+	ve.Push(input)
+	ve.Flush()
+	if len(reports) != 0 {
+		t.Fatalf("a single chunk stream diverged: %v", reports[0])
+	}
+}
@@ -1,6 +1,7 @@
 package streaming_encoder_naive
 
 import (
+	"fmt"
 	"os"
 	"sync"
 	"testing"
@@ -91,6 +92,30 @@ func BenchmarkNaiveEncodeStreaming_4KBChunks(b *testing.B) {
 	}
 }
 
+// BenchmarkNaiveEncodeStreaming_SmallChunks feeds the corpus a few bytes at a time, the workload where
+// re-encoding the whole held back tail on every Push used to dominate.
+func BenchmarkNaiveEncodeStreaming_SmallChunks(b *testing.B) {
+	tok := loadTestTokenizerB(b)
+	input := mustLoadBenchCorpus(b, "../testdata/gpt2/bench_corpus.txt")
+	input = input[:min(len(input), 256<<10)]
+
+	for _, chunkSize := range []int{1, 16} {
+		b.Run(fmt.Sprintf("%dB", chunkSize), func(b *testing.B) {
+			b.SetBytes(int64(len(input)))
+			b.ReportAllocs()
+			b.ResetTimer()
+
+			for i := 0; i < b.N; i++ {
+				es := NewNaiveStreamingEncoderState(tok)
+				for pos := 0; pos < len(input); pos += chunkSize {
+					_ = es.Push(input[pos:min(pos+chunkSize, len(input))])
+				}
+				_ = es.Flush()
+			}
+		})
+	}
+}
+
 func loadTestTokenizerB(b *testing.B) *core.Tokenizer {
 	b.Helper()
 	tok, err := core.LoadTokenizerFromFiles(
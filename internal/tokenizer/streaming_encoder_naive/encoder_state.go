@@ -3,17 +3,37 @@ package streaming_encoder_naive
 import "github.com/bpetok/internal/tokenizer/core"
 
 // NaiveStreamingEncoderState implements a NAIVE (greedy) streaming encoder by buffering input bytes
-// and greedily flushing any prefix that is guaranteed not to participate in
-// future merges (based off of the max token size in our vocab). The final lMax-1 bytes are held back as a safety margin so
-// merges that span chunk boundaries are preserved.
+// and greedily flushing any prefix that ends at a junction no merge can join, so it can't take part in
+// future merges. The final lMax-1 bytes are also held back, which batches the flushes.
 type NaiveStreamingEncoderState struct {
 	core.BaseEncoderState
 
 	tok         *core.Tokenizer
 	tailReserve int
 
+	// buf[start:] holds the bytes not emitted yet; Push moves them back to the front before appending, so
+	// buf is copied at most once per Push and keeps its capacity
 	buf    []byte
+	start  int
 	outBuf []int
+	// cache holds the tokens of buf[:cacheLen], which ends at a junction no merge can join (see
+	// core.Tokenizer.CanJoin), so they never change; joinScan is how far buf has been checked for such
+	// junctions. Only the bytes after the last one are re-encoded on each Push, see emitCommitted.
+	cache    []int
+	cacheLen int
+	joinScan int
+	// splits replaces buf for a tokenizer with a pre-tokenizer, whose whole splits are final as soon as no
+	// later input can move their end, nil otherwise
+	splits *core.SplitBuffer
+
+	// normalizer and prefixSpace are only set by NewNaiveEncoder, the other constructors take final bytes
+	normalizer  *core.StreamNormalizer
+	prefixSpace bool
+	started     bool
+	// utf8 and capped feed warnings, they only run once warnings are on, see NewNaiveEncoder
+	utf8     core.UTF8Tracker
+	capped   core.CappedRankTracker
+	warnings core.Warnings
 }
 
 // NewNaiveStreamingEncoderState returns a new instance of the encoder state with opt params disabled.
@@ -33,26 +53,36 @@ func NewNaiveStreamingEncoderState(t *core.Tokenizer) *NaiveStreamingEncoderStat
 		},
 		tok:         t,
 		tailReserve: tail,
+		splits:      core.NewSplitBuffer(t.PreTokenization()),
 	}
 }
 
-// NewNaiveStreamingEncoderStateWithOpts returns a new instance of the encoder state with opt params.
-func NewNaiveStreamingEncoderStateWithOpts(t *core.Tokenizer, optPreAllocScratch bool, optFlattenLookup bool, optHotLoopTighten bool, optOutBufReuse bool, optNoCopyReturn bool) *NaiveStreamingEncoderState {
+// NewNaiveEncoder returns an encoder over tok that normalizes its input and adds a prefix space the way tok
+// was loaded to, so its output equals EncodeOffline over the normalized stream; it implements core.Encoder.
+// The other constructors leave both to the caller. zeroCopy sets OptNoCopyReturn, warnings turns on
+// recording for Warnings, see core.Warnings.
+func NewNaiveEncoder(t *core.Tokenizer, zeroCopy, warnings bool) *NaiveStreamingEncoderState {
+	st := NewNaiveStreamingEncoderState(t)
+	st.OptNoCopyReturn = zeroCopy
+	st.warnings.Enable(warnings)
+	st.normalizer = core.NewStreamNormalizer(t.Normalization())
+	st.prefixSpace = t.AddPrefixSpace()
+	return st
+}
+
+// NewNaiveStreamingEncoderStateWithOpts returns a new instance of the encoder state with the opt params set in
+// opts, the zero value leaves them all disabled.
+func NewNaiveStreamingEncoderStateWithOpts(t *core.Tokenizer, opts core.BaseEncoderState) *NaiveStreamingEncoderState {
 	tail := 0
 	if t.MaxTokenByteLen > 0 {
 		tail = t.MaxTokenByteLen - 1
 	}
 
 	st := NaiveStreamingEncoderState{
-		BaseEncoderState: core.BaseEncoderState{
-			OptPreAllocScratch: optPreAllocScratch,
-			OptFlattenLookup:   optFlattenLookup,
-			OptHotLoopTighten:  optHotLoopTighten,
-			OptOutBufReuse:     optOutBufReuse,
-			OptNoCopyReturn:    optNoCopyReturn,
-		},
-		tok:         t,
-		tailReserve: tail,
+		BaseEncoderState: opts,
+		tok:              t,
+		tailReserve:      tail,
+		splits:           core.NewSplitBuffer(t.PreTokenization()),
 	}
 
 	if st.OptOutBufReuse {
@@ -73,14 +103,22 @@ func (st *NaiveStreamingEncoderState) returnOut() []int {
 	return out
 }
 
+// Feed implements core.Encoder.
+func (st *NaiveStreamingEncoderState) Feed(chunk []byte) []int {
+	return st.Push(chunk)
+}
+
 // Push consumes the next chunk of raw bytes and emits any finalized tokens.
 func (st *NaiveStreamingEncoderState) Push(chunk []byte) []int {
 	st.outBuf = st.outBuf[:0]
-	if len(chunk) > 0 {
-		st.buf = append(st.buf, chunk...)
+	if len(chunk) > 0 && st.warnings.Enabled() {
+		st.utf8.Feed(chunk, st.invalidUTF8)
 	}
-
-	st.emitCommitted()
+	if st.normalizer != nil {
+		chunk = st.normalizer.Push(chunk)
+	}
+	st.push(st.prefix(chunk))
+	st.checkCapped()
 
 	if len(st.outBuf) == 0 {
 		return nil
@@ -88,14 +126,48 @@ func (st *NaiveStreamingEncoderState) Push(chunk []byte) []int {
 	return st.returnOut()
 }
 
+// push adds final bytes to the stream, appending the tokens they commit to outBuf.
+func (st *NaiveStreamingEncoderState) push(chunk []byte) {
+	if st.splits != nil {
+		// tokens never cross a split, so whole splits no later input can change are final as they are
+		if final := st.splits.Push(chunk); len(final) > 0 {
+			st.outBuf = append(st.outBuf, st.tok.EncodeOffline(final, &st.BaseEncoderState)...)
+		}
+	} else {
+		if st.start > 0 {
+			st.buf = st.buf[:copy(st.buf, st.buf[st.start:])]
+			st.start = 0
+		}
+		st.buf = append(st.buf, chunk...)
+		st.emitCommitted()
+	}
+}
+
 // Flush encodes whatever bytes remain in the internal buffer.
 func (st *NaiveStreamingEncoderState) Flush() []int {
+	if st.warnings.Enabled() {
+		st.utf8.Finish(st.invalidUTF8)
+	}
 	st.outBuf = st.outBuf[:0]
-	if len(st.buf) > 0 {
-		tokens := st.tok.EncodeOffline(st.buf, &st.BaseEncoderState)
+	if st.normalizer != nil {
+		if rest := st.prefix(st.normalizer.Flush()); len(rest) > 0 {
+			st.push(rest)
+		}
+	}
+	st.started = false
+	if st.splits != nil {
+		st.buf, st.start = append(st.buf[:0], st.splits.Pending()...), 0
+		st.splits.Reset()
+	}
+	if len(st.buf) > st.start {
+		st.outBuf = append(st.outBuf, st.cache...)
+		tokens := st.tok.EncodeOffline(st.buf[st.start+st.cacheLen:], &st.BaseEncoderState)
 		st.outBuf = append(st.outBuf, tokens...)
-		st.buf = st.buf[:0]
 	}
+	st.buf, st.start = st.buf[:0], 0
+	st.resetCache()
+	st.checkCapped()
+	st.capped.Reset()
 
 	if len(st.outBuf) == 0 {
 		return nil
@@ -104,16 +176,92 @@ func (st *NaiveStreamingEncoderState) Flush() []int {
 	return st.returnOut()
 }
 
+// checkCapped looks for capped ranks in the output collected in outBuf.
+func (st *NaiveStreamingEncoderState) checkCapped() {
+	if st.warnings.Enabled() && st.tok.HasCappedRanks() {
+		st.capped.Feed(st.tok, st.outBuf, st.cappedRank)
+	}
+}
+
+// TakePending ends the stream without encoding the bytes held back: it returns them and resets the encoder
+// as Flush would. Pushing them into another encoder continues the stream exactly where this one's output
+// stopped. The slice is the caller's. An encoder from NewNaiveEncoder returns them normalized, the way
+// the other constructors take them.
+func (st *NaiveStreamingEncoderState) TakePending() []byte {
+	pending := append([]byte(nil), st.buf[st.start:]...)
+	st.buf, st.start = st.buf[:0], 0
+	st.resetCache()
+	if st.splits != nil {
+		pending = append(pending, st.splits.Pending()...)
+		st.splits.Reset()
+	}
+	if st.normalizer != nil {
+		pending = append(pending, st.prefix(st.normalizer.Flush())...)
+	}
+	st.started = false
+	st.utf8 = core.UTF8Tracker{}
+	st.capped.Reset()
+	return pending
+}
+
+// Warnings returns the non-fatal anomalies seen so far, nil unless warnings are on. They accumulate across
+// streams until ResetWarnings is called.
+func (st *NaiveStreamingEncoderState) Warnings() []core.Warning {
+	return st.warnings.List()
+}
+
+// ResetWarnings clears the recorded warnings.
+func (st *NaiveStreamingEncoderState) ResetWarnings() {
+	st.warnings.Reset()
+}
+
+// prefix adds the prefix space to the stream's first normalized bytes, if it is on.
+func (st *NaiveStreamingEncoderState) prefix(chunk []byte) []byte {
+	if !st.prefixSpace || st.started || len(chunk) == 0 {
+		return chunk
+	}
+	st.started = true
+	return core.PrefixSpace(chunk)
+}
+
+func (st *NaiveStreamingEncoderState) invalidUTF8(off int64, b byte) {
+	st.warnings.Add(core.WarnInvalidUTF8, off, "invalid UTF-8 byte 0x%02x", b)
+}
+
+func (st *NaiveStreamingEncoderState) cappedRank(off int64, left, right, rank int) {
+	st.warnings.Add(core.WarnCappedRank, off, "tokens %d and %d left unmerged, their merge has rank %d", left, right, rank)
+}
+
+// emitCommitted emits the cached tokens of buf that end at least tailReserve bytes before its end. The
+// cache ends at the last junction no merge can join, so its tokens are the ones the whole stream encodes
+// to; bytes after it are left to a later Push or Flush, however far back they reach, since no count of
+// held back bytes keeps a later merge from re-pairing them. The cache is only grown once the emitted
+// tokens reach its end, so in text that is one encode of about tailReserve bytes every tailReserve bytes;
+// input without such junctions is held back whole until one arrives. Push has just moved the held bytes
+// to the front of buf, so start is 0 here.
 func (st *NaiveStreamingEncoderState) emitCommitted() {
 	emitLimit := len(st.buf) - st.tailReserve
 	if emitLimit <= 0 {
 		return
 	}
 
-	tokens := st.tok.EncodeOffline(st.buf, &st.BaseEncoderState)
+	if st.cacheLen < emitLimit {
+		// the cache falls short of the limit: grow it up to the last junction
+		junction := st.cacheLen
+		for i := max(st.joinScan, st.cacheLen+1); i < len(st.buf); i++ {
+			if !st.tok.CanJoin(st.buf[i-1], st.buf[i]) {
+				junction = i
+			}
+		}
+		st.joinScan = len(st.buf)
+		if junction > st.cacheLen {
+			st.cache = append(st.cache, st.tok.EncodeOffline(st.buf[st.cacheLen:junction], &st.BaseEncoderState)...)
+			st.cacheLen = junction
+		}
+	}
 
-	consumed := 0
-	for _, id := range tokens {
+	consumed, n := 0, 0
+	for _, id := range st.cache {
 		tokLen := st.tok.TokenLen(id)
 		if consumed+tokLen > emitLimit {
 			break
@@ -121,9 +269,21 @@ func (st *NaiveStreamingEncoderState) emitCommitted() {
 
 		st.outBuf = append(st.outBuf, id)
 		consumed += tokLen
+		n++
 	}
 
-	if consumed > 0 {
-		st.buf = st.buf[consumed:]
+	if consumed == 0 {
+		return
 	}
+	st.start = consumed
+	st.joinScan -= consumed
+	st.cache = st.cache[:copy(st.cache, st.cache[n:])]
+	st.cacheLen -= consumed
+}
+
+// resetCache forgets the cached tokens, for a buf that starts over.
+func (st *NaiveStreamingEncoderState) resetCache() {
+	st.cache = st.cache[:0]
+	st.cacheLen = 0
+	st.joinScan = 0
 }
@@ -1,9 +1,13 @@
 package streaming_encoder_naive
 
 import (
+	"bytes"
+	"encoding/base64"
+	"fmt"
 	"math/rand"
 	"os"
 	"path/filepath"
+	"strings"
 	"testing"
 	"time"
 
@@ -165,3 +169,74 @@ func equalIntSlices(a, b []int) bool {
 
 	return true
 }
+
+// TestNaiveStreamingMatchesGreedy_LongSmallChunks covers inputs long enough for the cached encode of the
+// buffer's front to be emitted from, grown and dropped many times over.
+func TestNaiveStreamingMatchesGreedy_LongSmallChunks(t *testing.T) {
+	tok := loadTestTokenizer(t)
+
+	corpus, err := os.ReadFile("../testdata/gpt2/bench_corpus.txt")
+	if err != nil {
+		t.Skipf("bench corpus unavailable: %v", err)
+	}
+	cases := map[string][]byte{
+		"corpus":    corpus[:min(len(corpus), 16<<10)],
+		"no_spaces": bytes.Repeat([]byte("abcdefgh"), 512),
+		"mixed":     []byte(strings.Repeat("hello world, "+strings.Repeat("x", 300)+" 你好\n", 8)),
+	}
+
+	for name, input := range cases {
+		want := tok.EncodeOffline(input, nil)
+		for _, chunk := range []int{1, 7, 64} {
+			got := encodeStreamingNaive(t, tok, input, []int{chunk})
+			if !equalIntSlices(want, got) {
+				t.Fatalf("%s chunk %d: mismatch (%d tokens, want %d)", name, chunk, len(got), len(want))
+			}
+		}
+	}
+}
+
+// TestNaiveStreaming_BufferIsReused checks that a long stream keeps one buffer instead of sliding along
+// ever new ones.
+func TestNaiveStreaming_BufferIsReused(t *testing.T) {
+	tok := loadTestTokenizer(t)
+	es := NewNaiveStreamingEncoderState(tok)
+
+	chunk := []byte("the quick brown fox jumps over the lazy dog, ")
+	for i := 0; i < 100; i++ {
+		es.Push(chunk)
+	}
+	backing := &es.buf[:1][0]
+	for i := 0; i < 20000; i++ {
+		es.Push(chunk)
+		if &es.buf[:1][0] != backing {
+			t.Fatalf("push %d: buffer reallocated (len %d, cap %d)", i, len(es.buf), cap(es.buf))
+		}
+	}
+	es.Flush()
+}
+
+// With pairs of adjacent letters ranked from the end of the alphabet down, one more letter re-pairs a
+// whole run, so holding back a maximum token length isn't enough: a..x encodes as ab cd .. wx, a..y as
+// a bc de .. xy.
+func TestNaiveStreaming_RightToLeftMerges(t *testing.T) {
+	var sb strings.Builder
+	for id := range 256 {
+		fmt.Fprintf(&sb, "%s %d\n", base64.StdEncoding.EncodeToString([]byte{byte(id)}), id)
+	}
+	for i := range 25 {
+		fmt.Fprintf(&sb, "%s %d\n", base64.StdEncoding.EncodeToString([]byte{'y' - byte(i), 'z' - byte(i)}), 256+i)
+	}
+	tok, err := core.LoadTokenizerFromTiktokenBytes([]byte(sb.String()))
+	if err != nil {
+		t.Fatalf("load: %v", err)
+	}
+
+	input := []byte("abcdefghijklmnopqrstuvwxy.abcdefghijklmnopqrstuvwxyz,mnopqrs")
+	want := tok.EncodeOffline(input, nil)
+	for _, chunks := range [][]int{{24, 1}, {1}, {3}, {7, 2}, {25, 1}} {
+		if got := encodeStreamingNaive(t, tok, input, chunks); !equalIntSlices(got, want) {
+			t.Fatalf("chunks %v: got %v, want %v", chunks, got, want)
+		}
+	}
+}
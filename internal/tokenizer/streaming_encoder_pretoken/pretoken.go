@@ -0,0 +1,197 @@
+// Package streaming_encoder_pretoken streams through the pre-tokenizer alone: a pre-token (a word, a run of
+// digits or spaces) is encoded and emitted as soon as the next one has started, and nothing else is held
+// back. Merges never cross a pre-token boundary, so that needs no merge state and no tail reserve.
+package streaming_encoder_pretoken
+
+import (
+	"errors"
+
+	"github.com/bpetok/internal/tokenizer/core"
+)
+
+// ErrNoPreTokenizer is returned by NewPretokenEncoder for a tokenizer loaded without a pre-tokenizer, whose
+// merges may span the whole stream.
+var ErrNoPreTokenizer = errors.New("tokenizer has no pre-tokenizer")
+
+// PretokenEncoder is the streaming encoder for tokenizers with a pre-tokenizer, the way the reference GPT-2
+// and tiktoken encoders work. Its latency is one pre-token: IDs come out about a word behind the input,
+// however long the stream and whatever the merge table, and output equals EncodeOffline over the whole
+// stream. Special tokens are ordinary text to it, as to EncodeOffline.
+type PretokenEncoder struct {
+	tok    *core.Tokenizer
+	splits *core.SplitBuffer
+
+	// normalization, the prefix space and the checks behind warnings work as in the other encoders
+	normalizer  *core.StreamNormalizer
+	prefixSpace bool
+	started     bool
+	utf8        core.UTF8Tracker
+	capped      core.CappedRankTracker
+	warnings    core.Warnings
+
+	// zeroCopy makes Push and Flush return outBuf, see WithZeroCopyOutput
+	zeroCopy bool
+	outBuf   []int
+}
+
+// Option configures a PretokenEncoder.
+type Option func(*PretokenEncoder)
+
+// WithZeroCopyOutput makes Push and Flush return slices backed by a buffer the encoder reuses. They are only
+// valid until the next call.
+func WithZeroCopyOutput(on bool) Option {
+	return func(pe *PretokenEncoder) {
+		pe.zeroCopy = on
+	}
+}
+
+// WithWarnings makes the encoder record non-fatal anomalies for Warnings, see core.Warnings. Off by
+// default, which also skips the checks behind them.
+func WithWarnings(on bool) Option {
+	return func(pe *PretokenEncoder) {
+		pe.warnings.Enable(on)
+	}
+}
+
+// NewPretokenEncoder returns an encoder over tok that normalizes its input and adds a prefix space the way
+// tok was loaded to. tok must have a pre-tokenizer, see core.WithPreTokenization.
+func NewPretokenEncoder(tok *core.Tokenizer, opts ...Option) (*PretokenEncoder, error) {
+	splits := core.NewSplitBuffer(tok.PreTokenization())
+	if splits == nil {
+		return nil, ErrNoPreTokenizer
+	}
+	pe := &PretokenEncoder{
+		tok:         tok,
+		splits:      splits,
+		normalizer:  core.NewStreamNormalizer(tok.Normalization()),
+		prefixSpace: tok.AddPrefixSpace(),
+	}
+	for _, opt := range opts {
+		opt(pe)
+	}
+	return pe, nil
+}
+
+// Feed implements core.Encoder.
+func (pe *PretokenEncoder) Feed(chunk []byte) []int {
+	return pe.Push(chunk)
+}
+
+// Push encodes the next chunk and returns the IDs of the pre-tokens it completed.
+func (pe *PretokenEncoder) Push(chunk []byte) []int {
+	if len(chunk) == 0 {
+		return nil
+	}
+	if pe.warnings.Enabled() {
+		pe.utf8.Feed(chunk, pe.invalidUTF8)
+	}
+	if pe.normalizer != nil {
+		chunk = pe.normalizer.Push(chunk)
+	}
+	return pe.finishOut(pe.encode(pe.newOut(), pe.splits.Push(pe.prefix(chunk))))
+}
+
+// Flush emits the last pre-token and leaves the encoder ready for a new stream.
+func (pe *PretokenEncoder) Flush() []int {
+	if pe.warnings.Enabled() {
+		pe.utf8.Finish(pe.invalidUTF8)
+	}
+
+	out := pe.FlushSoft()
+	pe.capped.Reset()
+	pe.started = false
+	return out
+}
+
+// FlushSoft emits the unfinished pre-token but goes on with the stream, the next Push starting a new
+// pre-token as if the input had a hard split here. The prefix space isn't added again.
+func (pe *PretokenEncoder) FlushSoft() []int {
+	var rest []byte
+	if pe.normalizer != nil {
+		rest = pe.normalizer.Flush()
+	}
+	out := pe.encode(pe.newOut(), pe.splits.Push(pe.prefix(rest)))
+	out = pe.encode(out, pe.splits.Pending())
+	pe.splits.Reset()
+	return pe.finishOut(out)
+}
+
+// Reset abandons the stream without emitting what is held back. Warnings are kept, like across Flush.
+func (pe *PretokenEncoder) Reset() {
+	if pe.normalizer != nil {
+		pe.normalizer.Reset()
+	}
+	pe.splits.Reset()
+	pe.utf8 = core.UTF8Tracker{}
+	pe.capped.Reset()
+	pe.started = false
+}
+
+// PendingBytes returns how many bytes of input are held back: the unfinished pre-token plus what the
+// normalizer carries.
+func (pe *PretokenEncoder) PendingBytes() int {
+	n := pe.splits.Len()
+	if pe.normalizer != nil {
+		n += pe.normalizer.Pending()
+	}
+	return n
+}
+
+// Warnings returns the non-fatal anomalies seen so far, nil unless WithWarnings is on. They accumulate
+// across streams until ResetWarnings is called.
+func (pe *PretokenEncoder) Warnings() []core.Warning {
+	return pe.warnings.List()
+}
+
+// ResetWarnings clears the recorded warnings.
+func (pe *PretokenEncoder) ResetWarnings() {
+	pe.warnings.Reset()
+}
+
+// encode appends the IDs of whole pre-tokens b to out.
+func (pe *PretokenEncoder) encode(out []int, b []byte) []int {
+	for id := range pe.tok.EncodeSeq(b) {
+		out = append(out, id)
+	}
+	return out
+}
+
+// newOut returns the slice a call collects its output in: the reused buffer in zero-copy mode, none
+// otherwise.
+func (pe *PretokenEncoder) newOut() []int {
+	if pe.zeroCopy {
+		return pe.outBuf[:0]
+	}
+	return nil
+}
+
+// finishOut keeps a grown buffer for the next call and maps empty output to nil.
+func (pe *PretokenEncoder) finishOut(out []int) []int {
+	if pe.warnings.Enabled() && pe.tok.HasCappedRanks() {
+		pe.capped.Feed(pe.tok, out, pe.cappedRank)
+	}
+	if pe.zeroCopy {
+		pe.outBuf = out[:0]
+	}
+	if len(out) == 0 {
+		return nil
+	}
+	return out
+}
+
+// prefix adds the prefix space to the stream's first normalized bytes, if it is on.
+func (pe *PretokenEncoder) prefix(chunk []byte) []byte {
+	if !pe.prefixSpace || pe.started || len(chunk) == 0 {
+		return chunk
+	}
+	pe.started = true
+	return core.PrefixSpace(chunk)
+}
+
+func (pe *PretokenEncoder) invalidUTF8(off int64, b byte) {
+	pe.warnings.Add(core.WarnInvalidUTF8, off, "invalid UTF-8 byte 0x%02x", b)
+}
+
+func (pe *PretokenEncoder) cappedRank(off int64, left, right, rank int) {
+	pe.warnings.Add(core.WarnCappedRank, off, "tokens %d and %d left unmerged, their merge has rank %d", left, right, rank)
+}
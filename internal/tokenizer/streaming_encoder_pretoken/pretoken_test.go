@@ -0,0 +1,98 @@
+package streaming_encoder_pretoken
+
+import (
+	"errors"
+	"math/rand/v2"
+	"os"
+	"reflect"
+	"testing"
+
+	"github.com/bpetok/internal/tokenizer/core"
+)
+
+func load(t *testing.T, opts ...core.Option) *core.Tokenizer {
+	t.Helper()
+	tok, err := core.Load(core.Files("../testdata/gpt2/vocab.json", "../testdata/gpt2/merges.txt"), opts...)
+	if err != nil {
+		t.Fatalf("load tokenizer: %v", err)
+	}
+	return tok
+}
+
+func TestPretokenEncoder_MatchesOffline(t *testing.T) {
+	corpus, err := os.ReadFile("../testdata/gpt2/bench_corpus.txt")
+	if err != nil {
+		t.Fatalf("read corpus: %v", err)
+	}
+	input := append(corpus[:min(len(corpus), 64<<10):min(len(corpus), 64<<10)], " 12345678 café \xff   \n\n"...)
+
+	configs := map[string][]core.Option{
+		"gpt2":       {core.WithPreTokenization(core.PreTokenizeGPT2)},
+		"cl100k":     {core.WithPreTokenization(core.PreTokenizeCL100K), core.WithDigitGroup(3)},
+		"o200k":      {core.WithPreTokenization(core.PreTokenizeO200K)},
+		"normalized": {core.WithPreTokenization(core.PreTokenizeGPT2), core.WithNormalization(core.NormalizeNFC), core.WithAddPrefixSpace(true)},
+	}
+	rng := rand.New(rand.NewPCG(3, 4))
+	for name, opts := range configs {
+		tok := load(t, opts...)
+		pe, err := NewPretokenEncoder(tok, WithWarnings(true))
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		want := tok.EncodeOffline(tok.Prepare(input), nil)
+
+		// twice, to check the encoder is ready for a new stream after Flush
+		for range 2 {
+			var got []int
+			for pos := 0; pos < len(input); {
+				end := min(pos+1+rng.IntN(300), len(input))
+				got = append(got, pe.Push(input[pos:end])...)
+				pos = end
+			}
+			got = append(got, pe.Flush()...)
+			if !reflect.DeepEqual(got, want) {
+				t.Fatalf("%s: got %d tokens, want %d", name, len(got), len(want))
+			}
+		}
+		if w := pe.Warnings(); len(w) != 1 || w[0].Kind != core.WarnInvalidUTF8 || w[0].Count != 2 {
+			t.Fatalf("%s: warnings %v", name, w)
+		}
+	}
+}
+
+func TestPretokenEncoder_EmitsCompletedWords(t *testing.T) {
+	tok := load(t, core.WithPreTokenization(core.PreTokenizeGPT2))
+	pe, err := NewPretokenEncoder(tok)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got := pe.Push([]byte("hello world, stre"))
+	if want := tok.EncodeOffline([]byte("hello world,"), nil); !reflect.DeepEqual(got, want) {
+		t.Fatalf("got %v, want %v", got, want)
+	}
+	if pe.PendingBytes() != len(" stre") {
+		t.Fatalf("%d bytes pending", pe.PendingBytes())
+	}
+
+	// the checkpoint cuts "stre" off from the rest of the word
+	got = pe.FlushSoft()
+	got = append(got, pe.Push([]byte("am"))...)
+	got = append(got, pe.Flush()...)
+	want := append(tok.EncodeOffline([]byte(" stre"), nil), tok.EncodeOffline([]byte("am"), nil)...)
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("after FlushSoft: got %v, want %v", got, want)
+	}
+
+	pe.Push([]byte("abandoned"))
+	pe.Reset()
+	if pe.PendingBytes() != 0 || len(pe.Flush()) != 0 {
+		t.Fatalf("Reset left input behind")
+	}
+}
+
+func TestNewPretokenEncoder_NeedsPreTokenizer(t *testing.T) {
+	if _, err := NewPretokenEncoder(load(t)); !errors.Is(err, ErrNoPreTokenizer) {
+		t.Fatalf("got %v, want ErrNoPreTokenizer", err)
+	}
+}
@@ -1,7 +1,10 @@
 package utils
 
 type BucketQueue struct {
-	buckets    [][]MergeCand
+	buckets [][]MergeCand
+	// heads[r] is the index of the next candidate to pop from buckets[r]. Popping by index instead of
+	// reslicing keeps each bucket's capacity, so a reused queue stops allocating once it's warm.
+	heads      []int32
 	current    int
 	totalCount int
 }
@@ -9,6 +12,7 @@ type BucketQueue struct {
 func NewBucketQueue(maxRank int) *BucketQueue {
 	return &BucketQueue{
 		buckets: make([][]MergeCand, maxRank+1),
+		heads:   make([]int32, maxRank+1),
 		current: 0,
 	}
 }
@@ -23,9 +27,13 @@ func (bq *BucketQueue) Push(c MergeCand) {
 		newBuckets := make([][]MergeCand, rank+1)
 		copy(newBuckets, bq.buckets)
 		bq.buckets = newBuckets
+		newHeads := make([]int32, rank+1)
+		copy(newHeads, bq.heads)
+		bq.heads = newHeads
 	}
 
-	bucket := bq.buckets[rank]
+	head := int(bq.heads[rank])
+	bucket := bq.buckets[rank][head:]
 	bucketLen := len(bucket)
 
 	var insertPos int
@@ -50,19 +58,31 @@ func (bq *BucketQueue) Push(c MergeCand) {
 		insertPos = left
 	}
 
-	if insertPos == bucketLen {
-		bucket = append(bucket, c)
+	full := bq.buckets[rank]
+	insertPos += head
+	if insertPos == len(full) {
+		full = append(full, c)
 	} else {
-		bucket = append(bucket, MergeCand{})
-		copy(bucket[insertPos+1:], bucket[insertPos:])
-		bucket[insertPos] = c
+		full = append(full, MergeCand{})
+		copy(full[insertPos+1:], full[insertPos:])
+		full[insertPos] = c
 	}
-	bq.buckets[rank] = bucket
+	bq.buckets[rank] = full
 	bq.totalCount++
+
+	// a merge can create a pair that outranks the bucket being drained: never in merges.txt order, but a
+	// rank file can rank "aaab" below "aaa", and (aaa, b) only appears once aaa is built
+	if rank < bq.current {
+		bq.current = rank
+	}
 }
 
 func (bq *BucketQueue) Pop() (MergeCand, bool) {
-	for bq.current < len(bq.buckets) && len(bq.buckets[bq.current]) == 0 {
+	// an empty queue would otherwise scan every bucket up to maxRank, which dominates encoding short inputs
+	if bq.totalCount == 0 {
+		return MergeCand{}, false
+	}
+	for bq.current < len(bq.buckets) && int(bq.heads[bq.current]) == len(bq.buckets[bq.current]) {
 		bq.current++
 	}
 
@@ -71,9 +91,41 @@ func (bq *BucketQueue) Pop() (MergeCand, bool) {
 	}
 
 	bucket := bq.buckets[bq.current]
-	c := bucket[0]
-	bq.buckets[bq.current] = bucket[1:]
+	head := bq.heads[bq.current]
+	c := bucket[head]
+	if int(head)+1 == len(bucket) {
+		// drained, rewind so the next push reuses the whole backing array
+		bq.buckets[bq.current] = bucket[:0]
+		bq.heads[bq.current] = 0
+	} else {
+		bq.heads[bq.current] = head + 1
+	}
 	bq.totalCount--
 
 	return c, true
 }
+
+// Reset empties the queue but keeps the buckets, so one queue can serve many encodes. A drained queue has
+// every bucket rewound already, see Pop.
+func (bq *BucketQueue) Reset() {
+	bq.current = 0
+	if bq.totalCount == 0 {
+		return
+	}
+	for i := range bq.buckets {
+		bq.buckets[i] = bq.buckets[i][:0]
+		bq.heads[i] = 0
+	}
+	bq.current = 0
+	bq.totalCount = 0
+}
+
+// Trim releases the backing array of every bucket whose capacity exceeds maxCap. The queue must be empty.
+func (bq *BucketQueue) Trim(maxCap int) {
+	for i, b := range bq.buckets {
+		if cap(b) > maxCap {
+			bq.buckets[i] = nil
+			bq.heads[i] = 0
+		}
+	}
+}
@@ -0,0 +1,283 @@
+// Package replay records streaming encode sessions to a compact file and re-runs them against an encoder,
+// reporting where the emissions differ. When a customer sees a divergence we ask for a replay file instead of
+// trying to reconstruct their chunking by hand.
+//
+// File layout (all integers are uvarints unless noted):
+//
+//	magic "BPRP" | version byte | mode byte
+//	repeated records:
+//	  kind byte (recChunk | recFlush)
+//	  recChunk: size | data (ModeStored) or sha256 (ModeHashed) | n | n token IDs
+//	  recFlush: n | n token IDs
+//
+// A session ends with exactly one recFlush record.
+package replay
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/bpetok/bpetok"
+)
+
+const (
+	magic   = "BPRP"
+	version = 1
+
+	recChunk byte = 1
+	recFlush byte = 2
+
+	// maxChunkSize guards against allocating absurd buffers when reading a corrupt file.
+	maxChunkSize = 1 << 31
+)
+
+// Mode controls whether chunk contents are stored in the file or only their hashes.
+type Mode byte
+
+const (
+	// ModeStored keeps every chunk's bytes, the file alone is enough to replay.
+	ModeStored Mode = 1
+	// ModeHashed keeps only chunk sizes and SHA-256 hashes, the original input must be supplied on replay.
+	// Use it when the input can't leave the customer's environment.
+	ModeHashed Mode = 2
+)
+
+// ErrBadFormat is returned for files that aren't replay files or are corrupt.
+var ErrBadFormat = errors.New("replay: bad format")
+
+// Chunk is one recorded Feed call.
+type Chunk struct {
+	Size    int
+	Data    []byte // nil in ModeHashed
+	Hash    [sha256.Size]byte
+	Emitted []int
+}
+
+// Session is a decoded replay file.
+type Session struct {
+	Mode    Mode
+	Chunks  []Chunk
+	Flushed []int
+}
+
+// Recorder writes a session as it happens.
+type Recorder struct {
+	w    *bufio.Writer
+	mode Mode
+	tmp  [binary.MaxVarintLen64]byte
+	done bool
+}
+
+// NewRecorder writes the file header and returns a recorder for one session.
+func NewRecorder(w io.Writer, mode Mode) (*Recorder, error) {
+	if mode != ModeStored && mode != ModeHashed {
+		return nil, fmt.Errorf("replay: unknown mode %d", mode)
+	}
+
+	bw := bufio.NewWriter(w)
+	if _, err := bw.WriteString(magic); err != nil {
+		return nil, err
+	}
+	if err := bw.WriteByte(version); err != nil {
+		return nil, err
+	}
+	if err := bw.WriteByte(byte(mode)); err != nil {
+		return nil, err
+	}
+	return &Recorder{w: bw, mode: mode}, nil
+}
+
+// Chunk records one Feed call and the IDs it emitted.
+func (r *Recorder) Chunk(chunk []byte, emitted []int) error {
+	if r.done {
+		return errors.New("replay: chunk after flush")
+	}
+
+	if err := r.w.WriteByte(recChunk); err != nil {
+		return err
+	}
+	if err := r.uvarint(uint64(len(chunk))); err != nil {
+		return err
+	}
+
+	var err error
+	if r.mode == ModeStored {
+		_, err = r.w.Write(chunk)
+	} else {
+		sum := sha256.Sum256(chunk)
+		_, err = r.w.Write(sum[:])
+	}
+	if err != nil {
+		return err
+	}
+
+	return r.ids(emitted)
+}
+
+// Flush records the final Flush call and flushes the underlying writer. The recorder is done afterwards.
+func (r *Recorder) Flush(emitted []int) error {
+	if r.done {
+		return errors.New("replay: flush recorded twice")
+	}
+	r.done = true
+
+	if err := r.w.WriteByte(recFlush); err != nil {
+		return err
+	}
+	if err := r.ids(emitted); err != nil {
+		return err
+	}
+	return r.w.Flush()
+}
+
+func (r *Recorder) ids(ids []int) error {
+	if err := r.uvarint(uint64(len(ids))); err != nil {
+		return err
+	}
+	for _, id := range ids {
+		if id < 0 {
+			return fmt.Errorf("replay: negative token id %d", id)
+		}
+		if err := r.uvarint(uint64(id)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (r *Recorder) uvarint(v uint64) error {
+	n := binary.PutUvarint(r.tmp[:], v)
+	_, err := r.w.Write(r.tmp[:n])
+	return err
+}
+
+// RecordingEncoder wraps an encoder and records every call it sees.
+// Recording errors don't interrupt encoding, check Err after Flush.
+type RecordingEncoder struct {
+	enc bpetok.Encoder
+	rec *Recorder
+	err error
+}
+
+// NewRecordingEncoder returns enc wrapped so every Feed/Flush is recorded to rec.
+func NewRecordingEncoder(enc bpetok.Encoder, rec *Recorder) *RecordingEncoder {
+	return &RecordingEncoder{enc: enc, rec: rec}
+}
+
+// Feed implements bpetok.Encoder.
+func (re *RecordingEncoder) Feed(chunk []byte) []int {
+	out := re.enc.Feed(chunk)
+	if re.err == nil {
+		re.err = re.rec.Chunk(chunk, out)
+	}
+	return out
+}
+
+// Flush implements bpetok.Encoder.
+func (re *RecordingEncoder) Flush() []int {
+	out := re.enc.Flush()
+	if re.err == nil {
+		re.err = re.rec.Flush(out)
+	}
+	return out
+}
+
+// Err returns the first recording error, if any.
+func (re *RecordingEncoder) Err() error {
+	return re.err
+}
+
+// Read decodes a replay file.
+func Read(r io.Reader) (*Session, error) {
+	br := bufio.NewReader(r)
+
+	var hdr [len(magic) + 2]byte
+	if _, err := io.ReadFull(br, hdr[:]); err != nil {
+		return nil, fmt.Errorf("%w: header: %v", ErrBadFormat, err)
+	}
+	if string(hdr[:len(magic)]) != magic {
+		return nil, fmt.Errorf("%w: not a replay file", ErrBadFormat)
+	}
+	if hdr[len(magic)] != version {
+		return nil, fmt.Errorf("%w: unsupported version %d", ErrBadFormat, hdr[len(magic)])
+	}
+
+	s := &Session{Mode: Mode(hdr[len(magic)+1])}
+	if s.Mode != ModeStored && s.Mode != ModeHashed {
+		return nil, fmt.Errorf("%w: unknown mode %d", ErrBadFormat, s.Mode)
+	}
+
+	for {
+		kind, err := br.ReadByte()
+		if err != nil {
+			return nil, fmt.Errorf("%w: missing flush record", ErrBadFormat)
+		}
+
+		switch kind {
+		case recChunk:
+			c, err := readChunk(br, s.Mode)
+			if err != nil {
+				return nil, fmt.Errorf("%w: chunk %d: %v", ErrBadFormat, len(s.Chunks), err)
+			}
+			s.Chunks = append(s.Chunks, c)
+		case recFlush:
+			ids, err := readIDs(br)
+			if err != nil {
+				return nil, fmt.Errorf("%w: flush: %v", ErrBadFormat, err)
+			}
+			s.Flushed = ids
+			return s, nil
+		default:
+			return nil, fmt.Errorf("%w: unknown record kind %d", ErrBadFormat, kind)
+		}
+	}
+}
+
+func readChunk(br *bufio.Reader, mode Mode) (Chunk, error) {
+	size, err := binary.ReadUvarint(br)
+	if err != nil {
+		return Chunk{}, err
+	}
+
+	if size > maxChunkSize {
+		return Chunk{}, fmt.Errorf("chunk size %d exceeds limit", size)
+	}
+
+	c := Chunk{Size: int(size)}
+	if mode == ModeStored {
+		// grow with what the file actually holds, a corrupt size mustn't allocate up to the limit up front
+		var buf bytes.Buffer
+		if _, err := io.CopyN(&buf, br, int64(size)); err != nil {
+			return Chunk{}, err
+		}
+		c.Data = buf.Bytes()
+		c.Hash = sha256.Sum256(c.Data)
+	} else if _, err := io.ReadFull(br, c.Hash[:]); err != nil {
+		return Chunk{}, err
+	}
+
+	c.Emitted, err = readIDs(br)
+	return c, err
+}
+
+func readIDs(br *bufio.Reader) ([]int, error) {
+	n, err := binary.ReadUvarint(br)
+	if err != nil {
+		return nil, err
+	}
+
+	var ids []int
+	for i := uint64(0); i < n; i++ {
+		v, err := binary.ReadUvarint(br)
+		if err != nil {
+			return nil, err
+		}
+		ids = append(ids, int(v))
+	}
+	return ids, nil
+}
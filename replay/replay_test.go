@@ -0,0 +1,201 @@
+package replay
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"runtime"
+	"testing"
+
+	"github.com/bpetok/internal/tokenizer/core"
+	"github.com/bpetok/internal/tokenizer/streaming_encoder_incremental"
+	"github.com/bpetok/internal/tokenizer/streaming_encoder_naive"
+)
+
+type pushEncoder struct {
+	push  func([]byte) []int
+	flush func() []int
+}
+
+func (p pushEncoder) Feed(chunk []byte) []int { return p.push(chunk) }
+func (p pushEncoder) Flush() []int            { return p.flush() }
+
+func loadTestTokenizer(t *testing.T) *core.Tokenizer {
+	t.Helper()
+	tok, err := core.LoadTokenizerFromFiles("../internal/tokenizer/testdata/gpt2/vocab.json", "../internal/tokenizer/testdata/gpt2/merges.txt")
+	if err != nil {
+		t.Fatalf("failed to load tokenizer: %v", err)
+	}
+	return tok
+}
+
+func incremental(tok *core.Tokenizer) pushEncoder {
+	se := streaming_encoder_incremental.NewStreamingEncoderV2(tok)
+	return pushEncoder{push: se.Push, flush: se.Flush}
+}
+
+func naive(tok *core.Tokenizer) pushEncoder {
+	st := streaming_encoder_naive.NewNaiveStreamingEncoderStateWithOpts(tok, core.BaseEncoderState{})
+	return pushEncoder{push: st.Push, flush: st.Flush}
+}
+
+var testChunks = []string{"The qu", "ick brown ", "f", "ox jumps over the lazy dog. ", "Héllo 🌍", "!"}
+
+func record(t *testing.T, mode Mode, enc pushEncoder) []byte {
+	t.Helper()
+
+	var buf bytes.Buffer
+	rec, err := NewRecorder(&buf, mode)
+	if err != nil {
+		t.Fatalf("NewRecorder: %v", err)
+	}
+
+	re := NewRecordingEncoder(enc, rec)
+	for _, c := range testChunks {
+		re.Feed([]byte(c))
+	}
+	re.Flush()
+	if err := re.Err(); err != nil {
+		t.Fatalf("record: %v", err)
+	}
+	return buf.Bytes()
+}
+
+func TestReplay_StoredRoundTrip(t *testing.T) {
+	tok := loadTestTokenizer(t)
+	data := record(t, ModeStored, incremental(tok))
+
+	sess, err := Read(bytes.NewReader(data))
+	if err != nil {
+		t.Fatalf("Read: %v", err)
+	}
+	if len(sess.Chunks) != len(testChunks) {
+		t.Fatalf("expected %d chunks, got %d", len(testChunks), len(sess.Chunks))
+	}
+	for i, c := range sess.Chunks {
+		if string(c.Data) != testChunks[i] || c.Size != len(testChunks[i]) {
+			t.Fatalf("chunk %d: got %q", i, c.Data)
+		}
+	}
+
+	rep, err := Run(sess, incremental(tok), nil)
+	if err != nil {
+		t.Fatalf("Run: %v", err)
+	}
+	if len(rep.Diffs) != 0 || !rep.StreamMatch || rep.FirstDivergence != -1 {
+		t.Fatalf("same encoder should replay identically: %+v", rep)
+	}
+}
+
+func TestReplay_AgainstOtherEncoder(t *testing.T) {
+	tok := loadTestTokenizer(t)
+	sess, err := Read(bytes.NewReader(record(t, ModeStored, incremental(tok))))
+	if err != nil {
+		t.Fatalf("Read: %v", err)
+	}
+
+	rep, err := Run(sess, naive(tok), nil)
+	if err != nil {
+		t.Fatalf("Run: %v", err)
+	}
+	if !rep.StreamMatch {
+		t.Fatalf("naive and incremental should produce the same stream: %+v", rep)
+	}
+}
+
+func TestReplay_ReportsDivergence(t *testing.T) {
+	tok := loadTestTokenizer(t)
+	sess, err := Read(bytes.NewReader(record(t, ModeStored, incremental(tok))))
+	if err != nil {
+		t.Fatalf("Read: %v", err)
+	}
+	sess.Flushed = append([]int{0}, sess.Flushed...)
+
+	rep, err := Run(sess, incremental(tok), nil)
+	if err != nil {
+		t.Fatalf("Run: %v", err)
+	}
+	if rep.StreamMatch || len(rep.Diffs) == 0 {
+		t.Fatalf("expected a divergence: %+v", rep)
+	}
+	if last := rep.Diffs[len(rep.Diffs)-1]; last.Step != len(sess.Chunks) {
+		t.Fatalf("expected the flush step to differ, got step %d", last.Step)
+	}
+}
+
+func TestReplay_HashedNeedsMatchingInput(t *testing.T) {
+	tok := loadTestTokenizer(t)
+	data := record(t, ModeHashed, incremental(tok))
+
+	sess, err := Read(bytes.NewReader(data))
+	if err != nil {
+		t.Fatalf("Read: %v", err)
+	}
+	for _, c := range sess.Chunks {
+		if c.Data != nil {
+			t.Fatalf("hashed sessions must not carry chunk data")
+		}
+	}
+
+	var input []byte
+	for _, c := range testChunks {
+		input = append(input, c...)
+	}
+
+	rep, err := Run(sess, incremental(tok), input)
+	if err != nil {
+		t.Fatalf("Run: %v", err)
+	}
+	if !rep.StreamMatch {
+		t.Fatalf("expected a match: %+v", rep)
+	}
+
+	tampered := append([]byte(nil), input...)
+	tampered[3] = 'X'
+	if _, err := Run(sess, incremental(tok), tampered); err == nil {
+		t.Fatalf("expected a hash mismatch error")
+	}
+	if _, err := Run(sess, incremental(tok), input[:5]); err == nil {
+		t.Fatalf("expected a short input error")
+	}
+}
+
+func TestRead_Corrupt(t *testing.T) {
+	tok := loadTestTokenizer(t)
+	data := record(t, ModeStored, incremental(tok))
+
+	cases := map[string][]byte{
+		"empty":     nil,
+		"bad magic": append([]byte("NOPE"), data[4:]...),
+		"version":   append(append([]byte(nil), data[:4]...), append([]byte{99}, data[5:]...)...),
+		"truncated": data[:len(data)-3],
+	}
+	for name, in := range cases {
+		if _, err := Read(bytes.NewReader(in)); !errors.Is(err, ErrBadFormat) {
+			t.Fatalf("%s: expected ErrBadFormat, got %v", name, err)
+		}
+	}
+}
+
+func TestRead_HugeChunkSizeDoesNotAllocate(t *testing.T) {
+	tok := loadTestTokenizer(t)
+	data := record(t, ModeStored, incremental(tok))
+
+	// a valid header, then a chunk claiming the maximum size but holding three bytes
+	in := append([]byte(nil), data[:len(magic)+2]...)
+	in = append(in, recChunk)
+	in = binary.AppendUvarint(in, maxChunkSize)
+	in = append(in, "abc"...)
+
+	var before, after runtime.MemStats
+	runtime.ReadMemStats(&before)
+	_, err := Read(bytes.NewReader(in))
+	runtime.ReadMemStats(&after)
+
+	if !errors.Is(err, ErrBadFormat) {
+		t.Fatalf("expected ErrBadFormat, got %v", err)
+	}
+	if n := after.TotalAlloc - before.TotalAlloc; n > 1<<20 {
+		t.Fatalf("reading a 3 byte chunk allocated %d bytes", n)
+	}
+}
@@ -0,0 +1,101 @@
+package replay
+
+import (
+	"crypto/sha256"
+	"fmt"
+
+	"github.com/bpetok/bpetok"
+)
+
+// Diff is one step whose emission differs from the recording.
+type Diff struct {
+	// Step is the chunk index, or len(Session.Chunks) for the final flush.
+	Step int
+	Want []int
+	Got  []int
+}
+
+// Report is the outcome of a replay.
+type Report struct {
+	Steps int
+	// Diffs lists every step that emitted something other than what was recorded.
+	Diffs []Diff
+	// StreamMatch is true when the concatenated output matches the recording, even if tokens were emitted
+	// at different steps (e.g. replaying a naive-encoder session against the incremental one).
+	StreamMatch bool
+	// FirstDivergence is the index of the first mismatching token in the concatenated stream, -1 if none.
+	FirstDivergence int
+}
+
+// Run re-feeds a recorded session into enc and compares what comes out step by step.
+// For ModeHashed sessions input must be the original stream: it is cut into the recorded chunk sizes and
+// every piece is checked against its hash. input is ignored for ModeStored sessions.
+func Run(s *Session, enc bpetok.Encoder, input []byte) (*Report, error) {
+	var want, got []int
+	rep := &Report{Steps: len(s.Chunks) + 1, FirstDivergence: -1}
+
+	pos := 0
+	for i, c := range s.Chunks {
+		chunk := c.Data
+		if s.Mode == ModeHashed {
+			if pos+c.Size > len(input) {
+				return nil, fmt.Errorf("replay: input too short for chunk %d", i)
+			}
+			chunk = input[pos : pos+c.Size]
+			if sha256.Sum256(chunk) != c.Hash {
+				return nil, fmt.Errorf("replay: input does not match recorded hash of chunk %d", i)
+			}
+			pos += c.Size
+		}
+
+		out := enc.Feed(chunk)
+		if !equalIDs(out, c.Emitted) {
+			rep.Diffs = append(rep.Diffs, Diff{Step: i, Want: c.Emitted, Got: append([]int(nil), out...)})
+		}
+		want = append(want, c.Emitted...)
+		got = append(got, out...)
+	}
+
+	if s.Mode == ModeHashed && pos != len(input) {
+		return nil, fmt.Errorf("replay: input has %d bytes beyond the recorded session", len(input)-pos)
+	}
+
+	out := enc.Flush()
+	if !equalIDs(out, s.Flushed) {
+		rep.Diffs = append(rep.Diffs, Diff{Step: len(s.Chunks), Want: s.Flushed, Got: append([]int(nil), out...)})
+	}
+	want = append(want, s.Flushed...)
+	got = append(got, out...)
+
+	rep.FirstDivergence = firstDivergence(want, got)
+	rep.StreamMatch = rep.FirstDivergence == -1
+
+	return rep, nil
+}
+
+func equalIDs(a, b []int) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// firstDivergence returns the first index where a and b differ, counting a length mismatch as a
+// divergence at the end of the shorter one.
+func firstDivergence(a, b []int) int {
+	n := min(len(a), len(b))
+	for i := 0; i < n; i++ {
+		if a[i] != b[i] {
+			return i
+		}
+	}
+	if len(a) != len(b) {
+		return n
+	}
+	return -1
+}